@@ -0,0 +1,83 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"os"
+
+	"github.com/google/uuid"
+
+	"transmission-proxy/internal/logger"
+	"transmission-proxy/internal/reqctx"
+	"transmission-proxy/internal/response"
+)
+
+// accessLog logs every request once it is served, unless ACCESS_LOG is set to no.
+var accessLog = os.Getenv("ACCESS_LOG") == "" || getBoolEnv("ACCESS_LOG")
+
+// maxRequestIDLength caps X-Request-Id accepted from clients, longer ones are replaced.
+const maxRequestIDLength = 128
+
+// withRequestID tags the request with an ID, taken from X-Request-Id if the client sent a sane one. The ID is
+// attached to all log records of the request, sent back in X-Request-Id and forwarded upstream. With ACCESS_LOG
+// the request is logged once served.
+func withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-Id")
+		if !validRequestID(id) {
+			id = uuid.NewString()
+		}
+
+		ctx := reqctx.WithRequestID(r.Context(), id)
+		ctx = logger.ContextWithAttrs(ctx, logger.HTTPRequestID(id))
+		w.Header().Set("X-Request-Id", id)
+
+		if !accessLog {
+			next.ServeHTTP(w, r.WithContext(ctx))
+			return
+		}
+
+		ctx, call := reqctx.WithRPCCall(ctx)
+		rec := response.NewRecorder(w)
+		next.ServeHTTP(rec, r.WithContext(ctx))
+
+		status := rec.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+
+		attrs := append(rec.OutcomeAttrs(),
+			logger.HTTPMethod(r.Method),
+			logger.HTTPRequestPath(r.URL.Path),
+			logger.HTTPStatus(status))
+		if call.Upstream != "" {
+			attrs = append(attrs, logger.HTTPUpstream(call.Upstream))
+		}
+		if call.Method != "" {
+			attrs = append(attrs, logger.RPCMethod(call.Method))
+		}
+
+		lvl := slog.LevelInfo
+		if isProbe(r) {
+			lvl = slog.LevelDebug
+		}
+		slog.LogAttrs(ctx, lvl, "request served", attrs...)
+	})
+}
+
+// validRequestID reports whether the ID sent by the client is safe to log and echo.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-', c == '_', c == '.', c == ':':
+		default:
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"transmission-proxy/internal/jrpc"
+	"transmission-proxy/internal/reqctx"
+	"transmission-proxy/internal/transmission"
+)
+
+func TestAccessLogUpstream(t *testing.T) {
+	var buf bytes.Buffer
+	prev := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&buf, nil)))
+	t.Cleanup(func() { slog.SetDefault(prev) })
+
+	h := withRequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reqctx.SetRPCMethod(r.Context(), "torrent-get")
+		reqctx.SetUpstream(r.Context(), "standby:9091")
+		w.WriteHeader(http.StatusOK)
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/transmission/rpc", nil))
+
+	out := buf.String()
+	if !strings.Contains(out, `"msg":"request served"`) || !strings.Contains(out, `"http":{"upstream":"standby:9091"}`) {
+		t.Errorf("got access log %s", out)
+	}
+}
+
+func TestValidationWarningRequestID(t *testing.T) {
+	logs := captureLog(t)
+
+	v := transmission.DefaultMethodsValidator("/downloads/")
+	h := withRequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req, err := jrpc.FromRequest(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := v.Validate(req); err != nil {
+			t.Fatal(err)
+		}
+	}))
+
+	r := httptest.NewRequest(http.MethodPost, "/transmission/rpc",
+		strings.NewReader(`{"method":"torrent-stop","arguments":{"ids":[1],"bogus":true},"tag":9}`))
+	r.Header.Set("X-Request-Id", "poll-42")
+	h.ServeHTTP(httptest.NewRecorder(), r)
+
+	rec := logRecord(t, logs, "skip field from RPC request")
+	if attrs, _ := rec["http"].(map[string]any); attrs["request_id"] != "poll-42" {
+		t.Errorf("got record %v, want request id of the middleware", rec)
+	}
+	if attrs, _ := rec["rpc"].(map[string]any); attrs["tag"] != float64(9) || attrs["field"] != "bogus" || attrs["method"] != "torrent-stop" {
+		t.Errorf("got record %v, want method, tag and field", rec)
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"transmission-proxy/internal/logger"
+	"transmission-proxy/internal/reqctx"
+	"transmission-proxy/internal/response"
+)
+
+var adminToken = os.Getenv("ADMIN_TOKEN")
+
+// adminOnly protects administrative endpoints with the bearer token from ADMIN_TOKEN.
+// Without the token configured administrative endpoints are disabled.
+func adminOnly(rr *response.Responder, next http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if adminToken == "" {
+			rr.RespondAndLogCustom(w, r.Context(), fmt.Errorf("admin endpoints are disabled"), 0, slog.LevelWarn, http.StatusNotFound)
+			return
+		}
+
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) != 1 {
+			rr.RespondAndLogCustom(w, r.Context(), fmt.Errorf("admin authentication failed"), 0, slog.LevelWarn, http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, r *http.Request, status int, data any) {
+	bs, _ := json.Marshal(data)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	if _, err := fmt.Fprintln(w, string(bs)); err != nil {
+		slog.ErrorContext(r.Context(), "failed to write response: "+err.Error(), logger.IgnoredAttr(err))
+	}
+}
+
+func logLevelState() map[string]any {
+	data := map[string]any{}
+	data["level"] = logger.Level().String()
+	if t := logger.LevelRevertTime(); !t.IsZero() {
+		data["revert_at"] = t
+	}
+
+	return data
+}
+
+func logLevel(rr *response.Responder) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			writeJSON(w, r, http.StatusOK, logLevelState())
+		case http.MethodPut:
+			var req struct {
+				Level    string `json:"level"`
+				Duration string `json:"duration"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				rr.RespondAndLogCustom(w, r.Context(), fmt.Errorf("failed to parse request: %w", err), 0, slog.LevelWarn, http.StatusBadRequest)
+				return
+			}
+
+			var lvl slog.Level
+			if err := lvl.UnmarshalText([]byte(req.Level)); err != nil {
+				rr.RespondAndLogCustom(w, r.Context(), fmt.Errorf("bad level: %w", err), 0, slog.LevelWarn, http.StatusBadRequest)
+				return
+			}
+
+			var d time.Duration
+			if req.Duration != "" {
+				var err error
+				if d, err = time.ParseDuration(req.Duration); err != nil || d < 0 {
+					rr.RespondAndLogCustom(w, r.Context(), fmt.Errorf("bad duration: %q", req.Duration), 0, slog.LevelWarn, http.StatusBadRequest)
+					return
+				}
+			}
+
+			changeLogLevel(lvl, d, "admin "+reqctx.ClientIP(r.Context()).String())
+			writeJSON(w, r, http.StatusOK, logLevelState())
+		default:
+			w.Header().Set("Allow", "GET, PUT")
+			rr.RespondAndLogCustom(w, r.Context(), fmt.Errorf("method not allowed"), 0, slog.LevelWarn, http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+func changeLogLevel(lvl slog.Level, d time.Duration, requestedBy string) {
+	revertAt := logger.SetLevel(lvl, d)
+
+	attrs := []slog.Attr{slog.String("level", lvl.String()), slog.String("requested_by", requestedBy)}
+	if !revertAt.IsZero() {
+		attrs = append(attrs, slog.Time("revert_at", revertAt))
+	}
+
+	slog.LogAttrs(context.Background(), lvl, "log level changed", attrs...)
+}
+
+// startLogLevel is the log level from LOG_LEVEL, which the proxy starts with.
+var startLogLevel = func() slog.Level {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(getEnvOrDefault("LOG_LEVEL", "info"))); err != nil {
+		slog.Error("LOG_LEVEL must be one of debug, info, warn, error")
+		os.Exit(1)
+	}
+
+	return lvl
+}()
+
+// cycleLogLevelOnSignal switches global log level between debug and LOG_LEVEL (info if that is debug)
+// on every SIGUSR1 or SIGUSR2.
+func cycleLogLevelOnSignal() {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGUSR1, syscall.SIGUSR2)
+
+	go func() {
+		for sig := range ch {
+			lvl := slog.LevelDebug
+			if logger.Level() <= slog.LevelDebug {
+				lvl = max(startLogLevel, slog.LevelInfo)
+			}
+
+			name := "SIGUSR2"
+			if sig == syscall.SIGUSR1 {
+				name = "SIGUSR1"
+			}
+			changeLogLevel(lvl, 0, "signal "+name)
+		}
+	}()
+}
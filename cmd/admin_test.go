@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"transmission-proxy/internal/logger"
+	"transmission-proxy/internal/ownership"
+	"transmission-proxy/internal/response"
+	"transmission-proxy/internal/stats"
+	"transmission-proxy/internal/upstream"
+)
+
+// setAdminToken sets ADMIN_TOKEN for the duration of the test.
+func setAdminToken(t *testing.T, token string) {
+	prev := adminToken
+	adminToken = token
+	t.Cleanup(func() { adminToken = prev })
+}
+
+func TestStatusRequiresAdminToken(t *testing.T) {
+	st := stats.NewRegistry()
+	st.RecordRejection(stats.Rejection{Method: "torrent-add", ClientIP: "192.0.2.7", Reason: "forbidden_location", Body: `{"method":"torrent-add"}`})
+	h := adminOnly(&response.Responder{}, status(st, nil, &drainMode{}))
+
+	cases := []struct {
+		name, token, header string
+		want                int
+	}{
+		{name: "disabled", token: "", header: "Bearer secret", want: http.StatusNotFound},
+		{name: "missing", token: "secret", header: "", want: http.StatusUnauthorized},
+		{name: "wrong", token: "secret", header: "Bearer guess", want: http.StatusUnauthorized},
+		{name: "basic", token: "secret", header: "Basic c2VjcmV0", want: http.StatusUnauthorized},
+		{name: "admin", token: "secret", header: "Bearer secret", want: http.StatusOK},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			setAdminToken(t, tc.token)
+
+			r := httptest.NewRequest(http.MethodGet, "/proxy/status", nil)
+			if tc.header != "" {
+				r.Header.Set("Authorization", tc.header)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+
+			if w.Code != tc.want {
+				t.Fatalf("got status %d, want %d", w.Code, tc.want)
+			}
+			leaked := strings.Contains(w.Body.String(), "192.0.2.7")
+			if leaked != (tc.want == http.StatusOK) {
+				t.Errorf("client IP in response: %v, body %s", leaked, w.Body)
+			}
+		})
+	}
+}
+
+func TestStatusReconciliation(t *testing.T) {
+	captureLog(t)
+	rec := &ownership.Reconciler{
+		Upstream: &upstream.Client{URL: "http://transmission:9091/transmission/rpc",
+			HTTP: &http.Client{Transport: upstreamStatus(http.StatusBadGateway, "")}},
+		Interval: time.Hour,
+	}
+	// a single failing run
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	rec.Run(ctx)
+
+	w := httptest.NewRecorder()
+	status(stats.NewRegistry(), rec, &drainMode{}).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/proxy/status", nil))
+
+	var got struct {
+		Reconciliation *ownership.Status `json:"ownership_reconciliation"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if st := got.Reconciliation; st == nil || st.LastRun.IsZero() || st.Error == "" || st.NextRun.Before(st.LastRun.Add(time.Hour)) {
+		t.Errorf("got reconciliation status %+v, want the failed run and the next one backed off", st)
+	}
+}
+
+func TestLogLevelEndpoint(t *testing.T) {
+	prev := logger.Level()
+	t.Cleanup(func() { logger.SetLevel(prev, 0) })
+	logger.SetLevel(slog.LevelInfo, 0)
+	logs := captureLog(t)
+
+	setAdminToken(t, "secret")
+	h := adminOnly(&response.Responder{}, logLevel(&response.Responder{}))
+	call := func(method, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, "/proxy/log-level", strings.NewReader(body))
+		r.Header.Set("Authorization", "Bearer secret")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	for _, body := range []string{`{"level":"loud"}`, `{"level":"debug","duration":"-1m"}`, `{"level":"debug","duration":"soon"}`, `nonsense`} {
+		if w := call(http.MethodPut, body); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: got status %d", body, w.Code)
+		}
+	}
+	if logger.Level() != slog.LevelInfo {
+		t.Fatalf("bad requests changed level to %v", logger.Level())
+	}
+
+	w := call(http.MethodPut, `{"level":"debug","duration":"15m"}`)
+	var state struct {
+		Level    string    `json:"level"`
+		RevertAt time.Time `json:"revert_at"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &state); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("got status %d, body %s", w.Code, w.Body)
+	}
+	if state.Level != "DEBUG" || time.Until(state.RevertAt) < 14*time.Minute || logger.Level() != slog.LevelDebug {
+		t.Errorf("got state %+v, level %v", state, logger.Level())
+	}
+
+	rec := logRecord(t, logs, "log level changed")
+	if rec["level"] != "DEBUG" || !strings.HasPrefix(rec["requested_by"].(string), "admin ") || rec["revert_at"] == nil {
+		t.Errorf("got record %v", rec)
+	}
+
+	if w := call(http.MethodGet, ""); !strings.Contains(w.Body.String(), `"level":"DEBUG"`) {
+		t.Errorf("got state %s", w.Body)
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"transmission-proxy/internal/apikeys"
+	"transmission-proxy/internal/authlog"
+	"transmission-proxy/internal/jrpc"
+	"transmission-proxy/internal/logger"
+	"transmission-proxy/internal/reqctx"
+	"transmission-proxy/internal/transmission"
+)
+
+var apiKeysFile = os.Getenv("API_KEYS_FILE")
+
+// apiKeyAuth authenticates requests presenting an API key in X-Api-Key header, as bearer token or as basic
+// authentication password. Requests without a key, or with a basic authentication password which is not
+// a key, are passed to fallback authentication if there is one.
+func apiKeyAuth(g *authGuard, keys *apikeys.Store, next, fallback http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, explicit := presentedToken(r)
+		if explicit && g.rejectLocked(w, r, "") {
+			return
+		}
+
+		var key *apikeys.Key
+		if token != "" {
+			key = keys.Lookup(token)
+		}
+
+		if key == nil {
+			if fallback != nil && !explicit {
+				fallback.ServeHTTP(w, r)
+				return
+			}
+
+			if explicit {
+				g.fail(r, "", authlog.ReasonBadAPIKey)
+			}
+
+			w.Header().Set("WWW-Authenticate", `Basic realm="Transmission", charset="UTF-8"`)
+			g.rr.RespondAndLogCustom(w, r.Context(), fmt.Errorf("API key authentication failed"), 0, slog.LevelWarn, http.StatusUnauthorized)
+			return
+		}
+
+		ctx := apikeys.WithKey(r.Context(), key)
+		ctx = reqctx.WithUser(ctx, key.Name)
+		ctx = logger.ContextWithAttrs(ctx, logger.HTTPUser(key.Name))
+
+		if l := key.Limiter(); l != nil {
+			if ok, retryAfter := l.Allow(); !ok {
+				g.record(r, authlog.ReasonRateLimited, key.Name)
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+				g.rr.RespondAndLogCustom(w, ctx, fmt.Errorf("rate limit of API key exceeded"), 0, slog.LevelWarn, http.StatusTooManyRequests)
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r.WithContext(ctx))
+	}
+}
+
+// presentedToken returns the API key candidate from the request. Explicit means the client presented it
+// specifically as an API key rather than as a basic authentication password.
+func presentedToken(r *http.Request) (token string, explicit bool) {
+	if t := r.Header.Get("X-Api-Key"); t != "" {
+		return t, true
+	}
+
+	if t, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return t, true
+	}
+
+	if _, password, ok := r.BasicAuth(); ok {
+		return password, false
+	}
+
+	return "", false
+}
+
+func loadAPIKeys() *apikeys.Store {
+	keys, err := apikeys.Load(apiKeysFile)
+	if err == nil {
+		for _, k := range keys.Keys {
+			if k.DownloadPrefix != "" {
+				if err = checkPrefix(k.DownloadPrefix); err != nil {
+					err = fmt.Errorf("key %q: download_prefix %w", k.Name, err)
+					break
+				}
+			}
+		}
+	}
+	if err != nil {
+		slog.Error("failed to load API_KEYS_FILE: "+err.Error(), logger.IgnoredAttr(err))
+		os.Exit(1)
+	}
+
+	slog.Info(fmt.Sprintf("loaded %d API keys from API_KEYS_FILE", len(keys.Keys)))
+	return keys
+}
+
+// checkKeyNames returns error if any key is named as a known user: key names are the users of the requests
+// made with the keys, so such a key would be granted the rights and torrents of the user.
+func checkKeyNames(keys *apikeys.Store, known ...func(user string) bool) error {
+	for _, k := range keys.Keys {
+		for _, has := range known {
+			if has(k.Name) {
+				return fmt.Errorf("key %q is named as a user", k.Name)
+			}
+		}
+	}
+
+	return nil
+}
+
+// keyScopedValidator validates requests made with API keys overriding download prefix with validators
+// built for that prefix, other requests with the default validator.
+type keyScopedValidator struct {
+	def      transmission.RequestValidator
+	byPrefix map[string]transmission.RequestValidator
+}
+
+func (v *keyScopedValidator) Validate(req *jrpc.Request) (*jrpc.Request, error) {
+	if k := apikeys.FromContext(req.Ctx()); k != nil && k.DownloadPrefix != "" {
+		return v.byPrefix[k.DownloadPrefix].Validate(req)
+	}
+
+	return v.def.Validate(req)
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"transmission-proxy/internal/apikeys"
+	"transmission-proxy/internal/audit"
+	"transmission-proxy/internal/htpasswd"
+	"transmission-proxy/internal/response"
+	"transmission-proxy/internal/roles"
+	"transmission-proxy/internal/stats"
+	"transmission-proxy/internal/transmission"
+)
+
+const sonarrToken = "sonarr-0123456789"
+
+// sonarrKeys loads the keys file with the sonarr key restricted to some methods under /downloads/tv/.
+func sonarrKeys(t *testing.T) *apikeys.Store {
+	sum := sha256.Sum256([]byte(sonarrToken))
+	path := filepath.Join(t.TempDir(), "keys.yaml")
+	keys := "keys:\n  - name: sonarr\n    token_sha256: " + hex.EncodeToString(sum[:]) +
+		"\n    methods: [torrent-add, torrent-get, torrent-remove]\n    download_prefix: /downloads/tv/\n"
+	if err := os.WriteFile(path, []byte(keys), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := apikeys.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return s
+}
+
+func TestAPIKeyScope(t *testing.T) {
+	logs := captureLog(t)
+	keys := sonarrKeys(t)
+	auditPath := filepath.Join(t.TempDir(), "audit.log")
+	al, err := audit.Open(auditPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = al.Close() }()
+
+	// as main wires it: the method ACL on every validator, validators of the key prefixes chosen by the key
+	newValidator := func(prefix string) transmission.RequestValidator {
+		v := buildValidator(prefix)
+		v.RegisterPreValidateHook(apikeys.MethodACL)
+		return v
+	}
+	kv := &keyScopedValidator{def: newValidator("/downloads/"),
+		byPrefix: map[string]transmission.RequestValidator{"/downloads/tv/": newValidator("/downloads/tv/")}}
+
+	var forwarded []string
+	proxy := testRPCProxy(recordingUpstream(`{"arguments":{},"result":"success"}`, &forwarded), func(cfg *rpcProxyConfig) {
+		cfg.validator = kv
+		cfg.audit = al
+		cfg.responder = &response.Responder{DebugMode: true}
+	})
+	h := apiKeyAuth(&authGuard{rr: &response.Responder{}, st: stats.NewRegistry()}, keys, proxy, nil)
+
+	call := func(body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, rpcPath, strings.NewReader(body))
+		r.Header.Set("X-Api-Key", sonarrToken)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	cases := []struct {
+		name, body string
+		forwarded  bool
+		rejection  string
+	}{
+		{name: "in scope", forwarded: true,
+			body: `{"method":"torrent-add","arguments":{"filename":"magnet:?xt=urn:btih:abc","download-dir":"/downloads/tv/show"}}`},
+		{name: "out-of-scope method", rejection: "method torrent-set-location is not allowed for API key sonarr",
+			body: `{"method":"torrent-set-location","arguments":{"ids":[1],"location":"/downloads/tv/other"}}`},
+		{name: "out-of-prefix location", rejection: "forbidden location",
+			body: `{"method":"torrent-add","arguments":{"filename":"magnet:?xt=urn:btih:abc","download-dir":"/downloads/movies"}}`},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			forwarded = nil
+			w := call(tc.body)
+			if tc.forwarded {
+				if w.Code != http.StatusOK || len(forwarded) != 1 {
+					t.Errorf("got status %d, forwarded %q", w.Code, forwarded)
+				}
+				return
+			}
+
+			if w.Code == http.StatusOK || len(forwarded) != 0 {
+				t.Fatalf("got status %d, forwarded %q, want rejection", w.Code, forwarded)
+			}
+			if !strings.Contains(w.Body.String(), tc.rejection) {
+				t.Errorf("got body %s, want %q", w.Body, tc.rejection)
+			}
+		})
+	}
+
+	// the key is named in the audit records and logs, the token never appears
+	bs, err := os.ReadFile(auditPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(bs)), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("got audit log %q, want 3 records", bs)
+	}
+	for _, line := range lines {
+		var rec audit.Record
+		if err := json.Unmarshal([]byte(line), &rec); err != nil {
+			t.Fatal(err)
+		}
+		if rec.User != "sonarr" {
+			t.Errorf("got audit record of user %q, want the key name", rec.User)
+		}
+	}
+	if rec := logRecord(t, logs, "torrent-set-location is not allowed"); rec["http"].(map[string]any)["user"] != "sonarr" {
+		t.Errorf("got log record %v, want the key name as user", rec)
+	}
+	if strings.Contains(logs.String()+string(bs), sonarrToken) {
+		t.Errorf("the token is logged:\n%s\n%s", logs, bs)
+	}
+}
+
+func TestAPIKeyRejected(t *testing.T) {
+	captureLog(t)
+	keys := sonarrKeys(t)
+	h := apiKeyAuth(&authGuard{rr: &response.Responder{}, st: stats.NewRegistry()}, keys,
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { t.Error("request with a bad key passed") }), nil)
+
+	for _, header := range []string{"X-Api-Key", "Authorization"} {
+		r := httptest.NewRequest(http.MethodPost, rpcPath, nil)
+		value := "guess"
+		if header == "Authorization" {
+			value = "Bearer guess"
+		}
+		r.Header.Set(header, value)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("%s: got status %d", header, w.Code)
+		}
+	}
+}
+
+func TestCheckKeyNames(t *testing.T) {
+	keys := sonarrKeys(t)
+	const hash = ":$2a$04$ep6koQz20G/6ptxBdOvXBO2DZp9cfH/3EWwAMwEvlu1UZNK8Ob6SG"
+
+	cases := []struct {
+		name   string
+		admins []string
+		users  []string
+		ok     bool
+	}{
+		{name: "apart", admins: []string{"admin"}, users: []string{"alice" + hash}, ok: true},
+		// the key would be granted the rights of the administrator
+		{name: "admin", admins: []string{"admin", "sonarr"}},
+		{name: "user", users: []string{"sonarr" + hash}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			users, err := htpasswd.FromEntries(tc.users)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = checkKeyNames(keys, users.Has, roles.New(tc.admins).Known)
+			if (err == nil) != tc.ok {
+				t.Errorf("got error %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"transmission-proxy/internal/audit"
+	"transmission-proxy/internal/reqctx"
+	"transmission-proxy/internal/transmissiontest"
+)
+
+// auditedProxy returns rpcProxy of the upstream writing the audit log, and function reading the records written so far.
+func auditedProxy(t *testing.T, up http.RoundTripper) (http.Handler, func() []audit.Record) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	al, err := audit.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = al.Close() })
+
+	proxy := testRPCProxy(up, func(cfg *rpcProxyConfig) { cfg.audit = al })
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := reqctx.WithClientIP(reqctx.WithUser(r.Context(), "alice"), netip.MustParseAddr("203.0.113.7"))
+		proxy.ServeHTTP(w, r.WithContext(ctx))
+	})
+
+	return h, func() []audit.Record {
+		t.Helper()
+
+		bs, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var res []audit.Record
+		for _, line := range strings.Split(strings.TrimSpace(string(bs)), "\n") {
+			if line == "" {
+				continue
+			}
+			var rec audit.Record
+			if err := json.Unmarshal([]byte(line), &rec); err != nil {
+				t.Fatalf("bad record %q: %v", line, err)
+			}
+			res = append(res, rec)
+		}
+		return res
+	}
+}
+
+func TestAuditRPC(t *testing.T) {
+	captureLog(t)
+	h, records := auditedProxy(t, &transmissiontest.Server{})
+
+	// reading methods are not audited
+	for _, body := range []string{`{"method":"torrent-get","arguments":{"fields":["id"]}}`, `{"method":"session-get"}`} {
+		if w := postRPC(h, body); w.Code != http.StatusOK {
+			t.Fatalf("got status %d, body %s", w.Code, w.Body)
+		}
+	}
+	if recs := records(); len(recs) != 0 {
+		t.Fatalf("got records %+v of reading methods", recs)
+	}
+
+	postRPC(h, `{"method":"torrent-add","arguments":{"filename":"magnet:?xt=urn:btih:abc","download-dir":"/downloads/tv","paused":true},"tag":3}`)
+	postRPC(h, `{"method":"torrent-set-location","arguments":{"ids":[1,2],"location":"/etc","move":true},"tag":4}`)
+
+	recs := records()
+	if len(recs) != 2 {
+		t.Fatalf("got records %+v, want 2", recs)
+	}
+	added, rejected := recs[0], recs[1]
+	if added.Method != "torrent-add" || added.Tag != 3 || added.User != "alice" || added.ClientIP != "203.0.113.7" ||
+		added.Status != http.StatusOK || added.UpstreamStatus != http.StatusOK || added.Result != "" || added.Time.IsZero() {
+		t.Errorf("got record %+v of forwarded request", added)
+	}
+	if added.Arguments["filename"] != "magnet:?xt=urn:btih:abc" || added.Arguments["download-dir"] != "/downloads/tv" || added.Arguments["paused"] != true {
+		t.Errorf("got arguments %v", added.Arguments)
+	}
+
+	// the rejected requests are recorded with the reason, nothing came from the upstream
+	if rejected.Method != "torrent-set-location" || rejected.Status != http.StatusBadRequest || rejected.UpstreamStatus != 0 ||
+		!strings.Contains(rejected.Result, "forbidden location") || rejected.Arguments["location"] != "/etc" {
+		t.Errorf("got record %+v of rejected request", rejected)
+	}
+	if ids, _ := rejected.Ids.([]any); len(ids) != 2 {
+		t.Errorf("got ids %v", rejected.Ids)
+	}
+}
+
+func TestAuditSessionConflict(t *testing.T) {
+	captureLog(t)
+	// the client negotiating the session id has not changed anything yet
+	h, records := auditedProxy(t, &transmissiontest.Server{SessionID: "sid"})
+
+	if w := postRPC(h, `{"method":"torrent-remove","arguments":{"ids":[1]}}`); w.Code != http.StatusConflict {
+		t.Fatalf("got status %d, body %s", w.Code, w.Body)
+	}
+	if recs := records(); len(recs) != 0 {
+		t.Errorf("got records %+v of 409", recs)
+	}
+
+	r := httptest.NewRequest(http.MethodPost, rpcPath, strings.NewReader(`{"method":"torrent-remove","arguments":{"ids":[1]}}`))
+	r.Header.Set("X-Transmission-Session-Id", "sid")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if recs := records(); w.Code != http.StatusOK || len(recs) != 1 || recs[0].UpstreamStatus != http.StatusOK {
+		t.Errorf("got status %d, records %+v", w.Code, recs)
+	}
+}
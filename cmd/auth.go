@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"transmission-proxy/internal/authlog"
+	"transmission-proxy/internal/forwardauth"
+	"transmission-proxy/internal/htpasswd"
+	"transmission-proxy/internal/logger"
+	"transmission-proxy/internal/reqctx"
+	"transmission-proxy/internal/response"
+)
+
+var (
+	htpasswdFile   = os.Getenv("PROXY_HTPASSWD_FILE")
+	proxyUsers     = getListEnv("PROXY_USERS", "")
+	forwardAuthURL = os.Getenv("FORWARD_AUTH_URL")
+
+	// upstreamUser and upstreamPassword are sent to Transmission instead of the credentials of the clients.
+	upstreamUser     = os.Getenv("UPSTREAM_USER")
+	upstreamPassword = os.Getenv("UPSTREAM_PASSWORD")
+)
+
+// htpasswdPollInterval is how often the htpasswd file is checked for changes.
+const htpasswdPollInterval = 10 * time.Second
+
+// basicAuth requires the request to carry credentials of one of the users from the htpasswd file
+// and makes the user name available to other components as the request identity.
+func basicAuth(g *authGuard, users *htpasswd.File, next http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, password, ok := r.BasicAuth()
+		if ok && g.rejectLocked(w, r, user) {
+			return
+		}
+
+		if !ok || !users.Verify(user, password) {
+			err := fmt.Errorf("basic authentication failed")
+			if ok {
+				err = logger.WithAttributes(err, logger.HTTPUser(user))
+				g.fail(r, user, authlog.ReasonBadCredentials)
+			}
+
+			w.Header().Set("WWW-Authenticate", `Basic realm="Transmission", charset="UTF-8"`)
+			g.rr.RespondAndLogCustom(w, r.Context(), err, 0, slog.LevelWarn, http.StatusUnauthorized)
+			return
+		}
+
+		g.succeed(r, user)
+
+		ctx := reqctx.WithUser(r.Context(), user)
+		ctx = logger.ContextWithAttrs(ctx, logger.HTTPUser(user))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	}
+}
+
+// forwardAuth authenticates requests with the external forward-auth endpoint. Its denials are relayed
+// to the client; redirects (e.g. to a login page) only on paths opened in a browser, other paths get 401
+// instead. When the endpoint cannot be consulted the request is rejected.
+func forwardAuth(rr *response.Responder, c *forwardauth.Client, redirects bool, next http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		res, err := c.Authenticate(r)
+		if err != nil {
+			rr.RespondAndLogCustom(w, r.Context(), err, 0, slog.LevelError, http.StatusServiceUnavailable)
+			return
+		}
+
+		if !res.Authenticated() {
+			status := res.Status
+			isRedirect := status >= 300 && status < 400
+			for name, vs := range res.Header {
+				if name == "Location" && !(redirects && isRedirect) {
+					continue
+				}
+				w.Header()[name] = vs
+			}
+			if isRedirect && !redirects {
+				status = http.StatusUnauthorized
+			}
+
+			err = fmt.Errorf("forward authentication failed with status %d", res.Status)
+			rr.RespondAndLogCustom(w, r.Context(), err, 0, slog.LevelWarn, status)
+			return
+		}
+
+		ctx := reqctx.WithUser(r.Context(), res.User)
+		ctx = logger.ContextWithAttrs(ctx, logger.HTTPUser(res.User))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	}
+}
+
+func loadHtpasswd() *htpasswd.File {
+	if htpasswdFile == "" {
+		users, err := htpasswd.FromEntries(proxyUsers)
+		if err != nil {
+			slog.Error("invalid PROXY_USERS: "+err.Error(), logger.IgnoredAttr(err))
+			os.Exit(1)
+		}
+
+		return users
+	}
+
+	users, err := htpasswd.Load(htpasswdFile)
+	if err != nil {
+		slog.Error("failed to load PROXY_HTPASSWD_FILE: "+err.Error(), logger.IgnoredAttr(err))
+		os.Exit(1)
+	}
+
+	slog.Info(fmt.Sprintf("loaded %d users from PROXY_HTPASSWD_FILE", users.Len()))
+	return users
+}
+
+// reloadHtpasswd re-reads the htpasswd file when it changes or on SIGHUP. Failed reloads keep
+// the previously loaded users.
+func reloadHtpasswd(users *htpasswd.File) {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGHUP)
+
+	go func() {
+		ticker := time.NewTicker(htpasswdPollInterval)
+		defer ticker.Stop()
+
+		for {
+			var (
+				reloaded bool
+				err      error
+			)
+
+			select {
+			case <-ch:
+				reloaded, err = true, users.Reload()
+			case <-ticker.C:
+				reloaded, err = users.ReloadIfChanged()
+			}
+
+			if err != nil {
+				slog.Error("failed to reload PROXY_HTPASSWD_FILE: "+err.Error(), logger.IgnoredAttr(err))
+			} else if reloaded {
+				slog.Info(fmt.Sprintf("reloaded %d users from PROXY_HTPASSWD_FILE", users.Len()))
+			}
+		}
+	}()
+}
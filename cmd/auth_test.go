@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"transmission-proxy/internal/forwardauth"
+	"transmission-proxy/internal/htpasswd"
+	"transmission-proxy/internal/reqctx"
+	"transmission-proxy/internal/response"
+	"transmission-proxy/internal/stats"
+	"transmission-proxy/transmissionproxy"
+)
+
+// loginRedirect is the forward-auth endpoint redirecting every request to its login page.
+func loginRedirect(*http.Request) (*http.Response, error) {
+	w := httptest.NewRecorder()
+	w.Header().Set("Location", "https://auth.example.com/login")
+	w.Header().Set("Set-Cookie", "auth_state=1")
+	w.WriteHeader(http.StatusFound)
+	return w.Result(), nil
+}
+
+func testForwardAuth(endpoint upstreamFunc, redirects bool) http.Handler {
+	c := &forwardauth.Client{
+		URL:        "http://auth.example.com/verify",
+		Timeout:    time.Second,
+		UserHeader: "Remote-User",
+		HTTP: &http.Client{Transport: endpoint, CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		}},
+	}
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("hello " + reqctx.User(r.Context())))
+	})
+
+	return forwardAuth(&response.Responder{}, c, redirects, next)
+}
+
+func TestForwardAuthRelay(t *testing.T) {
+	captureLog(t)
+
+	cases := []struct {
+		name      string
+		endpoint  upstreamFunc
+		redirects bool
+		status    int
+		location  string
+		body      string
+	}{
+		{name: "authenticated", redirects: true, status: http.StatusOK, body: "hello alice",
+			endpoint: func(*http.Request) (*http.Response, error) {
+				w := httptest.NewRecorder()
+				w.Header().Set("Remote-User", "alice")
+				return w.Result(), nil
+			}},
+		{name: "redirect in browser", endpoint: loginRedirect, redirects: true,
+			status: http.StatusFound, location: "https://auth.example.com/login"},
+		{name: "redirect on RPC", endpoint: loginRedirect, redirects: false, status: http.StatusUnauthorized},
+		{name: "forbidden", endpoint: upstreamStatus(http.StatusForbidden, ""), redirects: true, status: http.StatusForbidden},
+		{name: "unavailable", redirects: true, status: http.StatusServiceUnavailable,
+			endpoint: func(*http.Request) (*http.Response, error) { return nil, errors.New("connection refused") }},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			testForwardAuth(tc.endpoint, tc.redirects).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/transmission/web/", nil))
+
+			if w.Code != tc.status {
+				t.Errorf("got status %d, want %d", w.Code, tc.status)
+			}
+			if got := w.Header().Get("Location"); got != tc.location {
+				t.Errorf("got Location %q, want %q", got, tc.location)
+			}
+			if tc.body != "" && w.Body.String() != tc.body {
+				t.Errorf("got body %q, want %q", w.Body, tc.body)
+			}
+		})
+	}
+}
+
+func TestBasicAuthUpstreamCredentials(t *testing.T) {
+	captureLog(t)
+	users, err := htpasswd.FromEntries([]string{"alice:$2a$04$ep6koQz20G/6ptxBdOvXBO2DZp9cfH/3EWwAMwEvlu1UZNK8Ob6SG"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var sent []string
+	u, _ := url.Parse("http://transmission:9091/")
+	gw := transmissionproxy.Forward(transmissionproxy.ForwardConfig{
+		Upstream: u,
+		Username: "transmission",
+		Password: "daemon-secret",
+		Client: &http.Client{Transport: upstreamFunc(func(r *http.Request) (*http.Response, error) {
+			sent = append(sent, r.Header.Get("Authorization"))
+			return upstreamStatus(http.StatusOK, sessionGetResponse)(r)
+		})},
+	})
+	h := basicAuth(&authGuard{rr: &response.Responder{DebugMode: true}, st: stats.NewRegistry()}, users, gw)
+
+	basic := func(credentials string) string {
+		return "Basic " + base64.StdEncoding.EncodeToString([]byte(credentials))
+	}
+	cases := []struct {
+		name, auth string
+	}{
+		{name: "missing header"},
+		{name: "wrong password", auth: basic("alice:daemon-secret")},
+		{name: "unknown user", auth: basic("transmission:daemon-secret")},
+		{name: "empty credentials", auth: basic(":")},
+		{name: "no colon", auth: basic("alice")},
+		{name: "not base64", auth: "Basic !!!"},
+		{name: "other scheme", auth: "Bearer " + base64.StdEncoding.EncodeToString([]byte("alice:alice-secret"))},
+		{name: "no credentials", auth: "Basic"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, rpcPath, strings.NewReader(sessionGet))
+			if tc.auth != "" {
+				r.Header.Set("Authorization", tc.auth)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+
+			if w.Code != http.StatusUnauthorized || !strings.HasPrefix(w.Header().Get("WWW-Authenticate"), `Basic realm=`) {
+				t.Errorf("got status %d, WWW-Authenticate %q", w.Code, w.Header().Get("WWW-Authenticate"))
+			}
+			var res map[string]any
+			if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil || !strings.Contains(strings.ToLower(fmt.Sprint(res["result"])), "authentication failed") {
+				t.Errorf("got body %s", w.Body)
+			}
+		})
+	}
+	if len(sent) != 0 {
+		t.Fatalf("rejected requests sent upstream: %q", sent)
+	}
+
+	// the credentials of the user are replaced with the ones of the upstream
+	r := httptest.NewRequest(http.MethodPost, rpcPath, strings.NewReader(sessionGet))
+	r.Header.Set("Authorization", basic("alice:alice-secret"))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusOK || len(sent) != 1 || sent[0] != basic("transmission:daemon-secret") {
+		t.Errorf("got status %d, sent upstream %q", w.Code, sent)
+	}
+}
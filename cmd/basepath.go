@@ -0,0 +1,83 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path"
+	"strings"
+
+	"transmission-proxy/transmissionproxy"
+)
+
+// basePath prefixes all routes of the proxy, e.g. /transmission-proxy, or is empty.
+var basePath = cleanBasePath(os.Getenv("BASE_PATH"))
+
+// publicPrefix is the path clients reach the proxy at, which is basePath under EXTERNAL_BASE_PATH,
+// e.g. /torrents/transmission-proxy, or empty if it is the root.
+var publicPrefix = cleanBasePath(externalBasePath + basePath)
+
+// cleanBasePath returns the path as route prefix: with leading slash and without trailing one, or empty
+// for the root.
+func cleanBasePath(p string) string {
+	return strings.TrimSuffix(path.Clean("/"+p), "/")
+}
+
+// withBasePath serves the routes under BASE_PATH, stripping it before routing, and redirects the base path
+// itself to the one with trailing slash. Other requests are not found. Root-relative redirects, e.g. to the login
+// page, are prefixed with publicPrefix for clients to follow them.
+func withBasePath(next http.Handler) http.HandlerFunc {
+	strip := http.StripPrefix(basePath, next)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		lw := &locationWriter{ResponseWriter: w}
+
+		switch {
+		case basePath == "" || strings.HasPrefix(r.URL.Path, basePath+"/"):
+			strip.ServeHTTP(lw, r)
+		case r.URL.Path == basePath:
+			u := "/"
+			if r.URL.RawQuery != "" {
+				u += "?" + r.URL.RawQuery
+			}
+			http.Redirect(lw, r, u, http.StatusMovedPermanently)
+		default:
+			transmissionproxy.NotFound(w, r)
+		}
+	}
+}
+
+type locationWriter struct {
+	http.ResponseWriter
+	wroteHeader bool
+}
+
+func (l *locationWriter) WriteHeader(status int) {
+	if !l.wroteHeader {
+		l.wroteHeader = true
+
+		h := l.Header()
+		if loc := h.Get("Location"); strings.HasPrefix(loc, "/") && !strings.HasPrefix(loc, "//") {
+			h.Set("Location", publicPrefix+loc)
+		}
+	}
+
+	l.ResponseWriter.WriteHeader(status)
+}
+
+func (l *locationWriter) Write(bs []byte) (int, error) {
+	if !l.wroteHeader {
+		l.WriteHeader(http.StatusOK)
+	}
+
+	return l.ResponseWriter.Write(bs)
+}
+
+func (l *locationWriter) Flush() {
+	if f, ok := l.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (l *locationWriter) Unwrap() http.ResponseWriter {
+	return l.ResponseWriter
+}
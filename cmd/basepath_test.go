@@ -0,0 +1,120 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCleanBasePath(t *testing.T) {
+	cases := map[string]string{
+		"":                               "",
+		"/":                              "",
+		"transmission-proxy":             "/transmission-proxy",
+		"/transmission-proxy/":           "/transmission-proxy",
+		"apps/transmission-proxy/":       "/apps/transmission-proxy",
+		"//apps//transmission-proxy//":   "/apps/transmission-proxy",
+		"/torrents/" + "/transmission":   "/torrents/transmission",
+		"/torrents/" + "":                "/torrents",
+		"/apps/./transmission-proxy/../": "/apps",
+	}
+	for p, want := range cases {
+		if got := cleanBasePath(p); got != want {
+			t.Errorf("%q: got %q, want %q", p, got, want)
+		}
+	}
+}
+
+// testBasePath returns withBasePath under the base path, the proxy being exposed at the external one,
+// serving the health endpoint, the login redirect and the rest with the handler recording the paths.
+func testBasePath(t *testing.T, base, external string) (http.Handler, *[]string) {
+	prevBase, prevPrefix := basePath, publicPrefix
+	t.Cleanup(func() { basePath, publicPrefix = prevBase, prevPrefix })
+	basePath, publicPrefix = cleanBasePath(base), cleanBasePath(external+base)
+
+	var paths []string
+	mux := http.NewServeMux()
+	mux.Handle(healthPath, healthz())
+	mux.HandleFunc("/transmission/web/", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, loginPath+"?next=/transmission/web/", http.StatusFound)
+	})
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+	})
+
+	return withBasePath(mux), &paths
+}
+
+func getPath(h http.Handler, target string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+	return w
+}
+
+func TestWithBasePath(t *testing.T) {
+	h, paths := testBasePath(t, "apps/transmission-proxy/", "")
+
+	// routes are served under the base path, with it stripped
+	if w := getPath(h, "/apps/transmission-proxy/healthz"); w.Code != http.StatusOK || w.Body.String() != "ok\n" {
+		t.Errorf("health: got status %d, body %q", w.Code, w.Body)
+	}
+	getPath(h, "/apps/transmission-proxy/transmission/rpc")
+	if len(*paths) != 1 || (*paths)[0] != "/transmission/rpc" {
+		t.Errorf("got paths %v routed", *paths)
+	}
+
+	// the rest are not found without routing them
+	for _, target := range []string{"/healthz", "/transmission/rpc", "/apps/transmission-proxyx/healthz", "/apps/"} {
+		if w := getPath(h, target); w.Code != http.StatusNotFound {
+			t.Errorf("%s: got status %d", target, w.Code)
+		}
+	}
+	if len(*paths) != 1 {
+		t.Errorf("got paths %v routed", *paths)
+	}
+
+	if w := getPath(h, "/apps/transmission-proxy?x=1"); w.Code != http.StatusMovedPermanently ||
+		w.Header().Get("Location") != "/apps/transmission-proxy/?x=1" {
+		t.Errorf("base path: got status %d, Location %q", w.Code, w.Header().Get("Location"))
+	}
+	if w := getPath(h, "/apps/transmission-proxy/transmission/web/"); w.Code != http.StatusFound ||
+		w.Header().Get("Location") != "/apps/transmission-proxy"+loginPath+"?next=/transmission/web/" {
+		t.Errorf("redirect: got status %d, Location %q", w.Code, w.Header().Get("Location"))
+	}
+}
+
+func TestWithBasePathExternal(t *testing.T) {
+	// the outer reverse proxy strips its prefix, but redirects must include it
+	h, _ := testBasePath(t, "", "/torrents/")
+	if w := getPath(h, "/healthz"); w.Code != http.StatusOK {
+		t.Errorf("health: got status %d", w.Code)
+	}
+	if w := getPath(h, "/transmission/web/"); !strings.HasPrefix(w.Header().Get("Location"), "/torrents"+loginPath+"?") {
+		t.Errorf("redirect: got Location %q", w.Header().Get("Location"))
+	}
+
+	h, _ = testBasePath(t, "/transmission-proxy", "/torrents")
+	if w := getPath(h, "/transmission-proxy/healthz"); w.Code != http.StatusOK {
+		t.Errorf("nested health: got status %d", w.Code)
+	}
+	if w := getPath(h, "/transmission-proxy/transmission/web/"); !strings.HasPrefix(w.Header().Get("Location"), "/torrents/transmission-proxy"+loginPath+"?") {
+		t.Errorf("nested redirect: got Location %q", w.Header().Get("Location"))
+	}
+	if w := getPath(h, "/transmission-proxy"); w.Header().Get("Location") != "/torrents/transmission-proxy/" {
+		t.Errorf("nested base path: got Location %q", w.Header().Get("Location"))
+	}
+}
+
+func TestIsProbe(t *testing.T) {
+	defer func(prev string) { basePath = prev }(basePath)
+	basePath = "/transmission-proxy"
+
+	for target, want := range map[string]bool{
+		"/transmission-proxy/healthz": true, "/transmission-proxy/readyz": true, "/healthz": false, "/transmission-proxy/status": false,
+	} {
+		if got := isProbe(httptest.NewRequest(http.MethodGet, target, nil)); got != want {
+			t.Errorf("%s: got %v", target, got)
+		}
+	}
+}
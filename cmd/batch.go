@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+
+	"transmission-proxy/internal/jrpc"
+	"transmission-proxy/internal/logger"
+	"transmission-proxy/internal/response"
+	"transmission-proxy/transmissionproxy"
+)
+
+var batchRequests = getBoolEnv("BATCH_REQUESTS")
+
+// batchLimits bound batched RPC requests.
+type batchLimits struct {
+	maxItems    int
+	maxBytes    int64
+	concurrency int
+	// atomic makes the whole batch rejected if any of its requests would be rejected by the proxy, unless
+	// BATCH_PARTIAL is set. It is a pre-check only, the requests are still executed one by one.
+	atomic bool
+}
+
+// batchLimitsFromEnv reads BATCH_MAX_ITEMS, BATCH_MAX_BYTES, BATCH_CONCURRENCY and BATCH_PARTIAL.
+func batchLimitsFromEnv() batchLimits {
+	var l batchLimits
+	var err error
+
+	if l.maxItems, err = strconv.Atoi(getEnvOrDefault("BATCH_MAX_ITEMS", "100")); err != nil || l.maxItems <= 0 {
+		slog.Error("BATCH_MAX_ITEMS must be a positive integer")
+		os.Exit(1)
+	}
+	if l.maxBytes, err = strconv.ParseInt(getEnvOrDefault("BATCH_MAX_BYTES", "10485760"), 10, 64); err != nil || l.maxBytes <= 0 {
+		slog.Error("BATCH_MAX_BYTES must be a positive integer")
+		os.Exit(1)
+	}
+	if l.concurrency, err = strconv.Atoi(getEnvOrDefault("BATCH_CONCURRENCY", "4")); err != nil || l.concurrency <= 0 {
+		slog.Error("BATCH_CONCURRENCY must be a positive integer")
+		os.Exit(1)
+	}
+	l.atomic = !getBoolEnv("BATCH_PARTIAL")
+
+	return l
+}
+
+// batchRPC accepts JSON arrays of RPC requests in addition to single requests, which are passed to next as they are.
+// Every item of the batch is handled by next as a request of its own, so it is validated, checked by policies,
+// audited and re-serialized for the upstream independently, and the responses are returned as JSON array
+// in the order of the requests.
+// The first item is sent alone: if it only negotiates the session id, the whole batch is answered with its 409,
+// so that the client retries it with the new id. Other items are sent with bounded concurrency afterwards.
+// Transmission does not accept arrays, so the batch is never forwarded as a whole.
+// In atomic mode, the default, all the items are checked in dry-run mode first, and nothing is sent if any would
+// be rejected. Otherwise the rejected items get their errors in place.
+// This does not make the batch a transaction: items may still fail upstream, or be rejected because something
+// changed since the check (e.g. a quota), while the items executed before them stay applied.
+func batchRPC(rr *response.Responder, limits batchLimits, next http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		br := bufio.NewReader(r.Body)
+		if !isBatch(br) {
+			r.Body = struct {
+				io.Reader
+				io.Closer
+			}{br, r.Body}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		r.Body = http.MaxBytesReader(w, struct {
+			io.Reader
+			io.Closer
+		}{br, r.Body}, limits.maxBytes)
+		reqs, err := jrpc.FromRequestBatch(r)
+		if err != nil {
+			var tooLarge *jrpc.BodyTooLargeError
+			var bad *jrpc.BatchItemError
+			switch {
+			case errors.As(err, &tooLarge):
+				err := fmt.Errorf("RPC batch exceeds %d bytes", limits.maxBytes)
+				rr.RespondAndLogCustom(w, r.Context(), err, 0, slog.LevelWarn, http.StatusRequestEntityTooLarge)
+			case errors.As(err, &bad):
+				err := logger.WithAttributes(fmt.Errorf("failed to unmarshal RPC batch: %w", err), logger.RPCBatchIndex(bad.Index))
+				rr.RespondAndLogCustom(w, r.Context(), err, 0, slog.LevelWarn, http.StatusBadRequest)
+			default:
+				rr.RespondAndLogCustom(w, r.Context(), fmt.Errorf("failed to unmarshal RPC batch: %w", err), 0, slog.LevelWarn, http.StatusBadRequest)
+			}
+			return
+		}
+		if len(reqs) == 0 || len(reqs) > limits.maxItems {
+			err := fmt.Errorf("RPC batch must have between 1 and %d requests, got %d", limits.maxItems, len(reqs))
+			rr.RespondAndLogCustom(w, r.Context(), err, 0, slog.LevelWarn, http.StatusBadRequest)
+			return
+		}
+
+		items := make([][]byte, len(reqs))
+		for i, req := range reqs {
+			items[i] = req.Raw
+		}
+
+		if limits.atomic && !transmissionproxy.IsDryRun(r) {
+			if i, res := firstRejected(next, r, items); res != nil {
+				err := logger.WithAttributes(fmt.Errorf("RPC batch rejected: request %d would be rejected with status %d", i, res.status),
+					logger.RPCBatchIndex(i), logger.RPCMethod(reqs[i].Method))
+				rr.RespondAndLogCustom(w, r.Context(), err, reqs[i].Tag, slog.LevelWarn, http.StatusBadRequest)
+				return
+			}
+		}
+
+		results := make([]*bufferedResponse, len(items))
+		results[0] = serveRPC(next, r, items[0])
+		if results[0].status == http.StatusConflict {
+			results[0].copyTo(w)
+			return
+		}
+
+		var wg sync.WaitGroup
+		sem := make(chan struct{}, limits.concurrency)
+		for i := 1; i < len(items); i++ {
+			wg.Add(1)
+			sem <- struct{}{}
+			go func(i int) {
+				defer func() {
+					<-sem
+					wg.Done()
+				}()
+				results[i] = serveRPC(next, r, items[i])
+			}(i)
+		}
+		wg.Wait()
+
+		responses := make([]json.RawMessage, len(items))
+		for i, res := range results {
+			responses[i] = res.batchResponse(items[i])
+		}
+
+		slog.DebugContext(r.Context(), fmt.Sprintf("RPC batch of %d requests completed", len(items)))
+		writeJSON(w, r, http.StatusOK, responses)
+	}
+}
+
+// firstRejected checks the requests of the batch in dry-run mode, returning the index and the response
+// of the first one which would be rejected, or nil response if none would.
+func firstRejected(next http.Handler, r *http.Request, items [][]byte) (int, *bufferedResponse) {
+	dr := r.Clone(r.Context())
+	dr.Header.Set(transmissionproxy.DryRunHeader, "1")
+
+	for i, item := range items {
+		if res := serveRPC(next, dr, item); res.status != http.StatusOK {
+			return i, res
+		}
+	}
+
+	return 0, nil
+}
+
+// isBatch reports whether the body starts with JSON array, peeking at it without consuming anything.
+func isBatch(br *bufio.Reader) bool {
+	for n := 1; ; n++ {
+		bs, _ := br.Peek(n)
+		if len(bs) < n {
+			return false
+		}
+
+		switch bs[n-1] {
+		case ' ', '\t', '\r', '\n':
+			continue
+		case '[':
+			return true
+		default:
+			return false
+		}
+	}
+}
+
+// batchResponse returns the response to embed into the batch response. Responses which are not JSON objects
+// (e.g. pages of upstream errors) are replaced with the error result carrying the tag of the request.
+func (b *bufferedResponse) batchResponse(item []byte) json.RawMessage {
+	if bs := bytes.TrimSpace(b.body.Bytes()); len(bs) > 0 && bs[0] == '{' && json.Valid(bs) {
+		return bs
+	}
+
+	var req struct {
+		Tag int `json:"tag"`
+	}
+	_ = json.Unmarshal(item, &req)
+
+	data := map[string]any{"result": fmt.Sprintf("request failed with status %d", b.status)}
+	if req.Tag != 0 {
+		data["tag"] = req.Tag
+	}
+
+	bs, _ := json.Marshal(data)
+	return bs
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"transmission-proxy/internal/logger"
+	"transmission-proxy/internal/response"
+	"transmission-proxy/transmissionproxy"
+)
+
+// fakeRPC answers every request with its method as result, rejecting torrent-remove. It records the bodies
+// of the requests it got other than dry runs.
+type fakeRPC struct {
+	mu     sync.Mutex
+	bodies []string
+}
+
+func (f *fakeRPC) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	bs, _ := io.ReadAll(r.Body)
+	var req struct {
+		Method string `json:"method"`
+		Tag    int    `json:"tag"`
+	}
+	_ = json.Unmarshal(bs, &req)
+
+	if req.Method == "torrent-remove" {
+		writeJSON(w, r, http.StatusForbidden, map[string]any{"result": "forbidden", "tag": req.Tag})
+		return
+	}
+	if !transmissionproxy.IsDryRun(r) {
+		f.mu.Lock()
+		f.bodies = append(f.bodies, string(bs))
+		f.mu.Unlock()
+	}
+	writeJSON(w, r, http.StatusOK, map[string]any{"result": req.Method, "tag": req.Tag})
+}
+
+func serveBatch(t *testing.T, atomic bool, body string) (*httptest.ResponseRecorder, *fakeRPC) {
+	f := &fakeRPC{}
+	h := batchRPC(&response.Responder{}, batchLimits{maxItems: 10, maxBytes: 1 << 20, concurrency: 2, atomic: atomic}, f)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/transmission/rpc", strings.NewReader(body)))
+
+	return w, f
+}
+
+func TestBatchForwardedOneByOne(t *testing.T) {
+	w, f := serveBatch(t, false, ` [{"method":"session-get","tag":1},{"method":"torrent-remove","tag":2},{"method":"torrent-get","tag":3}]`)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d", w.Code)
+	}
+	var responses []map[string]any
+	if err := json.Unmarshal(w.Body.Bytes(), &responses); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"session-get", "forbidden", "torrent-get"}
+	if len(responses) != len(want) {
+		t.Fatalf("got responses %s", w.Body)
+	}
+	for i, resp := range responses {
+		if resp["result"] != want[i] || resp["tag"] != float64(i+1) {
+			t.Errorf("response %d: got %v, want result %s", i, resp, want[i])
+		}
+	}
+
+	// every request is forwarded as an object of its own, never as array
+	if len(f.bodies) != 2 {
+		t.Fatalf("got forwarded requests %q", f.bodies)
+	}
+	for _, body := range f.bodies {
+		if !strings.HasPrefix(body, `{"method":`) {
+			t.Errorf("forwarded %s", body)
+		}
+	}
+}
+
+func TestBatchAtomicPreCheck(t *testing.T) {
+	logs := captureLog(t)
+	w, f := serveBatch(t, true, `[{"method":"session-get","tag":1},{"method":"torrent-remove","tag":2}]`)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("got status %d, want the batch rejected", w.Code)
+	}
+	// the request which would be rejected is named by its index
+	if rec := logRecord(t, logs, "batch rejected"); rec[logger.GroupRPC].(map[string]any)[logger.KeyBatchIndex] != 1.0 {
+		t.Errorf("got record %v", rec)
+	}
+	if len(f.bodies) != 0 {
+		t.Errorf("requests of the rejected batch were forwarded: %q", f.bodies)
+	}
+
+	w, f = serveBatch(t, true, `[{"method":"session-get","tag":1},{"method":"torrent-get","tag":2}]`)
+	if w.Code != http.StatusOK || len(f.bodies) != 2 {
+		t.Errorf("got status %d, forwarded %q", w.Code, f.bodies)
+	}
+}
+
+func TestBatchLimitsFromEnv(t *testing.T) {
+	// the batch is rejected as a whole unless partial results are asked for
+	if !batchLimitsFromEnv().atomic {
+		t.Error("got partial batches by default")
+	}
+	t.Setenv("BATCH_PARTIAL", "yes")
+	if batchLimitsFromEnv().atomic {
+		t.Error("got atomic batches with BATCH_PARTIAL")
+	}
+}
+
+func TestBatchSingleRequest(t *testing.T) {
+	body := `{"method":"session-get","tag":7}`
+	w, f := serveBatch(t, false, body)
+
+	if w.Code != http.StatusOK || len(f.bodies) != 1 || f.bodies[0] != body {
+		t.Errorf("got status %d, forwarded %q", w.Code, f.bodies)
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// Benchmarks of the RPC hot path: rpcProxy with the default validator forwarding to a canned upstream
+// through transmissionproxy.Forward, without the network. Numbers on 1 vCPU (go test -bench RPCProxy -benchmem):
+//
+//	BenchmarkRPCProxy_TorrentGet    22-35 µs/op    13.9 kB/op    83 allocs/op
+//	BenchmarkRPCProxy_SessionGet    16-27 µs/op    11.3 kB/op    73 allocs/op
+//	BenchmarkRPCProxy_TorrentAdd    23-33 µs/op    12.3 kB/op    86 allocs/op
+//
+// Before the allocation-reduction pass torrent-get took 142 allocs/op, session-get 72 and torrent-add 115;
+// most of the difference is the single-pass request decoding of jrpc.
+
+// torrentGetPoll is torrent-get request the web interface sends every few seconds.
+const torrentGetPoll = `{"method":"torrent-get","arguments":{"fields":["id","error","errorString","eta","isFinished",` +
+	`"isStalled","leftUntilDone","metadataPercentComplete","peersConnected","peersGettingFromUs","peersSendingToUs",` +
+	`"percentDone","queuePosition","rateDownload","rateUpload","recheckProgress","seedRatioMode","seedRatioLimit",` +
+	`"sizeWhenDone","status","trackers","downloadDir","uploadedEver","uploadRatio","webseedsSendingToUs"],` +
+	`"ids":"recently-active"},"tag":3}`
+
+const torrentGetPollResponse = `{"arguments":{"removed":[],"torrents":[{"id":1,"error":0,"errorString":"","eta":-1,` +
+	`"isFinished":false,"isStalled":false,"leftUntilDone":0,"percentDone":1,"rateDownload":0,"rateUpload":1024,` +
+	`"status":6,"downloadDir":"/downloads/linux","uploadRatio":0.52}]},"result":"success","tag":3}`
+
+const sessionGet = `{"method":"session-get","tag":1}`
+
+const sessionGetResponse = `{"arguments":{"download-dir":"/downloads","rpc-version":17,"version":"4.0.5"},` +
+	`"result":"success","tag":1}`
+
+const torrentAdd = `{"method":"torrent-add","arguments":{"filename":"magnet:?xt=urn:btih:0123456789abcdef0123456789abcdef01234567",` +
+	`"download-dir":"/downloads/linux","paused":false,"labels":["linux"]},"tag":5}`
+
+const torrentAddResponse = `{"arguments":{"torrent-added":{"hashString":"0123456789abcdef0123456789abcdef01234567",` +
+	`"id":2,"name":"ubuntu.iso"}},"result":"success","tag":5}`
+
+// cannedUpstream answers every request with the body, serving it in memory rather than over the network.
+type cannedUpstream string
+
+func (c cannedUpstream) RoundTrip(r *http.Request) (*http.Response, error) {
+	w := httptest.NewRecorder()
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Server", "Transmission")
+	_, _ = w.WriteString(string(c))
+
+	return w.Result(), nil
+}
+
+func benchmarkRPCProxy(b *testing.B, body, upstreamResponse string) {
+	h := testRPCProxy(cannedUpstream(upstreamResponse))
+	if w := postRPC(h, body); w.Code != http.StatusOK || w.Body.String() != upstreamResponse {
+		b.Fatalf("got status %d, body %s", w.Code, w.Body)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		postRPC(h, body)
+	}
+}
+
+func BenchmarkRPCProxy_TorrentGet(b *testing.B) {
+	benchmarkRPCProxy(b, torrentGetPoll, torrentGetPollResponse)
+}
+
+func BenchmarkRPCProxy_SessionGet(b *testing.B) {
+	benchmarkRPCProxy(b, sessionGet, sessionGetResponse)
+}
+
+func BenchmarkRPCProxy_TorrentAdd(b *testing.B) {
+	benchmarkRPCProxy(b, torrentAdd, torrentAddResponse)
+}
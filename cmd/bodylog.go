@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+
+	"transmission-proxy/internal/logger"
+	"transmission-proxy/internal/redact"
+	"transmission-proxy/internal/response"
+)
+
+// bodyLogMaxCapture limits the response kept to be logged. Responses have to be parsed whole to be redacted,
+// larger ones are not logged.
+const bodyLogMaxCapture = 1 << 20
+
+// rpcBodyLog logs bodies of the forwarded RPC requests and of the responses to them at debug level, with the
+// values of sensitive arguments redacted and the bodies truncated.
+type rpcBodyLog struct {
+	maxBytes int
+	redactor *redact.Redactor
+}
+
+// rpcBodyLogFromEnv returns the body log configured with DEBUG_MODE, or nil if it is not enabled.
+func rpcBodyLogFromEnv() *rpcBodyLog {
+	if !debugMode {
+		return nil
+	}
+
+	return &rpcBodyLog{
+		maxBytes: int(getSizeEnv("RPC_BODY_LOG_MAX_BYTES", 4096)),
+		redactor: redact.New(getListEnv("RPC_BODY_LOG_REDACT", strings.Join(redact.DefaultFields, ","))),
+	}
+}
+
+// enabled reports whether the bodies would be logged, so that they are not serialized otherwise.
+func (l *rpcBodyLog) enabled(ctx context.Context) bool {
+	return l != nil && slog.Default().Enabled(ctx, slog.LevelDebug)
+}
+
+// request logs the body of the request as forwarded and makes w keep the response for response.
+func (l *rpcBodyLog) request(ctx context.Context, w *response.Recorder, method string, body []byte) {
+	slog.LogAttrs(ctx, slog.LevelDebug, "forwarding RPC request", logger.RPCMethod(method),
+		logger.RPC(slog.String(logger.KeyRequestBody, redact.Truncate(l.redactor.Body(body), l.maxBytes))))
+	w.CaptureBody(bodyLogMaxCapture)
+}
+
+// response logs the response captured by w.
+func (l *rpcBodyLog) response(ctx context.Context, w *response.Recorder, method string) {
+	body, trimmed := w.Body()
+	if trimmed {
+		body = []byte(fmt.Sprintf("[not logged, exceeds %d bytes]", bodyLogMaxCapture))
+	} else {
+		if w.Header().Get("Content-Encoding") == "gzip" {
+			body = gunzip(body)
+		}
+		body = l.redactor.Body(body)
+	}
+
+	slog.LogAttrs(ctx, slog.LevelDebug, "RPC response", logger.RPCMethod(method), logger.HTTPStatus(w.Status()),
+		logger.RPC(slog.String(logger.KeyResponseBody, redact.Truncate(body, l.maxBytes))))
+}
+
+// gunzip decompresses the body, returning it as is if it cannot be decompressed.
+func gunzip(bs []byte) []byte {
+	gz, err := gzip.NewReader(bytes.NewReader(bs))
+	if err != nil {
+		return bs
+	}
+
+	out, err := io.ReadAll(gz)
+	if err != nil {
+		return bs
+	}
+
+	return out
+}
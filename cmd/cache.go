@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"slices"
+
+	"transmission-proxy/internal/logger"
+	"transmission-proxy/internal/reqctx"
+	"transmission-proxy/internal/response"
+	"transmission-proxy/internal/rpccache"
+	"transmission-proxy/internal/transmission"
+)
+
+// coalesceRPC makes concurrent identical read-only RPC requests share one upstream request.
+var coalesceRPC = getBoolEnv("COALESCE_RPC")
+
+// rpcCacheFromEnv returns the cache of RPC responses configured with RPC_CACHE_TTL, or nil if it is not set.
+func rpcCacheFromEnv() *rpccache.Cache {
+	if os.Getenv("RPC_CACHE_TTL") == "" {
+		return nil
+	}
+
+	return &rpccache.Cache{
+		TTL:        getDurationEnv("RPC_CACHE_TTL", 0),
+		MaxEntries: int(getSizeEnv("RPC_CACHE_MAX_ENTRIES", 256)),
+	}
+}
+
+// sharedRPC serves the responses to read-only RPC requests from the cache while they are fresh, and makes
+// concurrent identical ones wait for the first of them to be answered if g is set. Other requests are
+// forwarded to gw; the ones which may change anything invalidate the cache once forwarded.
+func sharedRPC(gw http.Handler, c *rpccache.Cache, g *rpccache.Group, rr *response.Responder) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req := reqctx.ForwardedRPC(r.Context())
+		if req == nil {
+			gw.ServeHTTP(w, r)
+			return
+		}
+
+		// the upstream answers according to the credentials, which are the client's own without UPSTREAM_USER
+		var scope string
+		if upstreamUser == "" {
+			scope = r.Header.Get("Authorization")
+		}
+
+		key, ok := rpccache.Key(req, scope)
+		if !ok {
+			rec := response.NewRecorder(w)
+			gw.ServeHTTP(rec, r)
+			setUpstreamStatus(w, rec.UpstreamStatus())
+
+			// 409 only negotiates the session id, the request is not executed
+			if c != nil && !slices.Contains(transmission.ReadOnlyMethods, req.Method) && rec.UpstreamStatus() != http.StatusConflict {
+				c.Invalidate()
+			}
+			return
+		}
+
+		var gen uint64
+		if c != nil {
+			var e *rpccache.Entry
+			if e, gen = c.Get(key); e != nil {
+				slog.DebugContext(r.Context(), "RPC response served from cache", logger.RPCMethod(req.Method))
+				sendShared(w, r, &rpccache.Response{
+					Status:         http.StatusOK,
+					UpstreamStatus: http.StatusOK,
+					Header:         e.Header,
+					Body:           e.Body,
+					Tagless:        true,
+				})
+				return
+			}
+		}
+
+		fetch := func(r *http.Request) *rpccache.Response {
+			// shared responses are decompressed, as the clients they are shared with may not accept gzip;
+			// the transport decompresses the response when it asks for compression itself
+			r.Header.Del("Accept-Encoding")
+
+			buf := response.NewBuffer()
+			gw.ServeHTTP(buf, r)
+
+			resp := &rpccache.Response{
+				Status:         buf.Status(),
+				UpstreamStatus: buf.UpstreamStatus(),
+				Header:         buf.Header(),
+				Body:           buf.Body.Bytes(),
+			}
+			if body, ok := rpccache.StripTag(resp.Body); ok {
+				resp.Body, resp.Tagless = body, true
+			}
+			if c != nil && resp.Tagless && resp.Status == http.StatusOK && resp.UpstreamStatus == http.StatusOK {
+				c.Put(key, gen, resp.Header, resp.Body)
+			}
+
+			return resp
+		}
+
+		if g == nil {
+			sendShared(w, r, fetch(r))
+			return
+		}
+
+		f, shared := g.Do(key, func() *rpccache.Response {
+			// the caller going away must not fail the request for the others waiting for it
+			ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), rpcTimeout(req.Method))
+			defer cancel()
+
+			return fetch(r.Clone(ctx))
+		})
+		if shared {
+			slog.DebugContext(r.Context(), "RPC request coalesced with identical one in progress", logger.RPCMethod(req.Method))
+		}
+
+		select {
+		case <-f.Done():
+			sendShared(w, r, f.Response())
+		case <-r.Context().Done():
+			err := r.Context().Err()
+			status, lvl := http.StatusBadGateway, slog.LevelInfo // the client went away
+			if errors.Is(err, context.DeadlineExceeded) {
+				status, lvl = http.StatusGatewayTimeout, slog.LevelError
+			}
+			rr.RespondAndLogCustom(w, r.Context(), fmt.Errorf("upstream error: %w", err), req.Tag, lvl, status)
+		}
+	}
+}
+
+// sendShared sends copy of the shared response with the tag of the request.
+func sendShared(w http.ResponseWriter, r *http.Request, resp *rpccache.Response) {
+	setUpstreamStatus(w, resp.UpstreamStatus)
+
+	body := resp.Body
+	if resp.Tagless {
+		req := reqctx.ForwardedRPC(r.Context())
+		body = rpccache.WithTag(body, req.Tag, req.HasTag)
+	}
+
+	for h, vals := range resp.Header {
+		w.Header()[h] = slices.Clone(vals)
+	}
+	w.Header().Del("Content-Length")
+	w.WriteHeader(resp.Status)
+	if _, err := w.Write(body); err != nil {
+		slog.ErrorContext(r.Context(), "proxy: failed to write response: "+err.Error(), logger.IgnoredAttr(err))
+	}
+}
+
+// setUpstreamStatus passes the status of the upstream response to w, if it records it.
+func setUpstreamStatus(w http.ResponseWriter, status int) {
+	if rec, ok := w.(interface{ SetUpstreamStatus(int) }); ok && status != 0 {
+		rec.SetUpstreamStatus(status)
+	}
+}
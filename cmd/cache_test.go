@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"transmission-proxy/internal/events"
+	"transmission-proxy/internal/jrpc"
+	"transmission-proxy/internal/logger"
+	"transmission-proxy/internal/response"
+	"transmission-proxy/internal/rpccache"
+	"transmission-proxy/internal/stats"
+	"transmission-proxy/internal/transmissiontest"
+	"transmission-proxy/transmissionproxy"
+)
+
+// heldTransmission answers with the status once released.
+func heldTransmission(status int, release <-chan struct{}) *transmissiontest.Server {
+	return &transmissiontest.Server{Hold: release, RPC: func(w http.ResponseWriter, _ *http.Request, req *jrpc.Request) {
+		transmissiontest.Reply(w, status, req, `{"torrents":[]}`)
+	}}
+}
+
+// coalescingProxy returns rpcProxy sharing the upstream requests as with COALESCE_RPC.
+func coalescingProxy(up http.RoundTripper) http.Handler {
+	u, _ := url.Parse("http://transmission:9091/")
+	rr := &response.Responder{}
+	gw := transmissionproxy.Forward(transmissionproxy.ForwardConfig{Upstream: u, Client: &http.Client{Transport: up}})
+
+	return rpcProxy(sharedRPC(gw, nil, &rpccache.Group{}, rr), rpcProxyConfig{
+		validator: buildValidator("/downloads/"),
+		events:    events.NewBus(),
+		drain:     &drainMode{},
+		responder: rr,
+		stats:     stats.NewRegistry(),
+	})
+}
+
+// logLines passes the logged records on, dropping them if nobody waits.
+type logLines chan string
+
+func (l logLines) Write(p []byte) (int, error) {
+	select {
+	case l <- string(p):
+	default:
+	}
+
+	return len(p), nil
+}
+
+// captureCoalesced returns function waiting for n more requests to join the ones in progress.
+func captureCoalesced(t *testing.T) func(n int) {
+	lines := make(logLines, 100)
+	prev := slog.Default()
+	slog.SetDefault(slog.New(logger.NewHandler(slog.NewJSONHandler(lines, &slog.HandlerOptions{Level: slog.LevelDebug}), "/")))
+	t.Cleanup(func() { slog.SetDefault(prev) })
+
+	return func(n int) {
+		t.Helper()
+
+		for n > 0 {
+			select {
+			case line := <-lines:
+				if strings.Contains(line, "coalesced") {
+					n--
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatalf("%d requests not coalesced", n)
+			}
+		}
+	}
+}
+
+// postTorrentGet posts torrent-get with the tag, omitted if zero, in the background.
+func postTorrentGet(ctx context.Context, wg *sync.WaitGroup, h http.Handler, tag int) *httptest.ResponseRecorder {
+	body := `{"method":"torrent-get","arguments":{"fields":["id","name"]}`
+	if tag != 0 {
+		body += `,"tag":` + strconv.Itoa(tag)
+	}
+	r := httptest.NewRequest(http.MethodPost, rpcPath, strings.NewReader(body+"}")).WithContext(ctx)
+	r.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		h.ServeHTTP(w, r)
+	}()
+
+	return w
+}
+
+func TestCoalesceRPC(t *testing.T) {
+	waitCoalesced := captureCoalesced(t)
+	release := make(chan struct{})
+	up := heldTransmission(http.StatusOK, release)
+	h := coalescingProxy(up)
+
+	var wg sync.WaitGroup
+	tags := []int{1, 2, 3, 4, 0}
+	ws := make([]*httptest.ResponseRecorder, len(tags))
+	for i, tag := range tags {
+		ws[i] = postTorrentGet(context.Background(), &wg, h, tag)
+	}
+	waitCoalesced(len(tags) - 1)
+	close(release)
+	wg.Wait()
+
+	if n := len(up.Requests()); n != 1 {
+		t.Errorf("got %d upstream requests, want 1", n)
+	}
+	// every client gets the response with its own tag
+	for i, w := range ws {
+		var res map[string]any
+		if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil || w.Code != http.StatusOK {
+			t.Fatalf("tag %d: got status %d, body %s", tags[i], w.Code, w.Body)
+		}
+		var want any
+		if tags[i] != 0 {
+			want = float64(tags[i])
+		}
+		if res["result"] != "success" || res["tag"] != want {
+			t.Errorf("tag %d: got body %s", tags[i], w.Body)
+		}
+	}
+
+	// requests coming after the response are forwarded again
+	postTorrentGet(context.Background(), &wg, h, 6)
+	wg.Wait()
+	if n := len(up.Requests()); n != 2 {
+		t.Errorf("got %d upstream requests, want 2", n)
+	}
+}
+
+func TestCoalesceRPCError(t *testing.T) {
+	waitCoalesced := captureCoalesced(t)
+	release := make(chan struct{})
+	up := heldTransmission(http.StatusBadGateway, release)
+	h := coalescingProxy(up)
+
+	var wg sync.WaitGroup
+	ws := make([]*httptest.ResponseRecorder, 3)
+	for i := range ws {
+		ws[i] = postTorrentGet(context.Background(), &wg, h, i+1)
+	}
+	waitCoalesced(len(ws) - 1)
+	close(release)
+	wg.Wait()
+
+	// the error is delivered to all the waiters
+	for i, w := range ws {
+		if w.Code != http.StatusBadGateway || !strings.Contains(w.Body.String(), `"tag":`+strconv.Itoa(i+1)) {
+			t.Errorf("tag %d: got status %d, body %s", i+1, w.Code, w.Body)
+		}
+	}
+	if n := len(up.Requests()); n != 1 {
+		t.Errorf("got %d upstream requests, want 1", n)
+	}
+}
+
+func TestCoalesceRPCCanceledCaller(t *testing.T) {
+	waitCoalesced := captureCoalesced(t)
+	release := make(chan struct{})
+	up := heldTransmission(http.StatusOK, release)
+	h := coalescingProxy(up)
+
+	// the client whose request is forwarded goes away
+	var first sync.WaitGroup
+	ctx, cancel := context.WithCancel(context.Background())
+	postTorrentGet(ctx, &first, h, 1)
+	up.WaitRequests(t, 1)
+
+	var wg sync.WaitGroup
+	ws := []*httptest.ResponseRecorder{postTorrentGet(context.Background(), &wg, h, 2), postTorrentGet(context.Background(), &wg, h, 3)}
+	waitCoalesced(len(ws))
+	cancel()
+	first.Wait()
+	close(release)
+	wg.Wait()
+
+	if len(up.Canceled()) != 0 {
+		t.Error("upstream request canceled with the client")
+	}
+	for i, w := range ws {
+		if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"tag":`+strconv.Itoa(i+2)) {
+			t.Errorf("tag %d: got status %d, body %s", i+2, w.Code, w.Body)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"transmission-proxy/internal/capture"
+	"transmission-proxy/internal/logger"
+	"transmission-proxy/internal/reqctx"
+	"transmission-proxy/internal/response"
+	"transmission-proxy/transmissionproxy"
+)
+
+// captureMaxResponse limits the captured part of each response body.
+const captureMaxResponse = 1 << 20
+
+// verdictFailed is the verdict of captured requests the proxy failed to handle, e.g. when a policy could not be checked.
+const verdictFailed = "failed"
+
+var captureDir = os.Getenv("CAPTURE_DIR")
+
+// openCapture opens CAPTURE_DIR for capturing RPC exchanges, which is only allowed in DEBUG_MODE.
+func openCapture() *capture.Writer {
+	if !debugMode {
+		slog.Warn("CAPTURE_DIR is ignored unless DEBUG_MODE is enabled")
+		return nil
+	}
+
+	maxFiles, err := strconv.Atoi(getEnvOrDefault("CAPTURE_MAX_FILES", "1000"))
+	if err != nil || maxFiles <= 0 {
+		slog.Error("CAPTURE_MAX_FILES must be a positive integer")
+		os.Exit(1)
+	}
+	maxBytes, err := strconv.ParseInt(getEnvOrDefault("CAPTURE_MAX_BYTES", "104857600"), 10, 64)
+	if err != nil || maxBytes <= 0 {
+		slog.Error("CAPTURE_MAX_BYTES must be a positive integer")
+		os.Exit(1)
+	}
+
+	cw, err := capture.Open(captureDir, maxFiles, maxBytes)
+	if err != nil {
+		slog.Error("failed to open CAPTURE_DIR: "+err.Error(), logger.IgnoredAttr(err))
+		os.Exit(1)
+	}
+	cw.SetEnabled(!getBoolEnv("CAPTURE_PAUSED"))
+
+	go cw.Run(context.Background())
+	return cw
+}
+
+// rpcCapture collects the RPC exchange while the request is handled.
+type rpcCapture struct {
+	ex   capture.Exchange
+	body bytes.Buffer
+}
+
+// startCapture starts capturing the exchange if capture is configured and enabled.
+func startCapture(cw *capture.Writer, w *response.Recorder, r *http.Request) *rpcCapture {
+	if cw == nil || !cw.Enabled() {
+		return nil
+	}
+
+	c := &rpcCapture{}
+	c.ex.Time = time.Now()
+	c.ex.ClientIP = clientIP(r)
+	c.ex.User = reqctx.User(r.Context())
+
+	r.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.TeeReader(r.Body, &c.body), r.Body}
+	w.CaptureBody(captureMaxResponse)
+
+	return c
+}
+
+// outcome records how the request was handled. Requests the proxy failed to handle are neither
+// accepted nor rejected.
+func (c *rpcCapture) outcome(out *transmissionproxy.RPCOutcome) {
+	if out.Request != nil {
+		c.ex.Method = out.Request.Method
+		c.ex.Tag = out.Request.Tag
+	}
+	switch {
+	case out.RejectReason != "":
+		c.ex.Verdict = capture.VerdictRejected
+		c.ex.RejectReason = out.RejectReason
+	case out.Accepted:
+		c.ex.Verdict = capture.VerdictAccepted
+		c.ex.Forwarded = string(out.Forwarded)
+	}
+}
+
+// finish submits the exchange for writing once the response is sent.
+func (c *rpcCapture) finish(cw *capture.Writer, w *response.Recorder) {
+	if c.ex.Verdict == "" {
+		c.ex.Verdict = verdictFailed
+	}
+	c.ex.Request = c.body.String()
+	c.ex.Status = w.Status()
+	c.ex.UpstreamStatus = w.UpstreamStatus()
+	c.ex.ContentEncoding = w.Header().Get("Content-Encoding")
+	body, trimmed := w.Body()
+	c.ex.Response = string(body)
+	c.ex.ResponseTruncated = trimmed
+	c.ex.DurationMs = float64(w.Duration()) / float64(time.Millisecond)
+
+	cw.Submit(&c.ex)
+}
+
+// captureState returns the state reported by the capture admin endpoint.
+func captureState(cw *capture.Writer) map[string]any {
+	return map[string]any{
+		"enabled": cw.Enabled(),
+		"dir":     cw.Dir(),
+		"dropped": cw.Dropped(),
+	}
+}
+
+// captureControl lets admins pause and resume capture at runtime.
+func captureControl(rr *response.Responder, cw *capture.Writer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if cw == nil {
+			rr.RespondAndLogCustom(w, r.Context(), fmt.Errorf("capture is not configured"), 0, slog.LevelWarn, http.StatusNotFound)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			writeJSON(w, r, http.StatusOK, captureState(cw))
+		case http.MethodPut:
+			var req struct {
+				Enabled *bool `json:"enabled"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Enabled == nil {
+				rr.RespondAndLogCustom(w, r.Context(), fmt.Errorf("failed to parse request: expected {\"enabled\": bool}"), 0, slog.LevelWarn, http.StatusBadRequest)
+				return
+			}
+
+			cw.SetEnabled(*req.Enabled)
+			slog.InfoContext(r.Context(), fmt.Sprintf("RPC capture enabled: %t", *req.Enabled))
+			writeJSON(w, r, http.StatusOK, captureState(cw))
+		default:
+			w.Header().Set("Allow", "GET, PUT")
+			rr.RespondAndLogCustom(w, r.Context(), fmt.Errorf("method not allowed"), 0, slog.LevelWarn, http.StatusMethodNotAllowed)
+		}
+	}
+}
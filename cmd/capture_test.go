@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"transmission-proxy/internal/capture"
+	"transmission-proxy/internal/response"
+	"transmission-proxy/internal/upstream"
+	"transmission-proxy/transmissionproxy"
+)
+
+// loadCaptured waits for n exchanges to be written to the directory and returns them.
+func loadCaptured(t *testing.T, dir string, n int) []*capture.Exchange {
+	t.Helper()
+
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(time.Millisecond) {
+		exchanges, err := capture.Load(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(exchanges) >= n {
+			return exchanges
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("got %d exchanges captured, want %d", len(exchanges), n)
+		}
+	}
+}
+
+func TestCaptureRPC(t *testing.T) {
+	captureLog(t)
+	dir := t.TempDir()
+	cw, err := capture.Open(dir, 100, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go cw.Run(ctx)
+
+	h := testRPCProxy(upstreamStatus(http.StatusOK, `{"arguments":{"torrents":[]},"result":"success","tag":1}`), func(cfg *rpcProxyConfig) {
+		cfg.capture = cw
+	})
+
+	accepted := `{"method":"torrent-get","arguments":{"fields":["id"],"bogus":1},"tag":1}`
+	rejected := `{"method":"torrent-add","arguments":{"metainfo":"c2VjcmV0","download-dir":"/etc"},"tag":2}`
+	postRPC(h, accepted)
+	postRPC(h, rejected)
+
+	exchanges := loadCaptured(t, dir, 2)
+	got, _ := json.Marshal(exchanges)
+	a, r := exchanges[0], exchanges[1]
+	// the request as received, the request as forwarded and the response to the client
+	if a.Method != "torrent-get" || a.Tag != 1 || a.Verdict != capture.VerdictAccepted || a.Request != accepted ||
+		a.Forwarded != `{"method":"torrent-get","arguments":{"fields":["id"]},"tag":1}` ||
+		a.Status != http.StatusOK || a.UpstreamStatus != http.StatusOK || !strings.Contains(a.Response, `"tag":1`) {
+		t.Errorf("got accepted exchange %s", got)
+	}
+	if r.Method != "torrent-add" || r.Verdict != capture.VerdictRejected || r.RejectReason == "" || r.Forwarded != "" ||
+		r.Status != http.StatusBadRequest || r.UpstreamStatus != 0 || strings.Contains(r.Request, "c2VjcmV0") {
+		t.Errorf("got rejected exchange %s", got)
+	}
+
+	// capture is paused at runtime
+	ctrl := captureControl(&response.Responder{}, cw)
+	w := httptest.NewRecorder()
+	ctrl.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/proxy/capture", strings.NewReader(`{"enabled":false}`)))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"enabled":false`) || cw.Enabled() {
+		t.Fatalf("got status %d, body %s", w.Code, w.Body)
+	}
+	postRPC(h, accepted)
+	// exchanges of paused capture are not even collected, so nothing can be queued
+	if exchanges := loadCaptured(t, dir, 2); len(exchanges) != 2 {
+		t.Errorf("got %d exchanges captured while paused", len(exchanges))
+	}
+
+	w = httptest.NewRecorder()
+	ctrl.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/proxy/capture", strings.NewReader(`{}`)))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("bad request: got status %d", w.Code)
+	}
+}
+
+// writeCaptured writes the exchanges to a new capture directory.
+func writeCaptured(t *testing.T, exchanges ...*capture.Exchange) string {
+	dir := t.TempDir()
+	for i, ex := range exchanges {
+		ex.Seq = uint64(i + 1)
+		bs, _ := json.Marshal(ex)
+		if err := os.WriteFile(filepath.Join(dir, fmt.Sprintf("%010d.json", ex.Seq)), bs, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return dir
+}
+
+func TestReplayValidator(t *testing.T) {
+	dir := writeCaptured(t,
+		&capture.Exchange{Method: "torrent-get", Request: `{"method":"torrent-get","arguments":{"fields":["id"]}}`, Verdict: capture.VerdictAccepted},
+		// the validator rejects it now
+		&capture.Exchange{Method: "torrent-set", Request: `{"method":"torrent-set","arguments":{"ids":[1],"location":"/etc"}}`, Verdict: capture.VerdictAccepted},
+		&capture.Exchange{Method: "torrent-add", Request: `{"method":"torrent-add","arguments":{"filename":"x","download-dir":"/etc"}}`,
+			Verdict: capture.VerdictRejected, RejectReason: "forbidden_location"},
+		// verdicts of policies and failures cannot be replayed by the validator
+		&capture.Exchange{Method: "torrent-remove", Request: `{"method":"torrent-remove","arguments":{"ids":[1]}}`,
+			Verdict: capture.VerdictRejected, RejectReason: "policy"},
+		&capture.Exchange{Method: "session-get", Request: `{"method":"session-get"}`, Verdict: verdictFailed},
+	)
+
+	var out bytes.Buffer
+	if code := replayCommand([]string{"--prefix=/downloads/", dir}, &out); code != 1 {
+		t.Errorf("got exit code %d", code)
+	}
+	if want := "#2 torrent-set: captured accepted, replayed rejected (forbidden_location)\nreplayed 3 exchanges: 1 differ, 2 skipped\n"; out.String() != want {
+		t.Errorf("got output %q, want %q", &out, want)
+	}
+
+	// with the validator of another prefix
+	out.Reset()
+	if code := replayCommand([]string{"--prefix=/", dir}, &out); code != 1 ||
+		out.String() != "#3 torrent-add: captured rejected (forbidden_location), replayed accepted\nreplayed 3 exchanges: 1 differ, 2 skipped\n" {
+		t.Errorf("got exit code %d, output %q", code, &out)
+	}
+
+	dir = writeCaptured(t, &capture.Exchange{Method: "session-get", Request: `{"method":"session-get"}`, Verdict: capture.VerdictAccepted})
+	out.Reset()
+	if code := replayCommand([]string{"--prefix=/downloads/", dir}, &out); code != 0 || out.String() != "replayed 1 exchanges: 0 differ, 0 skipped\n" {
+		t.Errorf("got exit code %d, output %q", code, &out)
+	}
+}
+
+func TestReplayTarget(t *testing.T) {
+	captureLog(t)
+	proxy := testRPCProxy(upstreamFunc(func(*http.Request) (*http.Response, error) {
+		return nil, errors.New("dry run forwarded")
+	}))
+	var conflicts int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(upstream.SessionIDHeader) != "sid" {
+			conflicts++
+			w.Header().Set(upstream.SessionIDHeader, "sid")
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		if !transmissionproxy.IsDryRun(r) {
+			t.Error("request replayed for real")
+		}
+		proxy.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+
+	dir := writeCaptured(t,
+		&capture.Exchange{Method: "torrent-get", Request: `{"method":"torrent-get","arguments":{"fields":["id"]}}`, Verdict: capture.VerdictAccepted},
+		&capture.Exchange{Method: "torrent-set", Request: `{"method":"torrent-set","arguments":{"ids":[1],"location":"/etc"}}`, Verdict: capture.VerdictAccepted},
+	)
+
+	var out bytes.Buffer
+	if code := replayCommand([]string{"--target=" + srv.URL + rpcPath, dir}, &out); code != 1 {
+		t.Errorf("got exit code %d", code)
+	}
+	if want := "#2 torrent-set: captured accepted, replayed rejected (status 400)\nreplayed 2 exchanges: 1 differ, 0 skipped\n"; out.String() != want {
+		t.Errorf("got output %q, want %q", &out, want)
+	}
+	if conflicts != 1 {
+		t.Errorf("session id negotiated %d times", conflicts)
+	}
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"time"
+
+	"transmission-proxy/internal/completion"
+	"transmission-proxy/internal/ownership"
+	"transmission-proxy/internal/upstream"
+	"transmission-proxy/internal/webhook"
+)
+
+var completionWebhookURL = os.Getenv("COMPLETION_WEBHOOK_URL")
+
+// startCompletionWatcher starts posting completed torrents to COMPLETION_WEBHOOK_URL. The store may be nil
+// if ownership is not tracked.
+func startCompletionWatcher(uc *upstream.Client, store ownership.Store) {
+	w := &completion.Watcher{
+		Upstream: uc,
+		Notifier: &webhook.Notifier{
+			URL:     completionWebhookURL,
+			Timeout: getDurationEnv("WEBHOOK_TIMEOUT", 5*time.Second),
+			HTTP:    &http.Client{},
+		},
+		Interval:         getDurationEnv("COMPLETION_POLL_INTERVAL", time.Minute),
+		StatePath:        os.Getenv("COMPLETION_STATE_FILE"),
+		Owners:           store,
+		OwnerLabelPrefix: ownerPrefix,
+	}
+
+	go w.Run(context.Background())
+}
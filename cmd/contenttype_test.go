@@ -0,0 +1,77 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"transmission-proxy/internal/logger"
+	"transmission-proxy/internal/response"
+	"transmission-proxy/internal/transmissiontest"
+	"transmission-proxy/transmissionproxy"
+)
+
+func TestCheckRPCRequest(t *testing.T) {
+	defer func(c transmissionproxy.RequestCheck) { rpcRequestCheck = c }(rpcRequestCheck)
+
+	cases := []struct {
+		name                string
+		require, missing    bool
+		method, contentType string
+		// status is the status the proxy answers with itself, 0 if the request is forwarded
+		status int
+	}{
+		{name: "json", require: true, method: http.MethodPost, contentType: "application/json"},
+		{name: "json with charset", require: true, method: http.MethodPost, contentType: "application/json; charset=utf-8"},
+		{name: "text", require: true, method: http.MethodPost, contentType: "text/plain", status: http.StatusUnsupportedMediaType},
+		{name: "form", require: true, method: http.MethodPost, contentType: "multipart/form-data; boundary=x", status: http.StatusUnsupportedMediaType},
+		{name: "malformed type", require: true, method: http.MethodPost, contentType: "application/json; ;", status: http.StatusUnsupportedMediaType},
+		{name: "missing accepted", require: true, method: http.MethodPost},
+		{name: "missing rejected", require: true, missing: true, method: http.MethodPost, status: http.StatusUnsupportedMediaType},
+		{name: "not required", method: http.MethodPost, contentType: "text/plain"},
+		// the method is checked regardless of the content type settings
+		{name: "get", method: http.MethodGet, status: http.StatusMethodNotAllowed},
+		{name: "put", require: true, method: http.MethodPut, contentType: "application/json", status: http.StatusMethodNotAllowed},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			rpcRequestCheck = transmissionproxy.RequestCheck{RequireJSONContentType: tc.require, RejectMissingContentType: tc.missing}
+			logs := captureLog(t)
+			up := &transmissiontest.Server{}
+			h := rpcPathHandler(&response.Responder{}, rpcPath, nil, testRPCProxy(up))
+
+			r := httptest.NewRequest(tc.method, rpcPath, strings.NewReader(`{"method":"session-get","tag":2}`))
+			if tc.contentType != "" {
+				r.Header.Set("Content-Type", tc.contentType)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+
+			if tc.status == 0 {
+				if w.Code != http.StatusOK || len(up.Bodies()) != 1 {
+					t.Errorf("got status %d, body %s, %d requests forwarded", w.Code, w.Body, len(up.Bodies()))
+				}
+				return
+			}
+
+			if w.Code != tc.status || len(up.Requests()) != 0 {
+				t.Fatalf("got status %d, body %s, %d requests forwarded", w.Code, w.Body, len(up.Requests()))
+			}
+			switch tc.status {
+			case http.StatusMethodNotAllowed:
+				rec := logRecord(t, logs, "must be sent with POST")
+				if a := w.Header().Get("Allow"); a != http.MethodPost || rec[logger.GroupHTTP].(map[string]any)[logger.KeyMethod] != tc.method {
+					t.Errorf("got Allow %q, record %v", a, rec)
+				}
+			case http.StatusUnsupportedMediaType:
+				// the offending type is logged
+				rec := logRecord(t, logs, "must be sent as application/json")
+				if rec[logger.GroupHTTP].(map[string]any)[logger.KeyContentType] != tc.contentType || rec["level"] != "WARN" {
+					t.Errorf("got record %v", rec)
+				}
+			}
+		})
+	}
+}
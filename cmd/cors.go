@@ -0,0 +1,72 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"transmission-proxy/internal/upstream"
+)
+
+// corsMaxAge is how long browsers may cache the answer to preflight requests, in seconds.
+const corsMaxAge = 600
+
+// corsAllowedOrigins lists origins of the web pages which may send RPC requests to the proxy, or "*" for any.
+var corsAllowedOrigins = getListEnv("CORS_ALLOWED_ORIGINS", "")
+
+// corsAllowedHeaders are the request headers RPC clients send.
+var corsAllowedHeaders = strings.Join([]string{"Authorization", "Content-Type", upstream.SessionIDHeader}, ", ")
+
+// withCORS lets pages of CORS_ALLOWED_ORIGINS call next from browsers: preflight requests are answered
+// by the proxy itself, before authentication, and other responses of next (including errors) are sent
+// with the CORS headers. Requests from other origins get no CORS headers, so browsers block them.
+func withCORS(next http.Handler) http.Handler {
+	if len(corsAllowedOrigins) == 0 {
+		return next
+	}
+	anyOrigin := slices.Contains(corsAllowedOrigins, "*")
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		h := w.Header()
+		h.Add("Vary", "Origin")
+		allowed := anyOrigin || slices.Contains(corsAllowedOrigins, origin)
+
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			if !allowed {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+
+			setCORSOrigin(h, origin, anyOrigin)
+			h.Set("Access-Control-Allow-Methods", "POST")
+			h.Set("Access-Control-Allow-Headers", corsAllowedHeaders)
+			h.Set("Access-Control-Max-Age", strconv.Itoa(corsMaxAge))
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		if allowed {
+			setCORSOrigin(h, origin, anyOrigin)
+			h.Set("Access-Control-Expose-Headers", upstream.SessionIDHeader+", X-Request-Id")
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// setCORSOrigin allows the origin. Browsers send credentials only to explicitly listed origins.
+func setCORSOrigin(h http.Header, origin string, anyOrigin bool) {
+	if anyOrigin {
+		h.Set("Access-Control-Allow-Origin", "*")
+		return
+	}
+
+	h.Set("Access-Control-Allow-Origin", origin)
+	h.Set("Access-Control-Allow-Credentials", "true")
+}
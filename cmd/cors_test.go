@@ -0,0 +1,102 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"transmission-proxy/internal/transmissiontest"
+)
+
+// corsRequest serves the RPC request from the page of the origin, omitted if empty.
+func corsRequest(h http.Handler, method, origin, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, rpcPath, strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	if origin != "" {
+		r.Header.Set("Origin", origin)
+	}
+	if method == http.MethodOptions {
+		r.Header.Set("Access-Control-Request-Method", http.MethodPost)
+		r.Header.Set("Access-Control-Request-Headers", "content-type, x-transmission-session-id")
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+
+	return w
+}
+
+func TestCORS(t *testing.T) {
+	defer func(origins []string) { corsAllowedOrigins = origins }(corsAllowedOrigins)
+	corsAllowedOrigins = []string{"https://ui.example"}
+	captureLog(t)
+	up := &transmissiontest.Server{}
+	h := withCORS(testRPCProxy(up))
+
+	// the preflight is answered by the proxy
+	w := corsRequest(h, http.MethodOptions, "https://ui.example", "")
+	if w.Code != http.StatusNoContent || len(up.Requests()) != 0 {
+		t.Fatalf("preflight: got status %d, %d upstream requests", w.Code, len(up.Requests()))
+	}
+	for k, want := range map[string]string{
+		"Access-Control-Allow-Origin":      "https://ui.example",
+		"Access-Control-Allow-Credentials": "true",
+		"Access-Control-Allow-Methods":     "POST",
+		"Access-Control-Allow-Headers":     "Authorization, Content-Type, X-Transmission-Session-Id",
+		"Access-Control-Max-Age":           "600",
+		"Vary":                             "Origin",
+	} {
+		if v := w.Header().Get(k); v != want {
+			t.Errorf("preflight: got %s %q, want %q", k, v, want)
+		}
+	}
+	if w := corsRequest(h, http.MethodOptions, "https://evil.example", ""); w.Code != http.StatusForbidden || w.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Errorf("preflight of other origin: got status %d, headers %v", w.Code, w.Header())
+	}
+
+	// the responses of the proxy carry the headers, errors included, so that the page can read them
+	for _, body := range []string{`{"method":"session-get"}`, `{"method":"torrent-set-location","arguments":{"ids":[1],"location":"/etc"}}`} {
+		w := corsRequest(h, http.MethodPost, "https://ui.example", body)
+		if w.Header().Get("Access-Control-Allow-Origin") != "https://ui.example" ||
+			!strings.Contains(w.Header().Get("Access-Control-Expose-Headers"), "X-Transmission-Session-Id") {
+			t.Errorf("%s: got status %d, headers %v", body, w.Code, w.Header())
+		}
+	}
+
+	// the other pages and the clients outside of browsers get no CORS headers
+	for _, origin := range []string{"https://evil.example", ""} {
+		w := corsRequest(h, http.MethodPost, origin, `{"method":"session-get"}`)
+		if w.Code != http.StatusOK || w.Header().Get("Access-Control-Allow-Origin") != "" || w.Header().Get("Access-Control-Expose-Headers") != "" {
+			t.Errorf("origin %q: got status %d, headers %v", origin, w.Code, w.Header())
+		}
+	}
+}
+
+func TestCORSAnyOrigin(t *testing.T) {
+	defer func(origins []string) { corsAllowedOrigins = origins }(corsAllowedOrigins)
+	corsAllowedOrigins = []string{"*"}
+	captureLog(t)
+	h := withCORS(testRPCProxy(&transmissiontest.Server{}))
+
+	// credentials are not sent to any origin
+	for _, method := range []string{http.MethodOptions, http.MethodPost} {
+		w := corsRequest(h, method, "https://ui.example", `{"method":"session-get"}`)
+		if w.Header().Get("Access-Control-Allow-Origin") != "*" || w.Header().Get("Access-Control-Allow-Credentials") != "" {
+			t.Errorf("%s: got status %d, headers %v", method, w.Code, w.Header())
+		}
+	}
+}
+
+func TestCORSDisabled(t *testing.T) {
+	defer func(origins []string) { corsAllowedOrigins = origins }(corsAllowedOrigins)
+	corsAllowedOrigins = nil
+	captureLog(t)
+	up := &transmissiontest.Server{}
+	h := withCORS(testRPCProxy(up))
+
+	// the preflight is an RPC request as any other
+	w := corsRequest(h, http.MethodOptions, "https://ui.example", "")
+	if w.Code == http.StatusNoContent || w.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Errorf("got status %d, headers %v", w.Code, w.Header())
+	}
+}
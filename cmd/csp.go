@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"transmission-proxy/internal/logger"
+)
+
+const (
+	cspHeader           = "Content-Security-Policy"
+	cspReportOnlyHeader = "Content-Security-Policy-Report-Only"
+	cspReportPath       = "/proxy/csp-report"
+)
+
+// defaultCSP allows what the stock web UI needs: its own scripts, styles (including inline ones), images
+// and RPC requests.
+const defaultCSP = "default-src 'self'; script-src 'self'; style-src 'self' 'unsafe-inline'; img-src 'self' data:; " +
+	"connect-src 'self'; object-src 'none'; base-uri 'self'; form-action 'self'"
+
+// cspReportMaxBytes limits the size of violation reports accepted.
+const cspReportMaxBytes = 64 << 10
+
+// cspReportLogWindow is the period during which repeated identical violations are logged only once.
+const cspReportLogWindow = 5 * time.Minute
+
+var (
+	cspPolicy      = getEnvOrDefault("CSP_POLICY", defaultCSP)
+	cspReportOnly  = getBoolEnv("CSP_REPORT_ONLY")
+	allowedOrigins = getListEnv("ALLOWED_ORIGINS", "")
+)
+
+// contentSecurityPolicy returns the header and the policy the web UI pages are sent with, or empty header
+// if CSP_POLICY is "none". Unless the policy sets frame-ancestors, the UI may only be framed by itself
+// and ALLOWED_ORIGINS.
+func contentSecurityPolicy() (header, policy string) {
+	policy = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(cspPolicy), ";"))
+	if policy == "none" {
+		return "", ""
+	}
+
+	if !strings.Contains(policy, "frame-ancestors") {
+		policy += "; frame-ancestors " + strings.Join(append([]string{"'self'"}, allowedOrigins...), " ")
+	}
+
+	if !cspReportOnly {
+		return cspHeader, policy
+	}
+
+	return cspReportOnlyHeader, policy + "; report-uri " + publicPrefix + cspReportPath
+}
+
+// withCSP sends HTML pages with the Content-Security-Policy header (or its report-only variant), replacing
+// any sent upstream. Other responses, e.g. scripts and styles, are left as they are.
+func withCSP(header, policy string, next http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(&cspWriter{ResponseWriter: w, header: header, policy: policy}, r)
+	}
+}
+
+type cspWriter struct {
+	http.ResponseWriter
+	header, policy string
+	wroteHeader    bool
+}
+
+func (c *cspWriter) WriteHeader(status int) {
+	if !c.wroteHeader {
+		c.wroteHeader = true
+
+		h := c.Header()
+		if strings.HasPrefix(strings.ToLower(h.Get("Content-Type")), "text/html") {
+			h.Del(cspHeader)
+			h.Del(cspReportOnlyHeader)
+			h.Set(c.header, c.policy)
+		}
+	}
+
+	c.ResponseWriter.WriteHeader(status)
+}
+
+func (c *cspWriter) Write(bs []byte) (int, error) {
+	if !c.wroteHeader {
+		c.WriteHeader(http.StatusOK)
+	}
+
+	return c.ResponseWriter.Write(bs)
+}
+
+func (c *cspWriter) Unwrap() http.ResponseWriter {
+	return c.ResponseWriter
+}
+
+// cspReport logs violation reports browsers send in report-only mode, in either the report-uri format
+// ({"csp-report": {...}}) or the Reporting API one ([{"type": "csp-violation", "body": {...}}]).
+func cspReport() http.HandlerFunc {
+	throttle := logger.NewThrottle(cspReportLogWindow)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", "POST")
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		bs, err := io.ReadAll(io.LimitReader(r.Body, cspReportMaxBytes))
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		var reports []map[string]any
+		var single struct {
+			Report map[string]any `json:"csp-report"`
+		}
+		if json.Unmarshal(bs, &single) == nil && single.Report != nil {
+			reports = append(reports, single.Report)
+		} else {
+			var batch []struct {
+				Body map[string]any `json:"body"`
+			}
+			if err := json.Unmarshal(bs, &batch); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			for _, b := range batch {
+				reports = append(reports, b.Body)
+			}
+		}
+
+		for _, rep := range reports {
+			directive := cspReportField(rep, "violated-directive", "effectiveDirective")
+			blocked := cspReportField(rep, "blocked-uri", "blockedURL")
+			lvl := throttle.Level(r.Context(), "csp "+directive+" "+blocked, slog.LevelWarn)
+			slog.Log(r.Context(), lvl, "content security policy violated",
+				slog.String("directive", directive),
+				slog.String("blocked", blocked),
+				slog.String("document", cspReportField(rep, "document-uri", "documentURL")))
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// cspReportField returns the field of the report by its name in either format.
+func cspReportField(rep map[string]any, names ...string) string {
+	for _, name := range names {
+		if s, ok := rep[name].(string); ok {
+			return s
+		}
+	}
+
+	return ""
+}
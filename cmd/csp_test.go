@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestContentSecurityPolicy(t *testing.T) {
+	defer func(policy string, reportOnly bool, origins []string, prefix string) {
+		cspPolicy, cspReportOnly, allowedOrigins, publicPrefix = policy, reportOnly, origins, prefix
+	}(cspPolicy, cspReportOnly, allowedOrigins, publicPrefix)
+
+	cases := []struct {
+		name, policy string
+		reportOnly   bool
+		origins      []string
+		prefix       string
+		header, want string
+	}{
+		{name: "default", policy: defaultCSP, header: cspHeader, want: defaultCSP + "; frame-ancestors 'self'"},
+		{name: "allowed origins", policy: "default-src 'self';", origins: []string{"https://home.example", "https://nas.example"},
+			header: cspHeader, want: "default-src 'self'; frame-ancestors 'self' https://home.example https://nas.example"},
+		{name: "frame-ancestors set", policy: "default-src 'self'; frame-ancestors 'none'", origins: []string{"https://home.example"},
+			header: cspHeader, want: "default-src 'self'; frame-ancestors 'none'"},
+		{name: "report only", policy: "default-src 'self'", reportOnly: true, prefix: "/torrents",
+			header: cspReportOnlyHeader, want: "default-src 'self'; frame-ancestors 'self'; report-uri /torrents/proxy/csp-report"},
+		{name: "disabled", policy: " none ", reportOnly: true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			cspPolicy, cspReportOnly, allowedOrigins, publicPrefix = tc.policy, tc.reportOnly, tc.origins, tc.prefix
+			if header, policy := contentSecurityPolicy(); header != tc.header || policy != tc.want {
+				t.Errorf("got %s: %s\nwant %s: %s", header, policy, tc.header, tc.want)
+			}
+		})
+	}
+}
+
+// serveWeb serves the response of the web UI with the content type and headers through withCSP.
+func serveWeb(header, policy, contentType string, upstreamHeader ...string) http.Header {
+	h := withCSP(header, policy, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", contentType)
+		for i := 0; i+1 < len(upstreamHeader); i += 2 {
+			w.Header().Set(upstreamHeader[i], upstreamHeader[i+1])
+		}
+		_, _ = w.Write([]byte("<html></html>"))
+	}))
+
+	w := httptest.NewRecorder()
+	h(w, httptest.NewRequest(http.MethodGet, "/transmission/web/", nil))
+	return w.Header()
+}
+
+func TestWithCSP(t *testing.T) {
+	const policy = "default-src 'self'; frame-ancestors 'self'"
+
+	for _, ct := range []string{"text/html", "text/html; charset=utf-8", "Text/HTML"} {
+		if got := serveWeb(cspHeader, policy, ct).Values(cspHeader); len(got) != 1 || got[0] != policy {
+			t.Errorf("%s: got %v", ct, got)
+		}
+	}
+
+	// the policy sent upstream is replaced, whichever variant it is
+	h := serveWeb(cspHeader, policy, "text/html", cspHeader, "default-src *", cspReportOnlyHeader, "default-src *")
+	if h.Get(cspHeader) != policy || h.Get(cspReportOnlyHeader) != "" {
+		t.Errorf("upstream policy: got headers %v", h)
+	}
+	h = serveWeb(cspReportOnlyHeader, policy, "text/html", cspHeader, "default-src *")
+	if h.Get(cspReportOnlyHeader) != policy || h.Get(cspHeader) != "" {
+		t.Errorf("report only: got headers %v", h)
+	}
+
+	// assets are sent as they are
+	for _, ct := range []string{"application/javascript", "text/css", "image/png", "application/json"} {
+		if h := serveWeb(cspHeader, policy, ct); h.Get(cspHeader) != "" || h.Get(cspReportOnlyHeader) != "" {
+			t.Errorf("%s: got headers %v", ct, h)
+		}
+	}
+	if h := serveWeb(cspHeader, policy, "image/svg+xml", cspHeader, "default-src 'none'"); h.Get(cspHeader) != "default-src 'none'" {
+		t.Errorf("asset with policy: got headers %v", h)
+	}
+}
+
+func TestCSPReport(t *testing.T) {
+	logs := captureLog(t)
+	h := cspReport()
+	post := func(body string) int {
+		w := httptest.NewRecorder()
+		h(w, httptest.NewRequest(http.MethodPost, cspReportPath, strings.NewReader(body)))
+		return w.Code
+	}
+
+	// report-uri format
+	if code := post(`{"csp-report":{"document-uri":"https://nas.example/transmission/web/","violated-directive":"script-src-elem",` +
+		`"blocked-uri":"https://cdn.example/x.js"}}`); code != http.StatusNoContent {
+		t.Errorf("report-uri report: got status %d", code)
+	}
+	// Reporting API format, batched
+	if code := post(`[{"type":"csp-violation","body":{"documentURL":"https://nas.example/transmission/web/",` +
+		`"effectiveDirective":"style-src","blockedURL":"inline"}},{"type":"csp-violation","body":{"effectiveDirective":"style-src",` +
+		`"blockedURL":"inline"}}]`); code != http.StatusNoContent {
+		t.Errorf("Reporting API report: got status %d", code)
+	}
+
+	var recs []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(logs.String()), "\n") {
+		var rec map[string]any
+		if err := json.Unmarshal([]byte(line), &rec); err != nil {
+			t.Fatal(err)
+		}
+		if rec["msg"] == "content security policy violated" {
+			recs = append(recs, rec)
+		}
+	}
+	if len(recs) != 3 {
+		t.Fatalf("got %d violations logged, want 3:\n%s", len(recs), logs)
+	}
+	if r := recs[0]; r["level"] != "WARN" || r["directive"] != "script-src-elem" || r["blocked"] != "https://cdn.example/x.js" ||
+		r["document"] != "https://nas.example/transmission/web/" {
+		t.Errorf("got record %v", r)
+	}
+	if r := recs[1]; r["level"] != "WARN" || r["directive"] != "style-src" || r["blocked"] != "inline" {
+		t.Errorf("got record %v", r)
+	}
+	// repeated violations are logged at debug level
+	if r := recs[2]; r["level"] != "DEBUG" {
+		t.Errorf("got repeated record %v", r)
+	}
+
+	for _, body := range []string{"", `{"csp-report":`, `"report"`} {
+		if code := post(body); code != http.StatusBadRequest {
+			t.Errorf("%q: got status %d", body, code)
+		}
+	}
+	w := httptest.NewRecorder()
+	h(w, httptest.NewRequest(http.MethodGet, cspReportPath, nil))
+	if w.Code != http.StatusMethodNotAllowed || w.Header().Get("Allow") != "POST" {
+		t.Errorf("GET: got status %d", w.Code)
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"transmission-proxy/internal/audit"
+	"transmission-proxy/internal/jrpc"
+	"transmission-proxy/internal/logger"
+	"transmission-proxy/internal/response"
+	"transmission-proxy/internal/transmission"
+)
+
+// rejectDrain is the reject reason for changes refused in drain mode.
+const rejectDrain = "drain"
+
+// Audit methods of drain mode transitions.
+const (
+	auditDrain   = "proxy-drain"
+	auditUndrain = "proxy-undrain"
+)
+
+const defaultDrainMessage = "Transmission is under maintenance, changes are not accepted at the moment"
+
+// drainMode refuses requests for methods changing state (see transmission.ReadOnlyMethods) while the proxy is
+// drained, e.g. for upgrading Transmission, letting users still see their torrents.
+type drainMode struct {
+	retryAfter time.Duration
+
+	mu       sync.Mutex
+	draining bool
+	since    time.Time
+	message  string
+}
+
+func newDrainMode() *drainMode {
+	d := &drainMode{retryAfter: getDurationEnv("DRAIN_RETRY_AFTER", 5*time.Minute)}
+	if getBoolEnv("DRAIN_MODE") {
+		d.set(true, "")
+		slog.Warn("proxy starts drained, changes are refused until /proxy/undrain")
+	}
+
+	return d
+}
+
+// set drains or undrains the proxy, with the message returned to refused requests (default if empty).
+// Returns false if the proxy is in the mode already.
+func (d *drainMode) set(draining bool, message string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.draining == draining {
+		return false
+	}
+
+	d.draining, d.since, d.message = draining, time.Now(), orDefault(message, defaultDrainMessage)
+	return true
+}
+
+// refuses returns the message to refuse the request for the method with, or empty string if it is allowed.
+func (d *drainMode) refuses(method string) string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if !d.draining || slices.Contains(transmission.ReadOnlyMethods, method) {
+		return ""
+	}
+
+	return d.message
+}
+
+func (d *drainMode) ready() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return !d.draining
+}
+
+func (d *drainMode) state() map[string]any {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	data := map[string]any{"draining": d.draining}
+	if d.draining {
+		data["since"] = d.since
+		data["message"] = d.message
+	}
+
+	return data
+}
+
+// refuse answers the RPC request refused in drain mode with 503 and the message as result.
+func (d *drainMode) refuse(w http.ResponseWriter, r *http.Request, req *jrpc.Request, message string) {
+	slog.InfoContext(r.Context(), "RPC request refused in drain mode",
+		logger.RPCMethod(req.Method),
+		logger.RPCTag(req.Tag),
+		logger.RPCRejectReason(rejectDrain),
+		logger.HTTPStatus(http.StatusServiceUnavailable))
+
+	w.Header().Set("Retry-After", strconv.Itoa(int(d.retryAfter.Seconds())))
+	writeJSON(w, r, http.StatusServiceUnavailable, &jrpc.Response{Result: message, Arguments: map[string]any{}, Tag: req.Tag, HasTag: req.HasTag})
+}
+
+// drainControl drains (POST /proxy/drain with optional {"message"}) or undrains (POST /proxy/undrain) the proxy.
+func drainControl(rr *response.Responder, d *drainMode, al *audit.Log, draining bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", "POST")
+			rr.RespondAndLogCustom(w, r.Context(), fmt.Errorf("method not allowed"), 0, slog.LevelWarn, http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req struct {
+			Message string `json:"message"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+			rr.RespondAndLogCustom(w, r.Context(), fmt.Errorf("failed to parse request: %w", err), 0, slog.LevelWarn, http.StatusBadRequest)
+			return
+		}
+
+		if d.set(draining, req.Message) {
+			method, msg := auditUndrain, "proxy undrained by admin, changes are accepted again"
+			if draining {
+				method, msg = auditDrain, "proxy drained by admin, changes are refused"
+			}
+
+			slog.WarnContext(r.Context(), msg)
+			if al != nil {
+				if err := al.Write(&audit.Record{Time: time.Now(), ClientIP: clientIP(r), Method: method, Result: jrpc.ResultSuccess}); err != nil {
+					slog.ErrorContext(r.Context(), "failed to write audit log: "+err.Error(), logger.IgnoredAttr(err))
+				}
+			}
+		}
+
+		writeJSON(w, r, http.StatusOK, d.state())
+	}
+}
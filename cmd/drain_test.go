@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"transmission-proxy/internal/audit"
+	"transmission-proxy/internal/response"
+	"transmission-proxy/internal/upstream"
+)
+
+func TestDrainRefuses(t *testing.T) {
+	d := &drainMode{}
+	methods := []string{"torrent-get", "session-get", "session-stats", "free-space", "port-test", "group-get",
+		"torrent-add", "torrent-remove", "torrent-start", "torrent-start-now", "torrent-stop", "torrent-verify",
+		"torrent-reannounce", "torrent-set", "torrent-set-location", "torrent-rename-path", "session-set",
+		"blocklist-update", "queue-move-top", "group-set", "session-close", "no-such-method"}
+	for _, method := range methods {
+		if msg := d.refuses(method); msg != "" {
+			t.Errorf("%s: refused while not drained", method)
+		}
+	}
+
+	d.set(true, "")
+	for _, method := range methods {
+		// reading methods go on, the others refused, including those not known
+		readOnly := method == "torrent-get" || strings.HasSuffix(method, "-get") || method == "session-stats" ||
+			method == "free-space" || method == "port-test"
+		if msg := d.refuses(method); readOnly != (msg == "") {
+			t.Errorf("%s: got message %q while drained", method, msg)
+		} else if !readOnly && msg != defaultDrainMessage {
+			t.Errorf("%s: got message %q, want the default", method, msg)
+		}
+	}
+
+	if d.set(true, "again") || d.refuses("torrent-add") != defaultDrainMessage {
+		t.Error("draining again changed the mode")
+	}
+	if !d.set(false, "") || d.refuses("torrent-add") != "" {
+		t.Error("undraining did not accept changes")
+	}
+	d.set(true, "upgrading to 4.1")
+	if msg := d.refuses("torrent-add"); msg != "upgrading to 4.1" {
+		t.Errorf("got message %q, want the one given", msg)
+	}
+}
+
+func TestDrainRPC(t *testing.T) {
+	captureLog(t)
+	d := &drainMode{retryAfter: 2 * time.Minute}
+	d.set(true, "upgrading to 4.1")
+	var forwarded []string
+	h := testRPCProxy(recordingUpstream(`{"arguments":{"torrents":[]},"result":"success"}`, &forwarded), func(cfg *rpcProxyConfig) {
+		cfg.drain = d
+	})
+
+	w := postRPC(h, `{"method":"torrent-add","arguments":{"filename":"magnet:?xt=urn:btih:aaaa"},"tag":5}`)
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") != "120" ||
+		strings.TrimSpace(w.Body.String()) != `{"result":"upgrading to 4.1","arguments":{},"tag":5}` {
+		t.Errorf("torrent-add: got status %d, Retry-After %q, body %s", w.Code, w.Header().Get("Retry-After"), w.Body)
+	}
+	if w := postRPC(h, `{"method":"torrent-get","arguments":{"fields":["id"]}}`); w.Code != http.StatusOK {
+		t.Errorf("torrent-get: got status %d, body %s", w.Code, w.Body)
+	}
+	if len(forwarded) != 1 || !strings.Contains(forwarded[0], "torrent-get") {
+		t.Errorf("got forwarded %v, want only torrent-get", forwarded)
+	}
+}
+
+func TestDrainReadiness(t *testing.T) {
+	captureLog(t)
+	var checks atomic.Int32
+	uc := &upstream.Client{URL: "http://transmission:9091/transmission/rpc", HTTP: &http.Client{Transport: upstreamFunc(func(r *http.Request) (*http.Response, error) {
+		checks.Add(1)
+		return upstreamStatus(http.StatusOK, `{"arguments":{"version":"4.0.5"},"result":"success"}`)(r)
+	})}}
+	d := &drainMode{}
+	h := readyz(&response.Responder{}, d, &upstreamCheck{uc: uc})
+	ready := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		return w
+	}
+
+	if w := ready(); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"version":"4.0.5"`) {
+		t.Errorf("not drained: got status %d, body %s", w.Code, w.Body)
+	}
+
+	// the proxy is not ready while drained, whatever the upstream
+	d.set(true, "")
+	if w := ready(); w.Code != http.StatusServiceUnavailable || strings.TrimSpace(w.Body.String()) != `{"status":"draining"}` {
+		t.Errorf("drained: got status %d, body %s", w.Code, w.Body)
+	}
+	if n := checks.Load(); n != 1 {
+		t.Errorf("got %d upstream checks, want none while drained", n-1)
+	}
+
+	d.set(false, "")
+	if w := ready(); w.Code != http.StatusOK {
+		t.Errorf("undrained: got status %d, body %s", w.Code, w.Body)
+	}
+}
+
+func TestDrainControl(t *testing.T) {
+	captureLog(t)
+	path := filepath.Join(t.TempDir(), "audit.log")
+	al, err := audit.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = al.Close() }()
+
+	d := &drainMode{}
+	rr := &response.Responder{DebugMode: true}
+	drain, undrain := drainControl(rr, d, al, true), drainControl(rr, d, al, false)
+	post := func(h http.HandlerFunc, body string) map[string]any {
+		t.Helper()
+		w := httptest.NewRecorder()
+		h(w, httptest.NewRequest(http.MethodPost, "/proxy/drain", strings.NewReader(body)))
+		var state map[string]any
+		if err := json.Unmarshal(w.Body.Bytes(), &state); err != nil || w.Code != http.StatusOK {
+			t.Fatalf("got status %d, body %s", w.Code, w.Body)
+		}
+		return state
+	}
+
+	if state := post(drain, `{"message":"upgrading to 4.1"}`); state["draining"] != true || state["message"] != "upgrading to 4.1" || state["since"] == nil {
+		t.Errorf("drain: got state %v", state)
+	}
+	// draining again changes nothing
+	if state := post(drain, ""); state["message"] != "upgrading to 4.1" {
+		t.Errorf("drain again: got state %v", state)
+	}
+	if state := post(undrain, ""); len(state) != 1 || state["draining"] != false {
+		t.Errorf("undrain: got state %v", state)
+	}
+
+	bs, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var methods []string
+	for _, line := range strings.Split(strings.TrimSpace(string(bs)), "\n") {
+		var rec audit.Record
+		if err := json.Unmarshal([]byte(line), &rec); err != nil {
+			t.Fatal(err)
+		}
+		methods = append(methods, rec.Method)
+	}
+	if strings.Join(methods, ",") != "proxy-drain,proxy-undrain" {
+		t.Errorf("got audit records %v, want one for every transition", methods)
+	}
+
+	w := httptest.NewRecorder()
+	drain(w, httptest.NewRequest(http.MethodPost, "/proxy/drain", strings.NewReader(`{"message":`)))
+	if w.Code != http.StatusBadRequest || !d.ready() {
+		t.Errorf("bad body: got status %d, ready %v", w.Code, d.ready())
+	}
+	w = httptest.NewRecorder()
+	drain(w, httptest.NewRequest(http.MethodGet, "/proxy/drain", nil))
+	if w.Code != http.StatusMethodNotAllowed || w.Header().Get("Allow") != "POST" {
+		t.Errorf("GET: got status %d", w.Code)
+	}
+}
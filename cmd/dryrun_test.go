@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"transmission-proxy/internal/audit"
+	"transmission-proxy/internal/events"
+	"transmission-proxy/internal/policy"
+	"transmission-proxy/internal/response"
+	"transmission-proxy/internal/roles"
+	"transmission-proxy/transmissionproxy"
+)
+
+// testDryRun returns the RPC handler jailing users to their subdirectories of /downloads/, with the requests it
+// forwarded, its audit log path and event bus.
+func testDryRun(t *testing.T) (http.Handler, *[]string, string, *events.Bus) {
+	auditPath := filepath.Join(t.TempDir(), "audit.log")
+	al, err := audit.Open(auditPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = al.Close() })
+
+	var forwarded []string
+	bus := events.NewBus()
+	h := testRPCProxy(recordingUpstream(`{"arguments":{},"result":"success"}`, &forwarded), func(cfg *rpcProxyConfig) {
+		cfg.policies = []policy.Policy{&policy.UserSubdir{
+			Prefix: "/downloads/",
+			Roles:  roles.New(nil),
+			Exists: func(user string) bool { return user == "alice" || user == "bob" },
+		}}
+		cfg.audit = al
+		cfg.events = bus
+		cfg.responder = &response.Responder{DebugMode: true}
+	})
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Header.Set(transmissionproxy.DryRunHeader, "1")
+		h.ServeHTTP(w, r)
+	}), &forwarded, auditPath, bus
+}
+
+func TestDryRunAccepted(t *testing.T) {
+	logs := captureLog(t)
+	h, forwarded, auditPath, _ := testDryRun(t)
+
+	w := postAs(h, "alice", "", `{"method":"torrent-add","tag":5,"arguments":`+
+		`{"filename":"magnet:?xt=urn:btih:abc","download-dir":"/downloads/movies","paused":true,"no-such-argument":1}}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d, body %s", w.Code, w.Body)
+	}
+
+	var resp struct {
+		Result    string `json:"result"`
+		Tag       int    `json:"tag"`
+		Arguments struct {
+			DryRun    bool           `json:"dry-run"`
+			Dropped   []string       `json:"dropped"`
+			Rewritten []string       `json:"rewritten"`
+			Arguments map[string]any `json:"arguments"`
+		} `json:"arguments"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	args := resp.Arguments
+	if resp.Result != "success" || resp.Tag != 5 || !args.DryRun {
+		t.Errorf("got response %s", w.Body)
+	}
+	if strings.Join(args.Dropped, ",") != "no-such-argument" || strings.Join(args.Rewritten, ",") != "download-dir" {
+		t.Errorf("got dropped %v, rewritten %v", args.Dropped, args.Rewritten)
+	}
+	if args.Arguments["download-dir"] != "/downloads/alice/movies" || args.Arguments["paused"] != true {
+		t.Errorf("got arguments %v, want those which would be forwarded", args.Arguments)
+	}
+
+	if len(*forwarded) != 0 {
+		t.Errorf("dry run forwarded %v", *forwarded)
+	}
+	if bs, _ := os.ReadFile(auditPath); len(bs) != 0 {
+		t.Errorf("dry run audited: %s", bs)
+	}
+	if rec := logRecord(t, logs, "RPC dry run accepted"); rec["level"] != "INFO" {
+		t.Errorf("got log record %v", rec)
+	}
+}
+
+func TestDryRunRejected(t *testing.T) {
+	logs := captureLog(t)
+	h, forwarded, auditPath, bus := testDryRun(t)
+	sub := bus.Subscribe(10, nil)
+
+	w := postAs(h, "alice", "", `{"method":"torrent-add","tag":6,"arguments":`+
+		`{"filename":"magnet:?xt=urn:btih:abc","download-dir":"/downloads/bob"}}`)
+	if w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), "download-dir points to directory of another user") {
+		t.Errorf("got status %d, body %s, want the normal rejection", w.Code, w.Body)
+	}
+
+	if len(*forwarded) != 0 {
+		t.Errorf("dry run forwarded %v", *forwarded)
+	}
+	if bs, _ := os.ReadFile(auditPath); len(bs) != 0 {
+		t.Errorf("rejected dry run audited: %s", bs)
+	}
+	select {
+	case e := <-sub.C:
+		t.Errorf("rejected dry run streamed: %+v", e)
+	default:
+	}
+
+	rec := logRecord(t, logs, "download-dir points to directory of another user")
+	if attrs, _ := rec["rpc"].(map[string]any); attrs["dry_run"] != true {
+		t.Errorf("got log record %v, want it marked as dry run", rec)
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+
+	"transmission-proxy/internal/jrpc"
+	"transmission-proxy/internal/logger"
+	"transmission-proxy/internal/policy"
+	"transmission-proxy/internal/reqctx"
+	"transmission-proxy/internal/stats"
+	"transmission-proxy/internal/transmission"
+)
+
+// Modes of ENFORCEMENT_MODE.
+const (
+	modeEnforce = "enforce"
+	modeShadow  = "shadow"
+)
+
+// rejectRewrite is the reject reason of requests which checks in shadow mode would have changed.
+const rejectRewrite = "rewrite"
+
+// groupValidation is the feature group of request validation: arguments, their types and locations.
+const groupValidation = "validation"
+
+// enforcementGroups are the feature groups which may run in shadow mode: validation and the policies,
+// named as in their violations.
+var enforcementGroups = []string{groupValidation, "groups", "labels", "owner_label", "user_subdir", "ownership", "quota"}
+
+// enforcement tells which feature groups run in shadow mode, only reporting the requests they would have
+// rejected or changed.
+type enforcement map[string]bool
+
+// parseEnforcement parses the comma-separated list of the default mode and per group overrides,
+// e.g. "shadow,user_subdir=enforce". Groups are enforced by default.
+func parseEnforcement(s string) (enforcement, error) {
+	def := false
+	overrides := map[string]bool{}
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+
+		group, mode, ok := strings.Cut(item, "=")
+		if !ok {
+			group, mode = "", item
+		}
+		if mode != modeEnforce && mode != modeShadow {
+			return nil, fmt.Errorf("unknown mode %q", mode)
+		}
+
+		if group == "" {
+			def = mode == modeShadow
+		} else if slices.Contains(enforcementGroups, group) {
+			overrides[group] = mode == modeShadow
+		} else {
+			return nil, fmt.Errorf("unknown group %q", group)
+		}
+	}
+
+	res := enforcement{}
+	for _, group := range enforcementGroups {
+		shadow, ok := overrides[group]
+		if !ok {
+			shadow = def
+		}
+		res[group] = shadow
+	}
+
+	return res, nil
+}
+
+// policy returns the policy of the group, shadowed if the group runs in shadow mode.
+func (e enforcement) policy(group string, p policy.Policy, st *stats.Registry) policy.Policy {
+	if !e[group] {
+		return p
+	}
+
+	return &policy.Shadow{
+		Name:   group,
+		Policy: p,
+		Report: func(ctx context.Context, req *jrpc.Request, v *policy.Violation, changed bool) {
+			reason := transmission.RejectPolicy
+			if changed {
+				reason = rejectRewrite
+			}
+			recordShadow(ctx, st, group, req, v, reason)
+		},
+	}
+}
+
+// recordShadow logs and records the request forwarded although the check of the group running in shadow mode
+// would have rejected or changed it.
+func recordShadow(ctx context.Context, st *stats.Registry, group string, req *jrpc.Request, err error, reason string) {
+	err = logger.WithAttributes(err, logger.RPCRejectReason(reason))
+
+	rej := stats.Rejection{
+		Time:   time.Now(),
+		Method: req.Method,
+		Tag:    req.Tag,
+		Reason: err.Error(),
+	}
+	if ip := reqctx.ClientIP(ctx); ip.IsValid() {
+		rej.ClientIP = ip.String()
+	}
+	st.RecordShadowRejection(group, rej)
+
+	slog.WarnContext(ctx, "shadow "+group+": RPC request forwarded as is: "+err.Error(),
+		logger.IgnoredAttr(err),
+		logger.RPCTag(req.Tag))
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"maps"
+	"net/http"
+	"strings"
+	"testing"
+
+	"transmission-proxy/internal/policy"
+	"transmission-proxy/internal/roles"
+	"transmission-proxy/internal/stats"
+)
+
+func TestParseEnforcement(t *testing.T) {
+	enforced := func(shadowed ...string) enforcement {
+		res := enforcement{}
+		for _, group := range enforcementGroups {
+			res[group] = false
+		}
+		for _, group := range shadowed {
+			res[group] = true
+		}
+		return res
+	}
+
+	cases := []struct {
+		mode string
+		want enforcement
+		err  string
+	}{
+		{mode: "", want: enforced()},
+		{mode: "enforce", want: enforced()},
+		{mode: "shadow", want: enforced(enforcementGroups...)},
+		{mode: "shadow, user_subdir=enforce", want: enforced(groupValidation, "groups", "labels", "owner_label", "ownership", "quota")},
+		{mode: "quota=shadow,validation=shadow", want: enforced("quota", groupValidation)},
+		{mode: "quota=shadow,enforce", want: enforced("quota")},
+		{mode: "lenient", err: `unknown mode "lenient"`},
+		{mode: "quotas=shadow", err: `unknown group "quotas"`},
+	}
+
+	for _, tc := range cases {
+		got, err := parseEnforcement(tc.mode)
+		if tc.err != "" {
+			if err == nil || err.Error() != tc.err {
+				t.Errorf("%q: got error %v, want %q", tc.mode, err, tc.err)
+			}
+			continue
+		}
+		if err != nil || !maps.Equal(got, tc.want) {
+			t.Errorf("%q: got %v, %v, want %v", tc.mode, got, err, tc.want)
+		}
+	}
+}
+
+// enforcementRequests are rejected by validation, rejected by the user_subdir policy, and rewritten by it.
+var enforcementRequests = []string{
+	`{"method":"torrent-remove","arguments":{"ids":[1],"delete-local-data":"yes"},"tag":1}`,
+	`{"method":"torrent-add","arguments":{"filename":"magnet:?xt=urn:btih:abc","download-dir":"/downloads/bob"},"tag":2}`,
+	`{"method":"torrent-add","arguments":{"filename":"magnet:?xt=urn:btih:abc","download-dir":"/downloads/x"},"tag":3}`,
+}
+
+// testEnforcement returns the RPC handler with validation and the user_subdir policy in the mode, along with
+// the requests it forwarded and its statistics.
+func testEnforcement(t *testing.T, mode string) (http.Handler, *[]string, *stats.Registry) {
+	em, err := parseEnforcement(mode)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var forwarded []string
+	st := stats.NewRegistry()
+	h := testRPCProxy(recordingUpstream(`{"arguments":{},"result":"success"}`, &forwarded), func(cfg *rpcProxyConfig) {
+		cfg.shadowValidation = em[groupValidation]
+		cfg.policies = []policy.Policy{em.policy("user_subdir", &policy.UserSubdir{
+			Prefix: "/downloads/",
+			Roles:  roles.New(nil),
+			Exists: func(user string) bool { return user == "alice" || user == "bob" },
+		}, st)}
+		cfg.stats = st
+	})
+
+	return h, &forwarded, st
+}
+
+func TestShadowEnforcement(t *testing.T) {
+	logs := captureLog(t)
+
+	enforce, enforceForwarded, enforceStats := testEnforcement(t, "enforce")
+	shadow, shadowForwarded, shadowStats := testEnforcement(t, "shadow")
+	for _, body := range enforcementRequests {
+		postAs(enforce, "alice", "", body)
+		if w := postAs(shadow, "alice", "", body); w.Code != http.StatusOK {
+			t.Errorf("shadow: got status %d, body %s", w.Code, w.Body)
+		}
+	}
+
+	// the requests are forwarded byte for byte
+	if strings.Join(*shadowForwarded, "\n") != strings.Join(enforcementRequests, "\n") {
+		t.Errorf("shadow forwarded\n%s\nwant\n%s", strings.Join(*shadowForwarded, "\n"), strings.Join(enforcementRequests, "\n"))
+	}
+	if len(*enforceForwarded) != 1 || !strings.Contains((*enforceForwarded)[0], `"/downloads/alice/x"`) {
+		t.Errorf("enforce forwarded %v", *enforceForwarded)
+	}
+
+	// the records are those of enforcing mode, flagged
+	enforced, shadowed := enforceStats.RecentRejections(), shadowStats.RecentRejections()
+	if len(enforced) != 2 || len(shadowed) != 3 {
+		t.Fatalf("got %d rejections enforcing and %d in shadow mode", len(enforced), len(shadowed))
+	}
+	for i, rej := range enforced {
+		// the most recent first
+		if s := shadowed[i+1]; !s.Shadow || rej.Shadow || s.Method != rej.Method || s.Tag != rej.Tag || s.Reason != rej.Reason {
+			t.Errorf("got shadow rejection %+v, want as %+v", s, rej)
+		}
+	}
+	if s := shadowed[0]; !s.Shadow || s.Tag != 3 || s.Reason != "would rewrite download-dir in request" {
+		t.Errorf("got shadow rejection %+v, want the rewrite", s)
+	}
+
+	if got := shadowStats.ShadowRejections(); !maps.Equal(got, map[string]uint64{groupValidation: 1, "user_subdir": 2}) {
+		t.Errorf("got counts %v", got)
+	}
+	if n := strings.Count(logs.String(), `"msg":"shadow user_subdir: RPC request forwarded as is: `); n != 2 {
+		t.Errorf("got %d shadow user_subdir records logged, want 2:\n%s", n, logs)
+	}
+}
+
+func TestShadowEnforcementPerGroup(t *testing.T) {
+	// validation is only reported, the locations are enforced
+	h, forwarded, st := testEnforcement(t, "validation=shadow")
+	for _, body := range enforcementRequests {
+		postAs(h, "alice", "", body)
+	}
+
+	if len(*forwarded) != 2 || (*forwarded)[0] != enforcementRequests[0] || !strings.Contains((*forwarded)[1], `"/downloads/alice/x"`) {
+		t.Errorf("got forwarded %v", *forwarded)
+	}
+	if got := st.ShadowRejections(); !maps.Equal(got, map[string]uint64{groupValidation: 1}) {
+		t.Errorf("got counts %v", got)
+	}
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"html/template"
+	"log/slog"
+	"os"
+
+	"transmission-proxy/internal/logger"
+	"transmission-proxy/internal/response"
+)
+
+// errorPageFromEnv returns the template of the error page shown to browsers on the web paths: the built-in one,
+// the one from ERROR_PAGE_TEMPLATE file, or nil if it is "none".
+func errorPageFromEnv() *template.Template {
+	name := os.Getenv("ERROR_PAGE_TEMPLATE")
+	if name == "none" {
+		return nil
+	}
+
+	t, err := response.LoadErrorPage(name)
+	if err != nil {
+		slog.Error("failed to load ERROR_PAGE_TEMPLATE: "+err.Error(), logger.IgnoredAttr(err))
+		os.Exit(1)
+	}
+
+	return t
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"transmission-proxy/internal/response"
+	"transmission-proxy/transmissionproxy"
+)
+
+func TestErrorPageFromEnv(t *testing.T) {
+	t.Setenv("ERROR_PAGE_TEMPLATE", "none")
+	if errorPageFromEnv() != nil {
+		t.Error("got error page with none")
+	}
+
+	t.Setenv("ERROR_PAGE_TEMPLATE", "")
+	if errorPageFromEnv() == nil {
+		t.Error("got no built-in error page")
+	}
+
+	name := filepath.Join(t.TempDir(), "error.html")
+	if err := os.WriteFile(name, []byte(`<p>{{.Message}}</p>`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("ERROR_PAGE_TEMPLATE", name)
+	if page := errorPageFromEnv(); page == nil || page.Tree.Root.String() != "<p>{{.Message}}</p>" {
+		t.Errorf("got page %v, want the one of the file", page)
+	}
+}
+
+func TestWebErrorPage(t *testing.T) {
+	logs := captureLog(t)
+	u, _ := url.Parse("http://transmission:9091/")
+	rr := &response.Responder{ErrorPage: errorPageFromEnv()}
+	down := upstreamFunc(func(*http.Request) (*http.Response, error) { return nil, errors.New("connection refused") })
+	web := response.WithHTMLErrors(transmissionproxy.Forward(transmissionproxy.ForwardConfig{
+		Upstream: u, Client: &http.Client{Transport: down}, Responder: rr,
+	}))
+
+	// the browser opening the web interface while Transmission is down gets the page
+	r := httptest.NewRequest(http.MethodGet, "/transmission/web/", nil)
+	r.Header.Set("Accept", "text/html,application/xhtml+xml,*/*;q=0.8")
+	w := httptest.NewRecorder()
+	web.ServeHTTP(w, r)
+
+	rec := logRecord(t, logs, "connection refused")
+	id, _ := rec["err"].(map[string]any)["id"].(string)
+	if w.Code != http.StatusBadGateway || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/html") ||
+		id == "" || !strings.Contains(w.Body.String(), "Error ID: "+id) {
+		t.Errorf("got status %d, headers %v, record %v, body:\n%s", w.Code, w.Header(), rec, w.Body)
+	}
+
+	// scripts of the page get JSON
+	r = httptest.NewRequest(http.MethodGet, "/transmission/web/", nil)
+	r.Header.Set("Accept", "application/json")
+	w = httptest.NewRecorder()
+	web.ServeHTTP(w, r)
+	if w.Code != http.StatusBadGateway || !strings.HasPrefix(w.Header().Get("Content-Type"), "application/json") {
+		t.Errorf("got status %d, headers %v", w.Code, w.Header())
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"transmission-proxy/internal/events"
+	"transmission-proxy/internal/reqctx"
+	"transmission-proxy/internal/response"
+	"transmission-proxy/internal/roles"
+)
+
+const (
+	// eventsBuffer is the number of events buffered per subscriber before the oldest are dropped.
+	eventsBuffer = 256
+	// eventsHeartbeat is the interval of comments sent to keep idle connections alive.
+	eventsHeartbeat = 15 * time.Second
+)
+
+// eventStream streams events of RPC requests as server-sent events. Users only get events of their own
+// requests, admins get all. Query parameters: methods (comma-separated) limits events to these methods,
+// rejections=1 includes rejected requests.
+func eventStream(rr *response.Responder, rl *roles.Roles, bus *events.Bus) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := reqctx.User(r.Context())
+		if user == "" {
+			rr.RespondAndLogCustom(w, r.Context(), fmt.Errorf("authentication required"), 0, slog.LevelWarn, http.StatusUnauthorized)
+			return
+		}
+
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			rr.RespondAndLogError(w, r.Context(), fmt.Errorf("streaming is not supported"), 0)
+			return
+		}
+
+		var methods []string
+		if m := r.URL.Query().Get("methods"); m != "" {
+			methods = strings.Split(m, ",")
+		}
+		rejections := r.URL.Query().Get("rejections") == "1"
+		admin := rl.IsAdmin(user)
+
+		sub := bus.Subscribe(eventsBuffer, func(e events.Event) bool {
+			return (admin || e.User == user) &&
+				(rejections || e.Type != events.TypeRejection) &&
+				(methods == nil || slices.Contains(methods, e.Method))
+		})
+		defer bus.Unsubscribe(sub)
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("X-Accel-Buffering", "no")
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+
+		heartbeat := time.NewTicker(eventsHeartbeat)
+		defer heartbeat.Stop()
+
+		for {
+			var err error
+			select {
+			case <-r.Context().Done():
+				return
+			case <-heartbeat.C:
+				_, err = fmt.Fprint(w, ": heartbeat\n\n")
+			case e, ok := <-sub.C:
+				if !ok {
+					return
+				}
+
+				if n := sub.Dropped(); n > 0 {
+					_, err = fmt.Fprintf(w, "event: dropped\ndata: {\"count\":%d}\n\n", n)
+				}
+				if err == nil {
+					bs, _ := json.Marshal(e)
+					_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type, bs)
+				}
+			}
+			if err != nil {
+				return
+			}
+
+			flusher.Flush()
+		}
+	}
+}
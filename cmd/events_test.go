@@ -0,0 +1,270 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"transmission-proxy/internal/events"
+	"transmission-proxy/internal/reqctx"
+	"transmission-proxy/internal/response"
+	"transmission-proxy/internal/roles"
+)
+
+// testEventStream serves the event stream of the bus to the user named in the query, reporting on the returned
+// channel when a stream ends.
+func testEventStream(t *testing.T, bus *events.Bus) (*httptest.Server, <-chan struct{}) {
+	ended := make(chan struct{}, 10)
+	h := eventStream(&response.Responder{}, roles.New([]string{"root"}), bus)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() { ended <- struct{}{} }()
+		h(w, r.WithContext(reqctx.WithUser(r.Context(), r.URL.Query().Get("user"))))
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv, ended
+}
+
+// sseEvent is a single server-sent event as framed on the wire.
+type sseEvent struct {
+	name, data string
+}
+
+// subscribe opens the stream and returns the channel of its events, closed when the stream ends.
+func subscribe(t *testing.T, srv *httptest.Server, query string) (<-chan sseEvent, context.CancelFunc) {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/proxy/events?"+query, nil)
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("got status %d, content type %q", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+
+	c := make(chan sseEvent, 100)
+	go func() {
+		defer close(c)
+		defer func() { _ = resp.Body.Close() }()
+
+		var e sseEvent
+		sc := bufio.NewScanner(resp.Body)
+		for sc.Scan() {
+			line := sc.Text()
+			switch {
+			case strings.HasPrefix(line, ":"):
+				// heartbeat
+			case line == "":
+				c <- e
+				e = sseEvent{}
+			case strings.HasPrefix(line, "event: "):
+				e.name = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: "):
+				e.data = strings.TrimPrefix(line, "data: ")
+			default:
+				c <- sseEvent{name: "unexpected", data: line}
+			}
+		}
+	}()
+
+	return c, cancel
+}
+
+func nextEvent(t *testing.T, c <-chan sseEvent) sseEvent {
+	t.Helper()
+
+	select {
+	case e, ok := <-c:
+		if !ok {
+			t.Fatal("stream ended")
+		}
+		return e
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event")
+	}
+
+	return sseEvent{}
+}
+
+func TestEventStream(t *testing.T) {
+	bus := events.NewBus()
+	srv, _ := testEventStream(t, bus)
+	h := testRPCProxy(upstreamStatus(http.StatusOK, `{"arguments":{},"result":"success"}`), func(cfg *rpcProxyConfig) {
+		cfg.events = bus
+	})
+
+	alice, _ := subscribe(t, srv, "user=alice")
+	removals, _ := subscribe(t, srv, "user=root&methods=torrent-remove,torrent-stop&rejections=1")
+
+	postAs(h, "bob", "", `{"method":"torrent-remove","arguments":{"ids":[1]},"tag":1}`)
+	postAs(h, "alice", "", `{"method":"torrent-add","arguments":{"filename":"magnet:?xt=urn:btih:abc"},"tag":2}`)
+	postAs(h, "alice", "", `{"method":"torrent-remove","arguments":{"ids":[2],"delete-local-data":"yes"},"tag":3}`)
+
+	e := nextEvent(t, alice)
+	if e.name != events.TypeRPC {
+		t.Errorf("got event %q, want rpc", e.name)
+	}
+	var got events.Event
+	if err := json.Unmarshal([]byte(e.data), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Type != events.TypeRPC || got.User != "alice" || got.Method != "torrent-add" || got.Tag != 2 || got.Status != http.StatusOK {
+		t.Errorf("got event %s", e.data)
+	}
+	// the rejection of alice's request is not streamed without rejections=1
+	select {
+	case e := <-alice:
+		t.Errorf("got event %v, want only events of the user", e)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	e = nextEvent(t, removals)
+	if e.name != events.TypeRPC || !strings.Contains(e.data, `"user":"bob","method":"torrent-remove","tag":1,"ids":[1]`) {
+		t.Errorf("got event %v, want the removal by bob", e)
+	}
+	e = nextEvent(t, removals)
+	if e.name != events.TypeRejection || !strings.Contains(e.data, `"user":"alice","method":"torrent-remove","tag":3`) ||
+		!strings.Contains(e.data, `"status":400,"reason":"`) {
+		t.Errorf("got event %v, want the rejected removal by alice", e)
+	}
+}
+
+func TestEventStreamUnauthenticated(t *testing.T) {
+	srv, _ := testEventStream(t, events.NewBus())
+	resp, err := srv.Client().Get(srv.URL + "/proxy/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("got status %d, want 401", resp.StatusCode)
+	}
+}
+
+func TestEventStreamEnds(t *testing.T) {
+	bus := events.NewBus()
+	srv, ended := testEventStream(t, bus)
+
+	waitEnded := func(what string) {
+		t.Helper()
+		select {
+		case <-ended:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("stream not ended on %s", what)
+		}
+	}
+
+	// the client disconnects
+	_, cancel := subscribe(t, srv, "user=alice")
+	cancel()
+	waitEnded("disconnect")
+
+	// the server shuts down
+	c, _ := subscribe(t, srv, "user=alice")
+	bus.Close()
+	waitEnded("shutdown")
+	select {
+	case e, ok := <-c:
+		if ok {
+			t.Errorf("got event %v, want end of stream", e)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("stream not ended on the client")
+	}
+}
+
+// gatedWriter is a streaming response writer blocking writes until the gate opens. It reports the first flush,
+// made once the subscription is set up, and the first write.
+type gatedWriter struct {
+	mu      sync.Mutex
+	rec     *httptest.ResponseRecorder
+	flushed chan struct{}
+	written chan struct{}
+	gate    chan struct{}
+	once    [2]sync.Once
+}
+
+func (w *gatedWriter) Header() http.Header {
+	return w.rec.Header()
+}
+
+func (w *gatedWriter) WriteHeader(status int) {
+	w.rec.WriteHeader(status)
+}
+
+func (w *gatedWriter) Write(bs []byte) (int, error) {
+	w.once[1].Do(func() { close(w.written) })
+	<-w.gate
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.rec.Write(bs)
+}
+
+func (w *gatedWriter) Flush() {
+	w.once[0].Do(func() { close(w.flushed) })
+}
+
+func (w *gatedWriter) body() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.rec.Body.String()
+}
+
+func TestEventStreamSlowConsumer(t *testing.T) {
+	bus := events.NewBus()
+	w := &gatedWriter{rec: httptest.NewRecorder(), flushed: make(chan struct{}), written: make(chan struct{}), gate: make(chan struct{})}
+	ctx, cancel := context.WithCancel(reqctx.WithUser(context.Background(), "root"))
+	r := httptest.NewRequest(http.MethodGet, "/proxy/events", nil).WithContext(ctx)
+
+	done := make(chan struct{})
+	go func() {
+		eventStream(&response.Responder{}, roles.New([]string{"root"}), bus)(w, r)
+		close(done)
+	}()
+
+	<-w.flushed
+	bus.Publish(events.Event{Type: events.TypeRPC, Method: "torrent-add", Tag: 1})
+	// the consumer is stuck writing the first event while the rest are published
+	<-w.written
+	const total = eventsBuffer + 44
+	for i := 2; i <= total; i++ {
+		bus.Publish(events.Event{Type: events.TypeRPC, Method: "torrent-add", Tag: i})
+	}
+	close(w.gate)
+
+	last := fmt.Sprintf(`"tag":%d}`, total)
+	for deadline := time.Now().Add(5 * time.Second); !strings.Contains(w.body(), last); {
+		if time.Now().After(deadline) {
+			t.Fatalf("last event not streamed:\n%s", w.body())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	// the first event was being written, the buffer holds the latest, those in between are dropped
+	frames := strings.Split(strings.TrimSuffix(w.body(), "\n\n"), "\n\n")
+	if len(frames) != eventsBuffer+2 {
+		t.Fatalf("got %d frames, want %d", len(frames), eventsBuffer+2)
+	}
+	if !strings.HasPrefix(frames[0], "event: rpc\ndata: {") || !strings.HasSuffix(frames[0], `"tag":1}`) {
+		t.Errorf("got first frame %q", frames[0])
+	}
+	if want := "event: dropped\ndata: {\"count\":43}"; frames[1] != want {
+		t.Errorf("got frame %q, want %q", frames[1], want)
+	}
+	if !strings.HasSuffix(frames[2], `"tag":45}`) {
+		t.Errorf("got frame %q after the drop, want the oldest buffered event", frames[2])
+	}
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+
+	"transmission-proxy/internal/fault"
+	"transmission-proxy/internal/jrpc"
+	"transmission-proxy/internal/logger"
+	"transmission-proxy/internal/response"
+	"transmission-proxy/internal/upstream"
+)
+
+// Defaults of the fault parameters.
+const (
+	defaultTruncateBytes = 16
+	defaultSlowChunk     = 64
+	defaultSlowDelay     = 500 * time.Millisecond
+)
+
+var faultInjection = os.Getenv("FAULT_INJECTION")
+
+// openFaultInjector creates the injector of FAULT_INJECTION rules, which is refused unless DEBUG_MODE is enabled.
+func openFaultInjector() *fault.Injector {
+	if !debugMode {
+		slog.Error("FAULT_INJECTION requires DEBUG_MODE to be enabled")
+		os.Exit(1)
+	}
+
+	rules, err := fault.Parse([]byte(faultInjection))
+	if err != nil {
+		slog.Error("failed to parse FAULT_INJECTION: "+err.Error(), logger.IgnoredAttr(err))
+		os.Exit(1)
+	}
+
+	seed := time.Now().UnixNano()
+	if s := os.Getenv("FAULT_INJECTION_SEED"); s != "" {
+		if seed, err = strconv.ParseInt(s, 10, 64); err != nil {
+			slog.Error("FAULT_INJECTION_SEED must be an integer")
+			os.Exit(1)
+		}
+	}
+
+	slog.Warn(fmt.Sprintf("fault injection is enabled with %d rules", len(rules)))
+	return fault.New(rules, seed)
+}
+
+// injectFaults injects the faults drawn for the request. Returns true if the request has been answered already.
+func injectFaults(fi *fault.Injector, w *response.Recorder, r *http.Request, req *jrpc.Request, rr *response.Responder) bool {
+	var fw *faultyWriter
+	for _, rule := range fi.Decide(req.Method) {
+		slog.InfoContext(r.Context(), "injecting fault "+rule.Fault,
+			logger.RPCMethod(req.Method),
+			logger.RPCTag(req.Tag))
+
+		switch rule.Fault {
+		case fault.Latency:
+			if !sleepContext(r.Context(), time.Duration(rule.Delay)) {
+				return true
+			}
+		case fault.SessionChurn:
+			w.Header().Set(upstream.SessionIDHeader, uuid.NewString())
+			rr.RespondAndLogCustom(w, r.Context(), fmt.Errorf("injected fault: session id changed"), req.Tag, slog.LevelInfo, http.StatusConflict)
+			return true
+		case fault.BadGateway:
+			rr.RespondAndLogCustom(w, r.Context(), fmt.Errorf("injected fault: bad gateway"), req.Tag, slog.LevelInfo, http.StatusBadGateway)
+			return true
+		case fault.Unavailable:
+			rr.RespondAndLogCustom(w, r.Context(), fmt.Errorf("injected fault: service unavailable"), req.Tag, slog.LevelInfo, http.StatusServiceUnavailable)
+			return true
+		case fault.Truncate, fault.Slow:
+			if fw == nil {
+				fw = &faultyWriter{ResponseWriter: w.ResponseWriter, ctx: r.Context(), limit: -1}
+				w.ResponseWriter = fw
+			}
+			if rule.Fault == fault.Truncate {
+				fw.limit = orDefault(rule.Bytes, defaultTruncateBytes)
+			} else {
+				fw.chunk = orDefault(rule.Bytes, defaultSlowChunk)
+				fw.delay = orDefault(time.Duration(rule.Delay), defaultSlowDelay)
+			}
+		}
+	}
+
+	return false
+}
+
+// orDefault returns the value, or the default if the value is zero.
+func orDefault[T comparable](v, def T) T {
+	var zero T
+	if v == zero {
+		return def
+	}
+
+	return v
+}
+
+// sleepContext waits for the duration, returning false if the context is done first.
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// faultyWriter cuts the response body after limit bytes (unless negative) and streams it slowly in chunks
+// (if chunk is set). Cut bytes are reported as written, so that the proxy goes on as if nothing happened.
+type faultyWriter struct {
+	http.ResponseWriter
+	ctx     context.Context
+	limit   int
+	chunk   int
+	delay   time.Duration
+	written int
+}
+
+func (f *faultyWriter) Write(bs []byte) (int, error) {
+	n := len(bs)
+	if f.limit >= 0 {
+		bs = bs[:min(len(bs), max(f.limit-f.written, 0))]
+	}
+
+	for len(bs) > 0 {
+		part := bs
+		if f.chunk > 0 {
+			part = bs[:min(len(bs), f.chunk)]
+			if !sleepContext(f.ctx, f.delay) {
+				return 0, f.ctx.Err()
+			}
+		}
+
+		written, err := f.ResponseWriter.Write(part)
+		f.written += written
+		if err != nil {
+			return written, err
+		}
+		f.Flush()
+
+		bs = bs[len(part):]
+	}
+
+	return n, nil
+}
+
+func (f *faultyWriter) Flush() {
+	if fl, ok := f.ResponseWriter.(http.Flusher); ok {
+		fl.Flush()
+	}
+}
+
+// faultRules lets admins change the fault injection rules at runtime.
+func faultRules(rr *response.Responder, fi *fault.Injector) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if fi == nil {
+			rr.RespondAndLogCustom(w, r.Context(), fmt.Errorf("fault injection is not configured"), 0, slog.LevelWarn, http.StatusNotFound)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			writeJSON(w, r, http.StatusOK, fi.Rules())
+		case http.MethodPut:
+			bs, err := io.ReadAll(r.Body)
+			var rules []fault.Rule
+			if err == nil {
+				rules, err = fault.Parse(bs)
+			}
+			if err != nil {
+				rr.RespondAndLogCustom(w, r.Context(), fmt.Errorf("failed to parse rules: %w", err), 0, slog.LevelWarn, http.StatusBadRequest)
+				return
+			}
+
+			fi.SetRules(rules)
+			slog.WarnContext(r.Context(), fmt.Sprintf("fault injection rules changed, %d rules", len(rules)))
+			writeJSON(w, r, http.StatusOK, fi.Rules())
+		default:
+			w.Header().Set("Allow", "GET, PUT")
+			rr.RespondAndLogCustom(w, r.Context(), fmt.Errorf("method not allowed"), 0, slog.LevelWarn, http.StatusMethodNotAllowed)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"transmission-proxy/internal/fault"
+	"transmission-proxy/internal/response"
+	"transmission-proxy/internal/upstream"
+)
+
+const faultUpstreamBody = `{"arguments":{"torrents":[{"id":1,"name":"debian.iso"}]},"result":"success"}`
+
+// testFaults returns the RPC handler injecting faults by the rules, with the requests it forwarded.
+func testFaults(rules ...fault.Rule) (http.Handler, *[]string) {
+	var forwarded []string
+	return testRPCProxy(recordingUpstream(faultUpstreamBody, &forwarded), func(cfg *rpcProxyConfig) {
+		cfg.faults = fault.New(rules, 1)
+	}), &forwarded
+}
+
+const faultRequest = `{"method":"torrent-get","arguments":{"fields":["id","name"]}}`
+
+func TestInjectFaults(t *testing.T) {
+	cases := []struct {
+		name      string
+		rule      fault.Rule
+		status    int
+		body      string
+		forwarded bool
+	}{
+		{name: "bad gateway", rule: fault.Rule{Fault: fault.BadGateway, Probability: 1}, status: http.StatusBadGateway},
+		{name: "unavailable", rule: fault.Rule{Fault: fault.Unavailable, Probability: 1}, status: http.StatusServiceUnavailable},
+		{name: "session churn", rule: fault.Rule{Fault: fault.SessionChurn, Probability: 1}, status: http.StatusConflict},
+		{name: "truncate", rule: fault.Rule{Fault: fault.Truncate, Probability: 1, Bytes: 20},
+			status: http.StatusOK, body: faultUpstreamBody[:20], forwarded: true},
+		{name: "truncate by default", rule: fault.Rule{Fault: fault.Truncate, Probability: 1},
+			status: http.StatusOK, body: faultUpstreamBody[:defaultTruncateBytes], forwarded: true},
+		{name: "slow", rule: fault.Rule{Fault: fault.Slow, Probability: 1, Bytes: 8, Delay: fault.Duration(time.Millisecond)},
+			status: http.StatusOK, body: faultUpstreamBody, forwarded: true},
+		{name: "other method", rule: fault.Rule{Fault: fault.BadGateway, Probability: 1, Methods: []string{"torrent-add"}},
+			status: http.StatusOK, body: faultUpstreamBody, forwarded: true},
+		{name: "never", rule: fault.Rule{Fault: fault.BadGateway, Probability: 0},
+			status: http.StatusOK, body: faultUpstreamBody, forwarded: true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			h, forwarded := testFaults(tc.rule)
+			w := postRPC(h, faultRequest)
+			if w.Code != tc.status || tc.body != "" && w.Body.String() != tc.body {
+				t.Errorf("got status %d, body %s", w.Code, w.Body)
+			}
+			if got := len(*forwarded) == 1; got != tc.forwarded {
+				t.Errorf("got forwarded %v, want %v", *forwarded, tc.forwarded)
+			}
+		})
+	}
+}
+
+func TestInjectSessionChurn(t *testing.T) {
+	h, _ := testFaults(fault.Rule{Fault: fault.SessionChurn, Probability: 1})
+
+	first, second := postRPC(h, faultRequest), postRPC(h, faultRequest)
+	id := first.Header().Get(upstream.SessionIDHeader)
+	if id == "" || id == second.Header().Get(upstream.SessionIDHeader) {
+		t.Errorf("got session ids %q and %q, want a new one every time", id, second.Header().Get(upstream.SessionIDHeader))
+	}
+}
+
+func TestInjectLatency(t *testing.T) {
+	logs := captureLog(t)
+	h, forwarded := testFaults(fault.Rule{Fault: fault.Latency, Probability: 1, Delay: fault.Duration(50 * time.Millisecond)},
+		fault.Rule{Fault: fault.Slow, Probability: 1, Bytes: 32, Delay: fault.Duration(10 * time.Millisecond)})
+
+	start := time.Now()
+	w := postRPC(h, faultRequest)
+	// the latency and a delay for every of the three chunks of the body
+	if d := time.Since(start); d < 80*time.Millisecond {
+		t.Errorf("answered in %s, want at least 80ms", d)
+	}
+	if w.Code != http.StatusOK || w.Body.String() != faultUpstreamBody || len(*forwarded) != 1 {
+		t.Errorf("got status %d, body %s", w.Code, w.Body)
+	}
+
+	for _, msg := range []string{"injecting fault latency", "injecting fault slow"} {
+		if rec := logRecord(t, logs, msg); rec["rpc"].(map[string]any)["method"] != "torrent-get" {
+			t.Errorf("got record %v", rec)
+		}
+	}
+
+	// the request is given up when the client goes away during the latency
+	r := httptest.NewRequest(http.MethodPost, rpcPath, strings.NewReader(faultRequest))
+	ctx, cancel := context.WithCancel(r.Context())
+	cancel()
+	h.ServeHTTP(httptest.NewRecorder(), r.WithContext(ctx))
+	if len(*forwarded) != 1 {
+		t.Errorf("got %d requests forwarded, want the cancelled one not forwarded", len(*forwarded))
+	}
+}
+
+func TestFaultRules(t *testing.T) {
+	fi := fault.New([]fault.Rule{{Fault: fault.Latency, Probability: 0.5, Delay: fault.Duration(time.Second)}}, 1)
+	h := faultRules(&response.Responder{DebugMode: true}, fi)
+
+	do := func(method, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h(w, httptest.NewRequest(method, "/proxy/faults", strings.NewReader(body)))
+		return w
+	}
+
+	if w := do(http.MethodGet, ""); w.Code != http.StatusOK || strings.TrimSpace(w.Body.String()) != `[{"fault":"latency","probability":0.5,"delay":"1s"}]` {
+		t.Errorf("GET: got status %d, body %s", w.Code, w.Body)
+	}
+
+	// test scripts switch the scenarios
+	w := do(http.MethodPut, `[{"fault":"unavailable","probability":1,"methods":["torrent-add"]}]`)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"unavailable"`) {
+		t.Errorf("PUT: got status %d, body %s", w.Code, w.Body)
+	}
+	if got := fi.Decide("torrent-add"); len(got) != 1 || got[0].Fault != fault.Unavailable {
+		t.Errorf("got decision %v after the rules changed", got)
+	}
+
+	if w := do(http.MethodPut, `[{"fault":"explode","probability":1}]`); w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "unknown fault") {
+		t.Errorf("PUT invalid: got status %d, body %s", w.Code, w.Body)
+	}
+	if len(fi.Rules()) != 1 || fi.Rules()[0].Fault != fault.Unavailable {
+		t.Errorf("invalid rules applied: %v", fi.Rules())
+	}
+	if w := do(http.MethodDelete, ""); w.Code != http.StatusMethodNotAllowed || w.Header().Get("Allow") != "GET, PUT" {
+		t.Errorf("DELETE: got status %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	faultRules(&response.Responder{}, nil)(w, httptest.NewRequest(http.MethodGet, "/proxy/faults", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("not configured: got status %d", w.Code)
+	}
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"strings"
+
+	"transmission-proxy/internal/transmission"
+)
+
+// filenameValidator returns the validator of torrent-add filename configured by FILENAME_FORBID_LOCAL,
+// FILENAME_TRACKER_ALLOWLIST, FILENAME_TRACKER_DENYLIST and FILENAME_URL_HOSTS, or nil if none is set.
+func filenameValidator() *transmission.FilenameValidator {
+	fv := &transmission.FilenameValidator{
+		ForbidLocal:  getBoolEnv("FILENAME_FORBID_LOCAL"),
+		TrackerAllow: lowerAll(getListEnv("FILENAME_TRACKER_ALLOWLIST", "")),
+		TrackerDeny:  lowerAll(getListEnv("FILENAME_TRACKER_DENYLIST", "")),
+		URLHosts:     lowerAll(getListEnv("FILENAME_URL_HOSTS", "")),
+	}
+	if !fv.ForbidLocal && fv.TrackerAllow == nil && fv.TrackerDeny == nil && fv.URLHosts == nil {
+		return nil
+	}
+
+	return fv
+}
+
+func lowerAll(list []string) []string {
+	for i, s := range list {
+		list[i] = strings.ToLower(s)
+	}
+
+	return list
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"transmission-proxy/internal/transmission"
+)
+
+func TestFilenameValidatorEnv(t *testing.T) {
+	// existing deployments stay permissive
+	if fv := filenameValidator(); fv != nil {
+		t.Errorf("got validator %+v without configuration", fv)
+	}
+
+	t.Setenv("FILENAME_TRACKER_ALLOWLIST", "Tracker.example, open.example")
+	t.Setenv("FILENAME_URL_HOSTS", "TORRENTS.example")
+	want := &transmission.FilenameValidator{
+		TrackerAllow: []string{"tracker.example", "open.example"},
+		URLHosts:     []string{"torrents.example"},
+	}
+	if fv := filenameValidator(); !reflect.DeepEqual(fv, want) {
+		t.Errorf("got validator %+v, want %+v", fv, want)
+	}
+}
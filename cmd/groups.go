@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"transmission-proxy/internal/jrpc"
+	"transmission-proxy/internal/logger"
+	"transmission-proxy/internal/roles"
+	"transmission-proxy/internal/upstream"
+)
+
+// provisionTimeout limits the time spent creating bandwidth groups at startup.
+const provisionTimeout = 30 * time.Second
+
+// provisionGroups creates or updates upstream the bandwidth groups declared in the users config. Failures
+// are logged, torrents then keep the groups Transmission already has.
+func provisionGroups(uc *upstream.Client, rl *roles.Roles) {
+	ctx, cancel := context.WithTimeout(context.Background(), provisionTimeout)
+	defer cancel()
+
+	for name, g := range rl.BandwidthGroups {
+		args := map[string]any{
+			"name":                     name,
+			"speed-limit-down-enabled": g.SpeedLimitDown != nil,
+			"speed-limit-up-enabled":   g.SpeedLimitUp != nil,
+		}
+		if g.SpeedLimitDown != nil {
+			args["speed-limit-down"] = *g.SpeedLimitDown
+		}
+		if g.SpeedLimitUp != nil {
+			args["speed-limit-up"] = *g.SpeedLimitUp
+		}
+		if g.HonorsSessionLimits != nil {
+			args["honorsSessionLimits"] = *g.HonorsSessionLimits
+		}
+
+		if _, err := uc.Call(ctx, nil, &jrpc.Request{Method: "group-set", Arguments: args}); err != nil {
+			slog.Error(fmt.Sprintf("failed to provision bandwidth group %q: %s", name, err.Error()), logger.IgnoredAttr(err))
+			continue
+		}
+
+		slog.Info(fmt.Sprintf("provisioned bandwidth group %q", name))
+	}
+}
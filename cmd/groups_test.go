@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"transmission-proxy/internal/roles"
+	"transmission-proxy/internal/upstream"
+)
+
+func TestProvisionGroups(t *testing.T) {
+	logs := captureLog(t)
+
+	path := filepath.Join(t.TempDir(), "users.yaml")
+	users := `
+users:
+  alice:
+    bandwidth_group: alice
+bandwidth_groups:
+  alice:
+    speed_limit_down: 1024
+  shared:
+    speed_limit_up: 0
+    honors_session_limits: false
+  unlimited:
+`
+	if err := os.WriteFile(path, []byte(users), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	rl := roles.New(nil)
+	if err := rl.LoadUsers(path); err != nil {
+		t.Fatal(err)
+	}
+
+	var requests []string
+	uc := &upstream.Client{URL: "http://transmission:9091/transmission/rpc",
+		HTTP: &http.Client{Transport: recordingUpstream(`{"arguments":{},"result":"success"}`, &requests)}}
+	provisionGroups(uc, rl)
+
+	var got []string
+	for _, body := range requests {
+		var req struct {
+			Method    string         `json:"method"`
+			Arguments map[string]any `json:"arguments"`
+		}
+		if err := json.Unmarshal([]byte(body), &req); err != nil {
+			t.Fatal(err)
+		}
+		bs, _ := json.Marshal(req.Arguments)
+		got = append(got, req.Method+" "+string(bs))
+	}
+	slices.Sort(got)
+
+	want := []string{
+		`group-set {"honorsSessionLimits":false,"name":"shared","speed-limit-down-enabled":false,"speed-limit-up":0,"speed-limit-up-enabled":true}`,
+		`group-set {"name":"alice","speed-limit-down":1024,"speed-limit-down-enabled":true,"speed-limit-up-enabled":false}`,
+		`group-set {"name":"unlimited","speed-limit-down-enabled":false,"speed-limit-up-enabled":false}`,
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got requests\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+	if n := strings.Count(logs.String(), "provisioned bandwidth group"); n != 3 {
+		t.Errorf("got %d groups logged as provisioned, want 3:\n%s", n, logs)
+	}
+}
+
+func TestProvisionGroupsFailure(t *testing.T) {
+	logs := captureLog(t)
+	rl := roles.New(nil)
+	rl.BandwidthGroups["alice"] = &roles.BandwidthGroup{}
+
+	uc := &upstream.Client{URL: "http://transmission:9091/transmission/rpc",
+		HTTP: &http.Client{Transport: upstreamFunc(func(*http.Request) (*http.Response, error) {
+			return nil, errors.New("connection refused")
+		})}}
+	provisionGroups(uc, rl)
+
+	rec := logRecord(t, logs, `failed to provision bandwidth group "alice"`)
+	if rec["level"] != "ERROR" {
+		t.Errorf("got record %v", rec)
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"transmission-proxy/internal/events"
+	"transmission-proxy/internal/jrpc"
+	"transmission-proxy/internal/policy"
+	"transmission-proxy/internal/response"
+	"transmission-proxy/internal/stats"
+	"transmission-proxy/internal/transmissiontest"
+	"transmission-proxy/transmissionproxy"
+)
+
+const gzipTestResponse = `{"arguments":{"torrents":[{"id":1,"name":"debian"}]},"result":"success"}`
+
+// gzipUpstream answers RPC requests with gzipTestResponse, compressed if compress is set and the request accepts gzip.
+func gzipUpstream(t *testing.T, compress bool) *url.URL {
+	up := &transmissiontest.Server{RPC: func(w http.ResponseWriter, r *http.Request, _ *jrpc.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if !compress || !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+			_, _ = w.Write([]byte(gzipTestResponse))
+			return
+		}
+
+		w.Header().Set("Content-Encoding", "gzip")
+		gz := gzip.NewWriter(w)
+		_, _ = gz.Write([]byte(gzipTestResponse))
+		_ = gz.Close()
+	}}
+
+	return up.Start(t)
+}
+
+// renaming rewrites names of the torrents in responses, so that the proxy has to read them.
+var renaming = policyFunc(func(_ context.Context, req *jrpc.Request) (*jrpc.Request, policy.ResponseRewriter, error) {
+	return req, func(resp *jrpc.Response) (bool, error) {
+		for _, tr := range resp.Arguments["torrents"].([]any) {
+			tr.(map[string]any)["name"] = "renamed"
+		}
+		return true, nil
+	}, nil
+})
+
+// gzipProxy returns the RPC proxy forwarding to the upstream over the network, so that the transport handles
+// compression as in production, applying the renaming policy if inspect is set.
+func gzipProxy(u *url.URL, inspect bool) http.Handler {
+	cfg := rpcProxyConfig{
+		validator: buildValidator("/downloads/"),
+		events:    events.NewBus(),
+		drain:     &drainMode{},
+		responder: &response.Responder{},
+		stats:     stats.NewRegistry(),
+	}
+	if inspect {
+		cfg.policies = []policy.Policy{renaming}
+	}
+
+	return rpcProxy(transmissionproxy.Forward(transmissionproxy.ForwardConfig{Upstream: u}), cfg)
+}
+
+// readBody returns the body of the response, decompressed if it is gzip-encoded.
+func readBody(t *testing.T, w *httptest.ResponseRecorder) string {
+	t.Helper()
+
+	if w.Header().Get("Content-Encoding") != "gzip" {
+		return w.Body.String()
+	}
+
+	gz, err := gzip.NewReader(bytes.NewReader(w.Body.Bytes()))
+	if err != nil {
+		t.Fatalf("body is not gzip: %v", err)
+	}
+	bs, err := io.ReadAll(gz)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(bs)
+}
+
+func TestGzipRPC(t *testing.T) {
+	defer func(c bool) { compressRPC = c }(compressRPC)
+	captureLog(t)
+
+	renamed := strings.Replace(gzipTestResponse, "debian", "renamed", 1)
+	cases := []struct {
+		name                string
+		upstreamGzip        bool
+		clientGzip, inspect bool
+		compressRPC         bool
+		// wantGzip tells whether the response to the client is compressed
+		wantGzip bool
+		want     string
+	}{
+		{name: "upstream gzip, client plain, inspected", upstreamGzip: true, inspect: true, want: renamed},
+		{name: "upstream gzip, client gzip, inspected", upstreamGzip: true, clientGzip: true, inspect: true, wantGzip: true, want: renamed},
+		{name: "upstream plain, client gzip, inspected", clientGzip: true, inspect: true, wantGzip: true, want: renamed},
+		{name: "upstream plain, client gzip, compressed by proxy", clientGzip: true, compressRPC: true, wantGzip: true, want: gzipTestResponse},
+		{name: "upstream plain, client plain, compressed by proxy", compressRPC: true, want: gzipTestResponse},
+		{name: "upstream plain, client gzip", clientGzip: true, want: gzipTestResponse},
+		// not inspected responses are passed through as the upstream sent them, never compressed twice
+		{name: "pass-through", upstreamGzip: true, clientGzip: true, wantGzip: true, want: gzipTestResponse},
+		{name: "pass-through, compressed by proxy", upstreamGzip: true, clientGzip: true, compressRPC: true, wantGzip: true, want: gzipTestResponse},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			compressRPC = tc.compressRPC
+			u := gzipUpstream(t, tc.upstreamGzip)
+			h := gzipProxy(u, tc.inspect)
+
+			r := httptest.NewRequest(http.MethodPost, rpcPath, strings.NewReader(`{"method":"torrent-get","arguments":{"fields":["id","name"]}}`))
+			if tc.clientGzip {
+				r.Header.Set("Accept-Encoding", "gzip")
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("got status %d, body %s", w.Code, w.Body)
+			}
+			if gzipped := w.Header().Get("Content-Encoding") == "gzip"; gzipped != tc.wantGzip {
+				t.Errorf("got Content-Encoding %q, want gzip %v", w.Header().Get("Content-Encoding"), tc.wantGzip)
+			}
+			if cl := w.Header().Get("Content-Length"); cl != "" && cl != strconv.Itoa(w.Body.Len()) {
+				t.Errorf("got Content-Length %s for body of %d bytes", cl, w.Body.Len())
+			}
+			// the inspected responses are encoded again, with the members in another order
+			var got, want any
+			body := readBody(t, w)
+			if err := json.Unmarshal([]byte(body), &got); err != nil {
+				t.Fatalf("got body %s: %v", body, err)
+			}
+			_ = json.Unmarshal([]byte(tc.want), &want)
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("got body %s, want %s", body, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"transmission-proxy/internal/jrpc"
+	"transmission-proxy/internal/logger"
+	"transmission-proxy/internal/response"
+	"transmission-proxy/internal/upstream"
+)
+
+var (
+	healthPath = getEnvOrDefault("HEALTH_PATH", "/healthz")
+	readyPath  = getEnvOrDefault("READY_PATH", "/readyz")
+	// readyCheckTTL is how long the result of the upstream check is reused, so that probes do not load Transmission.
+	readyCheckTTL = getDurationEnv("READY_CHECK_TTL", 5*time.Second)
+)
+
+// readyCheckTimeout bounds the upstream check, probes usually give up after a second or a few.
+const readyCheckTimeout = 3 * time.Second
+
+// isProbe reports whether the request is of health or readiness probe, which are not worth logging at info level.
+func isProbe(r *http.Request) bool {
+	return r.URL.Path == basePath+healthPath || r.URL.Path == basePath+readyPath
+}
+
+// healthz reports that the proxy is serving.
+func healthz() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		_, _ = io.WriteString(w, "ok\n")
+	}
+}
+
+// upstreamCheck checks that Transmission answers RPC requests, caching the result for readyCheckTTL.
+type upstreamCheck struct {
+	uc *upstream.Client
+	// pool, if set, is reported along with the check
+	pool *upstream.Pool
+
+	mu      sync.Mutex
+	checked time.Time
+	version any
+	rtt     time.Duration
+	err     error
+}
+
+func (c *upstreamCheck) check(ctx context.Context) (version any, rtt time.Duration, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if time.Since(c.checked) < readyCheckTTL {
+		return c.version, c.rtt, c.err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, readyCheckTimeout)
+	defer cancel()
+
+	start := time.Now()
+	resp, err := c.uc.Call(ctx, nil, &jrpc.Request{Method: "session-get", Arguments: map[string]any{"fields": []string{"version"}}})
+	c.checked, c.rtt, c.err = time.Now(), time.Since(start), err
+	c.version = nil
+	if err == nil {
+		c.version = resp.Arguments["version"]
+	}
+
+	return c.version, c.rtt, c.err
+}
+
+// readyz reports whether the proxy takes traffic: it does not while drained or while Transmission does not answer.
+func readyz(rr *response.Responder, d *drainMode, uc *upstreamCheck) http.HandlerFunc {
+	throttle := logger.NewThrottle(upstreamErrorLogWindow)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !d.ready() {
+			writeJSON(w, r, http.StatusServiceUnavailable, map[string]any{"status": "draining"})
+			return
+		}
+
+		version, rtt, err := uc.check(r.Context())
+		if err != nil {
+			class := upstream.Classify(err)
+			lvl := throttle.Level(r.Context(), "readiness check "+class+" errors", slog.LevelWarn)
+			err = logger.WithAttributes(err, logger.ErrClass(class))
+			rr.RespondAndLogCustom(w, r.Context(), fmt.Errorf("upstream is not ready: %w", err), 0, lvl, http.StatusServiceUnavailable)
+			return
+		}
+
+		data := map[string]any{
+			"status": "ready",
+			"upstream": map[string]any{
+				"version": version,
+				"rtt_ms":  float64(rtt) / float64(time.Millisecond),
+			},
+		}
+		if uc.pool != nil {
+			data["upstreams"] = uc.pool.Status()
+		}
+		writeJSON(w, r, http.StatusOK, data)
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"transmission-proxy/internal/apikeys"
+	"transmission-proxy/internal/jrpc"
+	"transmission-proxy/internal/logger"
+	"transmission-proxy/internal/reqctx"
+	"transmission-proxy/internal/response"
+	"transmission-proxy/internal/roles"
+	"transmission-proxy/internal/transmission"
+)
+
+const impersonateHeader = "X-Proxy-Impersonate"
+
+// impersonate lets admins make requests evaluated under policies of the user named in X-Proxy-Impersonate
+// header. The header is rejected for other users and for users which do not exist. Impersonating an API key
+// name applies the restrictions of that key.
+func impersonate(rr *response.Responder, rl *roles.Roles, keys *apikeys.Store, exists func(user string) bool, next http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		target := r.Header.Get(impersonateHeader)
+		if target == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		user := reqctx.User(r.Context())
+		if !rl.IsAdmin(user) {
+			rr.RespondAndLogCustom(w, r.Context(), fmt.Errorf("only admins may impersonate users"), 0, slog.LevelWarn, http.StatusForbidden)
+			return
+		}
+
+		if !exists(target) {
+			err := logger.WithAttributes(fmt.Errorf("cannot impersonate unknown user"), logger.HTTPImpersonatedUser(target))
+			rr.RespondAndLogCustom(w, r.Context(), err, 0, slog.LevelWarn, http.StatusBadRequest)
+			return
+		}
+
+		ctx := reqctx.WithImpersonation(r.Context(), target)
+		ctx = logger.ContextWithAttrs(ctx, logger.HTTPImpersonatedUser(target))
+		if keys != nil {
+			ctx = apikeys.WithKey(ctx, keys.ByName(target))
+		}
+
+		r = r.Clone(ctx)
+		r.Header.Del(impersonateHeader)
+
+		next.ServeHTTP(w, r)
+	}
+}
+
+// adminValidator validates requests of admins authenticated as users (not with API keys) with the validator
+// not restricting download locations, other requests with the default validator.
+type adminValidator struct {
+	def   transmission.RequestValidator
+	admin transmission.RequestValidator
+	roles *roles.Roles
+}
+
+func (v *adminValidator) Validate(req *jrpc.Request) (*jrpc.Request, error) {
+	if ctx := req.Ctx(); apikeys.FromContext(ctx) == nil && v.roles.IsAdmin(reqctx.User(ctx)) {
+		return v.admin.Validate(req)
+	}
+
+	return v.def.Validate(req)
+}
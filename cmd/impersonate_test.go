@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"transmission-proxy/internal/audit"
+	"transmission-proxy/internal/logger"
+	"transmission-proxy/internal/reqctx"
+	"transmission-proxy/internal/response"
+	"transmission-proxy/internal/roles"
+)
+
+// testImpersonation returns the RPC handler for root, an admin, and alice, a user restricted to /downloads/alice/,
+// along with the path of its audit log.
+func testImpersonation(t *testing.T) (http.Handler, string) {
+	rl := roles.New([]string{"root"})
+	auditPath := filepath.Join(t.TempDir(), "audit.log")
+	al, err := audit.Open(auditPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = al.Close() })
+
+	v := &adminValidator{def: buildValidator("/downloads/alice/"), admin: buildValidator("/"), roles: rl}
+	proxy := testRPCProxy(upstreamStatus(http.StatusOK, `{"arguments":{},"result":"success"}`), func(cfg *rpcProxyConfig) {
+		cfg.validator = v
+		cfg.audit = al
+		cfg.responder = &response.Responder{DebugMode: true}
+	})
+	exists := func(user string) bool { return user == "alice" || user == "root" }
+
+	return impersonate(&response.Responder{}, rl, nil, exists, proxy), auditPath
+}
+
+func postAs(h http.Handler, user, impersonated, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, rpcPath, strings.NewReader(body))
+	ctx := reqctx.WithUser(r.Context(), user)
+	ctx = logger.ContextWithAttrs(ctx, logger.HTTPUser(user))
+	r = r.WithContext(ctx)
+	if impersonated != "" {
+		r.Header.Set(impersonateHeader, impersonated)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+
+	return w
+}
+
+const addOutsideAlice = `{"method":"torrent-add","arguments":{"filename":"magnet:?xt=urn:btih:abc","download-dir":"/downloads/bob"}}`
+
+func TestImpersonate(t *testing.T) {
+	logs := captureLog(t)
+	h, auditPath := testImpersonation(t)
+
+	if w := postAs(h, "alice", "root", `{"method":"session-get"}`); w.Code != http.StatusForbidden {
+		t.Errorf("user impersonating: got status %d, want 403", w.Code)
+	}
+	if w := postAs(h, "root", "mallory", `{"method":"session-get"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("impersonating unknown user: got status %d, want 400", w.Code)
+	}
+
+	// the admin is not restricted, the impersonated user is
+	if w := postAs(h, "root", "", addOutsideAlice); w.Code != http.StatusOK {
+		t.Errorf("admin: got status %d, body %s", w.Code, w.Body)
+	}
+	if w := postAs(h, "root", "alice", addOutsideAlice); w.Code == http.StatusOK || !strings.Contains(w.Body.String(), "forbidden location") {
+		t.Errorf("admin as alice: got status %d, body %s", w.Code, w.Body)
+	}
+	if w := postAs(h, "root", "alice", strings.ReplaceAll(addOutsideAlice, "/downloads/bob", "/downloads/alice/x")); w.Code != http.StatusOK {
+		t.Errorf("admin as alice in her directory: got status %d, body %s", w.Code, w.Body)
+	}
+
+	bs, err := os.ReadFile(auditPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var records []audit.Record
+	for _, line := range strings.Split(strings.TrimSpace(string(bs)), "\n") {
+		var rec audit.Record
+		if err := json.Unmarshal([]byte(line), &rec); err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, rec)
+	}
+	if len(records) != 3 {
+		t.Fatalf("got audit log %s, want 3 records", bs)
+	}
+	if rec := records[0]; rec.User != "root" || rec.Impersonator != "" {
+		t.Errorf("admin record: got user %q, impersonator %q", rec.User, rec.Impersonator)
+	}
+	for _, rec := range records[1:] {
+		if rec.User != "alice" || rec.Impersonator != "root" {
+			t.Errorf("impersonated record: got user %q, impersonator %q, want both identities", rec.User, rec.Impersonator)
+		}
+	}
+
+	rec := logRecord(t, logs, "forbidden location")
+	if attrs := rec["http"].(map[string]any); attrs["user"] != "root" || attrs["impersonated_user"] != "alice" {
+		t.Errorf("got log record %v, want both identities", rec)
+	}
+}
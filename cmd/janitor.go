@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+
+	"transmission-proxy/internal/audit"
+	"transmission-proxy/internal/janitor"
+	"transmission-proxy/internal/logger"
+	"transmission-proxy/internal/upstream"
+	"transmission-proxy/internal/webhook"
+)
+
+var (
+	janitorConfig     = os.Getenv("JANITOR_CONFIG")
+	janitorWebhookURL = os.Getenv("JANITOR_WEBHOOK_URL")
+)
+
+// startJanitor starts stopping or removing torrents according to the rules of JANITOR_CONFIG.
+func startJanitor(uc *upstream.Client, al *audit.Log) {
+	cfg, err := janitor.Load(janitorConfig)
+	if err != nil {
+		slog.Error("failed to load JANITOR_CONFIG: "+err.Error(), logger.IgnoredAttr(err))
+		os.Exit(1)
+	}
+
+	j := &janitor.Janitor{Config: cfg, Upstream: uc, Audit: al}
+	if janitorWebhookURL != "" {
+		j.Notifier = &webhook.Notifier{
+			URL:     janitorWebhookURL,
+			Timeout: getDurationEnv("WEBHOOK_TIMEOUT", 5*time.Second),
+			HTTP:    &http.Client{},
+		}
+	}
+
+	slog.Info(fmt.Sprintf("loaded %d janitor rules from JANITOR_CONFIG", len(cfg.Rules)))
+	go j.Run(context.Background())
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+
+	"transmission-proxy/internal/authlog"
+	"transmission-proxy/internal/lockout"
+	"transmission-proxy/internal/logger"
+	"transmission-proxy/internal/reqctx"
+	"transmission-proxy/internal/response"
+	"transmission-proxy/internal/stats"
+)
+
+// authGuard protects authentication mechanisms from brute force: clients (client address and user name pairs)
+// failing authentication repeatedly are locked out for a while. Locked out clients get the same response
+// regardless of whether the user exists.
+type authGuard struct {
+	rr *response.Responder
+	// tracker is nil if lockouts are disabled.
+	tracker *lockout.Tracker
+	st      *stats.Registry
+	// failLog is nil unless AUTH_FAIL_LOG is set.
+	failLog *authlog.Writer
+}
+
+var authFailLog = os.Getenv("AUTH_FAIL_LOG")
+
+func newAuthGuard(rr *response.Responder, st *stats.Registry) *authGuard {
+	g := &authGuard{rr: rr, st: st}
+
+	maxFailures, err := strconv.Atoi(getEnvOrDefault("AUTH_MAX_FAILURES", "5"))
+	if err != nil || maxFailures < 0 {
+		slog.Error("AUTH_MAX_FAILURES must be a non-negative integer")
+		os.Exit(1)
+	}
+
+	if maxFailures > 0 {
+		g.tracker = &lockout.Tracker{
+			MaxFailures: maxFailures,
+			Window:      getDurationEnv("AUTH_FAILURE_WINDOW", 10*time.Minute),
+			Duration:    getDurationEnv("AUTH_LOCKOUT_DURATION", 15*time.Minute),
+		}
+	}
+
+	if authFailLog != "" {
+		g.failLog, err = authlog.Open(authFailLog)
+		if err != nil {
+			slog.Error("failed to open AUTH_FAIL_LOG: "+err.Error(), logger.IgnoredAttr(err))
+			os.Exit(1)
+		}
+
+		reopenOnSignal(g.failLog)
+	}
+
+	return g
+}
+
+// reopenOnSignal reopens the authentication failure log on SIGHUP, so that it can be rotated.
+func reopenOnSignal(w *authlog.Writer) {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGHUP)
+
+	go func() {
+		for range ch {
+			if err := w.Reopen(); err != nil {
+				slog.Error("failed to reopen AUTH_FAIL_LOG: "+err.Error(), logger.IgnoredAttr(err))
+			}
+		}
+	}()
+}
+
+// record writes authentication failure to AUTH_FAIL_LOG.
+func (g *authGuard) record(r *http.Request, reason, user string) {
+	if g.failLog == nil {
+		return
+	}
+
+	key := lockoutKey(r, user)
+	if err := g.failLog.Log(time.Now(), key.ClientIP, reason, user, r.URL.Path); err != nil {
+		slog.ErrorContext(r.Context(), "failed to write AUTH_FAIL_LOG: "+err.Error(), logger.IgnoredAttr(err))
+	}
+}
+
+func lockoutKey(r *http.Request, user string) lockout.Key {
+	key := lockout.Key{User: user}
+	if ip := reqctx.ClientIP(r.Context()); ip.IsValid() {
+		key.ClientIP = ip.String()
+	}
+
+	return key
+}
+
+// rejectLocked responds with 429 if the client is locked out.
+func (g *authGuard) rejectLocked(w http.ResponseWriter, r *http.Request, user string) bool {
+	if g.tracker == nil {
+		return false
+	}
+
+	locked, retryAfter := g.tracker.Locked(lockoutKey(r, user))
+	if !locked {
+		return false
+	}
+
+	g.record(r, authlog.ReasonLockedOut, user)
+
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	err := logger.WithAttributes(fmt.Errorf("too many authentication failures, try again later"), logger.HTTPUser(user))
+	g.rr.RespondAndLogCustom(w, r.Context(), err, 0, slog.LevelWarn, http.StatusTooManyRequests)
+	return true
+}
+
+func (g *authGuard) fail(r *http.Request, user, reason string) {
+	g.record(r, reason, user)
+
+	if g.tracker == nil {
+		return
+	}
+
+	if g.tracker.Fail(lockoutKey(r, user)) {
+		g.st.RecordAuthLockout()
+		slog.WarnContext(r.Context(), fmt.Sprintf("locking out client for %s after %d authentication failures",
+			g.tracker.Duration, g.tracker.MaxFailures), logger.HTTPUser(user))
+	}
+}
+
+func (g *authGuard) succeed(r *http.Request, user string) {
+	if g.tracker != nil {
+		g.tracker.Succeed(lockoutKey(r, user))
+	}
+}
+
+// lockouts lists current lockouts on GET and clears lockout of the client given by client_ip and user
+// query parameters on DELETE.
+func lockouts(g *authGuard) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if g.tracker == nil {
+			g.rr.RespondAndLogCustom(w, r.Context(), fmt.Errorf("authentication lockouts are disabled"), 0, slog.LevelWarn, http.StatusNotFound)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			items := g.tracker.Lockouts()
+			if items == nil {
+				items = []lockout.Lockout{}
+			}
+			writeJSON(w, r, http.StatusOK, items)
+		case http.MethodDelete:
+			key := lockout.Key{ClientIP: r.URL.Query().Get("client_ip"), User: r.URL.Query().Get("user")}
+			if !g.tracker.Clear(key) {
+				g.rr.RespondAndLogCustom(w, r.Context(), fmt.Errorf("no lockout for the client"), 0, slog.LevelInfo, http.StatusNotFound)
+				return
+			}
+
+			slog.WarnContext(r.Context(), "authentication lockout cleared",
+				slog.String("cleared_client_ip", key.ClientIP), slog.String("cleared_user", key.User))
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			g.rr.RespondAndLogCustom(w, r.Context(), fmt.Errorf("method %s is not allowed", r.Method), 0, slog.LevelWarn, http.StatusMethodNotAllowed)
+		}
+	}
+}
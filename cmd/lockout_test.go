@@ -0,0 +1,189 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"transmission-proxy/internal/apikeys"
+	"transmission-proxy/internal/authlog"
+	"transmission-proxy/internal/htpasswd"
+	"transmission-proxy/internal/lockout"
+	"transmission-proxy/internal/reqctx"
+	"transmission-proxy/internal/response"
+	"transmission-proxy/internal/stats"
+)
+
+// aliceUsers is the user store with alice, whose password is alice-secret.
+func aliceUsers(t *testing.T) *htpasswd.File {
+	hash, err := bcrypt.GenerateFromPassword([]byte("alice-secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	users, err := htpasswd.FromEntries([]string{"alice:" + string(hash)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return users
+}
+
+func TestBasicAuthLockout(t *testing.T) {
+	logs := captureLog(t)
+	users := aliceUsers(t)
+
+	st := stats.NewRegistry()
+	g := &authGuard{rr: &response.Responder{}, st: st,
+		tracker: &lockout.Tracker{MaxFailures: 3, Window: time.Minute, Duration: time.Hour}}
+	h := basicAuth(g, users, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	try := func(ip, user, password string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, rpcPath, nil)
+		r = r.WithContext(reqctx.WithClientIP(r.Context(), netip.MustParseAddr(ip)))
+		r.SetBasicAuth(user, password)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	// existing and unknown users are locked out alike, so that the response does not tell them apart
+	for _, user := range []string{"alice", "nobody"} {
+		for i := 0; i < 3; i++ {
+			if w := try("198.51.100.9", user, "guess"); w.Code != http.StatusUnauthorized {
+				t.Fatalf("%s: failure %d got status %d", user, i+1, w.Code)
+			}
+		}
+
+		w := try("198.51.100.9", user, "alice-secret")
+		retryAfter, _ := strconv.Atoi(w.Header().Get("Retry-After"))
+		if w.Code != http.StatusTooManyRequests || retryAfter < 3599 || retryAfter > 3600 {
+			t.Errorf("%s: got status %d, Retry-After %q", user, w.Code, w.Header().Get("Retry-After"))
+		}
+	}
+
+	rec := logRecord(t, logs, "locking out client for 1h0m0s after 3 authentication failures")
+	if rec["level"] != "WARN" {
+		t.Errorf("got lockout record %v, want warning", rec)
+	}
+
+	// other clients are not affected
+	if w := try("192.0.2.1", "alice", "alice-secret"); w.Code != http.StatusOK {
+		t.Errorf("another client got status %d", w.Code)
+	}
+
+	var metrics strings.Builder
+	if err := st.WritePrometheus(&metrics); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(metrics.String(), "\ntransmission_proxy_auth_lockouts_total 2\n") {
+		t.Errorf("lockouts not counted:\n%s", metrics.String())
+	}
+
+	// the admin clears the lockout of alice
+	admin := lockouts(g)
+	w := httptest.NewRecorder()
+	admin.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/proxy/lockouts", nil))
+	var items []lockout.Lockout
+	if err := json.Unmarshal(w.Body.Bytes(), &items); err != nil || len(items) != 2 {
+		t.Fatalf("got lockouts %s, %v", w.Body, err)
+	}
+
+	w = httptest.NewRecorder()
+	admin.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/proxy/lockouts?client_ip=198.51.100.9&user=alice", nil))
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("clearing got status %d", w.Code)
+	}
+	if w := try("198.51.100.9", "alice", "alice-secret"); w.Code != http.StatusOK {
+		t.Errorf("after clearing got status %d", w.Code)
+	}
+	if w := try("198.51.100.9", "nobody", "guess"); w.Code != http.StatusTooManyRequests {
+		t.Errorf("lockout of another user cleared, got status %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	admin.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/proxy/lockouts?client_ip=198.51.100.9&user=alice", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("clearing again got status %d", w.Code)
+	}
+}
+
+func TestAuthFailLog(t *testing.T) {
+	captureLog(t)
+	path := filepath.Join(t.TempDir(), "auth-fail.log")
+	failLog, err := authlog.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sum := sha256.Sum256([]byte("radarr-token"))
+	keysPath := filepath.Join(t.TempDir(), "keys.yaml")
+	keysYAML := "keys: [{name: radarr, token_sha256: " + hex.EncodeToString(sum[:]) + ", rate_limit: 0.001, burst: 1}]"
+	if err := os.WriteFile(keysPath, []byte(keysYAML), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	keys, err := apikeys.Load(keysPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	g := &authGuard{rr: &response.Responder{}, st: stats.NewRegistry(), failLog: failLog,
+		tracker: &lockout.Tracker{MaxFailures: 2, Window: time.Minute, Duration: time.Hour}}
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	h := apiKeyAuth(g, keys, ok, basicAuth(g, aliceUsers(t), ok))
+
+	send := func(ip string, set func(r *http.Request)) {
+		r := httptest.NewRequest(http.MethodPost, rpcPath, nil)
+		r = r.WithContext(reqctx.WithClientIP(r.Context(), netip.MustParseAddr(ip)))
+		set(r)
+		h.ServeHTTP(httptest.NewRecorder(), r)
+	}
+	basic := func(user, password string) func(r *http.Request) {
+		return func(r *http.Request) { r.SetBasicAuth(user, password) }
+	}
+	apiKey := func(token string) func(r *http.Request) {
+		return func(r *http.Request) { r.Header.Set("X-Api-Key", token) }
+	}
+
+	send("192.0.2.1", basic("alice", "alice-secret"))
+	send("192.0.2.1", basic("alice", "guess"))
+	send("192.0.2.1", basic("alice", "guess"))
+	send("192.0.2.1", basic("alice", "alice-secret"))
+	send("2001:db8::7", apiKey("guess"))
+	send("2001:db8::7", apiKey("radarr-token"))
+	send("2001:db8::7", apiKey("radarr-token"))
+
+	const ts = `\d{4}-\d\d-\d\dT\d\d:\d\d:\d\dZ `
+	want := []string{
+		`client=192.0.2.1 reason=bad_credentials user="alice" path="/transmission/rpc"`,
+		`client=192.0.2.1 reason=bad_credentials user="alice" path="/transmission/rpc"`,
+		`client=192.0.2.1 reason=locked_out user="alice" path="/transmission/rpc"`,
+		`client=2001:db8::7 reason=bad_api_key user="" path="/transmission/rpc"`,
+		`client=2001:db8::7 reason=rate_limited user="radarr" path="/transmission/rpc"`,
+	}
+
+	bs, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(bs), "\n"), "\n")
+	if len(lines) != len(want) {
+		t.Fatalf("got %d lines, want %d:\n%s", len(lines), len(want), bs)
+	}
+	for i, line := range lines {
+		if !regexp.MustCompile("^" + ts + regexp.QuoteMeta(want[i]) + "$").MatchString(line) {
+			t.Errorf("line %d: got %q, want %q", i+1, line, want[i])
+		}
+	}
+}
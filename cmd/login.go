@@ -0,0 +1,146 @@
+package main
+
+import (
+	_ "embed"
+	"errors"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"transmission-proxy/internal/authlog"
+	"transmission-proxy/internal/htpasswd"
+	"transmission-proxy/internal/logger"
+	"transmission-proxy/internal/reqctx"
+	"transmission-proxy/internal/session"
+)
+
+var (
+	sessionLogin        = getBoolEnv("SESSION_LOGIN")
+	sessionSecret       = os.Getenv("SESSION_SECRET")
+	sessionCookieSecure = getBoolEnv("SESSION_COOKIE_SECURE")
+)
+
+const loginPath = "/proxy/login"
+
+//go:embed login.html
+var loginHTML string
+
+var loginTemplate = template.Must(template.New("login").Parse(loginHTML))
+
+type loginForm struct {
+	Action string
+	CSRF   string
+	Next   string
+	Error  string
+}
+
+func newSessionManager() *session.Manager {
+	key := []byte(sessionSecret)
+	if sessionSecret == "" {
+		slog.Warn("SESSION_SECRET is not set, using ephemeral key: sessions will not survive restart")
+		key = session.NewKey()
+	}
+
+	return &session.Manager{
+		Key:      key,
+		Lifetime: getDurationEnv("SESSION_LIFETIME", 24*time.Hour),
+		Secure:   sessionCookieSecure,
+	}
+}
+
+// sessionAuth authenticates requests by the session cookie. Requests with other credentials are passed
+// to fallback authentication; browsers without any credentials are redirected to the login page.
+func sessionAuth(sm *session.Manager, browser bool, next, fallback http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, err := sm.User(r)
+		if err == nil {
+			ctx := reqctx.WithUser(r.Context(), user)
+			ctx = logger.ContextWithAttrs(ctx, logger.HTTPUser(user))
+			next.ServeHTTP(w, r.WithContext(ctx))
+			return
+		}
+
+		if errors.Is(err, session.ErrInvalidSession) {
+			slog.WarnContext(r.Context(), "rejected tampered session cookie")
+		}
+
+		if browser && r.Method == http.MethodGet && r.Header.Get("Authorization") == "" {
+			http.Redirect(w, r, loginPath+"?next="+url.QueryEscape(r.URL.RequestURI()), http.StatusFound)
+			return
+		}
+
+		fallback.ServeHTTP(w, r)
+	}
+}
+
+// login serves the login form and starts session for the users from the htpasswd file.
+func login(g *authGuard, sm *session.Manager, users *htpasswd.File) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			renderLogin(w, r, sm, http.StatusOK, loginForm{Next: safeNext(r.URL.Query().Get("next"))})
+		case http.MethodPost:
+			form := loginForm{Next: safeNext(r.PostFormValue("next"))}
+
+			if err := sm.CheckCSRF(r, r.PostFormValue("csrf")); err != nil {
+				slog.WarnContext(r.Context(), "login rejected: "+err.Error(), logger.HTTPStatus(http.StatusForbidden))
+				form.Error = "The form has expired, please try again."
+				renderLogin(w, r, sm, http.StatusForbidden, form)
+				return
+			}
+
+			user := r.PostFormValue("user")
+			if g.rejectLocked(w, r, user) {
+				return
+			}
+
+			if !users.Verify(user, r.PostFormValue("password")) {
+				slog.WarnContext(r.Context(), "login failed", logger.HTTPUser(user), logger.HTTPStatus(http.StatusUnauthorized))
+				g.fail(r, user, authlog.ReasonBadCredentials)
+				form.Error = "Invalid user or password."
+				renderLogin(w, r, sm, http.StatusUnauthorized, form)
+				return
+			}
+
+			g.succeed(r, user)
+			sm.Start(w, user)
+			slog.InfoContext(r.Context(), "user logged in", logger.HTTPUser(user))
+			http.Redirect(w, r, form.Next, http.StatusSeeOther)
+		default:
+			g.rr.RespondAndLogCustom(w, r.Context(), fmt.Errorf("method %s is not allowed", r.Method), 0, slog.LevelWarn, http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+func logout(sm *session.Manager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		sm.End(w)
+		http.Redirect(w, r, loginPath, http.StatusSeeOther)
+	}
+}
+
+func renderLogin(w http.ResponseWriter, r *http.Request, sm *session.Manager, status int, form loginForm) {
+	form.Action = publicPrefix + loginPath
+	form.CSRF = sm.CSRFToken(w)
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	if err := loginTemplate.Execute(w, form); err != nil {
+		slog.ErrorContext(r.Context(), "failed to render login page: "+err.Error(), logger.IgnoredAttr(err))
+	}
+}
+
+// safeNext only allows redirects after login to local paths.
+func safeNext(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return webPath
+	}
+
+	return next
+}
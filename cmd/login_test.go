@@ -0,0 +1,198 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"transmission-proxy/internal/htpasswd"
+	"transmission-proxy/internal/reqctx"
+	"transmission-proxy/internal/response"
+	"transmission-proxy/internal/session"
+	"transmission-proxy/internal/stats"
+)
+
+var csrfInput = regexp.MustCompile(`name="csrf" value="([^"]+)"`)
+
+func testLogin(t *testing.T) (http.Handler, *session.Manager) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("alice-secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	users, err := htpasswd.FromEntries([]string{"alice:" + string(hash)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sm := &session.Manager{Key: session.NewKey(), Lifetime: time.Hour}
+	return login(&authGuard{rr: &response.Responder{}, st: stats.NewRegistry()}, sm, users), sm
+}
+
+// openLoginForm opens the login page, returning the CSRF cookie and the token of the form.
+func openLoginForm(t *testing.T, h http.Handler) (*http.Cookie, string) {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, loginPath+"?next=/transmission/web/%23files", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d", w.Code)
+	}
+
+	m := csrfInput.FindStringSubmatch(w.Body.String())
+	if m == nil {
+		t.Fatalf("no CSRF token in the form:\n%s", w.Body)
+	}
+	if !strings.Contains(w.Body.String(), `name="next" value="/transmission/web/#files"`) {
+		t.Errorf("next page not kept in the form:\n%s", w.Body)
+	}
+
+	return w.Result().Cookies()[0], m[1]
+}
+
+func submitLogin(h http.Handler, csrf *http.Cookie, form url.Values) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, loginPath, strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if csrf != nil {
+		r.AddCookie(csrf)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+
+	return w
+}
+
+func TestLogin(t *testing.T) {
+	captureLog(t)
+	h, sm := testLogin(t)
+	csrf, token := openLoginForm(t, h)
+
+	cases := []struct {
+		name   string
+		csrf   *http.Cookie
+		form   url.Values
+		status int
+	}{
+		{name: "no CSRF cookie", form: url.Values{"csrf": {token}, "user": {"alice"}, "password": {"alice-secret"}},
+			status: http.StatusForbidden},
+		{name: "no CSRF token", csrf: csrf, form: url.Values{"user": {"alice"}, "password": {"alice-secret"}},
+			status: http.StatusForbidden},
+		{name: "wrong password", csrf: csrf, form: url.Values{"csrf": {token}, "user": {"alice"}, "password": {"guess"}},
+			status: http.StatusUnauthorized},
+		{name: "unknown user", csrf: csrf, form: url.Values{"csrf": {token}, "user": {"mallory"}, "password": {"alice-secret"}},
+			status: http.StatusUnauthorized},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			w := submitLogin(h, tc.csrf, tc.form)
+			if w.Code != tc.status {
+				t.Errorf("got status %d, want %d", w.Code, tc.status)
+			}
+			for _, c := range w.Result().Cookies() {
+				if c.Name == session.CookieName {
+					t.Errorf("session started: %+v", c)
+				}
+			}
+		})
+	}
+
+	w := submitLogin(h, csrf, url.Values{"csrf": {token}, "user": {"alice"}, "password": {"alice-secret"},
+		"next": {"/transmission/web/#files"}})
+	if w.Code != http.StatusSeeOther || w.Header().Get("Location") != "/transmission/web/#files" {
+		t.Fatalf("got status %d, Location %q", w.Code, w.Header().Get("Location"))
+	}
+	r := httptest.NewRequest(http.MethodGet, webPath, nil)
+	for _, c := range w.Result().Cookies() {
+		r.AddCookie(c)
+	}
+	if user, err := sm.User(r); err != nil || user != "alice" {
+		t.Errorf("got session of %q, %v", user, err)
+	}
+}
+
+func TestSessionAuth(t *testing.T) {
+	logs := captureLog(t)
+	sm := &session.Manager{Key: session.NewKey(), Lifetime: time.Hour}
+	expired := &session.Manager{Key: sm.Key, Lifetime: -time.Second}
+
+	cookie := func(m *session.Manager, user string) *http.Cookie {
+		w := httptest.NewRecorder()
+		m.Start(w, user)
+		return w.Result().Cookies()[0]
+	}
+	valid := cookie(sm, "alice")
+	tampered := &http.Cookie{Name: session.CookieName, Value: cookie(sm, "alice").Value + "x"}
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("session of " + reqctx.User(r.Context())))
+	})
+	fallback := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	})
+
+	cases := []struct {
+		name     string
+		cookie   *http.Cookie
+		browser  bool
+		status   int
+		location string
+	}{
+		{name: "valid", cookie: valid, browser: true, status: http.StatusOK},
+		{name: "valid on RPC", cookie: valid, status: http.StatusOK},
+		{name: "none in browser", browser: true, status: http.StatusFound, location: loginPath + "?next=%2Ftransmission%2Fweb%2F"},
+		{name: "none on RPC", status: http.StatusUnauthorized},
+		{name: "expired", cookie: cookie(expired, "alice"), browser: true, status: http.StatusFound,
+			location: loginPath + "?next=%2Ftransmission%2Fweb%2F"},
+		{name: "tampered", cookie: tampered, status: http.StatusUnauthorized},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, webPath, nil)
+			if tc.cookie != nil {
+				r.AddCookie(tc.cookie)
+			}
+			w := httptest.NewRecorder()
+			sessionAuth(sm, tc.browser, next, fallback).ServeHTTP(w, r)
+
+			if w.Code != tc.status || w.Header().Get("Location") != tc.location {
+				t.Errorf("got status %d, Location %q, want %d, %q", w.Code, w.Header().Get("Location"), tc.status, tc.location)
+			}
+			if tc.status == http.StatusOK && w.Body.String() != "session of alice" {
+				t.Errorf("got body %q", w.Body)
+			}
+		})
+	}
+
+	if n := strings.Count(logs.String(), "rejected tampered session cookie"); n != 1 {
+		t.Errorf("got %d tampered cookie warnings, want 1:\n%s", n, logs)
+	}
+}
+
+func TestLogout(t *testing.T) {
+	w := httptest.NewRecorder()
+	logout(&session.Manager{Key: session.NewKey()}).ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/proxy/logout", nil))
+
+	c := w.Result().Cookies()[0]
+	if w.Code != http.StatusSeeOther || w.Header().Get("Location") != loginPath || c.Name != session.CookieName || c.MaxAge >= 0 {
+		t.Errorf("got status %d, Location %q, cookie %+v", w.Code, w.Header().Get("Location"), c)
+	}
+}
+
+func TestSafeNext(t *testing.T) {
+	for next, want := range map[string]string{
+		"/transmission/web/#files": "/transmission/web/#files",
+		"":                         webPath,
+		"https://evil.example.com": webPath,
+		"//evil.example.com/":      webPath,
+		`/\evil.example.com`:       webPath,
+		"javascript:alert(1)":      webPath,
+	} {
+		if got := safeNext(next); got != want {
+			t.Errorf("safeNext(%q) = %q, want %q", next, got, want)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	_ "embed"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"transmission-proxy/internal/logger"
+	"transmission-proxy/internal/magnet"
+	"transmission-proxy/internal/response"
+)
+
+//go:embed magnet.html
+var magnetHTML string
+
+var magnetTemplate = template.Must(template.New("magnet").Parse(magnetHTML))
+
+// magnetPage is rendered for browsers: the confirmation form, or the result of adding the link.
+type magnetPage struct {
+	Magnet string
+	Dir    string
+	Labels string
+	Paused bool
+	Link   *magnet.Link
+	Result *addResult
+	Error  string
+}
+
+// addMagnet adds the magnet link from the magnet parameter with optional dir, labels (comma-separated) and paused,
+// e.g. for browsers registering the proxy as magnet: protocol handler. GET only shows the link to be confirmed
+// (as JSON for clients accepting application/json), so that other sites cannot add torrents by linking here;
+// the link is added by POST, which is refused from other origins. The link must have info hash and, if trackers
+// are restricted, only allowed trackers. It is added by torrent-add RPC request through rc, so validation,
+// policies and quotas apply as usual.
+func addMagnet(rr *response.Responder, rc *rpcCaller, trackers []string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodPost {
+			w.Header().Set("Allow", "GET, POST")
+			rr.RespondAndLogCustom(w, r.Context(), fmt.Errorf("method %s is not allowed", r.Method), 0, slog.LevelWarn, http.StatusMethodNotAllowed)
+			return
+		}
+
+		jsonResponse := strings.Contains(r.Header.Get("Accept"), "application/json")
+		fail := func(status int, err error) {
+			if jsonResponse {
+				rr.RespondAndLogCustom(w, r.Context(), err, 0, slog.LevelWarn, status)
+				return
+			}
+
+			slog.WarnContext(r.Context(), err.Error(), logger.HTTPStatus(status))
+			renderMagnet(w, r, status, &magnetPage{Error: err.Error()})
+		}
+
+		if r.Method == http.MethodPost && !sameOrigin(r) {
+			fail(http.StatusForbidden, fmt.Errorf("cross-origin request refused"))
+			return
+		}
+		if err := r.ParseForm(); err != nil {
+			fail(http.StatusBadRequest, fmt.Errorf("failed to parse form: %w", err))
+			return
+		}
+
+		page := &magnetPage{Magnet: r.Form.Get("magnet"), Dir: r.Form.Get("dir"), Labels: r.Form.Get("labels")}
+		if s := r.Form.Get("paused"); s != "" {
+			var err error
+			if page.Paused, err = strconv.ParseBool(s); err != nil {
+				fail(http.StatusBadRequest, fmt.Errorf("paused must be true or false"))
+				return
+			}
+		}
+
+		link, err := magnet.Parse(page.Magnet)
+		if err == nil && len(trackers) > 0 {
+			err = link.CheckTrackers(trackers)
+		}
+		if err != nil {
+			fail(http.StatusBadRequest, err)
+			return
+		}
+		page.Link = link
+
+		if r.Method == http.MethodGet {
+			if jsonResponse {
+				writeJSON(w, r, http.StatusOK, map[string]any{"hash": link.Hash, "name": link.Name, "trackers": link.Trackers})
+			} else {
+				renderMagnet(w, r, http.StatusOK, page)
+			}
+			return
+		}
+
+		args := map[string]any{"filename": page.Magnet, "paused": page.Paused}
+		if page.Dir != "" {
+			args["download-dir"] = page.Dir
+		}
+		if page.Labels != "" {
+			var labels []any
+			for _, l := range strings.Split(page.Labels, ",") {
+				if l = strings.TrimSpace(l); l != "" {
+					labels = append(labels, l)
+				}
+			}
+			args["labels"] = labels
+		}
+
+		page.Result = addTorrent(r, rc, args)
+		status := http.StatusOK
+		if page.Result.Outcome == outcomeRejected {
+			status = orDefault(page.Result.Status, http.StatusUnprocessableEntity)
+		}
+
+		if jsonResponse {
+			writeJSON(w, r, status, page.Result)
+		} else {
+			renderMagnet(w, r, status, page)
+		}
+	}
+}
+
+// sameOrigin reports whether the request comes from the proxy's own pages or from a client which is not a browser.
+// Browsers tell where the request comes from by Sec-Fetch-Site or, if they are older, Origin.
+func sameOrigin(r *http.Request) bool {
+	if site := r.Header.Get("Sec-Fetch-Site"); site != "" {
+		return site == "same-origin" || site == "none"
+	}
+
+	if origin := r.Header.Get("Origin"); origin != "" {
+		u, err := url.Parse(origin)
+		return err == nil && u.Host == r.Host
+	}
+
+	return true
+}
+
+func renderMagnet(w http.ResponseWriter, r *http.Request, status int, page *magnetPage) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	if err := magnetTemplate.Execute(w, page); err != nil {
+		slog.ErrorContext(r.Context(), "failed to render magnet page: "+err.Error(), logger.IgnoredAttr(err))
+	}
+}
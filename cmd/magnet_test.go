@@ -0,0 +1,176 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"transmission-proxy/internal/policy"
+	"transmission-proxy/internal/response"
+)
+
+const testMagnet = "magnet:?xt=urn:btih:c9e15763f722f23e98a29decdfae341b98d53056&dn=Debian+<12>&tr=http://tracker.example/announce"
+
+// testAddMagnet returns the add-magnet handler adding torrents through rpcProxy to the upstream.
+func testAddMagnet(t *testing.T, forwarded *[]string, trackers ...string) http.HandlerFunc {
+	rr := &response.Responder{DebugMode: true}
+	return addMagnet(rr, &rpcCaller{rpc: testRPCProxy(mockUpstream(t, forwarded), func(cfg *rpcProxyConfig) {
+		cfg.responder = rr
+		cfg.policies = []policy.Policy{blockedNames}
+	})}, trackers)
+}
+
+// doMagnet makes the request with the form in the query string for GET and in the body for POST.
+func doMagnet(h http.Handler, method string, form url.Values, header ...string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, "/proxy/add-magnet?"+form.Encode(), nil)
+	if method == http.MethodPost {
+		r = httptest.NewRequest(method, "/proxy/add-magnet", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	for i := 0; i+1 < len(header); i += 2 {
+		r.Header.Set(header[i], header[i+1])
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+
+	return w
+}
+
+func TestAddMagnetHTML(t *testing.T) {
+	captureLog(t)
+	var forwarded []string
+	h := testAddMagnet(t, &forwarded)
+
+	// following the link only shows the confirmation form
+	form := url.Values{"magnet": {testMagnet}, "dir": {"/downloads/linux"}, "labels": {"linux, iso"}}
+	w := doMagnet(h, http.MethodGet, form, "Sec-Fetch-Site", "cross-site")
+	body := w.Body.String()
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "text/html; charset=utf-8" || w.Header().Get("Cache-Control") != "no-store" {
+		t.Fatalf("GET: got status %d, headers %v", w.Code, w.Header())
+	}
+	for _, s := range []string{
+		`<b>Debian &lt;12&gt;</b>`, `<code>c9e15763f722f23e98a29decdfae341b98d53056</code>`, `<form method="post">`,
+		`name="magnet" value="magnet:?xt=urn:btih:c9e15763f722f23e98a29decdfae341b98d53056&amp;dn=Debian&#43;&lt;12&gt;&amp;tr=http://tracker.example/announce"`,
+		`name="dir" value="/downloads/linux"`, `name="labels" value="linux, iso"`,
+	} {
+		if !strings.Contains(body, s) {
+			t.Errorf("GET: no %s in page:\n%s", s, body)
+		}
+	}
+	if len(forwarded) != 0 {
+		t.Errorf("GET added the torrent: %v", forwarded)
+	}
+
+	// submitting the form adds it
+	form.Set("paused", "true")
+	w = doMagnet(h, http.MethodPost, form, "Sec-Fetch-Site", "same-origin")
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "The torrent has been added.") || strings.Contains(w.Body.String(), "<form") {
+		t.Errorf("POST: got status %d, body %s", w.Code, w.Body)
+	}
+	if method, args := lastRPC(t, forwarded); method != "torrent-add" ||
+		args != `{"download-dir":"/downloads/linux","filename":"`+jsonString(testMagnet)+`","labels":["linux","iso"],"paused":true}` {
+		t.Errorf("POST: forwarded %s %s", method, args)
+	}
+
+	if w := doMagnet(h, http.MethodPost, form); !strings.Contains(w.Body.String(), "The torrent has been added already.") {
+		t.Errorf("duplicate: got status %d, body %s", w.Code, w.Body)
+	}
+
+	// submitting from other sites is refused
+	for _, header := range [][]string{{"Sec-Fetch-Site", "cross-site"}, {"Sec-Fetch-Site", "same-site"}, {"Origin", "http://evil.example"}} {
+		w := doMagnet(h, http.MethodPost, form, header...)
+		if w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), `<p class="error">cross-origin request refused</p>`) {
+			t.Errorf("%v: got status %d, body %s", header, w.Code, w.Body)
+		}
+	}
+	if w := doMagnet(h, http.MethodPost, form, "Origin", "http://example.com"); w.Code != http.StatusOK {
+		t.Errorf("same origin: got status %d, body %s", w.Code, w.Body)
+	}
+	if len(forwarded) != 3 {
+		t.Errorf("got %d requests forwarded, want 3", len(forwarded))
+	}
+}
+
+// jsonString returns s as encoded in JSON string.
+func jsonString(s string) string {
+	bs, _ := json.Marshal(s)
+	return string(bs[1 : len(bs)-1])
+}
+
+func TestAddMagnetJSON(t *testing.T) {
+	captureLog(t)
+	var forwarded []string
+	h := testAddMagnet(t, &forwarded)
+
+	form := url.Values{"magnet": {testMagnet}}
+	w := doMagnet(h, http.MethodGet, form, "Accept", "application/json")
+	if w.Code != http.StatusOK || strings.TrimSpace(w.Body.String()) !=
+		`{"hash":"c9e15763f722f23e98a29decdfae341b98d53056","name":"Debian \u003c12\u003e","trackers":["http://tracker.example/announce"]}` {
+		t.Errorf("GET: got status %d, body %s", w.Code, w.Body)
+	}
+
+	w = doMagnet(h, http.MethodPost, form, "Accept", "application/json")
+	var res addResult
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil || w.Code != http.StatusOK || res.Outcome != outcomeAdded {
+		t.Errorf("POST: got status %d, body %s", w.Code, w.Body)
+	}
+	if _, args := lastRPC(t, forwarded); args != `{"filename":"`+jsonString(testMagnet)+`","paused":false}` {
+		t.Errorf("POST: forwarded %s", args)
+	}
+
+	// rejections by validation and policies are reported with their status
+	w = doMagnet(h, http.MethodPost, url.Values{"magnet": {testMagnet}, "dir": {"/etc"}}, "Accept", "application/json")
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), `"outcome":"rejected"`) {
+		t.Errorf("outside prefix: got status %d, body %s", w.Code, w.Body)
+	}
+	if len(forwarded) != 1 {
+		t.Errorf("got %d requests forwarded, want 1", len(forwarded))
+	}
+}
+
+func TestAddMagnetInvalid(t *testing.T) {
+	captureLog(t)
+	var forwarded []string
+	h := testAddMagnet(t, &forwarded, "tracker.example")
+
+	cases := []struct {
+		name   string
+		form   url.Values
+		status int
+		err    string
+	}{
+		{name: "not magnet", form: url.Values{"magnet": {"http://example.com/debian.torrent"}}, status: http.StatusBadRequest,
+			err: "not a magnet link"},
+		{name: "no hash", form: url.Values{"magnet": {"magnet:?dn=debian"}}, status: http.StatusBadRequest, err: "magnet link has no bittorrent info hash"},
+		{name: "bad hash", form: url.Values{"magnet": {"magnet:?xt=urn:btih:c9e1"}}, status: http.StatusBadRequest, err: "malformed info hash"},
+		{name: "tracker", form: url.Values{"magnet": {testMagnet + "&tr=udp://other.example"}}, status: http.StatusBadRequest,
+			err: "tracker is not allowed: udp://other.example"},
+		{name: "paused", form: url.Values{"magnet": {testMagnet}, "paused": {"maybe"}}, status: http.StatusBadRequest,
+			err: "paused must be true or false"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			for _, method := range []string{http.MethodGet, http.MethodPost} {
+				w := doMagnet(h, method, tc.form)
+				if w.Code != tc.status || !strings.Contains(strings.ToLower(w.Body.String()), `<p class="error">`+tc.err) || strings.Contains(w.Body.String(), "<form") {
+					t.Errorf("%s: got status %d, body %s", method, w.Code, w.Body)
+				}
+
+				w = doMagnet(h, method, tc.form, "Accept", "application/json")
+				if w.Code != tc.status || !strings.Contains(strings.ToLower(w.Body.String()), tc.err) {
+					t.Errorf("%s JSON: got status %d, body %s", method, w.Code, w.Body)
+				}
+			}
+		})
+	}
+	if len(forwarded) != 0 {
+		t.Errorf("invalid links added: %v", forwarded)
+	}
+
+	if w := doMagnet(h, http.MethodDelete, nil); w.Code != http.StatusMethodNotAllowed || w.Header().Get("Allow") != "GET, POST" {
+		t.Errorf("DELETE: got status %d", w.Code)
+	}
+}
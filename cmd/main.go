@@ -1,25 +1,49 @@
 package main
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
 	"log/slog"
 	"net/http"
-	"net/url"
 	"os"
+	"os/signal"
 	"path"
 	"runtime"
+	"slices"
+	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
 	_ "github.com/joho/godotenv/autoload"
 
+	"transmission-proxy/internal/apikeys"
+	"transmission-proxy/internal/audit"
+	"transmission-proxy/internal/authz"
+	"transmission-proxy/internal/capture"
+	"transmission-proxy/internal/clientip"
+	"transmission-proxy/internal/events"
+	"transmission-proxy/internal/exporter"
+	"transmission-proxy/internal/fault"
+	"transmission-proxy/internal/forwardauth"
 	"transmission-proxy/internal/jrpc"
 	"transmission-proxy/internal/logger"
+	"transmission-proxy/internal/mirror"
+	"transmission-proxy/internal/ownership"
+	"transmission-proxy/internal/policy"
+	"transmission-proxy/internal/quota"
+	"transmission-proxy/internal/redact"
+	"transmission-proxy/internal/reqctx"
 	"transmission-proxy/internal/response"
+	"transmission-proxy/internal/roles"
+	"transmission-proxy/internal/rpccache"
+	"transmission-proxy/internal/stats"
 	"transmission-proxy/internal/transmission"
+	"transmission-proxy/internal/upstream"
+	"transmission-proxy/internal/userstats"
+	"transmission-proxy/transmissionproxy"
 )
 
 func getEnvOrDefault(key, default_ string) string {
@@ -38,151 +62,985 @@ func getBoolEnv(key string) bool {
 	return false
 }
 
+func getDurationEnv(key string, default_ time.Duration) time.Duration {
+	val := os.Getenv(key)
+	if val == "" {
+		return default_
+	}
+
+	d, err := time.ParseDuration(val)
+	if err != nil || d <= 0 {
+		slog.Error(key + " must be a positive duration, e.g. 10s")
+		os.Exit(1)
+	}
+
+	return d
+}
+
+func getSizeEnv(key string, default_ int64) int64 {
+	val := os.Getenv(key)
+	if val == "" {
+		return default_
+	}
+
+	n, err := strconv.ParseInt(val, 10, 64)
+	if err != nil || n <= 0 {
+		slog.Error(key + " must be a positive integer")
+		os.Exit(1)
+	}
+
+	return n
+}
+
+func getListEnv(key, default_ string) []string {
+	var res []string
+	for _, item := range strings.Split(getEnvOrDefault(key, default_), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			res = append(res, item)
+		}
+	}
+
+	return res
+}
+
 var (
 	downloadPrefix = os.Getenv("DOWNLOAD_PREFIX")
 	upstreamHost   = os.Getenv("UPSTREAM_HOST")
 	webPath        = getEnvOrDefault("WEB_PATH", "/transmission/web/")
-	rpcPath        = getEnvOrDefault("RPC_PATH", "/transmission/rpc")
+	rpcPath        = rpcPaths[0]
+	trustedProxies = os.Getenv("TRUSTED_PROXIES")
+	usersConfig    = os.Getenv("USERS_CONFIG")
+	labelIsolation = getBoolEnv("LABEL_ISOLATION")
+	validatorCfg   = os.Getenv("VALIDATOR_CONFIG")
+	ownerPrefix    = os.Getenv("OWNER_LABEL_PREFIX")
+	auditLogFile   = os.Getenv("AUDIT_LOG_FILE")
+	ownershipDB    = os.Getenv("OWNERSHIP_DB")
+	userSubdirMode = getBoolEnv("USER_SUBDIR_MODE")
+	hideOutside    = getBoolEnv("HIDE_OUTSIDE_PREFIX")
+	rewriteSession = getBoolEnv("REWRITE_SESSION_DIRS")
+
+	strictNumericTypes = getBoolEnv("STRICT_NUMERIC_TYPES")
 
 	debugMode = getBoolEnv("DEBUG_MODE")
+
+	externalAuthzURL = os.Getenv("EXTERNAL_AUTHZ_URL")
+
+	denyMethods = getListEnv("DENY_METHODS", "")
+	readOnly    = getBoolEnv("READ_ONLY")
+
+	forceDownloadDir = getBoolEnv("FORCE_DOWNLOAD_DIR")
+
+	removeDataCheck        = getBoolEnv("REMOVE_DATA_CHECK")
+	removeDataCheckTimeout = getDurationEnv("REMOVE_DATA_CHECK_TIMEOUT", 3*time.Second)
+
+	metricsPath   = getEnvOrDefault("METRICS_PATH", "/metrics")
+	metricsListen = os.Getenv("METRICS_LISTEN")
+
+	rejectedBodyCapture  = getBoolEnv("REJECTED_BODY_CAPTURE")
+	rejectedBodyMaxBytes = getEnvOrDefault("REJECTED_BODY_MAX_BYTES", "4096")
+
+	maxRPCBodyBytes = getSizeEnv("MAX_RPC_BODY_BYTES", 16<<20)
+
+	// compressRPC makes the proxy compress RPC responses for clients accepting gzip when the upstream did not,
+	// since torrent-get responses listing many torrents are large.
+	compressRPC = getBoolEnv("COMPRESS_RPC")
 )
 
-type rpcTag struct{}
+// bodyCapture describes how bodies of rejected requests are captured for debugging.
+type bodyCapture struct {
+	maxBytes int
+	redactor *redact.Redactor
+}
+
+func (c *bodyCapture) capture(bs []byte) string {
+	return redact.Truncate(c.redactor.Body(bs), c.maxBytes)
+}
+
+// minReconcileInterval keeps the ownership reconciliation from loading upstream with full torrent lists too often.
+const minReconcileInterval = time.Minute
+
+// rpcProxyConfig holds what rpcProxy needs to handle the requests. audit, mirror, faults and rejectedBodies
+// are optional, the features are disabled when they are nil.
+type rpcProxyConfig struct {
+	validator transmission.RequestValidator
+	// shadowValidation forwards the requests as they are, only recording what validation would have done.
+	shadowValidation bool
+	policies         []policy.Policy
+	audit            *audit.Log
+	events           *events.Bus
+	mirror           *mirror.Mirror
+	faults           *fault.Injector
+	capture          *capture.Writer
+	drain            *drainMode
+	clientLimit      *clientLimit
+	responder        *response.Responder
+	stats            *stats.Registry
+	// rejectedBodies captures bodies of the rejected requests (see REJECTED_BODY_CAPTURE).
+	rejectedBodies *bodyCapture
+	bodyLog        *rpcBodyLog
+}
 
-func proxy(gw *url.URL, rr *response.Responder) http.HandlerFunc {
-	c := &http.Client{
-		CheckRedirect: func(req *http.Request, via []*http.Request) error {
-			return http.ErrUseLastResponse
+// rpcProxy returns the RPC handler of the library with the features of the command enabled by cfg.
+func rpcProxy(gw http.Handler, cfg rpcProxyConfig) http.HandlerFunc {
+	rc := transmissionproxy.RPCConfig{
+		Validator:         cfg.validator,
+		Policies:          cfg.policies,
+		MaxBodyBytes:      maxRPCBodyBytes,
+		Timeout:           rpcTimeout,
+		CompressResponses: compressRPC,
+		Responder:         cfg.responder,
+		Stats:             cfg.stats,
+		Hooks: transmissionproxy.RPCHooks{
+			Admit:     cfg.admit,
+			Rejected:  cfg.rejected,
+			Forward:   cfg.forward,
+			Forwarded: cfg.forwarded,
 		},
 	}
+	if cfg.shadowValidation {
+		rc.ShadowValidation = cfg.shadow
+	}
+	if cfg.capture != nil {
+		rc.Hooks.Begin = cfg.begin
+	}
 
-	return func(w http.ResponseWriter, r *http.Request) {
-		u := gw.JoinPath(r.URL.Path)
-		u.RawQuery = r.URL.RawQuery
-		r.URL = u
-		r.RequestURI = ""
+	return transmissionproxy.RPC(gw, rc)
+}
 
-		resp, err := c.Do(r)
-		if err != nil {
-			var tag int
-			if t := r.Context().Value(rpcTag{}); t != nil {
-				tag = t.(int)
-			}
+// begin starts capturing the exchange, if capture is enabled.
+func (cfg *rpcProxyConfig) begin(w *response.Recorder, r *http.Request) func(*transmissionproxy.RPCOutcome) {
+	c := startCapture(cfg.capture, w, r)
+	if c == nil {
+		return nil
+	}
 
-			rr.RespondAndLogCustom(w, r.Context(), fmt.Errorf("upstream error: %w", err), tag, slog.LevelError, http.StatusBadGateway)
-			return
-		}
+	return func(out *transmissionproxy.RPCOutcome) {
+		c.outcome(out)
+		c.finish(cfg.capture, w)
+	}
+}
 
-		for h, vals := range resp.Header {
-			for _, val := range vals {
-				w.Header().Add(h, val)
-			}
-		}
+// admit refuses requests over the rate limit of the client and changes in drain mode.
+func (cfg *rpcProxyConfig) admit(w http.ResponseWriter, r *http.Request, req *jrpc.Request) string {
+	if cfg.clientLimit.refuse(w, r, req, cfg.responder) {
+		return rejectRateLimit
+	}
 
-		w.WriteHeader(resp.StatusCode)
+	// dry runs change nothing, so they are checked as usual
+	if msg := cfg.drain.refuses(req.Method); msg != "" && !transmissionproxy.IsDryRun(r) {
+		cfg.drain.refuse(w, r, req, msg)
+		return rejectDrain
+	}
 
-		defer func() { _ = resp.Body.Close() }()
+	return ""
+}
 
-		_, err = io.Copy(w, resp.Body)
-		if err != nil {
-			slog.ErrorContext(r.Context(), "proxy: failed to write response: "+err.Error(), logger.IgnoredAttr(err))
-		}
+// shadow records what validation in shadow mode would have done to the request.
+func (cfg *rpcProxyConfig) shadow(r *http.Request, req, sanitized *jrpc.Request, err error) {
+	if err != nil {
+		recordShadow(r.Context(), cfg.stats, groupValidation, req, err, transmission.RejectReason(err))
+	} else if v := policy.ChangeViolation(groupValidation, req, sanitized); v != nil {
+		recordShadow(r.Context(), cfg.stats, groupValidation, req, v, rejectRewrite)
 	}
 }
 
-func rpcProxy(gw http.Handler, v transmission.RequestValidator, rr *response.Responder) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		req, err := jrpc.FromRequest(r)
-		if err != nil {
-			rr.RespondAndLogCustom(w, r.Context(), fmt.Errorf("failed to unmarshal RPC request: %w", err), 0, slog.LevelError, http.StatusBadRequest)
-			return
-		}
+// rejected completes the record of the request rejected by validation or policy with its body in debug mode,
+// and records the rejection in the audit log too if the method is not read-only and the request is not a dry run.
+func (cfg *rpcProxyConfig) rejected(r *http.Request, req *jrpc.Request, err error, reason string, status int, rej *stats.Rejection) error {
+	if cfg.rejectedBodies != nil {
+		rej.Body = cfg.rejectedBodies.capture(req.Raw)
+		err = logger.WithAttributes(err, logger.RPC(slog.String(logger.KeyRejectedBody, rej.Body)))
+	}
+	// dry runs are not audited and streamed, as nothing was attempted
+	if transmissionproxy.IsDryRun(r) {
+		return err
+	}
 
-		if err = v.Validate(req); err != nil {
-			rr.RespondAndLogCustom(w, r.Context(), fmt.Errorf("invalid RPC request: %w", err), req.Tag, slog.LevelError, http.StatusBadRequest)
-			return
+	if cfg.audit != nil && !slices.Contains(transmission.ReadOnlyMethods, req.Method) {
+		rec := auditRecord(r, req)
+		rec.Status = status
+		rec.Result = rej.Reason
+		var violation *policy.Violation
+		if errors.As(err, &violation) {
+			rec.Policy = violation.Policy
 		}
+		writeAuditRecord(cfg.audit, r, rec)
+	}
+	cfg.events.Publish(events.Event{
+		Type:     events.TypeRejection,
+		Time:     rej.Time,
+		ClientIP: rej.ClientIP,
+		User:     reqctx.User(r.Context()),
+		Method:   req.Method,
+		Tag:      req.Tag,
+		Ids:      req.Arguments["ids"],
+		Status:   status,
+		Reason:   reason,
+	})
 
-		bs, err := json.Marshal(req)
-		if err != nil {
-			rr.RespondAndLogError(w, r.Context(), fmt.Errorf("cannot serialize RPC request: %w", err), req.Tag)
-			return
-		}
+	return err
+}
 
-		r.ContentLength = -1
-		r.Header.Del("Content-Length")
-		r.Body = io.NopCloser(bytes.NewReader(bs))
+// forward injects the configured faults, and logs and mirrors the request about to be forwarded.
+func (cfg *rpcProxyConfig) forward(w *response.Recorder, r *http.Request, sanitized *jrpc.Request, body []byte) bool {
+	if cfg.faults != nil && injectFaults(cfg.faults, w, r, sanitized, cfg.responder) {
+		return false
+	}
 
-		gw.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), rpcTag{}, req.Tag)))
+	if cfg.bodyLog.enabled(r.Context()) {
+		cfg.bodyLog.request(r.Context(), w, sanitized.Method, body)
 	}
+	if cfg.mirror != nil {
+		cfg.mirror.Submit(sanitized)
+	}
+
+	return true
 }
 
-func homePage(gw http.Handler) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		if r.URL.Path == "/" {
-			gw.ServeHTTP(w, r)
-			return
+// forwarded logs the response and records the executed request in the audit log and the event stream.
+func (cfg *rpcProxyConfig) forwarded(w *response.Recorder, r *http.Request, req, sanitized *jrpc.Request) {
+	if cfg.bodyLog.enabled(r.Context()) {
+		cfg.bodyLog.response(r.Context(), w, req.Method)
+	}
+
+	// 409 only negotiates the session id, the request is not executed
+	if w.UpstreamStatus() == http.StatusConflict {
+		return
+	}
+	if cfg.audit != nil && !slices.Contains(transmission.ReadOnlyMethods, req.Method) {
+		writeAudit(cfg.audit, r, sanitized, w)
+	}
+	cfg.events.Publish(events.Event{
+		Type:     events.TypeRPC,
+		Time:     time.Now(),
+		ClientIP: clientIP(r),
+		User:     reqctx.User(r.Context()),
+		Method:   req.Method,
+		Tag:      req.Tag,
+		Ids:      sanitized.Arguments["ids"],
+		Status:   w.Status(),
+	})
+}
+
+// writeAudit records the forwarded mutating request in the audit log.
+func writeAudit(al *audit.Log, r *http.Request, req *jrpc.Request, w *response.Recorder) {
+	rec := auditRecord(r, req)
+	rec.Status = w.Status()
+	rec.UpstreamStatus = w.UpstreamStatus()
+	writeAuditRecord(al, r, rec)
+}
+
+// auditRecord describes the RPC request for the audit log, without the outcome.
+func auditRecord(r *http.Request, req *jrpc.Request) *audit.Record {
+	return &audit.Record{
+		Time:         time.Now(),
+		ClientIP:     clientIP(r),
+		User:         reqctx.User(r.Context()),
+		Impersonator: reqctx.Impersonator(r.Context()),
+		Path:         reqctx.RPCPath(r.Context()),
+		Method:       req.Method,
+		Tag:          req.Tag,
+		Ids:          req.Arguments["ids"],
+		Arguments:    audit.RecordedArguments(req.Arguments),
+	}
+}
+
+func writeAuditRecord(al *audit.Log, r *http.Request, rec *audit.Record) {
+	if err := al.Write(rec); err != nil {
+		slog.ErrorContext(r.Context(), "failed to write audit log: "+err.Error(), logger.IgnoredAttr(err))
+	}
+}
+
+// reopenAuditOnSignal reopens the audit log on SIGHUP, so that it can be rotated.
+func reopenAuditOnSignal(al *audit.Log) {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGHUP)
+
+	go func() {
+		for range ch {
+			if err := al.Reopen(); err != nil {
+				slog.Error("failed to reopen AUDIT_LOG_FILE: "+err.Error(), logger.IgnoredAttr(err))
+			}
 		}
+	}()
+}
+
+// clientIP returns the client address of the request, or empty string if unknown.
+func clientIP(r *http.Request) string {
+	if ip := reqctx.ClientIP(r.Context()); ip.IsValid() {
+		return ip.String()
+	}
 
+	return ""
+}
+
+func status(st *stats.Registry, rec *ownership.Reconciler, dr *drainMode) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
 		data := map[string]any{}
-		data["result"] = "page not found"
+		data["drain"] = dr.state()
+		data["upstreams"] = st.Upstreams()
+		data["recent_rejections"] = st.RecentRejections()
+		data["shadow_rejections"] = st.ShadowRejections()
+		if rec != nil {
+			data["ownership_reconciliation"] = rec.Status()
+		}
 
 		bs, _ := json.Marshal(data)
 
 		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusNotFound)
 
 		if _, err := fmt.Fprintln(w, string(bs)); err != nil {
-			slog.ErrorContext(r.Context(), "not_found: failed to write response: "+err.Error(), logger.IgnoredAttr(err))
+			slog.ErrorContext(r.Context(), "status: failed to write response: "+err.Error(), logger.IgnoredAttr(err))
 		}
 	}
 }
 
-func main() {
-	_, thisFile, _, _ := runtime.Caller(0)
-	logger.SetupSLog(slog.LevelDebug, path.Dir(path.Dir(thisFile)))
+func metrics(st *stats.Registry, col *exporter.Collector) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
 
-	if downloadPrefix == "" {
-		slog.Error("DOWNLOAD_PREFIX must be defined")
-		os.Exit(1)
+		err := st.WritePrometheus(w)
+		if err == nil && col != nil {
+			err = col.WritePrometheus(w)
+		}
+		if err != nil {
+			slog.ErrorContext(r.Context(), "metrics: failed to write response: "+err.Error(), logger.IgnoredAttr(err))
+		}
 	}
-	if downloadPrefix[0] != '/' {
-		slog.Error("DOWNLOAD_PREFIX must begin with /")
+}
+
+func checkPrefix(prefix string) error {
+	switch {
+	case prefix == "":
+		return errors.New("must be defined")
+	case prefix[0] != '/':
+		return errors.New("must begin with /")
+	case prefix[len(prefix)-1] != '/':
+		return errors.New("must end with /")
+	}
+
+	return nil
+}
+
+func checkDownloadPrefix(prefix string) {
+	if err := checkPrefix(prefix); err != nil {
+		slog.Error("DOWNLOAD_PREFIX " + err.Error())
 		os.Exit(1)
 	}
-	if downloadPrefix[len(downloadPrefix)-1] != '/' {
-		slog.Error("DOWNLOAD_PREFIX must end with /")
+}
+
+// buildValidator constructs the validator stack shared by the proxy and the validate subcommand.
+func buildValidator(prefix string) *transmission.MethodsValidator {
+	transmission.StrictNumericTypes = strictNumericTypes
+
+	v := transmission.DefaultMethodsValidator(prefix)
+	if fv := filenameValidator(); fv != nil {
+		if hf, ok := v.Methods["torrent-add"].(transmission.HasFields); ok {
+			v.Methods["torrent-add"] = hf.WithField("filename", fv)
+		}
+	}
+	if forceDownloadDir && prefix != "/" {
+		// otherwise Transmission uses its own default, which may be outside the prefix; the root prefix of
+		// admins allows any directory, so they keep the default
+		if hd, ok := v.Methods["torrent-add"].(transmission.HasDefaults); ok {
+			v.Methods["torrent-add"] = hd.WithDefault("download-dir", prefix)
+		}
+	}
+	if validatorCfg != "" {
+		cfg, err := transmission.LoadValidatorConfig(validatorCfg)
+		if err == nil {
+			err = cfg.Apply(v)
+		}
+		if err != nil {
+			slog.Error("failed to load VALIDATOR_CONFIG: "+err.Error(), logger.IgnoredAttr(err))
+			os.Exit(1)
+		}
+	}
+
+	for _, method := range denyMethods {
+		if !transmission.IsSpecMethod(method) {
+			slog.Error("DENY_METHODS lists unknown method " + method)
+			os.Exit(1)
+		}
+	}
+	v.DenyMethods(denyMethods...)
+	if readOnly {
+		v.AllowOnlyMethods(transmission.ReadOnlyMethods...)
+	}
+
+	return v
+}
+
+func rootPath() string {
+	_, thisFile, _, _ := runtime.Caller(0)
+	return path.Dir(path.Dir(thisFile))
+}
+
+func main() {
+	if len(os.Args) > 1 && (os.Args[1] == "--version" || os.Args[1] == "version") {
+		printVersion()
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "validate" {
+		logger.SetupSLog(slog.LevelError, rootPath())
+		os.Exit(validateCommand(os.Args[2:], os.Stdin, os.Stdout))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "replay" {
+		logger.SetupSLog(slog.LevelError, rootPath())
+		os.Exit(replayCommand(os.Args[2:], os.Stdout))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "mock-upstream" {
+		logger.SetupSLog(slog.LevelInfo, rootPath())
+		os.Exit(mockUpstreamCommand(os.Args[2:]))
+	}
+
+	logger.SetupSLog(startLogLevel, rootPath())
+
+	info := readBuildInfo()
+	slog.Info("starting "+info.String(),
+		slog.String("version", info.Version), slog.String("commit", info.Commit), slog.String("go_version", info.GoVersion),
+		slog.String("log_level", startLogLevel.String()))
+
+	checkDownloadPrefix(downloadPrefix)
+	pathConfigs := loadRPCPathConfigs()
+
+	upstreams := parseUpstreamHosts()
+	gw := upstreams[0]
+	pool := upstreamPool(upstreams)
+
+	trusted, err := clientip.ParseTrustedProxies(trustedProxies)
+	if err != nil {
+		slog.Error("failed to parse TRUSTED_PROXIES: "+err.Error(), logger.IgnoredAttr(err))
 		os.Exit(1)
 	}
+	ipResolver := &clientip.Resolver{TrustedProxies: trusted}
+
+	rr := &response.Responder{DebugMode: debugMode, ErrorPage: errorPageFromEnv()}
+
+	var hooks []transmission.ValidationHook
+	if externalAuthzURL != "" {
+		skip := map[string]bool{}
+		for _, m := range getListEnv("EXTERNAL_AUTHZ_SKIP_METHODS", strings.Join(transmission.ReadOnlyMethods, ",")) {
+			skip[m] = true
+		}
+
+		ac := &authz.Client{
+			URL:         externalAuthzURL,
+			Timeout:     getDurationEnv("EXTERNAL_AUTHZ_TIMEOUT", 2*time.Second),
+			FailOpen:    getBoolEnv("EXTERNAL_AUTHZ_FAIL_OPEN"),
+			SkipMethods: skip,
+			Redactor:    redact.New(redact.DefaultFields),
+			HTTP:        &http.Client{},
+		}
+		hooks = append(hooks, ac.Hook)
+	}
 
-	if upstreamHost == "" {
-		slog.Error("UPSTREAM_HOST must be defined")
+	var keys *apikeys.Store
+	if apiKeysFile != "" {
+		keys = loadAPIKeys()
+	}
+
+	st := stats.NewRegistry()
+	var ut http.RoundTripper = upstreamTransport(st.Upstream(gw.Host))
+	if pool != nil {
+		ut = newHostTransports(upstreams, st)
+	}
+	uc := &upstream.Client{URL: gw.JoinPath(rpcPath).String(), HTTP: &http.Client{Transport: ut}, Username: upstreamUser, Password: upstreamPassword, Pool: pool}
+	if !sessionPassthrough {
+		uc.Session = &upstream.Session{}
+	}
+
+	newValidator := func(prefix string, pre ...transmission.ValidationHook) *transmission.MethodsValidator {
+		v := buildValidator(prefix)
+		if keys != nil {
+			v.RegisterPreValidateHook(apikeys.MethodACL)
+		}
+		for _, h := range pre {
+			v.RegisterPreValidateHook(h)
+		}
+		// every torrent is under the root, e.g. for admins
+		if removeDataCheck && prefix != "/" {
+			c := &transmission.RemoveDataCheck{Prefix: prefix, Client: uc, Timeout: removeDataCheckTimeout}
+			v.RegisterPostValidateHook(c.Hook)
+		}
+		for _, h := range hooks {
+			v.RegisterPostValidateHook(h)
+		}
+
+		return v
+	}
+
+	var bc *bodyCapture
+	if rejectedBodyCapture {
+		maxBytes, err := strconv.Atoi(rejectedBodyMaxBytes)
+		if err != nil || maxBytes <= 0 {
+			slog.Error("REJECTED_BODY_MAX_BYTES must be a positive integer")
+			os.Exit(1)
+		}
+
+		if debugMode {
+			bc = &bodyCapture{maxBytes: maxBytes, redactor: redact.New(redact.DefaultFields)}
+		} else {
+			slog.Warn("REJECTED_BODY_CAPTURE is ignored unless DEBUG_MODE is enabled")
+		}
+	}
+
+	guard := newAuthGuard(rr, st)
+
+	var authenticate func(h http.Handler, browser bool) http.Handler
+	var known []func(user string) bool
+	switch {
+	case htpasswdFile != "" && proxyUsers != nil:
+		slog.Error("PROXY_HTPASSWD_FILE and PROXY_USERS are mutually exclusive")
+		os.Exit(1)
+	case (htpasswdFile != "" || proxyUsers != nil) && forwardAuthURL != "":
+		slog.Error("PROXY_HTPASSWD_FILE (or PROXY_USERS) and FORWARD_AUTH_URL are mutually exclusive")
 		os.Exit(1)
+	case htpasswdFile != "" || proxyUsers != nil:
+		users := loadHtpasswd()
+		if htpasswdFile != "" {
+			reloadHtpasswd(users)
+		}
+		known = append(known, users.Has)
+		authenticate = func(h http.Handler, _ bool) http.Handler { return basicAuth(guard, users, h) }
+
+		if sessionLogin {
+			sm := newSessionManager()
+			http.Handle(loginPath, login(guard, sm, users))
+			http.Handle("/proxy/logout", logout(sm))
+
+			authenticate = func(h http.Handler, browser bool) http.Handler {
+				return sessionAuth(sm, browser, h, basicAuth(guard, users, h))
+			}
+		}
+	case sessionLogin:
+		slog.Error("SESSION_LOGIN requires PROXY_HTPASSWD_FILE or PROXY_USERS")
+		os.Exit(1)
+	case forwardAuthURL != "":
+		fa := &forwardauth.Client{
+			URL:        forwardAuthURL,
+			Timeout:    getDurationEnv("FORWARD_AUTH_TIMEOUT", 2*time.Second),
+			UserHeader: getEnvOrDefault("FORWARD_AUTH_USER_HEADER", "Remote-User"),
+			CacheTTL:   getDurationEnv("FORWARD_AUTH_CACHE_TTL", 10*time.Second),
+			HTTP: &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			}},
+		}
+		authenticate = func(h http.Handler, browser bool) http.Handler { return forwardAuth(rr, fa, browser, h) }
+	}
+
+	if keys != nil {
+		fallback := authenticate
+		authenticate = func(h http.Handler, browser bool) http.Handler {
+			var fh http.Handler
+			if fallback != nil {
+				fh = fallback(h, browser)
+			}
+
+			return apiKeyAuth(guard, keys, h, fh)
+		}
+	}
+
+	auth := func(h http.Handler, browser bool) http.Handler {
+		if authenticate == nil {
+			return h
+		}
+
+		return authenticate(h, browser)
+	}
+
+	rl := roles.New(getListEnv("ADMIN_USERS", ""))
+	if usersConfig != "" {
+		if err := rl.LoadUsers(usersConfig); err != nil {
+			slog.Error("failed to load USERS_CONFIG: "+err.Error(), logger.IgnoredAttr(err))
+			os.Exit(1)
+		}
 	}
-	if !strings.HasSuffix(upstreamHost, "/") {
-		upstreamHost += "/"
+
+	known = append(known, rl.Known)
+	if keys != nil {
+		if err := checkKeyNames(keys, known...); err != nil {
+			slog.Error("API_KEYS_FILE conflicts with users: "+err.Error(), logger.IgnoredAttr(err))
+			os.Exit(1)
+		}
+		known = append(known, func(user string) bool { return keys.ByName(user) != nil })
 	}
-	gw, err := url.Parse(upstreamHost)
+	exists := func(user string) bool {
+		for _, k := range known {
+			if k(user) {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	// validatorFor builds the validator stack for requests at the RPC path with the download prefix and
+	// the method restrictions of the path
+	validatorFor := func(prefix string, pre ...transmission.ValidationHook) transmission.RequestValidator {
+		build := func(prefix string) transmission.RequestValidator {
+			return newValidator(prefix, pre...)
+		}
+		forPrefix := func(prefix string) transmission.RequestValidator {
+			if strings.Contains(prefix, userPlaceholder) {
+				if authenticate == nil {
+					slog.Error("download prefix " + prefix + " with " + userPlaceholder + " requires authentication to be configured")
+					os.Exit(1)
+				}
+				return newUserPrefixValidator(prefix, build)
+			}
+
+			return build(prefix)
+		}
+
+		v := forPrefix(prefix)
+		if keys != nil {
+			kv := &keyScopedValidator{def: v, byPrefix: map[string]transmission.RequestValidator{}}
+			for _, k := range keys.Keys {
+				if k.DownloadPrefix != "" && kv.byPrefix[k.DownloadPrefix] == nil {
+					kv.byPrefix[k.DownloadPrefix] = forPrefix(k.DownloadPrefix)
+				}
+			}
+			v = kv
+		}
+		if len(rl.Admins) > 0 {
+			v = &adminValidator{def: v, admin: newValidator("/", pre...), roles: rl}
+		}
+
+		return v
+	}
+
+	if upstreamUser != "" && authenticate == nil {
+		// otherwise anyone would act with the upstream credentials
+		slog.Error("UPSTREAM_USER requires authentication to be configured")
+		os.Exit(1)
+	}
+
+	em, err := parseEnforcement(os.Getenv("ENFORCEMENT_MODE"))
 	if err != nil {
-		slog.Error("failed to parse UPSTREAM_HOST: "+err.Error(), logger.IgnoredAttr(err))
+		slog.Error("failed to parse ENFORCEMENT_MODE: "+err.Error(), logger.IgnoredAttr(err))
 		os.Exit(1)
 	}
-	if gw.Path != "/" || gw.RawQuery != "" || gw.Fragment != "" {
-		slog.Error("UPSTREAM_HOST must not define path or query")
+
+	var policies []policy.Policy
+	// prefixed builds the policies depending on the download prefix, by their index in policies
+	prefixed := map[int]func(prefix string) policy.Policy{}
+	if authenticate != nil {
+		policies = append(policies, em.policy("groups", &policy.Groups{
+			Roles:             rl,
+			GroupSetAdminOnly: os.Getenv("GROUP_SET_ADMIN_ONLY") == "" || getBoolEnv("GROUP_SET_ADMIN_ONLY"),
+			Upstream:          uc,
+		}, st))
+	}
+	if getBoolEnv("PROVISION_GROUPS") {
+		go provisionGroups(uc, rl)
+	}
+	if labelIsolation {
+		if authenticate == nil {
+			slog.Error("LABEL_ISOLATION requires authentication to be configured")
+			os.Exit(1)
+		}
+
+		policies = append(policies, em.policy("labels", &policy.Labels{Roles: rl, Upstream: uc}, st))
+	}
+	if ownerPrefix != "" {
+		if authenticate == nil {
+			slog.Error("OWNER_LABEL_PREFIX requires authentication to be configured")
+			os.Exit(1)
+		}
+
+		policies = append(policies, em.policy("owner_label", &policy.OwnerLabel{Prefix: ownerPrefix, Roles: rl, Upstream: uc}, st))
+	}
+	if userSubdirMode {
+		if authenticate == nil {
+			slog.Error("USER_SUBDIR_MODE requires authentication to be configured")
+			os.Exit(1)
+		}
+		if strings.Contains(downloadPrefix, userPlaceholder) {
+			slog.Error("USER_SUBDIR_MODE cannot be used with " + userPlaceholder + " in DOWNLOAD_PREFIX")
+			os.Exit(1)
+		}
+
+		prefixed[len(policies)] = func(prefix string) policy.Policy {
+			return em.policy("user_subdir", &policy.UserSubdir{Prefix: prefix, Roles: rl, Exists: exists}, st)
+		}
+		policies = append(policies, prefixed[len(policies)](downloadPrefix))
+	}
+	if hideOutside {
+		prefixed[len(policies)] = func(prefix string) policy.Policy {
+			return &policy.PrefixFilter{Prefix: prefix, Roles: rl}
+		}
+		policies = append(policies, prefixed[len(policies)](downloadPrefix))
+	}
+	if rewriteSession {
+		prefixed[len(policies)] = func(prefix string) policy.Policy {
+			return &policy.SessionDirs{Prefix: prefix, Roles: rl}
+		}
+		policies = append(policies, prefixed[len(policies)](downloadPrefix))
+	}
+	if getBoolEnv("VERIFY_SESSION_SET") {
+		policies = append(policies, &policy.SessionSetCheck{Upstream: uc, Report: getBoolEnv("VERIFY_SESSION_SET_REPORT")})
+	}
+
+	var reconciler *ownership.Reconciler
+	var store ownership.Store
+	if ownershipDB != "" {
+		if authenticate == nil {
+			slog.Error("OWNERSHIP_DB requires authentication to be configured")
+			os.Exit(1)
+		}
+
+		unknown := getEnvOrDefault("OWNERSHIP_UNKNOWN", ownership.UnknownAdmin)
+		if unknown != ownership.UnknownAdmin && unknown != ownership.UnknownShared {
+			slog.Error("OWNERSHIP_UNKNOWN must be either " + ownership.UnknownAdmin + " or " + ownership.UnknownShared)
+			os.Exit(1)
+		}
+
+		if store, err = ownership.Open(ownershipDB); err != nil {
+			slog.Error("failed to open OWNERSHIP_DB: "+err.Error(), logger.IgnoredAttr(err))
+			os.Exit(1)
+		}
+
+		policies = append(policies, em.policy("ownership", &policy.Ownership{Store: store, Unknown: unknown, Roles: rl, Upstream: uc}, st))
+
+		fields := append(slices.Clone(quota.Fields), userstats.Fields...)
+		slices.Sort(fields)
+		torrents := &upstream.TorrentList{
+			Client: uc,
+			Fields: slices.Compact(fields),
+			TTL:    getDurationEnv("TORRENT_CACHE_TTL", 30*time.Second),
+		}
+		calc := &quota.Calculator{Store: store, Torrents: torrents}
+		policies = append(policies, em.policy("quota", &policy.Quota{Roles: rl, Calc: calc}, st))
+		http.Handle("/proxy/quota", auth(quotaReport(rr, rl, calc), true))
+		http.Handle("/proxy/stats/me", auth(userStats(rr, rl, store, torrents), true))
+
+		reconciler = &ownership.Reconciler{
+			Store:            store,
+			Upstream:         uc,
+			Interval:         getDurationEnv("OWNERSHIP_RECONCILE_INTERVAL", 10*time.Minute),
+			OwnerLabelPrefix: ownerPrefix,
+			Prefixes:         map[string]string{},
+		}
+		if reconciler.Interval < minReconcileInterval {
+			slog.Error("OWNERSHIP_RECONCILE_INTERVAL must be at least " + minReconcileInterval.String())
+			os.Exit(1)
+		}
+		if keys != nil {
+			for _, k := range keys.Keys {
+				if k.DownloadPrefix != "" {
+					reconciler.Prefixes[k.DownloadPrefix] = k.Name
+				}
+			}
+		}
+
+		go reconciler.Run(context.Background())
+	}
+
+	var al *audit.Log
+	if auditLogFile != "" {
+		if al, err = audit.Open(auditLogFile); err != nil {
+			slog.Error("failed to open AUDIT_LOG_FILE: "+err.Error(), logger.IgnoredAttr(err))
+			os.Exit(1)
+		}
+		reopenAuditOnSignal(al)
+	}
+
+	var col *exporter.Collector
+	if getBoolEnv("UPSTREAM_METRICS") {
+		col = &exporter.Collector{
+			Upstream: uc,
+			Interval: getDurationEnv("UPSTREAM_METRICS_INTERVAL", 30*time.Second),
+			Path:     commonPrefix(downloadPrefix),
+			PerLabel: getBoolEnv("UPSTREAM_METRICS_LABELS"),
+		}
+		go col.Run(context.Background())
+	}
+
+	if scheduleFile != "" {
+		startScheduler(uc, al)
+	}
+	if janitorConfig != "" {
+		startJanitor(uc, al)
+	}
+	if completionWebhookURL != "" {
+		startCompletionWatcher(uc, store)
+	}
+
+	bus := events.NewBus()
+
+	var cw *capture.Writer
+	if captureDir != "" {
+		cw = openCapture()
+	}
+
+	var fi *fault.Injector
+	if faultInjection != "" {
+		fi = openFaultInjector()
+	}
+
+	var mr *mirror.Mirror
+	if mirrorUpstreamHost != "" {
+		mr = startMirror(st)
+	}
+
+	dr := newDrainMode()
+
+	p := transmissionproxy.Forward(transmissionproxy.ForwardConfig{
+		Upstream:        gw,
+		Client:          &http.Client{Transport: ut},
+		Username:        upstreamUser,
+		Password:        upstreamPassword,
+		Session:         uc.Session,
+		Pool:            pool,
+		BalanceReads:    upstreamBalanceReads,
+		CollapseSlashes: upstreamCollapseSlashes,
+		Resolver:        ipResolver,
+		Stats:           st,
+		Responder:       rr,
+		ErrorLogWindow:  upstreamErrorLogWindow,
+	})
+	var web http.Handler = p
+	if publicPrefix != "" {
+		web = rewriteBasePath(publicPrefix+"/", web)
+	}
+	if header, policy := contentSecurityPolicy(); header != "" {
+		http.Handle(webPath, response.WithHTMLErrors(auth(withCSP(header, policy, web), true)))
+		if cspReportOnly {
+			http.Handle(cspReportPath, cspReport())
+		}
+	} else {
+		http.Handle(webPath, response.WithHTMLErrors(auth(web, true)))
+	}
+
+	// every RPC path has its own validator and policies, which differ from the primary ones
+	// by the download prefix and method restrictions configured for the path
+	cl := clientLimitFromEnv()
+
+	bl := rpcBodyLogFromEnv()
+
+	var rpcGateway http.Handler = p
+	if c := rpcCacheFromEnv(); c != nil || coalesceRPC {
+		var g *rpccache.Group
+		if coalesceRPC {
+			g = &rpccache.Group{}
+		}
+		rpcGateway = sharedRPC(p, c, g, rr)
+	}
+
+	proxyCfg := rpcProxyConfig{
+		shadowValidation: em[groupValidation],
+		audit:            al,
+		events:           bus,
+		mirror:           mr,
+		faults:           fi,
+		capture:          cw,
+		drain:            dr,
+		clientLimit:      cl,
+		responder:        rr,
+		stats:            st,
+		rejectedBodies:   bc,
+		bodyLog:          bl,
+	}
+	var rc *rpcCaller
+	for _, path := range rpcPaths {
+		prefix, ps := downloadPrefix, policies
+		var pre []transmission.ValidationHook
+		pc := pathConfigs[path]
+		if pc != nil {
+			pre = append(pre, pc.methodACL(path))
+			if pc.DownloadPrefix != "" {
+				prefix = pc.DownloadPrefix
+			}
+		}
+		if prefix != downloadPrefix && len(prefixed) > 0 {
+			ps = slices.Clone(policies)
+			for i, build := range prefixed {
+				ps[i] = build(prefix)
+			}
+		}
+
+		pcfg := proxyCfg
+		pcfg.validator, pcfg.policies = validatorFor(prefix, pre...), ps
+		var rpc http.Handler = rpcProxy(rpcGateway, pcfg)
+		if batchRequests {
+			rpc = batchRPC(rr, batchLimitsFromEnv(), rpc)
+		}
+		if authenticate != nil {
+			rpc = impersonate(rr, rl, keys, exists, rpc)
+		}
+		rpc = rpcPathHandler(rr, path, pc.limiter(), rpc)
+		http.Handle(path, withCORS(auth(rpc, false)))
+		if rc == nil {
+			rc = &rpcCaller{rpc: rpc}
+		}
+	}
+	if restAPI {
+		http.Handle(restPrefix, auth(restHandler(rr, rc), false))
+	}
+	http.Handle("/proxy/upload", auth(upload(rr, rc), false))
+	http.Handle("/proxy/add-magnet", auth(addMagnet(rr, rc, getListEnv("MAGNET_TRACKER_ALLOWLIST", "")), true))
+	http.Handle(healthPath, healthz())
+	http.Handle(readyPath, readyz(rr, dr, &upstreamCheck{uc: uc, pool: pool}))
+	http.Handle("/proxy/version", version(&upstreamVersion{uc: uc}))
+	http.Handle("/proxy/events", auth(eventStream(rr, rl, bus), true))
+	var metricsSrv *http.Server
+	switch {
+	case metricsPath == "none":
+	case !strings.HasPrefix(metricsPath, "/"):
+		slog.Error("METRICS_PATH must begin with /")
 		os.Exit(1)
+	case metricsListen != "":
+		// served on its own listener, so that it is not exposed to the users of the proxy
+		mux := http.NewServeMux()
+		mux.Handle(metricsPath, metrics(st, col))
+		metricsSrv = &http.Server{Addr: metricsListen, Handler: mux}
+		go func() {
+			if err := metricsSrv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+				slog.Error("metrics listener failed: "+err.Error(), logger.IgnoredAttr(err))
+				os.Exit(1)
+			}
+		}()
+	default:
+		http.Handle(metricsPath, metrics(st, col))
 	}
+	// recent rejections list client IPs and, in debug mode, request bodies
+	http.Handle("/proxy/status", adminOnly(rr, status(st, reconciler, dr)))
+	http.Handle("/proxy/log-level", adminOnly(rr, logLevel(rr)))
+	http.Handle("/proxy/lockouts", adminOnly(rr, lockouts(guard)))
+	http.Handle("/proxy/capture", adminOnly(rr, captureControl(rr, cw)))
+	http.Handle("/proxy/faults", adminOnly(rr, faultRules(rr, fi)))
+	http.Handle("/proxy/drain", adminOnly(rr, drainControl(rr, dr, al, true)))
+	http.Handle("/proxy/undrain", adminOnly(rr, drainControl(rr, dr, al, false)))
 
-	v := transmission.DefaultMethodsValidator(downloadPrefix)
+	cycleLogLevelOnSignal()
+	http.Handle("/", response.WithHTMLErrors(auth(transmissionproxy.HomePage(web), true)))
 
-	rr := &response.Responder{DebugMode: debugMode}
+	var handler http.Handler = http.DefaultServeMux
+	if publicPrefix != "" {
+		handler = withBasePath(handler)
+	}
+	handler = withRequestID(handler)
+	handler = ipResolver.Middleware(handler)
+	handler = withVersionHeader(handler)
 
-	p := proxy(gw, rr)
-	http.Handle(webPath, p)
-	http.Handle(rpcPath, rpcProxy(p, v, rr))
-	http.Handle("/", homePage(p))
+	srv := &http.Server{Addr: ":8080", Handler: handler, TLSConfig: serverTLSConfig()}
+	// event streams never complete on their own
+	srv.RegisterOnShutdown(bus.Close)
 
-	err = http.ListenAndServe(":8080", nil)
+	if err = serve(srv); err != nil {
+		slog.Error("aborting: "+err.Error(), logger.IgnoredAttr(err))
+		os.Exit(1)
+	}
 
-	slog.Error("aborting: "+err.Error(), logger.IgnoredAttr(err))
-	os.Exit(1)
+	if metricsSrv != nil {
+		closeOnShutdown("METRICS_LISTEN", metricsSrv.Close)
+	}
+	if al != nil {
+		closeOnShutdown("AUDIT_LOG_FILE", al.Close)
+	}
+	if store != nil {
+		closeOnShutdown("OWNERSHIP_DB", store.Close)
+	}
+	slog.Info("shutdown complete")
 }
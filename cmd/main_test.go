@@ -0,0 +1,509 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"transmission-proxy/internal/audit"
+	"transmission-proxy/internal/authz"
+	"transmission-proxy/internal/events"
+	"transmission-proxy/internal/jrpc"
+	"transmission-proxy/internal/logger"
+	"transmission-proxy/internal/policy"
+	"transmission-proxy/internal/redact"
+	"transmission-proxy/internal/reqctx"
+	"transmission-proxy/internal/response"
+	"transmission-proxy/internal/stats"
+	"transmission-proxy/internal/transmission"
+	"transmission-proxy/transmissionproxy"
+)
+
+// rejectConfig is the configuration of rpcProxy needed to record rejections.
+func rejectConfig(al *audit.Log) *rpcProxyConfig {
+	return &rpcProxyConfig{audit: al, events: events.NewBus(), responder: &response.Responder{}, stats: stats.NewRegistry()}
+}
+
+func TestRejectAudited(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	al, err := audit.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = al.Close() }()
+
+	rejectRequest := func(method string, err error) {
+		req := &jrpc.Request{Method: method, Tag: 7, Arguments: map[string]any{"ids": []any{1}, "location": "/srv"}}
+		r := httptest.NewRequest(http.MethodPost, "/transmission/rpc", nil)
+		r = r.WithContext(reqctx.WithUser(r.Context(), "alice"))
+		rejectConfig(al).rejected(r, req, err, transmission.RejectReason(err), http.StatusForbidden, &stats.Rejection{Reason: err.Error()})
+	}
+	rejectRequest("torrent-get", &authz.Denied{Reason: "not now"})
+	rejectRequest("torrent-set-location", &authz.Denied{Reason: "quiet hours"})
+
+	bs, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(bs)), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("got audit log %q, want only the mutating request", bs)
+	}
+	var rec audit.Record
+	if err := json.Unmarshal([]byte(lines[0]), &rec); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Method != "torrent-set-location" || rec.User != "alice" || rec.Tag != 7 || rec.Status != http.StatusForbidden ||
+		rec.Arguments["location"] != "/srv" {
+		t.Errorf("got record %+v", rec)
+	}
+	if !strings.Contains(rec.Result, "quiet hours") {
+		t.Errorf("got result %q, want the reason of the denial", rec.Result)
+	}
+}
+
+func TestRejectAuditedPolicy(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	al, err := audit.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = al.Close() }()
+
+	req := &jrpc.Request{Method: "torrent-set", Arguments: map[string]any{"ids": []any{1}, "group": "fast"}}
+	err = &policy.Violation{Policy: "groups", Reason: "group fast is not allowed"}
+	r := httptest.NewRequest(http.MethodPost, "/transmission/rpc", nil)
+	rejectConfig(al).rejected(r, req, err, transmission.RejectPolicy, http.StatusForbidden, &stats.Rejection{Reason: err.Error()})
+
+	bs, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rec audit.Record
+	if err := json.Unmarshal(bs, &rec); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Policy != "groups" || rec.Result != "group fast is not allowed" {
+		t.Errorf("got record %+v", rec)
+	}
+}
+
+// captureLog makes the default logger write records of all levels as JSON to the returned buffer for the duration
+// of the test, expanding context and error attributes as in production.
+func captureLog(t *testing.T) *bytes.Buffer {
+	var buf bytes.Buffer
+	prev := slog.Default()
+	slog.SetDefault(slog.New(logger.NewHandler(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}), "/")))
+	t.Cleanup(func() { slog.SetDefault(prev) })
+
+	return &buf
+}
+
+// logRecord returns the first logged record with the message containing msg.
+func logRecord(t *testing.T, logs *bytes.Buffer, msg string) map[string]any {
+	t.Helper()
+
+	for _, line := range strings.Split(strings.TrimSpace(logs.String()), "\n") {
+		var rec map[string]any
+		if err := json.Unmarshal([]byte(line), &rec); err != nil {
+			t.Fatalf("bad log record %q: %v", line, err)
+		}
+		if m, _ := rec["msg"].(string); strings.Contains(m, msg) {
+			return rec
+		}
+	}
+
+	t.Fatalf("no %q record in log:\n%s", msg, logs)
+	return nil
+}
+
+// upstreamFunc is the upstream of the tests, answering requests in memory.
+type upstreamFunc func(*http.Request) (*http.Response, error)
+
+func (f upstreamFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+// upstreamStatus answers every request with the status and body.
+func upstreamStatus(status int, body string) upstreamFunc {
+	return func(*http.Request) (*http.Response, error) {
+		w := httptest.NewRecorder()
+		w.WriteHeader(status)
+		_, _ = w.WriteString(body)
+		return w.Result(), nil
+	}
+}
+
+// testRPCProxy returns rpcProxy with the default validator forwarding to the upstream, its configuration
+// adjusted by the configure functions.
+func testRPCProxy(upstream http.RoundTripper, configure ...func(*rpcProxyConfig)) http.HandlerFunc {
+	u, _ := url.Parse("http://transmission:9091/")
+	gw := transmissionproxy.Forward(transmissionproxy.ForwardConfig{Upstream: u, Client: &http.Client{Transport: upstream}})
+
+	cfg := rpcProxyConfig{
+		validator: buildValidator("/downloads/"),
+		events:    events.NewBus(),
+		drain:     &drainMode{},
+		responder: &response.Responder{},
+		stats:     stats.NewRegistry(),
+	}
+	for _, f := range configure {
+		f(&cfg)
+	}
+
+	return rpcProxy(gw, cfg)
+}
+
+func postRPC(h http.Handler, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, rpcPath, strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+
+	return w
+}
+
+func TestRPCOutcomeAttrs(t *testing.T) {
+	cases := []struct {
+		name     string
+		upstream http.RoundTripper
+		body     string
+		msg      string
+		level    string
+		status   int
+		// upstreamStatus is zero if the request should not have reached the upstream
+		upstreamStatus int
+	}{
+		{name: "success", upstream: upstreamStatus(http.StatusOK, sessionGetResponse), body: sessionGet,
+			msg: "RPC request completed", level: "DEBUG", status: http.StatusOK, upstreamStatus: http.StatusOK},
+		{name: "rejection", upstream: upstreamStatus(http.StatusOK, torrentAddResponse),
+			body: `{"method":"torrent-add","arguments":{"filename":"a.torrent","download-dir":"/etc"},"tag":5}`,
+			msg:  "invalid RPC request", level: "WARN", status: http.StatusBadRequest},
+		{name: "upstream failure", upstream: upstreamStatus(http.StatusInternalServerError, "oops"), body: sessionGet,
+			msg: "RPC request completed", level: "ERROR", status: http.StatusInternalServerError, upstreamStatus: http.StatusInternalServerError},
+		{name: "upstream unreachable", body: sessionGet, msg: "upstream error", level: "ERROR", status: http.StatusBadGateway,
+			upstream: upstreamFunc(func(*http.Request) (*http.Response, error) { return nil, errors.New("connection refused") })},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			logs := captureLog(t)
+			w := postRPC(testRPCProxy(tc.upstream), tc.body)
+			if w.Code != tc.status {
+				t.Fatalf("got status %d, want %d", w.Code, tc.status)
+			}
+
+			rec := logRecord(t, logs, tc.msg)
+			if rec["level"] != tc.level {
+				t.Errorf("got level %v, want %s", rec["level"], tc.level)
+			}
+			attrs, _ := rec[logger.GroupHTTP].(map[string]any)
+			if _, ok := attrs[logger.KeyDurationMs].(float64); !ok {
+				t.Errorf("no duration in %v", rec)
+			}
+			if attrs[logger.KeyStatus] != float64(tc.status) {
+				t.Errorf("got status %v in %v", attrs[logger.KeyStatus], rec)
+			}
+			if tc.status == http.StatusOK && attrs[logger.KeyBytesOut] != float64(w.Body.Len()) {
+				t.Errorf("got bytes out %v, want %d", attrs[logger.KeyBytesOut], w.Body.Len())
+			}
+			if got, _ := attrs[logger.KeyUpstreamStatus].(float64); int(got) != tc.upstreamStatus {
+				t.Errorf("got upstream status %v, want %d", attrs[logger.KeyUpstreamStatus], tc.upstreamStatus)
+			}
+		})
+	}
+}
+
+func TestRejectedBodyCapture(t *testing.T) {
+	st := stats.NewRegistry()
+	h := testRPCProxy(upstreamStatus(http.StatusOK, torrentAddResponse), func(cfg *rpcProxyConfig) {
+		cfg.stats = st
+		cfg.rejectedBodies = &bodyCapture{maxBytes: 100, redactor: redact.New(redact.DefaultFields)}
+	})
+	logs := captureLog(t)
+
+	if w := postRPC(h, torrentAdd); w.Code != http.StatusOK {
+		t.Fatalf("got status %d", w.Code)
+	}
+	if strings.Contains(logs.String(), logger.KeyRejectedBody) || len(st.RecentRejections()) != 0 {
+		t.Errorf("body of accepted request captured:\n%s", logs)
+	}
+
+	body := `{"method":"torrent-add","arguments":{"cookies":"session=secret","download-dir":"/etc","filename":"` +
+		strings.Repeat("x", 200) + `"}}`
+	if w := postRPC(h, body); w.Code != http.StatusBadRequest {
+		t.Fatalf("got status %d", w.Code)
+	}
+
+	rejections := st.RecentRejections()
+	if len(rejections) != 1 {
+		t.Fatalf("got rejections %v", rejections)
+	}
+	captured := rejections[0].Body
+	if strings.Contains(captured, "secret") || !strings.Contains(captured, "[redacted, 16 bytes]") {
+		t.Errorf("captured body not redacted: %s", captured)
+	}
+	if i := strings.Index(captured, "...[truncated, "); i != 100 {
+		t.Errorf("captured body not truncated: %s", captured)
+	}
+
+	rec := logRecord(t, logs, "invalid RPC request")
+	if attrs, _ := rec[logger.GroupRPC].(map[string]any); attrs[logger.KeyRejectedBody] != captured {
+		t.Errorf("got record %v, want the captured body", rec)
+	}
+}
+
+// recordingUpstream answers with the body and remembers the bodies of the requests it got.
+func recordingUpstream(body string, got *[]string) upstreamFunc {
+	return func(r *http.Request) (*http.Response, error) {
+		bs, err := io.ReadAll(r.Body)
+		if err != nil {
+			return nil, err
+		}
+		*got = append(*got, string(bs))
+
+		return upstreamStatus(http.StatusOK, body)(r)
+	}
+}
+
+func TestForwardSanitized(t *testing.T) {
+	var forwarded []string
+	h := testRPCProxy(recordingUpstream(torrentGetPollResponse, &forwarded))
+	captureLog(t)
+
+	// requests left as they are go byte for byte
+	unchanged := `{ "method" : "torrent-get", "arguments": {"fields": ["id"], "ids": "recently-active"}, "tag": 3 }`
+	postRPC(h, unchanged)
+	postRPC(h, `{"method":"torrent-get","arguments":{"fields":["id"],"ids":[1],"script":"rm -rf /"},"tag":4}`)
+
+	if len(forwarded) != 2 {
+		t.Fatalf("got forwarded %q", forwarded)
+	}
+	if forwarded[0] != unchanged {
+		t.Errorf("unchanged request forwarded as %s", forwarded[0])
+	}
+
+	var got jrpc.Request
+	if err := json.Unmarshal([]byte(forwarded[1]), &got); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := got.Arguments["script"]; ok || got.Tag != 4 || len(got.Arguments) != 2 {
+		t.Errorf("got forwarded request %s, want it sanitized", forwarded[1])
+	}
+}
+
+func TestForwardSanitizedKeepsMembers(t *testing.T) {
+	var forwarded []string
+	h := testRPCProxy(recordingUpstream(torrentGetPollResponse, &forwarded))
+	captureLog(t)
+
+	// the request has to be serialized again without the dropped argument
+	postRPC(h, `{"tag":0,"method":"torrent-get","format":"table","arguments":{"fields":["id"],"script":"rm"},"x-client":{"v":[1,2.50]}}`)
+	if len(forwarded) != 1 {
+		t.Fatalf("got forwarded %q", forwarded)
+	}
+	if want := `{"method":"torrent-get","arguments":{"fields":["id"]},"tag":0,"format":"table","x-client":{"v":[1,2.50]}}`; forwarded[0] != want {
+		t.Errorf("got forwarded %s, want %s", forwarded[0], want)
+	}
+}
+
+func TestForwardSanitizedKeepsNumbers(t *testing.T) {
+	var forwarded []string
+	h := testRPCProxy(recordingUpstream(`{"arguments":{},"result":"success"}`, &forwarded))
+	captureLog(t)
+
+	args := `"downloadLimit":9223372036854775807,"files-wanted":[0,1,9007199254740993],"ids":[1],"seedRatioLimit":1.10`
+	if w := postRPC(h, `{"method":"torrent-set","arguments":{`+args+`,"script":"rm"},"tag":1}`); w.Code != http.StatusOK {
+		t.Fatalf("got status %d, body %s", w.Code, w.Body)
+	}
+	if _, got := lastRPC(t, forwarded); got != `{`+args+`}` {
+		t.Errorf("got forwarded arguments %s", got)
+	}
+}
+
+func TestRPCBodyTooLarge(t *testing.T) {
+	defer func(n int64) { maxRPCBodyBytes = n }(maxRPCBodyBytes)
+	maxRPCBodyBytes = 100
+
+	var forwarded []string
+	st := stats.NewRegistry()
+	h := testRPCProxy(recordingUpstream(sessionGetResponse, &forwarded), func(cfg *rpcProxyConfig) {
+		cfg.responder = &response.Responder{DebugMode: true}
+		cfg.stats = st
+	})
+	logs := captureLog(t)
+
+	metainfo := strings.Repeat("A", 200)
+	// the tag is found if it comes before the limit, zero tag is omitted as for other errors
+	cases := []struct {
+		body string
+		tag  any
+	}{
+		{body: `{"tag":9,"method":"torrent-add","arguments":{"metainfo":"` + metainfo + `"}}`, tag: float64(9)},
+		{body: `{"method":"torrent-add","arguments":{"metainfo":"` + metainfo + `"},"tag":9}`},
+	}
+	for _, tc := range cases {
+		w := postRPC(h, tc.body)
+		if w.Code != http.StatusRequestEntityTooLarge {
+			t.Fatalf("got status %d, body %s", w.Code, w.Body)
+		}
+		var res map[string]any
+		if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+			t.Fatalf("got body %s: %v", w.Body, err)
+		}
+		if msg, _ := res["result"].(string); !strings.Contains(msg, "request body exceeds 100 bytes") || res["tag"] != tc.tag {
+			t.Errorf("got body %s, want tag %v", w.Body, tc.tag)
+		}
+	}
+
+	if len(forwarded) != 0 {
+		t.Errorf("forwarded %q", forwarded)
+	}
+	rec := logRecord(t, logs, "invalid RPC request")
+	if attrs, _ := rec[logger.GroupRPC].(map[string]any); attrs[logger.KeyRejectReason] != transmissionproxy.RejectTooLarge {
+		t.Errorf("got record %v", rec)
+	}
+
+	// requests within the limit go through
+	if w := postRPC(h, sessionGet); w.Code != http.StatusOK || len(forwarded) != 1 {
+		t.Errorf("small request: got status %d, body %s", w.Code, w.Body)
+	}
+}
+
+func TestRPCMalformed(t *testing.T) {
+	var forwarded []string
+	// the problems of the body are told to the clients outside of debug mode too
+	h := testRPCProxy(recordingUpstream(sessionGetResponse, &forwarded))
+	logs := captureLog(t)
+
+	cases := []struct {
+		name, body, message string
+		tag                 any
+	}{
+		{name: "empty", body: "", message: "request body is empty"},
+		{name: "not JSON", body: "method=session-get", message: "request body is not valid JSON"},
+		{name: "arguments array", body: `{"method":"torrent-get","arguments":[1,2,3],"tag":4}`, message: "arguments must be a JSON object", tag: float64(4)},
+		{name: "arguments string", body: `{"tag":5,"method":"torrent-get","arguments":"oops"}`, message: "arguments must be a JSON object", tag: float64(5)},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			logs.Reset()
+			w := postRPC(h, tc.body)
+			if w.Code != http.StatusBadRequest {
+				t.Fatalf("got status %d, body %s", w.Code, w.Body)
+			}
+			var res map[string]any
+			if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+				t.Fatalf("got body %s: %v", w.Body, err)
+			}
+			if msg, _ := res["result"].(string); !strings.HasPrefix(msg, strings.ToUpper(tc.message[:1])+tc.message[1:]) || res["tag"] != tc.tag {
+				t.Errorf("got body %s, want %q with tag %v", w.Body, tc.message, tc.tag)
+			}
+
+			rec := logRecord(t, logs, "invalid RPC request")
+			if attrs, _ := rec[logger.GroupRPC].(map[string]any); attrs[logger.KeyRejectReason] != transmissionproxy.RejectMalformed || rec["level"] != "WARN" {
+				t.Errorf("got record %v", rec)
+			}
+		})
+	}
+
+	if len(forwarded) != 0 {
+		t.Errorf("forwarded %q", forwarded)
+	}
+}
+
+func TestDenyMethodsConfig(t *testing.T) {
+	defer func(deny []string, ro bool) { denyMethods, readOnly = deny, ro }(denyMethods, readOnly)
+
+	cases := []struct {
+		name    string
+		deny    []string
+		ro      bool
+		allowed []string
+		denied  []string
+	}{
+		{name: "deny list", deny: []string{"session-set", "blocklist-update"},
+			allowed: []string{"session-get", "torrent-stop"}, denied: []string{"session-set", "blocklist-update"}},
+		{name: "read only", ro: true,
+			allowed: []string{"torrent-get", "session-get", "session-stats", "free-space", "port-test"},
+			denied:  []string{"torrent-add", "torrent-remove", "session-set", "torrent-stop"}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			denyMethods, readOnly = tc.deny, tc.ro
+			var forwarded []string
+			h := testRPCProxy(recordingUpstream(sessionGetResponse, &forwarded), func(cfg *rpcProxyConfig) {
+				cfg.validator = buildValidator("/downloads/")
+				cfg.responder = &response.Responder{DebugMode: true}
+			})
+
+			for _, method := range tc.denied {
+				logs := captureLog(t)
+				w := postRPC(h, `{"method":"`+method+`","tag":4}`)
+				if w.Code != http.StatusBadRequest || !strings.Contains(strings.ToLower(w.Body.String()), "method not allowed by proxy policy") {
+					t.Errorf("%s: got status %d, body %s", method, w.Code, w.Body)
+				}
+				rec := logRecord(t, logs, "invalid RPC request")
+				if attrs, _ := rec[logger.GroupRPC].(map[string]any); attrs[logger.KeyRejectReason] != transmission.RejectMethodDenied ||
+					attrs[logger.KeyMethod] != method {
+					t.Errorf("%s: got record %v", method, rec)
+				}
+			}
+			if len(forwarded) != 0 {
+				t.Errorf("denied methods forwarded: %q", forwarded)
+			}
+
+			for _, method := range tc.allowed {
+				if w := postRPC(h, `{"method":"`+method+`","arguments":{"ids":[1],"fields":["id"],"path":"/downloads/"}}`); w.Code != http.StatusOK {
+					t.Errorf("%s: got status %d, body %s", method, w.Code, w.Body)
+				}
+			}
+		})
+	}
+}
+
+func TestForceDownloadDir(t *testing.T) {
+	defer func(force bool) { forceDownloadDir = force }(forceDownloadDir)
+	forceDownloadDir = true
+	captureLog(t)
+
+	var forwarded []string
+	h := testRPCProxy(recordingUpstream(torrentAddResponse, &forwarded), func(cfg *rpcProxyConfig) {
+		cfg.validator = buildValidator("/downloads/")
+	})
+
+	// the injected directory survives serialization of the sanitized request
+	if w := postRPC(h, `{"method":"torrent-add","arguments":{"filename":"magnet:?xt=urn:btih:a","paused":true},"tag":2}`); w.Code != http.StatusOK {
+		t.Fatalf("got status %d", w.Code)
+	}
+	if method, args := lastRPC(t, forwarded); method != "torrent-add" ||
+		args != `{"download-dir":"/downloads/","filename":"magnet:?xt=urn:btih:a","paused":true}` {
+		t.Errorf("without download-dir: forwarded %s %s", method, args)
+	}
+
+	// explicit valid directory is forwarded as sent
+	explicit := `{"method":"torrent-add","arguments":{"filename":"magnet:?xt=urn:btih:a","download-dir":"/downloads/tv"},"tag":3}`
+	postRPC(h, explicit)
+	if forwarded[len(forwarded)-1] != explicit {
+		t.Errorf("with download-dir: forwarded %s", forwarded[len(forwarded)-1])
+	}
+
+	// admins with the root prefix keep the default of Transmission
+	forwarded = nil
+	h = testRPCProxy(recordingUpstream(torrentAddResponse, &forwarded), func(cfg *rpcProxyConfig) {
+		cfg.validator = buildValidator("/")
+	})
+	postRPC(h, `{"method":"torrent-add","arguments":{"filename":"magnet:?xt=urn:btih:a"}}`)
+	if _, args := lastRPC(t, forwarded); args != `{"filename":"magnet:?xt=urn:btih:a"}` {
+		t.Errorf("root prefix: forwarded %s", args)
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"transmission-proxy/internal/logger"
+	"transmission-proxy/internal/mirror"
+	"transmission-proxy/internal/stats"
+	"transmission-proxy/internal/upstream"
+)
+
+var mirrorUpstreamHost = os.Getenv("MIRROR_UPSTREAM_HOST")
+
+// startMirror starts mirroring sampled requests to MIRROR_UPSTREAM_HOST.
+func startMirror(st *stats.Registry) *mirror.Mirror {
+	host := mirrorUpstreamHost
+	if !strings.HasSuffix(host, "/") {
+		host += "/"
+	}
+	u, err := url.Parse(host)
+	if err != nil {
+		slog.Error("failed to parse MIRROR_UPSTREAM_HOST: "+err.Error(), logger.IgnoredAttr(err))
+		os.Exit(1)
+	}
+	if u.Path != "/" || u.RawQuery != "" || u.Fragment != "" {
+		slog.Error("MIRROR_UPSTREAM_HOST must not define path or query")
+		os.Exit(1)
+	}
+
+	rate, err := strconv.ParseFloat(getEnvOrDefault("MIRROR_SAMPLE_RATE", "1"), 64)
+	if err != nil || rate < 0 || rate > 1 {
+		slog.Error("MIRROR_SAMPLE_RATE must be a number between 0 and 1")
+		os.Exit(1)
+	}
+
+	queueSize, err := strconv.Atoi(getEnvOrDefault("MIRROR_QUEUE_SIZE", "100"))
+	if err != nil || queueSize <= 0 {
+		slog.Error("MIRROR_QUEUE_SIZE must be a positive number")
+		os.Exit(1)
+	}
+
+	uc := &upstream.Client{
+		URL:  u.JoinPath(rpcPath).String(),
+		HTTP: &http.Client{Timeout: getDurationEnv("MIRROR_TIMEOUT", 10*time.Second)},
+	}
+	m := mirror.New(uc, rate, getBoolEnv("MIRROR_ALL_METHODS"), queueSize, st)
+
+	slog.Info(fmt.Sprintf("mirroring %g of requests to %s", rate, u.Host))
+	go m.Run(context.Background())
+
+	return m
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"transmission-proxy/internal/mirror"
+	"transmission-proxy/internal/stats"
+	"transmission-proxy/internal/upstream"
+)
+
+func TestMirrorIsolation(t *testing.T) {
+	// the mirror hangs until the test ends
+	release := make(chan struct{})
+	var mirrored atomic.Int32
+	hanging := upstreamFunc(func(r *http.Request) (*http.Response, error) {
+		mirrored.Add(1)
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+		return nil, r.Context().Err()
+	})
+
+	st := stats.NewRegistry()
+	m := mirror.New(&upstream.Client{URL: "http://transmission4:9091/transmission/rpc", HTTP: &http.Client{Transport: hanging}},
+		1, false, 2, st)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	defer close(release)
+	go m.Run(ctx)
+
+	var forwarded []string
+	h := testRPCProxy(recordingUpstream(`{"arguments":{"torrents":[]},"result":"success"}`, &forwarded), func(cfg *rpcProxyConfig) {
+		cfg.mirror = m
+		cfg.stats = st
+	})
+
+	start := time.Now()
+	for i := 0; i < 20; i++ {
+		if w := postRPC(h, `{"method":"torrent-get","arguments":{"fields":["id"]}}`); w.Code != http.StatusOK {
+			t.Fatalf("got status %d, body %s", w.Code, w.Body)
+		}
+	}
+	if d := time.Since(start); d > 2*time.Second {
+		t.Errorf("primary requests took %s with the mirror hanging", d)
+	}
+	if len(forwarded) != 20 {
+		t.Errorf("got %d requests forwarded to the primary upstream, want 20", len(forwarded))
+	}
+
+	// up to four workers are stuck and two requests wait in the queue, the rest are dropped
+	if n := st.MirrorDrops(); n < 14 || n > 18 {
+		t.Errorf("got %d mirror drops, want 14 to 18", n)
+	}
+	for deadline := time.Now().Add(5 * time.Second); mirrored.Load() < 2; time.Sleep(5 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("requests not sent to the mirror")
+		}
+	}
+}
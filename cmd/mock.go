@@ -0,0 +1,42 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+
+	"transmission-proxy/internal/logger"
+	"transmission-proxy/internal/mock"
+)
+
+// mockUpstreamCommand implements `transmission-proxy mock-upstream [--listen=:9091] [--latency=0s] [--state=file]`:
+// it serves an in-memory fake of Transmission RPC for testing the proxy and clients without a real daemon.
+// Returns process exit code.
+func mockUpstreamCommand(args []string) int {
+	fs := flag.NewFlagSet("mock-upstream", flag.ContinueOnError)
+	listen := fs.String("listen", ":9091", "address to listen on")
+	latency := fs.Duration("latency", 0, "delay of every response")
+	statePath := fs.String("state", "", "JSON file to keep the state in across restarts")
+	sessionID := fs.String("session-id", "mock-session-id", "session id clients must negotiate")
+	rpcPath := fs.String("rpc-path", "/transmission/rpc", "path of the RPC endpoint")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	srv, err := mock.New(*sessionID, *latency, *statePath)
+	if err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, err.Error())
+		return 2
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(*rpcPath, srv)
+
+	slog.Info("mock upstream listening on " + *listen + *rpcPath)
+	err = http.ListenAndServe(*listen, mux)
+
+	slog.Error("aborting: "+err.Error(), logger.IgnoredAttr(err))
+	return 1
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"transmission-proxy/internal/events"
+	"transmission-proxy/internal/jrpc"
+	"transmission-proxy/internal/mock"
+	"transmission-proxy/internal/response"
+	"transmission-proxy/internal/stats"
+	"transmission-proxy/internal/upstream"
+	"transmission-proxy/transmissionproxy"
+)
+
+// TestMockEndToEnd boots the mock upstream, points the proxy at it and goes through a client session:
+// the session id handshake, adding torrents, listing and removing them.
+func TestMockEndToEnd(t *testing.T) {
+	m, err := mock.New("mock-session-id", 0, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle(rpcPath, m)
+	upstreamSrv := httptest.NewServer(mux)
+	defer upstreamSrv.Close()
+
+	u, _ := url.Parse(upstreamSrv.URL + "/")
+	gw := transmissionproxy.Forward(transmissionproxy.ForwardConfig{Upstream: u, Client: upstreamSrv.Client()})
+	proxySrv := httptest.NewServer(rpcProxy(gw, rpcProxyConfig{
+		validator: buildValidator("/downloads/"),
+		events:    events.NewBus(),
+		drain:     &drainMode{},
+		responder: &response.Responder{DebugMode: true},
+		stats:     stats.NewRegistry(),
+	}))
+	defer proxySrv.Close()
+
+	client := &upstream.Client{URL: proxySrv.URL + rpcPath, HTTP: proxySrv.Client()}
+	rpc := func(method string, args map[string]any) (*jrpc.Response, error) {
+		return client.Call(context.Background(), nil, &jrpc.Request{Method: method, Arguments: args})
+	}
+
+	var hashes []string
+	for _, magnet := range []string{"magnet:?xt=urn:btih:aaaa", "magnet:?xt=urn:btih:bbbb"} {
+		res, err := rpc("torrent-add", map[string]any{"filename": magnet, "download-dir": "/downloads/movies"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		added, _ := res.Arguments["torrent-added"].(map[string]any)
+		hash, _ := added["hashString"].(string)
+		if hash == "" {
+			t.Fatalf("torrent-add: got arguments %v", res.Arguments)
+		}
+		hashes = append(hashes, hash)
+	}
+
+	// the proxy rejects what it would have rejected in front of a real daemon
+	if _, err := rpc("torrent-add", map[string]any{"filename": "magnet:?xt=urn:btih:cccc", "download-dir": "/etc"}); err == nil ||
+		!strings.Contains(err.Error(), "400") {
+		t.Errorf("torrent-add outside the prefix: got error %v", err)
+	}
+
+	list := func() []any {
+		t.Helper()
+		res, err := rpc("torrent-get", map[string]any{"fields": []any{"id", "hashString", "downloadDir"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		torrents, _ := res.Arguments["torrents"].([]any)
+		return torrents
+	}
+
+	torrents := list()
+	if len(torrents) != 2 {
+		t.Fatalf("got torrents %v, want 2", torrents)
+	}
+	for i, v := range torrents {
+		tr := v.(map[string]any)
+		if tr["hashString"] != hashes[i] || tr["downloadDir"] != "/downloads/movies" {
+			t.Errorf("got torrent %v", tr)
+		}
+	}
+
+	if _, err := rpc("torrent-remove", map[string]any{"ids": []any{hashes[0]}, "delete-local-data": true}); err != nil {
+		t.Fatal(err)
+	}
+	torrents = list()
+	if len(torrents) != 1 || torrents[0].(map[string]any)["hashString"] != hashes[1] {
+		t.Errorf("after removal: got torrents %v", torrents)
+	}
+}
+
+func TestMockUpstreamCommand(t *testing.T) {
+	bad := filepath.Join(t.TempDir(), "state.json")
+	if err := os.WriteFile(bad, []byte("{"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, args := range [][]string{{"--latency=soon"}, {"--state=" + bad}} {
+		if code := mockUpstreamCommand(args); code != 2 {
+			t.Errorf("%v: got exit code %d, want 2", args, code)
+		}
+	}
+}
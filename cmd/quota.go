@@ -0,0 +1,77 @@
+package main
+
+import (
+	_ "embed"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"transmission-proxy/internal/logger"
+	"transmission-proxy/internal/quota"
+	"transmission-proxy/internal/reqctx"
+	"transmission-proxy/internal/response"
+	"transmission-proxy/internal/roles"
+)
+
+//go:embed quota.html
+var quotaHTML string
+
+var quotaTemplate = template.Must(template.New("quota").Funcs(template.FuncMap{"bytes": formatBytes}).Parse(quotaHTML))
+
+// quotaReport reports limits and usage of the calling user, or of the user given in ?user= to admins,
+// as JSON or as HTML page for browsers.
+func quotaReport(rr *response.Responder, rl *roles.Roles, calc *quota.Calculator) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			rr.RespondAndLogCustom(w, r.Context(), fmt.Errorf("method %s is not allowed", r.Method), 0, slog.LevelWarn, http.StatusMethodNotAllowed)
+			return
+		}
+
+		user := reqctx.User(r.Context())
+		if user == "" {
+			rr.RespondAndLogCustom(w, r.Context(), fmt.Errorf("authentication required"), 0, slog.LevelWarn, http.StatusUnauthorized)
+			return
+		}
+
+		if u := r.URL.Query().Get("user"); u != "" && u != user {
+			if !rl.IsAdmin(user) {
+				rr.RespondAndLogCustom(w, r.Context(), fmt.Errorf("only admins may query quota of other users"), 0, slog.LevelWarn, http.StatusForbidden)
+				return
+			}
+			user = u
+		}
+
+		report, err := calc.Check(r.Context(), user, rl.User(user).Quota)
+		if err != nil {
+			rr.RespondAndLogCustom(w, r.Context(), fmt.Errorf("failed to compute quota usage: %w", err), 0, slog.LevelError, http.StatusBadGateway)
+			return
+		}
+
+		if !strings.Contains(r.Header.Get("Accept"), "text/html") {
+			writeJSON(w, r, http.StatusOK, report)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := quotaTemplate.Execute(w, report); err != nil {
+			slog.ErrorContext(r.Context(), "quota: failed to write response: "+err.Error(), logger.IgnoredAttr(err))
+		}
+	}
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
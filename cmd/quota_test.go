@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"transmission-proxy/internal/jrpc"
+	"transmission-proxy/internal/ownership"
+	"transmission-proxy/internal/policy"
+	"transmission-proxy/internal/quota"
+	"transmission-proxy/internal/reqctx"
+	"transmission-proxy/internal/response"
+	"transmission-proxy/internal/roles"
+	"transmission-proxy/internal/upstream"
+)
+
+// testQuota returns the roles of admin root and alice limited to 2 torrents of 2 KiB, and the calculator
+// of alice owning 2 torrents of 1536 bytes in total.
+func testQuota(t *testing.T) (*roles.Roles, *quota.Calculator) {
+	store, err := ownership.Open(filepath.Join(t.TempDir(), "ownership.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+
+	for hash, user := range map[string]string{"aaaa": "alice", "bbbb": "alice", "cccc": "bob"} {
+		if err := store.Put(hash, &ownership.Entry{User: user}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	up := upstreamStatus(http.StatusOK, `{"arguments":{"torrents":[{"hashString":"aaaa","totalSize":1024},`+
+		`{"hashString":"bbbb","totalSize":512},{"hashString":"cccc","totalSize":100}]},"result":"success"}`)
+	client := &upstream.Client{URL: "http://transmission:9091/transmission/rpc", HTTP: &http.Client{Transport: up}}
+
+	rl := roles.New([]string{"root"})
+	rl.Users["alice"] = &roles.User{Quota: quota.Limits{Bytes: 2048, Torrents: 2}}
+
+	return rl, &quota.Calculator{Store: store, Torrents: &upstream.TorrentList{Client: client, Fields: quota.Fields, TTL: time.Minute}}
+}
+
+func TestQuotaReport(t *testing.T) {
+	captureLog(t)
+	rl, calc := testQuota(t)
+	h := quotaReport(&response.Responder{}, rl, calc)
+
+	cases := []struct {
+		name, user, target string
+		status             int
+		// want is the reported user
+		want string
+	}{
+		{name: "own", user: "alice", target: "/proxy/quota", status: http.StatusOK, want: "alice"},
+		{name: "own by name", user: "alice", target: "/proxy/quota?user=alice", status: http.StatusOK, want: "alice"},
+		{name: "admin of other", user: "root", target: "/proxy/quota?user=alice", status: http.StatusOK, want: "alice"},
+		{name: "user of other", user: "bob", target: "/proxy/quota?user=alice", status: http.StatusForbidden},
+		{name: "anonymous", target: "/proxy/quota", status: http.StatusUnauthorized},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, tc.target, nil)
+			if tc.user != "" {
+				r = r.WithContext(reqctx.WithUser(r.Context(), tc.user))
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+
+			if w.Code != tc.status {
+				t.Fatalf("got status %d, body %s", w.Code, w.Body)
+			}
+			if tc.want == "" {
+				return
+			}
+
+			var report quota.Report
+			if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil {
+				t.Fatal(err)
+			}
+			if report.User != tc.want || report.Usage != (quota.Usage{Bytes: 1536, Torrents: 2}) || !report.Exceeded ||
+				*report.Remaining.Bytes != 512 || *report.Remaining.Torrents != 0 {
+				t.Errorf("got report %s", w.Body)
+			}
+		})
+	}
+
+	// the report agrees with the enforcement: alice may not add more torrents
+	q := &policy.Quota{Roles: rl, Calc: calc}
+	ctx := reqctx.WithUser(context.Background(), "alice")
+	if _, _, err := q.Apply(ctx, &jrpc.Request{Method: "torrent-add", Arguments: map[string]any{"filename": "magnet:?xt=urn:btih:dddd"}}); err == nil || !strings.Contains(err.Error(), "quota exceeded: 2 torrents of 1536 bytes") {
+		t.Errorf("got error %v", err)
+	}
+}
+
+func TestQuotaReportHTML(t *testing.T) {
+	rl, calc := testQuota(t)
+	r := httptest.NewRequest(http.MethodGet, "/proxy/quota", nil)
+	r = r.WithContext(reqctx.WithUser(r.Context(), "alice"))
+	r.Header.Set("Accept", "text/html,application/xhtml+xml")
+	w := httptest.NewRecorder()
+	quotaReport(&response.Responder{}, rl, calc).ServeHTTP(w, r)
+
+	if w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/html") {
+		t.Fatalf("got status %d, headers %v", w.Code, w.Header())
+	}
+	for _, s := range []string{"Quota of alice", "1.5 KiB", "2.0 KiB", "512 B", "exhausted"} {
+		if !strings.Contains(w.Body.String(), s) {
+			t.Errorf("%q missing from page %s", s, w.Body)
+		}
+	}
+}
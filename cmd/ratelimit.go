@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+
+	"transmission-proxy/internal/jrpc"
+	"transmission-proxy/internal/logger"
+	"transmission-proxy/internal/ratelimit"
+	"transmission-proxy/internal/reqctx"
+	"transmission-proxy/internal/response"
+	"transmission-proxy/transmissionproxy"
+)
+
+// rejectRateLimit is the reject reason for requests over the rate limit of the client.
+const rejectRateLimit = "rate_limited"
+
+// clientLimit limits the rate of RPC requests of every client IP, except for methods exempt from the limit.
+type clientLimit struct {
+	buckets  *ratelimit.Keyed
+	exempt   []string
+	throttle *logger.Throttle
+}
+
+// clientLimitFromEnv reads RATE_LIMIT_PER_IP, RATE_LIMIT_BURST and RATE_LIMIT_EXEMPT_METHODS. Returns nil
+// unless the limit is set.
+func clientLimitFromEnv() *clientLimit {
+	val := os.Getenv("RATE_LIMIT_PER_IP")
+	if val == "" {
+		return nil
+	}
+
+	rate, err := strconv.ParseFloat(val, 64)
+	if err != nil || rate <= 0 {
+		slog.Error("RATE_LIMIT_PER_IP must be a positive number of requests per second")
+		os.Exit(1)
+	}
+
+	burst, err := strconv.Atoi(getEnvOrDefault("RATE_LIMIT_BURST", strconv.Itoa(int(math.Ceil(rate)))))
+	if err != nil || burst <= 0 {
+		slog.Error("RATE_LIMIT_BURST must be a positive integer")
+		os.Exit(1)
+	}
+
+	return &clientLimit{
+		buckets:  ratelimit.NewKeyed(rate, burst),
+		exempt:   getListEnv("RATE_LIMIT_EXEMPT_METHODS", "session-get"),
+		throttle: logger.NewThrottle(transmissionproxy.UpstreamErrorLogWindow),
+	}
+}
+
+// refuse responds with 429 and returns true if the client is over its limit.
+func (l *clientLimit) refuse(w http.ResponseWriter, r *http.Request, req *jrpc.Request, rr *response.Responder) bool {
+	if l == nil || slices.Contains(l.exempt, req.Method) {
+		return false
+	}
+
+	ip := reqctx.ClientIP(r.Context())
+	if !ip.IsValid() {
+		return false
+	}
+
+	ok, retryAfter := l.buckets.Allow(ip.String())
+	if ok {
+		return false
+	}
+
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	err := logger.WithAttributes(fmt.Errorf("rate limit of client exceeded"), logger.RPCMethod(req.Method), logger.RPCRejectReason(rejectRateLimit))
+	// a client looping over the limit would flood the log otherwise
+	lvl := l.throttle.Level(r.Context(), "rate limit of "+ip.String(), slog.LevelWarn)
+	rr.RespondAndLogCustom(w, r.Context(), err, req.Tag, lvl, http.StatusTooManyRequests)
+	return true
+}
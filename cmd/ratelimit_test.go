@@ -0,0 +1,103 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"transmission-proxy/internal/clientip"
+	"transmission-proxy/internal/logger"
+	"transmission-proxy/internal/ratelimit"
+	"transmission-proxy/internal/transmissiontest"
+)
+
+// postRPCFrom posts the RPC request forwarded for the client by the proxy at 192.0.2.1.
+func postRPCFrom(h http.Handler, client, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, rpcPath, strings.NewReader(body))
+	r.RemoteAddr = "192.0.2.1:40000"
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set("X-Forwarded-For", client)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+
+	return w
+}
+
+func TestRateLimit(t *testing.T) {
+	logs := captureLog(t)
+	up := &transmissiontest.Server{}
+	trusted, _ := clientip.ParseTrustedProxies("192.0.2.1/32")
+	h := (&clientip.Resolver{TrustedProxies: trusted}).Middleware(testRPCProxy(up, func(cfg *rpcProxyConfig) {
+		cfg.clientLimit = &clientLimit{
+			buckets:  ratelimit.NewKeyed(0.01, 2),
+			exempt:   []string{"session-get"},
+			throttle: logger.NewThrottle(time.Minute),
+		}
+	}))
+
+	for i := 0; i < 2; i++ {
+		if w := postRPCFrom(h, "203.0.113.1", `{"method":"torrent-get","arguments":{"fields":["id"]}}`); w.Code != http.StatusOK {
+			t.Fatalf("request %d: got status %d, body %s", i+1, w.Code, w.Body)
+		}
+	}
+
+	w := postRPCFrom(h, "203.0.113.1", `{"method":"torrent-get","arguments":{"fields":["id"]},"tag":3}`)
+	if w.Code != http.StatusTooManyRequests || !strings.Contains(w.Body.String(), `"tag":3`) {
+		t.Errorf("over the limit: got status %d, body %s", w.Code, w.Body)
+	}
+	if s, err := strconv.Atoi(w.Header().Get("Retry-After")); err != nil || s < 1 || s > 100 {
+		t.Errorf("got Retry-After %q", w.Header().Get("Retry-After"))
+	}
+	rec := logRecord(t, logs, "rate limit of client exceeded")
+	if rpc, _ := rec[logger.GroupRPC].(map[string]any); rpc[logger.KeyRejectReason] != rejectRateLimit {
+		t.Errorf("got record %v", rec)
+	}
+
+	// the exempt methods and the other clients are not limited
+	if w := postRPCFrom(h, "203.0.113.1", `{"method":"session-get"}`); w.Code != http.StatusOK {
+		t.Errorf("exempt method: got status %d, body %s", w.Code, w.Body)
+	}
+	if w := postRPCFrom(h, "203.0.113.2", `{"method":"torrent-get","arguments":{"fields":["id"]}}`); w.Code != http.StatusOK {
+		t.Errorf("other client: got status %d, body %s", w.Code, w.Body)
+	}
+	if n := len(up.Bodies()); n != 4 {
+		t.Errorf("got %d requests forwarded, want 4", n)
+	}
+}
+
+func TestRateLimitUntrustedProxy(t *testing.T) {
+	captureLog(t)
+	// X-Forwarded-For of untrusted peers is ignored, so that clients cannot get a bucket per request
+	h := (&clientip.Resolver{}).Middleware(testRPCProxy(&transmissiontest.Server{}, func(cfg *rpcProxyConfig) {
+		cfg.clientLimit = &clientLimit{buckets: ratelimit.NewKeyed(0.01, 1), throttle: logger.NewThrottle(time.Minute)}
+	}))
+
+	postRPCFrom(h, "203.0.113.1", `{"method":"session-get"}`)
+	if w := postRPCFrom(h, "203.0.113.2", `{"method":"session-get"}`); w.Code != http.StatusTooManyRequests {
+		t.Errorf("got status %d, body %s", w.Code, w.Body)
+	}
+}
+
+func TestClientLimitFromEnv(t *testing.T) {
+	t.Setenv("RATE_LIMIT_PER_IP", "")
+	if clientLimitFromEnv() != nil {
+		t.Error("got limit without RATE_LIMIT_PER_IP")
+	}
+
+	// the burst defaults to the rate rounded up
+	t.Setenv("RATE_LIMIT_PER_IP", "2.5")
+	l := clientLimitFromEnv()
+	if l.buckets.Rate != 2.5 || l.buckets.Burst != 3 || strings.Join(l.exempt, ",") != "session-get" {
+		t.Errorf("got limit %v per second, burst %d, exempt %q", l.buckets.Rate, l.buckets.Burst, l.exempt)
+	}
+
+	t.Setenv("RATE_LIMIT_BURST", "10")
+	t.Setenv("RATE_LIMIT_EXEMPT_METHODS", "session-get,torrent-get")
+	l = clientLimitFromEnv()
+	if l.buckets.Burst != 10 || strings.Join(l.exempt, ",") != "session-get,torrent-get" {
+		t.Errorf("got burst %d, exempt %q", l.buckets.Burst, l.exempt)
+	}
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"transmission-proxy/internal/transmissiontest"
+)
+
+func TestRenamePathForwarded(t *testing.T) {
+	captureLog(t)
+	up := &transmissiontest.Server{}
+	h := testRPCProxy(up)
+
+	// renaming a file from the web interface of Transmission
+	body := `{"method":"torrent-rename-path","arguments":{"ids":[1],"path":"debian/debian.iso","name":"debian-12.iso"},"tag":4}`
+	w := postRPC(h, body)
+	if w.Code != http.StatusOK || w.Body.String() != `{"arguments":{},"result":"success","tag":4}` {
+		t.Fatalf("got status %d, body %s", w.Code, w.Body)
+	}
+
+	// names leaving the directory of the torrent are not forwarded
+	for _, name := range []string{"../../etc/cron.d/x", "..", `..\x`} {
+		w := postRPC(h, `{"method":"torrent-rename-path","arguments":{"ids":1,"path":"debian.iso","name":"`+strings.ReplaceAll(name, `\`, `\\`)+`"},"tag":5}`)
+		if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), `"tag":5`) {
+			t.Errorf("%s: got status %d, body %s", name, w.Code, w.Body)
+		}
+	}
+
+	if bodies := up.Bodies(); len(bodies) != 1 || bodies[0] != body {
+		t.Errorf("forwarded %q, want the valid request only", bodies)
+	}
+}
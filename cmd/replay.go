@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"transmission-proxy/internal/capture"
+	"transmission-proxy/internal/jrpc"
+	"transmission-proxy/internal/transmission"
+	"transmission-proxy/internal/upstream"
+	"transmission-proxy/transmissionproxy"
+)
+
+// replayTimeout limits each request replayed against a target.
+const replayTimeout = 30 * time.Second
+
+// replayCommand implements `transmission-proxy replay [--target=URL] [--auth=user:password] [--prefix=/downloads/] <dir>`:
+// it re-sends requests captured in CAPTURE_DIR and reports those with a verdict different from the captured one.
+// Without target the requests are only checked by the validator, like the validate subcommand does; with target
+// they are sent as dry runs to the RPC endpoint of a running proxy. Returns process exit code: 0 if all verdicts
+// match, 1 if any differs, 2 on usage or input errors.
+func replayCommand(args []string, stdout io.Writer) int {
+	fs := flag.NewFlagSet("replay", flag.ContinueOnError)
+	target := fs.String("target", "", "RPC URL of the proxy to replay against, validator only if empty")
+	auth := fs.String("auth", "", "user:password for basic authentication at the target")
+	prefix := fs.String("prefix", downloadPrefix, "required download prefix for the validator (defaults to DOWNLOAD_PREFIX)")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 {
+		_, _ = fmt.Fprintln(os.Stderr, "usage: transmission-proxy replay [--target=URL] [--auth=user:password] [--prefix=/downloads/] <dir>")
+		return 2
+	}
+
+	exchanges, err := capture.Load(fs.Arg(0))
+	if err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, err.Error())
+		return 2
+	}
+
+	var replay func(ex *capture.Exchange) (verdict, reason string, err error)
+	if *target == "" {
+		checkDownloadPrefix(*prefix)
+		v := buildValidator(*prefix)
+		replay = func(ex *capture.Exchange) (string, string, error) {
+			verdict, reason := replayValidator(v, ex)
+			return verdict, reason, nil
+		}
+	} else {
+		client := &http.Client{Timeout: replayTimeout}
+		sessionID := ""
+		replay = func(ex *capture.Exchange) (string, string, error) {
+			return replayTarget(client, *target, *auth, &sessionID, ex)
+		}
+	}
+
+	differ, skipped := 0, 0
+	for _, ex := range exchanges {
+		// the validator alone cannot reproduce policy verdicts, failures are not verdicts at all
+		if ex.Verdict == verdictFailed || (*target == "" && ex.RejectReason == transmission.RejectPolicy) {
+			skipped++
+			continue
+		}
+
+		verdict, reason, err := replay(ex)
+		if err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "#%d: %s\n", ex.Seq, err)
+			return 2
+		}
+		if verdict == ex.Verdict {
+			continue
+		}
+
+		differ++
+		line := fmt.Sprintf("#%d %s: captured %s", ex.Seq, ex.Method, ex.Verdict)
+		if ex.RejectReason != "" {
+			line += " (" + ex.RejectReason + ")"
+		}
+		line += ", replayed " + verdict
+		if reason != "" {
+			line += " (" + reason + ")"
+		}
+		if _, err := fmt.Fprintln(stdout, line); err != nil {
+			return 2
+		}
+	}
+
+	if _, err := fmt.Fprintf(stdout, "replayed %d exchanges: %d differ, %d skipped\n", len(exchanges)-skipped, differ, skipped); err != nil {
+		return 2
+	}
+	if differ > 0 {
+		return 1
+	}
+
+	return 0
+}
+
+// replayValidator checks the captured request with the validator.
+func replayValidator(v transmission.RequestValidator, ex *capture.Exchange) (verdict, reason string) {
+	var req jrpc.Request
+	if err := json.Unmarshal([]byte(ex.Request), &req); err != nil {
+		return capture.VerdictRejected, transmissionproxy.RejectMalformed
+	}
+
+	if _, err := v.Validate(&req); err != nil {
+		return capture.VerdictRejected, transmission.RejectReason(err)
+	}
+
+	return capture.VerdictAccepted, ""
+}
+
+// replayTarget sends the captured request as a dry run to the target, negotiating the session id as needed.
+func replayTarget(client *http.Client, target, auth string, sessionID *string, ex *capture.Exchange) (verdict, reason string, err error) {
+	for attempt := 0; ; attempt++ {
+		hr, err := http.NewRequest(http.MethodPost, target, bytes.NewReader([]byte(ex.Request)))
+		if err != nil {
+			return "", "", err
+		}
+
+		hr.Header.Set("Content-Type", "application/json")
+		hr.Header.Set(transmissionproxy.DryRunHeader, "1")
+		if *sessionID != "" {
+			hr.Header.Set(upstream.SessionIDHeader, *sessionID)
+		}
+		if user, password, ok := strings.Cut(auth, ":"); ok {
+			hr.SetBasicAuth(user, password)
+		}
+
+		resp, err := client.Do(hr)
+		if err != nil {
+			return "", "", err
+		}
+		_, _ = io.Copy(io.Discard, resp.Body)
+		_ = resp.Body.Close()
+
+		if resp.StatusCode == http.StatusConflict && attempt == 0 && resp.Header.Get(upstream.SessionIDHeader) != "" {
+			*sessionID = resp.Header.Get(upstream.SessionIDHeader)
+			continue
+		}
+
+		if resp.StatusCode == http.StatusOK {
+			return capture.VerdictAccepted, "", nil
+		}
+
+		return capture.VerdictRejected, fmt.Sprintf("status %d", resp.StatusCode), nil
+	}
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"transmission-proxy/internal/jrpc"
+	"transmission-proxy/internal/response"
+	"transmission-proxy/internal/transmission"
+	"transmission-proxy/internal/upstream"
+)
+
+// restPrefix is the path the REST API is served under.
+const restPrefix = "/api/"
+
+var restAPI = getBoolEnv("REST_API")
+
+// restDefaultFields are the torrent fields returned unless the fields query parameter is given.
+var restDefaultFields = []string{"id", "name", "hashString", "status", "percentDone", "totalSize", "downloadDir", "labels"}
+
+// restAddRequest is the body of POST /api/torrents.
+type restAddRequest struct {
+	URL         string   `json:"url"`
+	Metainfo    string   `json:"metainfo"`
+	DownloadDir string   `json:"downloadDir"`
+	Paused      bool     `json:"paused"`
+	Labels      []string `json:"labels"`
+}
+
+// restHandler serves the simplified REST API for common operations:
+//
+//	GET    /api/torrents?fields=id,name&ids=1,2   torrent-get, returns the list of torrents
+//	POST   /api/torrents                          torrent-add of {"url"} or {"metainfo"}, returns the torrent
+//	GET    /api/torrents/{id}                     torrent-get of one torrent
+//	DELETE /api/torrents/{id}?deleteData=false    torrent-remove
+//	POST   /api/torrents/{id}/start|stop          torrent-start/torrent-stop
+//	GET    /api/session                           session-get, returns the session settings
+//
+// Torrents are referred to by id or hash. Every operation is made as RPC request through rc, so it goes
+// through validation, policies, audit etc. like any other; the session id is negotiated by the handler.
+// Results are returned without the RPC envelope, errors are returned as by RPC endpoint with their status,
+// failures reported by Transmission with 422.
+func restHandler(rr *response.Responder, rc *rpcCaller) http.HandlerFunc {
+	// call makes RPC request and returns its arguments. If it fails, the error response is sent already.
+	call := func(w http.ResponseWriter, r *http.Request, method string, args map[string]any) (map[string]any, bool) {
+		body, err := json.Marshal(&jrpc.Request{Method: method, Arguments: args})
+		if err != nil {
+			rr.RespondAndLogError(w, r.Context(), fmt.Errorf("cannot serialize RPC request: %w", err), 0)
+			return nil, false
+		}
+
+		res := rc.call(r, body)
+		if res.status != http.StatusOK {
+			res.copyTo(w)
+			return nil, false
+		}
+
+		resp, err := res.rpcResponse()
+		if err != nil {
+			rr.RespondAndLogCustom(w, r.Context(), err, 0, slog.LevelError, http.StatusBadGateway)
+			return nil, false
+		}
+		if resp.Result != jrpc.ResultSuccess {
+			err := &upstream.ResultError{Method: method, Result: resp.Result}
+			rr.RespondAndLogCustom(w, r.Context(), err, 0, slog.LevelWarn, http.StatusUnprocessableEntity)
+			return nil, false
+		}
+
+		return resp.Arguments, true
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, restPrefix), "/"), "/")
+
+		var allow string
+		switch {
+		case len(parts) == 1 && parts[0] == "torrents":
+			switch r.Method {
+			case http.MethodGet:
+				restListTorrents(rr, call, w, r)
+				return
+			case http.MethodPost:
+				restAddTorrent(rr, call, w, r)
+				return
+			}
+			allow = "GET, POST"
+		case len(parts) == 2 && parts[0] == "torrents":
+			id, ok := restTorrentID(rr, w, r, parts[1])
+			if !ok {
+				return
+			}
+
+			switch r.Method {
+			case http.MethodGet:
+				restGetTorrent(rr, call, w, r, id)
+				return
+			case http.MethodDelete:
+				restRemoveTorrent(rr, call, w, r, id)
+				return
+			}
+			allow = "GET, DELETE"
+		case len(parts) == 3 && parts[0] == "torrents" && (parts[2] == "start" || parts[2] == "stop"):
+			id, ok := restTorrentID(rr, w, r, parts[1])
+			if !ok {
+				return
+			}
+
+			if r.Method == http.MethodPost {
+				if _, ok := call(w, r, "torrent-"+parts[2], map[string]any{"ids": []any{id}}); ok {
+					w.WriteHeader(http.StatusNoContent)
+				}
+				return
+			}
+			allow = "POST"
+		case len(parts) == 1 && parts[0] == "session":
+			if r.Method == http.MethodGet {
+				if args, ok := call(w, r, "session-get", nil); ok {
+					writeJSON(w, r, http.StatusOK, args)
+				}
+				return
+			}
+			allow = "GET"
+		default:
+			rr.RespondAndLogCustom(w, r.Context(), fmt.Errorf("unknown API endpoint"), 0, slog.LevelWarn, http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Allow", allow)
+		rr.RespondAndLogCustom(w, r.Context(), fmt.Errorf("method not allowed"), 0, slog.LevelWarn, http.StatusMethodNotAllowed)
+	}
+}
+
+// restCall makes RPC request for REST endpoint, see restHandler.
+type restCall func(w http.ResponseWriter, r *http.Request, method string, args map[string]any) (map[string]any, bool)
+
+func restListTorrents(rr *response.Responder, call restCall, w http.ResponseWriter, r *http.Request) {
+	args := map[string]any{"fields": restFields(r)}
+	if s := r.URL.Query().Get("ids"); s != "" {
+		var ids []any
+		for _, item := range strings.Split(s, ",") {
+			id, ok := restTorrentID(rr, w, r, strings.TrimSpace(item))
+			if !ok {
+				return
+			}
+			ids = append(ids, id)
+		}
+		args["ids"] = ids
+	}
+
+	res, ok := call(w, r, "torrent-get", args)
+	if !ok {
+		return
+	}
+
+	torrents, _ := res["torrents"].([]any)
+	if torrents == nil {
+		torrents = []any{}
+	}
+	writeJSON(w, r, http.StatusOK, torrents)
+}
+
+func restGetTorrent(rr *response.Responder, call restCall, w http.ResponseWriter, r *http.Request, id any) {
+	res, ok := call(w, r, "torrent-get", map[string]any{"fields": restFields(r), "ids": []any{id}})
+	if !ok {
+		return
+	}
+
+	torrents, _ := res["torrents"].([]any)
+	if len(torrents) == 0 {
+		rr.RespondAndLogCustom(w, r.Context(), fmt.Errorf("torrent not found"), 0, slog.LevelInfo, http.StatusNotFound)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, torrents[0])
+}
+
+func restAddTorrent(rr *response.Responder, call restCall, w http.ResponseWriter, r *http.Request) {
+	var req restAddRequest
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		rr.RespondAndLogCustom(w, r.Context(), fmt.Errorf("failed to parse request: %w", err), 0, slog.LevelWarn, http.StatusBadRequest)
+		return
+	}
+	if (req.URL == "") == (req.Metainfo == "") {
+		rr.RespondAndLogCustom(w, r.Context(), fmt.Errorf("exactly one of url and metainfo is required"), 0, slog.LevelWarn, http.StatusBadRequest)
+		return
+	}
+
+	args := map[string]any{"paused": req.Paused}
+	if req.URL != "" {
+		args["filename"] = req.URL
+	} else {
+		args["metainfo"] = req.Metainfo
+	}
+	if req.DownloadDir != "" {
+		args["download-dir"] = req.DownloadDir
+	}
+	if req.Labels != nil {
+		labels := make([]any, len(req.Labels))
+		for i, l := range req.Labels {
+			labels[i] = l
+		}
+		args["labels"] = labels
+	}
+
+	res, ok := call(w, r, "torrent-add", args)
+	if !ok {
+		return
+	}
+
+	if t, ok := res["torrent-added"]; ok {
+		writeJSON(w, r, http.StatusCreated, t)
+	} else {
+		writeJSON(w, r, http.StatusOK, res["torrent-duplicate"])
+	}
+}
+
+func restRemoveTorrent(rr *response.Responder, call restCall, w http.ResponseWriter, r *http.Request, id any) {
+	deleteData := false
+	if s := r.URL.Query().Get("deleteData"); s != "" {
+		var err error
+		if deleteData, err = strconv.ParseBool(s); err != nil {
+			rr.RespondAndLogCustom(w, r.Context(), fmt.Errorf("deleteData must be true or false"), 0, slog.LevelWarn, http.StatusBadRequest)
+			return
+		}
+	}
+
+	if _, ok := call(w, r, "torrent-remove", map[string]any{"ids": []any{id}, "delete-local-data": deleteData}); ok {
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// restTorrentID parses torrent id or hash. If it is neither, the error response is sent already.
+func restTorrentID(rr *response.Responder, w http.ResponseWriter, r *http.Request, s string) (any, bool) {
+	if id, err := strconv.Atoi(s); err == nil && id > 0 {
+		return id, true
+	}
+	if transmission.IsTorrentHash(s) {
+		return strings.ToLower(s), true
+	}
+
+	rr.RespondAndLogCustom(w, r.Context(), fmt.Errorf("torrent must be referred to by id or hash, got %q", s), 0, slog.LevelWarn, http.StatusBadRequest)
+	return nil, false
+}
+
+// restFields returns the torrent fields requested by the fields query parameter.
+func restFields(r *http.Request) []any {
+	fields := restDefaultFields
+	if s := r.URL.Query().Get("fields"); s != "" {
+		fields = strings.Split(s, ",")
+	}
+
+	res := make([]any, 0, len(fields))
+	for _, f := range fields {
+		if f = strings.TrimSpace(f); f != "" {
+			res = append(res, f)
+		}
+	}
+
+	return res
+}
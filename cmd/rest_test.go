@@ -0,0 +1,229 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"transmission-proxy/internal/mock"
+	"transmission-proxy/internal/response"
+	"transmission-proxy/internal/upstream"
+)
+
+// mockUpstream serves the requests by the mock daemon, recording their bodies.
+func mockUpstream(t *testing.T, got *[]string) upstreamFunc {
+	m, err := mock.New("mock-session-id", 0, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return func(r *http.Request) (*http.Response, error) {
+		bs, err := io.ReadAll(r.Body)
+		if err != nil {
+			return nil, err
+		}
+		r.Body = io.NopCloser(strings.NewReader(string(bs)))
+		if r.Header.Get(upstream.SessionIDHeader) == m.SessionID {
+			*got = append(*got, string(bs))
+		}
+
+		w := httptest.NewRecorder()
+		m.ServeHTTP(w, r)
+		return w.Result(), nil
+	}
+}
+
+// testREST returns the REST handler making its requests through rpcProxy to the upstream.
+func testREST(upstream http.RoundTripper) http.Handler {
+	rr := &response.Responder{DebugMode: true}
+	return restHandler(rr, &rpcCaller{rpc: testRPCProxy(upstream, func(cfg *rpcProxyConfig) { cfg.responder = rr })})
+}
+
+func doREST(h http.Handler, method, target, body string) *httptest.ResponseRecorder {
+	var rd io.Reader
+	if body != "" {
+		rd = strings.NewReader(body)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(method, target, rd))
+
+	return w
+}
+
+// lastRPC returns the method and arguments of the last forwarded request.
+func lastRPC(t *testing.T, forwarded []string) (string, string) {
+	t.Helper()
+
+	if len(forwarded) == 0 {
+		t.Fatal("nothing forwarded")
+	}
+	var req struct {
+		Method    string          `json:"method"`
+		Arguments json.RawMessage `json:"arguments"`
+	}
+	if err := json.Unmarshal([]byte(forwarded[len(forwarded)-1]), &req); err != nil {
+		t.Fatal(err)
+	}
+
+	return req.Method, string(req.Arguments)
+}
+
+func TestREST(t *testing.T) {
+	captureLog(t)
+	var forwarded []string
+	h := testREST(mockUpstream(t, &forwarded))
+
+	w := doREST(h, http.MethodPost, "/api/torrents", `{"url":"magnet:?xt=urn:btih:aaaa","downloadDir":"/downloads/tv","paused":true,"labels":["tv"]}`)
+	if w.Code != http.StatusCreated || !strings.Contains(w.Body.String(), `"id":1`) {
+		t.Fatalf("add: got status %d, body %s", w.Code, w.Body)
+	}
+	if method, args := lastRPC(t, forwarded); method != "torrent-add" ||
+		args != `{"download-dir":"/downloads/tv","filename":"magnet:?xt=urn:btih:aaaa","labels":["tv"],"paused":true}` {
+		t.Errorf("add: forwarded %s %s", method, args)
+	}
+	var added struct {
+		HashString string `json:"hashString"`
+	}
+	_ = json.Unmarshal(w.Body.Bytes(), &added)
+
+	if w := doREST(h, http.MethodPost, "/api/torrents", `{"url":"magnet:?xt=urn:btih:aaaa"}`); w.Code != http.StatusOK ||
+		!strings.Contains(w.Body.String(), `"id":1`) {
+		t.Errorf("duplicate: got status %d, body %s", w.Code, w.Body)
+	}
+	if w := doREST(h, http.MethodPost, "/api/torrents", `{"metainfo":"ZDg6YW5ub3VuY2U="}`); w.Code != http.StatusCreated {
+		t.Errorf("add metainfo: got status %d, body %s", w.Code, w.Body)
+	}
+	if method, args := lastRPC(t, forwarded); method != "torrent-add" || args != `{"metainfo":"ZDg6YW5ub3VuY2U=","paused":false}` {
+		t.Errorf("add metainfo: forwarded %s %s", method, args)
+	}
+
+	w = doREST(h, http.MethodGet, "/api/torrents?fields=id,+status", "")
+	if w.Code != http.StatusOK || strings.TrimSpace(w.Body.String()) != `[{"id":1,"status":0},{"id":2,"status":4}]` {
+		t.Errorf("list: got status %d, body %s", w.Code, w.Body)
+	}
+	if _, args := lastRPC(t, forwarded); args != `{"fields":["id","status"]}` {
+		t.Errorf("list: forwarded %s", args)
+	}
+	w = doREST(h, http.MethodGet, "/api/torrents?ids=2,"+strings.ToUpper(added.HashString), "")
+	if _, args := lastRPC(t, forwarded); w.Code != http.StatusOK ||
+		args != `{"fields":["id","name","hashString","status","percentDone","totalSize","downloadDir","labels"],"ids":[2,"`+added.HashString+`"]}` {
+		t.Errorf("list by ids: got status %d, forwarded %s", w.Code, args)
+	}
+
+	if w := doREST(h, http.MethodGet, "/api/torrents/"+added.HashString+"?fields=id,downloadDir", ""); w.Code != http.StatusOK ||
+		strings.TrimSpace(w.Body.String()) != `{"downloadDir":"/downloads/tv","id":1}` {
+		t.Errorf("get: got status %d, body %s", w.Code, w.Body)
+	}
+	if w := doREST(h, http.MethodGet, "/api/torrents/9", ""); w.Code != http.StatusNotFound {
+		t.Errorf("get missing: got status %d, body %s", w.Code, w.Body)
+	}
+
+	if w := doREST(h, http.MethodPost, "/api/torrents/1/start", ""); w.Code != http.StatusNoContent {
+		t.Errorf("start: got status %d, body %s", w.Code, w.Body)
+	}
+	if method, args := lastRPC(t, forwarded); method != "torrent-start" || args != `{"ids":[1]}` {
+		t.Errorf("start: forwarded %s %s", method, args)
+	}
+	if w := doREST(h, http.MethodPost, "/api/torrents/2/stop", ""); w.Code != http.StatusNoContent {
+		t.Errorf("stop: got status %d, body %s", w.Code, w.Body)
+	}
+	if w := doREST(h, http.MethodGet, "/api/torrents?fields=id,status", ""); strings.TrimSpace(w.Body.String()) != `[{"id":1,"status":4},{"id":2,"status":0}]` {
+		t.Errorf("after start and stop: got body %s", w.Body)
+	}
+
+	if w := doREST(h, http.MethodDelete, "/api/torrents/1", ""); w.Code != http.StatusNoContent {
+		t.Errorf("remove: got status %d, body %s", w.Code, w.Body)
+	}
+	if method, args := lastRPC(t, forwarded); method != "torrent-remove" || args != `{"delete-local-data":false,"ids":[1]}` {
+		t.Errorf("remove: forwarded %s %s", method, args)
+	}
+	doREST(h, http.MethodDelete, "/api/torrents/2?deleteData=true", "")
+	if _, args := lastRPC(t, forwarded); args != `{"delete-local-data":true,"ids":[2]}` {
+		t.Errorf("remove with data: forwarded %s", args)
+	}
+	if w := doREST(h, http.MethodGet, "/api/torrents", ""); w.Code != http.StatusOK || strings.TrimSpace(w.Body.String()) != `[]` {
+		t.Errorf("list empty: got status %d, body %s", w.Code, w.Body)
+	}
+
+	w = doREST(h, http.MethodGet, "/api/session", "")
+	var session map[string]any
+	if err := json.Unmarshal(w.Body.Bytes(), &session); err != nil || w.Code != http.StatusOK || session["version"] != "4.0.0 (mock)" {
+		t.Errorf("session: got status %d, body %s", w.Code, w.Body)
+	}
+}
+
+func TestRESTErrors(t *testing.T) {
+	captureLog(t)
+	var forwarded []string
+	h := testREST(mockUpstream(t, &forwarded))
+
+	cases := []struct {
+		name, method, target, body string
+		status                     int
+		err                        string
+	}{
+		{name: "unknown endpoint", method: http.MethodGet, target: "/api/files", status: http.StatusNotFound, err: "unknown api endpoint"},
+		{name: "unknown action", method: http.MethodPost, target: "/api/torrents/1/verify", status: http.StatusNotFound},
+		{name: "list method", method: http.MethodPut, target: "/api/torrents", status: http.StatusMethodNotAllowed},
+		{name: "torrent method", method: http.MethodPost, target: "/api/torrents/1", status: http.StatusMethodNotAllowed},
+		{name: "action method", method: http.MethodGet, target: "/api/torrents/1/start", status: http.StatusMethodNotAllowed},
+		{name: "session method", method: http.MethodPost, target: "/api/session", status: http.StatusMethodNotAllowed},
+		{name: "bad id", method: http.MethodGet, target: "/api/torrents/0", status: http.StatusBadRequest, err: "by id or hash"},
+		{name: "bad hash", method: http.MethodPost, target: "/api/torrents/abcd/stop", status: http.StatusBadRequest, err: "by id or hash"},
+		{name: "bad ids", method: http.MethodGet, target: "/api/torrents?ids=1,x", status: http.StatusBadRequest, err: `got \"x\"`},
+		{name: "bad deleteData", method: http.MethodDelete, target: "/api/torrents/1?deleteData=maybe", status: http.StatusBadRequest,
+			err: "deletedata must be true or false"},
+		{name: "bad body", method: http.MethodPost, target: "/api/torrents", body: `{"url":1}`, status: http.StatusBadRequest,
+			err: "failed to parse request"},
+		{name: "unknown field", method: http.MethodPost, target: "/api/torrents", body: `{"url":"magnet:?","start":true}`,
+			status: http.StatusBadRequest, err: "unknown field"},
+		{name: "no source", method: http.MethodPost, target: "/api/torrents", body: `{}`, status: http.StatusBadRequest,
+			err: "exactly one of url and metainfo"},
+		{name: "both sources", method: http.MethodPost, target: "/api/torrents", body: `{"url":"magnet:?","metainfo":"ZA=="}`,
+			status: http.StatusBadRequest, err: "exactly one of url and metainfo"},
+		// the request goes through validation like any RPC request
+		{name: "outside prefix", method: http.MethodPost, target: "/api/torrents", body: `{"url":"magnet:?xt=urn:btih:a","downloadDir":"/etc"}`,
+			status: http.StatusBadRequest, err: "forbidden location"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			// the messages are capitalized in responses
+			w := doREST(h, tc.method, tc.target, tc.body)
+			if w.Code != tc.status || !strings.Contains(strings.ToLower(w.Body.String()), tc.err) {
+				t.Errorf("got status %d, body %s", w.Code, w.Body)
+			}
+			if tc.status == http.StatusMethodNotAllowed && w.Header().Get("Allow") == "" {
+				t.Error("no Allow header")
+			}
+		})
+	}
+	if len(forwarded) != 0 {
+		t.Errorf("invalid requests forwarded: %v", forwarded)
+	}
+}
+
+func TestRESTUpstreamErrors(t *testing.T) {
+	captureLog(t)
+
+	// failures reported by Transmission
+	w := doREST(testREST(upstreamStatus(http.StatusOK, `{"arguments":{},"result":"no such torrent"}`)), http.MethodPost, "/api/torrents/1/start", "")
+	if w.Code != http.StatusUnprocessableEntity || !strings.Contains(w.Body.String(), "no such torrent") {
+		t.Errorf("result error: got status %d, body %s", w.Code, w.Body)
+	}
+
+	// responses of the upstream that cannot be understood
+	w = doREST(testREST(upstreamStatus(http.StatusOK, `{"arguments":`)), http.MethodGet, "/api/session", "")
+	if w.Code != http.StatusBadGateway {
+		t.Errorf("bad response: got status %d, body %s", w.Code, w.Body)
+	}
+
+	// other statuses are passed as they are
+	w = doREST(testREST(upstreamStatus(http.StatusUnauthorized, "")), http.MethodGet, "/api/session", "")
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("unauthorized: got status %d, body %s", w.Code, w.Body)
+	}
+}
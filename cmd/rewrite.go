@@ -0,0 +1,107 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+
+	"transmission-proxy/internal/logger"
+	"transmission-proxy/internal/rewrite"
+)
+
+// externalBasePath is the path the proxy is exposed at by an outer reverse proxy stripping it, e.g. /torrents/,
+// or empty if it is exposed at the root.
+var externalBasePath = func() string {
+	if base := strings.Trim(os.Getenv("EXTERNAL_BASE_PATH"), "/"); base != "" {
+		return "/" + base + "/"
+	}
+
+	return ""
+}()
+
+// basePathRules returns the rules rewriting root-relative URLs of HTML attributes and the paths of the web UI
+// and RPC endpoint quoted in scripts to be under base.
+func basePathRules(base string) (html, js []rewrite.Rule) {
+	for _, path := range []string{webPath, rpcPath} {
+		for _, q := range []string{`"`, `'`, "`"} {
+			js = append(js, rewrite.Rule{From: q + path, To: q + base + strings.TrimPrefix(path, "/")})
+		}
+	}
+
+	for _, attr := range []string{"href", "src", "action"} {
+		for _, q := range []string{`"`, `'`} {
+			html = append(html, rewrite.Rule{From: attr + "=" + q + "/", To: attr + "=" + q + base})
+		}
+	}
+
+	return append(html, js...), js
+}
+
+// rewriteBasePath rewrites absolute URLs in HTML pages and scripts of the web UI for the UI to work when exposed
+// under base (see publicPrefix). Rewritten responses are streamed chunked; others, including compressed ones,
+// pass as they are.
+func rewriteBasePath(base string, next http.Handler) http.HandlerFunc {
+	html, js := basePathRules(base)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		// compressed content cannot be rewritten
+		r.Header.Del("Accept-Encoding")
+
+		bw := &basePathWriter{ResponseWriter: w, html: html, js: js}
+		next.ServeHTTP(bw, r)
+
+		if bw.rw != nil {
+			if err := bw.rw.Close(); err != nil {
+				slog.ErrorContext(r.Context(), "rewrite: failed to write response: "+err.Error(), logger.IgnoredAttr(err))
+			}
+		}
+	}
+}
+
+type basePathWriter struct {
+	http.ResponseWriter
+	html, js    []rewrite.Rule
+	rw          *rewrite.Writer
+	wroteHeader bool
+}
+
+func (b *basePathWriter) WriteHeader(status int) {
+	if b.wroteHeader {
+		b.ResponseWriter.WriteHeader(status)
+		return
+	}
+	b.wroteHeader = true
+
+	h := b.Header()
+	var rules []rewrite.Rule
+	ct := strings.ToLower(h.Get("Content-Type"))
+	switch {
+	case strings.HasPrefix(ct, "text/html"):
+		rules = b.html
+	case strings.Contains(ct, "javascript"):
+		rules = b.js
+	}
+	if rules != nil && h.Get("Content-Encoding") == "" {
+		h.Del("Content-Length")
+		b.rw = rewrite.NewWriter(b.ResponseWriter, rules...)
+	}
+
+	b.ResponseWriter.WriteHeader(status)
+}
+
+func (b *basePathWriter) Write(bs []byte) (int, error) {
+	if !b.wroteHeader {
+		b.WriteHeader(http.StatusOK)
+	}
+
+	if b.rw != nil {
+		return b.rw.Write(bs)
+	}
+
+	return b.ResponseWriter.Write(bs)
+}
+
+func (b *basePathWriter) Unwrap() http.ResponseWriter {
+	return b.ResponseWriter
+}
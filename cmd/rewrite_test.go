@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+// serveRewritten serves the fixture of the stock web UI through rewriteBasePath.
+func serveRewritten(t *testing.T, next http.Handler, path string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	r := httptest.NewRequest(http.MethodGet, path, nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	rewriteBasePath("/torrents/", next).ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("%s: got status %d", path, w.Code)
+	}
+
+	return w
+}
+
+func readFixture(t *testing.T, name string) string {
+	t.Helper()
+
+	bs, err := os.ReadFile("testdata/web/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(bs)
+}
+
+func TestRewriteBasePath(t *testing.T) {
+	web := http.FileServer(http.Dir("testdata/web"))
+
+	cases := []struct{ path, want string }{
+		{path: "/", want: "index.torrents.html"},
+		{path: "/transmission-app.js", want: "transmission-app.torrents.js"},
+	}
+	for _, tc := range cases {
+		w := serveRewritten(t, web, tc.path)
+		if want := readFixture(t, tc.want); w.Body.String() != want {
+			t.Errorf("%s: got\n%s\nwant\n%s", tc.path, w.Body, want)
+		}
+		// the length changes, so the response is sent chunked
+		if cl := w.Header().Get("Content-Length"); cl != "" {
+			t.Errorf("%s: got Content-Length %s", tc.path, cl)
+		}
+	}
+
+	// other content passes as it is
+	w := serveRewritten(t, web, "/favicon.png")
+	if png := readFixture(t, "favicon.png"); w.Body.String() != png || w.Header().Get("Content-Length") != "33" {
+		t.Errorf("image: got Content-Length %s, body %q", w.Header().Get("Content-Length"), w.Body)
+	}
+}
+
+func TestRewriteBasePathCompressed(t *testing.T) {
+	script := readFixture(t, "transmission-app.js")
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	_, _ = zw.Write([]byte(script))
+	_ = zw.Close()
+
+	var acceptEncoding []string
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		acceptEncoding = append(acceptEncoding, r.Header.Get("Accept-Encoding"))
+		w.Header().Set("Content-Type", "text/javascript; charset=utf-8")
+		if r.URL.Path == "/compressed.js" {
+			w.Header().Set("Content-Encoding", "gzip")
+			_, _ = w.Write(gz.Bytes())
+			return
+		}
+		_, _ = w.Write([]byte(script))
+	})
+
+	// compressed content cannot be rewritten, so it is not asked for
+	if w := serveRewritten(t, h, "/transmission-app.js"); w.Body.String() != readFixture(t, "transmission-app.torrents.js") {
+		t.Errorf("got body\n%s", w.Body)
+	}
+	if acceptEncoding[0] != "" {
+		t.Errorf("got Accept-Encoding %q sent", acceptEncoding[0])
+	}
+
+	// and passes as it is if sent anyway
+	if w := serveRewritten(t, h, "/compressed.js"); !bytes.Equal(w.Body.Bytes(), gz.Bytes()) {
+		t.Error("compressed response changed")
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"transmission-proxy/internal/jrpc"
+	"transmission-proxy/internal/upstream"
+)
+
+// rpcCaller makes RPC requests through the RPC handler on behalf of clients of other endpoints, negotiating
+// the session id itself.
+type rpcCaller struct {
+	rpc http.Handler
+
+	mu        sync.Mutex
+	sessionID string
+}
+
+// call makes RPC request with the body on behalf of the client of r.
+func (c *rpcCaller) call(r *http.Request, body []byte) *bufferedResponse {
+	for attempt := 0; ; attempt++ {
+		c.mu.Lock()
+		r.Header.Set(upstream.SessionIDHeader, c.sessionID)
+		c.mu.Unlock()
+
+		res := serveRPC(c.rpc, r, body)
+		if res.status == http.StatusConflict && attempt == 0 && res.header.Get(upstream.SessionIDHeader) != "" {
+			c.mu.Lock()
+			c.sessionID = res.header.Get(upstream.SessionIDHeader)
+			c.mu.Unlock()
+			continue
+		}
+
+		return res
+	}
+}
+
+// serveRPC handles the RPC request with the body as if it came in the HTTP request of its own, on behalf
+// of the client of r. The response is buffered for the caller to inspect.
+func serveRPC(next http.Handler, r *http.Request, body []byte) *bufferedResponse {
+	ir := r.Clone(r.Context())
+	ir.Method = http.MethodPost
+	ir.URL.Path = rpcPath
+	ir.URL.RawQuery = ""
+	ir.Body = io.NopCloser(bytes.NewReader(body))
+	ir.ContentLength = int64(len(body))
+	ir.Header.Set("Content-Type", "application/json")
+	ir.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	// responses are read by the proxy, so they must not be compressed
+	ir.Header.Del("Accept-Encoding")
+
+	br := &bufferedResponse{header: http.Header{}}
+	next.ServeHTTP(br, ir)
+	return br
+}
+
+// bufferedResponse buffers the response to RPC request made by serveRPC.
+type bufferedResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (b *bufferedResponse) Header() http.Header {
+	return b.header
+}
+
+func (b *bufferedResponse) WriteHeader(status int) {
+	if b.status == 0 {
+		b.status = status
+	}
+}
+
+func (b *bufferedResponse) Write(bs []byte) (int, error) {
+	b.WriteHeader(http.StatusOK)
+	return b.body.Write(bs)
+}
+
+// copyTo sends the buffered response as it is.
+func (b *bufferedResponse) copyTo(w http.ResponseWriter) {
+	for h, vals := range b.header {
+		w.Header()[h] = vals
+	}
+	w.WriteHeader(b.status)
+	_, _ = w.Write(b.body.Bytes())
+}
+
+// rpcResponse parses the buffered response to successful RPC request.
+func (b *bufferedResponse) rpcResponse() (*jrpc.Response, error) {
+	var resp jrpc.Response
+	if err := json.Unmarshal(b.body.Bytes(), &resp); err != nil {
+		return nil, fmt.Errorf("failed to parse RPC response: %w", err)
+	}
+
+	return &resp, nil
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"transmission-proxy/internal/jrpc"
+	"transmission-proxy/internal/logger"
+	"transmission-proxy/internal/ratelimit"
+	"transmission-proxy/internal/reqctx"
+	"transmission-proxy/internal/response"
+	"transmission-proxy/transmissionproxy"
+)
+
+// rpcPaths are the paths the RPC endpoint is served at. The first one is also the path of the upstream endpoint,
+// which requests arriving at the others are forwarded to.
+var rpcPaths = func() []string {
+	if paths := getListEnv("RPC_PATH", ""); len(paths) > 0 {
+		return paths
+	}
+
+	return []string{"/transmission/rpc"}
+}()
+
+// rpcRequestCheck rejects RPC requests not sent as application/json with RPC_REQUIRE_JSON_CONTENT_TYPE, and those
+// without Content-Type too with RPC_REJECT_MISSING_CONTENT_TYPE.
+var rpcRequestCheck = transmissionproxy.RequestCheck{
+	RequireJSONContentType:   getBoolEnv("RPC_REQUIRE_JSON_CONTENT_TYPE"),
+	RejectMissingContentType: getBoolEnv("RPC_REJECT_MISSING_CONTENT_TYPE"),
+}
+
+// rpcPathConfig overrides settings for requests arriving at one of RPC_PATH paths. It is read from the paths
+// section of VALIDATOR_CONFIG, e.g.
+//
+//	paths:
+//	  /automation/rpc:
+//	    allow_methods: [torrent-add, torrent-get, torrent-remove]
+//	    deny_methods: [torrent-remove]
+//	    download_prefix: /downloads/tv/
+//	    rate_limit: 5
+//	    burst: 10
+type rpcPathConfig struct {
+	// AllowMethods restricts the methods which may be called, all may if empty.
+	AllowMethods []string `yaml:"allow_methods"`
+	DenyMethods  []string `yaml:"deny_methods"`
+	// DownloadPrefix overrides DOWNLOAD_PREFIX.
+	DownloadPrefix string `yaml:"download_prefix"`
+	// RateLimit is the allowed average number of requests per second at the path, zero means unlimited.
+	RateLimit float64 `yaml:"rate_limit"`
+	Burst     int     `yaml:"burst"`
+}
+
+// loadRPCPathConfigs checks RPC_PATH and reads the overrides for its paths from VALIDATOR_CONFIG.
+func loadRPCPathConfigs() map[string]*rpcPathConfig {
+	cfgs, err := readRPCPathConfigs()
+	if err != nil {
+		slog.Error("invalid RPC_PATH configuration: "+err.Error(), logger.IgnoredAttr(err))
+		os.Exit(1)
+	}
+
+	return cfgs
+}
+
+func readRPCPathConfigs() (map[string]*rpcPathConfig, error) {
+	for i, p := range rpcPaths {
+		if !strings.HasPrefix(p, "/") {
+			return nil, fmt.Errorf("path %q must begin with /", p)
+		}
+		if slices.Contains(rpcPaths[:i], p) {
+			return nil, fmt.Errorf("path %q is listed twice", p)
+		}
+		if strings.HasPrefix(p, webPath) {
+			return nil, fmt.Errorf("path %q is under WEB_PATH", p)
+		}
+	}
+
+	var cfg struct {
+		Paths map[string]*rpcPathConfig `yaml:"paths"`
+	}
+	if validatorCfg != "" {
+		bs, err := os.ReadFile(validatorCfg)
+		if err != nil {
+			return nil, err
+		}
+		if err = yaml.Unmarshal(bs, &cfg); err != nil {
+			return nil, fmt.Errorf("parse %s: %w", validatorCfg, err)
+		}
+	}
+
+	for p, c := range cfg.Paths {
+		switch {
+		case !slices.Contains(rpcPaths, p):
+			return nil, fmt.Errorf("path %q is configured but not listed in RPC_PATH", p)
+		case c == nil:
+			return nil, fmt.Errorf("path %q: configuration is empty", p)
+		case c.RateLimit < 0:
+			return nil, fmt.Errorf("path %q: rate_limit must not be negative", p)
+		}
+		if c.DownloadPrefix != "" {
+			if err := checkPrefix(c.DownloadPrefix); err != nil {
+				return nil, fmt.Errorf("path %q: download_prefix %w", p, err)
+			}
+		}
+	}
+
+	return cfg.Paths, nil
+}
+
+// methodACL is a validation hook rejecting methods which may not be called at the path.
+func (c *rpcPathConfig) methodACL(path string) func(ctx context.Context, req *jrpc.Request) error {
+	return func(_ context.Context, req *jrpc.Request) error {
+		if slices.Contains(c.DenyMethods, req.Method) || (len(c.AllowMethods) > 0 && !slices.Contains(c.AllowMethods, req.Method)) {
+			return fmt.Errorf("method %s is not allowed at %s", req.Method, path)
+		}
+
+		return nil
+	}
+}
+
+// limiter returns the rate limiter of the path, or nil if it is not rate limited.
+func (c *rpcPathConfig) limiter() *ratelimit.Bucket {
+	if c == nil || c.RateLimit == 0 {
+		return nil
+	}
+
+	return ratelimit.NewBucket(c.RateLimit, c.Burst)
+}
+
+// rpcPathHandler serves RPC requests arriving at the path, rate limiting them if configured. Requests are
+// forwarded upstream to the primary RPC_PATH. When there are several paths, the one the request arrived at
+// is attached to its log records and audit record.
+func rpcPathHandler(rr *response.Responder, path string, l *ratelimit.Bucket, next http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		if len(rpcPaths) > 1 {
+			ctx = reqctx.WithRPCPath(ctx, path)
+			ctx = logger.ContextWithAttrs(ctx, logger.HTTPPath(path))
+		}
+
+		if l != nil {
+			if ok, retryAfter := l.Allow(); !ok {
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+				rr.RespondAndLogCustom(w, ctx, fmt.Errorf("rate limit of %s exceeded", path), 0, slog.LevelWarn, http.StatusTooManyRequests)
+				return
+			}
+		}
+
+		r = r.WithContext(ctx)
+		if !rpcRequestCheck.Allow(rr, w, r) {
+			return
+		}
+		if path != rpcPath {
+			u := *r.URL
+			u.Path, u.RawPath = rpcPath, ""
+			r.URL = &u
+		}
+
+		next.ServeHTTP(w, r)
+	}
+}
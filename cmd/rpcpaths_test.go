@@ -0,0 +1,210 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"transmission-proxy/internal/audit"
+	"transmission-proxy/internal/response"
+)
+
+// setRPCPaths makes the proxy serve RPC at the paths for the test.
+func setRPCPaths(t *testing.T, paths ...string) {
+	prevPaths, prevPath := rpcPaths, rpcPath
+	t.Cleanup(func() { rpcPaths, rpcPath = prevPaths, prevPath })
+	rpcPaths, rpcPath = paths, paths[0]
+}
+
+func TestReadRPCPathConfigs(t *testing.T) {
+	defer func(prev string) { validatorCfg = prev }(validatorCfg)
+	setRPCPaths(t, "/transmission/rpc", "/automation/rpc")
+
+	validatorCfg = filepath.Join(t.TempDir(), "validator.yaml")
+	write := func(yaml string) {
+		if err := os.WriteFile(validatorCfg, []byte(yaml), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write("paths:\n  /automation/rpc:\n    allow_methods: [torrent-add, torrent-get]\n    download_prefix: /downloads/tv/\n" +
+		"    rate_limit: 0.5\n    burst: 3\n")
+	cfgs, err := readRPCPathConfigs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := cfgs["/automation/rpc"]; len(cfgs) != 1 || c == nil || c.DownloadPrefix != "/downloads/tv/" || c.RateLimit != 0.5 ||
+		c.Burst != 3 || strings.Join(c.AllowMethods, ",") != "torrent-add,torrent-get" {
+		t.Errorf("got configs %v", cfgs)
+	}
+
+	cases := []struct {
+		paths []string
+		yaml  string
+		err   string
+	}{
+		{paths: []string{"transmission/rpc"}, err: `path "transmission/rpc" must begin with /`},
+		{paths: []string{"/transmission/rpc", "/transmission/rpc"}, err: `path "/transmission/rpc" is listed twice`},
+		{paths: []string{"/transmission/rpc", "/transmission/web/rpc"}, err: `path "/transmission/web/rpc" is under WEB_PATH`},
+		{yaml: "paths:\n  /other/rpc:\n    rate_limit: 1\n", err: `path "/other/rpc" is configured but not listed in RPC_PATH`},
+		{yaml: "paths:\n  /automation/rpc:\n", err: `path "/automation/rpc": configuration is empty`},
+		{yaml: "paths:\n  /automation/rpc:\n    rate_limit: -1\n", err: "rate_limit must not be negative"},
+		{yaml: "paths:\n  /automation/rpc:\n    download_prefix: downloads\n", err: `path "/automation/rpc": download_prefix`},
+		{yaml: "paths: [", err: "parse " + validatorCfg},
+	}
+	for _, tc := range cases {
+		setRPCPaths(t, "/transmission/rpc", "/automation/rpc")
+		if tc.paths != nil {
+			setRPCPaths(t, tc.paths...)
+		}
+		write(orDefault(tc.yaml, "{}"))
+		if _, err := readRPCPathConfigs(); err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("%v %q: got error %v, want %q", tc.paths, tc.yaml, err, tc.err)
+		}
+	}
+}
+
+// testRPCPaths returns the handler serving RPC at the main path and at the automation one restricted by
+// the configuration, as main does, with the requests they forwarded and the audit log.
+func testRPCPaths(t *testing.T, automation *rpcPathConfig) (http.Handler, *[]string, string) {
+	setRPCPaths(t, "/transmission/rpc", "/automation/rpc")
+	pathConfigs := map[string]*rpcPathConfig{"/automation/rpc": automation}
+
+	auditPath := filepath.Join(t.TempDir(), "audit.log")
+	al, err := audit.Open(auditPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = al.Close() })
+
+	var forwarded []string
+	upstream := upstreamFunc(func(r *http.Request) (*http.Response, error) {
+		bs, err := io.ReadAll(r.Body)
+		if err != nil {
+			return nil, err
+		}
+		forwarded = append(forwarded, r.URL.Path+" "+string(bs))
+		return upstreamStatus(http.StatusOK, `{"arguments":{},"result":"success"}`)(r)
+	})
+
+	rr := &response.Responder{DebugMode: true}
+	mux := http.NewServeMux()
+	for _, path := range rpcPaths {
+		v := buildValidator("/downloads/")
+		pc := pathConfigs[path]
+		if pc != nil {
+			v = buildValidator(orDefault(pc.DownloadPrefix, "/downloads/"))
+			v.RegisterPreValidateHook(pc.methodACL(path))
+		}
+		rpc := testRPCProxy(upstream, func(cfg *rpcProxyConfig) {
+			cfg.validator, cfg.audit, cfg.responder = v, al, rr
+		})
+		mux.Handle(path, rpcPathHandler(rr, path, pc.limiter(), rpc))
+	}
+
+	return mux, &forwarded, auditPath
+}
+
+func postRPCAt(h http.Handler, path, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+
+	return w
+}
+
+func TestRPCPaths(t *testing.T) {
+	logs := captureLog(t)
+	h, forwarded, auditPath := testRPCPaths(t, &rpcPathConfig{
+		AllowMethods:   []string{"torrent-add", "torrent-get", "torrent-remove"},
+		DenyMethods:    []string{"torrent-remove"},
+		DownloadPrefix: "/downloads/tv/",
+	})
+
+	cases := []struct {
+		name, body     string
+		main, automate int
+		err            string
+	}{
+		{name: "allowed", body: `{"method":"torrent-add","arguments":{"filename":"magnet:?xt=urn:btih:aaaa","download-dir":"/downloads/tv/show"}}`,
+			main: http.StatusOK, automate: http.StatusOK},
+		{name: "denied", body: `{"method":"torrent-remove","arguments":{"ids":[1]}}`,
+			main: http.StatusOK, automate: http.StatusBadRequest, err: "method torrent-remove is not allowed at /automation/rpc"},
+		{name: "not allowed", body: `{"method":"session-set","arguments":{"speed-limit-down":100}}`,
+			main: http.StatusOK, automate: http.StatusBadRequest, err: "method session-set is not allowed at /automation/rpc"},
+		{name: "outside prefix", body: `{"method":"torrent-add","arguments":{"filename":"magnet:?xt=urn:btih:aaaa","download-dir":"/downloads/movies"}}`,
+			main: http.StatusOK, automate: http.StatusBadRequest, err: "forbidden location"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if w := postRPCAt(h, "/transmission/rpc", tc.body); w.Code != tc.main {
+				t.Errorf("main path: got status %d, body %s", w.Code, w.Body)
+			}
+			if w := postRPCAt(h, "/automation/rpc", tc.body); w.Code != tc.automate || !strings.Contains(w.Body.String(), tc.err) {
+				t.Errorf("automation path: got status %d, body %s", w.Code, w.Body)
+			}
+		})
+	}
+
+	// requests at either path go to the upstream endpoint
+	if len(*forwarded) != len(cases)+1 {
+		t.Fatalf("got %d requests forwarded, want %d", len(*forwarded), len(cases)+1)
+	}
+	for _, f := range *forwarded {
+		if !strings.HasPrefix(f, "/transmission/rpc ") {
+			t.Errorf("forwarded %s", f)
+		}
+	}
+
+	// the path is recorded in the logs and audit records
+	if rec := logRecord(t, logs, "not allowed at /automation/rpc"); rec["http"].(map[string]any)["path"] != "/automation/rpc" {
+		t.Errorf("got log record %v", rec)
+	}
+	bs, err := os.ReadFile(auditPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var paths []string
+	for _, line := range strings.Split(strings.TrimSpace(string(bs)), "\n") {
+		var rec audit.Record
+		if err := json.Unmarshal([]byte(line), &rec); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, rec.Method+" "+rec.Path)
+	}
+	want := "torrent-add /transmission/rpc,torrent-add /automation/rpc,torrent-remove /transmission/rpc,torrent-remove /automation/rpc," +
+		"session-set /transmission/rpc,session-set /automation/rpc,torrent-add /transmission/rpc,torrent-add /automation/rpc"
+	if got := strings.Join(paths, ","); got != want {
+		t.Errorf("got audit records\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestRPCPathRateLimit(t *testing.T) {
+	captureLog(t)
+	h, forwarded, _ := testRPCPaths(t, &rpcPathConfig{RateLimit: 0.001, Burst: 2})
+
+	const body = `{"method":"torrent-get","arguments":{"fields":["id"]}}`
+	for i := 0; i < 2; i++ {
+		if w := postRPCAt(h, "/automation/rpc", body); w.Code != http.StatusOK {
+			t.Fatalf("request #%d: got status %d", i+1, w.Code)
+		}
+	}
+	w := postRPCAt(h, "/automation/rpc", body)
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" || !strings.Contains(strings.ToLower(w.Body.String()), "rate limit of /automation/rpc exceeded") {
+		t.Errorf("over the limit: got status %d, body %s", w.Code, w.Body)
+	}
+
+	// the other path has limits of its own
+	if w := postRPCAt(h, "/transmission/rpc", body); w.Code != http.StatusOK {
+		t.Errorf("main path: got status %d", w.Code)
+	}
+	if len(*forwarded) != 3 {
+		t.Errorf("got %d requests forwarded, want 3", len(*forwarded))
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+	_ "time/tzdata"
+
+	"transmission-proxy/internal/audit"
+	"transmission-proxy/internal/logger"
+	"transmission-proxy/internal/schedule"
+	"transmission-proxy/internal/upstream"
+)
+
+var scheduleFile = os.Getenv("SCHEDULE_FILE")
+
+// startScheduler starts making the RPC calls of SCHEDULE_FILE at the scheduled times in SCHEDULE_TZ.
+func startScheduler(uc *upstream.Client, al *audit.Log) {
+	cfg, err := schedule.Load(scheduleFile)
+	if err != nil {
+		slog.Error("failed to load SCHEDULE_FILE: "+err.Error(), logger.IgnoredAttr(err))
+		os.Exit(1)
+	}
+
+	loc, err := time.LoadLocation(getEnvOrDefault("SCHEDULE_TZ", "Local"))
+	if err != nil {
+		slog.Error("failed to load SCHEDULE_TZ: "+err.Error(), logger.IgnoredAttr(err))
+		os.Exit(1)
+	}
+
+	s := &schedule.Scheduler{
+		Config:   cfg,
+		Upstream: uc,
+		Location: loc,
+		DryRun:   getBoolEnv("SCHEDULE_DRY_RUN"),
+		Audit:    al,
+	}
+
+	slog.Info(fmt.Sprintf("loaded %d scheduled calls from SCHEDULE_FILE", len(cfg.Entries)))
+	go s.Run(context.Background())
+}
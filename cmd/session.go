@@ -0,0 +1,6 @@
+package main
+
+// sessionPassthrough leaves negotiating Transmission session id to the clients. Otherwise the proxy sends
+// the session id it saw last and retries requests answered with 409 once with the new id, so that clients
+// rarely see 409 at all.
+var sessionPassthrough = getBoolEnv("SESSION_ID_PASSTHROUGH")
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"transmission-proxy/internal/logger"
+)
+
+// shutdownTimeout is how long in-flight requests are waited for on SIGINT or SIGTERM before the remaining
+// connections are closed.
+var shutdownTimeout = getDurationEnv("SHUTDOWN_TIMEOUT", 10*time.Second)
+
+// serve runs the server, over TLS if it has TLSConfig, until it fails or SIGINT or SIGTERM is received. On a signal the server stops accepting
+// new connections and waits for in-flight requests up to shutdownTimeout.
+func serve(srv *http.Server) error {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(ch)
+
+	errc := make(chan error, 1)
+	go func() {
+		if srv.TLSConfig != nil {
+			// the certificate is provided by TLSConfig
+			errc <- srv.ListenAndServeTLS("", "")
+		} else {
+			errc <- srv.ListenAndServe()
+		}
+	}()
+
+	select {
+	case err := <-errc:
+		return err
+	case sig := <-ch:
+		slog.Info("shutting down", slog.String("signal", sig.String()), slog.String("timeout", shutdownTimeout.String()))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	if err := srv.Shutdown(ctx); err != nil {
+		_ = srv.Close()
+		return fmt.Errorf("requests did not complete in time: %w", err)
+	}
+
+	if err := <-errc; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+
+	slog.Info("in-flight requests completed")
+	return nil
+}
+
+// closeOnShutdown closes the resource once the server stopped, logging the failure if any.
+func closeOnShutdown(name string, close func() error) {
+	if err := close(); err != nil {
+		slog.Error("failed to close "+name+": "+err.Error(), logger.IgnoredAttr(err))
+	}
+}
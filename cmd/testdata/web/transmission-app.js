@@ -0,0 +1,11 @@
+/*! Transmission web interface (excerpt) */
+class Remote {
+  constructor(controller) {
+    this._controller = controller;
+    this._url = "/transmission/rpc";
+  }
+}
+const WEB_ROOT = '/transmission/web/';
+const ICONS = `/transmission/web/images/`;
+const upload = (form) => fetch("/transmission/upload", { method: "POST", body: form });
+const docs = "https://github.com/transmission/transmission/blob/main/docs/rpc-spec.md";
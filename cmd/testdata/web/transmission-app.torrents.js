@@ -0,0 +1,11 @@
+/*! Transmission web interface (excerpt) */
+class Remote {
+  constructor(controller) {
+    this._controller = controller;
+    this._url = "/torrents/transmission/rpc";
+  }
+}
+const WEB_ROOT = '/torrents/transmission/web/';
+const ICONS = `/torrents/transmission/web/images/`;
+const upload = (form) => fetch("/transmission/upload", { method: "POST", body: form });
+const docs = "https://github.com/transmission/transmission/blob/main/docs/rpc-spec.md";
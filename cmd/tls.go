@@ -0,0 +1,185 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"log/slog"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"transmission-proxy/internal/logger"
+)
+
+var (
+	tlsCertFile = os.Getenv("TLS_CERT_FILE")
+	tlsKeyFile  = os.Getenv("TLS_KEY_FILE")
+
+	upstreamCAFile             = os.Getenv("UPSTREAM_CA_FILE")
+	upstreamInsecureSkipVerify = getBoolEnv("UPSTREAM_INSECURE_SKIP_VERIFY")
+)
+
+// certPollInterval is how often the certificate files are checked for changes.
+const certPollInterval = 10 * time.Second
+
+// serverTLSConfig returns TLS configuration of the listener serving the certificate from TLS_CERT_FILE and
+// TLS_KEY_FILE, or nil if they are not set.
+func serverTLSConfig() *tls.Config {
+	switch {
+	case tlsCertFile == "" && tlsKeyFile == "":
+		return nil
+	case tlsCertFile == "" || tlsKeyFile == "":
+		slog.Error("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+		os.Exit(1)
+	}
+
+	cr := &certReloader{}
+	if err := cr.load(); err != nil {
+		slog.Error("failed to load TLS_CERT_FILE and TLS_KEY_FILE: "+err.Error(), logger.IgnoredAttr(err))
+		os.Exit(1)
+	}
+	cr.reloadOnChange()
+
+	return &tls.Config{GetCertificate: cr.certificate, MinVersion: tls.VersionTLS12}
+}
+
+// certReloader holds the certificate of the listener, so that renewed certificates are served without restart.
+type certReloader struct {
+	mu   sync.RWMutex
+	cert *tls.Certificate
+	// mtime is the modification time of the newer of the files
+	mtime time.Time
+}
+
+func (c *certReloader) certificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.cert, nil
+}
+
+func (c *certReloader) load() error {
+	mtime, err := certModTime()
+	if err != nil {
+		return err
+	}
+
+	cert, err := tls.LoadX509KeyPair(tlsCertFile, tlsKeyFile)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.cert, c.mtime = &cert, mtime
+	return nil
+}
+
+func (c *certReloader) loadIfChanged() (reloaded bool, err error) {
+	mtime, err := certModTime()
+	if err != nil {
+		return false, err
+	}
+
+	c.mu.RLock()
+	changed := !mtime.Equal(c.mtime)
+	c.mu.RUnlock()
+
+	if !changed {
+		return false, nil
+	}
+
+	return true, c.load()
+}
+
+// reloadOnChange re-reads the certificate when the files change or on SIGHUP. Failed reloads keep
+// the previously loaded certificate.
+func (c *certReloader) reloadOnChange() {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGHUP)
+
+	go func() {
+		ticker := time.NewTicker(certPollInterval)
+		defer ticker.Stop()
+
+		for {
+			var (
+				reloaded bool
+				err      error
+			)
+
+			select {
+			case <-ch:
+				reloaded, err = true, c.load()
+			case <-ticker.C:
+				reloaded, err = c.loadIfChanged()
+			}
+
+			if err != nil {
+				slog.Error("failed to reload TLS_CERT_FILE and TLS_KEY_FILE: "+err.Error(), logger.IgnoredAttr(err))
+			} else if reloaded {
+				slog.Info("reloaded TLS certificate")
+			}
+		}
+	}()
+}
+
+func certModTime() (time.Time, error) {
+	var mtime time.Time
+	for _, name := range []string{tlsCertFile, tlsKeyFile} {
+		fi, err := os.Stat(name)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if fi.ModTime().After(mtime) {
+			mtime = fi.ModTime()
+		}
+	}
+
+	return mtime, nil
+}
+
+// upstreamTLSConfig returns TLS configuration of requests to UPSTREAM_HOST, trusting the certificates
+// from UPSTREAM_CA_FILE in addition to the system ones, or any certificate with UPSTREAM_INSECURE_SKIP_VERIFY.
+// It returns nil if neither is set.
+func upstreamTLSConfig() *tls.Config {
+	if upstreamCAFile == "" && !upstreamInsecureSkipVerify {
+		return nil
+	}
+
+	cfg := &tls.Config{InsecureSkipVerify: upstreamInsecureSkipVerify}
+	if upstreamCAFile != "" {
+		pool, err := loadCAFile(upstreamCAFile)
+		if err != nil {
+			slog.Error("failed to load UPSTREAM_CA_FILE: "+err.Error(), logger.IgnoredAttr(err))
+			os.Exit(1)
+		}
+		cfg.RootCAs = pool
+	}
+	if upstreamInsecureSkipVerify {
+		slog.Warn("UPSTREAM_INSECURE_SKIP_VERIFY is set, certificate of UPSTREAM_HOST is not verified")
+	}
+
+	return cfg
+}
+
+func loadCAFile(name string) (*x509.CertPool, error) {
+	bs, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(bs) {
+		return nil, errors.New("no PEM certificates found")
+	}
+
+	return pool, nil
+}
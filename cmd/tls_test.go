@@ -0,0 +1,215 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// writeCert writes the self-signed certificate of 127.0.0.1 with the serial number and its key
+// to TLS_CERT_FILE and TLS_KEY_FILE, returning the certificate.
+func writeCert(t *testing.T, serial int64) *x509.Certificate {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IsCA:         true,
+
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(tlsCertFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(tlsKeyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	// the files are rewritten within the resolution of modification times
+	mtime := time.Now().Add(time.Duration(serial) * time.Second)
+	for _, name := range []string{tlsCertFile, tlsKeyFile} {
+		if err := os.Chtimes(name, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cert, _ := x509.ParseCertificate(der)
+	return cert
+}
+
+// setCertFiles points TLS_CERT_FILE and TLS_KEY_FILE to the temporary directory of the test.
+func setCertFiles(t *testing.T) {
+	cert, key := tlsCertFile, tlsKeyFile
+	t.Cleanup(func() { tlsCertFile, tlsKeyFile = cert, key })
+
+	dir := t.TempDir()
+	tlsCertFile, tlsKeyFile = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+}
+
+func servedSerial(t *testing.T, cr *certReloader) int64 {
+	t.Helper()
+
+	cert, err := cr.certificate(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return leaf.SerialNumber.Int64()
+}
+
+func TestCertReloader(t *testing.T) {
+	setCertFiles(t)
+	writeCert(t, 1)
+
+	cr := &certReloader{}
+	if err := cr.load(); err != nil {
+		t.Fatal(err)
+	}
+	if reloaded, err := cr.loadIfChanged(); reloaded || err != nil {
+		t.Errorf("unchanged files: got reloaded %v, error %v", reloaded, err)
+	}
+
+	// the renewed certificate replaces the loaded one
+	writeCert(t, 2)
+	if reloaded, err := cr.loadIfChanged(); !reloaded || err != nil {
+		t.Fatalf("renewed files: got reloaded %v, error %v", reloaded, err)
+	}
+	if n := servedSerial(t, cr); n != 2 {
+		t.Errorf("got certificate %d, want the renewed one", n)
+	}
+
+	// the broken pair keeps the previous certificate in use
+	if err := os.WriteFile(tlsKeyFile, []byte("garbage"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	mtime := time.Now().Add(time.Minute)
+	_ = os.Chtimes(tlsKeyFile, mtime, mtime)
+	if _, err := cr.loadIfChanged(); err == nil {
+		t.Error("got no error for broken key")
+	}
+	if n := servedSerial(t, cr); n != 2 {
+		t.Errorf("got certificate %d after failed reload, want the previous one", n)
+	}
+
+	// missing files are an error too
+	tlsCertFile = filepath.Join(filepath.Dir(tlsCertFile), "missing.pem")
+	if err := cr.load(); err == nil {
+		t.Error("got no error for missing certificate")
+	}
+}
+
+func TestServerTLSReload(t *testing.T) {
+	captureLog(t)
+	setCertFiles(t)
+	// the client trusts the certificates of the test only
+	pool := x509.NewCertPool()
+	pool.AddCert(writeCert(t, 1))
+
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", serverTLSConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &http.Server{Handler: http.HandlerFunc(func(http.ResponseWriter, *http.Request) {})}
+	go func() { _ = srv.Serve(ln) }()
+	t.Cleanup(func() { _ = srv.Close() })
+
+	// a connection per request gets the certificate served at the time
+	serial := func() int64 {
+		t.Helper()
+
+		c := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}, DisableKeepAlives: true}}
+		resp, err := c.Get("https://" + ln.Addr().String() + "/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = resp.Body.Close()
+
+		return resp.TLS.PeerCertificates[0].SerialNumber.Int64()
+	}
+	if n := serial(); n != 1 {
+		t.Fatalf("got certificate %d", n)
+	}
+
+	// the renewed certificate is served after SIGHUP
+	pool.AddCert(writeCert(t, 2))
+	if err := syscall.Kill(os.Getpid(), syscall.SIGHUP); err != nil {
+		t.Fatal(err)
+	}
+	for deadline := time.Now().Add(5 * time.Second); serial() != 2; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("renewed certificate not served")
+		}
+	}
+}
+
+func TestUpstreamTLSConfig(t *testing.T) {
+	defer func(f string, skip bool) { upstreamCAFile, upstreamInsecureSkipVerify = f, skip }(upstreamCAFile, upstreamInsecureSkipVerify)
+	captureLog(t)
+
+	upstreamCAFile, upstreamInsecureSkipVerify = "", false
+	if cfg := upstreamTLSConfig(); cfg != nil {
+		t.Errorf("got %+v, want the defaults", cfg)
+	}
+
+	upstreamInsecureSkipVerify = true
+	if cfg := upstreamTLSConfig(); cfg == nil || !cfg.InsecureSkipVerify || cfg.RootCAs != nil {
+		t.Errorf("insecure: got %+v", cfg)
+	}
+
+	setCertFiles(t)
+	cert := writeCert(t, 1)
+	upstreamCAFile, upstreamInsecureSkipVerify = tlsCertFile, false
+	cfg := upstreamTLSConfig()
+	if cfg == nil || cfg.InsecureSkipVerify {
+		t.Fatalf("CA file: got %+v", cfg)
+	}
+	if _, err := cert.Verify(x509.VerifyOptions{Roots: cfg.RootCAs}); err != nil {
+		t.Errorf("certificate of the CA file not trusted: %v", err)
+	}
+}
+
+func TestLoadCAFile(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "ca.pem")
+	if err := os.WriteFile(name, []byte("not a certificate"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := loadCAFile(name); err == nil || err.Error() != "no PEM certificates found" {
+		t.Errorf("got error %v", err)
+	}
+	if _, err := loadCAFile(filepath.Join(dir, "missing.pem")); err == nil {
+		t.Error("got no error for missing file")
+	}
+}
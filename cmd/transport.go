@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"transmission-proxy/internal/stats"
+)
+
+var (
+	upstreamDialTimeout           = getDurationEnv("UPSTREAM_DIAL_TIMEOUT", 5*time.Second)
+	upstreamTLSHandshakeTimeout   = getDurationEnv("UPSTREAM_TLS_HANDSHAKE_TIMEOUT", 10*time.Second)
+	upstreamResponseHeaderTimeout = getDurationEnv("UPSTREAM_RESPONSE_HEADER_TIMEOUT", 0)
+	upstreamIdleConnTimeout       = getDurationEnv("UPSTREAM_IDLE_CONN_TIMEOUT", 90*time.Second)
+	upstreamMaxIdleConns          = int(getSizeEnv("UPSTREAM_MAX_IDLE_CONNS", 32))
+	// upstreamRPCTimeout bounds RPC requests including their response bodies. Other requests, e.g. for assets
+	// of the web interface, are only ended by the client going away.
+	upstreamRPCTimeout = getDurationEnv("UPSTREAM_RPC_TIMEOUT", 30*time.Second)
+	rpcMethodTimeouts  = getMethodTimeouts()
+)
+
+// methodTimeoutPrefix starts the names of variables overriding UPSTREAM_RPC_TIMEOUT for single method,
+// e.g. RPC_TIMEOUT_blocklist-update or RPC_TIMEOUT_BLOCKLIST_UPDATE.
+const methodTimeoutPrefix = "RPC_TIMEOUT_"
+
+// getMethodTimeouts returns the timeouts of RPC requests set per method, by method name.
+func getMethodTimeouts() map[string]time.Duration {
+	timeouts := map[string]time.Duration{}
+	for _, kv := range os.Environ() {
+		key, _, _ := strings.Cut(kv, "=")
+		name, ok := strings.CutPrefix(key, methodTimeoutPrefix)
+		if !ok {
+			continue
+		}
+
+		// method names are lowercase with dashes, which shells do not allow in variable names
+		method := strings.ReplaceAll(strings.ToLower(name), "_", "-")
+		timeouts[method] = getDurationEnv(key, upstreamRPCTimeout)
+	}
+
+	return timeouts
+}
+
+// rpcTimeout returns the time the RPC request for the method may take.
+func rpcTimeout(method string) time.Duration {
+	if d, ok := rpcMethodTimeouts[method]; ok {
+		return d
+	}
+
+	return upstreamRPCTimeout
+}
+
+// upstreamTransport returns the transport of requests to UPSTREAM_HOST, recording its connections in u. All of
+// them go to the same host, so the idle connections kept are not limited per host separately. HTTP/2 is used
+// with upstreams supporting it over TLS.
+func upstreamTransport(u *stats.Upstream) http.RoundTripper {
+	d := &net.Dialer{Timeout: upstreamDialTimeout, KeepAlive: 30 * time.Second}
+
+	t := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			conn, err := d.DialContext(ctx, network, addr)
+			if err != nil {
+				return nil, err
+			}
+
+			u.ConnOpened()
+			return &countedConn{Conn: conn, u: u}, nil
+		},
+		ForceAttemptHTTP2:     true,
+		TLSClientConfig:       upstreamTLSConfig(),
+		TLSHandshakeTimeout:   upstreamTLSHandshakeTimeout,
+		ResponseHeaderTimeout: upstreamResponseHeaderTimeout,
+		ExpectContinueTimeout: time.Second,
+		IdleConnTimeout:       upstreamIdleConnTimeout,
+		MaxIdleConns:          upstreamMaxIdleConns,
+		MaxIdleConnsPerHost:   upstreamMaxIdleConns,
+	}
+
+	return &connTracker{t: t, u: u}
+}
+
+// countedConn records its closing once.
+type countedConn struct {
+	net.Conn
+	u      *stats.Upstream
+	closed atomic.Bool
+}
+
+func (c *countedConn) Close() error {
+	if !c.closed.Swap(true) {
+		c.u.ConnClosed()
+	}
+
+	return c.Conn.Close()
+}
+
+// connTracker records which requests reuse connections and how many connections are serving requests.
+// The connection is in use until the response body is closed.
+type connTracker struct {
+	t http.RoundTripper
+	u *stats.Upstream
+}
+
+func (c *connTracker) RoundTrip(r *http.Request) (*http.Response, error) {
+	var acquired atomic.Bool
+	release := func() {
+		if acquired.Swap(false) {
+			c.u.ConnReleased()
+		}
+	}
+
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			// redirects and retries get connection again
+			release()
+			acquired.Store(true)
+			c.u.ConnAcquired(info.Reused)
+		},
+	}
+
+	resp, err := c.t.RoundTrip(r.WithContext(httptrace.WithClientTrace(r.Context(), trace)))
+	if err != nil {
+		release()
+		return nil, err
+	}
+
+	resp.Body = &releasingBody{ReadCloser: resp.Body, release: release}
+	return resp, nil
+}
+
+type releasingBody struct {
+	io.ReadCloser
+	release func()
+}
+
+func (b *releasingBody) Close() error {
+	b.release()
+	return b.ReadCloser.Close()
+}
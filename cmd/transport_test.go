@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"transmission-proxy/internal/events"
+	"transmission-proxy/internal/logger"
+	"transmission-proxy/internal/response"
+	"transmission-proxy/internal/stats"
+	"transmission-proxy/internal/transmissiontest"
+	"transmission-proxy/internal/upstream"
+	"transmission-proxy/transmissionproxy"
+)
+
+// slowUpstream starts upstream answering only at the end of the test, reporting the requests
+// it stopped waiting for because they were canceled.
+func slowUpstream(t *testing.T) (*url.URL, <-chan string) {
+	release := make(chan struct{})
+	s := &transmissiontest.Server{Hold: release}
+	u := s.Start(t)
+	// the held requests return first
+	t.Cleanup(func() { close(release) })
+
+	return u, s.Canceled()
+}
+
+// testTransportProxy returns the RPC proxy and the web handler forwarding to the upstream through upstreamTransport.
+func testTransportProxy(u *url.URL) (http.Handler, http.Handler) {
+	st := stats.NewRegistry()
+	rr := &response.Responder{DebugMode: true}
+	gw := transmissionproxy.Forward(transmissionproxy.ForwardConfig{
+		Upstream:  u,
+		Client:    &http.Client{Transport: upstreamTransport(st.Upstream(u.Host))},
+		Stats:     st,
+		Responder: rr,
+	})
+
+	return rpcProxy(gw, rpcProxyConfig{
+		validator: buildValidator("/downloads/"),
+		events:    events.NewBus(),
+		drain:     &drainMode{},
+		responder: rr,
+		stats:     st,
+	}), gw
+}
+
+func TestRPCTimeout(t *testing.T) {
+	defer func(d time.Duration, m map[string]time.Duration) { upstreamRPCTimeout, rpcMethodTimeouts = d, m }(upstreamRPCTimeout, rpcMethodTimeouts)
+	upstreamRPCTimeout = 50 * time.Millisecond
+	rpcMethodTimeouts = map[string]time.Duration{}
+
+	u, canceled := slowUpstream(t)
+	h, _ := testTransportProxy(u)
+	logs := captureLog(t)
+
+	w := postRPC(h, `{"method":"session-get","tag":6}`)
+	if w.Code != http.StatusGatewayTimeout || !strings.Contains(w.Body.String(), `"tag":6`) {
+		t.Errorf("got status %d, body %s", w.Code, w.Body)
+	}
+	select {
+	case <-canceled:
+	case <-time.After(5 * time.Second):
+		t.Error("upstream request not canceled")
+	}
+
+	rec := logRecord(t, logs, "upstream error")
+	if attrs, _ := rec[logger.GroupErr].(map[string]any); attrs[logger.KeyClass] != upstream.ClassTimeout || rec["level"] != "ERROR" {
+		t.Errorf("got record %v", rec)
+	}
+}
+
+func TestClientCancelAbortsUpstream(t *testing.T) {
+	u, canceled := slowUpstream(t)
+	rpc, web := testTransportProxy(u)
+	logs := captureLog(t)
+
+	for _, tc := range []struct {
+		h      http.Handler
+		target string
+	}{
+		{h: rpc, target: rpcPath},
+		// web requests have no timeout of their own, they end with the client
+		{h: web, target: "/transmission/web/index.html"},
+	} {
+		ctx, cancel := context.WithCancel(context.Background())
+		r := httptest.NewRequest(http.MethodPost, tc.target, strings.NewReader(`{"method":"session-get"}`)).WithContext(ctx)
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			tc.h.ServeHTTP(httptest.NewRecorder(), r)
+		}()
+
+		// the browser tab is closed while the upstream is still busy
+		time.Sleep(20 * time.Millisecond)
+		cancel()
+
+		select {
+		case path := <-canceled:
+			if path != tc.target {
+				t.Errorf("got canceled request for %s, want %s", path, tc.target)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s: upstream request not canceled", tc.target)
+		}
+		<-done
+	}
+
+	// clients going away are no errors of the upstream
+	if strings.Contains(logs.String(), `"level":"ERROR"`) {
+		t.Errorf("cancellation logged as error:\n%s", logs)
+	}
+}
+
+func TestMethodTimeouts(t *testing.T) {
+	defer func(d time.Duration) { upstreamRPCTimeout = d }(upstreamRPCTimeout)
+	upstreamRPCTimeout = 30 * time.Second
+
+	t.Setenv("RPC_TIMEOUT_BLOCKLIST_UPDATE", "5m")
+	t.Setenv("RPC_TIMEOUT_torrent-get", "")
+	t.Setenv("RPC_TIMEOUT_session_get", "2s")
+
+	defer func(m map[string]time.Duration) { rpcMethodTimeouts = m }(rpcMethodTimeouts)
+	rpcMethodTimeouts = getMethodTimeouts()
+
+	for method, want := range map[string]time.Duration{
+		"blocklist-update": 5 * time.Minute,
+		"session-get":      2 * time.Second,
+		// empty value keeps the default
+		"torrent-get": 30 * time.Second,
+		"torrent-add": 30 * time.Second,
+	} {
+		if got := rpcTimeout(method); got != want {
+			t.Errorf("%s: got timeout %s, want %s", method, got, want)
+		}
+	}
+}
+
+// getAll requests the paths from the upstream one by one, reading the responses whole as the proxy does.
+func getAll(t *testing.T, c *http.Client, base string, paths ...string) {
+	t.Helper()
+
+	for _, p := range paths {
+		resp, err := c.Get(base + p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _ = io.Copy(io.Discard, resp.Body)
+		_ = resp.Body.Close()
+	}
+}
+
+// peers returns the number of connections the requests to the upstream were made over.
+func peers(s *transmissiontest.Server) int {
+	addrs := map[string]bool{}
+	for _, r := range s.Requests() {
+		addrs[r.RemoteAddr] = true
+	}
+
+	return len(addrs)
+}
+
+func TestUpstreamConnectionReuse(t *testing.T) {
+	release := make(chan struct{}, 20)
+	srv := &transmissiontest.Server{Hold: release}
+	u := srv.Start(t)
+	base := "http://" + u.Host
+	st := stats.NewRegistry()
+	c := &http.Client{Transport: upstreamTransport(st.Upstream(u.Host))}
+
+	// the assets of the web interface loaded one after another share the connection
+	paths := make([]string, 20)
+	for i := range paths {
+		paths[i] = "/transmission/web/asset" + strconv.Itoa(i) + ".css"
+		release <- struct{}{}
+	}
+	getAll(t, c, base, paths...)
+	if n := peers(srv); n != 1 {
+		t.Errorf("got %d connections for sequential requests, want 1", n)
+	}
+	s := st.Upstreams()[u.Host]
+	if s.ConnsNew != 1 || s.ConnsReused != 19 || s.ConnsActive != 0 || s.ConnsIdle != 1 {
+		t.Errorf("got %+v", s)
+	}
+
+	// concurrent requests need connections of their own, which are kept for the next burst
+	burst := func() {
+		sent := len(srv.Requests())
+		var wg sync.WaitGroup
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				resp, err := c.Get(base + "/transmission/web/")
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				_, _ = io.Copy(io.Discard, resp.Body)
+				_ = resp.Body.Close()
+			}()
+		}
+		srv.WaitRequests(t, sent+4)
+		if s := st.Upstreams()[u.Host]; s.ConnsActive != 4 || s.ConnsIdle != 0 {
+			t.Errorf("during burst: got %+v", s)
+		}
+		for i := 0; i < 4; i++ {
+			release <- struct{}{}
+		}
+		wg.Wait()
+	}
+	burst()
+	burst()
+
+	s = st.Upstreams()[u.Host]
+	if n := peers(srv); n != 4 || s.ConnsNew != 4 || s.ConnsReused != 19+1+4 || s.ConnsActive != 0 || s.ConnsIdle != 4 {
+		t.Errorf("after bursts: got %d connections, %+v", n, s)
+	}
+
+	var buf bytes.Buffer
+	if err := st.WritePrometheus(&buf); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		fmt.Sprintf(`transmission_proxy_upstream_connections{upstream=%q,state="idle"} 4`, u.Host),
+		fmt.Sprintf(`transmission_proxy_upstream_connections{upstream=%q,state="active"} 0`, u.Host),
+		fmt.Sprintf(`transmission_proxy_upstream_connections_acquired_total{upstream=%q,reused="true"} 24`, u.Host),
+		fmt.Sprintf(`transmission_proxy_upstream_connections_acquired_total{upstream=%q,reused="false"} 4`, u.Host),
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("metrics lack %s:\n%s", want, &buf)
+		}
+	}
+}
+
+func TestUpstreamHTTP2(t *testing.T) {
+	up := &transmissiontest.Server{}
+	srv := up.StartTLS(t)
+
+	defer func(f string) { upstreamCAFile = f }(upstreamCAFile)
+	upstreamCAFile = filepath.Join(t.TempDir(), "ca.pem")
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	if err := os.WriteFile(upstreamCAFile, ca, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	u, _ := url.Parse(srv.URL)
+	st := stats.NewRegistry()
+	c := &http.Client{Transport: upstreamTransport(st.Upstream(u.Host))}
+	getAll(t, c, srv.URL, "/transmission/web/", "/transmission/web/style.css", "/transmission/web/app.js")
+
+	for _, r := range up.Requests() {
+		if r.Proto != "HTTP/2.0" {
+			t.Errorf("%s: got protocol %s, want HTTP/2.0", r.RequestURI, r.Proto)
+		}
+	}
+	if s := st.Upstreams()[u.Host]; s.ConnsNew != 1 || s.ConnsReused != 2 {
+		t.Errorf("got %+v", s)
+	}
+}
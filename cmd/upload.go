@@ -0,0 +1,220 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"transmission-proxy/internal/bencode"
+	"transmission-proxy/internal/jrpc"
+	"transmission-proxy/internal/response"
+)
+
+// Outcomes of torrent-add requests made on behalf of clients.
+const (
+	outcomeAdded     = "added"
+	outcomeDuplicate = "duplicate"
+	outcomeRejected  = "rejected"
+)
+
+var (
+	uploadMaxFileBytes = getEnvOrDefault("UPLOAD_MAX_FILE_BYTES", "5242880")
+	uploadMaxBytes     = getEnvOrDefault("UPLOAD_MAX_BYTES", "20971520")
+)
+
+// addResult reports the outcome of torrent-add request, e.g. for one uploaded file.
+type addResult struct {
+	File    string `json:"file,omitempty"`
+	Outcome string `json:"outcome"`
+	// Torrent is the torrent added or the duplicate found, as reported by torrent-add.
+	Torrent any    `json:"torrent,omitempty"`
+	Reason  string `json:"reason,omitempty"`
+	// Status is the HTTP status the RPC request was rejected with.
+	Status int `json:"status,omitempty"`
+}
+
+// uploadedFile is the .torrent file from the form, or the reason it cannot be added.
+type uploadedFile struct {
+	name   string
+	data   []byte
+	reason string
+}
+
+// upload adds the .torrent files posted as multipart/form-data with optional downloadDir, labels (comma-separated
+// or repeated) and paused fields. Every file is added by torrent-add RPC request through rc, so validation,
+// policies and quotas apply as usual. Responds with the outcome of every file in the order of upload.
+func upload(rr *response.Responder, rc *rpcCaller) http.HandlerFunc {
+	maxFileBytes, err := strconv.ParseInt(uploadMaxFileBytes, 10, 64)
+	if err != nil || maxFileBytes <= 0 {
+		slog.Error("UPLOAD_MAX_FILE_BYTES must be a positive integer")
+		os.Exit(1)
+	}
+	maxBytes, err := strconv.ParseInt(uploadMaxBytes, 10, 64)
+	if err != nil || maxBytes <= 0 {
+		slog.Error("UPLOAD_MAX_BYTES must be a positive integer")
+		os.Exit(1)
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", "POST")
+			rr.RespondAndLogCustom(w, r.Context(), fmt.Errorf("method %s is not allowed", r.Method), 0, slog.LevelWarn, http.StatusMethodNotAllowed)
+			return
+		}
+
+		r.Body = http.MaxBytesReader(w, r.Body, maxBytes)
+		mr, err := r.MultipartReader()
+		if err != nil {
+			rr.RespondAndLogCustom(w, r.Context(), fmt.Errorf("expected multipart/form-data: %w", err), 0, slog.LevelWarn, http.StatusBadRequest)
+			return
+		}
+
+		files, args, err := readUpload(mr, maxFileBytes)
+		if err != nil {
+			status := http.StatusBadRequest
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				status = http.StatusRequestEntityTooLarge
+			}
+			rr.RespondAndLogCustom(w, r.Context(), fmt.Errorf("failed to read upload: %w", err), 0, slog.LevelWarn, status)
+			return
+		}
+		if len(files) == 0 {
+			rr.RespondAndLogCustom(w, r.Context(), fmt.Errorf("no .torrent files uploaded"), 0, slog.LevelWarn, http.StatusBadRequest)
+			return
+		}
+
+		results := make([]*addResult, len(files))
+		for i, f := range files {
+			if f.reason != "" {
+				results[i] = &addResult{File: f.name, Outcome: outcomeRejected, Reason: f.reason}
+				continue
+			}
+
+			fileArgs := map[string]any{"metainfo": base64.StdEncoding.EncodeToString(f.data)}
+			for k, v := range args {
+				fileArgs[k] = v
+			}
+			results[i] = addTorrent(r, rc, fileArgs)
+			results[i].File = f.name
+		}
+
+		writeJSON(w, r, http.StatusOK, map[string]any{"files": results})
+	}
+}
+
+// readUpload reads the files and torrent-add arguments from the form.
+func readUpload(mr *multipart.Reader, maxFileBytes int64) ([]*uploadedFile, map[string]any, error) {
+	var files []*uploadedFile
+	args := map[string]any{}
+	var labels []any
+
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+
+		bs, err := io.ReadAll(io.LimitReader(part, maxFileBytes+1))
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if part.FileName() != "" {
+			f := &uploadedFile{name: part.FileName(), data: bs}
+			if int64(len(bs)) > maxFileBytes {
+				f.reason = fmt.Sprintf("file exceeds %d bytes", maxFileBytes)
+			} else if err := checkMetainfo(bs); err != nil {
+				f.reason = "invalid torrent file: " + err.Error()
+			}
+			files = append(files, f)
+			continue
+		}
+
+		value := string(bs)
+		switch part.FormName() {
+		case "downloadDir":
+			args["download-dir"] = value
+		case "labels":
+			for _, l := range strings.Split(value, ",") {
+				if l = strings.TrimSpace(l); l != "" {
+					labels = append(labels, l)
+				}
+			}
+		case "paused":
+			paused, err := strconv.ParseBool(value)
+			if err != nil {
+				return nil, nil, fmt.Errorf("paused must be true or false")
+			}
+			args["paused"] = paused
+		default:
+			return nil, nil, fmt.Errorf("unknown field %q", part.FormName())
+		}
+	}
+
+	if labels != nil {
+		args["labels"] = labels
+	}
+
+	return files, args, nil
+}
+
+// checkMetainfo checks that the file is bencoded dictionary with info dictionary, as .torrent files are.
+func checkMetainfo(bs []byte) error {
+	v, err := bencode.Decode(bs)
+	if err != nil {
+		return err
+	}
+
+	dict, ok := v.(map[string]any)
+	if !ok {
+		return fmt.Errorf("not a dictionary")
+	}
+	if _, ok := dict["info"].(map[string]any); !ok {
+		return fmt.Errorf("info dictionary is missing")
+	}
+
+	return nil
+}
+
+// addTorrent adds the torrent by torrent-add RPC request with the arguments through rc.
+func addTorrent(r *http.Request, rc *rpcCaller, args map[string]any) *addResult {
+	res := &addResult{Outcome: outcomeRejected}
+
+	body, err := json.Marshal(&jrpc.Request{Method: "torrent-add", Arguments: args})
+	if err != nil {
+		res.Reason = err.Error()
+		return res
+	}
+
+	rpcRes := rc.call(r, body)
+	resp, err := rpcRes.rpcResponse()
+	if err != nil {
+		res.Reason, res.Status = err.Error(), rpcRes.status
+		return res
+	}
+
+	switch {
+	case rpcRes.status != http.StatusOK:
+		res.Reason, res.Status = resp.Result, rpcRes.status
+	case resp.Result != jrpc.ResultSuccess:
+		res.Reason = resp.Result
+	case resp.Arguments["torrent-added"] != nil:
+		res.Outcome, res.Torrent = outcomeAdded, resp.Arguments["torrent-added"]
+	default:
+		res.Outcome, res.Torrent = outcomeDuplicate, resp.Arguments["torrent-duplicate"]
+	}
+
+	return res
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"transmission-proxy/internal/jrpc"
+	"transmission-proxy/internal/policy"
+	"transmission-proxy/internal/response"
+)
+
+// policyFunc is the policy of the tests.
+type policyFunc func(ctx context.Context, req *jrpc.Request) (*jrpc.Request, policy.ResponseRewriter, error)
+
+func (f policyFunc) Apply(ctx context.Context, req *jrpc.Request) (*jrpc.Request, policy.ResponseRewriter, error) {
+	return f(ctx, req)
+}
+
+// metainfo returns .torrent file of the torrent with the name.
+func metainfo(name string) string {
+	return "d8:announce18:http://tracker/ann4:infod4:name" + strconv.Itoa(len(name)) + ":" + name + "6:lengthi1024eee"
+}
+
+// blockedNames rejects torrent-add of the torrents named "blocked".
+var blockedNames = policyFunc(func(_ context.Context, req *jrpc.Request) (*jrpc.Request, policy.ResponseRewriter, error) {
+	if s, _ := req.Arguments["metainfo"].(string); req.Method == "torrent-add" {
+		if bs, _ := base64.StdEncoding.DecodeString(s); strings.Contains(string(bs), "7:blocked") {
+			return nil, nil, &policy.Violation{Policy: "names", Reason: "torrent is blocked"}
+		}
+	}
+
+	return req, nil, nil
+})
+
+// testUpload returns the upload handler adding torrents through rpcProxy to the upstream.
+func testUpload(t *testing.T, forwarded *[]string) http.HandlerFunc {
+	rr := &response.Responder{DebugMode: true}
+	return upload(rr, &rpcCaller{rpc: testRPCProxy(mockUpstream(t, forwarded), func(cfg *rpcProxyConfig) {
+		cfg.responder = rr
+		cfg.policies = []policy.Policy{blockedNames}
+	})})
+}
+
+// uploadPart is the form field, or the file if name is set.
+type uploadPart struct {
+	field, name, data string
+}
+
+func postUpload(h http.Handler, parts ...uploadPart) *httptest.ResponseRecorder {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for _, p := range parts {
+		if p.name != "" {
+			fw, _ := mw.CreateFormFile(p.field, p.name)
+			_, _ = fw.Write([]byte(p.data))
+		} else {
+			_ = mw.WriteField(p.field, p.data)
+		}
+	}
+	_ = mw.Close()
+
+	r := httptest.NewRequest(http.MethodPost, "/proxy/upload", &body)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+
+	return w
+}
+
+func TestUpload(t *testing.T) {
+	captureLog(t)
+	var forwarded []string
+	h := testUpload(t, &forwarded)
+
+	w := postUpload(h,
+		uploadPart{field: "torrent", name: "debian.torrent", data: metainfo("debian")},
+		uploadPart{field: "torrent", name: "corrupt.torrent", data: "d4:info"},
+		uploadPart{field: "torrent", name: "blocked.torrent", data: metainfo("blocked")},
+		uploadPart{field: "torrent", name: "again.torrent", data: metainfo("debian")},
+		uploadPart{field: "torrent", name: "list.torrent", data: "li1ee"},
+		uploadPart{field: "downloadDir", data: "/downloads/linux"},
+		uploadPart{field: "labels", data: "linux, iso"},
+		uploadPart{field: "labels", data: "debian"},
+		uploadPart{field: "paused", data: "true"},
+	)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d, body %s", w.Code, w.Body)
+	}
+
+	var res struct {
+		Files []struct {
+			File    string         `json:"file"`
+			Outcome string         `json:"outcome"`
+			Torrent map[string]any `json:"torrent"`
+			Reason  string         `json:"reason"`
+			Status  int            `json:"status"`
+		} `json:"files"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Files) != 5 {
+		t.Fatalf("got %d results, want one for every file: %s", len(res.Files), w.Body)
+	}
+
+	// the results are in the order of upload
+	want := []struct{ file, outcome, reason string }{
+		{file: "debian.torrent", outcome: outcomeAdded},
+		{file: "corrupt.torrent", outcome: outcomeRejected, reason: "invalid torrent file"},
+		{file: "blocked.torrent", outcome: outcomeRejected, reason: "torrent is blocked"},
+		{file: "again.torrent", outcome: outcomeDuplicate},
+		{file: "list.torrent", outcome: outcomeRejected, reason: "invalid torrent file: not a dictionary"},
+	}
+	for i, f := range res.Files {
+		if f.File != want[i].file || f.Outcome != want[i].outcome || !strings.Contains(f.Reason, want[i].reason) {
+			t.Errorf("file #%d: got %+v, want %+v", i+1, f, want[i])
+		}
+	}
+	if res.Files[0].Torrent["id"] != float64(1) || res.Files[3].Torrent["id"] != float64(1) {
+		t.Errorf("got torrents %v and %v, want the added one and its duplicate", res.Files[0].Torrent, res.Files[3].Torrent)
+	}
+	if res.Files[2].Status != http.StatusForbidden {
+		t.Errorf("policy rejection: got status %d", res.Files[2].Status)
+	}
+
+	// the valid files only are sent, with the metainfo encoded and the form fields as arguments
+	if len(forwarded) != 2 {
+		t.Fatalf("got %d requests forwarded, want 2", len(forwarded))
+	}
+	method, args := lastRPC(t, forwarded[:1])
+	wantArgs := `{"download-dir":"/downloads/linux","labels":["linux","iso","debian"],"metainfo":"` +
+		base64.StdEncoding.EncodeToString([]byte(metainfo("debian"))) + `","paused":true}`
+	if method != "torrent-add" || args != wantArgs {
+		t.Errorf("forwarded %s %s, want %s", method, args, wantArgs)
+	}
+}
+
+func TestUploadInvalid(t *testing.T) {
+	captureLog(t)
+	defer func(file, total string) { uploadMaxFileBytes, uploadMaxBytes = file, total }(uploadMaxFileBytes, uploadMaxBytes)
+	uploadMaxFileBytes, uploadMaxBytes = "100", "1000"
+
+	var forwarded []string
+	h := testUpload(t, &forwarded)
+
+	cases := []struct {
+		name   string
+		parts  []uploadPart
+		status int
+		err    string
+	}{
+		{name: "no files", parts: []uploadPart{{field: "paused", data: "false"}}, status: http.StatusBadRequest, err: "no .torrent files uploaded"},
+		{name: "unknown field", parts: []uploadPart{{field: "start", data: "1"}}, status: http.StatusBadRequest, err: `unknown field \"start\"`},
+		{name: "bad paused", parts: []uploadPart{{field: "paused", data: "maybe"}}, status: http.StatusBadRequest, err: "paused must be true or false"},
+		{name: "request too large", parts: []uploadPart{{field: "torrent", name: "a.torrent", data: strings.Repeat("x", 1000)}},
+			status: http.StatusRequestEntityTooLarge},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			w := postUpload(h, tc.parts...)
+			if w.Code != tc.status || !strings.Contains(strings.ToLower(w.Body.String()), tc.err) {
+				t.Errorf("got status %d, body %s", w.Code, w.Body)
+			}
+		})
+	}
+
+	// files over the limit are rejected on their own
+	w := postUpload(h, uploadPart{field: "torrent", name: "large.torrent", data: strings.Repeat("x", 101)},
+		uploadPart{field: "torrent", name: "small.torrent", data: metainfo("small")})
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"file":"large.torrent","outcome":"rejected","reason":"file exceeds 100 bytes"`) ||
+		!strings.Contains(w.Body.String(), `"file":"small.torrent","outcome":"added"`) {
+		t.Errorf("large file: got status %d, body %s", w.Code, w.Body)
+	}
+
+	w = httptest.NewRecorder()
+	h(w, httptest.NewRequest(http.MethodGet, "/proxy/upload", nil))
+	if w.Code != http.StatusMethodNotAllowed || w.Header().Get("Allow") != "POST" {
+		t.Errorf("GET: got status %d", w.Code)
+	}
+	w = httptest.NewRecorder()
+	h(w, httptest.NewRequest(http.MethodPost, "/proxy/upload", strings.NewReader(`{}`)))
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "multipart/form-data") {
+		t.Errorf("JSON: got status %d, body %s", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"transmission-proxy/internal/logger"
+	"transmission-proxy/internal/stats"
+	"transmission-proxy/internal/upstream"
+	"transmission-proxy/transmissionproxy"
+)
+
+var (
+	// upstreamFailoverBackoff is how long the upstream failing requests is skipped when there are several.
+	upstreamFailoverBackoff = getDurationEnv("UPSTREAM_FAILOVER_BACKOFF", 30*time.Second)
+	// upstreamBalanceReads spreads read-only requests over all healthy upstreams rather than sending them to the first.
+	upstreamBalanceReads = getBoolEnv("UPSTREAM_BALANCE_READS")
+	// upstreamCollapseSlashes forwards paths with repeated slashes replaced by single ones.
+	upstreamCollapseSlashes = getBoolEnv("UPSTREAM_COLLAPSE_SLASHES")
+	// upstreamErrorLogWindow is how often repeated upstream errors are logged, summarizing the suppressed ones.
+	upstreamErrorLogWindow = getDurationEnv("UPSTREAM_ERROR_LOG_WINDOW", transmissionproxy.UpstreamErrorLogWindow)
+)
+
+// parseUpstreamHosts returns the URLs listed in UPSTREAM_HOST, exiting if any of them is not valid.
+func parseUpstreamHosts() []*url.URL {
+	var res []*url.URL
+	for _, host := range strings.Split(upstreamHost, ",") {
+		host = strings.TrimSpace(host)
+		if host == "" {
+			continue
+		}
+		if !strings.HasSuffix(host, "/") {
+			host += "/"
+		}
+
+		u, err := url.Parse(host)
+		if err != nil {
+			slog.Error("failed to parse UPSTREAM_HOST: "+err.Error(), logger.IgnoredAttr(err))
+			os.Exit(1)
+		}
+		if u.Path != "/" || u.RawQuery != "" || u.Fragment != "" {
+			slog.Error("UPSTREAM_HOST must not define path or query")
+			os.Exit(1)
+		}
+		res = append(res, u)
+	}
+
+	if len(res) == 0 {
+		slog.Error("UPSTREAM_HOST must be defined")
+		os.Exit(1)
+	}
+
+	return res
+}
+
+// upstreamPool returns the pool of the upstreams to fail over between, or nil if there is only one.
+func upstreamPool(hosts []*url.URL) *upstream.Pool {
+	if len(hosts) < 2 {
+		return nil
+	}
+
+	return upstream.NewPool(hosts, upstreamFailoverBackoff, !sessionPassthrough)
+}
+
+// hostTransports sends requests with the transport of their upstream host, so that connections
+// of every upstream are recorded separately.
+type hostTransports map[string]http.RoundTripper
+
+func newHostTransports(hosts []*url.URL, st *stats.Registry) hostTransports {
+	res := hostTransports{}
+	for _, u := range hosts {
+		res[u.Host] = upstreamTransport(st.Upstream(u.Host))
+	}
+
+	return res
+}
+
+func (t hostTransports) RoundTrip(r *http.Request) (*http.Response, error) {
+	rt, ok := t[r.URL.Host]
+	if !ok {
+		return nil, fmt.Errorf("%s is not upstream host", r.URL.Host)
+	}
+
+	return rt.RoundTrip(r)
+}
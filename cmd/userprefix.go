@@ -0,0 +1,60 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"sync"
+
+	"transmission-proxy/internal/jrpc"
+	"transmission-proxy/internal/policy"
+	"transmission-proxy/internal/reqctx"
+	"transmission-proxy/internal/transmission"
+)
+
+// userPlaceholder in download prefix (e.g. DOWNLOAD_PREFIX=/downloads/{user}/) is replaced with the name
+// of the user making the request, so that every user is confined to their own directory.
+const userPlaceholder = policy.UserPlaceholder
+
+var errNoPrefixUser = errors.New("download prefix requires request of authenticated user")
+
+// userPrefixValidator validates requests with validator built for the prefix of the user making them.
+type userPrefixValidator struct {
+	prefix string
+	build  func(prefix string) transmission.RequestValidator
+
+	mu     sync.Mutex
+	byUser map[string]transmission.RequestValidator
+}
+
+func newUserPrefixValidator(prefix string, build func(prefix string) transmission.RequestValidator) *userPrefixValidator {
+	return &userPrefixValidator{prefix: prefix, build: build, byUser: map[string]transmission.RequestValidator{}}
+}
+
+func (v *userPrefixValidator) Validate(req *jrpc.Request) (*jrpc.Request, error) {
+	user := reqctx.User(req.Ctx())
+	// user names which are not a single path segment cannot be the directory of the user
+	if user == "" || user == "." || user == ".." || strings.ContainsAny(user, "/\\") {
+		return nil, errNoPrefixUser
+	}
+
+	return v.forUser(user).Validate(req)
+}
+
+func (v *userPrefixValidator) forUser(user string) transmission.RequestValidator {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	uv, ok := v.byUser[user]
+	if !ok {
+		uv = v.build(strings.ReplaceAll(v.prefix, userPlaceholder, user))
+		v.byUser[user] = uv
+	}
+
+	return uv
+}
+
+// commonPrefix returns the part of the download prefix shared by all users.
+func commonPrefix(prefix string) string {
+	common, _, _ := strings.Cut(prefix, userPlaceholder)
+	return common
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"transmission-proxy/internal/htpasswd"
+	"transmission-proxy/internal/jrpc"
+	"transmission-proxy/internal/reqctx"
+	"transmission-proxy/internal/response"
+	"transmission-proxy/internal/stats"
+	"transmission-proxy/internal/transmission"
+)
+
+func TestUserPrefixValidator(t *testing.T) {
+	var built []string
+	v := newUserPrefixValidator("/downloads/"+userPlaceholder+"/", func(prefix string) transmission.RequestValidator {
+		built = append(built, prefix)
+		return buildValidator(prefix)
+	})
+
+	cases := []struct {
+		name, user, method string
+		args               map[string]any
+		ok                 bool
+	}{
+		{name: "own add", user: "alice", method: "torrent-add", args: map[string]any{"filename": "x.torrent", "download-dir": "/downloads/alice/tv"}, ok: true},
+		{name: "other add", user: "alice", method: "torrent-add", args: map[string]any{"filename": "x.torrent", "download-dir": "/downloads/bob/tv"}},
+		{name: "common add", user: "alice", method: "torrent-add", args: map[string]any{"filename": "x.torrent", "download-dir": "/downloads/"}},
+		{name: "own location", user: "alice", method: "torrent-set-location", args: map[string]any{"ids": []any{1}, "location": "/downloads/alice"}, ok: true},
+		{name: "other location", user: "alice", method: "torrent-set-location", args: map[string]any{"ids": []any{1}, "location": "/downloads/bob"}},
+		{name: "own session dir", user: "alice", method: "session-set", args: map[string]any{"download-dir": "/downloads/alice/"}, ok: true},
+		{name: "other session dir", user: "alice", method: "session-set", args: map[string]any{"download-dir": "/downloads/bob/"}},
+		{name: "other user add", user: "bob", method: "torrent-add", args: map[string]any{"filename": "x.torrent", "download-dir": "/downloads/bob/tv"}, ok: true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := &jrpc.Request{Method: tc.method, Arguments: tc.args, Context: reqctx.WithUser(context.Background(), tc.user)}
+			if _, err := v.Validate(req); (err == nil) != tc.ok {
+				t.Errorf("got error %v", err)
+			}
+		})
+	}
+	// validators are built once per user
+	if strings.Join(built, " ") != "/downloads/alice/ /downloads/bob/" {
+		t.Errorf("built validators for %q", built)
+	}
+
+	// user names which cannot be a directory get no directory
+	for _, user := range []string{"", ".", "..", "alice/..", `..\alice`} {
+		req := &jrpc.Request{Method: "torrent-get", Arguments: map[string]any{"fields": []any{"id"}}, Context: reqctx.WithUser(context.Background(), user)}
+		if _, err := v.Validate(req); !errors.Is(err, errNoPrefixUser) {
+			t.Errorf("%q: got error %v", user, err)
+		}
+	}
+}
+
+func TestUserPrefixUnauthenticated(t *testing.T) {
+	captureLog(t)
+	users, err := htpasswd.FromEntries([]string{"alice:$2a$04$ep6koQz20G/6ptxBdOvXBO2DZp9cfH/3EWwAMwEvlu1UZNK8Ob6SG"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var forwarded int
+	proxy := testRPCProxy(upstreamFunc(func(r *http.Request) (*http.Response, error) {
+		forwarded++
+		return upstreamStatus(http.StatusOK, `{"arguments":{},"result":"success"}`)(r)
+	}), func(cfg *rpcProxyConfig) {
+		cfg.validator = newUserPrefixValidator("/downloads/"+userPlaceholder+"/", func(prefix string) transmission.RequestValidator {
+			return buildValidator(prefix)
+		})
+	})
+	h := basicAuth(&authGuard{rr: &response.Responder{}, st: stats.NewRegistry()}, users, proxy)
+
+	body := `{"method":"torrent-add","arguments":{"filename":"x.torrent","download-dir":"/downloads/alice/"}}`
+	r := httptest.NewRequest(http.MethodPost, rpcPath, strings.NewReader(body))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusUnauthorized || forwarded != 0 {
+		t.Errorf("without credentials: got status %d, %d requests forwarded", w.Code, forwarded)
+	}
+
+	r = httptest.NewRequest(http.MethodPost, rpcPath, strings.NewReader(body))
+	r.Header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte("alice:alice-secret")))
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusOK || forwarded != 1 {
+		t.Errorf("with credentials: got status %d, body %s", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"transmission-proxy/internal/ownership"
+	"transmission-proxy/internal/reqctx"
+	"transmission-proxy/internal/response"
+	"transmission-proxy/internal/roles"
+	"transmission-proxy/internal/upstream"
+	"transmission-proxy/internal/userstats"
+)
+
+// userStats summarizes torrents of the calling user, or of all users by name for admins with ?all=1.
+func userStats(rr *response.Responder, rl *roles.Roles, store ownership.Store, torrents *upstream.TorrentList) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			rr.RespondAndLogCustom(w, r.Context(), fmt.Errorf("method %s is not allowed", r.Method), 0, slog.LevelWarn, http.StatusMethodNotAllowed)
+			return
+		}
+
+		user := reqctx.User(r.Context())
+		if user == "" {
+			rr.RespondAndLogCustom(w, r.Context(), fmt.Errorf("authentication required"), 0, slog.LevelWarn, http.StatusUnauthorized)
+			return
+		}
+
+		all := r.URL.Query().Get("all") == "1"
+		if all && !rl.IsAdmin(user) {
+			rr.RespondAndLogCustom(w, r.Context(), fmt.Errorf("only admins may query statistics of all users"), 0, slog.LevelWarn, http.StatusForbidden)
+			return
+		}
+
+		owners, err := store.All()
+		if err != nil {
+			rr.RespondAndLogError(w, r.Context(), fmt.Errorf("failed to load torrents ownership: %w", err), 0)
+			return
+		}
+
+		list, err := torrents.Get(r.Context())
+		if err != nil {
+			rr.RespondAndLogCustom(w, r.Context(), fmt.Errorf("failed to compute statistics: %w", err), 0, slog.LevelError, http.StatusBadGateway)
+			return
+		}
+
+		summaries := userstats.Compute(list, owners)
+		if all {
+			writeJSON(w, r, http.StatusOK, summaries)
+			return
+		}
+
+		s := summaries[user]
+		if s == nil {
+			s = &userstats.Summary{ByStatus: map[string]int{}}
+		}
+		writeJSON(w, r, http.StatusOK, s)
+	}
+}
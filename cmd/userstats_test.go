@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"transmission-proxy/internal/ownership"
+	"transmission-proxy/internal/reqctx"
+	"transmission-proxy/internal/response"
+	"transmission-proxy/internal/roles"
+	"transmission-proxy/internal/upstream"
+	"transmission-proxy/internal/userstats"
+)
+
+func TestUserStats(t *testing.T) {
+	captureLog(t)
+	store, err := ownership.Open(filepath.Join(t.TempDir(), "ownership.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = store.Close() }()
+	for hash, user := range map[string]string{"aaaa": "alice", "bbbb": "bob"} {
+		if err := store.Put(hash, &ownership.Entry{User: user}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var calls int
+	up := upstreamStatus(http.StatusOK, `{"arguments":{"torrents":[`+
+		`{"hashString":"aaaa","status":6,"totalSize":1000,"uploadedEver":10,"downloadedEver":1000,"rateUpload":5,"rateDownload":0},`+
+		`{"hashString":"bbbb","status":4,"totalSize":500,"uploadedEver":0,"downloadedEver":100,"rateUpload":0,"rateDownload":50}]},"result":"success"}`)
+	counted := upstreamFunc(func(r *http.Request) (*http.Response, error) {
+		calls++
+		return up(r)
+	})
+	client := &upstream.Client{URL: "http://transmission:9091/transmission/rpc", HTTP: &http.Client{Transport: counted}}
+	h := userStats(&response.Responder{}, roles.New([]string{"root"}), store,
+		&upstream.TorrentList{Client: client, Fields: userstats.Fields, TTL: time.Minute})
+
+	alice := `{"torrents":1,"by_status":{"seed":1},"total_size":1000,"uploaded":10,"downloaded":1000,"rate_upload":5,"rate_download":0}`
+	bob := `{"torrents":1,"by_status":{"download":1},"total_size":500,"uploaded":0,"downloaded":100,"rate_upload":0,"rate_download":50}`
+	cases := []struct {
+		name, user, target string
+		status             int
+		want               string
+	}{
+		{name: "own", user: "alice", target: "/proxy/stats/me", status: http.StatusOK, want: alice},
+		{name: "no torrents", user: "carol", target: "/proxy/stats/me", status: http.StatusOK,
+			want: `{"torrents":0,"by_status":{},"total_size":0,"uploaded":0,"downloaded":0,"rate_upload":0,"rate_download":0}`},
+		{name: "admin all", user: "root", target: "/proxy/stats/me?all=1", status: http.StatusOK,
+			want: `{"alice":` + alice + `,"bob":` + bob + `}`},
+		{name: "user all", user: "alice", target: "/proxy/stats/me?all=1", status: http.StatusForbidden},
+		{name: "anonymous", target: "/proxy/stats/me", status: http.StatusUnauthorized},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, tc.target, nil)
+			if tc.user != "" {
+				r = r.WithContext(reqctx.WithUser(r.Context(), tc.user))
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+
+			if w.Code != tc.status {
+				t.Fatalf("got status %d, body %s", w.Code, w.Body)
+			}
+			if tc.want != "" && !jsonEqual(t, w.Body.Bytes(), []byte(tc.want)) {
+				t.Errorf("got body %s, want %s", w.Body, tc.want)
+			}
+		})
+	}
+
+	// the dashboards share the cached list
+	if calls != 1 {
+		t.Errorf("got %d upstream requests, want 1", calls)
+	}
+}
+
+// jsonEqual reports whether the documents are the same regardless of formatting and member order.
+func jsonEqual(t *testing.T, a, b []byte) bool {
+	t.Helper()
+
+	var va, vb any
+	if err := json.Unmarshal(a, &va); err != nil {
+		t.Fatalf("bad JSON %s: %v", a, err)
+	}
+	if err := json.Unmarshal(b, &vb); err != nil {
+		t.Fatalf("bad JSON %s: %v", b, err)
+	}
+
+	return reflect.DeepEqual(va, vb)
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"transmission-proxy/internal/jrpc"
+	"transmission-proxy/internal/response"
+	"transmission-proxy/internal/transmission"
+)
+
+// verdict is the outcome of validating one RPC request in the validate subcommand.
+type verdict struct {
+	Source   string                 `json:"source"`
+	Index    int                    `json:"index"`
+	Method   string                 `json:"method,omitempty"`
+	Tag      int                    `json:"tag,omitempty"`
+	Accepted bool                   `json:"accepted"`
+	Dropped  []string               `json:"dropped,omitempty"`
+	Errors   []response.ErrorDetail `json:"errors,omitempty"`
+}
+
+// validateCommand implements `transmission-proxy validate [--format=text|json] [--prefix=/downloads/] [--user=name] [files...]`:
+// it reads RPC requests from the files (or stdin) and reports whether the proxy would accept them.
+// Returns process exit code: 0 if all requests are accepted, 1 if any is rejected, 2 on usage or input errors.
+func validateCommand(args []string, stdin io.Reader, stdout io.Writer) int {
+	fs := flag.NewFlagSet("validate", flag.ContinueOnError)
+	format := fs.String("format", "text", "output format: text or json")
+	prefix := fs.String("prefix", downloadPrefix, "required download prefix (defaults to DOWNLOAD_PREFIX)")
+	user := fs.String("user", "", "user to check the requests of, replacing "+userPlaceholder+" in the prefix")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *format != "text" && *format != "json" {
+		_, _ = fmt.Fprintln(os.Stderr, "format must be text or json")
+		return 2
+	}
+
+	if *user != "" {
+		*prefix = strings.ReplaceAll(*prefix, userPlaceholder, *user)
+	}
+	checkDownloadPrefix(*prefix)
+	v := buildValidator(*prefix)
+
+	var verdicts []verdict
+	sources := fs.Args()
+	if len(sources) == 0 {
+		sources = []string{"-"}
+	}
+
+	for _, src := range sources {
+		in := stdin
+		if src != "-" {
+			f, err := os.Open(src)
+			if err != nil {
+				_, _ = fmt.Fprintln(os.Stderr, err.Error())
+				return 2
+			}
+			defer func() { _ = f.Close() }()
+			in = f
+		}
+
+		vs, err := validateStream(v, src, in)
+		if err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "%s: %s\n", src, err)
+			return 2
+		}
+		verdicts = append(verdicts, vs...)
+	}
+
+	if err := renderVerdicts(stdout, *format, verdicts); err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, err.Error())
+		return 2
+	}
+
+	for _, vd := range verdicts {
+		if !vd.Accepted {
+			return 1
+		}
+	}
+
+	return 0
+}
+
+func validateStream(v transmission.RequestValidator, src string, in io.Reader) ([]verdict, error) {
+	var res []verdict
+
+	dec := json.NewDecoder(in)
+	for i := 1; ; i++ {
+		var req jrpc.Request
+		if err := dec.Decode(&req); err == io.EOF {
+			return res, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("request #%d: %w", i, err)
+		}
+
+		res = append(res, validateOne(v, src, i, &req))
+	}
+}
+
+func validateOne(v transmission.RequestValidator, src string, idx int, req *jrpc.Request) verdict {
+	vd := verdict{Source: src, Index: idx, Method: req.Method, Tag: req.Tag}
+
+	sanitized, err := v.Validate(req)
+	if err != nil {
+		var hd response.HasErrorDetails
+		var ba transmission.IsBadArgument
+		if errors.As(err, &hd) {
+			vd.Errors = hd.ErrorDetails()
+		} else if errors.As(err, &ba) {
+			vd.Errors = []response.ErrorDetail{{Field: ba.GetBadArgument(), Reason: err.Error()}}
+		} else {
+			vd.Errors = []response.ErrorDetail{{Reason: err.Error()}}
+		}
+
+		return vd
+	}
+
+	vd.Accepted = true
+	vd.Dropped, _ = jrpc.DiffArguments(req, sanitized)
+
+	return vd
+}
+
+func renderVerdicts(w io.Writer, format string, verdicts []verdict) error {
+	if format == "json" {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if verdicts == nil {
+			verdicts = []verdict{}
+		}
+		return enc.Encode(verdicts)
+	}
+
+	for _, vd := range verdicts {
+		status := "ACCEPTED"
+		if !vd.Accepted {
+			status = "REJECTED"
+		}
+
+		line := fmt.Sprintf("%s #%d %s: %s", vd.Source, vd.Index, vd.Method, status)
+		if len(vd.Dropped) > 0 {
+			line += " (dropped: " + strings.Join(vd.Dropped, ", ") + ")"
+		}
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+
+		for _, e := range vd.Errors {
+			reason := e.Reason
+			if e.Field != "" {
+				reason = e.Field + ": " + reason
+			}
+			if _, err := fmt.Fprintln(w, "    "+reason); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
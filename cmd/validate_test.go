@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const validateInput = `{"method":"torrent-get","arguments":{"fields":["id"]},"tag":1}
+{"method":"torrent-stop","arguments":{"ids":[1],"bogus":true}}
+{"method":"torrent-set-location","arguments":{"ids":1,"location":"/etc","move":true},"tag":3}
+`
+
+func runValidate(t *testing.T, stdin string, args ...string) (int, string) {
+	captureLog(t)
+
+	var out bytes.Buffer
+	code := validateCommand(append([]string{"--prefix=/downloads/"}, args...), strings.NewReader(stdin), &out)
+	return code, out.String()
+}
+
+func TestValidateCommandText(t *testing.T) {
+	code, out := runValidate(t, validateInput)
+	if code != 1 {
+		t.Errorf("got exit code %d, want 1 for rejected request", code)
+	}
+
+	want := `- #1 torrent-get: ACCEPTED
+- #2 torrent-stop: ACCEPTED (dropped: bogus)
+- #3 torrent-set-location: REJECTED
+    location: bad argument: forbidden location
+`
+	if out != want {
+		t.Errorf("got output\n%s\nwant\n%s", out, want)
+	}
+}
+
+func TestValidateCommandJSON(t *testing.T) {
+	code, out := runValidate(t, validateInput, "--format=json")
+	if code != 1 {
+		t.Errorf("got exit code %d", code)
+	}
+
+	var verdicts []verdict
+	if err := json.Unmarshal([]byte(out), &verdicts); err != nil {
+		t.Fatalf("bad output %s: %v", out, err)
+	}
+	if len(verdicts) != 3 {
+		t.Fatalf("got %+v", verdicts)
+	}
+	if v := verdicts[0]; !v.Accepted || v.Method != "torrent-get" || v.Tag != 1 || len(v.Dropped) != 0 {
+		t.Errorf("got %+v", v)
+	}
+	if v := verdicts[1]; !v.Accepted || len(v.Dropped) != 1 || v.Dropped[0] != "bogus" {
+		t.Errorf("got %+v", v)
+	}
+	if v := verdicts[2]; v.Accepted || v.Index != 3 || len(v.Errors) != 1 || v.Errors[0].Field != "location" {
+		t.Errorf("got %+v", v)
+	}
+}
+
+func TestValidateCommandFiles(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "add.json")
+	if err := os.WriteFile(path, []byte(`{"method":"torrent-add","arguments":{"filename":"a.torrent","download-dir":"/downloads/alice/tv"}}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	captureLog(t)
+	var out bytes.Buffer
+	if code := validateCommand([]string{"--prefix=/downloads/" + userPlaceholder + "/", "--user=alice", path}, strings.NewReader(""), &out); code != 0 {
+		t.Errorf("got exit code %d, output %s", code, &out)
+	}
+	if !strings.HasPrefix(out.String(), path+" #1 torrent-add: ACCEPTED") {
+		t.Errorf("got output %s", &out)
+	}
+
+	out.Reset()
+	if code := validateCommand([]string{"--prefix=/downloads/" + userPlaceholder + "/", "--user=bob", path}, strings.NewReader(""), &out); code != 1 {
+		t.Errorf("got exit code %d for other user's directory, output %s", code, &out)
+	}
+}
+
+func TestValidateCommandErrors(t *testing.T) {
+	cases := []struct {
+		name, stdin string
+		args        []string
+	}{
+		{name: "bad format", args: []string{"--format=yaml"}},
+		{name: "bad flag", args: []string{"--nonsense"}},
+		{name: "malformed input", stdin: `{"method":"torrent-get"} {"method":`},
+		{name: "missing file", args: []string{filepath.Join(t.TempDir(), "missing.json")}},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if code, out := runValidate(t, tc.stdin, tc.args...); code != 2 {
+				t.Errorf("got exit code %d, output %s", code, out)
+			}
+		})
+	}
+}
+
+func TestValidateCommandEmpty(t *testing.T) {
+	if code, out := runValidate(t, "", "--format=json"); code != 0 || strings.TrimSpace(out) != "[]" {
+		t.Errorf("got exit code %d, output %s", code, out)
+	}
+}
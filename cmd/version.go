@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"transmission-proxy/internal/jrpc"
+	"transmission-proxy/internal/upstream"
+)
+
+// versionHeader is added to all responses unless disabled by VERSION_HEADER=no.
+const versionHeader = "X-Proxy-Version"
+
+var versionHeaderEnabled = os.Getenv("VERSION_HEADER") == "" || getBoolEnv("VERSION_HEADER")
+
+// upstreamVersionTTL is how long the detected upstream version is cached.
+const upstreamVersionTTL = 5 * time.Minute
+
+// buildInfo describes the running build.
+type buildInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit,omitempty"`
+	Modified  bool   `json:"modified,omitempty"`
+	BuildDate string `json:"build_date,omitempty"`
+	GoVersion string `json:"go_version"`
+}
+
+// readBuildInfo returns information on the running build, which is shown by --version, logged on startup,
+// served on /proxy/version and sent in X-Proxy-Version header.
+func readBuildInfo() buildInfo {
+	info := buildInfo{Version: "unknown"}
+
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return info
+	}
+
+	info.Version = bi.Main.Version
+	info.GoVersion = bi.GoVersion
+	for _, s := range bi.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			info.Commit = s.Value
+		case "vcs.time":
+			info.BuildDate = s.Value
+		case "vcs.modified":
+			info.Modified = s.Value == "true"
+		}
+	}
+
+	return info
+}
+
+// short returns the version, or the abbreviated commit for development builds, e.g. "0123456789ab-dirty".
+// Versions derived from VCS by the toolchain include the commit already.
+func (b buildInfo) short() string {
+	if b.Version != "(devel)" || b.Commit == "" {
+		return b.Version
+	}
+
+	s := b.Commit[:min(len(b.Commit), 12)]
+	if b.Modified {
+		s += "-dirty"
+	}
+
+	return s
+}
+
+func (b buildInfo) String() string {
+	s := "transmission-proxy " + b.short()
+	if b.BuildDate != "" {
+		s += ", committed " + b.BuildDate
+	}
+
+	return s + ", " + b.GoVersion
+}
+
+// upstreamVersion caches the version of Transmission reported by session-get.
+type upstreamVersion struct {
+	uc *upstream.Client
+
+	mu      sync.Mutex
+	fetched time.Time
+	data    map[string]any
+}
+
+func (u *upstreamVersion) get(ctx context.Context) map[string]any {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if time.Since(u.fetched) < upstreamVersionTTL {
+		return u.data
+	}
+
+	resp, err := u.uc.Call(ctx, nil, &jrpc.Request{Method: "session-get", Arguments: map[string]any{"fields": []string{"rpc-version", "version"}}})
+	if err != nil {
+		// failures are not cached, so that the version is detected as soon as Transmission is up
+		return map[string]any{"error": err.Error()}
+	}
+
+	u.fetched = time.Now()
+	u.data = map[string]any{"rpc_version": resp.Arguments["rpc-version"], "version": resp.Arguments["version"]}
+	return u.data
+}
+
+// version reports the build of the proxy and the version of the upstream Transmission.
+func version(uv *upstreamVersion) http.HandlerFunc {
+	info := readBuildInfo()
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, r, http.StatusOK, struct {
+			buildInfo
+			Upstream map[string]any `json:"upstream"`
+		}{info, uv.get(r.Context())})
+	}
+}
+
+// withVersionHeader adds X-Proxy-Version header to all responses, unless disabled.
+func withVersionHeader(next http.Handler) http.Handler {
+	if !versionHeaderEnabled {
+		return next
+	}
+	v := readBuildInfo().short()
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(versionHeader, v)
+		next.ServeHTTP(w, r)
+	})
+}
+
+func printVersion() {
+	fmt.Println(readBuildInfo().String())
+}
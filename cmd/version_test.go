@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"testing"
+
+	"transmission-proxy/internal/upstream"
+)
+
+func TestBuildInfo(t *testing.T) {
+	if info := readBuildInfo(); info.GoVersion != runtime.Version() || info.Version == "" {
+		t.Errorf("got build info %+v", info)
+	}
+
+	cases := []struct {
+		info         buildInfo
+		short, print string
+	}{
+		{info: buildInfo{Version: "v1.4.0", Commit: "0123456789abcdef", BuildDate: "2024-05-01T10:00:00Z", GoVersion: "go1.22.3"},
+			short: "v1.4.0", print: "transmission-proxy v1.4.0, committed 2024-05-01T10:00:00Z, go1.22.3"},
+		{info: buildInfo{Version: "v1.4.1-0.20240601100000-0123456789ab", GoVersion: "go1.22.3"},
+			short: "v1.4.1-0.20240601100000-0123456789ab", print: "transmission-proxy v1.4.1-0.20240601100000-0123456789ab, go1.22.3"},
+		{info: buildInfo{Version: "(devel)", Commit: "0123456789abcdef", GoVersion: "go1.22.3"},
+			short: "0123456789ab", print: "transmission-proxy 0123456789ab, go1.22.3"},
+		{info: buildInfo{Version: "(devel)", Commit: "0123456789abcdef", Modified: true, GoVersion: "go1.22.3"},
+			short: "0123456789ab-dirty", print: "transmission-proxy 0123456789ab-dirty, go1.22.3"},
+		{info: buildInfo{Version: "(devel)", GoVersion: "go1.22.3"}, short: "(devel)", print: "transmission-proxy (devel), go1.22.3"},
+	}
+	for _, tc := range cases {
+		if got := tc.info.short(); got != tc.short {
+			t.Errorf("%+v: got short version %q, want %q", tc.info, got, tc.short)
+		}
+		if got := tc.info.String(); got != tc.print {
+			t.Errorf("%+v: got %q, want %q", tc.info, got, tc.print)
+		}
+	}
+}
+
+func TestVersion(t *testing.T) {
+	calls, down := 0, true
+	uc := &upstream.Client{URL: "http://transmission:9091/transmission/rpc", HTTP: &http.Client{Transport: upstreamFunc(func(r *http.Request) (*http.Response, error) {
+		calls++
+		if down {
+			return nil, errors.New("connection refused")
+		}
+		return upstreamStatus(http.StatusOK, `{"arguments":{"rpc-version":17,"version":"4.0.5 (a6fe2a64aa)"},"result":"success"}`)(r)
+	})}}
+	h := version(&upstreamVersion{uc: uc})
+
+	get := func() map[string]any {
+		t.Helper()
+		w := httptest.NewRecorder()
+		h(w, httptest.NewRequest(http.MethodGet, "/proxy/version", nil))
+		var data map[string]any
+		if err := json.Unmarshal(w.Body.Bytes(), &data); err != nil || w.Code != http.StatusOK ||
+			w.Header().Get("Content-Type") != "application/json" {
+			t.Fatalf("got status %d, body %s", w.Code, w.Body)
+		}
+		return data
+	}
+
+	// the failure is reported, and not cached
+	data := get()
+	if up, _ := data["upstream"].(map[string]any); len(up) != 1 || up["error"] == nil {
+		t.Errorf("upstream down: got %v", data["upstream"])
+	}
+
+	down = false
+	data = get()
+	info := readBuildInfo()
+	if data["version"] != info.Version || data["go_version"] != runtime.Version() {
+		t.Errorf("got build %v", data)
+	}
+	for k := range data {
+		switch k {
+		case "version", "commit", "modified", "build_date", "go_version", "upstream":
+		default:
+			t.Errorf("unexpected member %q", k)
+		}
+	}
+	if up, _ := data["upstream"].(map[string]any); len(up) != 2 || up["rpc_version"] != float64(17) || up["version"] != "4.0.5 (a6fe2a64aa)" {
+		t.Errorf("got upstream %v", data["upstream"])
+	}
+
+	// the detected version is cached
+	get()
+	if calls != 2 {
+		t.Errorf("got %d upstream requests, want 2", calls)
+	}
+}
+
+func TestVersionHeader(t *testing.T) {
+	defer func(enabled bool) { versionHeaderEnabled = enabled }(versionHeaderEnabled)
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	for _, enabled := range []bool{true, false} {
+		versionHeaderEnabled = enabled
+		w := httptest.NewRecorder()
+		withVersionHeader(ok).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/transmission/web/", nil))
+
+		want := ""
+		if enabled {
+			want = readBuildInfo().short()
+		}
+		if got, set := w.Header()[versionHeader]; enabled != set || w.Header().Get(versionHeader) != want {
+			t.Errorf("enabled %v: got header %q", enabled, got)
+		}
+	}
+}
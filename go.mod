@@ -5,4 +5,9 @@ go 1.21
 require (
 	github.com/google/uuid v1.5.0
 	github.com/joho/godotenv v1.5.1
+	go.etcd.io/bbolt v1.3.8
+	golang.org/x/crypto v0.17.0
+	gopkg.in/yaml.v3 v3.0.1
 )
+
+require golang.org/x/sys v0.15.0 // indirect
@@ -0,0 +1,160 @@
+package apikeys
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"gopkg.in/yaml.v3"
+
+	"transmission-proxy/internal/jrpc"
+	"transmission-proxy/internal/ratelimit"
+)
+
+// Key is a static credential of an automation tool. Only SHA-256 of the token is stored.
+type Key struct {
+	Name        string   `yaml:"name"`
+	TokenSHA256 string   `yaml:"token_sha256"`
+	Methods     []string `yaml:"methods"`
+	// DownloadPrefix overrides DOWNLOAD_PREFIX for requests made with the key.
+	DownloadPrefix string `yaml:"download_prefix"`
+	// RateLimit is the allowed average number of requests per second, zero means unlimited.
+	RateLimit float64 `yaml:"rate_limit"`
+	Burst     int     `yaml:"burst"`
+
+	hash    []byte
+	methods map[string]bool
+	limiter *ratelimit.Bucket
+}
+
+// AllowsMethod reports whether the key may call the RPC method. Keys without methods list may call any.
+func (k *Key) AllowsMethod(method string) bool {
+	return k.methods == nil || k.methods[method]
+}
+
+// Limiter returns the rate limiter of the key, or nil if the key is not rate limited.
+func (k *Key) Limiter() *ratelimit.Bucket {
+	return k.limiter
+}
+
+type Store struct {
+	Keys []*Key `yaml:"keys"`
+}
+
+// Load reads the keys file, e.g.
+//
+//	keys:
+//	  - name: sonarr
+//	    token_sha256: 9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08
+//	    methods: [torrent-add, torrent-get, torrent-remove]
+//	    download_prefix: /downloads/tv/
+//	    rate_limit: 5
+//	    burst: 10
+func Load(path string) (*Store, error) {
+	bs, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var s Store
+	if err = yaml.Unmarshal(bs, &s); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+
+	names := map[string]bool{}
+	for i, k := range s.Keys {
+		if k.Name == "" {
+			return nil, fmt.Errorf("key #%d: name must be set", i+1)
+		}
+		if names[k.Name] {
+			return nil, fmt.Errorf("key %q: duplicate name", k.Name)
+		}
+		names[k.Name] = true
+
+		k.hash, err = hex.DecodeString(k.TokenSHA256)
+		if err != nil || len(k.hash) != sha256.Size {
+			return nil, fmt.Errorf("key %q: token_sha256 must be hex encoded SHA-256 of the token", k.Name)
+		}
+
+		if k.Methods != nil {
+			k.methods = make(map[string]bool, len(k.Methods))
+			for _, m := range k.Methods {
+				k.methods[m] = true
+			}
+		}
+
+		if k.RateLimit < 0 {
+			return nil, fmt.Errorf("key %q: rate_limit must not be negative", k.Name)
+		}
+		if k.RateLimit > 0 {
+			k.limiter = ratelimit.NewBucket(k.RateLimit, k.Burst)
+		}
+	}
+
+	return &s, nil
+}
+
+// Lookup finds the key by the presented token. All keys are compared in constant time, so that the time
+// taken does not depend on which key matched or how much of it.
+func (s *Store) Lookup(token string) *Key {
+	sum := sha256.Sum256([]byte(token))
+
+	var found *Key
+	for _, k := range s.Keys {
+		if subtle.ConstantTimeCompare(sum[:], k.hash) == 1 {
+			found = k
+		}
+	}
+
+	return found
+}
+
+// ByName finds the key by its name.
+func (s *Store) ByName(name string) *Key {
+	for _, k := range s.Keys {
+		if k.Name == name {
+			return k
+		}
+	}
+
+	return nil
+}
+
+type keyCtx struct{}
+
+func WithKey(ctx context.Context, k *Key) context.Context {
+	return context.WithValue(ctx, keyCtx{}, k)
+}
+
+// FromContext returns the key the request was authenticated with, or nil.
+func FromContext(ctx context.Context) *Key {
+	k, _ := ctx.Value(keyCtx{}).(*Key)
+	return k
+}
+
+// MethodNotAllowed is returned for RPC methods outside of the key scope.
+type MethodNotAllowed struct {
+	Key    string
+	Method string
+}
+
+func (e *MethodNotAllowed) Error() string {
+	return fmt.Sprintf("method %s is not allowed for API key %s", e.Method, e.Key)
+}
+
+func (e *MethodNotAllowed) GetLoggableAttrs() []slog.Attr {
+	return []slog.Attr{slog.String("api_key", e.Key)}
+}
+
+// MethodACL is a validation hook rejecting methods the API key of the request is not allowed to call.
+func MethodACL(ctx context.Context, req *jrpc.Request) error {
+	if k := FromContext(ctx); k != nil && !k.AllowsMethod(req.Method) {
+		return &MethodNotAllowed{Key: k.Name, Method: req.Method}
+	}
+
+	return nil
+}
@@ -0,0 +1,115 @@
+package apikeys
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"transmission-proxy/internal/jrpc"
+)
+
+func tokenHash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// loadKeys writes the keys file and loads it.
+func loadKeys(t *testing.T, yaml string) (*Store, error) {
+	path := filepath.Join(t.TempDir(), "keys.yaml")
+	if err := os.WriteFile(path, []byte(yaml), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	return Load(path)
+}
+
+func TestLoad(t *testing.T) {
+	s, err := loadKeys(t, `
+keys:
+  - name: sonarr
+    token_sha256: `+tokenHash("sonarr-token")+`
+    methods: [torrent-add, torrent-get, torrent-remove]
+    download_prefix: /downloads/tv/
+    rate_limit: 5
+    burst: 10
+  - name: backup
+    token_sha256: `+strings.ToUpper(tokenHash("backup-token"))+`
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sonarr := s.Lookup("sonarr-token")
+	if sonarr == nil || sonarr.Name != "sonarr" || sonarr.DownloadPrefix != "/downloads/tv/" || sonarr.Limiter() == nil {
+		t.Fatalf("got key %+v", sonarr)
+	}
+	if !sonarr.AllowsMethod("torrent-add") || sonarr.AllowsMethod("torrent-set-location") {
+		t.Errorf("sonarr methods %v not applied", sonarr.Methods)
+	}
+
+	backup := s.Lookup("backup-token")
+	if backup == nil || backup.Name != "backup" || backup.Limiter() != nil || !backup.AllowsMethod("session-set") {
+		t.Errorf("got key %+v, want unrestricted backup key", backup)
+	}
+
+	for _, token := range []string{"", "sonarr", "sonarr-token ", tokenHash("sonarr-token")} {
+		if k := s.Lookup(token); k != nil {
+			t.Errorf("token %q matched key %s", token, k.Name)
+		}
+	}
+	if s.ByName("sonarr") != sonarr || s.ByName("radarr") != nil {
+		t.Error("ByName did not find the keys by their names")
+	}
+}
+
+func TestLoadInvalid(t *testing.T) {
+	hash := tokenHash("token")
+	cases := []struct {
+		name, yaml, err string
+	}{
+		{name: "no name", yaml: "keys: [{token_sha256: " + hash + "}]", err: "key #1: name must be set"},
+		{name: "duplicate", yaml: "keys: [{name: a, token_sha256: " + hash + "}, {name: a, token_sha256: " + hash + "}]",
+			err: `key "a": duplicate name`},
+		{name: "plain token", yaml: "keys: [{name: a, token_sha256: token}]", err: `key "a": token_sha256 must be hex`},
+		{name: "short hash", yaml: "keys: [{name: a, token_sha256: " + hash[:32] + "}]", err: `key "a": token_sha256 must be hex`},
+		{name: "negative rate", yaml: "keys: [{name: a, token_sha256: " + hash + ", rate_limit: -1}]",
+			err: `key "a": rate_limit must not be negative`},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := loadKeys(t, tc.yaml); err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("got error %v, want %q", err, tc.err)
+			}
+		})
+	}
+}
+
+func TestMethodACL(t *testing.T) {
+	s, err := loadKeys(t, "keys: [{name: sonarr, token_sha256: "+tokenHash("t")+", methods: [torrent-get]}]")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := WithKey(context.Background(), s.Lookup("t"))
+
+	if err := MethodACL(ctx, &jrpc.Request{Method: "torrent-get"}); err != nil {
+		t.Errorf("allowed method: got %v", err)
+	}
+	if err := MethodACL(context.Background(), &jrpc.Request{Method: "session-set"}); err != nil {
+		t.Errorf("request without key: got %v", err)
+	}
+
+	err = MethodACL(ctx, &jrpc.Request{Method: "session-set"})
+	var mna *MethodNotAllowed
+	if !errors.As(err, &mna) || mna.Key != "sonarr" || mna.Method != "session-set" {
+		t.Fatalf("got %v, want MethodNotAllowed", err)
+	}
+	if attrs := mna.GetLoggableAttrs(); len(attrs) != 1 || attrs[0].Value.String() != "sonarr" {
+		t.Errorf("got attrs %v", attrs)
+	}
+}
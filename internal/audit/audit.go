@@ -0,0 +1,115 @@
+package audit
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+)
+
+// Record describes one mutating RPC call.
+type Record struct {
+	Time     time.Time `json:"time"`
+	ClientIP string    `json:"client_ip,omitempty"`
+	User     string    `json:"user,omitempty"`
+	// Impersonator is the admin who made the request on behalf of User.
+	Impersonator string `json:"impersonator,omitempty"`
+	// Path is the RPC endpoint the request arrived at, when there are several.
+	Path   string `json:"path,omitempty"`
+	Method string `json:"method"`
+	Tag    int    `json:"tag,omitempty"`
+	Ids    any    `json:"ids,omitempty"`
+	// Arguments are the arguments telling what the call changes, see RecordedArguments.
+	Arguments map[string]any `json:"arguments,omitempty"`
+	// Status is the HTTP status of the response sent to the client.
+	Status         int `json:"status,omitempty"`
+	UpstreamStatus int `json:"upstream_status,omitempty"`
+	// Policy which rejected the request, if any.
+	Policy string `json:"policy,omitempty"`
+	// Result of the call made by the proxy itself (e.g. scheduled), "success" or the error,
+	// or why the request was rejected.
+	Result string `json:"result,omitempty"`
+}
+
+// recordedArguments are the arguments of mutating calls worth recording, besides ids.
+var recordedArguments = []string{"location", "move", "download-dir", "filename", "metainfo", "delete-local-data", "paused"}
+
+// maxMetainfoLen is how much of base64-encoded metainfo is recorded, the rest is cut.
+const maxMetainfoLen = 64
+
+// RecordedArguments returns the arguments of the call telling what it changes, such as location and filename.
+// Metainfo of added torrents is truncated to keep the log small. It returns nil if there are none.
+func RecordedArguments(args map[string]any) map[string]any {
+	var out map[string]any
+	for _, name := range recordedArguments {
+		v, ok := args[name]
+		if !ok {
+			continue
+		}
+		if s, isString := v.(string); isString && name == "metainfo" && len(s) > maxMetainfoLen {
+			v = s[:maxMetainfoLen] + "..."
+		}
+
+		if out == nil {
+			out = map[string]any{}
+		}
+		out[name] = v
+	}
+
+	return out
+}
+
+// Log appends audit records to a file as JSON lines.
+type Log struct {
+	path string
+
+	mu sync.Mutex
+	f  *os.File
+}
+
+func Open(path string) (*Log, error) {
+	l := &Log{path: path}
+	if err := l.Reopen(); err != nil {
+		return nil, err
+	}
+
+	return l, nil
+}
+
+// Reopen reopens the file, e.g. after it was rotated.
+func (l *Log) Reopen() error {
+	f, err := os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o640)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.f != nil {
+		_ = l.f.Close()
+	}
+	l.f = f
+	return nil
+}
+
+func (l *Log) Write(rec *Record) error {
+	bs, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	_, err = l.f.Write(append(bs, '\n'))
+	return err
+}
+
+// Close closes the log file. Records written afterwards fail.
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.f.Close()
+}
@@ -0,0 +1,117 @@
+package audit
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRecordedArguments(t *testing.T) {
+	long := strings.Repeat("A", 100)
+	cases := []struct {
+		name string
+		args map[string]any
+		want map[string]any
+	}{
+		{name: "none", args: map[string]any{"ids": []any{1.0}, "fields": []any{"id"}}},
+		{name: "location", args: map[string]any{"ids": 1.0, "location": "/downloads/tv", "move": true},
+			want: map[string]any{"location": "/downloads/tv", "move": true}},
+		{name: "filename", args: map[string]any{"filename": "magnet:?xt=urn:btih:abc", "download-dir": "/downloads", "paused": false},
+			want: map[string]any{"filename": "magnet:?xt=urn:btih:abc", "download-dir": "/downloads", "paused": false}},
+		{name: "metainfo", args: map[string]any{"metainfo": long}, want: map[string]any{"metainfo": long[:maxMetainfoLen] + "..."}},
+		{name: "short metainfo", args: map[string]any{"metainfo": "ZDQ6aW5mb2Vl"}, want: map[string]any{"metainfo": "ZDQ6aW5mb2Vl"}},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := RecordedArguments(tc.args); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+// readRecords returns the records of the audit log file.
+func readRecords(t *testing.T, path string) []Record {
+	t.Helper()
+
+	bs, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var res []Record
+	for _, line := range strings.Split(strings.TrimSpace(string(bs)), "\n") {
+		var rec Record
+		if err := json.Unmarshal([]byte(line), &rec); err != nil {
+			t.Fatalf("bad record %q: %v", line, err)
+		}
+		res = append(res, rec)
+	}
+
+	return res
+}
+
+func TestLogReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	l, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := l.Write(&Record{Method: "torrent-add"}); err != nil {
+		t.Fatal(err)
+	}
+	// the log is rotated as by logrotate, the records after reopen go to the new file
+	if err := os.Rename(path, path+".1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Write(&Record{Method: "torrent-remove"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Reopen(); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Write(&Record{Method: "torrent-set"}); err != nil {
+		t.Fatal(err)
+	}
+
+	rotated, current := readRecords(t, path+".1"), readRecords(t, path)
+	if len(rotated) != 2 || rotated[0].Method != "torrent-add" || rotated[1].Method != "torrent-remove" {
+		t.Errorf("got rotated records %+v", rotated)
+	}
+	if len(current) != 1 || current[0].Method != "torrent-set" {
+		t.Errorf("got records %+v", current)
+	}
+
+	// the file is appended to, not truncated
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if l, err = Open(path); err != nil {
+		t.Fatal(err)
+	}
+	_ = l.Write(&Record{Method: "session-set"})
+	if n := len(readRecords(t, path)); n != 2 {
+		t.Errorf("got %d records after reopening, want 2", n)
+	}
+}
+
+func TestLogErrors(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := Open(filepath.Join(dir, "missing", "audit.log")); err == nil {
+		t.Error("got no error for missing directory")
+	}
+
+	l, err := Open(filepath.Join(dir, "audit.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = l.Close()
+	if err := l.Write(&Record{Method: "torrent-add"}); err == nil {
+		t.Error("got no error writing to closed log")
+	}
+}
@@ -0,0 +1,74 @@
+package authlog
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Reasons of authentication failures and rejections.
+const (
+	ReasonBadCredentials = "bad_credentials"
+	ReasonBadAPIKey      = "bad_api_key"
+	ReasonLockedOut      = "locked_out"
+	ReasonRateLimited    = "rate_limited"
+)
+
+// Writer appends authentication failures to a file in a format stable regardless of the logging configuration,
+// so that tools like fail2ban can rely on it. Every record is a single line:
+//
+//	2006-01-02T15:04:05Z client=192.0.2.1 reason=bad_credentials user="alice" path="/transmission/rpc"
+//
+// Time is in UTC, client is the resolved client address ("-" if unknown), user and path are quoted Go strings,
+// user is "" when the client presented no user name.
+type Writer struct {
+	path string
+
+	mu sync.Mutex
+	f  *os.File
+}
+
+func Open(path string) (*Writer, error) {
+	w := &Writer{path: path}
+	if err := w.Reopen(); err != nil {
+		return nil, err
+	}
+
+	return w, nil
+}
+
+// Reopen reopens the file, e.g. after it was rotated.
+func (w *Writer) Reopen() error {
+	f, err := os.OpenFile(w.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.f != nil {
+		_ = w.f.Close()
+	}
+	w.f = f
+	return nil
+}
+
+// Log writes the record. Writing is best-effort: errors are returned for the caller to log, but the request
+// handling should not depend on them.
+func (w *Writer) Log(t time.Time, clientIP, reason, user, path string) error {
+	if clientIP == "" {
+		clientIP = "-"
+	}
+
+	line := fmt.Sprintf("%s client=%s reason=%s user=%s path=%s\n",
+		t.UTC().Format(time.RFC3339), clientIP, reason, strconv.Quote(user), strconv.Quote(path))
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	_, err := w.f.WriteString(line)
+	return err
+}
@@ -0,0 +1,127 @@
+package authlog
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testTime = time.Date(2024, 1, 2, 15, 4, 5, 0, time.FixedZone("CET", 3600))
+
+func openLog(t *testing.T) (*Writer, string) {
+	path := filepath.Join(t.TempDir(), "auth-fail.log")
+	w, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return w, path
+}
+
+func readLines(t *testing.T, path string) []string {
+	bs, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return strings.Split(strings.TrimSuffix(string(bs), "\n"), "\n")
+}
+
+// failRegex is failregex of the fail2ban filter, with <ADDR> expanded as fail2ban does for the addresses used here.
+func failRegex(t *testing.T) *regexp.Regexp {
+	bs, err := os.ReadFile("../../contrib/fail2ban/transmission-proxy.conf")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, line := range strings.Split(string(bs), "\n") {
+		if re, ok := strings.CutPrefix(line, "failregex = "); ok {
+			return regexp.MustCompile(strings.ReplaceAll(re, "<ADDR>", `(?P<addr>[0-9a-f.:]+)`))
+		}
+	}
+
+	t.Fatal("no failregex in the filter")
+	return nil
+}
+
+func TestLog(t *testing.T) {
+	cases := []struct {
+		name, clientIP, reason, user, path string
+		want                               string
+	}{
+		{name: "bad credentials", clientIP: "192.0.2.1", reason: ReasonBadCredentials, user: "alice", path: "/transmission/rpc",
+			want: `2024-01-02T14:04:05Z client=192.0.2.1 reason=bad_credentials user="alice" path="/transmission/rpc"`},
+		{name: "bad API key", clientIP: "2001:db8::1", reason: ReasonBadAPIKey, path: "/transmission/rpc",
+			want: `2024-01-02T14:04:05Z client=2001:db8::1 reason=bad_api_key user="" path="/transmission/rpc"`},
+		{name: "locked out", clientIP: "192.0.2.1", reason: ReasonLockedOut, user: "alice", path: "/proxy/login",
+			want: `2024-01-02T14:04:05Z client=192.0.2.1 reason=locked_out user="alice" path="/proxy/login"`},
+		{name: "rate limited", clientIP: "192.0.2.1", reason: ReasonRateLimited, user: "sonarr", path: "/transmission/rpc",
+			want: `2024-01-02T14:04:05Z client=192.0.2.1 reason=rate_limited user="sonarr" path="/transmission/rpc"`},
+		{name: "unknown client", reason: ReasonBadCredentials, user: "alice", path: "/transmission/rpc",
+			want: `2024-01-02T14:04:05Z client=- reason=bad_credentials user="alice" path="/transmission/rpc"`},
+		// the user name is chosen by the client, it must not forge the rest of the line or another line
+		{name: "hostile user name", clientIP: "192.0.2.1", reason: ReasonBadCredentials,
+			user: "x\" client=203.0.113.5 reason=bad_credentials\n2024-01-02T14:04:05Z client=203.0.113.5", path: "/transmission/rpc",
+			want: `2024-01-02T14:04:05Z client=192.0.2.1 reason=bad_credentials ` +
+				`user="x\" client=203.0.113.5 reason=bad_credentials\n2024-01-02T14:04:05Z client=203.0.113.5" path="/transmission/rpc"`},
+	}
+
+	re := failRegex(t)
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			w, path := openLog(t)
+			if err := w.Log(testTime, tc.clientIP, tc.reason, tc.user, tc.path); err != nil {
+				t.Fatal(err)
+			}
+
+			lines := readLines(t, path)
+			if len(lines) != 1 || lines[0] != tc.want {
+				t.Fatalf("got %q, want %q", lines, tc.want)
+			}
+
+			m := re.FindStringSubmatch(lines[0])
+			if tc.clientIP == "" {
+				if m != nil {
+					t.Errorf("fail2ban matched the line without client address")
+				}
+				return
+			}
+			if m == nil || m[re.SubexpIndex("addr")] != tc.clientIP {
+				t.Errorf("fail2ban got %q, want client %s", m, tc.clientIP)
+			}
+		})
+	}
+}
+
+func TestReopen(t *testing.T) {
+	w, path := openLog(t)
+	if err := w.Log(testTime, "192.0.2.1", ReasonBadCredentials, "alice", "/"); err != nil {
+		t.Fatal(err)
+	}
+
+	// as logrotate does: rename, then signal to reopen
+	if err := os.Rename(path, path+".1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Log(testTime, "192.0.2.2", ReasonBadCredentials, "alice", "/"); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Reopen(); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Log(testTime, "192.0.2.3", ReasonBadCredentials, "alice", "/"); err != nil {
+		t.Fatal(err)
+	}
+
+	rotated := readLines(t, path+".1")
+	if len(rotated) != 2 || !strings.Contains(rotated[1], "client=192.0.2.2") {
+		t.Errorf("rotated file got %q", rotated)
+	}
+	current := readLines(t, path)
+	if len(current) != 1 || !strings.Contains(current[0], "client=192.0.2.3") {
+		t.Errorf("new file got %q", current)
+	}
+}
@@ -0,0 +1,128 @@
+package authz
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"transmission-proxy/internal/jrpc"
+	"transmission-proxy/internal/logger"
+	"transmission-proxy/internal/redact"
+	"transmission-proxy/internal/reqctx"
+)
+
+var ErrUnavailable = errors.New("authorization service unavailable")
+
+// Denied is returned when the external authorization service denies the request.
+type Denied struct {
+	Reason string
+}
+
+func (d *Denied) Error() string {
+	if d.Reason == "" {
+		return "denied by authorization service"
+	}
+
+	return "denied by authorization service: " + d.Reason
+}
+
+func (d *Denied) GetLoggableAttrs() []slog.Attr {
+	return []slog.Attr{logger.RPC(slog.String(logger.KeyAuthzReason, d.Reason))}
+}
+
+// Client asks external policy service whether the RPC request may be forwarded.
+type Client struct {
+	URL      string
+	Timeout  time.Duration
+	FailOpen bool
+	// SkipMethods are never sent to the authorization service (normally the read-only methods).
+	SkipMethods map[string]bool
+	Redactor    *redact.Redactor
+	HTTP        *http.Client
+}
+
+type request struct {
+	User      string         `json:"user"`
+	ClientIP  string         `json:"clientIP"`
+	Method    string         `json:"method"`
+	Arguments map[string]any `json:"arguments"`
+	Tag       int            `json:"tag"`
+}
+
+type decision struct {
+	Allow  bool   `json:"allow"`
+	Reason string `json:"reason"`
+}
+
+// Hook is the post-validation hook consulting the authorization service.
+func (c *Client) Hook(ctx context.Context, req *jrpc.Request) error {
+	if c.SkipMethods[req.Method] {
+		return nil
+	}
+
+	d, err := c.ask(ctx, req)
+	if err != nil {
+		err = logger.WithAttributes(fmt.Errorf("%w: %w", ErrUnavailable, err), logger.HTTP(slog.String(logger.KeyAuthzURL, c.URL)))
+		if c.FailOpen {
+			slog.WarnContext(ctx, "allowing request despite authorization failure: "+err.Error(), logger.IgnoredAttr(err))
+			return nil
+		}
+
+		return err
+	}
+
+	if !d.Allow {
+		return &Denied{Reason: d.Reason}
+	}
+
+	return nil
+}
+
+func (c *Client) ask(ctx context.Context, req *jrpc.Request) (*decision, error) {
+	ar := request{
+		User:      reqctx.User(ctx),
+		Method:    req.Method,
+		Arguments: c.Redactor.Map(req.Arguments),
+		Tag:       req.Tag,
+	}
+	if ip := reqctx.ClientIP(ctx); ip.IsValid() {
+		ar.ClientIP = ip.String()
+	}
+
+	bs, err := json.Marshal(ar)
+	if err != nil {
+		return nil, fmt.Errorf("serialize: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.Timeout)
+	defer cancel()
+
+	hr, err := http.NewRequestWithContext(ctx, http.MethodPost, c.URL, bytes.NewReader(bs))
+	if err != nil {
+		return nil, err
+	}
+	hr.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.HTTP.Do(hr)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	var d decision
+	if err = json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&d); err != nil {
+		return nil, fmt.Errorf("parse response: %w", err)
+	}
+
+	return &d, nil
+}
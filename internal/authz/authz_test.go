@@ -0,0 +1,119 @@
+package authz
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+
+	"transmission-proxy/internal/jrpc"
+	"transmission-proxy/internal/redact"
+	"transmission-proxy/internal/reqctx"
+)
+
+// fakeService answers every request with the decision after the delay and records the requests it got.
+type fakeService struct {
+	decision string
+	delay    time.Duration
+	requests []request
+}
+
+func (f *fakeService) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var req request
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	f.requests = append(f.requests, req)
+
+	select {
+	case <-time.After(f.delay):
+	case <-r.Context().Done():
+		return
+	}
+	_, _ = w.Write([]byte(f.decision))
+}
+
+func newClient(t *testing.T, f *fakeService, failOpen bool) *Client {
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+
+	return &Client{
+		URL:         srv.URL,
+		Timeout:     100 * time.Millisecond,
+		FailOpen:    failOpen,
+		SkipMethods: map[string]bool{"torrent-get": true},
+		Redactor:    redact.New(redact.DefaultFields),
+		HTTP:        srv.Client(),
+	}
+}
+
+func torrentAdd() (context.Context, *jrpc.Request) {
+	ctx := reqctx.WithUser(context.Background(), "alice")
+	ctx = reqctx.WithClientIP(ctx, netip.MustParseAddr("192.0.2.7"))
+	req := &jrpc.Request{Method: "torrent-add", Tag: 7, Arguments: map[string]any{"metainfo": "ZDg6YW5ub3VuY2U=", "paused": true}}
+
+	return ctx, req
+}
+
+func TestHookAllow(t *testing.T) {
+	f := &fakeService{decision: `{"allow": true}`}
+	c := newClient(t, f, false)
+
+	if err := c.Hook(torrentAdd()); err != nil {
+		t.Fatalf("got error %v", err)
+	}
+
+	if len(f.requests) != 1 {
+		t.Fatalf("service got %d requests, want 1", len(f.requests))
+	}
+	got := f.requests[0]
+	if got.User != "alice" || got.ClientIP != "192.0.2.7" || got.Method != "torrent-add" || got.Tag != 7 {
+		t.Errorf("got request %+v", got)
+	}
+	if got.Arguments["metainfo"] == "ZDg6YW5ub3VuY2U=" || got.Arguments["paused"] != true {
+		t.Errorf("got arguments %v, want metainfo redacted", got.Arguments)
+	}
+}
+
+func TestHookDeny(t *testing.T) {
+	f := &fakeService{decision: `{"allow": false, "reason": "quiet hours"}`}
+	c := newClient(t, f, true)
+
+	err := c.Hook(torrentAdd())
+	var denied *Denied
+	if !errors.As(err, &denied) || denied.Reason != "quiet hours" {
+		t.Fatalf("got error %v, want denial with the reason", err)
+	}
+	attrs := denied.GetLoggableAttrs()
+	if len(attrs) != 1 || attrs[0].Key != "rpc" || attrs[0].Value.Group()[0].Key != "authz_reason" {
+		t.Errorf("got loggable attributes %v, want rpc.authz_reason", attrs)
+	}
+}
+
+func TestHookTimeout(t *testing.T) {
+	f := &fakeService{decision: `{"allow": false}`, delay: time.Second}
+
+	if err := newClient(t, f, true).Hook(torrentAdd()); err != nil {
+		t.Errorf("fail open: got error %v", err)
+	}
+	if err := newClient(t, f, false).Hook(torrentAdd()); !errors.Is(err, ErrUnavailable) {
+		t.Errorf("fail closed: got error %v, want %v", err, ErrUnavailable)
+	}
+}
+
+func TestHookSkipMethods(t *testing.T) {
+	f := &fakeService{decision: `{"allow": false}`}
+	c := newClient(t, f, false)
+
+	if err := c.Hook(context.Background(), &jrpc.Request{Method: "torrent-get"}); err != nil {
+		t.Errorf("got error %v", err)
+	}
+	if len(f.requests) != 0 {
+		t.Errorf("skipped method was sent to the service")
+	}
+}
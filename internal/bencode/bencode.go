@@ -0,0 +1,129 @@
+package bencode
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// maxDepth limits nesting of lists and dictionaries, so that hostile input cannot exhaust the stack.
+const maxDepth = 64
+
+var ErrTrailingData = errors.New("trailing data after value")
+
+// Decode decodes the single bencoded value filling the whole input. Integers are decoded as int64,
+// strings as string, lists as []any and dictionaries as map[string]any.
+func Decode(bs []byte) (any, error) {
+	d := decoder{bs: bs}
+	v, err := d.value(0)
+	if err != nil {
+		return nil, err
+	}
+	if d.pos != len(bs) {
+		return nil, ErrTrailingData
+	}
+
+	return v, nil
+}
+
+type decoder struct {
+	bs  []byte
+	pos int
+}
+
+func (d *decoder) value(depth int) (any, error) {
+	if d.pos >= len(d.bs) {
+		return nil, d.errorf("unexpected end of data")
+	}
+	if depth > maxDepth {
+		return nil, d.errorf("nested too deep")
+	}
+
+	switch c := d.bs[d.pos]; {
+	case c == 'i':
+		d.pos++
+		return d.integer('e')
+	case c == 'l':
+		d.pos++
+		var list []any
+		for {
+			if d.pos < len(d.bs) && d.bs[d.pos] == 'e' {
+				d.pos++
+				return list, nil
+			}
+
+			v, err := d.value(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, v)
+		}
+	case c == 'd':
+		d.pos++
+		dict := map[string]any{}
+		for {
+			if d.pos < len(d.bs) && d.bs[d.pos] == 'e' {
+				d.pos++
+				return dict, nil
+			}
+
+			key, err := d.string()
+			if err != nil {
+				return nil, err
+			}
+			v, err := d.value(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			dict[key] = v
+		}
+	case c >= '0' && c <= '9':
+		return d.string()
+	default:
+		return nil, d.errorf("unexpected %q", c)
+	}
+}
+
+func (d *decoder) string() (string, error) {
+	n, err := d.integer(':')
+	if err != nil {
+		return "", err
+	}
+	if n < 0 || n > int64(len(d.bs)-d.pos) {
+		return "", d.errorf("string length %d out of range", n)
+	}
+
+	s := string(d.bs[d.pos : d.pos+int(n)])
+	d.pos += int(n)
+	return s, nil
+}
+
+// integer decodes the integer terminated by the delimiter. Only the canonical form is accepted: no sign
+// but minus, no leading zeros and no negative zero, so that every value has the only encoding.
+func (d *decoder) integer(delim byte) (int64, error) {
+	end := bytes.IndexByte(d.bs[d.pos:], delim)
+	if end < 0 {
+		return 0, d.errorf("unterminated integer")
+	}
+
+	digits := d.bs[d.pos : d.pos+end]
+	if len(digits) > 0 && digits[0] == '-' {
+		digits = digits[1:]
+	}
+	if len(digits) == 0 || digits[0] < '0' || digits[0] > '9' || digits[0] == '0' && end > 1 {
+		return 0, d.errorf("bad integer")
+	}
+
+	n, err := strconv.ParseInt(string(d.bs[d.pos:d.pos+end]), 10, 64)
+	if err != nil {
+		return 0, d.errorf("bad integer")
+	}
+
+	d.pos += end + 1
+	return n, nil
+}
+
+func (d *decoder) errorf(format string, args ...any) error {
+	return fmt.Errorf("offset %d: %s", d.pos, fmt.Sprintf(format, args...))
+}
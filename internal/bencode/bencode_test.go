@@ -0,0 +1,91 @@
+package bencode
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDecode(t *testing.T) {
+	cases := []struct {
+		name, in string
+		want     any
+	}{
+		{name: "integer", in: "i42e", want: int64(42)},
+		{name: "zero", in: "i0e", want: int64(0)},
+		{name: "negative", in: "i-42e", want: int64(-42)},
+		{name: "largest", in: "i9223372036854775807e", want: int64(9223372036854775807)},
+		{name: "smallest", in: "i-9223372036854775808e", want: int64(-9223372036854775808)},
+		{name: "string", in: "4:spam", want: "spam"},
+		{name: "empty string", in: "0:", want: ""},
+		{name: "binary string", in: "3:\x00\xff:", want: "\x00\xff:"},
+		{name: "list", in: "l4:spami3ee", want: []any{"spam", int64(3)}},
+		{name: "empty list", in: "le", want: []any(nil)},
+		{name: "dictionary", in: "d3:cow3:moo4:spaml1:a1:bee", want: map[string]any{"cow": "moo", "spam": []any{"a", "b"}}},
+		{name: "empty dictionary", in: "de", want: map[string]any{}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := Decode([]byte(tc.in))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got %#v, want %#v", got, tc.want)
+			}
+		})
+	}
+
+	// nesting up to the limit is fine
+	if _, err := Decode([]byte(strings.Repeat("l", maxDepth+1) + strings.Repeat("e", maxDepth+1))); err != nil {
+		t.Errorf("nesting limit: got error %v", err)
+	}
+}
+
+func TestDecodeMalformed(t *testing.T) {
+	cases := []struct {
+		name, in string
+		// err is the message expected in the error
+		err string
+	}{
+		{name: "empty", in: "", err: "unexpected end of data"},
+		{name: "unknown type", in: "x", err: `unexpected 'x'`},
+		{name: "unterminated integer", in: "i42", err: "unterminated integer"},
+		{name: "empty integer", in: "ie", err: "bad integer"},
+		{name: "not a number", in: "i4xe", err: "bad integer"},
+		{name: "leading zero", in: "i03e", err: "bad integer"},
+		{name: "negative zero", in: "i-0e", err: "bad integer"},
+		{name: "negative leading zero", in: "i-03e", err: "bad integer"},
+		{name: "plus sign", in: "i+3e", err: "bad integer"},
+		{name: "minus only", in: "i-e", err: "bad integer"},
+		{name: "overflow", in: "i9223372036854775808e", err: "bad integer"},
+		{name: "negative overflow", in: "i-9223372036854775809e", err: "bad integer"},
+		{name: "string beyond input", in: "5:spam", err: "string length 5 out of range"},
+		{name: "huge string length", in: "99999999999999999999:x", err: "bad integer"},
+		{name: "string length leading zero", in: "04:spam", err: "bad integer"},
+		{name: "negative string length", in: "-1:x", err: `unexpected '-'`},
+		{name: "unterminated string length", in: "4", err: "unterminated integer"},
+		{name: "unterminated list", in: "l4:spam", err: "unexpected end of data"},
+		{name: "unterminated dictionary", in: "d3:cow3:moo", err: "unterminated integer"},
+		{name: "dictionary key not string", in: "di1e3:mooe", err: "bad integer"},
+		{name: "dictionary value missing", in: "d3:cowe", err: "unexpected 'e'"},
+		{name: "too deep", in: strings.Repeat("l", maxDepth+2) + strings.Repeat("e", maxDepth+2), err: "nested too deep"},
+		{name: "too deep dictionaries", in: strings.Repeat("d1:a", maxDepth+2) + "i1e" + strings.Repeat("e", maxDepth+2), err: "nested too deep"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if v, err := Decode([]byte(tc.in)); err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("got %#v, error %v, want %q", v, err, tc.err)
+			}
+		})
+	}
+}
+
+func TestDecodeTrailingData(t *testing.T) {
+	for _, in := range []string{"i1ei2e", "4:spamx", "lee"} {
+		if _, err := Decode([]byte(in)); !errors.Is(err, ErrTrailingData) {
+			t.Errorf("%q: got error %v", in, err)
+		}
+	}
+}
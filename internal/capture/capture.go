@@ -0,0 +1,229 @@
+package capture
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"transmission-proxy/internal/logger"
+	"transmission-proxy/internal/redact"
+)
+
+// Verdicts of captured exchanges.
+const (
+	VerdictAccepted = "accepted"
+	VerdictRejected = "rejected"
+)
+
+// queueSize is the number of exchanges waiting to be written before new ones are dropped.
+const queueSize = 64
+
+// fileExt is the extension of capture files, named by the sequence number.
+const fileExt = ".json"
+
+// Exchange is one RPC request captured along with what the proxy did with it.
+type Exchange struct {
+	Seq      uint64    `json:"seq"`
+	Time     time.Time `json:"time"`
+	ClientIP string    `json:"client_ip,omitempty"`
+	User     string    `json:"user,omitempty"`
+	Method   string    `json:"method,omitempty"`
+	Tag      int       `json:"tag,omitempty"`
+	// Request is the body as received, with sensitive arguments redacted.
+	Request string `json:"request"`
+	// Forwarded is the body as forwarded upstream after validation and policies, if it was.
+	Forwarded    string `json:"forwarded,omitempty"`
+	Verdict      string `json:"verdict"`
+	RejectReason string `json:"reject_reason,omitempty"`
+	Status       int    `json:"status"`
+	// UpstreamStatus is zero if the request did not reach upstream.
+	UpstreamStatus  int    `json:"upstream_status,omitempty"`
+	ContentEncoding string `json:"content_encoding,omitempty"`
+	// Response is the body sent to the client, cut at the size limit of the Writer.
+	Response          string  `json:"response"`
+	ResponseTruncated bool    `json:"response_truncated,omitempty"`
+	DurationMs        float64 `json:"duration_ms"`
+}
+
+type file struct {
+	name string
+	size int64
+}
+
+// Writer stores captured exchanges in the directory as numbered JSON files, evicting the oldest ones
+// to stay within the limits on their count and total size. Exchanges are written in the background,
+// those which do not fit the queue are dropped so that capturing never slows down requests.
+type Writer struct {
+	dir      string
+	maxFiles int
+	maxBytes int64
+	redactor *redact.Redactor
+
+	enabled atomic.Bool
+	seq     atomic.Uint64
+	queue   chan *Exchange
+	dropped atomic.Uint64
+
+	// files are owned by Run
+	files []file
+	total int64
+}
+
+// Open prepares the directory for capture. Numbering continues after files already present there,
+// which count towards the limits.
+func Open(dir string, maxFiles int, maxBytes int64) (*Writer, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+
+	w := &Writer{
+		dir:      dir,
+		maxFiles: maxFiles,
+		maxBytes: maxBytes,
+		redactor: redact.New(redact.DefaultFields),
+		queue:    make(chan *Exchange, queueSize),
+	}
+
+	names, err := list(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		fi, err := os.Stat(filepath.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+
+		w.files = append(w.files, file{name: name, size: fi.Size()})
+		w.total += fi.Size()
+	}
+	if len(names) > 0 {
+		last, _ := strconv.ParseUint(strings.TrimSuffix(names[len(names)-1], fileExt), 10, 64)
+		w.seq.Store(last)
+	}
+
+	w.enabled.Store(true)
+	return w, nil
+}
+
+func (w *Writer) Enabled() bool {
+	return w.enabled.Load()
+}
+
+func (w *Writer) SetEnabled(enabled bool) {
+	w.enabled.Store(enabled)
+}
+
+func (w *Writer) Dir() string {
+	return w.dir
+}
+
+// Dropped returns the number of exchanges dropped as the queue was full.
+func (w *Writer) Dropped() uint64 {
+	return w.dropped.Load()
+}
+
+// Submit redacts and queues the exchange for writing, assigning its sequence number.
+func (w *Writer) Submit(ex *Exchange) {
+	ex.Request = string(w.redactor.Body([]byte(ex.Request)))
+	if ex.Forwarded != "" {
+		ex.Forwarded = string(w.redactor.Body([]byte(ex.Forwarded)))
+	}
+
+	select {
+	case w.queue <- ex:
+	default:
+		w.dropped.Add(1)
+	}
+}
+
+// Run writes the queued exchanges until the context is done.
+func (w *Writer) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case ex := <-w.queue:
+			if err := w.write(ex); err != nil {
+				slog.ErrorContext(ctx, "failed to write captured exchange: "+err.Error(), logger.IgnoredAttr(err))
+			}
+		}
+	}
+}
+
+func (w *Writer) write(ex *Exchange) error {
+	ex.Seq = w.seq.Add(1)
+	bs, err := json.MarshalIndent(ex, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("%010d%s", ex.Seq, fileExt)
+	if err := os.WriteFile(filepath.Join(w.dir, name), bs, 0o600); err != nil {
+		return err
+	}
+
+	w.files = append(w.files, file{name: name, size: int64(len(bs))})
+	w.total += int64(len(bs))
+
+	for len(w.files) > 1 && (len(w.files) > w.maxFiles || w.total > w.maxBytes) {
+		if err := os.Remove(filepath.Join(w.dir, w.files[0].name)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+
+		w.total -= w.files[0].size
+		w.files = w.files[1:]
+	}
+
+	return nil
+}
+
+// Load reads the exchanges captured in the directory, oldest first.
+func Load(dir string) ([]*Exchange, error) {
+	names, err := list(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]*Exchange, 0, len(names))
+	for _, name := range names {
+		bs, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+
+		var ex Exchange
+		if err := json.Unmarshal(bs, &ex); err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		res = append(res, &ex)
+	}
+
+	return res, nil
+}
+
+// list returns names of the capture files in the directory in the order they were written.
+func list(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasSuffix(e.Name(), fileExt) {
+			names = append(names, e.Name())
+		}
+	}
+	sort.Strings(names)
+
+	return names, nil
+}
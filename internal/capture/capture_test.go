@@ -0,0 +1,153 @@
+package capture
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// files returns names of the files in the directory.
+func files(t *testing.T, dir string) []string {
+	t.Helper()
+
+	names, err := list(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return names
+}
+
+// writeAll writes the exchanges as Run does.
+func writeAll(t *testing.T, w *Writer, exchanges ...*Exchange) {
+	t.Helper()
+
+	for _, ex := range exchanges {
+		if err := w.write(ex); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestWriterRedacts(t *testing.T) {
+	dir := t.TempDir()
+	w, err := Open(dir, 10, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go w.Run(ctx)
+
+	w.Submit(&Exchange{
+		Method:    "torrent-add",
+		Request:   `{"method":"torrent-add","arguments":{"metainfo":"c2VjcmV0IHRvcnJlbnQ=","cookies":"sid=1"}}`,
+		Forwarded: `{"method":"torrent-add","arguments":{"metainfo":"c2VjcmV0IHRvcnJlbnQ=","download-dir":"/downloads"}}`,
+		Verdict:   VerdictAccepted,
+	})
+	for deadline := time.Now().Add(5 * time.Second); len(files(t, dir)) == 0; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("exchange not written")
+		}
+	}
+
+	bs, err := os.ReadFile(filepath.Join(dir, "0000000001.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := string(bs); strings.Contains(s, "c2VjcmV0") || strings.Contains(s, "sid=1") || !strings.Contains(s, "download-dir") {
+		t.Errorf("sensitive arguments not redacted: %s", s)
+	}
+}
+
+func TestWriterEviction(t *testing.T) {
+	cases := []struct {
+		name     string
+		maxFiles int
+		maxBytes int64
+		want     []string
+	}{
+		{name: "count", maxFiles: 2, maxBytes: 1 << 20, want: []string{"0000000004.json", "0000000005.json"}},
+		// every file is about 200 bytes
+		{name: "size", maxFiles: 100, maxBytes: 700, want: []string{"0000000003.json", "0000000004.json", "0000000005.json"}},
+		// the last exchange is kept even if it exceeds the limit alone
+		{name: "large", maxFiles: 100, maxBytes: 10, want: []string{"0000000005.json"}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			w, err := Open(dir, tc.maxFiles, tc.maxBytes)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for i := 0; i < 5; i++ {
+				writeAll(t, w, &Exchange{Method: "torrent-get", Request: `{"method":"torrent-get"}`, Verdict: VerdictAccepted})
+			}
+			if got := files(t, dir); strings.Join(got, " ") != strings.Join(tc.want, " ") {
+				t.Errorf("got files %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestWriterReopen(t *testing.T) {
+	dir := t.TempDir()
+	w, err := Open(dir, 3, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeAll(t, w, &Exchange{Method: "session-get", Request: "{}"}, &Exchange{Method: "torrent-get", Request: "{}"})
+
+	// numbering continues, and the files already there count towards the limits
+	w, err = Open(dir, 3, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeAll(t, w, &Exchange{Method: "torrent-add", Request: "{}"}, &Exchange{Method: "torrent-stop", Request: "{}"})
+
+	exchanges, err := Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, ex := range exchanges {
+		got = append(got, ex.Method)
+	}
+	if strings.Join(got, " ") != "torrent-get torrent-add torrent-stop" || exchanges[0].Seq != 2 || exchanges[2].Seq != 4 {
+		t.Errorf("got exchanges %q, first #%d", got, exchanges[0].Seq)
+	}
+}
+
+func TestWriterDrops(t *testing.T) {
+	w, err := Open(t.TempDir(), 10, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// nothing writes the queue, so that it fills up without slowing the submitters down
+	for i := 0; i < queueSize+3; i++ {
+		w.Submit(&Exchange{Request: "{}"})
+	}
+	if n := w.Dropped(); n != 3 {
+		t.Errorf("got %d dropped exchanges, want 3", n)
+	}
+}
+
+func TestLoadMalformed(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "0000000001.json"), []byte("{"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	// other files are not captures
+	if err := os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("{"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Load(dir); err == nil || !strings.Contains(err.Error(), "0000000001.json") {
+		t.Errorf("got error %v", err)
+	}
+}
@@ -0,0 +1,117 @@
+package clientip
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"transmission-proxy/internal/logger"
+	"transmission-proxy/internal/reqctx"
+)
+
+// Resolver determines the real client address, trusting forwarding headers only from configured proxies.
+type Resolver struct {
+	TrustedProxies []netip.Prefix
+}
+
+// ParseTrustedProxies parses comma-separated list of CIDRs or single addresses.
+func ParseTrustedProxies(list string) ([]netip.Prefix, error) {
+	var res []netip.Prefix
+
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		if strings.Contains(item, "/") {
+			p, err := netip.ParsePrefix(item)
+			if err != nil {
+				return nil, fmt.Errorf("bad CIDR %q: %w", item, err)
+			}
+			res = append(res, p.Masked())
+			continue
+		}
+
+		ip, err := netip.ParseAddr(item)
+		if err != nil {
+			return nil, fmt.Errorf("bad address %q: %w", item, err)
+		}
+		res = append(res, netip.PrefixFrom(ip.Unmap(), ip.Unmap().BitLen()))
+	}
+
+	return res, nil
+}
+
+func (res *Resolver) trusted(ip netip.Addr) bool {
+	for _, p := range res.TrustedProxies {
+		if p.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Resolve returns the client address of the request. X-Forwarded-For is walked from the right,
+// skipping trusted proxies, and only if the direct peer is trusted itself.
+func (res *Resolver) Resolve(r *http.Request) netip.Addr {
+	peer := parseAddr(r.RemoteAddr)
+	if !peer.IsValid() || !res.trusted(peer) {
+		return peer
+	}
+
+	if xff := r.Header.Values("X-Forwarded-For"); len(xff) > 0 {
+		hops := strings.Split(strings.Join(xff, ","), ",")
+
+		client := peer
+		for i := len(hops) - 1; i >= 0; i-- {
+			ip := parseAddr(strings.TrimSpace(hops[i]))
+			if !ip.IsValid() {
+				break
+			}
+
+			client = ip
+			if !res.trusted(ip) {
+				break
+			}
+		}
+
+		return client
+	}
+
+	if ip := parseAddr(strings.TrimSpace(r.Header.Get("X-Real-IP"))); ip.IsValid() {
+		return ip
+	}
+
+	return peer
+}
+
+// Middleware resolves client address once and stores it in the request context for all other components.
+func (res *Resolver) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := res.Resolve(r)
+
+		ctx := reqctx.WithClientIP(r.Context(), ip)
+		if ip.IsValid() {
+			ctx = logger.ContextWithAttrs(ctx, logger.HTTPClientIP(ip.String()))
+		}
+
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func parseAddr(s string) netip.Addr {
+	if host, _, err := net.SplitHostPort(s); err == nil {
+		s = host
+	}
+
+	ip, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Addr{}
+	}
+
+	return ip.Unmap().WithZone("")
+}
@@ -0,0 +1,126 @@
+package clientip
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+
+	"transmission-proxy/internal/logger"
+	"transmission-proxy/internal/reqctx"
+)
+
+func testResolver(t *testing.T) *Resolver {
+	trusted, err := ParseTrustedProxies("10.0.0.0/8, 192.168.1.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &Resolver{TrustedProxies: trusted}
+}
+
+func TestResolve(t *testing.T) {
+	cases := []struct {
+		name   string
+		peer   string
+		header http.Header
+		want   string
+	}{
+		{name: "direct", peer: "203.0.113.5:4242", want: "203.0.113.5"},
+		{name: "direct ipv6", peer: "[2001:db8::1]:4242", want: "2001:db8::1"},
+		{name: "mapped ipv4", peer: "[::ffff:203.0.113.5]:4242", want: "203.0.113.5"},
+		{name: "trusted proxy", peer: "10.0.0.2:4242",
+			header: http.Header{"X-Forwarded-For": {"203.0.113.5"}}, want: "203.0.113.5"},
+		{name: "trusted chain", peer: "10.0.0.2:4242",
+			header: http.Header{"X-Forwarded-For": {"198.51.100.7, 203.0.113.5, 192.168.1.1"}}, want: "203.0.113.5"},
+		{name: "trusted chain in several headers", peer: "10.0.0.2:4242",
+			header: http.Header{"X-Forwarded-For": {"198.51.100.7", "203.0.113.5, 10.1.1.1"}}, want: "203.0.113.5"},
+		{name: "garbage in chain", peer: "10.0.0.2:4242",
+			header: http.Header{"X-Forwarded-For": {"nonsense, 203.0.113.5"}}, want: "203.0.113.5"},
+		{name: "real ip", peer: "192.168.1.1:4242",
+			header: http.Header{"X-Real-Ip": {"203.0.113.5"}}, want: "203.0.113.5"},
+		{name: "spoofed forwarded for", peer: "203.0.113.5:4242",
+			header: http.Header{"X-Forwarded-For": {"10.0.0.3"}}, want: "203.0.113.5"},
+		{name: "spoofed real ip", peer: "203.0.113.5:4242",
+			header: http.Header{"X-Real-Ip": {"127.0.0.1"}}, want: "203.0.113.5"},
+		{name: "spoofed behind trusted proxy", peer: "10.0.0.2:4242",
+			header: http.Header{"X-Forwarded-For": {"127.0.0.1, 203.0.113.5"}}, want: "203.0.113.5"},
+		{name: "unparsable peer", peer: "@", header: http.Header{"X-Forwarded-For": {"203.0.113.5"}}},
+	}
+
+	res := testResolver(t)
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tc.peer
+			for k, v := range tc.header {
+				r.Header[k] = v
+			}
+
+			got := res.Resolve(r)
+			if tc.want == "" {
+				if got.IsValid() {
+					t.Errorf("got %s, want none", got)
+				}
+				return
+			}
+			if got != netip.MustParseAddr(tc.want) {
+				t.Errorf("got %s, want %s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestParseTrustedProxies(t *testing.T) {
+	got, err := ParseTrustedProxies(" 10.1.2.3/8,,::1 ,::ffff:192.168.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []netip.Prefix{
+		netip.MustParsePrefix("10.0.0.0/8"),
+		netip.MustParsePrefix("::1/128"),
+		netip.MustParsePrefix("192.168.0.1/32"),
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	}
+
+	for _, bad := range []string{"10.0.0.0/33", "localhost"} {
+		if _, err := ParseTrustedProxies(bad); err == nil {
+			t.Errorf("%q: no error", bad)
+		}
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	var buf bytes.Buffer
+	prev := slog.Default()
+	slog.SetDefault(slog.New(logger.NewHandler(slog.NewJSONHandler(&buf, nil), "/")))
+	t.Cleanup(func() { slog.SetDefault(prev) })
+
+	var got netip.Addr
+	h := testResolver(t).Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = reqctx.ClientIP(r.Context())
+		slog.InfoContext(r.Context(), "handled")
+	}))
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.RemoteAddr = "10.0.0.2:4242"
+	r.Header.Set("X-Forwarded-For", "203.0.113.5")
+	h.ServeHTTP(httptest.NewRecorder(), r)
+
+	if got != netip.MustParseAddr("203.0.113.5") {
+		t.Errorf("got client ip %s in context", got)
+	}
+	if !strings.Contains(buf.String(), `"http":{"client_ip":"203.0.113.5"}`) {
+		t.Errorf("client ip not logged: %s", &buf)
+	}
+}
@@ -0,0 +1,58 @@
+package clientip
+
+import (
+	"net/http"
+	"strings"
+
+	"transmission-proxy/internal/reqctx"
+)
+
+// SetForwardHeaders sets X-Forwarded-For, X-Forwarded-Proto, X-Forwarded-Host and X-Real-IP of the request
+// forwarded upstream. Values sent by the peer are extended or kept only if the peer is a trusted proxy,
+// otherwise they are replaced with what the proxy sees itself.
+func (res *Resolver) SetForwardHeaders(in *http.Request, out http.Header) {
+	peer := parseAddr(in.RemoteAddr)
+	trusted := peer.IsValid() && res.trusted(peer)
+
+	xff := ""
+	if trusted {
+		xff = strings.Join(in.Header.Values("X-Forwarded-For"), ", ")
+	}
+	if peer.IsValid() {
+		if xff != "" {
+			xff += ", "
+		}
+		xff += peer.String()
+	}
+	setOrDelete(out, "X-Forwarded-For", xff)
+
+	if ip := reqctx.ClientIP(in.Context()); ip.IsValid() {
+		out.Set("X-Real-IP", ip.String())
+	} else {
+		out.Del("X-Real-IP")
+	}
+
+	proto := "http"
+	if in.TLS != nil {
+		proto = "https"
+	}
+	if p := in.Header.Get("X-Forwarded-Proto"); trusted && p != "" {
+		proto = p
+	}
+	out.Set("X-Forwarded-Proto", proto)
+
+	host := in.Host
+	if h := in.Header.Get("X-Forwarded-Host"); trusted && h != "" {
+		host = h
+	}
+	setOrDelete(out, "X-Forwarded-Host", host)
+}
+
+func setOrDelete(h http.Header, key, value string) {
+	if value == "" {
+		h.Del(key)
+		return
+	}
+
+	h.Set(key, value)
+}
@@ -0,0 +1,67 @@
+package clientip
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"transmission-proxy/internal/reqctx"
+)
+
+func TestSetForwardHeaders(t *testing.T) {
+	res := testResolver(t)
+
+	cases := []struct {
+		name   string
+		peer   string
+		tls    bool
+		header http.Header
+		// want are the headers sent upstream, empty values for the absent ones
+		want map[string]string
+	}{
+		{name: "direct", peer: "203.0.113.5:4242",
+			want: map[string]string{"X-Forwarded-For": "203.0.113.5", "X-Real-Ip": "203.0.113.5", "X-Forwarded-Proto": "http",
+				"X-Forwarded-Host": "proxy.example"}},
+		{name: "direct tls", peer: "203.0.113.5:4242", tls: true,
+			want: map[string]string{"X-Forwarded-For": "203.0.113.5", "X-Forwarded-Proto": "https"}},
+		{name: "spoofed", peer: "203.0.113.5:4242",
+			header: http.Header{"X-Forwarded-For": {"127.0.0.1"}, "X-Real-Ip": {"127.0.0.1"},
+				"X-Forwarded-Proto": {"https"}, "X-Forwarded-Host": {"admin.example"}},
+			want: map[string]string{"X-Forwarded-For": "203.0.113.5", "X-Real-Ip": "203.0.113.5", "X-Forwarded-Proto": "http",
+				"X-Forwarded-Host": "proxy.example"}},
+		{name: "trusted", peer: "10.0.0.2:4242",
+			header: http.Header{"X-Forwarded-For": {"203.0.113.5"}, "X-Forwarded-Proto": {"https"}, "X-Forwarded-Host": {"torrents.example"}},
+			want: map[string]string{"X-Forwarded-For": "203.0.113.5, 10.0.0.2", "X-Real-Ip": "203.0.113.5", "X-Forwarded-Proto": "https",
+				"X-Forwarded-Host": "torrents.example"}},
+		{name: "trusted chain in several headers", peer: "10.0.0.2:4242",
+			header: http.Header{"X-Forwarded-For": {"198.51.100.7", "203.0.113.5"}},
+			want:   map[string]string{"X-Forwarded-For": "198.51.100.7, 203.0.113.5, 10.0.0.2", "X-Forwarded-Proto": "http"}},
+		{name: "unparsable peer", peer: "@",
+			header: http.Header{"X-Forwarded-For": {"203.0.113.5"}, "X-Real-Ip": {"203.0.113.5"}},
+			want:   map[string]string{"X-Forwarded-For": "", "X-Real-Ip": ""}},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "http://proxy.example/", nil)
+			r.RemoteAddr = tc.peer
+			if tc.tls {
+				r.TLS = &tls.ConnectionState{}
+			}
+			for k, v := range tc.header {
+				r.Header[k] = v
+			}
+			r = r.WithContext(reqctx.WithClientIP(r.Context(), res.Resolve(r)))
+
+			// the request as forwarded starts with the headers of the client
+			out := r.Header.Clone()
+			res.SetForwardHeaders(r, out)
+			for k, want := range tc.want {
+				if got := out.Get(k); got != want {
+					t.Errorf("got %s %q, want %q", k, got, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,242 @@
+package completion
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"transmission-proxy/internal/jrpc"
+	"transmission-proxy/internal/logger"
+	"transmission-proxy/internal/ownership"
+	"transmission-proxy/internal/transmission"
+	"transmission-proxy/internal/upstream"
+	"transmission-proxy/internal/webhook"
+)
+
+// Transmission statuses of torrents whose data is being verified, which may look complete meanwhile.
+const (
+	statusCheckWait = 1
+	statusCheck     = 2
+)
+
+// pollFields are requested for all torrents on every poll, the rest only for completed ones.
+var (
+	pollFields   = []string{"hashString", "status", "percentDone", "doneDate"}
+	detailFields = []string{"id", "hashString", "name", "totalSize", "labels", "downloadDir", "doneDate"}
+)
+
+// Watcher polls upstream for torrents which have finished downloading and posts a webhook for each.
+//
+// A torrent is reported when it is complete and either was seen incomplete by a previous poll or finished
+// (by its doneDate) after the watermark, the latest doneDate reported so far. The first poll only sets
+// the watermark, unless it was loaded from StatePath, so that restarts report torrents completed meanwhile
+// but not everything completed before.
+type Watcher struct {
+	Upstream *upstream.Client
+	Notifier *webhook.Notifier
+	Interval time.Duration
+	// StatePath, if set, is the JSON file the watermark is kept in between restarts.
+	StatePath string
+	// Owners and OwnerLabelPrefix, if set, are used to find the user owning the completed torrent.
+	Owners           ownership.Store
+	OwnerLabelPrefix string
+
+	watermark   int64
+	initialized bool
+	incomplete  map[string]bool
+}
+
+// state is what the watcher persists between restarts.
+type state struct {
+	Watermark int64 `json:"watermark"`
+}
+
+// Event is posted to the webhook for every completed torrent.
+type Event struct {
+	Event   string  `json:"event"`
+	Torrent Torrent `json:"torrent"`
+	// User owning the torrent, if known.
+	User string `json:"user,omitempty"`
+}
+
+type Torrent struct {
+	ID          int64  `json:"id"`
+	Hash        string `json:"hash"`
+	Name        string `json:"name"`
+	Size        int64  `json:"size"`
+	Labels      any    `json:"labels"`
+	DownloadDir string `json:"downloadDir"`
+	DoneDate    int64  `json:"doneDate"`
+}
+
+// Run polls every Interval until the context is done.
+func (w *Watcher) Run(ctx context.Context) {
+	if err := w.load(); err != nil {
+		slog.WarnContext(ctx, "failed to load completion watcher state: "+err.Error(), logger.IgnoredAttr(err))
+	}
+
+	for {
+		if err := w.Poll(ctx); err != nil {
+			slog.WarnContext(ctx, "completion poll failed: "+err.Error(), logger.IgnoredAttr(err))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(w.Interval):
+		}
+	}
+}
+
+// Poll checks the torrents once, notifying about those completed since the previous poll.
+func (w *Watcher) Poll(ctx context.Context) error {
+	torrents, err := w.torrents(ctx, nil, pollFields)
+	if err != nil {
+		return err
+	}
+
+	incomplete := map[string]bool{}
+	var completed []any
+	watermark := w.watermark
+	for i := 0; i < torrents.Len(); i++ {
+		hash := torrents.String(i, "hashString")
+		status := torrents.Int64(i, "status")
+		if torrents.Float64(i, "percentDone") < 1 || status == statusCheckWait || status == statusCheck {
+			incomplete[hash] = true
+			continue
+		}
+
+		done := torrents.Int64(i, "doneDate")
+		if w.initialized && (w.incomplete[hash] || done > w.watermark) {
+			completed = append(completed, hash)
+		}
+		watermark = max(watermark, done)
+	}
+
+	if len(completed) > 0 {
+		if err := w.notify(ctx, completed); err != nil {
+			return err
+		}
+	}
+
+	w.incomplete = incomplete
+	w.initialized = true
+	if watermark != w.watermark {
+		w.watermark = watermark
+		w.save(ctx)
+	}
+
+	return nil
+}
+
+func (w *Watcher) notify(ctx context.Context, hashes []any) error {
+	torrents, err := w.torrents(ctx, hashes, detailFields)
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < torrents.Len(); i++ {
+		labels, _ := torrents.Get(i, "labels")
+		ev := Event{
+			Event: "completed",
+			Torrent: Torrent{
+				ID:          torrents.Int64(i, "id"),
+				Hash:        torrents.String(i, "hashString"),
+				Name:        torrents.String(i, "name"),
+				Size:        torrents.Int64(i, "totalSize"),
+				Labels:      labels,
+				DownloadDir: torrents.String(i, "downloadDir"),
+				DoneDate:    torrents.Int64(i, "doneDate"),
+			},
+		}
+		ev.User = w.owner(ev.Torrent.Hash, labels)
+
+		slog.InfoContext(ctx, "torrent completed", slog.String("torrent", ev.Torrent.Name), slog.String("hash", ev.Torrent.Hash))
+		if err := w.Notifier.Send(ctx, &ev); err != nil {
+			slog.ErrorContext(ctx, "failed to notify completion webhook: "+err.Error(), logger.IgnoredAttr(err))
+		}
+	}
+
+	return nil
+}
+
+func (w *Watcher) torrents(ctx context.Context, ids []any, fields []string) (*transmission.Torrents, error) {
+	args := map[string]any{"fields": fields}
+	if ids != nil {
+		args["ids"] = ids
+	}
+
+	resp, err := w.Upstream.Call(ctx, nil, &jrpc.Request{Method: "torrent-get", Arguments: args})
+	if err != nil {
+		return nil, fmt.Errorf("list torrents: %w", err)
+	}
+
+	torrents, err := transmission.ParseTorrents(resp.Arguments["torrents"])
+	if err != nil {
+		return nil, fmt.Errorf("list torrents: %w", err)
+	}
+
+	return torrents, nil
+}
+
+// owner returns the user owning the torrent by the ownership records or, failing that, by the owner label.
+func (w *Watcher) owner(hash string, labels any) string {
+	if w.Owners != nil {
+		if e, err := w.Owners.Get(strings.ToLower(hash)); err == nil && e != nil {
+			return e.User
+		}
+	}
+
+	if w.OwnerLabelPrefix != "" {
+		ls, _ := labels.([]any)
+		for _, l := range ls {
+			if s, ok := l.(string); ok && strings.HasPrefix(s, w.OwnerLabelPrefix) && len(s) > len(w.OwnerLabelPrefix) {
+				return s[len(w.OwnerLabelPrefix):]
+			}
+		}
+	}
+
+	return ""
+}
+
+func (w *Watcher) load() error {
+	if w.StatePath == "" {
+		return nil
+	}
+
+	bs, err := os.ReadFile(w.StatePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var st state
+	if err := json.Unmarshal(bs, &st); err != nil {
+		return fmt.Errorf("parse %s: %w", w.StatePath, err)
+	}
+
+	w.watermark = st.Watermark
+	w.initialized = true
+	return nil
+}
+
+func (w *Watcher) save(ctx context.Context) {
+	if w.StatePath == "" {
+		return
+	}
+
+	bs, err := json.Marshal(&state{Watermark: w.watermark})
+	if err == nil {
+		err = os.WriteFile(w.StatePath, bs, 0o600)
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to save completion watcher state: "+err.Error(), logger.IgnoredAttr(err))
+	}
+}
@@ -0,0 +1,297 @@
+package completion
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"transmission-proxy/internal/ownership"
+	"transmission-proxy/internal/upstream"
+	"transmission-proxy/internal/webhook"
+)
+
+// fakeUpstream answers torrent-get with its snapshot of torrents, restricted to the requested ids and
+// fields, recording the arguments of every request.
+type fakeUpstream struct {
+	mu       sync.Mutex
+	torrents []map[string]any
+	requests []map[string]any
+	down     bool
+}
+
+func (f *fakeUpstream) set(torrents ...map[string]any) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.torrents = torrents
+}
+
+func (f *fakeUpstream) RoundTrip(r *http.Request) (*http.Response, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.down {
+		return nil, errors.New("connection refused")
+	}
+
+	var req struct {
+		Arguments map[string]any `json:"arguments"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, err
+	}
+	f.requests = append(f.requests, req.Arguments)
+
+	ids, filtered := req.Arguments["ids"].([]any)
+	torrents := []map[string]any{}
+	for _, t := range f.torrents {
+		if filtered && !slices.Contains(ids, t["hashString"]) {
+			continue
+		}
+		res := map[string]any{}
+		for _, field := range req.Arguments["fields"].([]any) {
+			if v, ok := t[field.(string)]; ok {
+				res[field.(string)] = v
+			}
+		}
+		torrents = append(torrents, res)
+	}
+
+	w := httptest.NewRecorder()
+	_ = json.NewEncoder(w).Encode(map[string]any{"result": "success", "arguments": map[string]any{"torrents": torrents}})
+	return w.Result(), nil
+}
+
+// fakeWebhook records the events posted to it.
+type fakeWebhook struct {
+	mu     sync.Mutex
+	events []Event
+	status int
+}
+
+func (f *fakeWebhook) RoundTrip(r *http.Request) (*http.Response, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	bs, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	var ev Event
+	if err := json.Unmarshal(bs, &ev); err != nil {
+		return nil, err
+	}
+	f.events = append(f.events, ev)
+
+	w := httptest.NewRecorder()
+	w.WriteHeader(max(f.status, http.StatusNoContent))
+	return w.Result(), nil
+}
+
+// hashes returns the hashes of the torrents posted since the previous call.
+func (f *fakeWebhook) hashes() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var res []string
+	for _, ev := range f.events {
+		res = append(res, ev.Torrent.Hash)
+	}
+	f.events = nil
+
+	return res
+}
+
+// memoryStore is ownership.Store keeping records in memory.
+type memoryStore map[string]*ownership.Entry
+
+func (s memoryStore) Get(hash string) (*ownership.Entry, error) { return s[hash], nil }
+func (s memoryStore) Put(hash string, e *ownership.Entry) error { s[hash] = e; return nil }
+func (s memoryStore) Delete(hashes ...string) error {
+	for _, h := range hashes {
+		delete(s, h)
+	}
+	return nil
+}
+func (s memoryStore) All() (map[string]*ownership.Entry, error) { return s, nil }
+func (s memoryStore) Close() error                              { return nil }
+
+func testWatcher(up *fakeUpstream, hook *fakeWebhook, statePath string) *Watcher {
+	return &Watcher{
+		Upstream:  &upstream.Client{URL: "http://transmission:9091/transmission/rpc", HTTP: &http.Client{Transport: up}},
+		Notifier:  &webhook.Notifier{URL: "http://hooks/completed", Timeout: time.Second, HTTP: &http.Client{Transport: hook}},
+		Interval:  time.Hour,
+		StatePath: statePath,
+	}
+}
+
+func torrent(hash string, status int, percentDone float64, doneDate int64) map[string]any {
+	return map[string]any{
+		"id": len(hash), "hashString": hash, "name": "torrent " + hash, "status": status, "percentDone": percentDone,
+		"doneDate": doneDate, "totalSize": 1 << 20, "labels": []any{"tv", "owner:bob"}, "downloadDir": "/downloads/tv",
+	}
+}
+
+func poll(t *testing.T, w *Watcher) {
+	t.Helper()
+
+	if err := w.Poll(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPollTransition(t *testing.T) {
+	up, hook := &fakeUpstream{}, &fakeWebhook{}
+	w := testWatcher(up, hook, "")
+	w.Owners = memoryStore{"aa": {User: "alice"}}
+	w.OwnerLabelPrefix = "owner:"
+
+	// the first poll only learns what is there
+	up.set(torrent("aa", 4, 0.5, 0), torrent("bbb", 6, 1, 100), torrent("cccc", 4, 0.9, 0))
+	poll(t, w)
+	if got := hook.hashes(); got != nil {
+		t.Fatalf("first poll: notified %v", got)
+	}
+
+	up.set(torrent("aa", 6, 1, 200), torrent("bbb", 6, 1, 100), torrent("cccc", 0, 1, 150))
+	poll(t, w)
+	if got := hook.hashes(); !slices.Equal(got, []string{"aa", "cccc"}) {
+		t.Fatalf("second poll: notified %v, want the completed torrents", got)
+	}
+
+	// nothing changed
+	poll(t, w)
+	if got := hook.hashes(); got != nil {
+		t.Errorf("third poll: notified %v", got)
+	}
+
+	// only the minimal fields are polled, details are requested for the completed torrents
+	if n := len(up.requests); n != 4 {
+		t.Fatalf("got %d requests, want 4", n)
+	}
+	for _, i := range []int{0, 1, 3} {
+		if fields := up.requests[i]["fields"]; !slices.Equal(fields.([]any), []any{"hashString", "status", "percentDone", "doneDate"}) ||
+			up.requests[i]["ids"] != nil {
+			t.Errorf("poll #%d: got arguments %v", i+1, up.requests[i])
+		}
+	}
+	if ids := up.requests[2]["ids"]; !slices.Equal(ids.([]any), []any{"aa", "cccc"}) {
+		t.Errorf("details: got ids %v", ids)
+	}
+}
+
+func TestPollEvent(t *testing.T) {
+	up, hook := &fakeUpstream{}, &fakeWebhook{}
+	w := testWatcher(up, hook, "")
+	w.Owners = memoryStore{"aa": {User: "alice"}}
+	w.OwnerLabelPrefix = "owner:"
+
+	up.set(torrent("aa", 4, 0.5, 0), torrent("bbb", 4, 0.5, 0))
+	poll(t, w)
+	up.set(torrent("aa", 6, 1, 200), torrent("bbb", 6, 1, 200))
+	poll(t, w)
+
+	if len(hook.events) != 2 {
+		t.Fatalf("got events %v", hook.events)
+	}
+	// the owner is found by the ownership records, then by the owner label
+	want := Event{Event: "completed", User: "alice", Torrent: Torrent{ID: 2, Hash: "aa", Name: "torrent aa", Size: 1 << 20,
+		Labels: []any{"tv", "owner:bob"}, DownloadDir: "/downloads/tv", DoneDate: 200}}
+	if got, _ := json.Marshal(hook.events[0]); string(got) != string(mustMarshal(t, want)) {
+		t.Errorf("got event %s", got)
+	}
+	if u := hook.events[1].User; u != "bob" {
+		t.Errorf("got user %q by the label, want bob", u)
+	}
+}
+
+func mustMarshal(t *testing.T, v any) []byte {
+	bs, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return bs
+}
+
+func TestPollVerifying(t *testing.T) {
+	up, hook := &fakeUpstream{}, &fakeWebhook{}
+	w := testWatcher(up, hook, "")
+
+	up.set(torrent("aa", 4, 0.5, 0))
+	poll(t, w)
+
+	// the data being verified looks complete until it is checked
+	for _, status := range []int{statusCheckWait, statusCheck} {
+		up.set(torrent("aa", status, 1, 0))
+		poll(t, w)
+		if got := hook.hashes(); got != nil {
+			t.Errorf("status %d: notified %v", status, got)
+		}
+	}
+
+	up.set(torrent("aa", 6, 1, 0))
+	poll(t, w)
+	if got := hook.hashes(); !slices.Equal(got, []string{"aa"}) {
+		t.Errorf("after verification: notified %v", got)
+	}
+}
+
+func TestPollWatermark(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "completion.json")
+	up, hook := &fakeUpstream{}, &fakeWebhook{}
+
+	up.set(torrent("aa", 6, 1, 100), torrent("bbb", 4, 0.5, 0))
+	w := testWatcher(up, hook, path)
+	if err := w.load(); err != nil {
+		t.Fatal(err)
+	}
+	poll(t, w)
+
+	// torrents completed while the watcher was down are reported after the restart, the others are not
+	up.set(torrent("aa", 6, 1, 100), torrent("bbb", 6, 1, 150), torrent("cccc", 6, 1, 90))
+	w = testWatcher(up, hook, path)
+	if err := w.load(); err != nil {
+		t.Fatal(err)
+	}
+	poll(t, w)
+	if got := hook.hashes(); !slices.Equal(got, []string{"bbb"}) {
+		t.Errorf("after restart: notified %v", got)
+	}
+
+	// a torrent completed again after being re-added is reported once
+	up.set(torrent("aa", 6, 1, 300))
+	poll(t, w)
+	poll(t, w)
+	if got := hook.hashes(); !slices.Equal(got, []string{"aa"}) {
+		t.Errorf("re-completed: notified %v", got)
+	}
+}
+
+func TestPollFailures(t *testing.T) {
+	up, hook := &fakeUpstream{}, &fakeWebhook{status: http.StatusInternalServerError}
+	w := testWatcher(up, hook, "")
+
+	up.set(torrent("aa", 4, 0.5, 0))
+	poll(t, w)
+
+	// the watcher goes on after the webhook fails
+	up.set(torrent("aa", 6, 1, 100))
+	poll(t, w)
+	if got := hook.hashes(); !slices.Equal(got, []string{"aa"}) {
+		t.Errorf("notified %v", got)
+	}
+
+	up.down = true
+	if err := w.Poll(context.Background()); err == nil {
+		t.Error("got no error with upstream down")
+	}
+}
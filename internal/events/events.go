@@ -0,0 +1,131 @@
+package events
+
+import (
+	"sync"
+	"time"
+)
+
+// Event types.
+const (
+	TypeRPC       = "rpc"
+	TypeRejection = "rejection"
+)
+
+// Event describes an RPC request which passed through the proxy.
+type Event struct {
+	Type     string    `json:"type"`
+	Time     time.Time `json:"time"`
+	ClientIP string    `json:"client_ip,omitempty"`
+	User     string    `json:"user,omitempty"`
+	Method   string    `json:"method"`
+	Tag      int       `json:"tag,omitempty"`
+	Ids      any       `json:"ids,omitempty"`
+	Status   int       `json:"status,omitempty"`
+	// Reason is why the request was rejected.
+	Reason string `json:"reason,omitempty"`
+}
+
+// Bus fans events out to subscribers without ever blocking the publisher: subscribers which do not keep up
+// lose their oldest events.
+type Bus struct {
+	mu     sync.Mutex
+	subs   map[*Subscription]struct{}
+	closed bool
+}
+
+func NewBus() *Bus {
+	return &Bus{subs: map[*Subscription]struct{}{}}
+}
+
+// Subscription receives events accepted by its filter.
+type Subscription struct {
+	// C is closed when the subscription ends.
+	C      <-chan Event
+	c      chan Event
+	filter func(Event) bool
+
+	mu      sync.Mutex
+	dropped int
+}
+
+// Subscribe creates a subscription buffering up to size events. Nil filter accepts all events.
+func (b *Bus) Subscribe(size int, filter func(Event) bool) *Subscription {
+	c := make(chan Event, max(size, 1))
+	s := &Subscription{C: c, c: c, filter: filter}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		close(c)
+		return s
+	}
+
+	b.subs[s] = struct{}{}
+	return s
+}
+
+// Unsubscribe ends the subscription.
+func (b *Bus) Unsubscribe(s *Subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.subs[s]; ok {
+		delete(b.subs, s)
+		close(s.c)
+	}
+}
+
+// Publish delivers the event to the subscribers.
+func (b *Bus) Publish(e Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for s := range b.subs {
+		if s.filter == nil || s.filter(e) {
+			s.deliver(e)
+		}
+	}
+}
+
+// Close ends all subscriptions, e.g. on shutdown.
+func (b *Bus) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.closed = true
+	for s := range b.subs {
+		delete(b.subs, s)
+		close(s.c)
+	}
+}
+
+// Dropped returns the number of events dropped since the last call.
+func (s *Subscription) Dropped() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := s.dropped
+	s.dropped = 0
+	return n
+}
+
+// deliver sends the event, dropping the oldest buffered one if the buffer is full. Called with the bus locked,
+// so that it does not race with closing the channel.
+func (s *Subscription) deliver(e Event) {
+	for {
+		select {
+		case s.c <- e:
+			return
+		default:
+		}
+
+		select {
+		case <-s.c:
+			s.mu.Lock()
+			s.dropped++
+			s.mu.Unlock()
+		default:
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package events
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+// received returns the tags of the events buffered for the subscription.
+func received(s *Subscription) []int {
+	var res []int
+	for {
+		select {
+		case e, ok := <-s.C:
+			if !ok {
+				return res
+			}
+			res = append(res, e.Tag)
+		default:
+			return res
+		}
+	}
+}
+
+func TestBusFilter(t *testing.T) {
+	b := NewBus()
+	all := b.Subscribe(10, nil)
+	adds := b.Subscribe(10, func(e Event) bool { return e.Method == "torrent-add" })
+
+	for i, method := range []string{"torrent-add", "torrent-remove", "torrent-add"} {
+		b.Publish(Event{Type: TypeRPC, Method: method, Tag: i + 1})
+	}
+
+	if got := received(all); !slices.Equal(got, []int{1, 2, 3}) {
+		t.Errorf("unfiltered: got %v", got)
+	}
+	if got := received(adds); !slices.Equal(got, []int{1, 3}) {
+		t.Errorf("filtered: got %v", got)
+	}
+}
+
+func TestBusDropsOldest(t *testing.T) {
+	b := NewBus()
+	slow := b.Subscribe(3, nil)
+	fast := b.Subscribe(10, nil)
+
+	done := make(chan struct{})
+	go func() {
+		for i := 1; i <= 8; i++ {
+			b.Publish(Event{Tag: i})
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("publishing blocked on a slow subscriber")
+	}
+
+	if got := received(slow); !slices.Equal(got, []int{6, 7, 8}) {
+		t.Errorf("slow subscriber: got %v, want the latest events", got)
+	}
+	if n := slow.Dropped(); n != 5 {
+		t.Errorf("got %d dropped, want 5", n)
+	}
+	if n := slow.Dropped(); n != 0 {
+		t.Errorf("got %d dropped after reading the count, want 0", n)
+	}
+	if got := received(fast); len(got) != 8 || fast.Dropped() != 0 {
+		t.Errorf("fast subscriber: got %v", got)
+	}
+}
+
+func TestBusUnsubscribe(t *testing.T) {
+	b := NewBus()
+	s := b.Subscribe(10, nil)
+	b.Publish(Event{Tag: 1})
+	b.Unsubscribe(s)
+	b.Unsubscribe(s)
+	b.Publish(Event{Tag: 2})
+
+	// the buffered events are still received before the channel is found closed
+	if got := received(s); !slices.Equal(got, []int{1}) {
+		t.Errorf("got %v", got)
+	}
+	if _, ok := <-s.C; ok {
+		t.Error("channel not closed")
+	}
+}
+
+func TestBusClose(t *testing.T) {
+	b := NewBus()
+	s := b.Subscribe(10, nil)
+	b.Close()
+
+	if _, ok := <-s.C; ok {
+		t.Error("subscription not ended on close")
+	}
+	// ending the subscription after the bus closed is harmless
+	b.Unsubscribe(s)
+	b.Publish(Event{Tag: 1})
+
+	late := b.Subscribe(10, nil)
+	if _, ok := <-late.C; ok {
+		t.Error("subscription to the closed bus not ended")
+	}
+}
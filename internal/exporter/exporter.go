@@ -0,0 +1,250 @@
+package exporter
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	"transmission-proxy/internal/jrpc"
+	"transmission-proxy/internal/logger"
+	"transmission-proxy/internal/transmission"
+	"transmission-proxy/internal/upstream"
+)
+
+// Exported metrics, all gauges unless noted:
+//
+//	transmission_torrents{status}                 torrents by status (stopped, check_wait, check, download_wait, download, seed_wait, seed)
+//	transmission_torrents_by_label{label}         torrents by label (only with PerLabel)
+//	transmission_active_torrents                  torrents transferring data
+//	transmission_download_rate_bytes              current download rate, bytes per second
+//	transmission_upload_rate_bytes                current upload rate, bytes per second
+//	transmission_downloaded_bytes_total           downloaded over all sessions (counter)
+//	transmission_uploaded_bytes_total             uploaded over all sessions (counter)
+//	transmission_free_space_bytes{path}           free space in the download directory
+//	transmission_exporter_up                      1 if the last collection succeeded
+//	transmission_exporter_last_success_timestamp_seconds  time of the last successful collection
+//
+// Values are collected in the background, so scrapes never wait for upstream; when upstream is down
+// the last collected values are served and transmission_exporter_up is 0.
+
+// collectTimeout limits time of a single collection.
+const collectTimeout = 30 * time.Second
+
+// Collector periodically collects statistics of the Transmission daemon.
+type Collector struct {
+	Upstream *upstream.Client
+	Interval time.Duration
+	// Path is the download directory to report free space of.
+	Path string
+	// PerLabel enables torrent counts by label, which may have high cardinality.
+	PerLabel bool
+
+	mu          sync.Mutex
+	snap        *snapshot
+	up          bool
+	lastSuccess time.Time
+}
+
+type snapshot struct {
+	byStatus   map[string]int
+	byLabel    map[string]int
+	active     int64
+	download   int64
+	upload     int64
+	downloaded int64
+	uploaded   int64
+	freeSpace  int64
+}
+
+// Run collects every Interval until the context is done.
+func (c *Collector) Run(ctx context.Context) {
+	for {
+		cctx, cancel := context.WithTimeout(ctx, collectTimeout)
+		snap, err := c.collect(cctx)
+		cancel()
+
+		c.mu.Lock()
+		c.up = err == nil
+		if err == nil {
+			c.snap, c.lastSuccess = snap, time.Now()
+		}
+		c.mu.Unlock()
+
+		if err != nil {
+			slog.WarnContext(ctx, "failed to collect upstream statistics: "+err.Error(), logger.IgnoredAttr(err))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(c.Interval):
+		}
+	}
+}
+
+func (c *Collector) collect(ctx context.Context) (*snapshot, error) {
+	snap := &snapshot{byStatus: map[string]int{}, byLabel: map[string]int{}}
+
+	stats, err := c.Upstream.Call(ctx, nil, &jrpc.Request{Method: "session-stats"})
+	if err != nil {
+		return nil, fmt.Errorf("session-stats: %w", err)
+	}
+
+	snap.active = number(stats.Arguments["activeTorrentCount"])
+	snap.download = number(stats.Arguments["downloadSpeed"])
+	snap.upload = number(stats.Arguments["uploadSpeed"])
+	if cumulative, ok := stats.Arguments["cumulative-stats"].(map[string]any); ok {
+		snap.downloaded = number(cumulative["downloadedBytes"])
+		snap.uploaded = number(cumulative["uploadedBytes"])
+	}
+
+	fields := []string{"status"}
+	if c.PerLabel {
+		fields = append(fields, "labels")
+	}
+
+	resp, err := c.Upstream.Call(ctx, nil, &jrpc.Request{
+		Method:    "torrent-get",
+		Arguments: map[string]any{"fields": fields, "format": transmission.FormatTable},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("torrent-get: %w", err)
+	}
+
+	torrents, err := transmission.ParseTorrents(resp.Arguments["torrents"])
+	if err != nil {
+		return nil, fmt.Errorf("torrent-get: %w", err)
+	}
+
+	for _, name := range transmission.StatusNames() {
+		snap.byStatus[name] = 0
+	}
+	for i := 0; i < torrents.Len(); i++ {
+		snap.byStatus[transmission.StatusName(torrents.Int64(i, "status"))]++
+
+		if c.PerLabel {
+			labels, _ := torrents.Get(i, "labels")
+			ls, _ := labels.([]any)
+			for _, l := range ls {
+				if s, ok := l.(string); ok {
+					snap.byLabel[s]++
+				}
+			}
+		}
+	}
+
+	if c.Path != "" {
+		fs, err := c.Upstream.Call(ctx, nil, &jrpc.Request{Method: "free-space", Arguments: map[string]any{"path": c.Path}})
+		if err != nil {
+			return nil, fmt.Errorf("free-space: %w", err)
+		}
+		snap.freeSpace = number(fs.Arguments["size-bytes"])
+	}
+
+	return snap, nil
+}
+
+func number(v any) int64 {
+	n, _ := v.(json.Number)
+	res, _ := n.Int64()
+	return res
+}
+
+// WritePrometheus writes the last collected values in Prometheus text format.
+func (c *Collector) WritePrometheus(w io.Writer) error {
+	c.mu.Lock()
+	snap, up, lastSuccess := c.snap, c.up, c.lastSuccess
+	c.mu.Unlock()
+
+	ew := &errWriter{w: w}
+
+	ew.printf("# HELP transmission_exporter_up Whether the last collection of upstream statistics succeeded.\n")
+	ew.printf("# TYPE transmission_exporter_up gauge\n")
+	ew.printf("transmission_exporter_up %d\n", boolToInt(up))
+
+	if snap == nil {
+		return ew.err
+	}
+
+	ew.printf("# HELP transmission_exporter_last_success_timestamp_seconds Time of the last successful collection.\n")
+	ew.printf("# TYPE transmission_exporter_last_success_timestamp_seconds gauge\n")
+	ew.printf("transmission_exporter_last_success_timestamp_seconds %d\n", lastSuccess.Unix())
+
+	ew.printf("# HELP transmission_torrents Torrents by status.\n")
+	ew.printf("# TYPE transmission_torrents gauge\n")
+	for _, status := range sortedKeys(snap.byStatus) {
+		ew.printf("transmission_torrents{status=%q} %d\n", status, snap.byStatus[status])
+	}
+
+	if c.PerLabel {
+		ew.printf("# HELP transmission_torrents_by_label Torrents by label.\n")
+		ew.printf("# TYPE transmission_torrents_by_label gauge\n")
+		for _, label := range sortedKeys(snap.byLabel) {
+			ew.printf("transmission_torrents_by_label{label=%q} %d\n", label, snap.byLabel[label])
+		}
+	}
+
+	ew.printf("# HELP transmission_active_torrents Torrents transferring data.\n")
+	ew.printf("# TYPE transmission_active_torrents gauge\n")
+	ew.printf("transmission_active_torrents %d\n", snap.active)
+
+	ew.printf("# HELP transmission_download_rate_bytes Current download rate in bytes per second.\n")
+	ew.printf("# TYPE transmission_download_rate_bytes gauge\n")
+	ew.printf("transmission_download_rate_bytes %d\n", snap.download)
+
+	ew.printf("# HELP transmission_upload_rate_bytes Current upload rate in bytes per second.\n")
+	ew.printf("# TYPE transmission_upload_rate_bytes gauge\n")
+	ew.printf("transmission_upload_rate_bytes %d\n", snap.upload)
+
+	ew.printf("# HELP transmission_downloaded_bytes_total Bytes downloaded over all sessions.\n")
+	ew.printf("# TYPE transmission_downloaded_bytes_total counter\n")
+	ew.printf("transmission_downloaded_bytes_total %d\n", snap.downloaded)
+
+	ew.printf("# HELP transmission_uploaded_bytes_total Bytes uploaded over all sessions.\n")
+	ew.printf("# TYPE transmission_uploaded_bytes_total counter\n")
+	ew.printf("transmission_uploaded_bytes_total %d\n", snap.uploaded)
+
+	if c.Path != "" {
+		ew.printf("# HELP transmission_free_space_bytes Free space in the download directory.\n")
+		ew.printf("# TYPE transmission_free_space_bytes gauge\n")
+		ew.printf("transmission_free_space_bytes{path=%q} %d\n", c.Path, snap.freeSpace)
+	}
+
+	return ew.err
+}
+
+func sortedKeys(m map[string]int) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+
+	return 0
+}
+
+type errWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (e *errWriter) printf(format string, args ...any) {
+	if e.err != nil {
+		return
+	}
+
+	_, e.err = fmt.Fprintf(e.w, format, args...)
+}
@@ -0,0 +1,142 @@
+package exporter
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"transmission-proxy/internal/jrpc"
+	"transmission-proxy/internal/upstream"
+)
+
+// statsUpstream answers the requests of the collector, or fails while down.
+type statsUpstream struct {
+	down  atomic.Bool
+	calls atomic.Int32
+}
+
+func (s *statsUpstream) RoundTrip(r *http.Request) (*http.Response, error) {
+	s.calls.Add(1)
+	if s.down.Load() {
+		return nil, errors.New("connection refused")
+	}
+
+	var req jrpc.Request
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, err
+	}
+
+	var args string
+	switch req.Method {
+	case "session-stats":
+		args = `{"activeTorrentCount":2,"downloadSpeed":1000,"uploadSpeed":200,` +
+			`"cumulative-stats":{"downloadedBytes":123456,"uploadedBytes":7890}}`
+	case "torrent-get":
+		if req.Arguments["format"] != "table" {
+			return nil, errors.New("torrent-get not in table format")
+		}
+		args = `{"torrents":[["status","labels"],[4,["tv"]],[6,["tv","hd"]],[6,[]],[0,null]]}`
+	case "free-space":
+		args = `{"path":"` + req.Arguments["path"].(string) + `","size-bytes":5000000}`
+	}
+
+	w := httptest.NewRecorder()
+	_, _ = w.WriteString(`{"arguments":` + args + `,"result":"success"}`)
+
+	return w.Result(), nil
+}
+
+// scrape returns the metrics once the collector has collected more than n times.
+func scrape(t *testing.T, c *Collector, up *statsUpstream, n int32) string {
+	t.Helper()
+
+	for deadline := time.Now().Add(5 * time.Second); up.calls.Load() <= n; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("nothing collected")
+		}
+	}
+	// the collection in progress completes
+	time.Sleep(50 * time.Millisecond)
+
+	var buf bytes.Buffer
+	if err := c.WritePrometheus(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.String()
+}
+
+func TestCollector(t *testing.T) {
+	up := &statsUpstream{}
+	c := &Collector{
+		Upstream: &upstream.Client{URL: "http://transmission:9091/transmission/rpc", HTTP: &http.Client{Transport: up}},
+		Interval: time.Hour,
+		Path:     "/downloads/",
+		PerLabel: true,
+	}
+
+	// nothing is reported before the first collection
+	var buf bytes.Buffer
+	if err := c.WritePrometheus(&buf); err != nil || buf.String() != "# HELP transmission_exporter_up Whether the last collection of upstream statistics succeeded.\n"+
+		"# TYPE transmission_exporter_up gauge\ntransmission_exporter_up 0\n" {
+		t.Errorf("got metrics %s, error %v", &buf, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go c.Run(ctx)
+
+	metrics := scrape(t, c, up, 2)
+	for _, m := range []string{
+		"transmission_exporter_up 1\n",
+		`transmission_torrents{status="download"} 1` + "\n",
+		`transmission_torrents{status="seed"} 2` + "\n",
+		`transmission_torrents{status="stopped"} 1` + "\n",
+		// statuses without torrents are reported too, so that series do not disappear
+		`transmission_torrents{status="check"} 0` + "\n",
+		`transmission_torrents_by_label{label="hd"} 1` + "\n",
+		`transmission_torrents_by_label{label="tv"} 2` + "\n",
+		"transmission_active_torrents 2\n",
+		"transmission_download_rate_bytes 1000\n",
+		"transmission_upload_rate_bytes 200\n",
+		"# TYPE transmission_downloaded_bytes_total counter\ntransmission_downloaded_bytes_total 123456\n",
+		"transmission_uploaded_bytes_total 7890\n",
+		`transmission_free_space_bytes{path="/downloads/"} 5000000` + "\n",
+	} {
+		if !strings.Contains(metrics, m) {
+			t.Errorf("%q missing from metrics:\n%s", m, metrics)
+		}
+	}
+}
+
+func TestCollectorDown(t *testing.T) {
+	up := &statsUpstream{}
+	c := &Collector{
+		Upstream: &upstream.Client{URL: "http://transmission:9091/transmission/rpc", HTTP: &http.Client{Transport: up}},
+		Interval: 10 * time.Millisecond,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go c.Run(ctx)
+
+	before := scrape(t, c, up, 1)
+	up.down.Store(true)
+	calls := up.calls.Load()
+
+	// the last values are served while upstream is down
+	after := scrape(t, c, up, calls)
+	if want := strings.Replace(before, "transmission_exporter_up 1\n", "transmission_exporter_up 0\n", 1); after != want {
+		t.Errorf("got metrics:\n%s\nwant:\n%s", after, want)
+	}
+	if strings.Contains(after, "label=") || strings.Contains(after, "free_space") {
+		t.Errorf("disabled metrics reported:\n%s", after)
+	}
+}
@@ -0,0 +1,126 @@
+package fault
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Faults which may be injected.
+const (
+	// Latency delays forwarding of the request by Delay.
+	Latency = "latency"
+	// SessionChurn answers 409 with a new session id, as if Transmission was restarted.
+	SessionChurn = "session_churn"
+	// BadGateway answers 502 without forwarding the request.
+	BadGateway = "bad_gateway"
+	// Unavailable answers 503 without forwarding the request.
+	Unavailable = "unavailable"
+	// Truncate cuts the response body after Bytes bytes.
+	Truncate = "truncate"
+	// Slow streams the response body in chunks of Bytes bytes, waiting Delay before each.
+	Slow = "slow"
+)
+
+var faults = []string{Latency, SessionChurn, BadGateway, Unavailable, Truncate, Slow}
+
+// Rule injects the fault into requests for the methods (any if empty) with the probability.
+type Rule struct {
+	Fault       string   `json:"fault"`
+	Probability float64  `json:"probability"`
+	Methods     []string `json:"methods,omitempty"`
+	Delay       Duration `json:"delay,omitempty"`
+	Bytes       int      `json:"bytes,omitempty"`
+}
+
+// Duration is time.Duration written as string in JSON, e.g. "500ms".
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(bs []byte) error {
+	var s string
+	if err := json.Unmarshal(bs, &s); err != nil {
+		return err
+	}
+
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+
+	*d = Duration(v)
+	return nil
+}
+
+// Parse parses JSON array of rules.
+func Parse(bs []byte) ([]Rule, error) {
+	var rules []Rule
+	if err := json.Unmarshal(bs, &rules); err != nil {
+		return nil, err
+	}
+
+	for i, r := range rules {
+		if !slices.Contains(faults, r.Fault) {
+			return nil, fmt.Errorf("rule #%d: unknown fault %q", i+1, r.Fault)
+		}
+		if r.Probability < 0 || r.Probability > 1 {
+			return nil, fmt.Errorf("rule #%d: probability must be between 0 and 1", i+1)
+		}
+		if r.Delay < 0 || r.Bytes < 0 {
+			return nil, fmt.Errorf("rule #%d: delay and bytes must not be negative", i+1)
+		}
+	}
+
+	return rules, nil
+}
+
+// Injector decides which faults to inject into requests. Decisions are drawn from the RNG seeded
+// on creation, so the same sequence of requests gets the same faults.
+type Injector struct {
+	mu    sync.Mutex
+	rules []Rule
+	rng   *rand.Rand
+}
+
+func New(rules []Rule, seed int64) *Injector {
+	return &Injector{rules: rules, rng: rand.New(rand.NewSource(seed))}
+}
+
+func (i *Injector) Rules() []Rule {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	return slices.Clone(i.rules)
+}
+
+func (i *Injector) SetRules(rules []Rule) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.rules = rules
+}
+
+// Decide returns the rules which fire for the request for the method. Every rule matching the method
+// draws from the RNG whether it fires or not.
+func (i *Injector) Decide(method string) []Rule {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	var res []Rule
+	for _, r := range i.rules {
+		if len(r.Methods) > 0 && !slices.Contains(r.Methods, method) {
+			continue
+		}
+		if i.rng.Float64() < r.Probability {
+			res = append(res, r)
+		}
+	}
+
+	return res
+}
@@ -0,0 +1,142 @@
+package fault
+
+import (
+	"encoding/json"
+	"math"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	rules, err := Parse([]byte(`[{"fault":"latency","probability":0.5,"delay":"250ms"},` +
+		`{"fault":"truncate","probability":1,"methods":["torrent-get"],"bytes":10}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Rule{
+		{Fault: Latency, Probability: 0.5, Delay: Duration(250 * time.Millisecond)},
+		{Fault: Truncate, Probability: 1, Methods: []string{"torrent-get"}, Bytes: 10},
+	}
+	if len(rules) != 2 || rules[0].Delay != want[0].Delay || rules[1].Bytes != 10 || !slices.Equal(rules[1].Methods, want[1].Methods) {
+		t.Errorf("got rules %+v", rules)
+	}
+
+	// rules are written back as they are read
+	bs, _ := json.Marshal(want)
+	if want := `[{"fault":"latency","probability":0.5,"delay":"250ms"},` +
+		`{"fault":"truncate","probability":1,"methods":["torrent-get"],"bytes":10}]`; string(bs) != want {
+		t.Errorf("got %s, want %s", bs, want)
+	}
+
+	cases := []struct {
+		rules, err string
+	}{
+		{rules: `[{"fault":"explode","probability":1}]`, err: `rule #1: unknown fault "explode"`},
+		{rules: `[{"fault":"slow","probability":0},{"fault":"slow","probability":1.5}]`, err: "rule #2: probability must be between 0 and 1"},
+		{rules: `[{"fault":"slow","probability":1,"bytes":-1}]`, err: "rule #1: delay and bytes must not be negative"},
+		{rules: `[{"fault":"slow","probability":1,"delay":"soon"}]`, err: `invalid duration "soon"`},
+		{rules: `{"fault":"slow"}`, err: "cannot unmarshal object"},
+	}
+	for _, tc := range cases {
+		if _, err := Parse([]byte(tc.rules)); err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("%s: got error %v, want %q", tc.rules, err, tc.err)
+		}
+	}
+}
+
+// decisions returns the faults decided for the methods in turn.
+func decisions(i *Injector, methods ...string) []string {
+	var res []string
+	for _, method := range methods {
+		var fired []string
+		for _, r := range i.Decide(method) {
+			fired = append(fired, r.Fault)
+		}
+		res = append(res, strings.Join(fired, "+"))
+	}
+
+	return res
+}
+
+func TestDecideSeeded(t *testing.T) {
+	rules := []Rule{{Fault: BadGateway, Probability: 0.3}, {Fault: Latency, Probability: 0.5}}
+	var methods []string
+	for k := 0; k < 50; k++ {
+		methods = append(methods, "torrent-get", "session-get")
+	}
+
+	// the same seed gives the same faults, another seed other ones
+	first := decisions(New(rules, 42), methods...)
+	if second := decisions(New(rules, 42), methods...); !slices.Equal(first, second) {
+		t.Errorf("got different decisions with the same seed:\n%v\n%v", first, second)
+	}
+	if other := decisions(New(rules, 43), methods...); slices.Equal(first, other) {
+		t.Error("got the same decisions with another seed")
+	}
+}
+
+func TestDecideProbability(t *testing.T) {
+	const n = 20000
+	i := New([]Rule{
+		{Fault: BadGateway, Probability: 0.1},
+		{Fault: Latency, Probability: 0.5},
+		{Fault: Unavailable, Probability: 0},
+		{Fault: Slow, Probability: 1},
+	}, 1)
+
+	counts := map[string]int{}
+	for k := 0; k < n; k++ {
+		for _, r := range i.Decide("torrent-get") {
+			counts[r.Fault]++
+		}
+	}
+
+	for fault, p := range map[string]float64{BadGateway: 0.1, Latency: 0.5, Unavailable: 0, Slow: 1} {
+		if got := float64(counts[fault]) / n; math.Abs(got-p) > 0.02 {
+			t.Errorf("%s: fired in %.3f of requests, want %.1f", fault, got, p)
+		}
+	}
+	if counts[Unavailable] != 0 || counts[Slow] != n {
+		t.Errorf("got counts %v, want certain rules exact", counts)
+	}
+}
+
+func TestDecideMethods(t *testing.T) {
+	scoped := []Rule{{Fault: SessionChurn, Probability: 1, Methods: []string{"torrent-add"}}, {Fault: Latency, Probability: 0.5}}
+	methods := make([]string, 50)
+	for k := range methods {
+		methods[k] = "torrent-get"
+	}
+
+	got := decisions(New(scoped, 7), append(methods, "torrent-add")...)
+	if last := got[len(got)-1]; !strings.HasPrefix(last, SessionChurn) {
+		t.Errorf("torrent-add: got %q, want session churn", last)
+	}
+
+	// rules for other methods draw nothing, so they do not change the decisions of the others
+	unscoped := decisions(New(scoped[1:], 7), methods...)
+	if !slices.Equal(got[:len(methods)], unscoped) {
+		t.Errorf("got decisions\n%v\nwant\n%v", got[:len(methods)], unscoped)
+	}
+}
+
+func TestSetRules(t *testing.T) {
+	i := New(nil, 1)
+	if got := i.Decide("torrent-get"); len(got) != 0 {
+		t.Errorf("got %v without rules", got)
+	}
+
+	i.SetRules([]Rule{{Fault: Unavailable, Probability: 1}})
+	if got := i.Decide("torrent-get"); len(got) != 1 || got[0].Fault != Unavailable {
+		t.Errorf("got %v after setting rules", got)
+	}
+
+	// the returned rules are a copy
+	rules := i.Rules()
+	rules[0].Fault = Slow
+	if i.Rules()[0].Fault != Unavailable {
+		t.Error("rules changed through the returned slice")
+	}
+}
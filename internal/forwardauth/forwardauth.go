@@ -0,0 +1,200 @@
+package forwardauth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"transmission-proxy/internal/reqctx"
+)
+
+var ErrUnavailable = errors.New("authentication service unavailable")
+
+// maxCacheEntries bounds the number of cached authentication results.
+const maxCacheEntries = 1024
+
+// credentialHeaders identify the client to the authentication service; results are cached per their values.
+var credentialHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie"}
+
+// forwardedHeaders are the request headers sent to the authentication service.
+var forwardedHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "User-Agent", "Accept"}
+
+// Client delegates authentication of the requests to an external endpoint (e.g. Authelia or
+// oauth2-proxy forward-auth). Any 2xx response authenticates the request as the user named
+// in UserHeader, other responses are relayed to the client.
+type Client struct {
+	URL        string
+	Timeout    time.Duration
+	UserHeader string
+	// CacheTTL is how long successful results are reused for the same client and credentials.
+	CacheTTL time.Duration
+	// HTTP must not follow redirects, so that they can be relayed to the client.
+	HTTP *http.Client
+
+	mu    sync.Mutex
+	cache map[string]cacheEntry
+}
+
+type cacheEntry struct {
+	user    string
+	expires time.Time
+}
+
+// Result is the verdict of the authentication service.
+type Result struct {
+	// User is set if the request is authenticated.
+	User string
+	// Status and Header of the service response to relay to the client if the request is not authenticated.
+	Status int
+	Header http.Header
+}
+
+func (r *Result) Authenticated() bool {
+	return r.Status == 0
+}
+
+// Authenticate asks the service whether the request is authenticated. Errors mean the service could not
+// be consulted and the request must be rejected.
+func (c *Client) Authenticate(r *http.Request) (*Result, error) {
+	key := c.cacheKey(r)
+	if user, ok := c.cached(key); ok {
+		return &Result{User: user}, nil
+	}
+
+	res, err := c.ask(r)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrUnavailable, err)
+	}
+
+	if res.Authenticated() {
+		c.store(key, res.User)
+	}
+
+	return res, nil
+}
+
+func (c *Client) ask(r *http.Request) (*Result, error) {
+	ctx, cancel := context.WithTimeout(r.Context(), c.Timeout)
+	defer cancel()
+
+	ar, err := http.NewRequestWithContext(ctx, http.MethodGet, c.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, h := range forwardedHeaders {
+		if vs := r.Header.Values(h); len(vs) > 0 {
+			ar.Header[h] = vs
+		}
+	}
+
+	proto := "http"
+	if r.TLS != nil {
+		proto = "https"
+	}
+	if p := r.Header.Get("X-Forwarded-Proto"); p != "" {
+		proto = p
+	}
+	host := r.Host
+	if h := r.Header.Get("X-Forwarded-Host"); h != "" {
+		host = h
+	}
+
+	ar.Header.Set("X-Forwarded-Method", r.Method)
+	ar.Header.Set("X-Forwarded-Proto", proto)
+	ar.Header.Set("X-Forwarded-Host", host)
+	ar.Header.Set("X-Forwarded-Uri", r.URL.RequestURI())
+	ar.Header.Set("X-Original-URL", proto+"://"+host+r.URL.RequestURI())
+	if ip := reqctx.ClientIP(r.Context()); ip.IsValid() {
+		ar.Header.Set("X-Forwarded-For", ip.String())
+	}
+
+	resp, err := c.HTTP.Do(ar)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<20))
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		user := resp.Header.Get(c.UserHeader)
+		if user == "" {
+			return nil, fmt.Errorf("no %s header in the response", c.UserHeader)
+		}
+
+		return &Result{User: user}, nil
+	}
+
+	if resp.StatusCode >= 500 {
+		return nil, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	res := &Result{Status: resp.StatusCode, Header: http.Header{}}
+	for _, h := range []string{"Location", "WWW-Authenticate", "Set-Cookie"} {
+		for _, v := range resp.Header.Values(h) {
+			res.Header.Add(h, v)
+		}
+	}
+
+	return res, nil
+}
+
+// cacheKey identifies the client and the credentials it presented.
+func (c *Client) cacheKey(r *http.Request) string {
+	h := sha256.New()
+	_, _ = io.WriteString(h, reqctx.ClientIP(r.Context()).String())
+	for _, name := range credentialHeaders {
+		for _, v := range r.Header.Values(name) {
+			_, _ = io.WriteString(h, "\x00"+name+":"+v)
+		}
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (c *Client) cached(key string) (string, bool) {
+	if c.CacheTTL <= 0 {
+		return "", false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.cache[key]
+	if !ok || time.Now().After(e.expires) {
+		return "", false
+	}
+
+	return e.user, true
+}
+
+func (c *Client) store(key, user string) {
+	if c.CacheTTL <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if c.cache == nil || len(c.cache) >= maxCacheEntries {
+		fresh := make(map[string]cacheEntry, len(c.cache))
+		for k, e := range c.cache {
+			if now.Before(e.expires) {
+				fresh[k] = e
+			}
+		}
+		if len(fresh) >= maxCacheEntries {
+			fresh = map[string]cacheEntry{}
+		}
+		c.cache = fresh
+	}
+
+	c.cache[key] = cacheEntry{user: user, expires: now.Add(c.CacheTTL)}
+}
@@ -0,0 +1,223 @@
+package forwardauth
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"transmission-proxy/internal/reqctx"
+)
+
+// fakeAuth is a scripted authentication service: it authenticates requests carrying the valid session cookie
+// as alice, redirects the rest to the login page and records the request it got last.
+type fakeAuth struct {
+	*httptest.Server
+	calls atomic.Int32
+	last  atomic.Pointer[http.Request]
+}
+
+func newFakeAuth(t *testing.T, handle func(w http.ResponseWriter, r *http.Request)) *fakeAuth {
+	fa := &fakeAuth{}
+	fa.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fa.calls.Add(1)
+		fa.last.Store(r)
+		handle(w, r)
+	}))
+	t.Cleanup(fa.Close)
+
+	return fa
+}
+
+func loginFlow(w http.ResponseWriter, r *http.Request) {
+	if c, err := r.Cookie("session"); err == nil && c.Value == "valid" {
+		w.Header().Set("Remote-User", "alice")
+		return
+	}
+	w.Header().Set("Location", "https://auth.example.com/login?rd="+r.Header.Get("X-Original-URL"))
+	w.Header().Set("Set-Cookie", "auth_state=1")
+	w.Header().Set("X-Internal", "secret")
+	w.WriteHeader(http.StatusFound)
+}
+
+func testClient(url string, ttl time.Duration) *Client {
+	return &Client{
+		URL:        url,
+		Timeout:    time.Second,
+		UserHeader: "Remote-User",
+		CacheTTL:   ttl,
+		HTTP: &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		}},
+	}
+}
+
+func clientRequest(ip, cookie string) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, "http://torrents.example.com/transmission/rpc?x=1", nil)
+	if cookie != "" {
+		r.Header.Set("Cookie", "session="+cookie)
+	}
+	r.Header.Set("X-Transmission-Session-Id", "not forwarded")
+
+	return r.WithContext(reqctx.WithClientIP(r.Context(), netip.MustParseAddr(ip)))
+}
+
+func TestAuthenticate(t *testing.T) {
+	fa := newFakeAuth(t, loginFlow)
+	c := testClient(fa.URL, 0)
+
+	res, err := c.Authenticate(clientRequest("192.0.2.1", "valid"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Authenticated() || res.User != "alice" {
+		t.Errorf("got %+v, want alice authenticated", res)
+	}
+
+	ar := fa.last.Load()
+	for name, want := range map[string]string{
+		"Cookie":                    "session=valid",
+		"X-Forwarded-Method":        http.MethodPost,
+		"X-Forwarded-Proto":         "http",
+		"X-Forwarded-Host":          "torrents.example.com",
+		"X-Forwarded-Uri":           "/transmission/rpc?x=1",
+		"X-Original-URL":            "http://torrents.example.com/transmission/rpc?x=1",
+		"X-Forwarded-For":           "192.0.2.1",
+		"X-Transmission-Session-Id": "",
+	} {
+		if got := ar.Header.Get(name); got != want {
+			t.Errorf("service got %s %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestAuthenticateDenied(t *testing.T) {
+	fa := newFakeAuth(t, loginFlow)
+	c := testClient(fa.URL, time.Minute)
+
+	res, err := c.Authenticate(clientRequest("192.0.2.1", "expired"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Authenticated() || res.Status != http.StatusFound {
+		t.Fatalf("got %+v, want status 302", res)
+	}
+	if got := res.Header.Get("Location"); got != "https://auth.example.com/login?rd=http://torrents.example.com/transmission/rpc?x=1" {
+		t.Errorf("got Location %q", got)
+	}
+	if got := res.Header.Get("Set-Cookie"); got != "auth_state=1" {
+		t.Errorf("got Set-Cookie %q", got)
+	}
+	if _, ok := res.Header["X-Internal"]; ok {
+		t.Error("other headers of the service are relayed")
+	}
+
+	// denials are not cached
+	if _, err := c.Authenticate(clientRequest("192.0.2.1", "expired")); err != nil {
+		t.Fatal(err)
+	}
+	if n := fa.calls.Load(); n != 2 {
+		t.Errorf("service consulted %d times, want 2", n)
+	}
+}
+
+func TestAuthenticateUnauthorized(t *testing.T) {
+	fa := newFakeAuth(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="auth"`)
+		w.WriteHeader(http.StatusUnauthorized)
+	})
+	c := testClient(fa.URL, 0)
+
+	res, err := c.Authenticate(clientRequest("192.0.2.1", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Status != http.StatusUnauthorized || res.Header.Get("WWW-Authenticate") != `Basic realm="auth"` {
+		t.Errorf("got %+v", res)
+	}
+}
+
+func TestAuthenticateCache(t *testing.T) {
+	fa := newFakeAuth(t, loginFlow)
+	c := testClient(fa.URL, time.Minute)
+
+	for i := 0; i < 3; i++ {
+		if res, err := c.Authenticate(clientRequest("192.0.2.1", "valid")); err != nil || res.User != "alice" {
+			t.Fatalf("got %+v, %v", res, err)
+		}
+	}
+	if n := fa.calls.Load(); n != 1 {
+		t.Errorf("service consulted %d times, want 1", n)
+	}
+
+	// another client or other credentials are not served from the cache
+	if _, err := c.Authenticate(clientRequest("192.0.2.2", "valid")); err != nil {
+		t.Fatal(err)
+	}
+	if res, err := c.Authenticate(clientRequest("192.0.2.1", "expired")); err != nil || res.Authenticated() {
+		t.Fatalf("got %+v, %v, want denial", res, err)
+	}
+	if n := fa.calls.Load(); n != 3 {
+		t.Errorf("service consulted %d times, want 3", n)
+	}
+}
+
+func TestAuthenticateCacheExpiry(t *testing.T) {
+	fa := newFakeAuth(t, loginFlow)
+	c := testClient(fa.URL, 20*time.Millisecond)
+
+	for i := 0; i < 2; i++ {
+		if _, err := c.Authenticate(clientRequest("192.0.2.1", "valid")); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(40 * time.Millisecond)
+	}
+	if n := fa.calls.Load(); n != 2 {
+		t.Errorf("service consulted %d times, want 2 after the result expired", n)
+	}
+}
+
+func TestAuthenticateFailsClosed(t *testing.T) {
+	cases := []struct {
+		name   string
+		handle func(w http.ResponseWriter, r *http.Request)
+	}{
+		{name: "server error", handle: func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusBadGateway)
+		}},
+		{name: "no user header", handle: func(w http.ResponseWriter, r *http.Request) {}},
+		{name: "timeout", handle: func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case <-r.Context().Done():
+			case <-time.After(5 * time.Second):
+			}
+			w.Header().Set("Remote-User", "alice")
+		}},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			fa := newFakeAuth(t, tc.handle)
+			c := testClient(fa.URL, time.Minute)
+			c.Timeout = 50 * time.Millisecond
+
+			res, err := c.Authenticate(clientRequest("192.0.2.1", "valid"))
+			if !errors.Is(err, ErrUnavailable) || res != nil {
+				t.Errorf("got %+v, %v, want ErrUnavailable", res, err)
+			}
+		})
+	}
+}
+
+func TestAuthenticateUnreachable(t *testing.T) {
+	fa := newFakeAuth(t, loginFlow)
+	fa.Close()
+	c := testClient(fa.URL, time.Minute)
+
+	if _, err := c.Authenticate(clientRequest("192.0.2.1", "valid")); !errors.Is(err, ErrUnavailable) {
+		t.Errorf("got %v, want ErrUnavailable", err)
+	}
+}
@@ -0,0 +1,110 @@
+// Command gen generates validator tables of the transmission package from the RPC spec description.
+//
+// Usage: go run transmission-proxy/internal/gen -spec spec/rpc-17.json -out methods_gen.go
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"go/format"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+type spec struct {
+	RPCVersion   int                          `json:"rpc-version"`
+	Transmission string                       `json:"transmission"`
+	Methods      map[string]map[string]string `json:"methods"`
+}
+
+var knownTypes = map[string]string{
+	"any":     "ArgAny",
+	"array":   "ArgArray",
+	"boolean": "ArgBoolean",
+	"ids":     "ArgIds",
+	"integer": "ArgInteger",
+	"number":  "ArgNumber",
+	"object":  "ArgObject",
+	"string":  "ArgString",
+}
+
+func main() {
+	specPath := flag.String("spec", "", "path to RPC spec description")
+	out := flag.String("out", "", "path to generated file")
+	pkg := flag.String("package", "transmission", "package of generated file")
+	flag.Parse()
+
+	if err := run(*specPath, *out, *pkg); err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, "gen: "+err.Error())
+		os.Exit(1)
+	}
+}
+
+func run(specPath, out, pkg string) error {
+	if specPath == "" || out == "" {
+		return fmt.Errorf("-spec and -out are required")
+	}
+
+	bs, err := os.ReadFile(specPath)
+	if err != nil {
+		return err
+	}
+
+	var s spec
+	if err = json.Unmarshal(bs, &s); err != nil {
+		return fmt.Errorf("parse %s: %w", specPath, err)
+	}
+
+	src, err := generate(&s, filepath.ToSlash(specPath), pkg)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(out, src, 0o644)
+}
+
+func generate(s *spec, specPath, pkg string) ([]byte, error) {
+	var b bytes.Buffer
+
+	fmt.Fprintf(&b, "// Code generated by transmission-proxy/internal/gen from %s. DO NOT EDIT.\n\n", specPath)
+	fmt.Fprintf(&b, "package %s\n\n", pkg)
+	fmt.Fprintf(&b, "// SpecRPCVersion is the Transmission RPC version (Transmission %s) the method tables are generated for.\n", s.Transmission)
+	fmt.Fprintf(&b, "const SpecRPCVersion = %d\n\n", s.RPCVersion)
+	fmt.Fprintf(&b, "// specMethods lists arguments of every RPC method with their types according to the spec.\n")
+	fmt.Fprintf(&b, "var specMethods = map[string]map[string]ArgType{\n")
+
+	for _, method := range sortedKeys(s.Methods) {
+		args := s.Methods[method]
+		if len(args) == 0 {
+			fmt.Fprintf(&b, "\t%q: {},\n", method)
+			continue
+		}
+
+		fmt.Fprintf(&b, "\t%q: {\n", method)
+		for _, arg := range sortedKeys(args) {
+			typ, ok := knownTypes[args[arg]]
+			if !ok {
+				return nil, fmt.Errorf("method %s argument %s: unknown type %q", method, arg, args[arg])
+			}
+			fmt.Fprintf(&b, "\t\t%q: %s,\n", arg, typ)
+		}
+		fmt.Fprintf(&b, "\t},\n")
+	}
+
+	fmt.Fprintf(&b, "}\n")
+
+	return format.Source(b.Bytes())
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
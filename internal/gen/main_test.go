@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+)
+
+func loadSpec(t *testing.T, path string) *spec {
+	bs, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var s spec
+	if err := json.Unmarshal(bs, &s); err != nil {
+		t.Fatal(err)
+	}
+
+	return &s
+}
+
+// TestGenerateUpToDate fails when methods_gen.go was edited by hand or not regenerated after the spec changed.
+func TestGenerateUpToDate(t *testing.T) {
+	s := loadSpec(t, "../transmission/spec/rpc-17.json")
+
+	got, err := generate(s, "spec/rpc-17.json", "transmission")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := os.ReadFile("../transmission/methods_gen.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != string(want) {
+		t.Errorf("methods_gen.go is out of date, run go generate ./internal/transmission")
+	}
+
+	// map iteration order must not leak into the output
+	for i := 0; i < 5; i++ {
+		again, err := generate(s, "spec/rpc-17.json", "transmission")
+		if err != nil || string(again) != string(got) {
+			t.Fatalf("output differs between runs: %v", err)
+		}
+	}
+}
+
+func TestGenerateUnknownType(t *testing.T) {
+	s := &spec{RPCVersion: 18, Methods: map[string]map[string]string{"torrent-get": {"fields": "list"}}}
+
+	if _, err := generate(s, "spec.json", "transmission"); err == nil || !strings.Contains(err.Error(), `argument fields: unknown type "list"`) {
+		t.Errorf("got error %v", err)
+	}
+}
@@ -0,0 +1,191 @@
+package htpasswd
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// dummyHash is checked for unknown users so that response time does not reveal whether the user exists.
+var dummyHash = []byte("$2a$10$VMslnZ.8D6YxM4Axy/Or4u3ncf.5vt2oBP.lKYH6zItcIxez9OVhi")
+
+// File is a set of users loaded from htpasswd file. Only bcrypt ($2a$, $2b$, $2y$) and SHA-512-crypt ($6$)
+// hashes are accepted, files with weaker formats fail to load.
+type File struct {
+	path string
+
+	mu    sync.RWMutex
+	users map[string]string
+	mtime time.Time
+}
+
+// Load reads the htpasswd file at path.
+func Load(path string) (*File, error) {
+	f := &File{path: path}
+	if err := f.Reload(); err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// Reload re-reads the file. On failure the previously loaded users stay in effect.
+func (f *File) Reload() error {
+	if f.path == "" {
+		return nil
+	}
+
+	fh, err := os.Open(f.path)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = fh.Close() }()
+
+	fi, err := fh.Stat()
+	if err != nil {
+		return err
+	}
+
+	users, err := parse(fh)
+	if err != nil {
+		return fmt.Errorf("%s: %w", f.path, err)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.users = users
+	f.mtime = fi.ModTime()
+	return nil
+}
+
+// ReloadIfChanged re-reads the file if its modification time differs from the loaded one.
+func (f *File) ReloadIfChanged() (reloaded bool, err error) {
+	if f.path == "" {
+		return false, nil
+	}
+
+	fi, err := os.Stat(f.path)
+	if err != nil {
+		return false, err
+	}
+
+	f.mu.RLock()
+	changed := !fi.ModTime().Equal(f.mtime)
+	f.mu.RUnlock()
+
+	if !changed {
+		return false, nil
+	}
+
+	return true, f.Reload()
+}
+
+// FromEntries makes the set of users from user:hash entries, e.g. given in configuration rather than file.
+// Reloading such a set does nothing.
+func FromEntries(entries []string) (*File, error) {
+	users, err := parse(strings.NewReader(strings.Join(entries, "\n")))
+	if err != nil {
+		return nil, err
+	}
+
+	return &File{users: users}, nil
+}
+
+// Verify checks the password of the user.
+func (f *File) Verify(user, password string) bool {
+	f.mu.RLock()
+	hash, ok := f.users[user]
+	f.mu.RUnlock()
+
+	if !ok {
+		_ = bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return false
+	}
+
+	if strings.HasPrefix(hash, sha512CryptPrefix) {
+		computed, err := sha512Crypt(password, hash)
+		return err == nil && subtle.ConstantTimeCompare([]byte(computed), []byte(hash)) == 1
+	}
+
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
+
+// Has reports whether the user is in the file.
+func (f *File) Has(user string) bool {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	_, ok := f.users[user]
+	return ok
+}
+
+// Len returns number of the loaded users.
+func (f *File) Len() int {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	return len(f.users)
+}
+
+func parse(r io.Reader) (map[string]string, error) {
+	users := map[string]string{}
+
+	sc := bufio.NewScanner(r)
+	for line := 1; sc.Scan(); line++ {
+		text := strings.TrimSpace(sc.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		user, hash, ok := strings.Cut(text, ":")
+		if !ok || user == "" || hash == "" {
+			return nil, fmt.Errorf("line %d: expected user:hash", line)
+		}
+
+		if err := checkFormat(hash); err != nil {
+			return nil, fmt.Errorf("line %d: user %q: %w", line, user, err)
+		}
+
+		users[user] = hash
+	}
+
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+
+	return users, nil
+}
+
+func checkFormat(hash string) error {
+	switch {
+	case strings.HasPrefix(hash, "$2a$"), strings.HasPrefix(hash, "$2b$"), strings.HasPrefix(hash, "$2y$"):
+		if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+			return fmt.Errorf("malformed bcrypt hash: %w", err)
+		}
+		return nil
+	case strings.HasPrefix(hash, sha512CryptPrefix):
+		computed, err := sha512Crypt("", hash)
+		if err != nil {
+			return err
+		}
+		// hashes missing the digest or with salt cut by sha512Crypt could never be matched
+		if len(computed) != len(hash) || computed[:strings.LastIndex(computed, "$")] != hash[:strings.LastIndex(hash, "$")] {
+			return errMalformedSHA512Crypt
+		}
+		return nil
+	case strings.HasPrefix(hash, "$apr1$"), strings.HasPrefix(hash, "$1$"):
+		return fmt.Errorf("MD5 hashes are too weak, use bcrypt (htpasswd -B) or SHA-512-crypt")
+	case strings.HasPrefix(hash, "{SHA}"):
+		return fmt.Errorf("SHA-1 hashes are too weak, use bcrypt (htpasswd -B) or SHA-512-crypt")
+	default:
+		return fmt.Errorf("unsupported hash format (crypt or plain text?), use bcrypt (htpasswd -B) or SHA-512-crypt")
+	}
+}
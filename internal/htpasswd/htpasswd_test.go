@@ -0,0 +1,147 @@
+package htpasswd
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVerify(t *testing.T) {
+	f, err := Load("testdata/users.htpasswd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Len() != 5 {
+		t.Errorf("got %d users", f.Len())
+	}
+
+	cases := []struct {
+		user, password string
+		ok             bool
+	}{
+		{user: "alice", password: "alice-secret", ok: true},
+		{user: "alice", password: "bob-secret"},
+		{user: "alice", password: ""},
+		{user: "bob", password: "bob-secret", ok: true},
+		{user: "carol", password: "carol-secret", ok: true},
+		{user: "carol", password: "Carol-secret"},
+		{user: "dave", password: "dave-secret", ok: true},
+		{user: "dave", password: "dave-secret "},
+		{user: "erin", password: "Hello world!", ok: true},
+		{user: "erin", password: "Hello world"},
+		{user: "mallory", password: "alice-secret"},
+		{user: "", password: ""},
+	}
+
+	for _, tc := range cases {
+		if got := f.Verify(tc.user, tc.password); got != tc.ok {
+			t.Errorf("Verify(%q, %q) = %v, want %v", tc.user, tc.password, got, tc.ok)
+		}
+	}
+}
+
+func TestWeakFormats(t *testing.T) {
+	cases := []struct {
+		entry, err string
+	}{
+		{entry: "u:$apr1$salt$hash", err: "MD5 hashes are too weak"},
+		{entry: "u:$1$salt$hash", err: "MD5 hashes are too weak"},
+		{entry: "u:{SHA}W6ph5Mm5Pz8GgiULbPgzG37mj9g=", err: "SHA-1 hashes are too weak"},
+		{entry: "u:rl4GaBvpYyoxw", err: "unsupported hash format"},
+		{entry: "u:password", err: "unsupported hash format"},
+		{entry: "u:$2a$04$short", err: "malformed bcrypt hash"},
+		{entry: "u:$6$", err: "malformed SHA-512-crypt hash"},
+		{entry: "u:$6$saltstring$tooshort", err: "malformed SHA-512-crypt hash"},
+		{entry: "u:$6$rounds=x$salt$hash", err: "malformed SHA-512-crypt hash"},
+		{entry: "no hash", err: "expected user:hash"},
+		{entry: ":$2a$04$ep6koQz20G/6ptxBdOvXBO2DZp9cfH/3EWwAMwEvlu1UZNK8Ob6SG", err: "expected user:hash"},
+	}
+
+	for _, tc := range cases {
+		_, err := FromEntries([]string{"ok:$6$saltstring$svn8UoSVapNtMuq1ukKS4tPQd8iKwSMHWjl/O817G3uBnIFNjnQJuesI68u4OTLiBFdcbYEdFCoEOfaS35inz1", tc.entry})
+		if err == nil || !strings.Contains(err.Error(), "line 2") || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("%s: got error %v, want %q", tc.entry, err, tc.err)
+		}
+	}
+}
+
+func TestReload(t *testing.T) {
+	orig, err := os.ReadFile("testdata/users.htpasswd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "users.htpasswd")
+	if err := os.WriteFile(path, orig, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reloaded, err := f.ReloadIfChanged(); reloaded || err != nil {
+		t.Errorf("unchanged file reloaded: %v, %v", reloaded, err)
+	}
+
+	// bob is removed and frank, who reuses alice's password, added
+	var lines []string
+	for _, line := range strings.Split(string(orig), "\n") {
+		if !strings.HasPrefix(line, "bob:") {
+			lines = append(lines, line)
+		}
+	}
+	lines = append(lines, "frank:$2a$04$ep6koQz20G/6ptxBdOvXBO2DZp9cfH/3EWwAMwEvlu1UZNK8Ob6SG")
+	update := func(content string) {
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		// the file may be rewritten within the resolution of modification time
+		future := time.Now().Add(time.Duration(len(content)) * time.Second)
+		if err := os.Chtimes(path, future, future); err != nil {
+			t.Fatal(err)
+		}
+	}
+	update(strings.Join(lines, "\n"))
+
+	if reloaded, err := f.ReloadIfChanged(); !reloaded || err != nil {
+		t.Fatalf("changed file not reloaded: %v, %v", reloaded, err)
+	}
+	if f.Has("bob") || f.Verify("bob", "bob-secret") {
+		t.Error("removed user still accepted")
+	}
+	if !f.Verify("frank", "alice-secret") || !f.Verify("alice", "alice-secret") {
+		t.Error("users of the new file not accepted")
+	}
+
+	// broken file keeps the users loaded before
+	update("frank:plaintext\n")
+	if reloaded, err := f.ReloadIfChanged(); !reloaded || err == nil {
+		t.Fatalf("got reloaded %v, error %v", reloaded, err)
+	}
+	if !f.Verify("frank", "alice-secret") {
+		t.Error("users lost after failed reload")
+	}
+}
+
+func TestFromEntries(t *testing.T) {
+	f, err := FromEntries([]string{
+		"alice:$2a$04$ep6koQz20G/6ptxBdOvXBO2DZp9cfH/3EWwAMwEvlu1UZNK8Ob6SG",
+		"erin:$6$saltstring$svn8UoSVapNtMuq1ukKS4tPQd8iKwSMHWjl/O817G3uBnIFNjnQJuesI68u4OTLiBFdcbYEdFCoEOfaS35inz1",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if f.Len() != 2 || !f.Verify("alice", "alice-secret") || !f.Verify("erin", "Hello world!") || f.Verify("alice", "Hello world!") {
+		t.Errorf("got users %d", f.Len())
+	}
+	// the set is not backed by a file, reloading keeps it
+	if reloaded, err := f.ReloadIfChanged(); reloaded || err != nil {
+		t.Errorf("reload if changed: got %v, %v", reloaded, err)
+	}
+	if err := f.Reload(); err != nil || !f.Verify("alice", "alice-secret") {
+		t.Errorf("reload: got %v", err)
+	}
+}
@@ -0,0 +1,143 @@
+package htpasswd
+
+import (
+	"crypto/sha512"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+const (
+	sha512CryptPrefix        = "$6$"
+	sha512CryptRoundsPrefix  = "rounds="
+	sha512CryptDefaultRounds = 5000
+	sha512CryptMinRounds     = 1000
+	sha512CryptMaxRounds     = 999999999
+	sha512CryptMaxSalt       = 16
+)
+
+var errMalformedSHA512Crypt = errors.New("malformed SHA-512-crypt hash")
+
+const cryptAlphabet = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+// sha512CryptOrder is the order in which digest bytes are encoded, three at a time.
+var sha512CryptOrder = [...][3]int{
+	{0, 21, 42}, {22, 43, 1}, {44, 2, 23}, {3, 24, 45}, {25, 46, 4}, {47, 5, 26}, {6, 27, 48},
+	{28, 49, 7}, {50, 8, 29}, {9, 30, 51}, {31, 52, 10}, {53, 11, 32}, {12, 33, 54}, {34, 55, 13},
+	{56, 14, 35}, {15, 36, 57}, {37, 58, 16}, {59, 17, 38}, {18, 39, 60}, {40, 61, 19}, {62, 20, 41},
+}
+
+// sha512Crypt computes SHA-512-crypt hash of the password using salt and rounds of the given hash,
+// returning the full hash string in the same format.
+func sha512Crypt(password, hash string) (string, error) {
+	rest, ok := strings.CutPrefix(hash, sha512CryptPrefix)
+	if !ok {
+		return "", errMalformedSHA512Crypt
+	}
+
+	rounds, customRounds := sha512CryptDefaultRounds, false
+	if r, ok := strings.CutPrefix(rest, sha512CryptRoundsPrefix); ok {
+		n, after, ok := strings.Cut(r, "$")
+		if !ok {
+			return "", errMalformedSHA512Crypt
+		}
+
+		v, err := strconv.ParseUint(n, 10, 32)
+		if err != nil {
+			return "", errMalformedSHA512Crypt
+		}
+
+		rounds = min(max(int(v), sha512CryptMinRounds), sha512CryptMaxRounds)
+		customRounds, rest = true, after
+	}
+
+	salt, _, _ := strings.Cut(rest, "$")
+	if len(salt) > sha512CryptMaxSalt {
+		salt = salt[:sha512CryptMaxSalt]
+	}
+
+	p, s := []byte(password), []byte(salt)
+
+	b := sha512.New()
+	b.Write(p)
+	b.Write(s)
+	b.Write(p)
+	bSum := b.Sum(nil)
+
+	a := sha512.New()
+	a.Write(p)
+	a.Write(s)
+	a.Write(repeatTo(bSum, len(p)))
+	for n := len(p); n > 0; n >>= 1 {
+		if n&1 != 0 {
+			a.Write(bSum)
+		} else {
+			a.Write(p)
+		}
+	}
+	aSum := a.Sum(nil)
+
+	dp := sha512.New()
+	for range p {
+		dp.Write(p)
+	}
+	pSeq := repeatTo(dp.Sum(nil), len(p))
+
+	ds := sha512.New()
+	for i := 0; i < 16+int(aSum[0]); i++ {
+		ds.Write(s)
+	}
+	sSeq := repeatTo(ds.Sum(nil), len(s))
+
+	for i := 0; i < rounds; i++ {
+		c := sha512.New()
+		if i&1 != 0 {
+			c.Write(pSeq)
+		} else {
+			c.Write(aSum)
+		}
+		if i%3 != 0 {
+			c.Write(sSeq)
+		}
+		if i%7 != 0 {
+			c.Write(pSeq)
+		}
+		if i&1 != 0 {
+			c.Write(aSum)
+		} else {
+			c.Write(pSeq)
+		}
+		aSum = c.Sum(aSum[:0])
+	}
+
+	var out strings.Builder
+	out.WriteString(sha512CryptPrefix)
+	if customRounds {
+		out.WriteString(sha512CryptRoundsPrefix + strconv.Itoa(rounds) + "$")
+	}
+	out.WriteString(salt)
+	out.WriteByte('$')
+	for _, o := range sha512CryptOrder {
+		encode24(&out, aSum[o[0]], aSum[o[1]], aSum[o[2]], 4)
+	}
+	encode24(&out, 0, 0, aSum[63], 2)
+
+	return out.String(), nil
+}
+
+func repeatTo(src []byte, n int) []byte {
+	res := make([]byte, 0, n)
+	for len(res) < n {
+		res = append(res, src[:min(len(src), n-len(res))]...)
+	}
+
+	return res
+}
+
+func encode24(out *strings.Builder, b2, b1, b0 byte, n int) {
+	w := uint(b2)<<16 | uint(b1)<<8 | uint(b0)
+	for ; n > 0; n-- {
+		out.WriteByte(cryptAlphabet[w&0x3f])
+		w >>= 6
+	}
+}
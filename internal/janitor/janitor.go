@@ -0,0 +1,264 @@
+package janitor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"transmission-proxy/internal/audit"
+	"transmission-proxy/internal/jrpc"
+	"transmission-proxy/internal/logger"
+	"transmission-proxy/internal/transmission"
+	"transmission-proxy/internal/upstream"
+	"transmission-proxy/internal/webhook"
+)
+
+// User is the name janitor actions are audited as.
+const User = "janitor"
+
+// Actions taken on torrents matching the rules.
+const (
+	ActionStop   = "stop"
+	ActionRemove = "remove"
+)
+
+const (
+	defaultInterval   = time.Hour
+	defaultMaxActions = 10
+	minInterval       = time.Minute
+)
+
+// Transmission values of uploadRatio meaning the ratio is not known and infinite.
+const (
+	ratioNone     = -1
+	ratioInfinite = -2
+)
+
+const statusStopped = 0
+
+var fields = []string{"id", "hashString", "name", "status", "uploadRatio", "doneDate", "labels", "downloadDir"}
+
+// Rule selects finished torrents which have reached the seeding goals.
+type Rule struct {
+	Name string `yaml:"name"`
+	// MinRatio and MinSeedDays (since the download finished) must both be reached.
+	MinRatio    float64 `yaml:"min_ratio"`
+	MinSeedDays float64 `yaml:"min_seed_days"`
+	// Label and DownloadPrefix, if set, restrict the rule to torrents with the label or under the directory.
+	Label          string `yaml:"label"`
+	DownloadPrefix string `yaml:"download_prefix"`
+	Action         string `yaml:"action"`
+	// DeleteLocalData removes the downloaded files along with the torrent.
+	DeleteLocalData bool `yaml:"delete_local_data"`
+}
+
+// Config is the janitor configuration file, e.g.
+//
+//	interval: 1h
+//	max_actions: 10
+//	rules:
+//	  - name: old-seeds
+//	    min_ratio: 2
+//	    min_seed_days: 14
+//	    label: movies
+//	    action: remove
+type Config struct {
+	Interval time.Duration `yaml:"interval"`
+	// MaxActions limits the number of torrents acted on in a single run.
+	MaxActions int     `yaml:"max_actions"`
+	DryRun     bool    `yaml:"dry_run"`
+	Rules      []*Rule `yaml:"rules"`
+}
+
+func Load(path string) (*Config, error) {
+	bs, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := Config{Interval: defaultInterval, MaxActions: defaultMaxActions}
+	if err = yaml.Unmarshal(bs, &cfg); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+
+	if cfg.Interval < minInterval {
+		return nil, fmt.Errorf("interval must be at least %s", minInterval)
+	}
+	if cfg.MaxActions <= 0 {
+		return nil, errors.New("max_actions must be positive")
+	}
+
+	for i, r := range cfg.Rules {
+		if r.Name == "" {
+			r.Name = fmt.Sprintf("rule %d", i+1)
+		}
+		if r.Action != ActionStop && r.Action != ActionRemove {
+			return nil, fmt.Errorf("%s: action must be either %s or %s", r.Name, ActionStop, ActionRemove)
+		}
+		if r.MinRatio <= 0 && r.MinSeedDays <= 0 {
+			return nil, fmt.Errorf("%s: min_ratio or min_seed_days must be set", r.Name)
+		}
+		if r.DeleteLocalData && r.Action != ActionRemove {
+			return nil, fmt.Errorf("%s: delete_local_data is only allowed with action %s", r.Name, ActionRemove)
+		}
+	}
+
+	return &cfg, nil
+}
+
+// Match reports whether the i-th torrent reached the goals of the rule at the time now.
+func (r *Rule) Match(t *transmission.Torrents, i int, now time.Time) bool {
+	done := t.Int64(i, "doneDate")
+	if done <= 0 {
+		return false
+	}
+
+	if r.MinSeedDays > 0 && now.Sub(time.Unix(done, 0)) < time.Duration(r.MinSeedDays*float64(24*time.Hour)) {
+		return false
+	}
+
+	if r.MinRatio > 0 {
+		ratio := t.Float64(i, "uploadRatio")
+		if ratio == ratioNone || (ratio != ratioInfinite && ratio < r.MinRatio) {
+			return false
+		}
+	}
+
+	if r.Label != "" {
+		if labels, _ := t.Get(i, "labels"); !transmission.HasLabel(labels, r.Label) {
+			return false
+		}
+	}
+
+	if r.DownloadPrefix != "" {
+		dir := t.String(i, "downloadDir")
+		if !strings.HasPrefix(dir+"/", strings.TrimSuffix(r.DownloadPrefix, "/")+"/") {
+			return false
+		}
+	}
+
+	if r.Action == ActionStop && t.Int64(i, "status") == statusStopped {
+		return false
+	}
+
+	return true
+}
+
+// Action is taken on a torrent by a rule.
+type Action struct {
+	Rule   *Rule
+	ID     int64
+	Hash   string
+	Name   string
+	DryRun bool
+}
+
+// Plan returns the actions to take on the torrents: for each torrent the first matching rule, at most
+// maxActions in total.
+func Plan(rules []*Rule, t *transmission.Torrents, now time.Time, maxActions int) []*Action {
+	var res []*Action
+	for i := 0; i < t.Len() && len(res) < maxActions; i++ {
+		for _, r := range rules {
+			if r.Match(t, i, now) {
+				res = append(res, &Action{Rule: r, ID: t.Int64(i, "id"), Hash: t.String(i, "hashString"), Name: t.String(i, "name")})
+				break
+			}
+		}
+	}
+
+	return res
+}
+
+// Janitor periodically stops or removes torrents according to the rules.
+type Janitor struct {
+	Config   *Config
+	Upstream *upstream.Client
+	// Audit and Notifier, if not nil, record actions and notify about them.
+	Audit    *audit.Log
+	Notifier *webhook.Notifier
+}
+
+// Run acts every interval until the context is done.
+func (j *Janitor) Run(ctx context.Context) {
+	for {
+		if err := j.run(ctx); err != nil {
+			slog.WarnContext(ctx, "janitor run failed: "+err.Error(), logger.IgnoredAttr(err))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(j.Config.Interval):
+		}
+	}
+}
+
+func (j *Janitor) run(ctx context.Context) error {
+	resp, err := j.Upstream.Call(ctx, nil, &jrpc.Request{Method: "torrent-get", Arguments: map[string]any{"fields": fields}})
+	if err != nil {
+		return fmt.Errorf("list torrents: %w", err)
+	}
+
+	torrents, err := transmission.ParseTorrents(resp.Arguments["torrents"])
+	if err != nil {
+		return fmt.Errorf("list torrents: %w", err)
+	}
+
+	actions := Plan(j.Config.Rules, torrents, time.Now(), j.Config.MaxActions)
+	for _, a := range actions {
+		a.DryRun = j.Config.DryRun
+		j.act(ctx, a)
+	}
+
+	return nil
+}
+
+func (j *Janitor) act(ctx context.Context, a *Action) {
+	attrs := []slog.Attr{
+		slog.String("rule", a.Rule.Name), slog.String("action", a.Rule.Action),
+		slog.String("torrent", a.Name), slog.String("hash", a.Hash),
+	}
+	if a.DryRun {
+		slog.LogAttrs(ctx, slog.LevelInfo, "dry run: janitor action skipped", attrs...)
+		return
+	}
+
+	req := &jrpc.Request{Method: "torrent-stop", Arguments: map[string]any{"ids": []any{a.Hash}}}
+	if a.Rule.Action == ActionRemove {
+		req.Method = "torrent-remove"
+		req.Arguments["delete-local-data"] = a.Rule.DeleteLocalData
+	}
+
+	rec := &audit.Record{Time: time.Now(), User: User, Method: req.Method, Ids: req.Arguments["ids"], Result: jrpc.ResultSuccess}
+	if _, err := j.Upstream.Call(ctx, nil, req); err != nil {
+		rec.Result = err.Error()
+		slog.LogAttrs(ctx, slog.LevelError, "janitor action failed: "+err.Error(), append(attrs, logger.IgnoredAttr(err))...)
+	} else {
+		slog.LogAttrs(ctx, slog.LevelInfo, "janitor action taken", attrs...)
+	}
+
+	if j.Audit != nil {
+		if err := j.Audit.Write(rec); err != nil {
+			slog.ErrorContext(ctx, "failed to write audit log: "+err.Error(), logger.IgnoredAttr(err))
+		}
+	}
+
+	if j.Notifier != nil && rec.Result == jrpc.ResultSuccess {
+		err := j.Notifier.Send(ctx, map[string]any{
+			"event":             "janitor",
+			"rule":              a.Rule.Name,
+			"action":            a.Rule.Action,
+			"delete_local_data": a.Rule.DeleteLocalData,
+			"torrent":           map[string]any{"id": a.ID, "hash": a.Hash, "name": a.Name},
+		})
+		if err != nil {
+			slog.ErrorContext(ctx, "failed to notify janitor webhook: "+err.Error(), logger.IgnoredAttr(err))
+		}
+	}
+}
@@ -0,0 +1,392 @@
+package janitor
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"transmission-proxy/internal/audit"
+	"transmission-proxy/internal/jrpc"
+	"transmission-proxy/internal/transmission"
+	"transmission-proxy/internal/upstream"
+	"transmission-proxy/internal/webhook"
+)
+
+var now = time.Date(2024, 5, 15, 12, 0, 0, 0, time.UTC)
+
+// daysAgo returns doneDate of a torrent finished the number of days before now.
+func daysAgo(days float64) int64 {
+	return now.Add(-time.Duration(days * float64(24*time.Hour))).Unix()
+}
+
+// parseTorrents parses the synthetic torrent list as upstream responses are parsed, with numbers kept as json.Number.
+func parseTorrents(t *testing.T, list string) *transmission.Torrents {
+	t.Helper()
+
+	dec := json.NewDecoder(strings.NewReader(list))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := transmission.ParseTorrents(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return res
+}
+
+func torrentJSON(id int, ratio float64, doneDate int64, status int, dir string, labels ...string) string {
+	bs, _ := json.Marshal(map[string]any{
+		"id": id, "hashString": fmt.Sprintf("hash%d", id), "name": fmt.Sprintf("torrent %d", id),
+		"uploadRatio": ratio, "doneDate": doneDate, "status": status, "downloadDir": dir, "labels": labels,
+	})
+	return string(bs)
+}
+
+func TestLoad(t *testing.T) {
+	cases := []struct {
+		name, config, err string
+	}{
+		{name: "short interval", config: "interval: 30s", err: "interval must be at least 1m0s"},
+		{name: "no actions", config: "max_actions: 0", err: "max_actions must be positive"},
+		{name: "bad action", config: "rules: [{min_ratio: 1, action: delete}]", err: "rule 1: action must be either stop or remove"},
+		{name: "no goals", config: "rules: [{name: all, action: stop}]", err: "all: min_ratio or min_seed_days must be set"},
+		{name: "delete on stop", config: "rules: [{min_ratio: 1, action: stop, delete_local_data: true}]",
+			err: "rule 1: delete_local_data is only allowed with action remove"},
+	}
+
+	for _, tc := range cases {
+		path := filepath.Join(t.TempDir(), "janitor.yaml")
+		if err := os.WriteFile(path, []byte(tc.config), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := Load(path); err == nil || err.Error() != tc.err {
+			t.Errorf("%s: got error %v, want %q", tc.name, err, tc.err)
+		}
+	}
+
+	path := filepath.Join(t.TempDir(), "janitor.yaml")
+	if err := os.WriteFile(path, []byte("rules: [{min_seed_days: 7, action: remove}]"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Interval != defaultInterval || cfg.MaxActions != defaultMaxActions || cfg.DryRun || cfg.Rules[0].DeleteLocalData {
+		t.Errorf("got config %+v, want defaults", cfg)
+	}
+}
+
+func TestRuleMatch(t *testing.T) {
+	goals := Rule{MinRatio: 2, MinSeedDays: 14, Action: ActionRemove}
+	labelled := goals
+	labelled.Label = "movies"
+	prefixed := goals
+	prefixed.DownloadPrefix = "/downloads/tv/"
+	stop := goals
+	stop.Action = ActionStop
+	ratioOnly := Rule{MinRatio: 2, Action: ActionRemove}
+
+	cases := []struct {
+		name    string
+		rule    Rule
+		torrent string
+		want    bool
+	}{
+		{name: "goals reached", rule: goals, torrent: torrentJSON(1, 2, daysAgo(14), 6, "/downloads"), want: true},
+		{name: "ratio not reached", rule: goals, torrent: torrentJSON(1, 1.99, daysAgo(30), 6, "/downloads")},
+		{name: "not seeded long enough", rule: goals, torrent: torrentJSON(1, 5, daysAgo(13.9), 6, "/downloads")},
+		{name: "not finished", rule: goals, torrent: torrentJSON(1, 5, 0, 4, "/downloads")},
+		{name: "ratio unknown", rule: goals, torrent: torrentJSON(1, ratioNone, daysAgo(30), 6, "/downloads")},
+		{name: "ratio infinite", rule: goals, torrent: torrentJSON(1, ratioInfinite, daysAgo(30), 6, "/downloads"), want: true},
+		{name: "ratio only", rule: ratioOnly, torrent: torrentJSON(1, 2, daysAgo(0.1), 6, "/downloads"), want: true},
+		{name: "label", rule: labelled, torrent: torrentJSON(1, 2, daysAgo(14), 6, "/downloads", "tv", "movies"), want: true},
+		{name: "other label", rule: labelled, torrent: torrentJSON(1, 2, daysAgo(14), 6, "/downloads", "tv")},
+		{name: "prefix", rule: prefixed, torrent: torrentJSON(1, 2, daysAgo(14), 6, "/downloads/tv/show"), want: true},
+		{name: "prefix itself", rule: prefixed, torrent: torrentJSON(1, 2, daysAgo(14), 6, "/downloads/tv"), want: true},
+		{name: "sibling of prefix", rule: prefixed, torrent: torrentJSON(1, 2, daysAgo(14), 6, "/downloads/tvshows")},
+		{name: "stop seeding", rule: stop, torrent: torrentJSON(1, 2, daysAgo(14), 6, "/downloads"), want: true},
+		{name: "already stopped", rule: stop, torrent: torrentJSON(1, 2, daysAgo(14), statusStopped, "/downloads")},
+	}
+
+	for _, tc := range cases {
+		torrents := parseTorrents(t, "["+tc.torrent+"]")
+		if got := tc.rule.Match(torrents, 0, now); got != tc.want {
+			t.Errorf("%s: got %v, want %v", tc.name, got, tc.want)
+		}
+	}
+
+	// tables are matched the same
+	table := parseTorrents(t, fmt.Sprintf(`[["id","uploadRatio","doneDate","labels"],[1,3,%d,["movies"]],[2,3,%d,["tv"]]]`,
+		daysAgo(20), daysAgo(20)))
+	if !labelled.Match(table, 0, now) || labelled.Match(table, 1, now) {
+		t.Error("table rows matched wrongly")
+	}
+}
+
+func TestPlan(t *testing.T) {
+	rules := []*Rule{
+		{Name: "movies", MinRatio: 1, Label: "movies", Action: ActionRemove},
+		{Name: "all", MinRatio: 3, Action: ActionStop},
+	}
+
+	var list []string
+	for i := 1; i <= 6; i++ {
+		labels := []string{"movies"}
+		if i%2 == 0 {
+			labels = nil
+		}
+		list = append(list, torrentJSON(i, float64(i), daysAgo(1), 6, "/downloads", labels...))
+	}
+	torrents := parseTorrents(t, "["+strings.Join(list, ",")+"]")
+
+	plan := func(maxActions int) []string {
+		var res []string
+		for _, a := range Plan(rules, torrents, now, maxActions) {
+			res = append(res, fmt.Sprintf("%d %s", a.ID, a.Rule.Name))
+		}
+		return res
+	}
+
+	// the first matching rule applies, torrents matching none are left alone
+	want := []string{"1 movies", "3 movies", "4 all", "5 movies", "6 all"}
+	if got := plan(10); !slices.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got := plan(3); !slices.Equal(got, want[:3]) {
+		t.Errorf("capped: got %v, want %v", got, want[:3])
+	}
+	if got := Plan(rules, parseTorrents(t, "[]"), now, 10); len(got) != 0 {
+		t.Errorf("no torrents: got %v", got)
+	}
+}
+
+// fakeUpstream lists its torrents and records the other calls, failing those for the failing hashes.
+type fakeUpstream struct {
+	torrents string
+	failing  []string
+	calls    []string
+}
+
+func (f *fakeUpstream) RoundTrip(r *http.Request) (*http.Response, error) {
+	var req jrpc.Request
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, err
+	}
+
+	w := httptest.NewRecorder()
+	if req.Method == "torrent-get" {
+		_, _ = w.WriteString(`{"result":"success","arguments":{"torrents":` + f.torrents + `}}`)
+		return w.Result(), nil
+	}
+
+	bs, _ := json.Marshal(req.Arguments)
+	f.calls = append(f.calls, req.Method+" "+string(bs))
+	result := "success"
+	if ids, _ := req.Arguments["ids"].([]any); len(ids) > 0 && slices.Contains(f.failing, ids[0].(string)) {
+		result = "torrent is busy"
+	}
+	_, _ = w.WriteString(`{"result":"` + result + `","arguments":{}}`)
+	return w.Result(), nil
+}
+
+func captureLog(t *testing.T) *bytes.Buffer {
+	var buf bytes.Buffer
+	prev := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&buf, nil)))
+	t.Cleanup(func() { slog.SetDefault(prev) })
+
+	return &buf
+}
+
+// testJanitor returns the janitor with the rules over the torrents along with the path of its audit log and the events
+// posted to its webhook.
+func testJanitor(t *testing.T, up *fakeUpstream, rules ...*Rule) (*Janitor, string, func() []map[string]any) {
+	var (
+		mu     sync.Mutex
+		events []map[string]any
+	)
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		bs, _ := io.ReadAll(r.Body)
+		var e map[string]any
+		if err := json.Unmarshal(bs, &e); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		events = append(events, e)
+		mu.Unlock()
+	}))
+	t.Cleanup(hook.Close)
+
+	auditPath := filepath.Join(t.TempDir(), "audit.log")
+	al, err := audit.Open(auditPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = al.Close() })
+
+	j := &Janitor{
+		Config:   &Config{Interval: time.Hour, MaxActions: 10, Rules: rules},
+		Upstream: &upstream.Client{URL: "http://transmission:9091/transmission/rpc", HTTP: &http.Client{Transport: up}},
+		Audit:    al,
+		Notifier: &webhook.Notifier{URL: hook.URL, Timeout: time.Second, HTTP: hook.Client()},
+	}
+
+	return j, auditPath, func() []map[string]any {
+		mu.Lock()
+		defer mu.Unlock()
+		return events
+	}
+}
+
+func auditRecords(t *testing.T, path string) []audit.Record {
+	t.Helper()
+
+	bs, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var res []audit.Record
+	for _, line := range strings.Split(strings.TrimSpace(string(bs)), "\n") {
+		if line == "" {
+			continue
+		}
+		var rec audit.Record
+		if err := json.Unmarshal([]byte(line), &rec); err != nil {
+			t.Fatal(err)
+		}
+		res = append(res, rec)
+	}
+
+	return res
+}
+
+func TestRun(t *testing.T) {
+	logs := captureLog(t)
+	up := &fakeUpstream{
+		torrents: "[" + strings.Join([]string{
+			torrentJSON(1, 3, time.Now().AddDate(0, 0, -30).Unix(), 6, "/downloads/movies", "movies"),
+			torrentJSON(2, 3, time.Now().AddDate(0, 0, -30).Unix(), 6, "/downloads/tv", "tv"),
+			torrentJSON(3, 3, time.Now().AddDate(0, 0, -30).Unix(), 6, "/downloads/movies", "movies"),
+			torrentJSON(4, 1, time.Now().AddDate(0, 0, -30).Unix(), 6, "/downloads/tv", "tv"),
+		}, ",") + "]",
+		failing: []string{"hash3"},
+	}
+	j, auditPath, events := testJanitor(t, up,
+		&Rule{Name: "movies", MinRatio: 2, Label: "movies", Action: ActionRemove, DeleteLocalData: true},
+		&Rule{Name: "seeded", MinRatio: 2, MinSeedDays: 7, Action: ActionStop},
+	)
+
+	if err := j.run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		`torrent-remove {"delete-local-data":true,"ids":["hash1"]}`,
+		`torrent-stop {"ids":["hash2"]}`,
+		`torrent-remove {"delete-local-data":true,"ids":["hash3"]}`,
+	}
+	if !slices.Equal(up.calls, want) {
+		t.Errorf("got calls %v, want %v", up.calls, want)
+	}
+
+	records := auditRecords(t, auditPath)
+	if len(records) != 3 {
+		t.Fatalf("got %d audit records, want 3", len(records))
+	}
+	for i, rec := range records {
+		if rec.User != User || rec.Method != strings.Fields(want[i])[0] {
+			t.Errorf("got audit record %+v", rec)
+		}
+		if failed := rec.Result != jrpc.ResultSuccess; failed != (i == 2) {
+			t.Errorf("got audit record %+v", rec)
+		}
+	}
+
+	// the failed action is not notified about
+	got := events()
+	if len(got) != 2 {
+		t.Fatalf("got events %v, want 2", got)
+	}
+	if e := got[0]; e["event"] != "janitor" || e["rule"] != "movies" || e["action"] != ActionRemove || e["delete_local_data"] != true ||
+		e["torrent"].(map[string]any)["hash"] != "hash1" {
+		t.Errorf("got event %v", e)
+	}
+	if !strings.Contains(logs.String(), `"level":"ERROR","msg":"janitor action failed: torrent-remove failed: torrent is busy"`) {
+		t.Errorf("failure not logged:\n%s", logs)
+	}
+}
+
+func TestRunCapped(t *testing.T) {
+	var list []string
+	for i := 1; i <= 5; i++ {
+		list = append(list, torrentJSON(i, 3, time.Now().AddDate(0, 0, -1).Unix(), 6, "/downloads"))
+	}
+	up := &fakeUpstream{torrents: "[" + strings.Join(list, ",") + "]"}
+	j, _, _ := testJanitor(t, up, &Rule{Name: "done", MinRatio: 1, Action: ActionRemove})
+	j.Config.MaxActions = 2
+
+	if err := j.run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{`torrent-remove {"delete-local-data":false,"ids":["hash1"]}`, `torrent-remove {"delete-local-data":false,"ids":["hash2"]}`}
+	if !slices.Equal(up.calls, want) {
+		t.Errorf("got calls %v, want %v", up.calls, want)
+	}
+}
+
+func TestRunDryRun(t *testing.T) {
+	logs := captureLog(t)
+	up := &fakeUpstream{torrents: "[" + torrentJSON(1, 3, time.Now().AddDate(0, 0, -1).Unix(), 6, "/downloads") + "]"}
+	j, auditPath, events := testJanitor(t, up, &Rule{Name: "done", MinRatio: 1, Action: ActionRemove})
+	j.Config.DryRun = true
+
+	if err := j.run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(up.calls) != 0 || len(auditRecords(t, auditPath)) != 0 || len(events()) != 0 {
+		t.Errorf("dry run acted: calls %v, events %v", up.calls, events())
+	}
+	if !strings.Contains(logs.String(), `"msg":"dry run: janitor action skipped","rule":"done","action":"remove","torrent":"torrent 1"`) {
+		t.Errorf("dry run not logged:\n%s", logs)
+	}
+}
+
+func TestRunUpstreamDown(t *testing.T) {
+	j, _, _ := testJanitor(t, &fakeUpstream{torrents: "null"}, &Rule{Name: "done", MinRatio: 1, Action: ActionRemove})
+	if err := j.run(context.Background()); err == nil || !strings.HasPrefix(err.Error(), "list torrents: ") {
+		t.Errorf("got error %v", err)
+	}
+
+	j.Upstream.HTTP.Transport = roundTripFunc(func(*http.Request) (*http.Response, error) {
+		return nil, errors.New("connection refused")
+	})
+	if err := j.run(context.Background()); err == nil || !strings.HasPrefix(err.Error(), "list torrents: ") {
+		t.Errorf("got error %v", err)
+	}
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
@@ -0,0 +1,419 @@
+package jrpc
+
+import (
+	"encoding/json"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"unicode/utf8"
+)
+
+// decodeRequest decodes well-formed requests in a single pass over the body, which is what most clients send,
+// so that frequent polls do not decode the body three times. Strings are interned (see internedString), notably
+// the field names torrent-get lists. It reports false, leaving r as it was, for anything out of the ordinary,
+// such as escaped member names, members named in other case or of unexpected types; such requests are decoded
+// by encoding/json as usual.
+func decodeRequest(bs []byte, r *Request) bool {
+	d := &decoder{bs: bs}
+	d.skipSpace()
+	if !d.consume('{') {
+		return false
+	}
+
+	var res Request
+	var seen [3]bool
+	d.skipSpace()
+	if d.consume('}') {
+		return d.end(r, &res)
+	}
+
+	for {
+		d.skipSpace()
+		key, ok := d.plainString()
+		if !ok {
+			return false
+		}
+		d.skipSpace()
+		if !d.consume(':') {
+			return false
+		}
+		d.skipSpace()
+
+		switch key {
+		case "method":
+			method, ok := d.plainString()
+			if !ok || seen[0] {
+				return false
+			}
+			res.Method, seen[0] = method, true
+		case "arguments":
+			if seen[1] {
+				return false
+			}
+			seen[1] = true
+			if d.literal("null") {
+				break
+			}
+			if d.peek() != '{' {
+				return false
+			}
+			args, ok := d.value(0)
+			if !ok {
+				return false
+			}
+			res.Arguments = args.(map[string]any)
+		case "tag":
+			if seen[2] {
+				return false
+			}
+			seen[2] = true
+			if d.literal("null") {
+				break
+			}
+			tag, ok := d.integer()
+			if !ok {
+				return false
+			}
+			res.Tag, res.HasTag = tag, true
+		default:
+			// encoding/json matches the known members case-insensitively
+			if strings.EqualFold(key, "method") || strings.EqualFold(key, "arguments") || strings.EqualFold(key, "tag") {
+				return false
+			}
+			start := d.pos
+			if _, ok := d.value(0); !ok {
+				return false
+			}
+			if res.Extra == nil {
+				res.Extra = map[string]json.RawMessage{}
+			}
+			res.Extra[key] = slices.Clone(d.bs[start:d.pos])
+		}
+
+		d.skipSpace()
+		if d.consume('}') {
+			return d.end(r, &res)
+		}
+		if !d.consume(',') {
+			return false
+		}
+	}
+}
+
+// maxDecodeDepth is the nesting limit of encoding/json, deeper values are left to it to reject.
+const maxDecodeDepth = 10000
+
+// decoder decodes JSON values the way encoding/json with UseNumber does, giving up on anything unusual.
+type decoder struct {
+	bs  []byte
+	pos int
+	// stack collects items of the arrays being decoded, so that every array is allocated once at its final size.
+	stack []any
+}
+
+// end completes decoding if nothing but spaces follows the request object.
+func (d *decoder) end(r *Request, res *Request) bool {
+	d.skipSpace()
+	if d.pos != len(d.bs) {
+		return false
+	}
+
+	r.Method, r.Arguments, r.Tag, r.HasTag, r.Extra = res.Method, res.Arguments, res.Tag, res.HasTag, res.Extra
+	return true
+}
+
+func (d *decoder) skipSpace() {
+	for d.pos < len(d.bs) {
+		switch d.bs[d.pos] {
+		case ' ', '\t', '\r', '\n':
+			d.pos++
+		default:
+			return
+		}
+	}
+}
+
+func (d *decoder) peek() byte {
+	if d.pos < len(d.bs) {
+		return d.bs[d.pos]
+	}
+
+	return 0
+}
+
+func (d *decoder) consume(c byte) bool {
+	if d.peek() != c {
+		return false
+	}
+
+	d.pos++
+	return true
+}
+
+func (d *decoder) literal(lit string) bool {
+	if len(d.bs)-d.pos < len(lit) || string(d.bs[d.pos:d.pos+len(lit)]) != lit {
+		return false
+	}
+
+	d.pos += len(lit)
+	return true
+}
+
+func (d *decoder) value(depth int) (any, bool) {
+	if depth > maxDecodeDepth {
+		return nil, false
+	}
+
+	switch c := d.peek(); {
+	case c == '{':
+		d.pos++
+		obj := map[string]any{}
+		d.skipSpace()
+		if d.consume('}') {
+			return obj, true
+		}
+		for {
+			d.skipSpace()
+			key, ok := d.plainString()
+			if !ok {
+				return nil, false
+			}
+			d.skipSpace()
+			if !d.consume(':') {
+				return nil, false
+			}
+			d.skipSpace()
+			if obj[key], ok = d.value(depth + 1); !ok {
+				return nil, false
+			}
+			d.skipSpace()
+			if d.consume('}') {
+				return obj, true
+			}
+			if !d.consume(',') {
+				return nil, false
+			}
+		}
+	case c == '[':
+		d.pos++
+		base := len(d.stack)
+		d.skipSpace()
+		if !d.consume(']') {
+			for {
+				d.skipSpace()
+				v, ok := d.value(depth + 1)
+				if !ok {
+					return nil, false
+				}
+				d.stack = append(d.stack, v)
+				d.skipSpace()
+				if d.consume(']') {
+					break
+				}
+				if !d.consume(',') {
+					return nil, false
+				}
+			}
+		}
+		arr := make([]any, len(d.stack)-base)
+		copy(arr, d.stack[base:])
+		clear(d.stack[base:])
+		d.stack = d.stack[:base]
+		return arr, true
+	case c == '"':
+		return d.stringValue()
+	case c == '-' || c >= '0' && c <= '9':
+		start := d.pos
+		if !d.number() {
+			return nil, false
+		}
+		return json.Number(d.bs[start:d.pos]), true
+	case d.literal("true"):
+		return true, true
+	case d.literal("false"):
+		return false, true
+	case d.literal("null"):
+		return nil, true
+	default:
+		return nil, false
+	}
+}
+
+// plainString decodes the string at the position if it has no escapes.
+func (d *decoder) plainString() (string, bool) {
+	b, ok := d.plainStringBytes()
+	if !ok {
+		return "", false
+	}
+
+	return internedString(b).(string), true
+}
+
+func (d *decoder) plainStringBytes() ([]byte, bool) {
+	if !d.consume('"') {
+		return nil, false
+	}
+
+	start := d.pos
+	ascii := true
+	for ; d.pos < len(d.bs); d.pos++ {
+		switch c := d.bs[d.pos]; {
+		case c == '"':
+			b := d.bs[start:d.pos]
+			d.pos++
+			// encoding/json replaces invalid UTF-8
+			return b, ascii || utf8.Valid(b)
+		case c == '\\' || c < 0x20:
+			return nil, false
+		case c >= utf8.RuneSelf:
+			ascii = false
+		}
+	}
+
+	return nil, false
+}
+
+// stringValue decodes the string at the position, unescaping it with encoding/json if needed.
+func (d *decoder) stringValue() (any, bool) {
+	start := d.pos
+	if b, ok := d.plainStringBytes(); ok {
+		return internedString(b), true
+	}
+
+	d.pos = start + 1
+	for d.pos < len(d.bs) {
+		switch d.bs[d.pos] {
+		case '\\':
+			d.pos += 2
+		case '"':
+			d.pos++
+			var s string
+			if err := json.Unmarshal(d.bs[start:d.pos], &s); err != nil {
+				return nil, false
+			}
+			return s, true
+		default:
+			d.pos++
+		}
+	}
+
+	return nil, false
+}
+
+// number skips the number at the position, reporting whether it follows the JSON grammar.
+func (d *decoder) number() bool {
+	d.consume('-')
+	switch {
+	case d.consume('0'):
+	case d.digits() > 0:
+	default:
+		return false
+	}
+	if d.consume('.') && d.digits() == 0 {
+		return false
+	}
+	if d.consume('e') || d.consume('E') {
+		if !d.consume('+') {
+			d.consume('-')
+		}
+		if d.digits() == 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (d *decoder) digits() int {
+	start := d.pos
+	for d.pos < len(d.bs) && d.bs[d.pos] >= '0' && d.bs[d.pos] <= '9' {
+		d.pos++
+	}
+
+	return d.pos - start
+}
+
+// integer decodes the number at the position if it is an integer written without fraction or exponent.
+func (d *decoder) integer() (int, bool) {
+	start := d.pos
+	if !d.number() {
+		return 0, false
+	}
+
+	b := d.bs[start:d.pos]
+	neg := b[0] == '-'
+	if neg {
+		b = b[1:]
+	}
+	// longer ones may overflow
+	if len(b) > 18 {
+		return 0, false
+	}
+
+	n := 0
+	for _, c := range b {
+		if c < '0' || c > '9' {
+			return 0, false
+		}
+		n = n*10 + int(c-'0')
+	}
+	if neg {
+		n = -n
+	}
+
+	return n, true
+}
+
+const (
+	// maxInterned bounds the number of interned strings, as the clients choose the strings.
+	maxInterned = 4096
+	// maxInternedLen keeps long strings, such as magnet links, from being interned.
+	maxInternedLen = 64
+)
+
+// interned maps the interned strings to themselves as interface values. It is replaced rather than modified,
+// so that it is read without locking.
+var (
+	interned   atomic.Pointer[map[string]any]
+	internedMu sync.Mutex
+)
+
+// internedString returns the string as interface value, the same one for the same string once it was seen.
+// Strings repeated in every poll, such as torrent field names, are allocated once rather than on every request.
+func internedString(b []byte) any {
+	if len(b) > maxInternedLen {
+		return string(b)
+	}
+	if m := interned.Load(); m != nil {
+		if v, ok := (*m)[string(b)]; ok {
+			return v
+		}
+	}
+
+	s := string(b)
+	var v any = s
+
+	internedMu.Lock()
+	defer internedMu.Unlock()
+
+	var cur map[string]any
+	if m := interned.Load(); m != nil {
+		cur = *m
+	}
+	if prev, ok := cur[s]; ok {
+		return prev
+	}
+	if len(cur) >= maxInterned {
+		return v
+	}
+
+	next := make(map[string]any, len(cur)+1)
+	for k, val := range cur {
+		next[k] = val
+	}
+	next[s] = v
+	interned.Store(&next)
+
+	return v
+}
@@ -0,0 +1,125 @@
+package jrpc
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDecodeRequest(t *testing.T) {
+	cases := []struct {
+		name string
+		in   string
+		// slow is set if the request is left to encoding/json
+		slow bool
+	}{
+		{name: "poll", in: `{"method":"torrent-get","arguments":{"fields":["id","name"],"ids":"recently-active"},"tag":3}`},
+		{name: "spaces", in: " {\n\t\"method\" : \"session-get\" ,\r\n \"tag\" : 1 } \n"},
+		{name: "empty", in: `{}`},
+		{name: "null members", in: `{"method":"session-get","arguments":null,"tag":null}`},
+		{name: "values", in: `{"method":"torrent-set","arguments":{"ids":[1,-2,3.5,1e3,-0.25E-2],"paused":true,` +
+			`"x":false,"y":null,"z":{},"w":[],"nested":{"a":[[{"b":"c"}]]}}}`},
+		{name: "big integer", in: `{"method":"torrent-get","arguments":{"ids":[9223372036854775807,18446744073709551616]}}`},
+		{name: "unicode", in: `{"method":"torrent-add","arguments":{"download-dir":"/downloads/фильмы/日本"}}`},
+		{name: "negative tag", in: `{"method":"session-get","tag":-7}`},
+		{name: "extra members", in: `{"method":"session-get","id":"x","extra":[1, {"a" : 2}]}`},
+		{name: "extra member twice", in: `{"method":"session-get","x":1,"x":2}`},
+		{name: "arguments member twice", in: `{"method":"torrent-get","arguments":{"ids":1,"ids":2}}`},
+		{name: "long string", in: `{"method":"torrent-add","arguments":{"filename":"` + strings.Repeat("a", 200) + `"}}`},
+
+		{name: "escaped value", in: `{"method":"torrent-add","arguments":{"download-dir":"/downloads/a\"b\\c\u00e9\n"}}`},
+		{name: "escaped key", in: `{"method":"torrent-add","arguments":{"download\u002ddir":"/downloads"}}`, slow: true},
+		{name: "escaped member", in: `{"m\u0065thod":"session-get"}`, slow: true},
+		{name: "escaped method", in: `{"method":"session\u002dget"}`, slow: true},
+		{name: "member case", in: `{"Method":"session-get","TAG":2}`, slow: true},
+		{name: "method twice", in: `{"method":"session-get","method":"session-set"}`, slow: true},
+		{name: "tag twice", in: `{"method":"session-get","tag":1,"tag":2}`, slow: true},
+		{name: "arguments twice", in: `{"method":"torrent-get","arguments":{"ids":1},"arguments":{"ids":2}}`, slow: true},
+		{name: "invalid utf-8 key", in: "{\"method\":\"torrent-add\",\"arguments\":{\"\xff\":1}}", slow: true},
+		{name: "invalid utf-8", in: "{\"method\":\"torrent-add\",\"arguments\":{\"download-dir\":\"/downloads/\xff\"}}"},
+		{name: "invalid utf-8 value", in: "{\"method\":\"torrent-add\",\"arguments\":{\"x\":\"\xff\\n\"}}"},
+		{name: "large tag", in: `{"method":"session-get","tag":12345678901234567890}`, slow: true},
+		{name: "fractional tag", in: `{"method":"session-get","tag":1.5}`, slow: true},
+		{name: "string tag", in: `{"method":"session-get","tag":"1"}`, slow: true},
+		{name: "numeric method", in: `{"method":1}`, slow: true},
+		{name: "array arguments", in: `{"method":"torrent-get","arguments":[]}`, slow: true},
+		{name: "leading zero", in: `{"method":"torrent-get","arguments":{"ids":01}}`, slow: true},
+		{name: "trailing comma", in: `{"method":"session-get",}`, slow: true},
+		{name: "trailing data", in: `{"method":"session-get"} {}`, slow: true},
+		{name: "unterminated", in: `{"method":"torrent-get","arguments":{"ids":[1,2}`, slow: true},
+		{name: "bare word", in: `{"method":"torrent-get","arguments":{"paused":tru}}`, slow: true},
+		{name: "array", in: `[{"method":"session-get"}]`, slow: true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var want Request
+			wantErr := want.decode([]byte(tc.in))
+
+			var got Request
+			if ok := decodeRequest([]byte(tc.in), &got); ok == tc.slow {
+				t.Fatalf("decoded in a single pass: %v, want %v", ok, !tc.slow)
+			}
+			if tc.slow {
+				if !reflect.DeepEqual(got, Request{}) {
+					t.Errorf("request modified: %+v", got)
+				}
+				return
+			}
+
+			if wantErr != nil {
+				t.Fatalf("encoding/json rejects the request: %v", wantErr)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("got %#v,\nwant %#v", got, want)
+			}
+		})
+	}
+}
+
+func TestDecodeRequestKeepsRaw(t *testing.T) {
+	r := Request{Raw: []byte("raw"), Header: map[string][]string{"X": {"y"}}}
+	if !decodeRequest([]byte(`{"method":"session-get"}`), &r) {
+		t.Fatal("not decoded")
+	}
+	if string(r.Raw) != "raw" || r.Header == nil || r.Method != "session-get" {
+		t.Errorf("got %+v", r)
+	}
+}
+
+func TestInternedString(t *testing.T) {
+	a, b := internedString([]byte("rateDownload")), internedString([]byte("rateDownload"))
+	if a != b || a.(string) != "rateDownload" {
+		t.Errorf("got %v and %v", a, b)
+	}
+
+	long := strings.Repeat("x", maxInternedLen+1)
+	if got := internedString([]byte(long)); got != long {
+		t.Errorf("got %v", got)
+	}
+	if m := interned.Load(); m != nil {
+		if _, ok := (*m)[long]; ok {
+			t.Error("long string interned")
+		}
+	}
+}
+
+func BenchmarkDecodeRequest(b *testing.B) {
+	bs := []byte(`{"method":"torrent-get","arguments":{"fields":["id","error","errorString","eta","isFinished",` +
+		`"leftUntilDone","percentDone","rateDownload","rateUpload","status"],"ids":"recently-active"},"tag":3}`)
+
+	b.Run("single pass", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			var r Request
+			decodeRequest(bs, &r)
+		}
+	})
+	b.Run("encoding/json", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			var r Request
+			_ = r.decode(bs)
+		}
+	})
+}
@@ -1,33 +1,450 @@
 package jrpc
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
 	"net/http"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
 )
 
+// Request is RPC request. It is serialized with the same members it was parsed from: top-level members other
+// than method, arguments and tag are kept in Extra, and tag is kept even if zero when HasTag is set. Numbers
+// in Arguments are kept as json.Number, so that they are forwarded as sent rather than rounded to float64.
 type Request struct {
 	Method    string                 `json:"method"`
 	Arguments map[string]interface{} `json:"arguments,omitempty"`
 	Tag       int                    `json:"tag,omitempty"`
-	Context   context.Context        `json:"-"`
+	// HasTag tells that the request came with tag, which may be zero.
+	HasTag bool `json:"-"`
+	// Extra holds unknown top-level members of the request as received.
+	Extra   map[string]json.RawMessage `json:"-"`
+	Context context.Context            `json:"-"`
+	// Raw is the request body exactly as received from the client.
+	Raw []byte `json:"-"`
+	// Header holds the HTTP headers the request came with.
+	Header http.Header `json:"-"`
 }
 
+// wireRequest holds the members of Request as sent over the wire.
+type wireRequest struct {
+	Method    string         `json:"method"`
+	Arguments map[string]any `json:"arguments,omitempty"`
+	Tag       *int           `json:"tag,omitempty"`
+}
+
+var (
+	// ErrEmptyBody is returned by FromRequest for requests without body.
+	ErrEmptyBody = errors.New("request body is empty")
+	// ErrNotJSON is returned by FromRequest for bodies which are not JSON at all.
+	ErrNotJSON = errors.New("request body is not valid JSON")
+	// ErrNotObject is returned for requests which are JSON, but not an object.
+	ErrNotObject = errors.New("request must be a JSON object")
+	// ErrArgumentsNotObject is returned for requests with arguments other than an object.
+	ErrArgumentsNotObject = errors.New("arguments must be a JSON object")
+)
+
+// MalformedError is returned by FromRequest when the body is not a valid RPC request. Its message describes
+// the problem with the body only, so it is safe to show to the client.
+type MalformedError struct {
+	// Tag of the request if it could be found in the body, zero otherwise.
+	Tag int
+	Err error
+}
+
+func (e *MalformedError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *MalformedError) Unwrap() error {
+	return e.Err
+}
+
+// PublicMessage implements response.HasPublicMessage.
+func (e *MalformedError) PublicMessage() string {
+	return e.Err.Error()
+}
+
+func (r *Request) UnmarshalJSON(bs []byte) error {
+	if decodeRequest(bs, r) {
+		return nil
+	}
+
+	return r.decode(bs)
+}
+
+// decode decodes the request with encoding/json, in any shape it accepts.
+func (r *Request) decode(bs []byte) error {
+	var members map[string]json.RawMessage
+	if err := json.Unmarshal(bs, &members); err != nil || members == nil {
+		return ErrNotObject
+	}
+
+	// arguments are decoded separately, so that method and tag are known even if arguments are malformed
+	var w struct {
+		Method    string          `json:"method"`
+		Arguments json.RawMessage `json:"arguments"`
+		Tag       *int            `json:"tag"`
+	}
+	if err := json.Unmarshal(bs, &w); err != nil {
+		return err
+	}
+
+	args, err := decodeArguments(w.Arguments)
+	if err != nil {
+		return err
+	}
+
+	r.Method, r.Arguments, r.Tag, r.HasTag = w.Method, args, 0, w.Tag != nil
+	if w.Tag != nil {
+		r.Tag = *w.Tag
+	}
+
+	r.Extra = extraMembers(members, "method", "arguments", "tag")
+	return nil
+}
+
+// extraMembers returns the members other than the known ones, or nil if there are none.
+func extraMembers(members map[string]json.RawMessage, known ...string) map[string]json.RawMessage {
+	var res map[string]json.RawMessage
+	for key, val := range members {
+		// encoding/json matches the known members case-insensitively
+		if slices.ContainsFunc(known, func(k string) bool { return strings.EqualFold(key, k) }) {
+			continue
+		}
+		if res == nil {
+			res = map[string]json.RawMessage{}
+		}
+		res[key] = val
+	}
+
+	return res
+}
+
+func (r Request) MarshalJSON() ([]byte, error) {
+	w := wireRequest{Method: r.Method, Arguments: r.Arguments}
+	if r.Tag != 0 || r.HasTag {
+		w.Tag = &r.Tag
+	}
+
+	bs, err := json.Marshal(w)
+	if err != nil {
+		return nil, err
+	}
+
+	return appendExtra(bs, r.Extra)
+}
+
+// decodeArguments decodes the arguments object, keeping numbers as json.Number. Missing or null arguments
+// are decoded as nil map.
+func decodeArguments(bs json.RawMessage) (map[string]any, error) {
+	if len(bs) == 0 || bytes.Equal(bs, []byte("null")) {
+		return nil, nil
+	}
+	if bs[0] != '{' {
+		return nil, ErrArgumentsNotObject
+	}
+
+	var args map[string]any
+	dec := json.NewDecoder(bytes.NewReader(bs))
+	dec.UseNumber()
+	if err := dec.Decode(&args); err != nil {
+		return nil, err
+	}
+
+	return args, nil
+}
+
+// appendExtra adds the extra members, sorted by name, to the serialized JSON object.
+func appendExtra(bs []byte, extra map[string]json.RawMessage) ([]byte, error) {
+	if len(extra) == 0 {
+		return bs, nil
+	}
+
+	buf := bytes.NewBuffer(bs[:len(bs)-1])
+	keys := make([]string, 0, len(extra))
+	for key := range extra {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		k, _ := json.Marshal(key)
+		buf.WriteByte(',')
+		buf.Write(k)
+		buf.WriteByte(':')
+		if err := json.Compact(buf, extra[key]); err != nil {
+			return nil, fmt.Errorf("member %s: %w", key, err)
+		}
+	}
+	buf.WriteByte('}')
+
+	return buf.Bytes(), nil
+}
+
+// Ctx returns the context of the HTTP request the RPC request came with, or background context
+// for requests constructed elsewhere (e.g. in tests or by internal callers).
+func (r *Request) Ctx() context.Context {
+	if r.Context == nil {
+		return context.Background()
+	}
+
+	return r.Context
+}
+
+// maxPreallocatedBody limits the buffer allocated upfront for the request body by its Content-Length.
+const maxPreallocatedBody = 1 << 20
+
+// BodyTooLargeError is returned by FromRequest when the body was cut short by http.MaxBytesReader.
+type BodyTooLargeError struct {
+	Limit int64
+	// Tag of the request if it could be found in the part of the body read, zero otherwise.
+	Tag int
+}
+
+func (e *BodyTooLargeError) Error() string {
+	return fmt.Sprintf("request body exceeds %d bytes", e.Limit)
+}
+
+// FromRequest reads and parses the RPC request. Body limited with http.MaxBytesReader results
+// in BodyTooLargeError when exceeded, invalid requests in MalformedError.
 func FromRequest(r *http.Request) (*Request, error) {
+	bs, err := readBody(r)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := parseRequest(bs)
+	if err != nil {
+		return nil, fmt.Errorf("parse body: %w", err)
+	}
+
+	req.Context = r.Context()
+	req.Header = r.Header
+	return req, nil
+}
+
+// BatchItemError is returned by FromRequestBatch when an item of the batch is not a valid request.
+type BatchItemError struct {
+	Index int
+	Err   error
+}
+
+func (e *BatchItemError) Error() string {
+	return fmt.Sprintf("request %d of batch: %s", e.Index, e.Err)
+}
+
+func (e *BatchItemError) Unwrap() error {
+	return e.Err
+}
+
+// FromRequestBatch reads and parses either a single RPC request or JSON array of them, which some clients use
+// to batch several calls in one HTTP request. Raw of batch items holds the item alone. Errors are the same as
+// of FromRequest, invalid items of the batch result in BatchItemError.
+func FromRequestBatch(r *http.Request) ([]*Request, error) {
+	bs, err := readBody(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if trimmed := bytes.TrimLeft(bs, " \t\r\n"); len(trimmed) == 0 || trimmed[0] != '[' {
+		req, err := parseRequest(bs)
+		if err != nil {
+			return nil, fmt.Errorf("parse body: %w", err)
+		}
+
+		req.Context = r.Context()
+		req.Header = r.Header
+		return []*Request{req}, nil
+	}
+
+	var items []json.RawMessage
+	if err = json.Unmarshal(bs, &items); err != nil {
+		return nil, fmt.Errorf("parse body: %w", err)
+	}
+
+	reqs := make([]*Request, len(items))
+	for i, item := range items {
+		req, err := parseRequest(item)
+		if err != nil {
+			return nil, &BatchItemError{Index: i, Err: err}
+		}
+
+		req.Context = r.Context()
+		req.Header = r.Header
+		reqs[i] = req
+	}
+
+	return reqs, nil
+}
+
+func readBody(r *http.Request) ([]byte, error) {
 	defer func() { _ = r.Body.Close() }()
 
-	bs, err := io.ReadAll(r.Body)
+	// read into buffer of the declared size at once rather than growing it in steps, not trusting the size too much
+	buf := bytes.NewBuffer(make([]byte, 0, min(max(r.ContentLength, 0), maxPreallocatedBody)+bytes.MinRead))
+	_, err := buf.ReadFrom(r.Body)
 	if err != nil {
+		var mbe *http.MaxBytesError
+		if errors.As(err, &mbe) {
+			return nil, &BodyTooLargeError{Limit: mbe.Limit, Tag: peekTag(buf.Bytes())}
+		}
+
 		return nil, fmt.Errorf("read body: %w", err)
 	}
 
+	return buf.Bytes(), nil
+}
+
+// parseRequest parses the request, returning MalformedError if it is not valid.
+func parseRequest(bs []byte) (*Request, error) {
+	if len(bytes.TrimSpace(bs)) == 0 {
+		return nil, &MalformedError{Err: ErrEmptyBody}
+	}
+
 	req := Request{}
-	if err = json.Unmarshal(bs, &req); err != nil {
-		return nil, fmt.Errorf("parse body: %w", err)
+	if err := json.Unmarshal(bs, &req); err != nil {
+		// the body is checked to be JSON before it is decoded
+		var syntaxErr *json.SyntaxError
+		if errors.As(err, &syntaxErr) {
+			err = fmt.Errorf("%w: %s", ErrNotJSON, err)
+		}
+
+		return nil, &MalformedError{Tag: peekTag(bs), Err: err}
 	}
 
-	req.Context = r.Context()
+	req.Raw = bs
 	return &req, nil
 }
+
+// peekTag finds the tag among top-level keys of the possibly incomplete request object.
+func peekTag(bs []byte) int {
+	dec := json.NewDecoder(bytes.NewReader(bs))
+	if t, err := dec.Token(); err != nil || t != json.Delim('{') {
+		return 0
+	}
+
+	for dec.More() {
+		key, err := dec.Token()
+		if err != nil {
+			return 0
+		}
+
+		var val json.RawMessage
+		if err = dec.Decode(&val); err != nil {
+			return 0
+		}
+
+		if key == "tag" {
+			var tag int
+			_ = json.Unmarshal(val, &tag)
+			return tag
+		}
+	}
+
+	return 0
+}
+
+// DiffArguments returns sorted names of the arguments of orig missing from changed, and of the arguments
+// of changed which were added or have different values. Both lists are non-nil.
+func DiffArguments(orig, changed *Request) (dropped, rewritten []string) {
+	dropped, rewritten = []string{}, []string{}
+	for key := range orig.Arguments {
+		if _, ok := changed.Arguments[key]; !ok {
+			dropped = append(dropped, key)
+		}
+	}
+	for key, val := range changed.Arguments {
+		if o, ok := orig.Arguments[key]; !ok || !reflect.DeepEqual(o, val) {
+			rewritten = append(rewritten, key)
+		}
+	}
+	sort.Strings(dropped)
+	sort.Strings(rewritten)
+
+	return dropped, rewritten
+}
+
+const ResultSuccess = "success"
+
+// Response is RPC response. Like Request, it is serialized with the same members it was parsed from, including
+// unknown top-level members and zero tag.
+type Response struct {
+	Result    string         `json:"result"`
+	Arguments map[string]any `json:"arguments"`
+	Tag       int            `json:"tag,omitempty"`
+	// HasTag tells that the response came with tag, which may be zero.
+	HasTag bool `json:"-"`
+	// Extra holds unknown top-level members of the response as received.
+	Extra map[string]json.RawMessage `json:"-"`
+}
+
+// wireResponse holds the members of Response as sent over the wire.
+type wireResponse struct {
+	Result    string         `json:"result"`
+	Arguments map[string]any `json:"arguments"`
+	Tag       *int           `json:"tag,omitempty"`
+}
+
+func (r *Response) UnmarshalJSON(bs []byte) error {
+	var members map[string]json.RawMessage
+	if err := json.Unmarshal(bs, &members); err != nil || members == nil {
+		return ErrNotObject
+	}
+
+	var w struct {
+		Result    string          `json:"result"`
+		Arguments json.RawMessage `json:"arguments"`
+		Tag       *int            `json:"tag"`
+	}
+	if err := json.Unmarshal(bs, &w); err != nil {
+		return err
+	}
+
+	args, err := decodeArguments(w.Arguments)
+	if err != nil {
+		return err
+	}
+
+	r.Result, r.Arguments, r.Tag, r.HasTag = w.Result, args, 0, w.Tag != nil
+	if w.Tag != nil {
+		r.Tag = *w.Tag
+	}
+	r.Extra = extraMembers(members, "result", "arguments", "tag")
+
+	return nil
+}
+
+func (r Response) MarshalJSON() ([]byte, error) {
+	w := wireResponse{Result: r.Result, Arguments: r.Arguments}
+	if r.Tag != 0 || r.HasTag {
+		w.Tag = &r.Tag
+	}
+
+	bs, err := json.Marshal(w)
+	if err != nil {
+		return nil, err
+	}
+
+	return appendExtra(bs, r.Extra)
+}
+
+// ParseResponse parses the upstream response. Numbers are kept as json.Number, so that responses
+// rewritten by the proxy do not lose precision.
+func ParseResponse(bs []byte) (*Response, error) {
+	var resp Response
+	if err := json.Unmarshal(bs, &resp); err != nil {
+		return nil, fmt.Errorf("parse response: %w", err)
+	}
+	// Transmission always sends arguments, even if empty
+	if resp.Arguments == nil {
+		resp.Arguments = map[string]any{}
+	}
+
+	return &resp, nil
+}
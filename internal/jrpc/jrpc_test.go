@@ -0,0 +1,280 @@
+package jrpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestResponseRoundTrip(t *testing.T) {
+	cases := []struct {
+		name, in, out string
+	}{
+		{
+			name: "zero tag",
+			in:   `{"arguments":{},"result":"success","tag":0}`,
+			out:  `{"result":"success","arguments":{},"tag":0}`,
+		},
+		{
+			name: "no tag",
+			in:   `{"arguments":{"x":1},"result":"success"}`,
+			out:  `{"result":"success","arguments":{"x":1}}`,
+		},
+		{
+			name: "unknown members",
+			in:   `{"result":"success","arguments":{"size":9223372036854775807},"tag":5,"b":[1, 2],"a":{"k":"v"}}`,
+			out:  `{"result":"success","arguments":{"size":9223372036854775807},"tag":5,"a":{"k":"v"},"b":[1,2]}`,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			resp, err := ParseResponse([]byte(tc.in))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			bs, err := json.Marshal(resp)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(bs) != tc.out {
+				t.Errorf("got %s\nwant %s", bs, tc.out)
+			}
+		})
+	}
+}
+
+func TestParseResponseNumbers(t *testing.T) {
+	resp, err := ParseResponse([]byte(`{"result":"success","arguments":{"torrents":[{"id":1,"percentDone":0.25}]}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	torrent := resp.Arguments["torrents"].([]any)[0].(map[string]any)
+	if n, ok := torrent["percentDone"].(json.Number); !ok || n != "0.25" {
+		t.Errorf("got percentDone %#v, want json.Number", torrent["percentDone"])
+	}
+}
+
+func TestParseResponseMissingArguments(t *testing.T) {
+	resp, err := ParseResponse([]byte(`{"result":"no such method"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Arguments == nil || resp.HasTag {
+		t.Errorf("got arguments %v, has tag %v", resp.Arguments, resp.HasTag)
+	}
+
+	if _, err := ParseResponse([]byte(`[]`)); err == nil {
+		t.Error("array parsed as response")
+	}
+}
+
+func TestRequestCtx(t *testing.T) {
+	var req Request
+	if req.Ctx() == nil {
+		t.Error("no context for request without one")
+	}
+
+	type key struct{}
+	r := httptest.NewRequest(http.MethodPost, "/transmission/rpc", strings.NewReader(`{"method":"session-get"}`))
+	r = r.WithContext(context.WithValue(r.Context(), key{}, "v"))
+	parsed, err := FromRequest(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.Ctx().Value(key{}) != "v" {
+		t.Error("request does not carry the context of HTTP request")
+	}
+}
+
+func TestFromRequestTooLarge(t *testing.T) {
+	cases := []struct {
+		name, body string
+		tag        int
+	}{
+		{name: "tag before the limit", body: `{"tag":7,"method":"torrent-add","arguments":{"metainfo":"` + strings.Repeat("A", 100) + `"}}`, tag: 7},
+		{name: "tag after the limit", body: `{"method":"torrent-add","arguments":{"metainfo":"` + strings.Repeat("A", 100) + `"},"tag":7}`},
+		{name: "not object", body: `[` + strings.Repeat(`1,`, 100) + `1]`},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodPost, "/transmission/rpc", strings.NewReader(tc.body))
+			r.Body = http.MaxBytesReader(w, r.Body, 64)
+
+			_, err := FromRequest(r)
+			var tooLarge *BodyTooLargeError
+			if !errors.As(err, &tooLarge) {
+				t.Fatalf("got error %v", err)
+			}
+			if tooLarge.Limit != 64 || tooLarge.Tag != tc.tag {
+				t.Errorf("got %+v, want tag %d", tooLarge, tc.tag)
+			}
+		})
+	}
+
+	// the body of the limit size exactly is fine
+	body := `{"method":"session-get","tag":1}`
+	r := httptest.NewRequest(http.MethodPost, "/transmission/rpc", strings.NewReader(body))
+	r.Body = http.MaxBytesReader(httptest.NewRecorder(), r.Body, int64(len(body)))
+	if _, err := FromRequest(r); err != nil {
+		t.Errorf("body at the limit: got error %v", err)
+	}
+}
+
+func TestFromRequestTrailingData(t *testing.T) {
+	for _, body := range []string{
+		`{"method":"session-get","tag":3} {"method":"session-set"}`,
+		`{"method":"session-get","tag":3}garbage`,
+		`{"method":"session-get","tag":3}}`,
+	} {
+		r := httptest.NewRequest(http.MethodPost, "/transmission/rpc", strings.NewReader(body))
+		_, err := FromRequest(r)
+		var malformed *MalformedError
+		if !errors.As(err, &malformed) {
+			t.Errorf("%s: got error %v", body, err)
+		}
+	}
+
+	r := httptest.NewRequest(http.MethodPost, "/transmission/rpc", strings.NewReader("{\"method\":\"session-get\"}\r\n"))
+	if _, err := FromRequest(r); err != nil {
+		t.Errorf("trailing newline: got error %v", err)
+	}
+}
+
+func TestFromRequestMalformed(t *testing.T) {
+	cases := []struct {
+		name, body string
+		err        error
+		tag        int
+	}{
+		{name: "empty", body: "", err: ErrEmptyBody},
+		{name: "blank", body: " \r\n", err: ErrEmptyBody},
+		{name: "not JSON", body: "method=session-get&tag=3", err: ErrNotJSON},
+		// the tag is found in what is there
+		{name: "truncated", body: `{"tag":3,"method":"session-get"`, err: ErrNotJSON, tag: 3},
+		{name: "not object", body: `["session-get",3]`, err: ErrNotObject},
+		{name: "arguments array", body: `{"method":"torrent-get","arguments":[1,2,3],"tag":4}`, err: ErrArgumentsNotObject, tag: 4},
+		{name: "arguments string", body: `{"tag":5,"method":"torrent-get","arguments":"oops"}`, err: ErrArgumentsNotObject, tag: 5},
+		{name: "arguments number", body: `{"method":"torrent-get","arguments":1}`, err: ErrArgumentsNotObject},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/transmission/rpc", strings.NewReader(tc.body))
+			_, err := FromRequest(r)
+			var malformed *MalformedError
+			if !errors.As(err, &malformed) || !errors.Is(err, tc.err) {
+				t.Fatalf("got error %v, want %v", err, tc.err)
+			}
+			if malformed.Tag != tc.tag {
+				t.Errorf("got tag %d, want %d", malformed.Tag, tc.tag)
+			}
+			if malformed.PublicMessage() != malformed.Err.Error() || !strings.HasPrefix(malformed.PublicMessage(), tc.err.Error()) {
+				t.Errorf("got public message %q", malformed.PublicMessage())
+			}
+		})
+	}
+
+	// missing and null arguments are no arguments
+	for _, body := range []string{`{"method":"session-get","tag":1}`, `{"method":"session-get","arguments":null,"tag":1}`} {
+		r := httptest.NewRequest(http.MethodPost, "/transmission/rpc", strings.NewReader(body))
+		if req, err := FromRequest(r); err != nil || req.Arguments != nil || req.Tag != 1 {
+			t.Errorf("%s: got request %+v, error %v", body, req, err)
+		}
+	}
+}
+
+// equivalentJSON reports whether the JSON texts have the same values, ignoring the order of object members
+// and whitespace, but not the representation of numbers.
+func equivalentJSON(t *testing.T, a, b []byte) bool {
+	t.Helper()
+
+	decode := func(bs []byte) any {
+		var v any
+		dec := json.NewDecoder(bytes.NewReader(bs))
+		dec.UseNumber()
+		if err := dec.Decode(&v); err != nil {
+			t.Fatalf("decode %s: %v", bs, err)
+		}
+		return v
+	}
+
+	return reflect.DeepEqual(decode(a), decode(b))
+}
+
+func TestRequestRoundTrip(t *testing.T) {
+	cases := []struct {
+		name, in string
+	}{
+		{name: "zero tag", in: `{"method":"session-get","tag":0}`},
+		{name: "no tag", in: `{"method":"session-get"}`},
+		{name: "extras and zero tag", in: `{"tag":0,"format":"table","method":"torrent-get","arguments":{"fields":["id"]},` +
+			`"x-vendor":{"client":"tremotesf","v":[1,2.50,null]}}`},
+		{name: "extra in odd case", in: `{"Method":"session-get","TAG":0,"Format":"objects"}`},
+		{name: "escaped extra", in: `{"method":"session-get","x\"y":"aé\n"}`},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var req Request
+			if err := json.Unmarshal([]byte(tc.in), &req); err != nil {
+				t.Fatal(err)
+			}
+			out, err := json.Marshal(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// the members known to be sent with other names are written with the canonical ones
+			want := strings.NewReplacer(`"Method"`, `"method"`, `"TAG"`, `"tag"`).Replace(tc.in)
+			if !equivalentJSON(t, out, []byte(want)) {
+				t.Errorf("got %s, want %s", out, want)
+			}
+		})
+	}
+
+	// requests made by the proxy itself have no tag unless it is set
+	out, _ := json.Marshal(Request{Method: "session-get", Tag: 3})
+	if string(out) != `{"method":"session-get","tag":3}` {
+		t.Errorf("got %s", out)
+	}
+	out, _ = json.Marshal(Request{Method: "session-get"})
+	if string(out) != `{"method":"session-get"}` {
+		t.Errorf("got %s", out)
+	}
+}
+
+func TestRequestNumbersVerbatim(t *testing.T) {
+	nested := `[[[[[[[[[[1,[9223372036854775807]]]]]]]]]],0.1,-0,1e400]`
+	in := `{"method":"torrent-set","arguments":{"downloadLimit":9223372036854775807,"files-wanted":` + nested +
+		`,"ids":[9007199254740993],"seedRatioLimit":1.10}}`
+
+	var req Request
+	if err := json.Unmarshal([]byte(in), &req); err != nil {
+		t.Fatal(err)
+	}
+	if n, ok := req.Arguments["downloadLimit"].(json.Number); !ok || n.String() != "9223372036854775807" {
+		t.Errorf("got downloadLimit %#v", req.Arguments["downloadLimit"])
+	}
+
+	// an argument is dropped, so that the request is not forwarded as received
+	delete(req.Arguments, "seedRatioLimit")
+	out, err := json.Marshal(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"method":"torrent-set","arguments":{"downloadLimit":9223372036854775807,"files-wanted":` + nested + `,"ids":[9007199254740993]}}`
+	if string(out) != want {
+		t.Errorf("got %s,\nwant %s", out, want)
+	}
+}
@@ -0,0 +1,168 @@
+package lockout
+
+import (
+	"sync"
+	"time"
+)
+
+// maxEntries bounds the number of tracked (client, user) pairs.
+const maxEntries = 10000
+
+// Tracker counts authentication failures per key (client address and user name) and locks the key out
+// for Duration after MaxFailures failures within Window.
+type Tracker struct {
+	MaxFailures int
+	Window      time.Duration
+	Duration    time.Duration
+	// OnLockout is called when a key gets locked out.
+	OnLockout func(key Key)
+
+	mu        sync.Mutex
+	entries   map[Key]*entry
+	lastSweep time.Time
+}
+
+type Key struct {
+	ClientIP string `json:"client_ip"`
+	User     string `json:"user"`
+}
+
+type entry struct {
+	failures    int
+	windowStart time.Time
+	lockedUntil time.Time
+	lastSeen    time.Time
+}
+
+// Lockout describes a locked out key.
+type Lockout struct {
+	Key
+	Until time.Time `json:"until"`
+}
+
+// Locked reports whether the key is locked out and for how long.
+func (t *Tracker) Locked(key Key) (bool, time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	e, ok := t.entries[key]
+	if !ok {
+		return false, 0
+	}
+
+	if d := time.Until(e.lockedUntil); d > 0 {
+		return true, d
+	}
+
+	return false, 0
+}
+
+// Fail records authentication failure, returning true if the key got locked out by it.
+func (t *Tracker) Fail(key Key) bool {
+	t.mu.Lock()
+
+	now := time.Now()
+	e, ok := t.entries[key]
+	if !ok {
+		if t.entries == nil {
+			t.entries = map[Key]*entry{}
+		}
+		if len(t.entries) >= maxEntries || now.Sub(t.lastSweep) > t.Window {
+			t.evict(now)
+		}
+
+		e = &entry{windowStart: now}
+		t.entries[key] = e
+	}
+
+	e.lastSeen = now
+	if now.Sub(e.windowStart) > t.Window {
+		e.failures, e.windowStart = 0, now
+	}
+	e.failures++
+
+	locked := e.failures >= t.MaxFailures && !now.Before(e.lockedUntil)
+	if locked {
+		e.lockedUntil = now.Add(t.Duration)
+		e.failures, e.windowStart = 0, now
+	}
+
+	t.mu.Unlock()
+
+	if locked && t.OnLockout != nil {
+		t.OnLockout(key)
+	}
+
+	return locked
+}
+
+// Succeed resets the failure counter of the key.
+func (t *Tracker) Succeed(key Key) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if e, ok := t.entries[key]; ok && !time.Now().Before(e.lockedUntil) {
+		delete(t.entries, key)
+	}
+}
+
+// Clear removes lockout and failures of the key, returning false if nothing was tracked for it.
+func (t *Tracker) Clear(key Key) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	_, ok := t.entries[key]
+	delete(t.entries, key)
+	return ok
+}
+
+// Lockouts returns currently locked out keys.
+func (t *Tracker) Lockouts() []Lockout {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	var res []Lockout
+	for k, e := range t.entries {
+		if now.Before(e.lockedUntil) {
+			res = append(res, Lockout{Key: k, Until: e.lockedUntil})
+		}
+	}
+
+	return res
+}
+
+// evict drops idle entries; if the store is still full, drops the least recently seen unlocked entries.
+// Must be called with the lock held.
+func (t *Tracker) evict(now time.Time) {
+	t.lastSweep = now
+	for k, e := range t.entries {
+		if now.Sub(e.lastSeen) > t.Window && !now.Before(e.lockedUntil) {
+			delete(t.entries, k)
+		}
+	}
+
+	for len(t.entries) >= maxEntries {
+		var oldest Key
+		var oldestSeen time.Time
+		for k, e := range t.entries {
+			if now.Before(e.lockedUntil) {
+				continue
+			}
+			if oldestSeen.IsZero() || e.lastSeen.Before(oldestSeen) {
+				oldest, oldestSeen = k, e.lastSeen
+			}
+		}
+
+		if oldestSeen.IsZero() {
+			// Everything is locked out, forget the lockout expiring first.
+			for k, e := range t.entries {
+				if oldestSeen.IsZero() || e.lockedUntil.Before(oldestSeen) {
+					oldest, oldestSeen = k, e.lockedUntil
+				}
+			}
+		}
+
+		delete(t.entries, oldest)
+	}
+}
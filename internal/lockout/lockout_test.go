@@ -0,0 +1,154 @@
+package lockout
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+var (
+	alice   = Key{ClientIP: "192.0.2.1", User: "alice"}
+	mallory = Key{ClientIP: "198.51.100.9", User: "alice"}
+)
+
+func failTimes(t *Tracker, key Key, n int) (locked bool) {
+	for i := 0; i < n; i++ {
+		locked = t.Fail(key) || locked
+	}
+
+	return locked
+}
+
+func TestLockout(t *testing.T) {
+	var lockedOut []Key
+	tr := &Tracker{MaxFailures: 3, Window: time.Minute, Duration: time.Hour,
+		OnLockout: func(key Key) { lockedOut = append(lockedOut, key) }}
+
+	if failTimes(tr, mallory, 2) {
+		t.Fatal("locked out before reaching the limit")
+	}
+	if locked, _ := tr.Locked(mallory); locked {
+		t.Fatal("locked out before reaching the limit")
+	}
+	if !tr.Fail(mallory) {
+		t.Fatal("not locked out after reaching the limit")
+	}
+
+	locked, retryAfter := tr.Locked(mallory)
+	if !locked || retryAfter <= 59*time.Minute || retryAfter > time.Hour {
+		t.Errorf("got locked %v for %s, want an hour", locked, retryAfter)
+	}
+	if len(lockedOut) != 1 || lockedOut[0] != mallory {
+		t.Errorf("OnLockout called for %v", lockedOut)
+	}
+
+	// the pair is locked out, not the user or the client
+	if locked, _ := tr.Locked(alice); locked {
+		t.Error("same user from another client locked out")
+	}
+	if locked, _ := tr.Locked(Key{ClientIP: mallory.ClientIP, User: "bob"}); locked {
+		t.Error("another user from the same client locked out")
+	}
+
+	// failures while locked out neither extend the lockout nor call OnLockout again
+	if failTimes(tr, mallory, 5) || len(lockedOut) != 1 {
+		t.Error("locked out again while locked out")
+	}
+
+	// success does not lift the lockout, e.g. if the password is guessed during it
+	tr.Succeed(mallory)
+	if locked, _ := tr.Locked(mallory); !locked {
+		t.Error("success lifted the lockout")
+	}
+
+	got := tr.Lockouts()
+	if len(got) != 1 || got[0].Key != mallory || time.Until(got[0].Until) <= 59*time.Minute {
+		t.Errorf("got lockouts %+v", got)
+	}
+}
+
+func TestLockoutReset(t *testing.T) {
+	tr := &Tracker{MaxFailures: 3, Window: time.Minute, Duration: time.Hour}
+
+	failTimes(tr, alice, 2)
+	tr.Succeed(alice)
+	if failTimes(tr, alice, 2) {
+		t.Error("failures before successful authentication counted")
+	}
+	if !tr.Fail(alice) {
+		t.Error("not locked out after the limit")
+	}
+
+	if !tr.Clear(alice) {
+		t.Fatal("lockout not found")
+	}
+	if locked, _ := tr.Locked(alice); locked || len(tr.Lockouts()) != 0 {
+		t.Error("cleared lockout persists")
+	}
+	if tr.Clear(alice) {
+		t.Error("cleared twice")
+	}
+	if failTimes(tr, alice, 2) {
+		t.Error("failures before clearing counted")
+	}
+}
+
+func TestLockoutExpiry(t *testing.T) {
+	tr := &Tracker{MaxFailures: 2, Window: 30 * time.Millisecond, Duration: 30 * time.Millisecond}
+
+	// failures spread over more than the window do not add up
+	tr.Fail(alice)
+	time.Sleep(50 * time.Millisecond)
+	if tr.Fail(alice) {
+		t.Error("failure outside of the window counted")
+	}
+
+	if !tr.Fail(alice) {
+		t.Fatal("not locked out")
+	}
+	time.Sleep(50 * time.Millisecond)
+	if locked, _ := tr.Locked(alice); locked || len(tr.Lockouts()) != 0 {
+		t.Error("lockout did not expire")
+	}
+
+	// the counter starts over after the lockout
+	if tr.Fail(alice) {
+		t.Error("locked out again by the first failure after lockout")
+	}
+}
+
+func TestLockoutBounded(t *testing.T) {
+	tr := &Tracker{MaxFailures: 2, Window: time.Hour, Duration: time.Hour}
+	failTimes(tr, mallory, 2)
+
+	for i := 0; i < maxEntries+100; i++ {
+		tr.Fail(Key{ClientIP: fmt.Sprintf("10.0.%d.%d", i/256, i%256), User: "root"})
+	}
+
+	tr.mu.Lock()
+	n := len(tr.entries)
+	tr.mu.Unlock()
+	if n > maxEntries {
+		t.Errorf("tracking %d entries, want at most %d", n, maxEntries)
+	}
+	// unlocked entries are evicted first
+	if locked, _ := tr.Locked(mallory); !locked {
+		t.Error("lockout evicted while unlocked entries were tracked")
+	}
+}
+
+func TestLockoutIdleEvicted(t *testing.T) {
+	tr := &Tracker{MaxFailures: 5, Window: 20 * time.Millisecond, Duration: time.Hour}
+	tr.Fail(alice)
+	time.Sleep(40 * time.Millisecond)
+
+	// the next new key sweeps entries idle for the window
+	tr.Fail(mallory)
+
+	tr.mu.Lock()
+	_, tracked := tr.entries[alice]
+	tr.mu.Unlock()
+	if tracked {
+		t.Error("idle entry not evicted")
+	}
+}
@@ -0,0 +1,27 @@
+package logger
+
+import (
+	"context"
+	"log/slog"
+)
+
+type contextAttrsKey struct{}
+
+// ContextWithAttrs returns context carrying attributes which are added to every record logged with that context.
+func ContextWithAttrs(ctx context.Context, attrs ...slog.Attr) context.Context {
+	prev := contextAttrs(ctx)
+	all := make([]slog.Attr, 0, len(prev)+len(attrs))
+	all = append(all, prev...)
+	all = append(all, attrs...)
+
+	return context.WithValue(ctx, contextAttrsKey{}, all)
+}
+
+func contextAttrs(ctx context.Context) []slog.Attr {
+	if ctx == nil {
+		return nil
+	}
+
+	attrs, _ := ctx.Value(contextAttrsKey{}).([]slog.Attr)
+	return attrs
+}
@@ -0,0 +1,56 @@
+package logger
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+)
+
+// fanoutHandler passes every record to all sinks which accept its level. Failure of one sink
+// does not prevent delivery to the others.
+type fanoutHandler struct {
+	sinks []slog.Handler
+}
+
+func (f *fanoutHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	for _, s := range f.sinks {
+		if s.Enabled(ctx, level) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (f *fanoutHandler) Handle(ctx context.Context, record slog.Record) error {
+	var errs []error
+	for _, s := range f.sinks {
+		if !s.Enabled(ctx, record.Level) {
+			continue
+		}
+
+		if err := s.Handle(ctx, record.Clone()); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (f *fanoutHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	sinks := make([]slog.Handler, len(f.sinks))
+	for i, s := range f.sinks {
+		sinks[i] = s.WithAttrs(attrs)
+	}
+
+	return &fanoutHandler{sinks: sinks}
+}
+
+func (f *fanoutHandler) WithGroup(name string) slog.Handler {
+	sinks := make([]slog.Handler, len(f.sinks))
+	for i, s := range f.sinks {
+		sinks[i] = s.WithGroup(name)
+	}
+
+	return &fanoutHandler{sinks: sinks}
+}
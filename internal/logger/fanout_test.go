@@ -0,0 +1,98 @@
+package logger
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+// failingWriter fails every write, like a sink whose disk is full.
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) {
+	return 0, errors.New("no space left on device")
+}
+
+// testFanout returns logger writing text records of info level and above to console and JSON records
+// of all levels to file, both through the custom handler.
+func testFanout() (*slog.Logger, *bytes.Buffer, *bytes.Buffer) {
+	var console, file bytes.Buffer
+	h := &fanoutHandler{sinks: []slog.Handler{
+		slog.NewTextHandler(&console, &slog.HandlerOptions{Level: slog.LevelInfo}),
+		slog.NewJSONHandler(&file, &slog.HandlerOptions{Level: slog.LevelDebug}),
+	}}
+
+	return slog.New(NewHandler(h, "/")), &console, &file
+}
+
+func TestFanoutLevels(t *testing.T) {
+	l, console, file := testFanout()
+
+	l.Debug("debug details")
+	l.Info("request served")
+
+	if strings.Contains(console.String(), "debug details") || !strings.Contains(console.String(), "request served") {
+		t.Errorf("got console output %q", console)
+	}
+	if !strings.Contains(file.String(), `"msg":"debug details"`) || !strings.Contains(file.String(), `"msg":"request served"`) {
+		t.Errorf("got file output %q", file)
+	}
+}
+
+func TestFanoutAttrs(t *testing.T) {
+	l, console, file := testFanout()
+
+	ctx := ContextWithAttrs(context.Background(), HTTPRequestID("r1"))
+	err := WithAttributes(errors.New("upstream error"), ErrClass("timeout"))
+	l.With("component", "proxy").ErrorContext(ctx, "failed", RPCMethod("torrent-get"), IgnoredAttr(err))
+
+	// context and error attributes are expanded for both sinks
+	for _, want := range []string{"component=proxy", "http.request_id=r1", "err.class=timeout", "rpc.method=torrent-get"} {
+		if !strings.Contains(console.String(), want) {
+			t.Errorf("console output %q lacks %s", console, want)
+		}
+	}
+	for _, want := range []string{`"component":"proxy"`, `"http":{"request_id":"r1"}`, `"err":{"class":"timeout"}`, `"rpc":{"method":"torrent-get"}`} {
+		if !strings.Contains(file.String(), want) {
+			t.Errorf("file output %q lacks %s", file, want)
+		}
+	}
+	for _, out := range []string{console.String(), file.String()} {
+		if strings.Contains(out, keyIgnore) || !strings.Contains(out, "fanout_test.go") {
+			t.Errorf("got output %q, want error attribute dropped and source trimmed", out)
+		}
+	}
+}
+
+func TestFanoutGroup(t *testing.T) {
+	l, console, file := testFanout()
+
+	l.WithGroup("upstream").With("host", "a:9091").Info("recovered", "failures", 3)
+
+	if !strings.Contains(console.String(), "upstream.host=a:9091 upstream.failures=3") {
+		t.Errorf("got console output %q", console)
+	}
+	if !strings.Contains(file.String(), `"upstream":{"host":"a:9091","failures":3`) {
+		t.Errorf("got file output %q", file)
+	}
+}
+
+func TestFanoutFailingSink(t *testing.T) {
+	var file bytes.Buffer
+	h := &fanoutHandler{sinks: []slog.Handler{
+		slog.NewTextHandler(failingWriter{}, nil),
+		slog.NewJSONHandler(&file, nil),
+	}}
+
+	err := NewHandler(h, "/").Handle(context.Background(), slog.NewRecord(time.Now(), slog.LevelInfo, "still logged", 0))
+	if err == nil || !strings.Contains(err.Error(), "no space left") {
+		t.Errorf("got error %v, want the error of failing sink", err)
+	}
+	if !strings.Contains(file.String(), "still logged") {
+		t.Errorf("record not delivered to working sink: %q", &file)
+	}
+}
@@ -29,6 +29,10 @@ func (e *errWithAttr) Error() string {
 	return e.err.Error()
 }
 
+func (e *errWithAttr) Unwrap() error {
+	return e.err
+}
+
 func (e *errWithAttr) GetLoggableAttrs() []slog.Attr {
 	return e.attrs
 }
@@ -58,12 +62,34 @@ func getEnvOrDefault(key, default_ string) string {
 }
 
 var (
-	logFormat = getEnvOrDefault("LOG_FORMAT", "json")
+	logFormat       = getEnvOrDefault("LOG_FORMAT", "json")
+	logFile         = os.Getenv("LOG_FILE")
+	consoleLogLevel = os.Getenv("CONSOLE_LOG_LEVEL")
+	fileLogLevel    = os.Getenv("FILE_LOG_LEVEL")
 )
 
+func parseLevel(name, val string, default_ slog.Leveler) slog.Leveler {
+	if val == "" {
+		return default_
+	}
+
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(val)); err != nil {
+		slog.Error(name + " must be one of debug, info, warn, error")
+		os.Exit(1)
+	}
+
+	return lvl
+}
+
+// SetupSLog configures the default logger. Records go to stderr in LOG_FORMAT and, if LOG_FILE
+// is set, additionally to that file as JSON; each sink may have its own fixed level, otherwise
+// it follows the global level which can be changed at runtime with SetLevel.
 func SetupSLog(lvl slog.Level, rootPath string) {
+	SetLevel(lvl, 0)
+
 	ho := slog.HandlerOptions{
-		Level: lvl,
+		Level: parseLevel("CONSOLE_LOG_LEVEL", consoleLogLevel, level),
 	}
 
 	var h slog.Handler
@@ -79,16 +105,35 @@ func SetupSLog(lvl slog.Level, rootPath string) {
 		os.Exit(1)
 	}
 
+	if logFile != "" {
+		f, err := os.OpenFile(logFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+		if err != nil {
+			slog.Error("failed to open LOG_FILE: "+err.Error(), IgnoredAttr(err))
+			os.Exit(1)
+		}
+
+		fh := slog.NewJSONHandler(f, &slog.HandlerOptions{
+			Level: parseLevel("FILE_LOG_LEVEL", fileLogLevel, level),
+		})
+		h = &fanoutHandler{sinks: []slog.Handler{h, fh}}
+	}
+
+	slog.SetDefault(slog.New(NewHandler(h, rootPath)))
+}
+
+// NewHandler wraps the handler so that it adds the context attributes (see ContextWithAttrs) and the attributes
+// of logged errors (see HasLoggableAttrs), merges groups and trims source paths to be relative to rootPath.
+func NewHandler(h slog.Handler, rootPath string) slog.Handler {
 	gopath := os.Getenv("GOPATH")
 	if gopath == "" {
 		gopath = build.Default.GOPATH
 	}
 
-	slog.SetDefault(slog.New(&handler{
+	return &handler{
 		baseHandler: h,
 		rootPath:    strings.TrimSuffix(rootPath, "/") + "/",
 		goPath:      strings.TrimSuffix(gopath, "/") + "/",
-	}))
+	}
 }
 
 type handler struct {
@@ -109,6 +154,8 @@ func (e *handler) Handle(ctx context.Context, record slog.Record) error {
 		PC:      record.PC,
 	}
 
+	attrs := append([]slog.Attr{}, contextAttrs(ctx)...)
+
 	record.Attrs(func(attr slog.Attr) bool {
 		ha, ok := attr.Value.Any().(HasLoggableAttrs)
 		if !ok {
@@ -119,16 +166,18 @@ func (e *handler) Handle(ctx context.Context, record slog.Record) error {
 		}
 
 		if ok {
-			newRecord.AddAttrs(ha.GetLoggableAttrs()...)
+			attrs = append(attrs, ha.GetLoggableAttrs()...)
 		}
 
 		if attr.Key != keyIgnore {
-			newRecord.AddAttrs(attr)
+			attrs = append(attrs, attr)
 		}
 
 		return true
 	})
 
+	newRecord.AddAttrs(mergeGroups(attrs)...)
+
 	record = newRecord
 
 	fs := runtime.CallersFrames([]uintptr{record.PC})
@@ -152,6 +201,7 @@ func (e *handler) WithAttrs(attrs []slog.Attr) slog.Handler {
 	return &handler{
 		baseHandler: e.baseHandler.WithAttrs(attrs),
 		rootPath:    e.rootPath,
+		goPath:      e.goPath,
 	}
 }
 
@@ -159,5 +209,6 @@ func (e *handler) WithGroup(name string) slog.Handler {
 	return &handler{
 		baseHandler: e.baseHandler.WithGroup(name),
 		rootPath:    e.rootPath,
+		goPath:      e.goPath,
 	}
 }
@@ -0,0 +1,104 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestWithAttributesWrapped(t *testing.T) {
+	if WithAttributes(nil, ErrClass("timeout")) != nil {
+		t.Error("got error for nil")
+	}
+
+	base := errors.New("connection refused")
+	inner := WithAttributes(base, ErrClass("network"))
+	// the attributes of wrapped errors are kept, before the own ones
+	err := WithAttributes(fmt.Errorf("forward: %w", inner), RPCMethod("torrent-get"))
+
+	var ha HasLoggableAttrs
+	if !errors.As(err, &ha) {
+		t.Fatal("attributes not found")
+	}
+	if got := fmt.Sprint(ha.GetLoggableAttrs()); got != "[err=[class=network] rpc=[method=torrent-get]]" {
+		t.Errorf("got attributes %s", got)
+	}
+	if err.Error() != "forward: connection refused" || !errors.Is(err, base) {
+		t.Errorf("got error %v not wrapping the original one", err)
+	}
+}
+
+// logJSON logs error with the attributes through the handler and returns the record.
+func logJSON(t *testing.T, attrs ...any) map[string]any {
+	t.Helper()
+
+	var buf bytes.Buffer
+	slog.New(NewHandler(slog.NewJSONHandler(&buf, nil), "/")).Error("failed", attrs...)
+
+	var rec map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &rec); err != nil {
+		t.Fatalf("bad record %s: %v", &buf, err)
+	}
+
+	return rec
+}
+
+func TestHandlerErrorAttrs(t *testing.T) {
+	err := fmt.Errorf("request: %w", WithAttributes(errors.New("timeout"), ErrClass("timeout"), RPCTag(3)))
+
+	cases := []struct {
+		name string
+		attr any
+		// want is the error member of the record, nil if dropped
+		want any
+	}{
+		{name: "logged", attr: slog.Any("error", err), want: "request: timeout"},
+		{name: "ignored", attr: IgnoredAttr(err)},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := logJSON(t, tc.attr)
+			errGroup, _ := rec[GroupErr].(map[string]any)
+			rpcGroup, _ := rec[GroupRPC].(map[string]any)
+			if errGroup[KeyClass] != "timeout" || rpcGroup[KeyTag] != float64(3) {
+				t.Errorf("got record %v, want attributes of wrapped error", rec)
+			}
+			if rec["error"] != tc.want || rec[keyIgnore] != nil {
+				t.Errorf("got record %v", rec)
+			}
+		})
+	}
+}
+
+func TestHandlerDerivedSource(t *testing.T) {
+	_, file, _, _ := runtime.Caller(0)
+	// the sources are outside of the root path, like those of dependencies
+	t.Setenv("GOPATH", filepath.Dir(file))
+
+	cases := []struct {
+		name string
+		l    func(*slog.Logger) *slog.Logger
+	}{
+		{name: "root", l: func(l *slog.Logger) *slog.Logger { return l }},
+		{name: "with attributes", l: func(l *slog.Logger) *slog.Logger { return l.With("component", "proxy") }},
+		{name: "with group", l: func(l *slog.Logger) *slog.Logger { return l.WithGroup("upstream") }},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			h := NewHandler(slog.NewJSONHandler(&buf, nil), "/nonexistent")
+			tc.l(slog.New(h)).Info("served")
+
+			// the source of the records of the group is in the group
+			if want := `"file":"` + filepath.Base(file) + `"`; !strings.Contains(buf.String(), want) {
+				t.Errorf("got record %s, want source %s", &buf, want)
+			}
+		})
+	}
+}
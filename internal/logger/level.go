@@ -0,0 +1,71 @@
+package logger
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+var (
+	level = new(slog.LevelVar)
+
+	levelMu    sync.Mutex
+	baseLevel  slog.Level
+	revertTmr  *time.Timer
+	revertTime time.Time
+)
+
+// Level returns the current global log level.
+func Level() slog.Level {
+	return level.Level()
+}
+
+// SetLevel changes the global log level. With non-zero duration the change is temporary and the level
+// reverts to the last permanently set one after the duration; otherwise the change is permanent.
+// Any pending reversion is cancelled. Returns the time of scheduled reversion, if any.
+func SetLevel(lvl slog.Level, duration time.Duration) time.Time {
+	levelMu.Lock()
+	defer levelMu.Unlock()
+
+	if revertTmr != nil {
+		revertTmr.Stop()
+		revertTmr = nil
+		revertTime = time.Time{}
+	}
+
+	level.Set(lvl)
+
+	if duration <= 0 {
+		baseLevel = lvl
+		return time.Time{}
+	}
+
+	var tmr *time.Timer
+	tmr = time.AfterFunc(duration, func() {
+		levelMu.Lock()
+		defer levelMu.Unlock()
+
+		// a newer change has replaced this timer
+		if revertTmr != tmr {
+			return
+		}
+
+		revertTmr = nil
+		revertTime = time.Time{}
+		level.Set(baseLevel)
+		slog.Log(context.Background(), baseLevel, "log level reverted", slog.String("level", baseLevel.String()))
+	})
+	revertTmr = tmr
+	revertTime = time.Now().Add(duration)
+
+	return revertTime
+}
+
+// LevelRevertTime returns time when temporary log level will be reverted, or zero time if no reversion is pending.
+func LevelRevertTime() time.Time {
+	levelMu.Lock()
+	defer levelMu.Unlock()
+
+	return revertTime
+}
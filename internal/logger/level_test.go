@@ -0,0 +1,89 @@
+package logger
+
+import (
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+)
+
+// keepLevel restores the global level after the test.
+func keepLevel(t *testing.T) {
+	prev := Level()
+	t.Cleanup(func() { SetLevel(prev, 0) })
+}
+
+func TestSetLevelRevert(t *testing.T) {
+	keepLevel(t)
+	captureLog(t)
+
+	SetLevel(slog.LevelInfo, 0)
+	if at := SetLevel(slog.LevelDebug, 50*time.Millisecond); at.IsZero() {
+		t.Error("no reversion scheduled")
+	}
+	if Level() != slog.LevelDebug || LevelRevertTime().IsZero() {
+		t.Fatalf("got level %v, reversion at %v", Level(), LevelRevertTime())
+	}
+
+	waitFor(t, "reversion", func() bool { return Level() == slog.LevelInfo })
+	if !LevelRevertTime().IsZero() {
+		t.Errorf("reversion still pending at %v", LevelRevertTime())
+	}
+}
+
+func TestSetLevelCancelsRevert(t *testing.T) {
+	keepLevel(t)
+	captureLog(t)
+
+	SetLevel(slog.LevelInfo, 0)
+	SetLevel(slog.LevelDebug, 20*time.Millisecond)
+	// the permanent change cancels the pending reversion
+	if at := SetLevel(slog.LevelWarn, 0); !at.IsZero() {
+		t.Errorf("got reversion at %v for permanent change", at)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if Level() != slog.LevelWarn {
+		t.Errorf("got level %v, want the permanent one", Level())
+	}
+}
+
+func TestSetLevelExtendsRevert(t *testing.T) {
+	keepLevel(t)
+	captureLog(t)
+
+	SetLevel(slog.LevelInfo, 0)
+	SetLevel(slog.LevelDebug, 20*time.Millisecond)
+	SetLevel(slog.LevelDebug, time.Hour)
+
+	// the timer of the first change must not revert the second one
+	time.Sleep(60 * time.Millisecond)
+	if Level() != slog.LevelDebug {
+		t.Errorf("got level %v, want debug until the second change expires", Level())
+	}
+}
+
+func TestSetLevelConcurrent(t *testing.T) {
+	keepLevel(t)
+	captureLog(t)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				SetLevel(slog.LevelDebug, time.Millisecond)
+				SetLevel(slog.LevelWarn, 0)
+				_ = LevelRevertTime()
+			}
+		}()
+	}
+	wg.Wait()
+
+	SetLevel(slog.LevelError, 10*time.Millisecond)
+	waitFor(t, "reversion", func() bool { return Level() == slog.LevelWarn })
+	if !LevelRevertTime().IsZero() {
+		t.Errorf("reversion still pending at %v", LevelRevertTime())
+	}
+}
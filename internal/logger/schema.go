@@ -0,0 +1,195 @@
+package logger
+
+import (
+	"log/slog"
+	"time"
+)
+
+// Structured log schema. Attributes are grouped so that log pipelines can rely on stable names:
+//
+//	rpc.method          RPC method name
+//	rpc.tag             RPC request tag
+//	rpc.field           RPC argument the record refers to
+//	rpc.fields          RPC arguments the record refers to, when there are several
+//	rpc.rule            dependency rule between RPC arguments which was violated
+//	rpc.reject_reason   why the RPC request was rejected (see transmission.RejectReason)
+//	rpc.rejected_body   captured body of the rejected request (debug mode only)
+//	rpc.request_body    body of the request forwarded upstream (debug mode only)
+//	rpc.response_body   body of the response to the forwarded request (debug mode only)
+//	rpc.value           value of the argument which was rejected, where it is short enough to be logged
+//	rpc.torrent_name    name of the torrent in rejected torrent-add metainfo
+//	rpc.torrent_size    total size of the torrent in rejected torrent-add metainfo
+//	rpc.batch_index     index of the request in the rejected batch (see BATCH_REQUESTS)
+//	rpc.authz_reason    reason given by the external authorization service for the denial
+//	rpc.policy          policy which rejected the request (see policy.Violation)
+//	http.method         HTTP method of the request
+//	http.request_path   URL path of the request
+//	http.request_id     ID of the request, also sent in X-Request-Id header
+//	http.status         status of the response sent to the client
+//	http.duration_ms    time spent handling the request
+//	http.bytes_out      size of the response body sent to the client
+//	http.content_type   Content-Type of the rejected request
+//	http.upstream_status status of the upstream response
+//	http.upstream       upstream host the request was sent to
+//	http.failed_upstream upstream host which failed the request, when failing over to another one
+//	http.client_ip      resolved client address
+//	http.user           authenticated user
+//	http.path           RPC endpoint the request arrived at, when there are several (see RPC_PATH)
+//	http.impersonated_user user the admin acts as (see X-Proxy-Impersonate)
+//	http.authz_url      external authorization service which failed (see EXTERNAL_AUTHZ_URL)
+//	err.id              error ID reported to the client
+//	err.class           class of the upstream error (see upstream.Classify)
+//
+// Several attributes of the same group in one record are merged into single group by the handler.
+const (
+	GroupRPC  = "rpc"
+	GroupHTTP = "http"
+	GroupErr  = "err"
+
+	KeyMethod         = "method"
+	KeyTag            = "tag"
+	KeyField          = "field"
+	KeyFields         = "fields"
+	KeyRule           = "rule"
+	KeyRejectReason   = "reject_reason"
+	KeyRejectedBody   = "rejected_body"
+	KeyRequestBody    = "request_body"
+	KeyResponseBody   = "response_body"
+	KeyValue          = "value"
+	KeyTorrentName    = "torrent_name"
+	KeyTorrentSize    = "torrent_size"
+	KeyBatchIndex     = "batch_index"
+	KeyAuthzReason    = "authz_reason"
+	KeyPolicy         = "policy"
+	KeyRequestPath    = "request_path"
+	KeyRequestID      = "request_id"
+	KeyStatus         = "status"
+	KeyDurationMs     = "duration_ms"
+	KeyBytesOut       = "bytes_out"
+	KeyContentType    = "content_type"
+	KeyUpstreamStatus = "upstream_status"
+	KeyUpstream       = "upstream"
+	KeyFailedUpstream = "failed_upstream"
+	KeyClientIP       = "client_ip"
+	KeyUser           = "user"
+	KeyPath           = "path"
+	KeyImpersonated   = "impersonated_user"
+	KeyAuthzURL       = "authz_url"
+	KeyID             = "id"
+	KeyClass          = "class"
+)
+
+func RPC(attrs ...any) slog.Attr {
+	return slog.Group(GroupRPC, attrs...)
+}
+
+func HTTP(attrs ...any) slog.Attr {
+	return slog.Group(GroupHTTP, attrs...)
+}
+
+func Err(attrs ...any) slog.Attr {
+	return slog.Group(GroupErr, attrs...)
+}
+
+func RPCMethod(method string) slog.Attr {
+	return RPC(slog.String(KeyMethod, method))
+}
+
+func RPCTag(tag int) slog.Attr {
+	return RPC(slog.Int(KeyTag, tag))
+}
+
+func RPCField(field string) slog.Attr {
+	return RPC(slog.String(KeyField, field))
+}
+
+func RPCBatchIndex(i int) slog.Attr {
+	return RPC(slog.Int(KeyBatchIndex, i))
+}
+
+func RPCRejectReason(reason string) slog.Attr {
+	return RPC(slog.String(KeyRejectReason, reason))
+}
+
+func HTTPMethod(method string) slog.Attr {
+	return HTTP(slog.String(KeyMethod, method))
+}
+
+func HTTPRequestPath(path string) slog.Attr {
+	return HTTP(slog.String(KeyRequestPath, path))
+}
+
+func HTTPRequestID(id string) slog.Attr {
+	return HTTP(slog.String(KeyRequestID, id))
+}
+
+func HTTPStatus(status int) slog.Attr {
+	return HTTP(slog.Int(KeyStatus, status))
+}
+
+func HTTPDuration(d time.Duration) slog.Attr {
+	return HTTP(slog.Float64(KeyDurationMs, float64(d)/float64(time.Millisecond)))
+}
+
+func HTTPContentType(ct string) slog.Attr {
+	return HTTP(slog.String(KeyContentType, ct))
+}
+
+func HTTPUpstream(host string) slog.Attr {
+	return HTTP(slog.String(KeyUpstream, host))
+}
+
+func HTTPFailedUpstream(host string) slog.Attr {
+	return HTTP(slog.String(KeyFailedUpstream, host))
+}
+
+func HTTPClientIP(ip string) slog.Attr {
+	return HTTP(slog.String(KeyClientIP, ip))
+}
+
+func HTTPUser(user string) slog.Attr {
+	return HTTP(slog.String(KeyUser, user))
+}
+
+func HTTPPath(path string) slog.Attr {
+	return HTTP(slog.String(KeyPath, path))
+}
+
+func HTTPImpersonatedUser(user string) slog.Attr {
+	return HTTP(slog.String(KeyImpersonated, user))
+}
+
+func ErrID(id string) slog.Attr {
+	return Err(slog.String(KeyID, id))
+}
+
+func ErrClass(class string) slog.Attr {
+	return Err(slog.String(KeyClass, class))
+}
+
+// mergeGroups combines top-level group attributes sharing the same key, keeping position of the first one,
+// so that e.g. two separate "rpc" groups are rendered as one object instead of duplicate keys.
+func mergeGroups(attrs []slog.Attr) []slog.Attr {
+	res := make([]slog.Attr, 0, len(attrs))
+	groups := map[string]int{}
+
+	for _, a := range attrs {
+		if a.Value.Kind() != slog.KindGroup || a.Key == "" {
+			res = append(res, a)
+			continue
+		}
+
+		if i, ok := groups[a.Key]; ok {
+			prev := res[i].Value.Group()
+			merged := make([]slog.Attr, 0, len(prev)+len(a.Value.Group()))
+			merged = append(append(merged, prev...), a.Value.Group()...)
+			res[i] = slog.Attr{Key: a.Key, Value: slog.GroupValue(merged...)}
+			continue
+		}
+
+		groups[a.Key] = len(res)
+		res = append(res, a)
+	}
+
+	return res
+}
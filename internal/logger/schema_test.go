@@ -0,0 +1,96 @@
+package logger
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSchemaGroupsJSON(t *testing.T) {
+	var buf bytes.Buffer
+	l := slog.New(NewHandler(slog.NewJSONHandler(&buf, nil), "/"))
+
+	ctx := ContextWithAttrs(context.Background(), HTTPRequestID("r1"), HTTPClientIP("192.0.2.7"))
+	err := WithAttributes(errors.New("bad download-dir"), RPCField("download-dir"), RPCRejectReason("forbidden_location"))
+	l.WarnContext(ctx, "invalid RPC request", RPCMethod("torrent-add"), RPCTag(5), HTTPStatus(400),
+		HTTPDuration(1500*time.Microsecond), ErrID("e1"), IgnoredAttr(err))
+
+	var rec map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &rec); err != nil {
+		t.Fatalf("bad record %s: %v", &buf, err)
+	}
+
+	want := map[string]map[string]any{
+		GroupRPC: {
+			KeyMethod:       "torrent-add",
+			KeyTag:          float64(5),
+			KeyField:        "download-dir",
+			KeyRejectReason: "forbidden_location",
+		},
+		GroupHTTP: {
+			KeyRequestID:  "r1",
+			KeyClientIP:   "192.0.2.7",
+			KeyStatus:     float64(400),
+			KeyDurationMs: 1.5,
+		},
+		GroupErr: {
+			KeyID: "e1",
+		},
+	}
+	for group, attrs := range want {
+		got, ok := rec[group].(map[string]any)
+		if !ok {
+			t.Fatalf("no %s group in %s", group, &buf)
+		}
+		if len(got) != len(attrs) {
+			t.Errorf("got %s group %v, want %v", group, got, attrs)
+		}
+		for k, v := range attrs {
+			if got[k] != v {
+				t.Errorf("got %s.%s = %v, want %v", group, k, got[k], v)
+			}
+		}
+	}
+
+	// the groups are merged rather than repeated
+	for _, group := range []string{GroupRPC, GroupHTTP, GroupErr} {
+		if n := strings.Count(buf.String(), `"`+group+`":`); n != 1 {
+			t.Errorf("%s group rendered %d times: %s", group, n, &buf)
+		}
+	}
+}
+
+func TestSchemaGroupsText(t *testing.T) {
+	var buf bytes.Buffer
+	l := slog.New(NewHandler(slog.NewTextHandler(&buf, nil), "/"))
+
+	l.Info("RPC request completed", RPCMethod("torrent-get"), HTTPStatus(200), RPCTag(3), HTTPUpstream("a:9091"))
+
+	for _, want := range []string{"rpc.method=torrent-get", "rpc.tag=3", "http.status=200", "http.upstream=a:9091"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("record %q lacks %s", &buf, want)
+		}
+	}
+}
+
+func TestMergeGroups(t *testing.T) {
+	attrs := mergeGroups([]slog.Attr{
+		RPCMethod("torrent-get"),
+		slog.String("msg_id", "x"),
+		HTTPStatus(200),
+		RPCTag(1),
+		slog.Group("", slog.String("inline", "y")),
+	})
+
+	if len(attrs) != 4 || attrs[0].Key != GroupRPC || attrs[1].Key != "msg_id" || attrs[2].Key != GroupHTTP || attrs[3].Key != "" {
+		t.Fatalf("got %v", attrs)
+	}
+	if rpc := attrs[0].Value.Group(); len(rpc) != 2 || rpc[0].Key != KeyMethod || rpc[1].Key != KeyTag {
+		t.Errorf("got rpc group %v", rpc)
+	}
+}
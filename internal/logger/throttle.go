@@ -0,0 +1,112 @@
+package logger
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Throttle limits the volume of repeated identical log records: the first occurrence of an event
+// is logged at the requested level, the rest are demoted to debug while the event keeps repeating.
+// Every window a summary of the records suppressed meanwhile is emitted; an event which did not repeat
+// for a whole window is forgotten, so that its next occurrence is logged at the requested level again.
+type Throttle struct {
+	window time.Duration
+
+	mu      sync.Mutex
+	events  map[string]*throttleState
+	ticking bool
+}
+
+type throttleState struct {
+	start      time.Time
+	lvl        slog.Level
+	suppressed int
+}
+
+func NewThrottle(window time.Duration) *Throttle {
+	return &Throttle{window: window, events: map[string]*throttleState{}}
+}
+
+// Level returns the level the current occurrence of event identified by key should be logged at.
+func (t *Throttle) Level(ctx context.Context, key string, lvl slog.Level) slog.Level {
+	now := time.Now()
+
+	t.mu.Lock()
+	st, ok := t.events[key]
+	if ok && now.Sub(st.start) < t.window {
+		st.suppressed++
+		t.mu.Unlock()
+		return slog.LevelDebug
+	}
+
+	// the summary is normally emitted by the ticker, unless the event repeats before it ticks
+	var suppressed int
+	var since time.Duration
+	if ok {
+		suppressed = st.suppressed
+		since = now.Sub(st.start)
+	}
+	t.events[key] = &throttleState{start: now, lvl: lvl}
+	if !t.ticking {
+		t.ticking = true
+		go t.tick()
+	}
+	t.mu.Unlock()
+
+	logSuppressed(ctx, key, lvl, suppressed, since)
+
+	return lvl
+}
+
+// tick emits the summaries every window until there are no events left to watch.
+func (t *Throttle) tick() {
+	ticker := time.NewTicker(t.window)
+	defer ticker.Stop()
+
+	type summary struct {
+		key        string
+		lvl        slog.Level
+		suppressed int
+		since      time.Duration
+	}
+
+	for now := range ticker.C {
+		var summaries []summary
+
+		t.mu.Lock()
+		for key, st := range t.events {
+			switch {
+			case st.suppressed > 0:
+				summaries = append(summaries, summary{key: key, lvl: st.lvl, suppressed: st.suppressed, since: now.Sub(st.start)})
+				// the event persists, its next occurrences are suppressed for another window
+				st.start, st.suppressed = now, 0
+			case now.Sub(st.start) >= t.window:
+				delete(t.events, key)
+			}
+		}
+		done := len(t.events) == 0
+		if done {
+			t.ticking = false
+		}
+		t.mu.Unlock()
+
+		for _, s := range summaries {
+			logSuppressed(context.Background(), s.key, s.lvl, s.suppressed, s.since)
+		}
+		if done {
+			return
+		}
+	}
+}
+
+func logSuppressed(ctx context.Context, key string, lvl slog.Level, suppressed int, since time.Duration) {
+	if suppressed == 0 {
+		return
+	}
+
+	slog.Log(ctx, lvl, fmt.Sprintf("suppressed %d identical %s in the last %s", suppressed, key, since.Round(time.Second)),
+		slog.String("event", key), slog.Int("suppressed", suppressed))
+}
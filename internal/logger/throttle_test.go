@@ -0,0 +1,95 @@
+package logger
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// syncBuffer is written by the ticker of the throttle while the test reads it.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func captureLog(t *testing.T) *syncBuffer {
+	buf := &syncBuffer{}
+	prev := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(buf, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	t.Cleanup(func() { slog.SetDefault(prev) })
+
+	return buf
+}
+
+// waitFor polls until the condition holds, failing the test after a second.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestThrottlePeriodicSummary(t *testing.T) {
+	logs := captureLog(t)
+	const window = 100 * time.Millisecond
+	th := NewThrottle(window)
+	ctx := context.Background()
+	const key = "upstream connection_refused errors"
+
+	if lvl := th.Level(ctx, key, slog.LevelError); lvl != slog.LevelError {
+		t.Errorf("first occurrence logged at %v", lvl)
+	}
+	for i := 0; i < 3; i++ {
+		if lvl := th.Level(ctx, key, slog.LevelError); lvl != slog.LevelDebug {
+			t.Errorf("repeated occurrence logged at %v", lvl)
+		}
+	}
+	if lvl := th.Level(ctx, "upstream timeout errors", slog.LevelError); lvl != slog.LevelError {
+		t.Errorf("other event logged at %v", lvl)
+	}
+
+	// the summary comes without waiting for the next occurrence
+	waitFor(t, "summary", func() bool {
+		return strings.Contains(logs.String(), "level=ERROR msg=\"suppressed 3 identical "+key+" in the last")
+	})
+	if strings.Contains(logs.String(), "timeout errors in the last") {
+		t.Errorf("event which was not repeated was summarized:\n%s", logs)
+	}
+
+	// the errors persist
+	if lvl := th.Level(ctx, key, slog.LevelError); lvl != slog.LevelDebug {
+		t.Errorf("occurrence after the summary logged at %v", lvl)
+	}
+	waitFor(t, "second summary", func() bool {
+		return strings.Contains(logs.String(), "suppressed 1 identical "+key)
+	})
+
+	// the errors stopped, the events are forgotten and the ticker stops
+	waitFor(t, "ticker to stop", func() bool {
+		th.mu.Lock()
+		defer th.mu.Unlock()
+		return !th.ticking
+	})
+	if lvl := th.Level(ctx, key, slog.LevelError); lvl != slog.LevelError {
+		t.Errorf("occurrence after the errors stopped logged at %v", lvl)
+	}
+}
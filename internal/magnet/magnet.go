@@ -0,0 +1,97 @@
+package magnet
+
+import (
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+const btihPrefix = "urn:btih:"
+
+var (
+	ErrNotMagnet     = errors.New("not a magnet link")
+	ErrNoInfoHash    = errors.New("magnet link has no BitTorrent info hash (xt=urn:btih:...)")
+	ErrBadInfoHash   = errors.New("malformed info hash")
+	ErrTrackerDenied = errors.New("tracker is not allowed")
+)
+
+// Link is the parsed magnet link.
+type Link struct {
+	// Hash is the info hash in lowercase hex.
+	Hash     string
+	Name     string
+	Trackers []string
+}
+
+// Parse parses the magnet link, which must have BitTorrent info hash in hex or base32.
+func Parse(uri string) (*Link, error) {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "magnet" {
+		return nil, ErrNotMagnet
+	}
+
+	q, err := url.ParseQuery(u.RawQuery)
+	if err != nil {
+		return nil, ErrNotMagnet
+	}
+
+	l := &Link{Name: q.Get("dn"), Trackers: q["tr"]}
+	for _, xt := range q["xt"] {
+		if len(xt) < len(btihPrefix) || !strings.EqualFold(xt[:len(btihPrefix)], btihPrefix) {
+			continue
+		}
+
+		if l.Hash, err = parseHash(xt[len(btihPrefix):]); err != nil {
+			return nil, err
+		}
+		return l, nil
+	}
+
+	return nil, ErrNoInfoHash
+}
+
+func parseHash(s string) (string, error) {
+	switch len(s) {
+	case 40:
+		if _, err := hex.DecodeString(s); err != nil {
+			return "", ErrBadInfoHash
+		}
+		return strings.ToLower(s), nil
+	case 32:
+		bs, err := base32.StdEncoding.DecodeString(strings.ToUpper(s))
+		if err != nil {
+			return "", ErrBadInfoHash
+		}
+		return hex.EncodeToString(bs), nil
+	}
+
+	return "", ErrBadInfoHash
+}
+
+// DenyTrackers fails if the host of any tracker of the link is in the denylist.
+func (l *Link) DenyTrackers(denied []string) error {
+	for _, tr := range l.Trackers {
+		u, err := url.Parse(tr)
+		if err != nil || slices.Contains(denied, strings.ToLower(u.Hostname())) {
+			return fmt.Errorf("%w: %s", ErrTrackerDenied, tr)
+		}
+	}
+
+	return nil
+}
+
+// CheckTrackers fails unless the hosts of all trackers of the link are in the allowlist.
+func (l *Link) CheckTrackers(allowed []string) error {
+	for _, tr := range l.Trackers {
+		u, err := url.Parse(tr)
+		if err != nil || !slices.Contains(allowed, strings.ToLower(u.Hostname())) {
+			return fmt.Errorf("%w: %s", ErrTrackerDenied, tr)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,68 @@
+package magnet
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+const hash = "c9e15763f722f23e98a29decdfae341b98d53056"
+
+func TestParse(t *testing.T) {
+	l, err := Parse("magnet:?xt=urn:btih:" + hash + "&dn=Debian+12&tr=http://tracker.example/announce&tr=udp://open.example:1337")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l.Hash != hash || l.Name != "Debian 12" || !slices.Equal(l.Trackers, []string{"http://tracker.example/announce", "udp://open.example:1337"}) {
+		t.Errorf("got link %+v", l)
+	}
+
+	cases := []struct {
+		uri, hash string
+		err       error
+	}{
+		{uri: "magnet:?xt=urn:btih:C9E15763F722F23E98A29DECDFAE341B98D53056", hash: hash},
+		{uri: "magnet:?xt=URN:BTIH:" + hash, hash: hash},
+		// base32 form of the same hash
+		{uri: "magnet:?xt=urn:btih:ZHQVOY7XELZD5GFCTXWN7LRUDOMNKMCW", hash: hash},
+		{uri: "magnet:?xt=urn:btih:zhqvoy7xelzd5gfctxwn7lrudomnkmcw", hash: hash},
+		// other hashes are skipped
+		{uri: "magnet:?xt=urn:sha1:abc&xt=urn:btih:" + hash, hash: hash},
+		{uri: "http://example.com/?xt=urn:btih:" + hash, err: ErrNotMagnet},
+		{uri: "magnet:?xt=%zz", err: ErrNotMagnet},
+		{uri: "magnet:?dn=debian", err: ErrNoInfoHash},
+		{uri: "magnet:?xt=urn:sha1:abc", err: ErrNoInfoHash},
+		{uri: "magnet:?xt=urn:btih:" + hash[:39], err: ErrBadInfoHash},
+		{uri: "magnet:?xt=urn:btih:" + hash[:39] + "x", err: ErrBadInfoHash},
+		{uri: "magnet:?xt=urn:btih:ZHQVOY7XELZD5GFCTXWN7LRUDOMNKMC1", err: ErrBadInfoHash},
+	}
+	for _, tc := range cases {
+		l, err := Parse(tc.uri)
+		if !errors.Is(err, tc.err) || tc.err == nil && l.Hash != tc.hash {
+			t.Errorf("%s: got link %+v, error %v", tc.uri, l, err)
+		}
+	}
+}
+
+func TestTrackers(t *testing.T) {
+	l := &Link{Hash: hash, Trackers: []string{"http://Tracker.example/announce", "udp://open.example:1337"}}
+
+	if err := l.CheckTrackers([]string{"tracker.example", "open.example"}); err != nil {
+		t.Errorf("allowed trackers: %v", err)
+	}
+	if err := l.CheckTrackers([]string{"tracker.example"}); !errors.Is(err, ErrTrackerDenied) || err.Error() != "tracker is not allowed: udp://open.example:1337" {
+		t.Errorf("tracker not allowed: got error %v", err)
+	}
+	if err := l.DenyTrackers([]string{"other.example"}); err != nil {
+		t.Errorf("no denied trackers: %v", err)
+	}
+	if err := l.DenyTrackers([]string{"tracker.example"}); !errors.Is(err, ErrTrackerDenied) {
+		t.Errorf("denied tracker: got error %v", err)
+	}
+
+	// links without trackers pass either check
+	l.Trackers = nil
+	if err := l.CheckTrackers([]string{"tracker.example"}); err != nil {
+		t.Errorf("no trackers: %v", err)
+	}
+}
@@ -0,0 +1,107 @@
+package mirror
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net/http"
+	"slices"
+	"time"
+
+	"transmission-proxy/internal/jrpc"
+	"transmission-proxy/internal/stats"
+	"transmission-proxy/internal/transmission"
+	"transmission-proxy/internal/upstream"
+)
+
+// Label is the upstream label of the mirror statistics.
+const Label = "mirror"
+
+// workers is the number of requests sent to the mirror concurrently.
+const workers = 4
+
+// Mirror sends copies of sampled requests to a secondary upstream, e.g. to try a new Transmission version
+// with real traffic. Responses are discarded after recording their status and latency. The mirror never
+// delays or fails requests to the primary upstream: requests which do not fit the queue are dropped.
+type Mirror struct {
+	client     *upstream.Client
+	rate       float64
+	allMethods bool
+	stats      *stats.Registry
+	queue      chan *jrpc.Request
+	// sample returns the number in [0, 1) a request is sampled by.
+	sample func() float64
+}
+
+// New creates the mirror sending the sampled fraction (0..1) of requests to the upstream client.
+// Only read-only methods are mirrored unless allMethods is set.
+func New(client *upstream.Client, rate float64, allMethods bool, queueSize int, st *stats.Registry) *Mirror {
+	return &Mirror{
+		client:     client,
+		rate:       rate,
+		allMethods: allMethods,
+		stats:      st,
+		queue:      make(chan *jrpc.Request, queueSize),
+		sample:     rand.Float64,
+	}
+}
+
+// Submit queues the copy of the request if it is sampled.
+func (m *Mirror) Submit(req *jrpc.Request) {
+	if !m.allMethods && !slices.Contains(transmission.ReadOnlyMethods, req.Method) {
+		return
+	}
+	if m.sample() >= m.rate {
+		return
+	}
+
+	// only authorization is passed, the mirror negotiates its own session id
+	cp := *req
+	cp.Header = http.Header{}
+	if auth := req.Header.Get("Authorization"); auth != "" {
+		cp.Header.Set("Authorization", auth)
+	}
+
+	select {
+	case m.queue <- &cp:
+	default:
+		m.stats.RecordMirrorDrop()
+	}
+}
+
+// Run sends the queued requests until the context is done.
+func (m *Mirror) Run(ctx context.Context) {
+	for i := 0; i < workers; i++ {
+		go m.work(ctx)
+	}
+
+	<-ctx.Done()
+}
+
+func (m *Mirror) work(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case req := <-m.queue:
+			start := time.Now()
+			_, err := m.client.Call(ctx, req.Header, req)
+			m.stats.Upstream(Label).Observe(time.Since(start), classify(err))
+		}
+	}
+}
+
+// classify returns the error class of the mirrored call. Requests answered by Transmission count
+// as successful whatever their result.
+func classify(err error) string {
+	var se *upstream.StatusError
+	var re *upstream.ResultError
+	switch {
+	case errors.As(err, &re):
+		return ""
+	case errors.As(err, &se):
+		return upstream.ClassifyStatus(se.Status)
+	default:
+		return upstream.Classify(err)
+	}
+}
@@ -0,0 +1,180 @@
+package mirror
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"transmission-proxy/internal/jrpc"
+	"transmission-proxy/internal/stats"
+	"transmission-proxy/internal/upstream"
+)
+
+// fakeDaemon requires its own session id, answering with 409 until the request carries it, and records the
+// methods and Authorization of the requests it executed. While down it fails the requests.
+type fakeDaemon struct {
+	mu    sync.Mutex
+	calls []string
+	down  bool
+}
+
+func (d *fakeDaemon) RoundTrip(r *http.Request) (*http.Response, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.down {
+		return nil, errors.New("connection refused")
+	}
+
+	w := httptest.NewRecorder()
+	if r.Header.Get(upstream.SessionIDHeader) != "mirror-session" {
+		w.Header().Set(upstream.SessionIDHeader, "mirror-session")
+		w.WriteHeader(http.StatusConflict)
+		return w.Result(), nil
+	}
+
+	var req jrpc.Request
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, err
+	}
+	d.calls = append(d.calls, req.Method+" "+r.Header.Get("Authorization"))
+
+	_, _ = w.WriteString(`{"result":"success","arguments":{}}`)
+	return w.Result(), nil
+}
+
+func (d *fakeDaemon) executed() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return append([]string(nil), d.calls...)
+}
+
+// samples returns the sampler returning the values in turn.
+func samples(values ...float64) func() float64 {
+	return func() float64 {
+		v := values[0]
+		values = values[1:]
+		return v
+	}
+}
+
+func testMirror(d *fakeDaemon, rate float64, allMethods bool, queueSize int) (*Mirror, *stats.Registry) {
+	st := stats.NewRegistry()
+	uc := &upstream.Client{URL: "http://transmission4:9091/transmission/rpc", HTTP: &http.Client{Transport: d}}
+
+	return New(uc, rate, allMethods, queueSize, st), st
+}
+
+func request(method string) *jrpc.Request {
+	h := http.Header{}
+	h.Set("Authorization", "Basic YWxpY2U6c2VjcmV0")
+	h.Set(upstream.SessionIDHeader, "primary-session")
+	h.Set("Cookie", "session=abc")
+
+	return &jrpc.Request{Method: method, Header: h}
+}
+
+func TestSubmitSampling(t *testing.T) {
+	m, _ := testMirror(&fakeDaemon{}, 0.5, false, 10)
+	m.sample = samples(0.1, 0.5, 0.49, 0.9)
+
+	for i := 0; i < 4; i++ {
+		m.Submit(request("torrent-get"))
+	}
+	if len(m.queue) != 2 {
+		t.Errorf("got %d requests queued, want 2", len(m.queue))
+	}
+
+	// mutating methods are not mirrored by default, and not even sampled
+	m.Submit(request("torrent-add"))
+	m.Submit(request("torrent-remove"))
+	if len(m.queue) != 2 {
+		t.Errorf("got %d requests queued, want mutating methods excluded", len(m.queue))
+	}
+
+	m, _ = testMirror(&fakeDaemon{}, 1, true, 10)
+	m.Submit(request("torrent-add"))
+	if len(m.queue) != 1 {
+		t.Errorf("got %d requests queued, want mutating methods mirrored with allMethods", len(m.queue))
+	}
+
+	m, _ = testMirror(&fakeDaemon{}, 0, false, 10)
+	m.Submit(request("torrent-get"))
+	if len(m.queue) != 0 {
+		t.Errorf("got %d requests queued with zero rate", len(m.queue))
+	}
+}
+
+func TestSubmitQueueFull(t *testing.T) {
+	m, st := testMirror(&fakeDaemon{}, 1, false, 2)
+
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 5; i++ {
+			m.Submit(request("session-get"))
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("submitting blocked on the full queue")
+	}
+
+	if len(m.queue) != 2 || st.MirrorDrops() != 3 {
+		t.Errorf("got %d queued and %d dropped, want 2 and 3", len(m.queue), st.MirrorDrops())
+	}
+}
+
+func TestRun(t *testing.T) {
+	d := &fakeDaemon{}
+	m, st := testMirror(d, 1, false, 10)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// a single worker, so that the requests are sent in order
+	go m.work(ctx)
+
+	waitRequests := func(n uint64) stats.UpstreamSnapshot {
+		t.Helper()
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+			if s := st.Upstreams()[Label]; s.Requests >= n {
+				return s
+			}
+		}
+		t.Fatalf("got statistics %v, want %d mirror requests", st.Upstreams(), n)
+		return stats.UpstreamSnapshot{}
+	}
+
+	orig := request("torrent-get")
+	m.Submit(orig)
+	m.Submit(request("session-get"))
+	s := waitRequests(2)
+	if len(s.Errors) != 0 {
+		t.Errorf("got statistics %+v, want no errors", s)
+	}
+
+	d.mu.Lock()
+	d.down = true
+	d.mu.Unlock()
+	m.Submit(request("session-stats"))
+	s = waitRequests(3)
+	if n := s.Errors[upstream.Classify(errors.New("connection refused"))]; n != 1 {
+		t.Errorf("got statistics %+v, want the failure recorded", s)
+	}
+
+	// the session id is negotiated with the mirror, only authorization of the client is passed
+	want := []string{"torrent-get Basic YWxpY2U6c2VjcmV0", "session-get Basic YWxpY2U6c2VjcmV0"}
+	if got := d.executed(); len(got) != 2 || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("got calls %v, want %v", got, want)
+	}
+	if orig.Header.Get(upstream.SessionIDHeader) != "primary-session" {
+		t.Error("the original request was modified")
+	}
+}
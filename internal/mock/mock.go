@@ -0,0 +1,372 @@
+package mock
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"transmission-proxy/internal/jrpc"
+	"transmission-proxy/internal/upstream"
+)
+
+// Torrent statuses set by the fake.
+const (
+	statusStopped  = 0
+	statusDownload = 4
+)
+
+// defaultSettings are the session settings of the fake on the first start.
+var defaultSettings = map[string]any{
+	"download-dir":        "/downloads/",
+	"rpc-version":         17,
+	"rpc-version-minimum": 14,
+	"version":             "4.0.0 (mock)",
+	"speed-limit-down":    100,
+	"speed-limit-up":      100,
+	"alt-speed-enabled":   false,
+}
+
+// freeSpace is the size reported by free-space for any path.
+const freeSpace = 1 << 40
+
+// state is what the fake persists between restarts.
+type state struct {
+	NextID   int              `json:"next_id"`
+	Settings map[string]any   `json:"settings"`
+	Torrents []map[string]any `json:"torrents"`
+}
+
+// Server is an in-memory fake of Transmission RPC, implementing enough of it to test the proxy and its
+// clients: the session id handshake, session-get/set over the settings map and torrent-add/get/set/remove,
+// torrent-start/stop, torrent-set-location and free-space over the torrent table. Ids are assigned in order
+// and hashes derived from the added filename or metainfo, so that runs are repeatable.
+type Server struct {
+	// SessionID is the session id clients must present, negotiated with 409 responses.
+	SessionID string
+	// Latency delays every response.
+	Latency time.Duration
+	// StatePath is the JSON file the state is loaded from and saved to after every change, if set.
+	StatePath string
+
+	mu    sync.Mutex
+	state state
+}
+
+// New creates the fake, loading the state from statePath if the file exists.
+func New(sessionID string, latency time.Duration, statePath string) (*Server, error) {
+	s := &Server{SessionID: sessionID, Latency: latency, StatePath: statePath}
+	s.state = state{NextID: 1, Settings: map[string]any{}}
+	for k, v := range defaultSettings {
+		s.state.Settings[k] = v
+	}
+
+	if statePath == "" {
+		return s, nil
+	}
+
+	bs, err := os.ReadFile(statePath)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(bs, &s.state); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", statePath, err)
+	}
+
+	return s, nil
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.Latency > 0 {
+		time.Sleep(s.Latency)
+	}
+
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if r.Header.Get(upstream.SessionIDHeader) != s.SessionID {
+		w.Header().Set(upstream.SessionIDHeader, s.SessionID)
+		w.WriteHeader(http.StatusConflict)
+		_, _ = io.WriteString(w, "<h1>409: Conflict</h1>")
+		return
+	}
+
+	var req jrpc.Request
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	resp := s.Call(&req)
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+// Call executes the RPC request.
+func (s *Server) Call(req *jrpc.Request) *jrpc.Response {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	args := req.Arguments
+	if args == nil {
+		args = map[string]any{}
+	}
+
+	var res map[string]any
+	var err error
+	changed := true
+	switch req.Method {
+	case "session-get":
+		res, changed = s.sessionGet(args), false
+	case "session-set":
+		for k, v := range args {
+			s.state.Settings[k] = v
+		}
+	case "torrent-add":
+		res, err = s.torrentAdd(args)
+	case "torrent-get":
+		res, changed = s.torrentGet(args), false
+	case "torrent-set":
+		for _, t := range s.selectTorrents(args["ids"]) {
+			for k, v := range args {
+				if k != "ids" {
+					t[k] = v
+				}
+			}
+		}
+	case "torrent-set-location":
+		location, _ := args["location"].(string)
+		for _, t := range s.selectTorrents(args["ids"]) {
+			t["downloadDir"] = location
+		}
+	case "torrent-start", "torrent-start-now":
+		s.setStatus(args["ids"], statusDownload)
+	case "torrent-stop":
+		s.setStatus(args["ids"], statusStopped)
+	case "torrent-remove":
+		removed := s.selectTorrents(args["ids"])
+		s.state.Torrents = slices.DeleteFunc(s.state.Torrents, func(t map[string]any) bool {
+			return slices.ContainsFunc(removed, func(r map[string]any) bool { return r["id"] == t["id"] })
+		})
+	case "free-space":
+		res, changed = map[string]any{"path": args["path"], "size-bytes": freeSpace}, false
+	default:
+		err, changed = fmt.Errorf("method name not recognized"), false
+	}
+
+	if err != nil {
+		return &jrpc.Response{Result: err.Error(), Arguments: map[string]any{}, Tag: req.Tag, HasTag: req.HasTag}
+	}
+	if changed {
+		s.save()
+	}
+	if res == nil {
+		res = map[string]any{}
+	}
+
+	return &jrpc.Response{Result: jrpc.ResultSuccess, Arguments: res, Tag: req.Tag, HasTag: req.HasTag}
+}
+
+func (s *Server) sessionGet(args map[string]any) map[string]any {
+	fields := stringList(args["fields"])
+
+	res := map[string]any{}
+	for k, v := range s.state.Settings {
+		if fields == nil || slices.Contains(fields, k) {
+			res[k] = v
+		}
+	}
+
+	return res
+}
+
+func (s *Server) torrentAdd(args map[string]any) (map[string]any, error) {
+	source, _ := args["filename"].(string)
+	if source == "" {
+		source, _ = args["metainfo"].(string)
+	}
+	if source == "" {
+		return nil, fmt.Errorf("no filename or metainfo specified")
+	}
+
+	sum := sha1.Sum([]byte(source))
+	hash := hex.EncodeToString(sum[:])
+	for _, t := range s.state.Torrents {
+		if t["hashString"] == hash {
+			return map[string]any{"torrent-duplicate": brief(t)}, nil
+		}
+	}
+
+	dir, _ := args["download-dir"].(string)
+	if dir == "" {
+		dir, _ = s.state.Settings["download-dir"].(string)
+	}
+	status := statusDownload
+	if paused, _ := args["paused"].(bool); paused {
+		status = statusStopped
+	}
+	labels := args["labels"]
+	if labels == nil {
+		labels = []any{}
+	}
+
+	t := map[string]any{
+		"id":          s.state.NextID,
+		"name":        fmt.Sprintf("torrent-%d", s.state.NextID),
+		"hashString":  hash,
+		"downloadDir": dir,
+		"labels":      labels,
+		"status":      status,
+		"totalSize":   0,
+		"percentDone": 0,
+		"addedDate":   time.Now().Unix(),
+	}
+	s.state.NextID++
+	s.state.Torrents = append(s.state.Torrents, t)
+
+	return map[string]any{"torrent-added": brief(t)}, nil
+}
+
+func (s *Server) torrentGet(args map[string]any) map[string]any {
+	fields := stringList(args["fields"])
+	torrents := s.selectTorrents(args["ids"])
+
+	if args["format"] == "table" {
+		table := []any{toAny(fields)}
+		for _, t := range torrents {
+			row := make([]any, len(fields))
+			for i, f := range fields {
+				row[i] = t[f]
+			}
+			table = append(table, row)
+		}
+
+		return map[string]any{"torrents": table}
+	}
+
+	list := make([]any, 0, len(torrents))
+	for _, t := range torrents {
+		obj := map[string]any{}
+		for _, f := range fields {
+			if v, ok := t[f]; ok {
+				obj[f] = v
+			}
+		}
+		list = append(list, obj)
+	}
+
+	return map[string]any{"torrents": list}
+}
+
+func (s *Server) setStatus(ids any, status int) {
+	for _, t := range s.selectTorrents(ids) {
+		t["status"] = status
+	}
+}
+
+// selectTorrents returns the torrents referred to by ids: nil for all, a single id, or a list of ids and hashes.
+func (s *Server) selectTorrents(ids any) []map[string]any {
+	if ids == nil || ids == "recently-active" {
+		return slices.Clone(s.state.Torrents)
+	}
+
+	list, ok := ids.([]any)
+	if !ok {
+		list = []any{ids}
+	}
+
+	var res []map[string]any
+	for _, t := range s.state.Torrents {
+		for _, id := range list {
+			if matches(t, id) {
+				res = append(res, t)
+				break
+			}
+		}
+	}
+
+	return res
+}
+
+func (s *Server) save() {
+	if s.StatePath == "" {
+		return
+	}
+
+	bs, err := json.MarshalIndent(&s.state, "", "  ")
+	if err == nil {
+		err = os.WriteFile(s.StatePath, bs, 0o600)
+	}
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "failed to save state: %s\n", err)
+	}
+}
+
+// matches reports whether the torrent is referred to by the id, which is either a number or a hash.
+func matches(t map[string]any, id any) bool {
+	switch id := id.(type) {
+	case string:
+		return t["hashString"] == id
+	case float64:
+		return toFloat(t["id"]) == id
+	case json.Number:
+		n, err := id.Float64()
+		return err == nil && toFloat(t["id"]) == n
+	}
+
+	return false
+}
+
+// toFloat converts the id, which is int when assigned and float64 when loaded from the state file.
+func toFloat(v any) float64 {
+	switch v := v.(type) {
+	case int:
+		return float64(v)
+	case float64:
+		return v
+	}
+
+	return -1
+}
+
+// brief returns the fields of the torrent reported by torrent-add.
+func brief(t map[string]any) map[string]any {
+	return map[string]any{"id": t["id"], "name": t["name"], "hashString": t["hashString"]}
+}
+
+func stringList(v any) []string {
+	list, ok := v.([]any)
+	if !ok {
+		return nil
+	}
+
+	res := make([]string, 0, len(list))
+	for _, item := range list {
+		if s, ok := item.(string); ok {
+			res = append(res, s)
+		}
+	}
+
+	return res
+}
+
+func toAny(ss []string) []any {
+	res := make([]any, len(ss))
+	for i, s := range ss {
+		res[i] = s
+	}
+
+	return res
+}
@@ -0,0 +1,194 @@
+package mock
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"transmission-proxy/internal/jrpc"
+	"transmission-proxy/internal/upstream"
+)
+
+// call makes the RPC call to the fake, returning the arguments as JSON, or the result if it is not success.
+func call(t *testing.T, s *Server, method string, args string) string {
+	t.Helper()
+
+	var req jrpc.Request
+	if err := json.Unmarshal([]byte(`{"method":"`+method+`","arguments":`+args+`}`), &req); err != nil {
+		t.Fatal(err)
+	}
+
+	resp := s.Call(&req)
+	if resp.Result != jrpc.ResultSuccess {
+		return resp.Result
+	}
+
+	bs, err := json.Marshal(resp.Arguments)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(bs)
+}
+
+func testServer(t *testing.T, statePath string) *Server {
+	t.Helper()
+
+	s, err := New("mock-session", 0, statePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return s
+}
+
+func hashOf(source string) string {
+	sum := sha1.Sum([]byte(source))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestHandshake(t *testing.T) {
+	s := testServer(t, "")
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+
+	r, _ := http.NewRequest(http.MethodPost, srv.URL, strings.NewReader(`{"method":"session-get"}`))
+	resp, err := srv.Client().Do(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusConflict || resp.Header.Get(upstream.SessionIDHeader) != "mock-session" {
+		t.Errorf("without session id: got status %d, session id %q", resp.StatusCode, resp.Header.Get(upstream.SessionIDHeader))
+	}
+
+	resp, err = srv.Client().Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("GET: got status %d", resp.StatusCode)
+	}
+
+	// the client negotiates the session id and retries
+	uc := &upstream.Client{URL: srv.URL, HTTP: srv.Client()}
+	res, err := uc.Call(context.Background(), nil, &jrpc.Request{Method: "session-get", Arguments: map[string]any{"fields": []any{"version"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Arguments["version"] != "4.0.0 (mock)" || len(res.Arguments) != 1 {
+		t.Errorf("got arguments %v", res.Arguments)
+	}
+}
+
+func TestSession(t *testing.T) {
+	s := testServer(t, "")
+
+	if got := call(t, s, "session-set", `{"alt-speed-enabled":true,"speed-limit-down":50}`); got != `{}` {
+		t.Fatalf("session-set: got %s", got)
+	}
+	if got := call(t, s, "session-get", `{"fields":["alt-speed-enabled","speed-limit-down","speed-limit-up"]}`); got !=
+		`{"alt-speed-enabled":true,"speed-limit-down":50,"speed-limit-up":100}` {
+		t.Errorf("session-get: got %s", got)
+	}
+	if got := call(t, s, "free-space", `{"path":"/downloads"}`); got != `{"path":"/downloads","size-bytes":1099511627776}` {
+		t.Errorf("free-space: got %s", got)
+	}
+	if got := call(t, s, "blocklist-update", `{}`); got != "method name not recognized" {
+		t.Errorf("unknown method: got %s", got)
+	}
+}
+
+func TestTorrents(t *testing.T) {
+	s := testServer(t, "")
+
+	// ids are assigned in order and hashes derived from the source
+	want := `{"torrent-added":{"hashString":"` + hashOf("magnet:?xt=urn:btih:a") + `","id":1,"name":"torrent-1"}}`
+	if got := call(t, s, "torrent-add", `{"filename":"magnet:?xt=urn:btih:a"}`); got != want {
+		t.Errorf("torrent-add: got %s, want %s", got, want)
+	}
+	call(t, s, "torrent-add", `{"metainfo":"ZDg6YW5ub3VuY2U=","download-dir":"/downloads/tv","paused":true,"labels":["tv"]}`)
+	want = `{"torrent-duplicate":{"hashString":"` + hashOf("magnet:?xt=urn:btih:a") + `","id":1,"name":"torrent-1"}}`
+	if got := call(t, s, "torrent-add", `{"filename":"magnet:?xt=urn:btih:a"}`); got != want {
+		t.Errorf("duplicate: got %s, want %s", got, want)
+	}
+	if got := call(t, s, "torrent-add", `{}`); got != "no filename or metainfo specified" {
+		t.Errorf("no source: got %s", got)
+	}
+
+	fields := `"fields":["id","downloadDir","status","labels"]`
+	if got := call(t, s, "torrent-get", `{`+fields+`}`); got !=
+		`{"torrents":[{"downloadDir":"/downloads/","id":1,"labels":[],"status":4},{"downloadDir":"/downloads/tv","id":2,"labels":["tv"],"status":0}]}` {
+		t.Errorf("torrent-get: got %s", got)
+	}
+	if got := call(t, s, "torrent-get", `{"ids":[2],"format":"table","fields":["id","status"]}`); got != `{"torrents":[["id","status"],[2,0]]}` {
+		t.Errorf("table: got %s", got)
+	}
+
+	// torrents are referred to by ids and hashes
+	call(t, s, "torrent-start", `{"ids":2}`)
+	call(t, s, "torrent-stop", `{"ids":["`+hashOf("magnet:?xt=urn:btih:a")+`"]}`)
+	call(t, s, "torrent-set", `{"ids":[1],"labels":["movies"]}`)
+	call(t, s, "torrent-set-location", `{"ids":[2],"location":"/downloads/shows","move":true}`)
+	if got := call(t, s, "torrent-get", `{`+fields+`}`); got !=
+		`{"torrents":[{"downloadDir":"/downloads/","id":1,"labels":["movies"],"status":0},{"downloadDir":"/downloads/shows","id":2,"labels":["tv"],"status":4}]}` {
+		t.Errorf("after changes: got %s", got)
+	}
+
+	call(t, s, "torrent-remove", `{"ids":[1],"delete-local-data":true}`)
+	if got := call(t, s, "torrent-get", `{"fields":["id"]}`); got != `{"torrents":[{"id":2}]}` {
+		t.Errorf("after removal: got %s", got)
+	}
+	// ids are not reused
+	if got := call(t, s, "torrent-add", `{"filename":"magnet:?xt=urn:btih:c"}`); !strings.Contains(got, `"id":3`) {
+		t.Errorf("torrent-add after removal: got %s", got)
+	}
+}
+
+func TestPersistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+
+	s := testServer(t, path)
+	call(t, s, "torrent-add", `{"filename":"magnet:?xt=urn:btih:a"}`)
+	call(t, s, "torrent-add", `{"filename":"magnet:?xt=urn:btih:b"}`)
+	call(t, s, "session-set", `{"speed-limit-up":10}`)
+
+	// the restarted fake continues where it stopped
+	s = testServer(t, path)
+	call(t, s, "torrent-stop", `{"ids":[2]}`)
+	if got := call(t, s, "torrent-get", `{"fields":["id","status"]}`); got != `{"torrents":[{"id":1,"status":4},{"id":2,"status":0}]}` {
+		t.Errorf("torrent-get: got %s", got)
+	}
+	if got := call(t, s, "torrent-add", `{"filename":"magnet:?xt=urn:btih:c"}`); !strings.Contains(got, `"id":3`) {
+		t.Errorf("torrent-add: got %s", got)
+	}
+	if got := call(t, s, "session-get", `{"fields":["speed-limit-up"]}`); got != `{"speed-limit-up":10}` {
+		t.Errorf("session-get: got %s", got)
+	}
+
+	if _, err := New("mock-session", 0, filepath.Join(t.TempDir(), "missing.json")); err != nil {
+		t.Errorf("missing state file: %v", err)
+	}
+}
+
+func TestLatency(t *testing.T) {
+	s, err := New("mock-session", 30*time.Millisecond, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/transmission/rpc", strings.NewReader(`{"method":"session-get"}`)))
+	if d := time.Since(start); d < 30*time.Millisecond || w.Code != http.StatusConflict {
+		t.Errorf("got status %d in %s", w.Code, d)
+	}
+}
@@ -0,0 +1,127 @@
+package ownership
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Sources of the ownership records.
+const (
+	SourceAdd = "torrent-add"
+)
+
+// Unknown torrents (present upstream but not in the store) policies.
+const (
+	// UnknownAdmin treats unknown torrents as owned by admins: only they can see or modify them.
+	UnknownAdmin = "admin"
+	// UnknownShared makes unknown torrents visible to and modifiable by everyone.
+	UnknownShared = "shared"
+)
+
+// Entry records who owns a torrent.
+type Entry struct {
+	User    string    `json:"user"`
+	AddedAt time.Time `json:"added_at"`
+	Source  string    `json:"source"`
+}
+
+// Store maps torrent info hashes (lowercase hex) to their owners. Implementations must be safe
+// for concurrent use.
+type Store interface {
+	Get(hash string) (*Entry, error)
+	Put(hash string, e *Entry) error
+	Delete(hashes ...string) error
+	// All returns all the records keyed by hash.
+	All() (map[string]*Entry, error)
+	Close() error
+}
+
+var bucket = []byte("torrents")
+
+// BoltStore keeps records in bbolt database file, every change is a separate transaction.
+type BoltStore struct {
+	db *bolt.DB
+}
+
+// Open opens (creating if necessary) the database at path.
+func Open(path string) (*BoltStore, error) {
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(bucket)
+		return err
+	})
+	if err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+
+	return &BoltStore{db: db}, nil
+}
+
+func (s *BoltStore) Get(hash string) (*Entry, error) {
+	var e *Entry
+	err := s.db.View(func(tx *bolt.Tx) error {
+		bs := tx.Bucket(bucket).Get([]byte(strings.ToLower(hash)))
+		if bs == nil {
+			return nil
+		}
+
+		e = &Entry{}
+		return json.Unmarshal(bs, e)
+	})
+
+	return e, err
+}
+
+func (s *BoltStore) Put(hash string, e *Entry) error {
+	bs, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucket).Put([]byte(strings.ToLower(hash)), bs)
+	})
+}
+
+func (s *BoltStore) Delete(hashes ...string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucket)
+		for _, h := range hashes {
+			if err := b.Delete([]byte(strings.ToLower(h))); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+func (s *BoltStore) All() (map[string]*Entry, error) {
+	res := map[string]*Entry{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucket).ForEach(func(k, v []byte) error {
+			e := &Entry{}
+			if err := json.Unmarshal(v, e); err != nil {
+				return fmt.Errorf("record %s: %w", k, err)
+			}
+
+			res[string(k)] = e
+			return nil
+		})
+	})
+
+	return res, err
+}
+
+func (s *BoltStore) Close() error {
+	return s.db.Close()
+}
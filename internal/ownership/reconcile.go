@@ -0,0 +1,201 @@
+package ownership
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"transmission-proxy/internal/jrpc"
+	"transmission-proxy/internal/logger"
+	"transmission-proxy/internal/transmission"
+	"transmission-proxy/internal/upstream"
+)
+
+// Sources of the records adopted by Reconciler.
+const (
+	SourceOwnerLabel     = "owner-label"
+	SourceDownloadPrefix = "download-prefix"
+)
+
+// maxBackoffShift limits how much longer than Interval (2^maxBackoffShift times) the reconciler waits
+// after repeated failures.
+const maxBackoffShift = 4
+
+// Reconciler periodically brings the store in line with the torrents present upstream: records of torrents
+// which no longer exist are deleted, and unknown torrents are adopted by the owner guessed from their owner label
+// or download directory.
+type Reconciler struct {
+	Store    Store
+	Upstream *upstream.Client
+	Interval time.Duration
+	// OwnerLabelPrefix, if not empty, adopts torrents labeled with it followed by the user name.
+	OwnerLabelPrefix string
+	// Prefixes map download directory prefixes to the users owning torrents under them.
+	Prefixes map[string]string
+
+	mu     sync.Mutex
+	status Status
+}
+
+// Status describes the most recent reconciliation run.
+type Status struct {
+	LastRun time.Time `json:"last_run"`
+	// Error of the last run, empty if it succeeded.
+	Error string `json:"error,omitempty"`
+	// Removed, Adopted and Unknown count the records deleted, records added and torrents left unknown
+	// by the last successful run.
+	Removed int       `json:"removed"`
+	Adopted int       `json:"adopted"`
+	Unknown int       `json:"unknown"`
+	NextRun time.Time `json:"next_run"`
+}
+
+func (r *Reconciler) Status() Status {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.status
+}
+
+// Run reconciles every Interval until the context is done. After failures (e.g. upstream being down)
+// the delay doubles with every failure, up to 16 intervals.
+func (r *Reconciler) Run(ctx context.Context) {
+	failures := 0
+	for {
+		err := r.reconcile(ctx)
+		if err != nil {
+			failures++
+			slog.WarnContext(ctx, "ownership reconciliation failed: "+err.Error(), logger.IgnoredAttr(err))
+		} else {
+			failures = 0
+		}
+
+		delay := r.Interval << min(failures, maxBackoffShift)
+
+		r.mu.Lock()
+		r.status.NextRun = time.Now().Add(delay)
+		r.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+	}
+}
+
+func (r *Reconciler) reconcile(ctx context.Context) error {
+	start := time.Now()
+	st := Status{LastRun: start}
+
+	err := r.sync(ctx, start, &st)
+	if err != nil {
+		st = r.Status()
+		st.LastRun = start
+		st.Error = err.Error()
+	} else {
+		slog.InfoContext(ctx, "ownership reconciled",
+			slog.Int("removed", st.Removed), slog.Int("adopted", st.Adopted), slog.Int("unknown", st.Unknown))
+	}
+
+	r.mu.Lock()
+	r.status = st
+	r.mu.Unlock()
+
+	return err
+}
+
+func (r *Reconciler) sync(ctx context.Context, start time.Time, st *Status) error {
+	resp, err := r.Upstream.Call(ctx, nil, &jrpc.Request{
+		Method:    "torrent-get",
+		Arguments: map[string]any{"fields": []string{"hashString", "downloadDir", "labels"}},
+	})
+	if err != nil {
+		return fmt.Errorf("list torrents: %w", err)
+	}
+
+	torrents, err := transmission.ParseTorrents(resp.Arguments["torrents"])
+	if err != nil {
+		return fmt.Errorf("list torrents: %w", err)
+	}
+
+	records, err := r.Store.All()
+	if err != nil {
+		return err
+	}
+
+	present := make(map[string]bool, torrents.Len())
+	for i := 0; i < torrents.Len(); i++ {
+		v, _ := torrents.Get(i, "hashString")
+		hash, _ := v.(string)
+		if hash == "" {
+			continue
+		}
+		hash = strings.ToLower(hash)
+		present[hash] = true
+
+		if records[hash] != nil {
+			continue
+		}
+
+		labels, _ := torrents.Get(i, "labels")
+		dir, _ := torrents.Get(i, "downloadDir")
+		user, source := r.guessOwner(labels, dir)
+		if user == "" {
+			st.Unknown++
+			continue
+		}
+
+		if err := r.Store.Put(hash, &Entry{User: user, AddedAt: time.Now(), Source: source}); err != nil {
+			return err
+		}
+		st.Adopted++
+	}
+
+	var vanished []string
+	for hash, e := range records {
+		// torrents added after the list was fetched are not in it yet
+		if !present[hash] && e.AddedAt.Before(start) {
+			vanished = append(vanished, hash)
+		}
+	}
+	if err := r.Store.Delete(vanished...); err != nil {
+		return err
+	}
+	st.Removed = len(vanished)
+
+	return nil
+}
+
+// guessOwner returns the owner of a torrent by its owner label or, failing that, by the longest matching
+// download prefix.
+func (r *Reconciler) guessOwner(labels, dir any) (user, source string) {
+	if r.OwnerLabelPrefix != "" {
+		ls, _ := labels.([]any)
+		for _, l := range ls {
+			if s, ok := l.(string); ok && strings.HasPrefix(s, r.OwnerLabelPrefix) && len(s) > len(r.OwnerLabelPrefix) {
+				return s[len(r.OwnerLabelPrefix):], SourceOwnerLabel
+			}
+		}
+	}
+
+	d, _ := dir.(string)
+	if d != "" && !strings.HasSuffix(d, "/") {
+		d += "/"
+	}
+
+	var longest string
+	for prefix, u := range r.Prefixes {
+		if strings.HasPrefix(d, prefix) && len(prefix) > len(longest) {
+			longest, user = prefix, u
+		}
+	}
+	if user != "" {
+		return user, SourceDownloadPrefix
+	}
+
+	return "", ""
+}
@@ -0,0 +1,213 @@
+package ownership
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"transmission-proxy/internal/upstream"
+)
+
+// fakeUpstream lists its torrents in reply to torrent-get, or fails while down.
+type fakeUpstream struct {
+	mu       sync.Mutex
+	torrents []map[string]any
+	down     bool
+	calls    int
+}
+
+func (f *fakeUpstream) set(torrents ...map[string]any) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.torrents = torrents
+}
+
+func (f *fakeUpstream) RoundTrip(r *http.Request) (*http.Response, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.calls++
+	if f.down {
+		return nil, errors.New("connection refused")
+	}
+
+	torrents := f.torrents
+	if torrents == nil {
+		torrents = []map[string]any{}
+	}
+	w := httptest.NewRecorder()
+	_ = json.NewEncoder(w).Encode(map[string]any{"result": "success", "arguments": map[string]any{"torrents": torrents}})
+	return w.Result(), nil
+}
+
+func torrent(hash, dir string, labels ...any) map[string]any {
+	return map[string]any{"hashString": hash, "downloadDir": dir, "labels": labels}
+}
+
+func openStore(t *testing.T) *BoltStore {
+	s, err := Open(filepath.Join(t.TempDir(), "ownership.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = s.Close() })
+
+	return s
+}
+
+func testReconciler(t *testing.T, up *fakeUpstream) *Reconciler {
+	return &Reconciler{
+		Store:            openStore(t),
+		Upstream:         &upstream.Client{URL: "http://transmission:9091/transmission/rpc", HTTP: &http.Client{Transport: up}},
+		Interval:         time.Hour,
+		OwnerLabelPrefix: "owner:",
+		Prefixes:         map[string]string{"/downloads/alice/": "alice", "/downloads/alice/shared/": "bob"},
+	}
+}
+
+func owners(t *testing.T, s Store) map[string]string {
+	all, err := s.All()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res := map[string]string{}
+	for hash, e := range all {
+		res[hash] = e.User + " " + e.Source
+	}
+
+	return res
+}
+
+func TestReconcile(t *testing.T) {
+	up := &fakeUpstream{}
+	r := testReconciler(t, up)
+
+	// added through the proxy earlier
+	if err := r.Store.Put("aaaa", &Entry{User: "carol", AddedAt: time.Now().Add(-time.Minute), Source: SourceAdd}); err != nil {
+		t.Fatal(err)
+	}
+
+	up.set(
+		torrent("aaaa", "/downloads/alice/x", "owner:bob"),
+		torrent("BBBB", "/downloads/other", "linux", "owner:bob"),
+		torrent("cccc", "/downloads/alice/shared/y"),
+		torrent("dddd", "/downloads/alice"),
+		torrent("eeee", "/downloads/other", "owner:"),
+		map[string]any{"downloadDir": "/downloads/alice"},
+	)
+	if err := r.reconcile(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{
+		"aaaa": "carol torrent-add",
+		"bbbb": "bob owner-label",
+		"cccc": "bob download-prefix",
+		"dddd": "alice download-prefix",
+	}
+	if got := owners(t, r.Store); !maps.Equal(got, want) {
+		t.Errorf("first run: got %v, want %v", got, want)
+	}
+	if st := r.Status(); st.Adopted != 3 || st.Removed != 0 || st.Unknown != 1 || st.Error != "" || st.LastRun.IsZero() {
+		t.Errorf("first run: got status %+v", st)
+	}
+
+	// the torrents change between the runs: two are removed in the daemon UI, one is added out of band
+	up.set(
+		torrent("aaaa", "/downloads/alice/x"),
+		torrent("ffff", "/downloads/alice/z"),
+		torrent("eeee", "/downloads/other"),
+	)
+	if err := r.reconcile(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	want = map[string]string{
+		"aaaa": "carol torrent-add",
+		"ffff": "alice download-prefix",
+	}
+	if got := owners(t, r.Store); !maps.Equal(got, want) {
+		t.Errorf("second run: got %v, want %v", got, want)
+	}
+	if st := r.Status(); st.Adopted != 1 || st.Removed != 3 || st.Unknown != 1 {
+		t.Errorf("second run: got status %+v", st)
+	}
+}
+
+func TestReconcileKeepsNewRecords(t *testing.T) {
+	up := &fakeUpstream{}
+	r := testReconciler(t, up)
+
+	// added through the proxy while the list was being fetched
+	if err := r.Store.Put("aaaa", &Entry{User: "carol", AddedAt: time.Now().Add(time.Second), Source: SourceAdd}); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.reconcile(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := owners(t, r.Store); got["aaaa"] != "carol torrent-add" {
+		t.Errorf("got %v, want the record added during the run kept", got)
+	}
+}
+
+func TestReconcileUpstreamDown(t *testing.T) {
+	up := &fakeUpstream{}
+	r := testReconciler(t, up)
+	up.set(torrent("aaaa", "/downloads/alice/x"))
+	if err := r.reconcile(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	up.down = true
+	if err := r.reconcile(context.Background()); err == nil {
+		t.Fatal("no error with upstream down")
+	}
+
+	// the counts of the last successful run are kept along with the error
+	st := r.Status()
+	if st.Error == "" || st.Adopted != 1 {
+		t.Errorf("got status %+v", st)
+	}
+	if got := owners(t, r.Store); got["aaaa"] != "alice download-prefix" {
+		t.Errorf("records changed while upstream was down: %v", got)
+	}
+}
+
+func TestReconcilerBackoff(t *testing.T) {
+	up := &fakeUpstream{down: true}
+	r := testReconciler(t, up)
+	r.Interval = 10 * time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		r.Run(ctx)
+		close(done)
+	}()
+
+	// without backoff there would be about 30 attempts, with it 10+20+40+80 ms pass by the fifth
+	time.Sleep(300 * time.Millisecond)
+	cancel()
+	<-done
+
+	up.mu.Lock()
+	calls := up.calls
+	up.mu.Unlock()
+	if calls < 3 || calls > 6 {
+		t.Errorf("upstream called %d times in 300ms, want backoff", calls)
+	}
+
+	st := r.Status()
+	if st.Error == "" || st.NextRun.IsZero() {
+		t.Errorf("got status %+v, want error and next run scheduled", st)
+	}
+}
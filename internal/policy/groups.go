@@ -0,0 +1,92 @@
+package policy
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"transmission-proxy/internal/jrpc"
+	"transmission-proxy/internal/logger"
+	"transmission-proxy/internal/reqctx"
+	"transmission-proxy/internal/roles"
+	"transmission-proxy/internal/upstream"
+)
+
+const argGroup = "group"
+
+// Groups restricts management of bandwidth groups: group-set may be reserved for admins, and other users
+// may only assign torrents to the groups listed for them in the users config (or clear the group).
+// Users with a bandwidth group configured have all their torrents assigned to it: torrent-add has no group
+// argument, so the group is set by a separate torrent-set after the torrent is added.
+type Groups struct {
+	Roles             *roles.Roles
+	GroupSetAdminOnly bool
+	Upstream          *upstream.Client
+}
+
+func (g *Groups) Apply(ctx context.Context, req *jrpc.Request) (*jrpc.Request, ResponseRewriter, error) {
+	user := reqctx.User(ctx)
+	if g.Roles.IsAdmin(user) {
+		return req, nil, nil
+	}
+
+	assigned := g.Roles.User(user).BandwidthGroup
+
+	switch req.Method {
+	case "group-set":
+		if g.GroupSetAdminOnly {
+			return nil, nil, &Violation{
+				Policy: "groups",
+				Reason: fmt.Sprintf("changing group %v is allowed to administrators only", req.Arguments["name"]),
+			}
+		}
+	case "torrent-add", "torrent-set":
+		group, ok := req.Arguments[argGroup]
+		if assigned != "" {
+			if ok && group != assigned {
+				slog.WarnContext(ctx, fmt.Sprintf("group %v requested by the client is replaced with %s", group, assigned),
+					logger.RPCMethod(req.Method), logger.RPCField(argGroup))
+			}
+
+			if req.Method == "torrent-add" {
+				return req, g.assignGroup(ctx, req, assigned), nil
+			}
+			if ok {
+				req = clone(req)
+				req.Arguments[argGroup] = assigned
+			}
+			break
+		}
+
+		if !ok || group == "" {
+			break
+		}
+
+		if name, _ := group.(string); !g.Roles.User(user).AllowsGroup(name) {
+			return nil, nil, &Violation{Policy: "groups", Reason: fmt.Sprintf("group %v is not allowed", group)}
+		}
+	}
+
+	return req, nil, nil
+}
+
+// assignGroup sets the group of the added torrent. Failures are only logged, as the torrent is added anyway.
+func (g *Groups) assignGroup(ctx context.Context, req *jrpc.Request, group string) ResponseRewriter {
+	return func(resp *jrpc.Response) (bool, error) {
+		added, _ := resp.Arguments[argTorrentAdded].(map[string]any)
+		hash, _ := added[fieldHash].(string)
+		if hash == "" {
+			return false, nil
+		}
+
+		_, err := g.Upstream.Call(ctx, req.Header, &jrpc.Request{
+			Method:    "torrent-set",
+			Arguments: map[string]any{argIds: []string{hash}, argGroup: group},
+		})
+		if err != nil {
+			slog.ErrorContext(ctx, "failed to assign bandwidth group: "+err.Error(), logger.IgnoredAttr(err))
+		}
+
+		return false, nil
+	}
+}
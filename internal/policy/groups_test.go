@@ -0,0 +1,168 @@
+package policy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"testing"
+
+	"transmission-proxy/internal/jrpc"
+	"transmission-proxy/internal/reqctx"
+	"transmission-proxy/internal/roles"
+	"transmission-proxy/internal/upstream"
+)
+
+func testGroups() *Groups {
+	r := roles.New([]string{"root"})
+	r.Users["alice"] = &roles.User{Groups: []string{"slow"}}
+	r.Users["bob"] = &roles.User{BandwidthGroup: "bob"}
+
+	return &Groups{Roles: r, GroupSetAdminOnly: true}
+}
+
+func TestGroups(t *testing.T) {
+	cases := []struct {
+		name, user, method string
+		args               map[string]any
+		// rejected is the text of the violation, empty if the request is allowed
+		rejected string
+		// group is what the forwarded request has in group argument
+		group any
+	}{
+		{name: "admin group-set", user: "root", method: "group-set", args: map[string]any{"name": "fast"}},
+		{name: "admin any group", user: "root", method: "torrent-set", args: map[string]any{"group": "fast"}, group: "fast"},
+		{name: "user group-get", user: "alice", method: "group-get", args: map[string]any{}},
+		{name: "user group-set", user: "alice", method: "group-set", args: map[string]any{"name": "slow"},
+			rejected: "changing group slow is allowed to administrators only"},
+		{name: "user allowed group", user: "alice", method: "torrent-set", args: map[string]any{"group": "slow"}, group: "slow"},
+		{name: "user clears group", user: "alice", method: "torrent-set", args: map[string]any{"group": ""}, group: ""},
+		{name: "user disallowed group", user: "alice", method: "torrent-set", args: map[string]any{"group": "fast"},
+			rejected: "group fast is not allowed"},
+		{name: "unknown user", user: "mallory", method: "torrent-set", args: map[string]any{"group": "slow"},
+			rejected: "group slow is not allowed"},
+		{name: "assigned group replaces requested", user: "bob", method: "torrent-set", args: map[string]any{"group": "fast"}, group: "bob"},
+	}
+
+	g := testGroups()
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := reqctx.WithUser(context.Background(), tc.user)
+			req := &jrpc.Request{Method: tc.method, Arguments: tc.args}
+
+			got, _, err := g.Apply(ctx, req)
+			if tc.rejected != "" {
+				var v *Violation
+				if !errors.As(err, &v) || v.Policy != "groups" || !strings.Contains(v.Reason, tc.rejected) {
+					t.Fatalf("got error %v, want violation %q", err, tc.rejected)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("got error %v", err)
+			}
+			if group, ok := got.Arguments[argGroup]; ok && group != tc.group {
+				t.Errorf("forwarded group %v, want %v", group, tc.group)
+			}
+			if _, ok := req.Arguments[argGroup]; ok && req.Arguments[argGroup] != tc.args[argGroup] {
+				t.Errorf("original request was modified: %v", req.Arguments)
+			}
+		})
+	}
+}
+
+func TestGroupsGroupSetAllowed(t *testing.T) {
+	g := testGroups()
+	g.GroupSetAdminOnly = false
+
+	ctx := reqctx.WithUser(context.Background(), "alice")
+	if _, _, err := g.Apply(ctx, &jrpc.Request{Method: "group-set", Arguments: map[string]any{"name": "slow"}}); err != nil {
+		t.Errorf("got error %v", err)
+	}
+}
+
+func TestGroupsAssignedOnAdd(t *testing.T) {
+	data := &fakeTransmission{}
+	g := testGroups()
+	g.Upstream = &upstream.Client{URL: "http://transmission:9091/transmission/rpc", HTTP: &http.Client{Transport: data}}
+	ctx := reqctx.WithUser(context.Background(), "bob")
+
+	req := &jrpc.Request{Method: "torrent-add", Arguments: map[string]any{"filename": "magnet:?xt=urn:btih:abc", "group": "fast"}}
+	got, rw, err := g.Apply(ctx, req)
+	if err != nil || rw == nil {
+		t.Fatalf("got rewriter %v, error %v", rw, err)
+	}
+	if got != req {
+		t.Errorf("torrent-add changed: %v", got.Arguments)
+	}
+
+	// the group is set once the torrent is added, the response is forwarded as is
+	changed, err := rw(&jrpc.Response{Result: "success", Arguments: map[string]any{
+		"torrent-added": map[string]any{"hashString": "0123abcd", "id": json.Number("7")},
+	}})
+	if err != nil || changed {
+		t.Fatalf("got changed %v, error %v", changed, err)
+	}
+	if len(data.requests) != 1 {
+		t.Fatalf("got %d upstream requests, want 1", len(data.requests))
+	}
+	set := data.requests[0]
+	if set.Method != "torrent-set" || set.Arguments["group"] != "bob" || fmt.Sprint(set.Arguments["ids"]) != "[0123abcd]" {
+		t.Errorf("got upstream request %s %v", set.Method, set.Arguments)
+	}
+
+	// nothing to assign for duplicates or failures
+	for _, args := range []map[string]any{{"torrent-duplicate": map[string]any{"hashString": "0123abcd"}}, {}} {
+		if _, err := rw(&jrpc.Response{Result: "success", Arguments: args}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(data.requests) != 1 {
+		t.Errorf("got %d upstream requests, want no more", len(data.requests))
+	}
+}
+
+func TestGroupsAssignedConflict(t *testing.T) {
+	logs := captureLog(t)
+	ctx := reqctx.WithUser(context.Background(), "bob")
+
+	got, _, err := testGroups().Apply(ctx, &jrpc.Request{Method: "torrent-set", Arguments: map[string]any{"ids": []any{1}, "group": "fast"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Arguments["group"] != "bob" {
+		t.Errorf("forwarded group %v, want bob", got.Arguments["group"])
+	}
+	if !strings.Contains(logs.String(), `"level":"WARN","msg":"group fast requested by the client is replaced with bob"`) {
+		t.Errorf("conflict not warned about:\n%s", logs)
+	}
+
+	// requests with the assigned group or without one are not warned about
+	logs.Reset()
+	for _, args := range []map[string]any{{"ids": []any{1}, "group": "bob"}, {"ids": []any{1}, "downloadLimit": 10}} {
+		got, _, err := testGroups().Apply(ctx, &jrpc.Request{Method: "torrent-set", Arguments: args})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Arguments["group"] != args["group"] {
+			t.Errorf("forwarded group %v, want %v", got.Arguments["group"], args["group"])
+		}
+	}
+	if logs.Len() != 0 {
+		t.Errorf("got warnings:\n%s", logs)
+	}
+}
+
+// captureLog makes the default logger write to the returned buffer for the duration of the test.
+func captureLog(t *testing.T) *bytes.Buffer {
+	var buf bytes.Buffer
+	prev := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&buf, nil)))
+	t.Cleanup(func() { slog.SetDefault(prev) })
+
+	return &buf
+}
@@ -0,0 +1,161 @@
+package policy
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"transmission-proxy/internal/jrpc"
+	"transmission-proxy/internal/reqctx"
+	"transmission-proxy/internal/roles"
+	"transmission-proxy/internal/transmission"
+	"transmission-proxy/internal/upstream"
+)
+
+const (
+	fieldID     = "id"
+	fieldLabels = "labels"
+	argIds      = "ids"
+	argFields   = "fields"
+	argTorrents = "torrents"
+)
+
+// Labels isolates users from each other by torrent labels: users see and modify only the torrents labeled
+// with their user name, and torrents they add get that label. Torrents without labels are only visible
+// to admins, who are not restricted at all.
+type Labels struct {
+	Roles    *roles.Roles
+	Upstream *upstream.Client
+}
+
+func (l *Labels) Apply(ctx context.Context, req *jrpc.Request) (*jrpc.Request, ResponseRewriter, error) {
+	user := reqctx.User(ctx)
+	if l.Roles.IsAdmin(user) {
+		return req, nil, nil
+	}
+	if user == "" {
+		return nil, nil, &Violation{Policy: "labels", Reason: "authentication required"}
+	}
+
+	switch req.Method {
+	case "torrent-add":
+		req = clone(req)
+		req.Arguments[fieldLabels] = withLabel(req.Arguments[fieldLabels], user)
+		return req, nil, nil
+	case "torrent-get":
+		return filterTorrents(req, fieldLabels, func(labels any) bool {
+			return transmission.HasLabel(labels, user)
+		})
+	}
+
+	if _, ok := transmission.SpecArguments(req.Method)[argIds]; !ok {
+		return req, nil, nil
+	}
+
+	if err := l.checkOwnership(ctx, req, user); err != nil {
+		return nil, nil, err
+	}
+
+	if labels, ok := req.Arguments[fieldLabels]; ok && req.Method == "torrent-set" {
+		req = clone(req)
+		req.Arguments[fieldLabels] = withLabel(labels, user)
+	}
+
+	return req, nil, nil
+}
+
+// filterTorrents makes sure the field is requested and removes torrents for which keep returns false
+// from the response. The field is removed from the response unless the client requested it.
+func filterTorrents(req *jrpc.Request, field string, keep func(value any) bool) (*jrpc.Request, ResponseRewriter, error) {
+	fields, _ := req.Arguments[argFields].([]any)
+
+	dropField := !containsString(fields, field)
+	if dropField {
+		req = clone(req)
+		req.Arguments[argFields] = append(append([]any{}, fields...), field)
+	}
+
+	return req, func(resp *jrpc.Response) (bool, error) {
+		raw, ok := resp.Arguments[argTorrents]
+		if !ok {
+			return false, nil
+		}
+
+		torrents, err := transmission.ParseTorrents(raw)
+		if err != nil {
+			return false, err
+		}
+
+		torrents.Filter(func(i int) bool {
+			v, _ := torrents.Get(i, field)
+			return keep(v)
+		})
+		if dropField {
+			torrents.DropField(field)
+		}
+
+		if !torrents.Changed() {
+			return false, nil
+		}
+
+		resp.Arguments[argTorrents] = torrents.Value()
+		return true, nil
+	}, nil
+}
+
+// checkOwnership asks upstream for labels of the torrents the request refers to and rejects the request
+// if any of them belongs to someone else.
+func (l *Labels) checkOwnership(ctx context.Context, req *jrpc.Request, user string) error {
+	ids, ok := req.Arguments[argIds]
+	if !ok {
+		return &Violation{Policy: "labels", Reason: "ids must be specified"}
+	}
+
+	torrents, err := fetchTorrents(ctx, l.Upstream, req.Header, ids, fieldID, fieldLabels)
+	if err != nil {
+		return fmt.Errorf("check torrents ownership: %w", err)
+	}
+
+	for i := 0; i < torrents.Len(); i++ {
+		if labels, _ := torrents.Get(i, fieldLabels); !transmission.HasLabel(labels, user) {
+			id, _ := torrents.Get(i, fieldID)
+			return &Violation{Policy: "labels", Reason: fmt.Sprintf("torrent %v belongs to another user", id)}
+		}
+	}
+
+	return nil
+}
+
+// fetchTorrents asks upstream for the fields of the torrents. Nil ids refer to all torrents.
+func fetchTorrents(ctx context.Context, uc *upstream.Client, header http.Header, ids any, fields ...string) (*transmission.Torrents, error) {
+	args := map[string]any{argFields: fields}
+	if ids != nil {
+		args[argIds] = ids
+	}
+
+	resp, err := uc.Call(ctx, header, &jrpc.Request{Method: "torrent-get", Arguments: args})
+	if err != nil {
+		return nil, err
+	}
+
+	return transmission.ParseTorrents(resp.Arguments[argTorrents])
+}
+
+func withLabel(labels any, label string) []any {
+	ls, _ := labels.([]any)
+	if containsString(ls, label) {
+		return ls
+	}
+
+	return append(append([]any{}, ls...), label)
+}
+
+func containsString(items []any, s string) bool {
+	for _, item := range items {
+		if item == s {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,286 @@
+package policy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"transmission-proxy/internal/jrpc"
+	"transmission-proxy/internal/reqctx"
+	"transmission-proxy/internal/roles"
+	"transmission-proxy/internal/upstream"
+)
+
+// fakeTransmission answers torrent-get from its torrents in memory, honoring ids, fields and format, and other
+// methods with success. It records the requests it got.
+type fakeTransmission struct {
+	torrents []map[string]any
+	calls    int
+	requests []*jrpc.Request
+}
+
+func (f *fakeTransmission) RoundTrip(r *http.Request) (*http.Response, error) {
+	f.calls++
+
+	var req jrpc.Request
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, err
+	}
+	f.requests = append(f.requests, &req)
+
+	args := map[string]any{}
+	if req.Method == "torrent-get" {
+		args["torrents"] = f.get(req.Arguments)
+	}
+
+	w := httptest.NewRecorder()
+	_ = json.NewEncoder(w).Encode(map[string]any{"result": "success", "arguments": args})
+
+	return w.Result(), nil
+}
+
+func (f *fakeTransmission) get(args map[string]any) any {
+	var ids []string
+	switch v := args[argIds].(type) {
+	case []any:
+		for _, id := range v {
+			ids = append(ids, fmt.Sprint(id))
+		}
+	case nil:
+	default:
+		ids = []string{fmt.Sprint(v)}
+	}
+
+	var fields []string
+	for _, f := range args[argFields].([]any) {
+		fields = append(fields, f.(string))
+	}
+
+	table := args["format"] == "table"
+	var res []any
+	if table {
+		res = append(res, toAny(fields))
+	}
+	for _, t := range f.torrents {
+		if ids != nil && !slices.Contains(ids, fmt.Sprint(t[fieldID])) {
+			continue
+		}
+
+		if table {
+			row := make([]any, len(fields))
+			for i, name := range fields {
+				row[i] = t[name]
+			}
+			res = append(res, row)
+			continue
+		}
+		obj := map[string]any{}
+		for _, name := range fields {
+			obj[name] = t[name]
+		}
+		res = append(res, obj)
+	}
+
+	return res
+}
+
+func toAny(ss []string) []any {
+	res := make([]any, len(ss))
+	for i, s := range ss {
+		res[i] = s
+	}
+
+	return res
+}
+
+// sharedDataset holds torrents of alice and bob, a torrent shared by both and one without labels.
+func sharedDataset() *fakeTransmission {
+	return &fakeTransmission{torrents: []map[string]any{
+		{"id": 1, "name": "alice.iso", "labels": []any{"alice"}},
+		{"id": 2, "name": "bob.iso", "labels": []any{"bob", "linux"}},
+		{"id": 3, "name": "shared.iso", "labels": []any{"alice", "bob"}},
+		{"id": 4, "name": "orphan.iso", "labels": []any{}},
+		{"id": 5, "name": "legacy.iso"},
+	}}
+}
+
+func testLabels(data *fakeTransmission) *Labels {
+	return &Labels{
+		Roles:    roles.New([]string{"root"}),
+		Upstream: &upstream.Client{URL: "http://transmission:9091/transmission/rpc", HTTP: &http.Client{Transport: data}},
+	}
+}
+
+// getTorrents applies the policy to torrent-get as the user and returns the names of the torrents in the response
+// from the dataset, along with the field names of the first torrent.
+func getTorrents(t *testing.T, l *Labels, data *fakeTransmission, user string, args map[string]any) (names []string, fields []string) {
+	t.Helper()
+
+	ctx := reqctx.WithUser(context.Background(), user)
+	req, rw, err := l.Apply(ctx, &jrpc.Request{Method: "torrent-get", Arguments: args})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp := &jrpc.Response{Result: "success", Arguments: map[string]any{"torrents": data.get(req.Arguments)}}
+	if rw != nil {
+		if _, err := rw(resp); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rows := resp.Arguments["torrents"].([]any)
+	if args["format"] == "table" {
+		for _, f := range rows[0].([]any) {
+			fields = append(fields, f.(string))
+		}
+		nameCol := slices.Index(fields, "name")
+		for _, row := range rows[1:] {
+			names = append(names, row.([]any)[nameCol].(string))
+		}
+		return names, fields
+	}
+
+	for i, row := range rows {
+		obj := row.(map[string]any)
+		names = append(names, obj["name"].(string))
+		if i == 0 {
+			for f := range obj {
+				fields = append(fields, f)
+			}
+			slices.Sort(fields)
+		}
+	}
+
+	return names, fields
+}
+
+func TestLabelsTorrentGet(t *testing.T) {
+	cases := []struct {
+		user, format string
+		want         []string
+	}{
+		{user: "alice", format: "objects", want: []string{"alice.iso", "shared.iso"}},
+		{user: "bob", format: "objects", want: []string{"bob.iso", "shared.iso"}},
+		{user: "alice", format: "table", want: []string{"alice.iso", "shared.iso"}},
+		{user: "bob", format: "table", want: []string{"bob.iso", "shared.iso"}},
+		{user: "carol", format: "objects"},
+		{user: "root", format: "objects", want: []string{"alice.iso", "bob.iso", "shared.iso", "orphan.iso", "legacy.iso"}},
+		{user: "root", format: "table", want: []string{"alice.iso", "bob.iso", "shared.iso", "orphan.iso", "legacy.iso"}},
+	}
+
+	data := sharedDataset()
+	l := testLabels(data)
+	for _, tc := range cases {
+		t.Run(tc.user+" "+tc.format, func(t *testing.T) {
+			names, fields := getTorrents(t, l, data, tc.user, map[string]any{"fields": []any{"id", "name"}, "format": tc.format})
+			if !slices.Equal(names, tc.want) {
+				t.Errorf("got %v, want %v", names, tc.want)
+			}
+			// the labels are requested for filtering only
+			if slices.Contains(fields, "labels") {
+				t.Errorf("got fields %v, want labels not requested by the client dropped", fields)
+			}
+		})
+	}
+
+	names, fields := getTorrents(t, l, data, "alice", map[string]any{"fields": []any{"id", "name", "labels"}})
+	if !slices.Equal(names, []string{"alice.iso", "shared.iso"}) || !slices.Equal(fields, []string{"id", "labels", "name"}) {
+		t.Errorf("requesting labels: got %v with fields %v", names, fields)
+	}
+	if data.calls != 0 {
+		t.Errorf("torrent-get consulted upstream %d times", data.calls)
+	}
+}
+
+func TestLabelsTorrentAdd(t *testing.T) {
+	l := testLabels(sharedDataset())
+
+	cases := []struct {
+		user   string
+		labels any
+		want   []any
+	}{
+		{user: "alice", want: []any{"alice"}},
+		{user: "alice", labels: []any{"linux"}, want: []any{"linux", "alice"}},
+		{user: "alice", labels: []any{"alice", "linux"}, want: []any{"alice", "linux"}},
+		{user: "root", labels: []any{"linux"}, want: []any{"linux"}},
+	}
+
+	for _, tc := range cases {
+		args := map[string]any{"filename": "magnet:?xt=urn:btih:abc"}
+		if tc.labels != nil {
+			args["labels"] = tc.labels
+		}
+		req := &jrpc.Request{Method: "torrent-add", Arguments: args}
+
+		got, _, err := l.Apply(reqctx.WithUser(context.Background(), tc.user), req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if labels, _ := got.Arguments["labels"].([]any); !slices.Equal(labels, tc.want) {
+			t.Errorf("%s adding with labels %v: got %v, want %v", tc.user, tc.labels, labels, tc.want)
+		}
+		if !slices.Equal(toAnySlice(req.Arguments["labels"]), toAnySlice(tc.labels)) {
+			t.Errorf("original request was modified: %v", req.Arguments)
+		}
+	}
+}
+
+func toAnySlice(v any) []any {
+	s, _ := v.([]any)
+	return s
+}
+
+func TestLabelsOwnership(t *testing.T) {
+	cases := []struct {
+		user, method string
+		args         map[string]any
+		// rejected is the text of the violation, empty if the request is allowed
+		rejected string
+	}{
+		{user: "alice", method: "torrent-stop", args: map[string]any{"ids": []any{1, 3}}},
+		{user: "bob", method: "torrent-stop", args: map[string]any{"ids": []any{1}}, rejected: "torrent 1 belongs to another user"},
+		{user: "bob", method: "torrent-remove", args: map[string]any{"ids": []any{2, 3}}},
+		{user: "alice", method: "torrent-remove", args: map[string]any{"ids": []any{3, 4}}, rejected: "torrent 4 belongs to another user"},
+		{user: "alice", method: "torrent-start", args: map[string]any{"ids": 5}, rejected: "torrent 5 belongs to another user"},
+		{user: "alice", method: "torrent-start", args: map[string]any{}, rejected: "ids must be specified"},
+		{user: "root", method: "torrent-remove", args: map[string]any{"ids": []any{4, 5}}},
+		{user: "", method: "torrent-get", args: map[string]any{}, rejected: "authentication required"},
+	}
+
+	data := sharedDataset()
+	l := testLabels(data)
+	for _, tc := range cases {
+		t.Run(tc.user+" "+tc.method+" "+fmt.Sprint(tc.args["ids"]), func(t *testing.T) {
+			_, _, err := l.Apply(reqctx.WithUser(context.Background(), tc.user), &jrpc.Request{Method: tc.method, Arguments: tc.args})
+			if tc.rejected == "" {
+				if err != nil {
+					t.Errorf("got error %v", err)
+				}
+				return
+			}
+
+			var v *Violation
+			if !errors.As(err, &v) || v.Policy != "labels" || !strings.Contains(v.Reason, tc.rejected) {
+				t.Errorf("got error %v, want violation %q", err, tc.rejected)
+			}
+		})
+	}
+
+	// a user cannot drop their own label from a torrent and thereby lose it
+	ctx := reqctx.WithUser(context.Background(), "alice")
+	got, _, err := l.Apply(ctx, &jrpc.Request{Method: "torrent-set", Arguments: map[string]any{"ids": []any{1}, "labels": []any{"movies"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if labels := got.Arguments["labels"]; !slices.Equal(toAnySlice(labels), []any{"movies", "alice"}) {
+		t.Errorf("torrent-set got labels %v, want the user label kept", labels)
+	}
+}
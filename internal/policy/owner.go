@@ -0,0 +1,104 @@
+package policy
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"transmission-proxy/internal/jrpc"
+	"transmission-proxy/internal/reqctx"
+	"transmission-proxy/internal/roles"
+	"transmission-proxy/internal/upstream"
+)
+
+// OwnerLabel marks torrents with the label naming the user who added them (Prefix followed by the user
+// name) and keeps that label when clients change torrent labels. Only admins may set owner labels
+// explicitly, e.g. to hand torrents over to another user.
+type OwnerLabel struct {
+	Prefix   string
+	Roles    *roles.Roles
+	Upstream *upstream.Client
+}
+
+func (o *OwnerLabel) Apply(ctx context.Context, req *jrpc.Request) (*jrpc.Request, ResponseRewriter, error) {
+	user := reqctx.User(ctx)
+	if user == "" {
+		return req, nil, nil
+	}
+
+	switch req.Method {
+	case "torrent-add":
+		req = clone(req)
+		req.Arguments[fieldLabels] = append(o.withoutOwner(req.Arguments[fieldLabels]), o.Prefix+user)
+		return req, nil, nil
+	case "torrent-set":
+		labels, ok := req.Arguments[fieldLabels]
+		if !ok {
+			return req, nil, nil
+		}
+
+		ls, _ := labels.([]any)
+		if o.Roles.IsAdmin(user) && len(o.withoutOwner(ls)) != len(ls) {
+			// admin reassigns the torrents
+			return req, nil, nil
+		}
+
+		owner, err := o.currentOwner(ctx, req)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		req = clone(req)
+		req.Arguments[fieldLabels] = o.withoutOwner(ls)
+		if owner != "" {
+			req.Arguments[fieldLabels] = append(req.Arguments[fieldLabels].([]any), owner)
+		}
+		return req, nil, nil
+	}
+
+	return req, nil, nil
+}
+
+// currentOwner returns the owner label of the torrents the request refers to, or empty string if they have none.
+func (o *OwnerLabel) currentOwner(ctx context.Context, req *jrpc.Request) (string, error) {
+	torrents, err := fetchTorrents(ctx, o.Upstream, req.Header, req.Arguments[argIds], fieldID, fieldLabels)
+	if err != nil {
+		return "", fmt.Errorf("check torrents owner: %w", err)
+	}
+
+	var owner string
+	for i := 0; i < torrents.Len(); i++ {
+		labels, _ := torrents.Get(i, fieldLabels)
+		ls, _ := labels.([]any)
+
+		var label string
+		for _, l := range ls {
+			if s, ok := l.(string); ok && strings.HasPrefix(s, o.Prefix) {
+				label = s
+				break
+			}
+		}
+
+		if i > 0 && label != owner {
+			return "", &Violation{Policy: "owner_label", Reason: "torrents have different owners, set their labels separately"}
+		}
+		owner = label
+	}
+
+	return owner, nil
+}
+
+// withoutOwner returns copy of the labels without owner labels.
+func (o *OwnerLabel) withoutOwner(labels any) []any {
+	ls, _ := labels.([]any)
+
+	res := make([]any, 0, len(ls)+1)
+	for _, l := range ls {
+		if s, ok := l.(string); ok && strings.HasPrefix(s, o.Prefix) {
+			continue
+		}
+		res = append(res, l)
+	}
+
+	return res
+}
@@ -0,0 +1,140 @@
+package policy
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+	"testing"
+
+	"transmission-proxy/internal/jrpc"
+	"transmission-proxy/internal/reqctx"
+	"transmission-proxy/internal/roles"
+	"transmission-proxy/internal/upstream"
+)
+
+func testOwnerLabel() (*OwnerLabel, *fakeTransmission) {
+	data := &fakeTransmission{torrents: []map[string]any{
+		{"id": 1, "labels": []any{"owner:alice", "linux"}},
+		{"id": 2, "labels": []any{"tv", "owner:alice"}},
+		{"id": 3, "labels": []any{"owner:bob"}},
+		{"id": 4, "labels": []any{"legacy"}},
+		{"id": 5},
+	}}
+
+	return &OwnerLabel{
+		Prefix:   "owner:",
+		Roles:    roles.New([]string{"root"}),
+		Upstream: &upstream.Client{URL: "http://transmission:9091/transmission/rpc", HTTP: &http.Client{Transport: data}},
+	}, data
+}
+
+func TestOwnerLabelAdd(t *testing.T) {
+	cases := []struct {
+		name, user string
+		labels     any
+		want       []any
+	}{
+		{name: "no labels", user: "alice", want: []any{"owner:alice"}},
+		{name: "merged", user: "alice", labels: []any{"linux", "iso"}, want: []any{"linux", "iso", "owner:alice"}},
+		{name: "forged owner", user: "alice", labels: []any{"owner:bob", "linux"}, want: []any{"linux", "owner:alice"}},
+		{name: "own owner label", user: "alice", labels: []any{"owner:alice"}, want: []any{"owner:alice"}},
+		{name: "admin", user: "root", labels: []any{"linux"}, want: []any{"linux", "owner:root"}},
+	}
+
+	o, _ := testOwnerLabel()
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			args := map[string]any{"filename": "magnet:?xt=urn:btih:abc"}
+			if tc.labels != nil {
+				args["labels"] = tc.labels
+			}
+			req := &jrpc.Request{Method: "torrent-add", Arguments: args}
+
+			got, _, err := o.Apply(reqctx.WithUser(context.Background(), tc.user), req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if labels := toAnySlice(got.Arguments["labels"]); !slices.Equal(labels, tc.want) {
+				t.Errorf("got labels %v, want %v", labels, tc.want)
+			}
+			if !slices.Equal(toAnySlice(req.Arguments["labels"]), toAnySlice(tc.labels)) {
+				t.Errorf("original request was modified: %v", req.Arguments)
+			}
+		})
+	}
+
+	// without authentication there is nobody to own the torrent
+	req := &jrpc.Request{Method: "torrent-add", Arguments: map[string]any{"labels": []any{"owner:bob"}}}
+	if got, _, err := o.Apply(context.Background(), req); err != nil || got != req {
+		t.Errorf("anonymous request changed: %v, %v", got, err)
+	}
+}
+
+func TestOwnerLabelSet(t *testing.T) {
+	cases := []struct {
+		name, user string
+		ids        []any
+		labels     []any
+		want       []any
+		// violation is set if the request is rejected
+		violation bool
+	}{
+		{name: "owner stripped", user: "alice", ids: []any{1}, labels: []any{"linux"}, want: []any{"linux", "owner:alice"}},
+		{name: "all labels cleared", user: "alice", ids: []any{1}, labels: []any{}, want: []any{"owner:alice"}},
+		{name: "owner replaced", user: "alice", ids: []any{1}, labels: []any{"owner:alice2", "x"}, want: []any{"x", "owner:alice"}},
+		{name: "several torrents of the owner", user: "alice", ids: []any{1, 2}, labels: []any{"done"}, want: []any{"done", "owner:alice"}},
+		{name: "owner of another user kept", user: "alice", ids: []any{3}, labels: []any{"mine"}, want: []any{"mine", "owner:bob"}},
+		{name: "torrent without owner", user: "alice", ids: []any{4}, labels: []any{"owner:alice"}, want: []any{}},
+		{name: "torrent without labels", user: "alice", ids: []any{5}, labels: []any{"x"}, want: []any{"x"}},
+		{name: "different owners", user: "alice", ids: []any{1, 3}, labels: []any{"x"}, violation: true},
+		{name: "owned and unowned", user: "alice", ids: []any{1, 4}, labels: []any{"x"}, violation: true},
+		{name: "admin reassigns", user: "root", ids: []any{1, 3}, labels: []any{"owner:carol"}, want: []any{"owner:carol"}},
+		{name: "admin without owner label", user: "root", ids: []any{3}, labels: []any{"x"}, want: []any{"x", "owner:bob"}},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			o, data := testOwnerLabel()
+			req := &jrpc.Request{Method: "torrent-set", Arguments: map[string]any{"ids": tc.ids, "labels": tc.labels}}
+
+			got, _, err := o.Apply(reqctx.WithUser(context.Background(), tc.user), req)
+			if tc.violation {
+				var v *Violation
+				if !errors.As(err, &v) || v.Policy != "owner_label" {
+					t.Errorf("got %v, want violation", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if labels := toAnySlice(got.Arguments["labels"]); !slices.Equal(labels, tc.want) {
+				t.Errorf("got labels %v, want %v", labels, tc.want)
+			}
+			if !slices.Equal(toAnySlice(req.Arguments["labels"]), tc.labels) {
+				t.Errorf("original request was modified: %v", req.Arguments)
+			}
+			if tc.name == "admin reassigns" && data.calls != 0 {
+				t.Errorf("upstream consulted %d times for the admin reassigning torrents", data.calls)
+			}
+		})
+	}
+}
+
+func TestOwnerLabelOtherCalls(t *testing.T) {
+	o, data := testOwnerLabel()
+	ctx := reqctx.WithUser(context.Background(), "alice")
+
+	for _, req := range []*jrpc.Request{
+		{Method: "torrent-set", Arguments: map[string]any{"ids": []any{3}, "downloadLimit": 100}},
+		{Method: "torrent-remove", Arguments: map[string]any{"ids": []any{3}}},
+	} {
+		if got, _, err := o.Apply(ctx, req); err != nil || got != req {
+			t.Errorf("%s changed: %v, %v", req.Method, got, err)
+		}
+	}
+	if data.calls != 0 {
+		t.Errorf("upstream consulted %d times", data.calls)
+	}
+}
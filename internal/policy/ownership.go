@@ -0,0 +1,148 @@
+package policy
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"transmission-proxy/internal/jrpc"
+	"transmission-proxy/internal/logger"
+	"transmission-proxy/internal/ownership"
+	"transmission-proxy/internal/reqctx"
+	"transmission-proxy/internal/roles"
+	"transmission-proxy/internal/transmission"
+	"transmission-proxy/internal/upstream"
+)
+
+const (
+	fieldHash       = "hashString"
+	argTorrentAdded = "torrent-added"
+)
+
+// Ownership restricts users to the torrents recorded as theirs in the ownership store. Torrents added
+// through the proxy are recorded as owned by the user who added them; torrents unknown to the store
+// are treated according to Unknown (ownership.UnknownAdmin or ownership.UnknownShared).
+// Admins are not restricted.
+type Ownership struct {
+	Store    ownership.Store
+	Unknown  string
+	Roles    *roles.Roles
+	Upstream *upstream.Client
+}
+
+func (o *Ownership) Apply(ctx context.Context, req *jrpc.Request) (*jrpc.Request, ResponseRewriter, error) {
+	user := reqctx.User(ctx)
+	if user == "" {
+		return nil, nil, &Violation{Policy: "ownership", Reason: "authentication required"}
+	}
+	admin := o.Roles.IsAdmin(user)
+
+	switch req.Method {
+	case "torrent-add":
+		return req, o.recordAdded(ctx, user), nil
+	case "torrent-get":
+		if admin {
+			return req, nil, nil
+		}
+
+		owners, err := o.Store.All()
+		if err != nil {
+			return nil, nil, fmt.Errorf("load torrents ownership: %w", err)
+		}
+
+		return filterTorrents(req, fieldHash, func(hash any) bool {
+			s, _ := hash.(string)
+			return o.allowed(owners[strings.ToLower(s)], user)
+		})
+	}
+
+	if _, ok := transmission.SpecArguments(req.Method)[argIds]; !ok {
+		return req, nil, nil
+	}
+	if admin && req.Method != "torrent-remove" {
+		return req, nil, nil
+	}
+
+	hashes, err := o.checkOwnership(ctx, req, user, admin)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if req.Method != "torrent-remove" {
+		return req, nil, nil
+	}
+
+	return req, func(*jrpc.Response) (bool, error) {
+		if err := o.Store.Delete(hashes...); err != nil {
+			slog.ErrorContext(ctx, "failed to delete ownership records: "+err.Error(), logger.IgnoredAttr(err))
+		}
+
+		return false, nil
+	}, nil
+}
+
+// checkOwnership resolves the torrents the request refers to into their hashes, rejecting the request
+// if any of them belongs to someone else (unless the user is admin).
+func (o *Ownership) checkOwnership(ctx context.Context, req *jrpc.Request, user string, admin bool) ([]string, error) {
+	ids, ok := req.Arguments[argIds]
+	if !ok && !admin {
+		return nil, &Violation{Policy: "ownership", Reason: "ids must be specified"}
+	}
+
+	torrents, err := fetchTorrents(ctx, o.Upstream, req.Header, ids, fieldID, fieldHash)
+	if err != nil {
+		return nil, fmt.Errorf("check torrents ownership: %w", err)
+	}
+
+	hashes := make([]string, 0, torrents.Len())
+	for i := 0; i < torrents.Len(); i++ {
+		v, _ := torrents.Get(i, fieldHash)
+		hash, _ := v.(string)
+		hashes = append(hashes, hash)
+
+		if admin {
+			continue
+		}
+
+		e, err := o.Store.Get(hash)
+		if err != nil {
+			return nil, fmt.Errorf("check torrents ownership: %w", err)
+		}
+		if !o.allowed(e, user) {
+			id, _ := torrents.Get(i, fieldID)
+			return nil, &Violation{Policy: "ownership", Reason: fmt.Sprintf("torrent %v belongs to another user", id)}
+		}
+	}
+
+	return hashes, nil
+}
+
+// allowed reports whether the (non-admin) user may access the torrent with the record e (nil if unknown).
+func (o *Ownership) allowed(e *ownership.Entry, user string) bool {
+	if e == nil {
+		return o.Unknown == ownership.UnknownShared
+	}
+
+	return e.User == user
+}
+
+// recordAdded records the user as the owner of the added torrent. Duplicates keep their owner.
+func (o *Ownership) recordAdded(ctx context.Context, user string) ResponseRewriter {
+	return func(resp *jrpc.Response) (bool, error) {
+		added, _ := resp.Arguments[argTorrentAdded].(map[string]any)
+		hash, _ := added[fieldHash].(string)
+		if hash == "" {
+			return false, nil
+		}
+
+		err := o.Store.Put(hash, &ownership.Entry{User: user, AddedAt: time.Now(), Source: ownership.SourceAdd})
+		if err != nil {
+			// the torrent is added anyway, so the response must get through
+			slog.ErrorContext(ctx, "failed to record torrent ownership: "+err.Error(), logger.IgnoredAttr(err))
+		}
+
+		return false, nil
+	}
+}
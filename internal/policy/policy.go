@@ -0,0 +1,80 @@
+package policy
+
+import (
+	"context"
+	"log/slog"
+
+	"transmission-proxy/internal/jrpc"
+	"transmission-proxy/internal/logger"
+)
+
+// Policy adjusts validated requests depending on who makes them, and optionally the responses to them.
+// Policies run after validation, in order, each receiving the request returned by the previous one.
+type Policy interface {
+	// Apply returns the request to forward and the rewriter to apply to the response (nil if the response
+	// is forwarded unchanged). The passed request must not be modified. Errors of type *Violation reject
+	// the request, other errors mean the policy could not be checked.
+	Apply(ctx context.Context, req *jrpc.Request) (*jrpc.Request, ResponseRewriter, error)
+}
+
+// ResponseRewriter modifies successful upstream response in place, reporting whether it changed anything,
+// so that responses left as they were are forwarded exactly as received.
+type ResponseRewriter func(resp *jrpc.Response) (changed bool, err error)
+
+// Violation is returned when the user is not allowed to make the request.
+type Violation struct {
+	Policy string
+	Reason string
+}
+
+func (v *Violation) Error() string {
+	return v.Reason
+}
+
+func (v *Violation) GetLoggableAttrs() []slog.Attr {
+	return []slog.Attr{logger.RPC(slog.String(logger.KeyPolicy, v.Policy))}
+}
+
+// Chain applies the policies in order, combining their response rewriters so that they run in reverse order.
+func Chain(ctx context.Context, policies []Policy, req *jrpc.Request) (*jrpc.Request, ResponseRewriter, error) {
+	var rewriters []ResponseRewriter
+	for _, p := range policies {
+		var rw ResponseRewriter
+		var err error
+		req, rw, err = p.Apply(ctx, req)
+		if err != nil {
+			return nil, nil, err
+		}
+		if rw != nil {
+			rewriters = append(rewriters, rw)
+		}
+	}
+
+	if len(rewriters) == 0 {
+		return req, nil, nil
+	}
+
+	return req, func(resp *jrpc.Response) (bool, error) {
+		var changed bool
+		for i := len(rewriters) - 1; i >= 0; i-- {
+			c, err := rewriters[i](resp)
+			if err != nil {
+				return false, err
+			}
+			changed = changed || c
+		}
+
+		return changed, nil
+	}, nil
+}
+
+// clone returns shallow copy of the request with copied arguments map, which may be modified.
+func clone(req *jrpc.Request) *jrpc.Request {
+	c := *req
+	c.Arguments = make(map[string]any, len(req.Arguments))
+	for k, v := range req.Arguments {
+		c.Arguments[k] = v
+	}
+
+	return &c
+}
@@ -0,0 +1,114 @@
+package policy
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	"transmission-proxy/internal/apikeys"
+	"transmission-proxy/internal/jrpc"
+	"transmission-proxy/internal/reqctx"
+	"transmission-proxy/internal/roles"
+	"transmission-proxy/internal/transmission"
+)
+
+// UserPlaceholder in download prefix is replaced with the name of the user making the request.
+const UserPlaceholder = "{user}"
+
+const argRemoved = "removed"
+
+// PrefixFilter hides torrents with download directory outside Prefix from torrent-get responses, e.g. added
+// directly on the daemon. Ids of hidden torrents are also removed from the list of recently removed torrents,
+// as far as the torrents were seen hidden before. Requests made with API keys having their own download prefix
+// see the torrents under that prefix instead. Admins see all torrents.
+type PrefixFilter struct {
+	Prefix string
+	Roles  *roles.Roles
+
+	mu sync.Mutex
+	// hidden tells whether the torrent was hidden, by prefix and torrent id
+	hidden map[string]bool
+}
+
+func (f *PrefixFilter) Apply(ctx context.Context, req *jrpc.Request) (*jrpc.Request, ResponseRewriter, error) {
+	user := reqctx.User(ctx)
+	if req.Method != "torrent-get" || f.Roles.IsAdmin(user) {
+		return req, nil, nil
+	}
+
+	prefix := f.Prefix
+	if k := apikeys.FromContext(ctx); k != nil && k.DownloadPrefix != "" {
+		prefix = k.DownloadPrefix
+	}
+	prefix = strings.ReplaceAll(prefix, UserPlaceholder, user)
+
+	fields, _ := req.Arguments[argFields].([]any)
+	var added []string
+	for _, field := range []string{fieldID, fieldDownloadDir} {
+		if !containsString(fields, field) {
+			added = append(added, field)
+		}
+	}
+	if len(added) > 0 {
+		req = clone(req)
+		fields = slices.Clone(fields)
+		for _, field := range added {
+			fields = append(fields, field)
+		}
+		req.Arguments[argFields] = fields
+	}
+
+	return req, func(resp *jrpc.Response) (bool, error) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+
+		if f.hidden == nil {
+			f.hidden = map[string]bool{}
+		}
+
+		var changed bool
+		if raw, ok := resp.Arguments[argTorrents]; ok {
+			torrents, err := transmission.ParseTorrents(raw)
+			if err != nil {
+				return false, err
+			}
+
+			torrents.Filter(func(i int) bool {
+				id, _ := torrents.Get(i, fieldID)
+				visible := strings.HasPrefix(torrents.String(i, fieldDownloadDir), prefix)
+				f.hidden[hiddenKey(prefix, id)] = !visible
+				return visible
+			})
+			for _, field := range added {
+				torrents.DropField(field)
+			}
+			if torrents.Changed() {
+				resp.Arguments[argTorrents] = torrents.Value()
+				changed = true
+			}
+		}
+
+		if removed, ok := resp.Arguments[argRemoved].([]any); ok {
+			kept := make([]any, 0, len(removed))
+			for _, id := range removed {
+				key := hiddenKey(prefix, id)
+				if !f.hidden[key] {
+					kept = append(kept, id)
+				}
+				delete(f.hidden, key)
+			}
+			if len(kept) != len(removed) {
+				resp.Arguments[argRemoved] = kept
+				changed = true
+			}
+		}
+
+		return changed, nil
+	}, nil
+}
+
+func hiddenKey(prefix string, id any) string {
+	return fmt.Sprint(prefix, "\x00", id)
+}
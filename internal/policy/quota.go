@@ -0,0 +1,45 @@
+package policy
+
+import (
+	"context"
+	"fmt"
+
+	"transmission-proxy/internal/jrpc"
+	"transmission-proxy/internal/quota"
+	"transmission-proxy/internal/reqctx"
+	"transmission-proxy/internal/roles"
+)
+
+// Quota rejects torrent-add of users who have reached their quota. Admins have no quota.
+type Quota struct {
+	Roles *roles.Roles
+	Calc  *quota.Calculator
+}
+
+func (q *Quota) Apply(ctx context.Context, req *jrpc.Request) (*jrpc.Request, ResponseRewriter, error) {
+	user := reqctx.User(ctx)
+	if req.Method != "torrent-add" || q.Roles.IsAdmin(user) {
+		return req, nil, nil
+	}
+
+	limits := q.Roles.User(user).Quota
+	if limits == (quota.Limits{}) {
+		return req, nil, nil
+	}
+
+	report, err := q.Calc.Check(ctx, user, limits)
+	if err != nil {
+		return nil, nil, fmt.Errorf("check quota: %w", err)
+	}
+	if report.Exceeded {
+		return nil, nil, &Violation{
+			Policy: "quota",
+			Reason: fmt.Sprintf("quota exceeded: %d torrents of %d bytes in total", report.Usage.Torrents, report.Usage.Bytes),
+		}
+	}
+
+	return req, func(*jrpc.Response) (bool, error) {
+		q.Calc.Torrents.Invalidate()
+		return false, nil
+	}, nil
+}
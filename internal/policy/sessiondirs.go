@@ -0,0 +1,47 @@
+package policy
+
+import (
+	"context"
+	"strings"
+
+	"transmission-proxy/internal/apikeys"
+	"transmission-proxy/internal/jrpc"
+	"transmission-proxy/internal/reqctx"
+	"transmission-proxy/internal/roles"
+)
+
+// sessionDirs are the session-get arguments replaced by SessionDirs.
+var sessionDirs = []string{"download-dir", "incomplete-dir"}
+
+// SessionDirs replaces the default directories of the daemon in session-get responses with Prefix, since
+// clients pre-fill them when adding torrents and adding outside of Prefix would be rejected. Requests made
+// with API keys having their own download prefix see that prefix instead. Admins see the actual directories.
+type SessionDirs struct {
+	Prefix string
+	Roles  *roles.Roles
+}
+
+func (s *SessionDirs) Apply(ctx context.Context, req *jrpc.Request) (*jrpc.Request, ResponseRewriter, error) {
+	user := reqctx.User(ctx)
+	if req.Method != "session-get" || s.Roles.IsAdmin(user) {
+		return req, nil, nil
+	}
+
+	prefix := s.Prefix
+	if k := apikeys.FromContext(ctx); k != nil && k.DownloadPrefix != "" {
+		prefix = k.DownloadPrefix
+	}
+	prefix = strings.ReplaceAll(prefix, UserPlaceholder, user)
+
+	return req, func(resp *jrpc.Response) (bool, error) {
+		var changed bool
+		for _, arg := range sessionDirs {
+			if v, ok := resp.Arguments[arg]; ok && v != prefix {
+				resp.Arguments[arg] = prefix
+				changed = true
+			}
+		}
+
+		return changed, nil
+	}, nil
+}
@@ -0,0 +1,129 @@
+package policy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math"
+	"sort"
+	"strconv"
+
+	"transmission-proxy/internal/jrpc"
+	"transmission-proxy/internal/logger"
+	"transmission-proxy/internal/upstream"
+)
+
+// argSessionMismatch is the response argument SessionSetCheck reports the settings which were not applied in.
+const argSessionMismatch = "proxy-mismatch"
+
+// SessionSetCheck reads the settings back with session-get after successful session-set and logs a warning
+// listing the ones the daemon reports different values of, e.g. because it refused them. With Report they are
+// also returned to the client in proxy-mismatch response argument as {"setting": {"requested": x, "actual": y}}.
+type SessionSetCheck struct {
+	Upstream *upstream.Client
+	Report   bool
+}
+
+func (s *SessionSetCheck) Apply(ctx context.Context, req *jrpc.Request) (*jrpc.Request, ResponseRewriter, error) {
+	if req.Method != "session-set" || len(req.Arguments) == 0 {
+		return req, nil, nil
+	}
+
+	fields := make([]string, 0, len(req.Arguments))
+	for key := range req.Arguments {
+		fields = append(fields, key)
+	}
+	sort.Strings(fields)
+
+	return req, func(resp *jrpc.Response) (bool, error) {
+		actual, err := s.Upstream.Call(ctx, req.Header, &jrpc.Request{Method: "session-get", Arguments: map[string]any{"fields": fields}})
+		if err != nil {
+			// the settings were changed regardless
+			slog.WarnContext(ctx, "cannot read back session-set settings: "+err.Error(), logger.IgnoredAttr(err))
+			return false, nil
+		}
+
+		mismatch := map[string]any{}
+		var keys []string
+		for _, key := range fields {
+			got, ok := actual.Arguments[key]
+			// not all settings are reported
+			if !ok || sameSetting(req.Arguments[key], got) {
+				continue
+			}
+
+			keys = append(keys, key)
+			mismatch[key] = map[string]any{"requested": req.Arguments[key], "actual": got}
+		}
+		if len(keys) == 0 {
+			return false, nil
+		}
+
+		slog.WarnContext(ctx, fmt.Sprintf("session-set succeeded but %d settings differ when read back", len(keys)),
+			logger.RPCMethod(req.Method), logger.RPC(slog.Any(logger.KeyFields, keys)))
+		if !s.Report {
+			return false, nil
+		}
+
+		resp.Arguments[argSessionMismatch] = mismatch
+		return true, nil
+	}, nil
+}
+
+// sameSetting compares the value sent in session-set with the one reported by session-get, which may differ in type:
+// numbers may be reported as fractions or strings, e.g. 5 as 5.0 or "5".
+func sameSetting(sent, got any) bool {
+	switch s := sent.(type) {
+	case []any:
+		g, ok := got.([]any)
+		if !ok || len(s) != len(g) {
+			return false
+		}
+		for i := range s {
+			if !sameSetting(s[i], g[i]) {
+				return false
+			}
+		}
+		return true
+	case map[string]any:
+		g, ok := got.(map[string]any)
+		if !ok || len(s) != len(g) {
+			return false
+		}
+		for k, v := range s {
+			if !sameSetting(v, g[k]) {
+				return false
+			}
+		}
+		return true
+	}
+
+	if a, ok := settingNumber(sent); ok {
+		b, ok := settingNumber(got)
+		// the daemon keeps some settings as floats, e.g. ratio limits
+		return ok && math.Abs(a-b) <= 1e-6*math.Max(1, math.Abs(a))
+	}
+
+	return sent == got
+}
+
+// settingNumber returns the value as number if it is one, including numbers sent as strings.
+func settingNumber(v any) (float64, bool) {
+	switch n := v.(type) {
+	case json.Number:
+		f, err := n.Float64()
+		return f, err == nil
+	case float64:
+		return n, true
+	case int64:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case string:
+		f, err := strconv.ParseFloat(n, 64)
+		return f, err == nil
+	}
+
+	return 0, false
+}
@@ -0,0 +1,175 @@
+package policy
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"transmission-proxy/internal/jrpc"
+	"transmission-proxy/internal/transmissiontest"
+	"transmission-proxy/internal/upstream"
+)
+
+func TestSameSetting(t *testing.T) {
+	cases := []struct {
+		name      string
+		sent, got any
+		want      bool
+	}{
+		{name: "integer", sent: json.Number("5"), got: 5.0, want: true},
+		{name: "integer as fraction", sent: json.Number("5"), got: json.Number("5.0"), want: true},
+		{name: "integer as string", sent: json.Number("5"), got: "5", want: true},
+		{name: "string as integer", sent: "5", got: 5.0, want: true},
+		{name: "rounded ratio", sent: json.Number("1.1"), got: 1.1000000001, want: true},
+		{name: "other number", sent: json.Number("5"), got: 4.0},
+		{name: "number and text", sent: json.Number("5"), got: "five"},
+		{name: "bool", sent: true, got: true, want: true},
+		{name: "other bool", sent: true, got: false},
+		{name: "text", sent: "/downloads", got: "/downloads", want: true},
+		{name: "other text", sent: "/downloads", got: "/downloads/"},
+		{name: "list", sent: []any{json.Number("1"), "a"}, got: []any{1.0, "a"}, want: true},
+		{name: "shorter list", sent: []any{json.Number("1"), "a"}, got: []any{1.0}},
+		{name: "map", sent: map[string]any{"a": json.Number("1")}, got: map[string]any{"a": "1"}, want: true},
+		{name: "other map", sent: map[string]any{"a": json.Number("1")}, got: map[string]any{"b": 1.0}},
+		{name: "list and value", sent: []any{"a"}, got: "a"},
+		{name: "missing", sent: true, got: nil},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := sameSetting(tc.sent, tc.got); got != tc.want {
+				t.Errorf("got %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+// sessionDaemon returns the client of the daemon answering session-get with the settings, or with the status
+// if it is not 200.
+func sessionDaemon(status int, settings string) (*upstream.Client, *transmissiontest.Server) {
+	up := &transmissiontest.Server{RPC: func(w http.ResponseWriter, _ *http.Request, req *jrpc.Request) {
+		transmissiontest.Reply(w, status, req, settings)
+	}}
+
+	return &upstream.Client{URL: "http://transmission:9091" + transmissiontest.DefaultRPCPath, HTTP: &http.Client{Transport: up}}, up
+}
+
+// applySessionSet applies the check to session-set with the arguments, returning the response after the rewrite
+// and whether it was changed.
+func applySessionSet(t *testing.T, check *SessionSetCheck, args string) (*jrpc.Response, bool) {
+	t.Helper()
+
+	var req *jrpc.Request
+	if err := json.Unmarshal([]byte(`{"method":"session-set","arguments":`+args+`}`), &req); err != nil {
+		t.Fatal(err)
+	}
+	_, rw, err := check.Apply(context.Background(), req)
+	if err != nil || rw == nil {
+		t.Fatalf("got rewriter %v, error %v", rw != nil, err)
+	}
+
+	resp := &jrpc.Response{Result: jrpc.ResultSuccess, Arguments: map[string]any{}}
+	changed, err := rw(resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return resp, changed
+}
+
+func TestSessionSetCheck(t *testing.T) {
+	const settings = `{"speed-limit-down":100,"speed-limit-down-enabled":true,"seedRatioLimit":"1.5","download-dir":"/data"}`
+	args := `{"speed-limit-down":100,"speed-limit-down-enabled":true,"seedRatioLimit":1.5,"download-dir":"/downloads","peer-port":51413}`
+
+	logs := captureLog(t)
+	c, up := sessionDaemon(http.StatusOK, settings)
+	resp, changed := applySessionSet(t, &SessionSetCheck{Upstream: c, Report: true}, args)
+
+	// the settings set are read back, in order
+	var got jrpc.Request
+	if bodies := up.Bodies(); len(bodies) != 1 || json.Unmarshal([]byte(bodies[0]), &got) != nil || got.Method != "session-get" ||
+		!reflect.DeepEqual(got.Arguments["fields"], []any{"download-dir", "peer-port", "seedRatioLimit", "speed-limit-down", "speed-limit-down-enabled"}) {
+		t.Fatalf("got requests %q", up.Bodies())
+	}
+
+	// only the setting reported differently is listed, the one not reported is skipped
+	want := map[string]any{"download-dir": map[string]any{"requested": "/downloads", "actual": "/data"}}
+	if !changed || !reflect.DeepEqual(resp.Arguments[argSessionMismatch], want) {
+		t.Errorf("got changed %v, response arguments %v", changed, resp.Arguments)
+	}
+	if !strings.Contains(logs.String(), `"msg":"session-set succeeded but 1 settings differ when read back"`) ||
+		!strings.Contains(logs.String(), `"fields":["download-dir"]`) {
+		t.Errorf("mismatch not logged:\n%s", logs)
+	}
+}
+
+func TestSessionSetCheckNotReported(t *testing.T) {
+	logs := captureLog(t)
+	c, _ := sessionDaemon(http.StatusOK, `{"peer-port":1}`)
+
+	// without Report the mismatch is logged only
+	resp, changed := applySessionSet(t, &SessionSetCheck{Upstream: c}, `{"peer-port":51413}`)
+	if changed || resp.Arguments[argSessionMismatch] != nil || !strings.Contains(logs.String(), "settings differ") {
+		t.Errorf("got changed %v, response arguments %v, log:\n%s", changed, resp.Arguments, logs)
+	}
+
+	// settings applied are not logged
+	logs.Reset()
+	c, _ = sessionDaemon(http.StatusOK, `{"peer-port":51413}`)
+	if _, changed := applySessionSet(t, &SessionSetCheck{Upstream: c, Report: true}, `{"peer-port":51413}`); changed || logs.Len() != 0 {
+		t.Errorf("got changed %v, log:\n%s", changed, logs)
+	}
+}
+
+func TestSessionSetCheckReadFails(t *testing.T) {
+	failing, _ := sessionDaemon(http.StatusServiceUnavailable, `{}`)
+	refusing := &upstream.Client{URL: failing.URL, HTTP: &http.Client{Transport: &transmissiontest.Server{
+		RPC: func(w http.ResponseWriter, _ *http.Request, _ *jrpc.Request) {
+			_, _ = w.Write([]byte(`{"arguments":{},"result":"no permission"}`))
+		},
+	}}}
+
+	cases := []struct {
+		name string
+		c    *upstream.Client
+		want string
+	}{
+		{name: "status", c: failing, want: "upstream answered with status 503"},
+		{name: "result", c: refusing, want: "session-get failed: no permission"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			logs := captureLog(t)
+
+			// the settings were changed regardless, so the response is passed on as is
+			resp, changed := applySessionSet(t, &SessionSetCheck{Upstream: tc.c, Report: true}, `{"peer-port":51413}`)
+			if changed || len(resp.Arguments) != 0 {
+				t.Errorf("got changed %v, response arguments %v", changed, resp.Arguments)
+			}
+			if !strings.Contains(logs.String(), `"level":"WARN","msg":"cannot read back session-set settings: `+tc.want+`"`) {
+				t.Errorf("failure not logged:\n%s", logs)
+			}
+		})
+	}
+}
+
+func TestSessionSetCheckOtherRequests(t *testing.T) {
+	c, up := sessionDaemon(http.StatusOK, `{}`)
+	check := &SessionSetCheck{Upstream: c, Report: true}
+
+	for _, body := range []string{`{"method":"session-get"}`, `{"method":"session-set","arguments":{}}`, `{"method":"torrent-set","arguments":{"ids":[1]}}`} {
+		var req *jrpc.Request
+		if err := json.Unmarshal([]byte(body), &req); err != nil {
+			t.Fatal(err)
+		}
+		if sanitized, rw, err := check.Apply(context.Background(), req); sanitized != req || rw != nil || err != nil {
+			t.Errorf("%s: got rewriter %v, error %v", body, rw != nil, err)
+		}
+	}
+	if n := len(up.Requests()); n != 0 {
+		t.Errorf("got %d requests to the daemon", n)
+	}
+}
@@ -0,0 +1,59 @@
+package policy
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"transmission-proxy/internal/jrpc"
+)
+
+// Shadow runs the policy without enforcing it: the request is passed on unchanged, and violations or
+// changes the policy would have made are reported instead. The response rewriter of the policy is not run,
+// as rewriters enforce the policy on responses and make changes upstream (e.g. assign bandwidth groups);
+// this also means shadowed policies keep no records, such as ownership of added torrents.
+type Shadow struct {
+	// Name is the policy name used in reports, the same its violations carry.
+	Name   string
+	Policy Policy
+	// Report is called with the violation the policy would have rejected the request with, or with
+	// the violation describing how the policy would have changed the request (then changed is set).
+	Report func(ctx context.Context, req *jrpc.Request, v *Violation, changed bool)
+}
+
+func (s *Shadow) Apply(ctx context.Context, req *jrpc.Request) (*jrpc.Request, ResponseRewriter, error) {
+	res, _, err := s.Policy.Apply(ctx, req)
+	var violation *Violation
+	if errors.As(err, &violation) {
+		s.Report(ctx, req, violation, false)
+		return req, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if v := ChangeViolation(s.Name, req, res); v != nil {
+		s.Report(ctx, req, v, true)
+	}
+
+	return req, nil, nil
+}
+
+// ChangeViolation describes the arguments changed by the check in shadow mode, or returns nil if the request
+// was not changed.
+func ChangeViolation(name string, orig, changed *jrpc.Request) *Violation {
+	dropped, rewritten := jrpc.DiffArguments(orig, changed)
+
+	var changes []string
+	if len(dropped) > 0 {
+		changes = append(changes, "drop "+strings.Join(dropped, ", "))
+	}
+	if len(rewritten) > 0 {
+		changes = append(changes, "rewrite "+strings.Join(rewritten, ", "))
+	}
+	if changes == nil {
+		return nil
+	}
+
+	return &Violation{Policy: name, Reason: "would " + strings.Join(changes, " and ") + " in request"}
+}
@@ -0,0 +1,89 @@
+package policy
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"transmission-proxy/internal/jrpc"
+)
+
+// policyFunc is a policy without response rewriting.
+type policyFunc func(req *jrpc.Request) (*jrpc.Request, error)
+
+func (f policyFunc) Apply(_ context.Context, req *jrpc.Request) (*jrpc.Request, ResponseRewriter, error) {
+	res, err := f(req)
+	return res, func(*jrpc.Response) (bool, error) { return true, nil }, err
+}
+
+type report struct {
+	violation *Violation
+	changed   bool
+}
+
+func testShadow(p Policy) (*Shadow, *[]report) {
+	var reports []report
+	return &Shadow{
+		Name:   "user_subdir",
+		Policy: p,
+		Report: func(_ context.Context, _ *jrpc.Request, v *Violation, changed bool) {
+			reports = append(reports, report{violation: v, changed: changed})
+		},
+	}, &reports
+}
+
+func TestShadow(t *testing.T) {
+	req := &jrpc.Request{Method: "torrent-add", Arguments: map[string]any{"download-dir": "/downloads/x", "paused": true}}
+
+	cases := []struct {
+		name   string
+		policy policyFunc
+		// reason is the reported violation, empty if nothing is reported
+		reason  string
+		changed bool
+	}{
+		{name: "allowed", policy: func(req *jrpc.Request) (*jrpc.Request, error) { return req, nil }},
+		{name: "rejected", reason: "forbidden", policy: func(*jrpc.Request) (*jrpc.Request, error) {
+			return nil, &Violation{Policy: "user_subdir", Reason: "forbidden"}
+		}},
+		{name: "changed", reason: "would drop paused and rewrite download-dir in request", changed: true,
+			policy: func(req *jrpc.Request) (*jrpc.Request, error) {
+				res := clone(req)
+				delete(res.Arguments, "paused")
+				res.Arguments["download-dir"] = "/downloads/alice/x"
+				return res, nil
+			}},
+	}
+
+	for _, tc := range cases {
+		s, reports := testShadow(tc.policy)
+		got, rw, err := s.Apply(context.Background(), req)
+		if err != nil || got != req || rw != nil {
+			t.Errorf("%s: got %v, %v, want the request passed on as is", tc.name, got, err)
+		}
+
+		switch {
+		case tc.reason == "" && len(*reports) != 0:
+			t.Errorf("%s: got reports %v", tc.name, *reports)
+		case tc.reason == "":
+		case len(*reports) != 1:
+			t.Errorf("%s: got %d reports, want 1", tc.name, len(*reports))
+		default:
+			r := (*reports)[0]
+			if r.violation.Policy != "user_subdir" || r.violation.Reason != tc.reason || r.changed != tc.changed {
+				t.Errorf("%s: got report %+v, %v", tc.name, r.violation, r.changed)
+			}
+		}
+	}
+}
+
+func TestShadowFailure(t *testing.T) {
+	s, reports := testShadow(policyFunc(func(*jrpc.Request) (*jrpc.Request, error) {
+		return nil, errors.New("upstream down")
+	}))
+
+	// a policy which cannot be checked fails the request in shadow mode too
+	if _, _, err := s.Apply(context.Background(), &jrpc.Request{Method: "torrent-get"}); err == nil || len(*reports) != 0 {
+		t.Errorf("got error %v, reports %v", err, *reports)
+	}
+}
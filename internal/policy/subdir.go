@@ -0,0 +1,140 @@
+package policy
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"transmission-proxy/internal/apikeys"
+	"transmission-proxy/internal/jrpc"
+	"transmission-proxy/internal/reqctx"
+	"transmission-proxy/internal/roles"
+	"transmission-proxy/internal/transmission"
+)
+
+const fieldDownloadDir = "downloadDir"
+
+// subdirPaths lists the path arguments rewritten by UserSubdir per method.
+var subdirPaths = map[string]string{
+	"torrent-add":          "download-dir",
+	"torrent-set":          "location",
+	"torrent-set-location": "location",
+	"free-space":           "path",
+}
+
+// UserSubdir jails users into their own directories under Prefix: paths sent by user "alice" as
+// "<Prefix>movies" are forwarded as "<Prefix>alice/movies", and the user segment is removed from paths
+// in the responses. Paths with another user's segment or with ".." are rejected. Admins are not affected.
+// Requests made with API keys having their own download prefix are jailed under that prefix instead.
+type UserSubdir struct {
+	Prefix string
+	Roles  *roles.Roles
+	// Exists reports whether the name is of a user.
+	Exists func(user string) bool
+}
+
+func (s *UserSubdir) Apply(ctx context.Context, req *jrpc.Request) (*jrpc.Request, ResponseRewriter, error) {
+	user := reqctx.User(ctx)
+	if user == "" || s.Roles.IsAdmin(user) {
+		return req, nil, nil
+	}
+
+	prefix := s.Prefix
+	if k := apikeys.FromContext(ctx); k != nil && k.DownloadPrefix != "" {
+		prefix = k.DownloadPrefix
+	}
+	jail := prefix + user + "/"
+
+	switch req.Method {
+	case "torrent-get":
+		return req, func(resp *jrpc.Response) (bool, error) {
+			raw, ok := resp.Arguments[argTorrents]
+			if !ok {
+				return false, nil
+			}
+
+			torrents, err := transmission.ParseTorrents(raw)
+			if err != nil {
+				return false, err
+			}
+
+			for i := 0; i < torrents.Len(); i++ {
+				dir := torrents.String(i, fieldDownloadDir)
+				if s := strip(dir, prefix, jail); s != dir {
+					torrents.Set(i, fieldDownloadDir, s)
+				}
+			}
+			if !torrents.Changed() {
+				return false, nil
+			}
+
+			resp.Arguments[argTorrents] = torrents.Value()
+			return true, nil
+		}, nil
+	case "free-space":
+		req, err := s.inject(req, prefix, user)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return req, func(resp *jrpc.Response) (bool, error) {
+			p, ok := resp.Arguments["path"].(string)
+			if !ok {
+				return false, nil
+			}
+			if s := strip(p, prefix, jail); s != p {
+				resp.Arguments["path"] = s
+				return true, nil
+			}
+
+			return false, nil
+		}, nil
+	}
+
+	req, err := s.inject(req, prefix, user)
+	return req, nil, err
+}
+
+// inject adds the user segment to the path argument of the request, if there is one.
+func (s *UserSubdir) inject(req *jrpc.Request, prefix, user string) (*jrpc.Request, error) {
+	arg, ok := subdirPaths[req.Method]
+	if !ok {
+		return req, nil
+	}
+
+	p, ok := req.Arguments[arg].(string)
+	if !ok {
+		return req, nil
+	}
+
+	rest, ok := strings.CutPrefix(p, prefix)
+	if !ok {
+		return nil, &Violation{Policy: "user_subdir", Reason: fmt.Sprintf("%s must begin with %s", arg, prefix)}
+	}
+
+	segments := strings.Split(rest, "/")
+	for _, seg := range segments {
+		if seg == ".." {
+			return nil, &Violation{Policy: "user_subdir", Reason: fmt.Sprintf("%s must not contain ..", arg)}
+		}
+	}
+	if first := segments[0]; first != user && s.Exists(first) {
+		return nil, &Violation{Policy: "user_subdir", Reason: fmt.Sprintf("%s points to directory of another user", arg)}
+	}
+
+	req = clone(req)
+	req.Arguments[arg] = prefix + user + "/" + rest
+	return req, nil
+}
+
+// strip removes the user segment from the path in the response.
+func strip(p, prefix, jail string) string {
+	if rest, ok := strings.CutPrefix(p, jail); ok {
+		return prefix + rest
+	}
+	if p == strings.TrimSuffix(jail, "/") {
+		return strings.TrimSuffix(prefix, "/")
+	}
+
+	return p
+}
@@ -0,0 +1,161 @@
+package policy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"transmission-proxy/internal/apikeys"
+	"transmission-proxy/internal/jrpc"
+	"transmission-proxy/internal/reqctx"
+	"transmission-proxy/internal/roles"
+)
+
+func testUserSubdir() *UserSubdir {
+	return &UserSubdir{
+		Prefix: "/downloads/",
+		Roles:  roles.New([]string{"root"}),
+		Exists: func(user string) bool { return user == "alice" || user == "bob" || user == "root" },
+	}
+}
+
+// roundTrip applies the policy to the request of the user, returning the forwarded request and the upstream response
+// as the user gets it.
+func roundTrip(t *testing.T, s *UserSubdir, ctx context.Context, req *jrpc.Request, upstreamResponse string) (*jrpc.Request, map[string]any) {
+	t.Helper()
+
+	got, rw, err := s.Apply(ctx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var resp jrpc.Response
+	if err := json.Unmarshal([]byte(upstreamResponse), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if rw != nil {
+		if _, err := rw(&resp); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return got, resp.Arguments
+}
+
+func asUser(user string) context.Context {
+	return reqctx.WithUser(context.Background(), user)
+}
+
+func TestUserSubdirRoundTrip(t *testing.T) {
+	s := testUserSubdir()
+
+	for _, user := range []string{"alice", "bob"} {
+		t.Run(user, func(t *testing.T) {
+			add := &jrpc.Request{Method: "torrent-add", Arguments: map[string]any{"filename": "x.torrent", "download-dir": "/downloads/movies"}}
+			got, _ := roundTrip(t, s, asUser(user), add, `{"result":"success","arguments":{}}`)
+			if dir := got.Arguments["download-dir"]; dir != "/downloads/"+user+"/movies" {
+				t.Errorf("forwarded download-dir %v", dir)
+			}
+			if add.Arguments["download-dir"] != "/downloads/movies" {
+				t.Errorf("original request was modified: %v", add.Arguments)
+			}
+
+			for _, method := range []string{"torrent-set", "torrent-set-location"} {
+				req := &jrpc.Request{Method: method, Arguments: map[string]any{"ids": []any{1}, "location": "/downloads/tv/show"}}
+				got, _ := roundTrip(t, s, asUser(user), req, `{"result":"success","arguments":{}}`)
+				if loc := got.Arguments["location"]; loc != "/downloads/"+user+"/tv/show" {
+					t.Errorf("%s forwarded location %v", method, loc)
+				}
+			}
+
+			// the user sees the paths as they sent them
+			get := &jrpc.Request{Method: "torrent-get", Arguments: map[string]any{"fields": []any{"id", "downloadDir"}}}
+			_, args := roundTrip(t, s, asUser(user), get, `{"result":"success","arguments":{"torrents":[`+
+				`{"id":1,"downloadDir":"/downloads/`+user+`/movies"},{"id":2,"downloadDir":"/downloads/`+user+`"},`+
+				`{"id":3,"downloadDir":"/srv/elsewhere"}]}}`)
+			bs, _ := json.Marshal(args["torrents"])
+			if want := `[{"downloadDir":"/downloads/movies","id":1},{"downloadDir":"/downloads","id":2},` +
+				`{"downloadDir":"/srv/elsewhere","id":3}]`; string(bs) != want {
+				t.Errorf("got torrents %s, want %s", bs, want)
+			}
+
+			_, args = roundTrip(t, s, asUser(user), get, `{"result":"success","arguments":{"torrents":[`+
+				`["id","downloadDir"],[1,"/downloads/`+user+`/movies"]]}}`)
+			if bs, _ := json.Marshal(args["torrents"]); string(bs) != `[["id","downloadDir"],[1,"/downloads/movies"]]` {
+				t.Errorf("got table %s", bs)
+			}
+
+			free := &jrpc.Request{Method: "free-space", Arguments: map[string]any{"path": "/downloads/movies"}}
+			got, args = roundTrip(t, s, asUser(user), free,
+				`{"result":"success","arguments":{"path":"/downloads/`+user+`/movies","size-bytes":1024}}`)
+			if got.Arguments["path"] != "/downloads/"+user+"/movies" || args["path"] != "/downloads/movies" {
+				t.Errorf("free-space forwarded %v, answered %v", got.Arguments["path"], args["path"])
+			}
+		})
+	}
+}
+
+func TestUserSubdirRejected(t *testing.T) {
+	cases := []struct {
+		name, method, arg, path, reason string
+	}{
+		{name: "other user", method: "torrent-add", arg: "download-dir", path: "/downloads/bob/movies",
+			reason: "download-dir points to directory of another user"},
+		{name: "other user exactly", method: "torrent-set-location", arg: "location", path: "/downloads/bob",
+			reason: "location points to directory of another user"},
+		{name: "admin directory", method: "free-space", arg: "path", path: "/downloads/root/",
+			reason: "path points to directory of another user"},
+		{name: "escape", method: "torrent-add", arg: "download-dir", path: "/downloads/movies/../../bob",
+			reason: "download-dir must not contain .."},
+		{name: "outside prefix", method: "torrent-set", arg: "location", path: "/srv/movies",
+			reason: "location must begin with /downloads/"},
+	}
+
+	s := testUserSubdir()
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := &jrpc.Request{Method: tc.method, Arguments: map[string]any{"ids": []any{1}, tc.arg: tc.path}}
+			_, _, err := s.Apply(asUser("alice"), req)
+
+			var v *Violation
+			if !errors.As(err, &v) || v.Policy != "user_subdir" || v.Reason != tc.reason {
+				t.Errorf("got error %v, want %q", err, tc.reason)
+			}
+		})
+	}
+}
+
+func TestUserSubdirNotJailed(t *testing.T) {
+	s := testUserSubdir()
+	req := &jrpc.Request{Method: "torrent-add", Arguments: map[string]any{"download-dir": "/downloads/bob/movies"}}
+
+	for _, ctx := range []context.Context{asUser("root"), context.Background()} {
+		if got, rw, err := s.Apply(ctx, req); err != nil || got != req || rw != nil {
+			t.Errorf("got %v, %v", got, err)
+		}
+	}
+
+	// names which are not of users are ordinary directories
+	req = &jrpc.Request{Method: "torrent-add", Arguments: map[string]any{"download-dir": "/downloads/carol"}}
+	if got, _, err := s.Apply(asUser("alice"), req); err != nil || got.Arguments["download-dir"] != "/downloads/alice/carol" {
+		t.Errorf("got %v, %v", got.Arguments, err)
+	}
+}
+
+func TestUserSubdirAPIKeyPrefix(t *testing.T) {
+	s := testUserSubdir()
+	ctx := apikeys.WithKey(asUser("sonarr"), &apikeys.Key{Name: "sonarr", DownloadPrefix: "/downloads/tv/"})
+
+	req := &jrpc.Request{Method: "torrent-add", Arguments: map[string]any{"download-dir": "/downloads/tv/show"}}
+	got, _ := roundTrip(t, s, ctx, req, `{"result":"success","arguments":{}}`)
+	if dir := got.Arguments["download-dir"]; dir != "/downloads/tv/sonarr/show" {
+		t.Errorf("forwarded download-dir %v", dir)
+	}
+
+	req = &jrpc.Request{Method: "torrent-add", Arguments: map[string]any{"download-dir": "/downloads/movies"}}
+	if _, _, err := s.Apply(ctx, req); err == nil || !strings.Contains(err.Error(), "must begin with /downloads/tv/") {
+		t.Errorf("got %v, want the key prefix enforced", err)
+	}
+}
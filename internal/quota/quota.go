@@ -0,0 +1,95 @@
+package quota
+
+import (
+	"context"
+	"strings"
+	"transmission-proxy/internal/ownership"
+	"transmission-proxy/internal/upstream"
+)
+
+// Fields are the torrent fields usage is computed from.
+var Fields = []string{"hashString", "totalSize"}
+
+// Limits of a user, zero means unlimited.
+type Limits struct {
+	Bytes    int64 `yaml:"bytes" json:"bytes,omitempty"`
+	Torrents int   `yaml:"torrents" json:"torrents,omitempty"`
+}
+
+// Usage of a user: the torrents owned according to the ownership store and their total size.
+type Usage struct {
+	Bytes    int64 `json:"bytes"`
+	Torrents int   `json:"torrents"`
+}
+
+// Report combines limits, usage and the remaining headroom.
+type Report struct {
+	User      string    `json:"user"`
+	Limits    Limits    `json:"limits"`
+	Usage     Usage     `json:"usage"`
+	Remaining Remaining `json:"remaining"`
+	// Exceeded is set if the user may not add more torrents.
+	Exceeded bool `json:"exceeded"`
+}
+
+// Remaining headroom, nil if unlimited.
+type Remaining struct {
+	Bytes    *int64 `json:"bytes"`
+	Torrents *int   `json:"torrents"`
+}
+
+// Calculator computes usage of the users from the ownership store and the sizes of torrents in the list.
+type Calculator struct {
+	Store    ownership.Store
+	Torrents *upstream.TorrentList
+}
+
+// Usage computes the current usage of the user.
+func (c *Calculator) Usage(ctx context.Context, user string) (*Usage, error) {
+	records, err := c.Store.All()
+	if err != nil {
+		return nil, err
+	}
+
+	torrents, err := c.Torrents.Get(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	u := &Usage{}
+	// torrents removed upstream but still in the store do not count
+	for i := 0; i < torrents.Len(); i++ {
+		hash := torrents.String(i, "hashString")
+		if e := records[strings.ToLower(hash)]; e == nil || e.User != user {
+			continue
+		}
+
+		u.Torrents++
+		u.Bytes += torrents.Int64(i, "totalSize")
+	}
+
+	return u, nil
+}
+
+// Check returns the report of the user's usage against the limits. Quota enforcement and reporting
+// both use it, so that they agree.
+func (c *Calculator) Check(ctx context.Context, user string, limits Limits) (*Report, error) {
+	u, err := c.Usage(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &Report{User: user, Limits: limits, Usage: *u}
+	if limits.Bytes > 0 {
+		b := max(limits.Bytes-u.Bytes, 0)
+		r.Remaining.Bytes = &b
+		r.Exceeded = r.Exceeded || b == 0
+	}
+	if limits.Torrents > 0 {
+		t := max(limits.Torrents-u.Torrents, 0)
+		r.Remaining.Torrents = &t
+		r.Exceeded = r.Exceeded || t == 0
+	}
+
+	return r, nil
+}
@@ -0,0 +1,95 @@
+package quota
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"transmission-proxy/internal/ownership"
+	"transmission-proxy/internal/upstream"
+)
+
+// torrentsUpstream answers torrent-get with the torrents.
+type torrentsUpstream []map[string]any
+
+func (t torrentsUpstream) RoundTrip(*http.Request) (*http.Response, error) {
+	w := httptest.NewRecorder()
+	_ = json.NewEncoder(w).Encode(map[string]any{"result": "success", "arguments": map[string]any{"torrents": t}})
+
+	return w.Result(), nil
+}
+
+// testCalculator returns the calculator of alice owning torrents a (1000 bytes) and b (500 bytes), bob owning c
+// and alice owning removed d according to the store.
+func testCalculator(t *testing.T) *Calculator {
+	store, err := ownership.Open(filepath.Join(t.TempDir(), "ownership.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+
+	for hash, user := range map[string]string{"aaaa": "alice", "bbbb": "alice", "cccc": "bob", "dddd": "alice"} {
+		if err := store.Put(hash, &ownership.Entry{User: user}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	up := torrentsUpstream{
+		{"hashString": "aaaa", "totalSize": 1000},
+		// hashes are matched regardless of case
+		{"hashString": "BBBB", "totalSize": 500},
+		{"hashString": "cccc", "totalSize": 7000},
+		{"hashString": "eeee", "totalSize": 9000},
+	}
+	client := &upstream.Client{URL: "http://transmission:9091/transmission/rpc", HTTP: &http.Client{Transport: up}}
+
+	return &Calculator{Store: store, Torrents: &upstream.TorrentList{Client: client, Fields: Fields, TTL: time.Minute}}
+}
+
+func TestCheck(t *testing.T) {
+	calc := testCalculator(t)
+
+	cases := []struct {
+		name   string
+		user   string
+		limits Limits
+		usage  Usage
+		// remaining bytes and torrents, -1 if unlimited
+		bytes    int64
+		torrents int
+		exceeded bool
+	}{
+		{name: "unlimited", user: "alice", usage: Usage{Bytes: 1500, Torrents: 2}, bytes: -1, torrents: -1},
+		{name: "headroom", user: "alice", limits: Limits{Bytes: 2000, Torrents: 5}, usage: Usage{Bytes: 1500, Torrents: 2},
+			bytes: 500, torrents: 3},
+		{name: "bytes exceeded", user: "alice", limits: Limits{Bytes: 1000}, usage: Usage{Bytes: 1500, Torrents: 2},
+			bytes: 0, torrents: -1, exceeded: true},
+		{name: "torrents reached", user: "alice", limits: Limits{Torrents: 2}, usage: Usage{Bytes: 1500, Torrents: 2},
+			bytes: -1, torrents: 0, exceeded: true},
+		{name: "other user", user: "bob", limits: Limits{Bytes: 10000}, usage: Usage{Bytes: 7000, Torrents: 1},
+			bytes: 3000, torrents: -1},
+		{name: "no torrents", user: "carol", limits: Limits{Torrents: 1}, bytes: -1, torrents: 1},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			r, err := calc.Check(context.Background(), tc.user, tc.limits)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if r.User != tc.user || r.Limits != tc.limits || r.Usage != tc.usage || r.Exceeded != tc.exceeded {
+				t.Errorf("got report %+v", r)
+			}
+			if bytes := r.Remaining.Bytes; (bytes == nil) != (tc.bytes < 0) || bytes != nil && *bytes != tc.bytes {
+				t.Errorf("got remaining bytes %v, want %d", bytes, tc.bytes)
+			}
+			if torrents := r.Remaining.Torrents; (torrents == nil) != (tc.torrents < 0) || torrents != nil && *torrents != tc.torrents {
+				t.Errorf("got remaining torrents %v, want %d", torrents, tc.torrents)
+			}
+		})
+	}
+}
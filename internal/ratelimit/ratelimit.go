@@ -0,0 +1,100 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// Bucket is a token bucket allowing Rate requests per second on average with bursts up to Burst requests.
+type Bucket struct {
+	Rate  float64
+	Burst int
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+func NewBucket(rate float64, burst int) *Bucket {
+	if burst < 1 {
+		burst = 1
+	}
+
+	return &Bucket{Rate: rate, Burst: burst, tokens: float64(burst)}
+}
+
+// Allow takes a token from the bucket if there is one. Otherwise it returns false and the time
+// until the next token becomes available.
+func (b *Bucket) Allow() (ok bool, retryAfter time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	if !b.last.IsZero() {
+		b.tokens = min(b.tokens+now.Sub(b.last).Seconds()*b.Rate, float64(b.Burst))
+	}
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+
+	return false, time.Duration((1 - b.tokens) / b.Rate * float64(time.Second))
+}
+
+// minSweepInterval keeps Keyed from scanning all the buckets too often.
+const minSweepInterval = time.Minute
+
+// Keyed keeps a bucket per key, e.g. client address. Buckets idle long enough to be full again
+// are dropped, as they are no different from new ones.
+type Keyed struct {
+	Rate  float64
+	Burst int
+
+	mu        sync.Mutex
+	buckets   map[string]*Bucket
+	lastSweep time.Time
+}
+
+func NewKeyed(rate float64, burst int) *Keyed {
+	return &Keyed{Rate: rate, Burst: max(burst, 1), buckets: map[string]*Bucket{}}
+}
+
+// Allow takes a token from the bucket of the key, see Bucket.Allow.
+func (k *Keyed) Allow(key string) (ok bool, retryAfter time.Duration) {
+	k.mu.Lock()
+	now := time.Now()
+	if now.Sub(k.lastSweep) > max(k.idle(), minSweepInterval) {
+		k.sweep(now)
+	}
+
+	b, found := k.buckets[key]
+	if !found {
+		b = NewBucket(k.Rate, k.Burst)
+		k.buckets[key] = b
+	}
+	k.mu.Unlock()
+
+	return b.Allow()
+}
+
+// idle is the time after which unused bucket is full again.
+func (k *Keyed) idle() time.Duration {
+	return time.Duration(float64(k.Burst) / k.Rate * float64(time.Second))
+}
+
+func (k *Keyed) sweep(now time.Time) {
+	idle := k.idle()
+	for key, b := range k.buckets {
+		b.mu.Lock()
+		last := b.last
+		b.mu.Unlock()
+
+		if now.Sub(last) > idle {
+			delete(k.buckets, key)
+		}
+	}
+
+	k.lastSweep = now
+}
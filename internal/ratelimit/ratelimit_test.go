@@ -0,0 +1,68 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBucket(t *testing.T) {
+	b := NewBucket(1, 3)
+
+	// the burst is available at once
+	for i := 0; i < 3; i++ {
+		if ok, _ := b.Allow(); !ok {
+			t.Fatalf("request %d refused", i+1)
+		}
+	}
+	ok, retryAfter := b.Allow()
+	if ok || retryAfter <= 0 || retryAfter > time.Second {
+		t.Errorf("over the burst: got %v, retry after %s", ok, retryAfter)
+	}
+
+	// tokens come back at the rate, up to the burst
+	b.last = b.last.Add(-2 * time.Second)
+	for i := 0; i < 2; i++ {
+		if ok, _ := b.Allow(); !ok {
+			t.Fatalf("request %d after 2 seconds refused", i+1)
+		}
+	}
+	if ok, _ := b.Allow(); ok {
+		t.Error("got more tokens than the rate gives")
+	}
+
+	b.last = b.last.Add(-time.Hour)
+	b.Allow()
+	if b.tokens != 2 {
+		t.Errorf("got %v tokens left of the burst of 3", b.tokens)
+	}
+}
+
+func TestKeyed(t *testing.T) {
+	k := NewKeyed(1, 1)
+
+	if ok, _ := k.Allow("192.0.2.1"); !ok {
+		t.Fatal("first request refused")
+	}
+	if ok, _ := k.Allow("192.0.2.1"); ok {
+		t.Error("request over the limit allowed")
+	}
+	// every key has a bucket of its own
+	if ok, _ := k.Allow("192.0.2.2"); !ok {
+		t.Error("request of other key refused")
+	}
+}
+
+func TestKeyedSweep(t *testing.T) {
+	k := NewKeyed(10, 1)
+	k.Allow("192.0.2.1")
+	k.Allow("192.0.2.2")
+
+	// the bucket idle long enough to be full is dropped on the next sweep, the busy one is kept
+	k.buckets["192.0.2.1"].last = time.Now().Add(-time.Second)
+	k.lastSweep = time.Now().Add(-2 * minSweepInterval)
+	k.Allow("192.0.2.2")
+
+	if _, found := k.buckets["192.0.2.1"]; found || len(k.buckets) != 1 {
+		t.Errorf("got buckets %v after sweep", k.buckets)
+	}
+}
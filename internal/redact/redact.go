@@ -0,0 +1,91 @@
+package redact
+
+import (
+	"encoding/json"
+	"fmt"
+	"unicode/utf8"
+)
+
+// DefaultFields lists RPC arguments which must never appear in logs verbatim.
+var DefaultFields = []string{"cookies", "metainfo"}
+
+// Redactor replaces values of sensitive RPC arguments before request bodies are logged or stored.
+type Redactor struct {
+	fields map[string]struct{}
+}
+
+func New(fields []string) *Redactor {
+	r := &Redactor{fields: make(map[string]struct{}, len(fields))}
+	for _, f := range fields {
+		r.fields[f] = struct{}{}
+	}
+
+	return r
+}
+
+// Body redacts sensitive arguments in the raw RPC request body. Bodies which cannot be parsed
+// are replaced entirely, since there is no way to tell which parts of them are sensitive.
+func (r *Redactor) Body(bs []byte) []byte {
+	var req map[string]json.RawMessage
+	if err := json.Unmarshal(bs, &req); err != nil {
+		return []byte(fmt.Sprintf("[unparseable body, %d bytes]", len(bs)))
+	}
+
+	var args map[string]json.RawMessage
+	if raw, ok := req["arguments"]; !ok || json.Unmarshal(raw, &args) != nil {
+		return bs
+	}
+
+	if !r.Arguments(args) {
+		return bs
+	}
+
+	req["arguments"], _ = json.Marshal(args)
+	res, err := json.Marshal(req)
+	if err != nil {
+		return []byte(fmt.Sprintf("[unserializable body, %d bytes]", len(bs)))
+	}
+
+	return res
+}
+
+// Arguments replaces sensitive values in the arguments map in place and reports whether anything changed.
+func (r *Redactor) Arguments(args map[string]json.RawMessage) bool {
+	changed := false
+	for key, val := range args {
+		if _, ok := r.fields[key]; ok {
+			args[key], _ = json.Marshal(fmt.Sprintf("[redacted, %d bytes]", len(val)))
+			changed = true
+		}
+	}
+
+	return changed
+}
+
+// Map returns copy of the decoded arguments with sensitive values replaced.
+func (r *Redactor) Map(args map[string]any) map[string]any {
+	res := make(map[string]any, len(args))
+	for key, val := range args {
+		if _, ok := r.fields[key]; ok {
+			res[key] = "[redacted]"
+		} else {
+			res[key] = val
+		}
+	}
+
+	return res
+}
+
+// Truncate cuts the body to at most max bytes, marking the cut.
+func Truncate(bs []byte, max int) string {
+	if max <= 0 || len(bs) <= max {
+		return string(bs)
+	}
+
+	cut := max
+	for cut > 0 && !utf8.RuneStart(bs[cut]) {
+		cut--
+	}
+
+	return fmt.Sprintf("%s...[truncated, %d bytes total]", bs[:cut], len(bs))
+}
@@ -0,0 +1,93 @@
+package redact
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestBody(t *testing.T) {
+	r := New(DefaultFields)
+
+	cases := []struct {
+		name, in string
+		// want lists arguments of the result, nil if the body should be returned as is
+		want map[string]any
+		// replaced is the whole result for bodies which are not JSON
+		replaced string
+	}{
+		{name: "nothing sensitive", in: `{"method":"torrent-get","arguments":{"ids":[1]}}`},
+		{name: "no arguments", in: `{"method":"session-get"}`},
+		{name: "sensitive", in: `{"method":"torrent-add","arguments":{"metainfo":"ZDg6YW5ub3VuY2U=","cookies":"a=b","paused":true}}`,
+			want: map[string]any{"metainfo": "[redacted, 18 bytes]", "cookies": "[redacted, 5 bytes]", "paused": true}},
+		{name: "not json", in: `{"method":`, replaced: "[unparseable body, 10 bytes]"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := string(r.Body([]byte(tc.in)))
+			switch {
+			case tc.replaced != "":
+				if got != tc.replaced {
+					t.Errorf("got %s, want %s", got, tc.replaced)
+				}
+			case tc.want == nil:
+				if got != tc.in {
+					t.Errorf("got %s, want body as is", got)
+				}
+			default:
+				var req struct {
+					Method    string         `json:"method"`
+					Arguments map[string]any `json:"arguments"`
+				}
+				if err := json.Unmarshal([]byte(got), &req); err != nil {
+					t.Fatal(err)
+				}
+				if req.Method != "torrent-add" || len(req.Arguments) != len(tc.want) {
+					t.Fatalf("got %s", got)
+				}
+				for k, v := range tc.want {
+					if req.Arguments[k] != v {
+						t.Errorf("got %s = %v, want %v", k, req.Arguments[k], v)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestMap(t *testing.T) {
+	args := map[string]any{"cookies": "a=b", "ids": 1}
+	got := New(DefaultFields).Map(args)
+	if got["cookies"] != "[redacted]" || got["ids"] != 1 {
+		t.Errorf("got %v", got)
+	}
+	if args["cookies"] != "a=b" {
+		t.Error("arguments modified")
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	cases := []struct {
+		in   string
+		max  int
+		want string
+	}{
+		{in: "short", max: 10, want: "short"},
+		{in: "exact", max: 5, want: "exact"},
+		{in: "unlimited", max: 0, want: "unlimited"},
+		{in: "0123456789", max: 4, want: "0123...[truncated, 10 bytes total]"},
+		// the cut falls into the middle of a rune
+		{in: "ab" + "ф" + "cd", max: 3, want: "ab...[truncated, 6 bytes total]"},
+	}
+
+	for _, tc := range cases {
+		if got := Truncate([]byte(tc.in), tc.max); got != tc.want {
+			t.Errorf("Truncate(%q, %d) = %q, want %q", tc.in, tc.max, got, tc.want)
+		}
+	}
+
+	if got := Truncate([]byte(strings.Repeat("й", 10)), 7); got != strings.Repeat("й", 3)+"...[truncated, 20 bytes total]" {
+		t.Errorf("got %q", got)
+	}
+}
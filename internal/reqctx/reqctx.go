@@ -0,0 +1,131 @@
+package reqctx
+
+import (
+	"context"
+	"net/netip"
+
+	"transmission-proxy/internal/jrpc"
+)
+
+type clientIPKey struct{}
+
+func WithClientIP(ctx context.Context, ip netip.Addr) context.Context {
+	return context.WithValue(ctx, clientIPKey{}, ip)
+}
+
+// ClientIP returns the client address resolved by the clientip middleware, or zero address if it was not resolved.
+func ClientIP(ctx context.Context) netip.Addr {
+	if ip, ok := ctx.Value(clientIPKey{}).(netip.Addr); ok {
+		return ip
+	}
+
+	return netip.Addr{}
+}
+
+type userKey struct{}
+
+func WithUser(ctx context.Context, user string) context.Context {
+	return context.WithValue(ctx, userKey{}, user)
+}
+
+// User returns name of the authenticated user, or empty string for anonymous requests.
+// For impersonated requests this is the impersonated user.
+func User(ctx context.Context) string {
+	user, _ := ctx.Value(userKey{}).(string)
+	return user
+}
+
+type impersonatorKey struct{}
+
+// WithImpersonation makes the request act as user on behalf of the currently authenticated one.
+func WithImpersonation(ctx context.Context, user string) context.Context {
+	ctx = context.WithValue(ctx, impersonatorKey{}, User(ctx))
+	return WithUser(ctx, user)
+}
+
+// Impersonator returns name of the admin who impersonates User, or empty string.
+func Impersonator(ctx context.Context) string {
+	user, _ := ctx.Value(impersonatorKey{}).(string)
+	return user
+}
+
+type rpcPathKey struct{}
+
+func WithRPCPath(ctx context.Context, path string) context.Context {
+	return context.WithValue(ctx, rpcPathKey{}, path)
+}
+
+// RPCPath returns the RPC endpoint path the request arrived at, or empty string if there is only one.
+func RPCPath(ctx context.Context) string {
+	path, _ := ctx.Value(rpcPathKey{}).(string)
+	return path
+}
+
+type requestIDKey struct{}
+
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the ID correlating log records, responses and upstream requests of the request.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+type rpcCallKey struct{}
+
+// RPCCall is filled in by the RPC handler, so that middleware running before the request body is parsed
+// can learn what was called, and where it was sent.
+type RPCCall struct {
+	Method string
+	// Upstream is the host the request was sent to last, empty if it was not forwarded.
+	Upstream string
+}
+
+// WithRPCCall returns context in which SetRPCMethod and SetUpstream fill in the returned call.
+func WithRPCCall(ctx context.Context) (context.Context, *RPCCall) {
+	c := &RPCCall{}
+	return context.WithValue(ctx, rpcCallKey{}, c), c
+}
+
+// SetRPCMethod records the RPC method of the request, if anyone is interested.
+func SetRPCMethod(ctx context.Context, method string) {
+	if c, ok := ctx.Value(rpcCallKey{}).(*RPCCall); ok {
+		c.Method = method
+	}
+}
+
+// SetUpstream records the upstream host the request is sent to, if anyone is interested.
+func SetUpstream(ctx context.Context, host string) {
+	if c, ok := ctx.Value(rpcCallKey{}).(*RPCCall); ok {
+		c.Upstream = host
+	}
+}
+
+type forwardedRPCKey struct{}
+
+// WithForwardedRPC returns context of the request forwarding the RPC request, as sent upstream after
+// validation and policies.
+func WithForwardedRPC(ctx context.Context, req *jrpc.Request) context.Context {
+	return context.WithValue(ctx, forwardedRPCKey{}, req)
+}
+
+// ForwardedRPC returns the RPC request being forwarded, or nil if the request is not an RPC one.
+func ForwardedRPC(ctx context.Context) *jrpc.Request {
+	req, _ := ctx.Value(forwardedRPCKey{}).(*jrpc.Request)
+	return req
+}
+
+type htmlErrorsKey struct{}
+
+// WithHTMLErrors marks the request of the client preferring HTML error pages to JSON.
+func WithHTMLErrors(ctx context.Context) context.Context {
+	return context.WithValue(ctx, htmlErrorsKey{}, true)
+}
+
+// HTMLErrors reports whether errors should be answered with HTML page.
+func HTMLErrors(ctx context.Context) bool {
+	v, _ := ctx.Value(htmlErrorsKey{}).(bool)
+	return v
+}
@@ -0,0 +1,61 @@
+package response
+
+import (
+	"bytes"
+	"net/http"
+)
+
+// Buffer is http.ResponseWriter keeping the whole response in memory, so that it can be inspected
+// and rewritten before being sent to the client.
+type Buffer struct {
+	header         http.Header
+	status         int
+	upstreamStatus int
+	Body           bytes.Buffer
+}
+
+func NewBuffer() *Buffer {
+	return &Buffer{header: http.Header{}}
+}
+
+func (b *Buffer) Header() http.Header {
+	return b.header
+}
+
+func (b *Buffer) WriteHeader(status int) {
+	if b.status == 0 {
+		b.status = status
+	}
+}
+
+func (b *Buffer) Write(bs []byte) (int, error) {
+	if b.status == 0 {
+		b.status = http.StatusOK
+	}
+
+	return b.Body.Write(bs)
+}
+
+func (b *Buffer) Status() int {
+	return b.status
+}
+
+func (b *Buffer) SetUpstreamStatus(status int) {
+	b.upstreamStatus = status
+}
+
+func (b *Buffer) UpstreamStatus() int {
+	return b.upstreamStatus
+}
+
+// Send sends the buffered response with the given body to w.
+func (b *Buffer) Send(w http.ResponseWriter, body []byte) error {
+	for h, vals := range b.header {
+		w.Header()[h] = vals
+	}
+	w.Header().Del("Content-Length")
+
+	w.WriteHeader(b.status)
+	_, err := w.Write(body)
+	return err
+}
@@ -0,0 +1,87 @@
+package response
+
+import (
+	_ "embed"
+	"html/template"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"transmission-proxy/internal/reqctx"
+)
+
+//go:embed errorpage.html
+var errorPageHTML string
+
+// ErrorPageData is passed to the error page template.
+type ErrorPageData struct {
+	Status     int
+	StatusText string
+	// Message is the same as the result of JSON error responses, without the error ID.
+	Message   string
+	ErrorID   string
+	RequestID string
+	// Retry is set for errors of the upstream or the proxy, which are likely to pass.
+	Retry bool
+}
+
+// pageMessages explain the errors without message of their own to the users of the error page.
+var pageMessages = map[int]string{
+	http.StatusBadGateway:         "Transmission cannot be reached at the moment.",
+	http.StatusServiceUnavailable: "Transmission is not available at the moment.",
+	http.StatusGatewayTimeout:     "Transmission did not answer in time.",
+	http.StatusTooManyRequests:    "Too many requests were made, please slow down.",
+	http.StatusForbidden:          "You are not allowed to access this page.",
+	http.StatusNotFound:           "The page was not found.",
+}
+
+// LoadErrorPage parses the error page template from the file, or the built-in one if name is empty.
+func LoadErrorPage(name string) (*template.Template, error) {
+	text := errorPageHTML
+	if name != "" {
+		bs, err := os.ReadFile(name)
+		if err != nil {
+			return nil, err
+		}
+		text = string(bs)
+	}
+
+	return template.New("error").Parse(text)
+}
+
+// PrefersHTML reports whether the client prefers HTML to JSON according to the Accept header,
+// as browsers navigating to a page do.
+func PrefersHTML(r *http.Request) bool {
+	html, json := -1.0, -1.0
+	for _, item := range strings.Split(r.Header.Get("Accept"), ",") {
+		typ, params, _ := strings.Cut(strings.TrimSpace(item), ";")
+		q := 1.0
+		for _, p := range strings.Split(params, ";") {
+			if k, v, ok := strings.Cut(strings.TrimSpace(p), "="); ok && k == "q" {
+				// malformed quality counts as not acceptable
+				q, _ = strconv.ParseFloat(v, 64)
+			}
+		}
+
+		switch strings.ToLower(strings.TrimSpace(typ)) {
+		case "text/html":
+			html = max(html, q)
+		case "application/json":
+			json = max(json, q)
+		}
+	}
+
+	return html > 0 && html >= json
+}
+
+// WithHTMLErrors makes the errors of next be answered with the error page of the Responder when the client
+// prefers HTML, e.g. for the web interface opened in a browser.
+func WithHTMLErrors(next http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if PrefersHTML(r) {
+			r = r.WithContext(reqctx.WithHTMLErrors(r.Context()))
+		}
+		next.ServeHTTP(w, r)
+	}
+}
@@ -0,0 +1,191 @@
+package response
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"transmission-proxy/internal/logger"
+	"transmission-proxy/internal/reqctx"
+)
+
+func TestPrefersHTML(t *testing.T) {
+	for accept, want := range map[string]bool{
+		"text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8": true,
+		"TEXT/HTML":                               true,
+		"application/json, text/html;q=0.5":       false,
+		"text/html;q=0.9, application/json;q=0.9": true,
+		"application/json":                        false,
+		"*/*":                                     false,
+		"text/html;q=0":                           false,
+		"text/html;q=bogus":                       false,
+		"":                                        false,
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/transmission/web/", nil)
+		r.Header.Set("Accept", accept)
+		if got := PrefersHTML(r); got != want {
+			t.Errorf("%q: got %v, want %v", accept, got, want)
+		}
+	}
+}
+
+// loggedErrID returns the error ID of the only record logged.
+func loggedErrID(t *testing.T, logs *bytes.Buffer) string {
+	t.Helper()
+
+	var rec map[string]any
+	if err := json.Unmarshal(logs.Bytes(), &rec); err != nil {
+		t.Fatalf("bad record %s: %v", logs, err)
+	}
+	id, _ := rec[logger.GroupErr].(map[string]any)[logger.KeyID].(string)
+	if id == "" {
+		t.Fatalf("no error ID in record %v", rec)
+	}
+
+	return id
+}
+
+func TestErrorPage(t *testing.T) {
+	page, err := LoadErrorPage("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := &Responder{ErrorPage: page}
+
+	cases := []struct {
+		name   string
+		status int
+		err    error
+		// want are parts of the page, besides the error ID
+		want []string
+	}{
+		{name: "upstream down", status: http.StatusBadGateway, err: errors.New("dial tcp: connection refused"),
+			want: []string{"<title>502 Bad Gateway</title>", "Transmission cannot be reached at the moment.", "try again"}},
+		{name: "public message", status: http.StatusForbidden, err: &publicError{"you may not <script>"},
+			want: []string{"<h1>Forbidden</h1>", "You may not &lt;script&gt;."}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			logs := captureLog(t)
+			w := httptest.NewRecorder()
+			ctx := reqctx.WithRequestID(reqctx.WithHTMLErrors(context.Background()), "req-1")
+			rr.RespondAndLogCustom(w, ctx, tc.err, 0, slog.LevelError, tc.status)
+
+			body := w.Body.String()
+			if w.Code != tc.status || w.Header().Get("Content-Type") != "text/html; charset=utf-8" || w.Header().Get("X-Content-Type-Options") != "nosniff" {
+				t.Fatalf("got status %d, headers %v", w.Code, w.Header())
+			}
+			// the ID on the page is the one logged, the details of the error are not shown
+			if id := loggedErrID(t, logs); !strings.Contains(body, "Error ID: "+id+", request ID: req-1") {
+				t.Errorf("got page without error ID %s:\n%s", id, body)
+			}
+			for _, want := range tc.want {
+				if !strings.Contains(body, want) {
+					t.Errorf("page lacks %q:\n%s", want, body)
+				}
+			}
+			if strings.Contains(body, "connection refused") || tc.status < 500 && strings.Contains(body, "try again") {
+				t.Errorf("got page:\n%s", body)
+			}
+		})
+	}
+}
+
+// publicError is an error with the message for the users.
+type publicError struct {
+	msg string
+}
+
+func (e *publicError) Error() string         { return "internal: " + e.msg }
+func (e *publicError) PublicMessage() string { return e.msg }
+
+func TestErrorPageJSON(t *testing.T) {
+	page, _ := LoadErrorPage("")
+	captureLog(t)
+
+	// RPC clients and the requests not marked keep getting JSON
+	w := httptest.NewRecorder()
+	(&Responder{ErrorPage: page}).RespondAndLogCustom(w, context.Background(), errors.New("refused"), 3, slog.LevelError, http.StatusBadGateway)
+	var res map[string]any
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil || res["tag"] != 3.0 {
+		t.Errorf("got status %d, body %s", w.Code, w.Body)
+	}
+
+	// and so do all without the page
+	w = httptest.NewRecorder()
+	(&Responder{}).RespondAndLogCustom(w, reqctx.WithHTMLErrors(context.Background()), errors.New("refused"), 0, slog.LevelError, http.StatusBadGateway)
+	if !strings.HasPrefix(w.Header().Get("Content-Type"), "application/json") {
+		t.Errorf("got headers %v, body %s", w.Header(), w.Body)
+	}
+}
+
+func TestErrorPageRenderFailure(t *testing.T) {
+	logs := captureLog(t)
+	// the template refers to a field the data has not
+	page := template.Must(template.New("error").Parse(`<p>{{.Missing}}</p>`))
+	w := httptest.NewRecorder()
+	(&Responder{ErrorPage: page}).RespondAndLogCustom(w, reqctx.WithHTMLErrors(context.Background()), errors.New("refused"), 0, slog.LevelError, http.StatusBadGateway)
+
+	// the client still gets the message and the error ID, as plain text
+	if w.Code != http.StatusBadGateway || w.Header().Get("Content-Type") != "text/plain; charset=utf-8" ||
+		!strings.HasPrefix(w.Body.String(), "Transmission cannot be reached at the moment. Error ID: ") {
+		t.Errorf("got status %d, headers %v, body %q", w.Code, w.Header(), w.Body)
+	}
+	if !strings.Contains(logs.String(), "cannot render error page") || strings.Contains(w.Body.String(), "<p>") {
+		t.Errorf("got log %s, body %q", logs, w.Body)
+	}
+}
+
+func TestLoadErrorPage(t *testing.T) {
+	dir := t.TempDir()
+	custom := filepath.Join(dir, "custom.html")
+	if err := os.WriteFile(custom, []byte(`<p>{{.Status}}: {{.Message}} ({{.ErrorID}})</p>`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	broken := filepath.Join(dir, "broken.html")
+	if err := os.WriteFile(broken, []byte(`<p>{{.Status</p>`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	page, err := LoadErrorPage(custom)
+	if err != nil {
+		t.Fatal(err)
+	}
+	captureLog(t)
+	w := httptest.NewRecorder()
+	(&Responder{ErrorPage: page}).RespondAndLogCustom(w, reqctx.WithHTMLErrors(context.Background()), errors.New("timeout"), 0, slog.LevelError, http.StatusGatewayTimeout)
+	if !strings.HasPrefix(w.Body.String(), "<p>504: Transmission did not answer in time. (") {
+		t.Errorf("got page %s", w.Body)
+	}
+
+	for _, name := range []string{broken, filepath.Join(dir, "missing.html")} {
+		if _, err := LoadErrorPage(name); err == nil {
+			t.Errorf("%s: got no error", filepath.Base(name))
+		}
+	}
+}
+
+func TestWithHTMLErrors(t *testing.T) {
+	var marked bool
+	h := WithHTMLErrors(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		marked = reqctx.HTMLErrors(r.Context())
+	}))
+
+	for accept, want := range map[string]bool{"text/html": true, "application/json": false} {
+		r := httptest.NewRequest(http.MethodGet, "/transmission/web/", nil)
+		r.Header.Set("Accept", accept)
+		h.ServeHTTP(httptest.NewRecorder(), r)
+		if marked != want {
+			t.Errorf("%s: got marked %v", accept, marked)
+		}
+	}
+}
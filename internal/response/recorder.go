@@ -0,0 +1,110 @@
+package response
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+
+	"transmission-proxy/internal/logger"
+)
+
+// Recorder wraps http.ResponseWriter to remember the outcome of the request: status, size and timing.
+type Recorder struct {
+	http.ResponseWriter
+	start          time.Time
+	status         int
+	bytes          int64
+	upstreamStatus int
+
+	body        []byte
+	bodyMax     int
+	bodyTrimmed bool
+}
+
+func NewRecorder(w http.ResponseWriter) *Recorder {
+	return &Recorder{ResponseWriter: w, start: time.Now()}
+}
+
+func (r *Recorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *Recorder) Write(bs []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+
+	n, err := r.ResponseWriter.Write(bs)
+	r.bytes += int64(n)
+	if r.body != nil {
+		keep := min(n, r.bodyMax-len(r.body))
+		r.body = append(r.body, bs[:keep]...)
+		r.bodyTrimmed = r.bodyTrimmed || keep < n
+	}
+	return n, err
+}
+
+// CaptureBody makes the recorder keep up to max bytes of the response body. If the body is captured already,
+// the larger of the limits applies.
+func (r *Recorder) CaptureBody(max int) {
+	if r.body == nil {
+		r.body = []byte{}
+	}
+	if max > r.bodyMax {
+		r.bodyMax = max
+	}
+}
+
+// Body returns the captured response body and whether it was cut short.
+func (r *Recorder) Body() ([]byte, bool) {
+	return r.body, r.bodyTrimmed
+}
+
+func (r *Recorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (r *Recorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// SetUpstreamStatus remembers the status the upstream answered with, if the request reached it.
+func (r *Recorder) SetUpstreamStatus(status int) {
+	r.upstreamStatus = status
+}
+
+func (r *Recorder) UpstreamStatus() int {
+	return r.upstreamStatus
+}
+
+func (r *Recorder) Status() int {
+	return r.status
+}
+
+func (r *Recorder) BytesWritten() int64 {
+	return r.bytes
+}
+
+func (r *Recorder) Duration() time.Duration {
+	return time.Since(r.start)
+}
+
+// OutcomeAttrs returns attributes describing the request outcome so far.
+func (r *Recorder) OutcomeAttrs() []slog.Attr {
+	attrs := []any{
+		slog.Float64(logger.KeyDurationMs, float64(r.Duration())/float64(time.Millisecond)),
+		slog.Int64(logger.KeyBytesOut, r.bytes),
+	}
+
+	if r.upstreamStatus != 0 {
+		attrs = append(attrs, slog.Int(logger.KeyUpstreamStatus, r.upstreamStatus))
+	}
+
+	return []slog.Attr{logger.HTTP(attrs...)}
+}
@@ -4,6 +4,8 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
+	"html/template"
 	"io"
 	"log/slog"
 	"net/http"
@@ -15,36 +17,99 @@ import (
 	"github.com/google/uuid"
 
 	"transmission-proxy/internal/logger"
+	"transmission-proxy/internal/reqctx"
 )
 
+// ErrorDetail describes a single problem of the request in debug error responses.
+type ErrorDetail struct {
+	Field  string `json:"field,omitempty"`
+	Reason string `json:"reason"`
+}
+
+// HasErrorDetails is implemented by errors consisting of several problems which are listed
+// individually in the error response in debug mode.
+type HasErrorDetails interface {
+	ErrorDetails() []ErrorDetail
+}
+
+// HasPublicMessage is implemented by errors describing problems of the request which are safe to show
+// to the client. The message is sent in the error response even outside of debug mode.
+type HasPublicMessage interface {
+	PublicMessage() string
+}
+
 type Responder struct {
 	DebugMode bool
+	// ErrorPage, if set, renders the errors of requests marked with reqctx.WithHTMLErrors as HTML page
+	// (see WithHTMLErrors).
+	ErrorPage *template.Template
 }
 
 func (rr *Responder) RespondAndLogError(w http.ResponseWriter, ctx context.Context, err error, tag int) {
-	errId := rr.renderErrorReturnID(w, ctx, http.StatusInternalServerError, err.Error(), tag)
-	log(ctx, slog.LevelError, err.Error(), errId, logger.IgnoredAttr(err))
+	errId := rr.renderErrorReturnID(w, ctx, http.StatusInternalServerError, err, tag)
+	log(ctx, slog.LevelError, err.Error(), append(outcomeAttrs(w, http.StatusInternalServerError, tag), errId, logger.IgnoredAttr(err))...)
 }
 
 func (rr *Responder) RespondAndLogCustom(w http.ResponseWriter, ctx context.Context, err error, tag int, lvl slog.Level, status int) {
-	errId := rr.renderErrorReturnID(w, ctx, status, err.Error(), tag)
-	log(ctx, lvl, err.Error(), errId, logger.IgnoredAttr(err))
+	errId := rr.renderErrorReturnID(w, ctx, status, err, tag)
+	log(ctx, lvl, err.Error(), append(outcomeAttrs(w, status, tag), errId, logger.IgnoredAttr(err))...)
+}
+
+func outcomeAttrs(w http.ResponseWriter, status, tag int) []slog.Attr {
+	attrs := []slog.Attr{logger.HTTPStatus(status)}
+	if tag != 0 {
+		attrs = append(attrs, logger.RPCTag(tag))
+	}
+
+	if rec, ok := w.(*Recorder); ok {
+		attrs = append(attrs, rec.OutcomeAttrs()...)
+	}
+
+	return attrs
 }
 
-func (rr *Responder) renderErrorReturnID(w http.ResponseWriter, ctx context.Context, status int, message string, tag int) slog.Attr {
+func (rr *Responder) renderErrorReturnID(w http.ResponseWriter, ctx context.Context, status int, respErr error, tag int) slog.Attr {
 	data := map[string]any{}
 
 	if tag != 0 {
 		data["tag"] = tag
 	}
+	if id := reqctx.RequestID(ctx); id != "" {
+		data["request_id"] = id
+	}
 
 	errId := uuid.NewString()
 
+	var message string
 	if rr.DebugMode {
-		r, s := utf8.DecodeRuneInString(message)
-		data["result"] = string(unicode.ToUpper(r)) + message[s:]
+		message = capitalize(respErr.Error())
+		data["result"] = message
+
+		var hd HasErrorDetails
+		if errors.As(respErr, &hd) {
+			data["errors"] = hd.ErrorDetails()
+		}
+	} else if pm := HasPublicMessage(nil); errors.As(respErr, &pm) {
+		message = capitalize(pm.PublicMessage()) + "."
+		data["result"] = message + " Error ID: " + errId
 	} else {
-		data["result"] = "Unknown error occurred while processing your request. Error ID: " + errId
+		message = "Unknown error occurred while processing your request."
+		data["result"] = message + " Error ID: " + errId
+		if m, ok := pageMessages[status]; ok {
+			message = m
+		}
+	}
+
+	if rr.ErrorPage != nil && reqctx.HTMLErrors(ctx) {
+		rr.renderErrorPage(w, ctx, status, &ErrorPageData{
+			Status:     status,
+			StatusText: http.StatusText(status),
+			Message:    message,
+			ErrorID:    errId,
+			RequestID:  reqctx.RequestID(ctx),
+			Retry:      status >= http.StatusInternalServerError,
+		})
+		return logger.ErrID(errId)
 	}
 
 	bs, err := json.Marshal(data)
@@ -60,7 +125,29 @@ func (rr *Responder) renderErrorReturnID(w http.ResponseWriter, ctx context.Cont
 	w.WriteHeader(status)
 	_, _ = io.Copy(w, bytes.NewReader(bs))
 
-	return slog.String("err_id", errId)
+	return logger.ErrID(errId)
+}
+
+// renderErrorPage sends the error page, falling back to plain text if the template fails.
+func (rr *Responder) renderErrorPage(w http.ResponseWriter, ctx context.Context, status int, data *ErrorPageData) {
+	var buf bytes.Buffer
+	if err := rr.ErrorPage.Execute(&buf, data); err != nil {
+		slog.ErrorContext(ctx, "cannot render error page: "+err.Error(), logger.IgnoredAttr(err))
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		buf.Reset()
+		buf.WriteString(data.Message + " Error ID: " + data.ErrorID + "\n")
+	} else {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	}
+
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	_, _ = io.Copy(w, &buf)
+}
+
+func capitalize(message string) string {
+	r, s := utf8.DecodeRuneInString(message)
+	return string(unicode.ToUpper(r)) + message[s:]
 }
 
 func log(ctx context.Context, level slog.Level, msg string, attrs ...slog.Attr) {
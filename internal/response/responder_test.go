@@ -0,0 +1,66 @@
+package response
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"transmission-proxy/internal/logger"
+)
+
+func captureLog(t *testing.T) *bytes.Buffer {
+	var buf bytes.Buffer
+	prev := slog.Default()
+	slog.SetDefault(slog.New(logger.NewHandler(slog.NewJSONHandler(&buf, nil), "/")))
+	t.Cleanup(func() { slog.SetDefault(prev) })
+
+	return &buf
+}
+
+func TestRespondAndLogSchema(t *testing.T) {
+	logs := captureLog(t)
+
+	w := NewRecorder(httptest.NewRecorder())
+	err := logger.WithAttributes(errors.New("bad download-dir"), logger.RPCRejectReason("forbidden_location"))
+	(&Responder{}).RespondAndLogCustom(w, context.Background(), err, 7, slog.LevelWarn, http.StatusBadRequest)
+
+	var rec struct {
+		Level string `json:"level"`
+		RPC   struct {
+			Tag          int    `json:"tag"`
+			RejectReason string `json:"reject_reason"`
+		} `json:"rpc"`
+		HTTP struct {
+			Status     int      `json:"status"`
+			DurationMs *float64 `json:"duration_ms"`
+			BytesOut   int      `json:"bytes_out"`
+		} `json:"http"`
+		Err struct {
+			ID string `json:"id"`
+		} `json:"err"`
+	}
+	if err := json.Unmarshal(logs.Bytes(), &rec); err != nil {
+		t.Fatalf("bad record %s: %v", logs, err)
+	}
+
+	if rec.Level != "WARN" || rec.RPC.Tag != 7 || rec.RPC.RejectReason != "forbidden_location" {
+		t.Errorf("got record %s", logs)
+	}
+	if rec.HTTP.Status != http.StatusBadRequest || rec.HTTP.DurationMs == nil || rec.HTTP.BytesOut != int(w.BytesWritten()) {
+		t.Errorf("got record %s", logs)
+	}
+	// the client is told the error ID to report
+	body := w.Unwrap().(*httptest.ResponseRecorder).Body.String()
+	if rec.Err.ID == "" || !strings.Contains(body, "Error ID: "+rec.Err.ID) {
+		t.Errorf("got error id %q, body %s", rec.Err.ID, body)
+	}
+	if strings.Contains(body, "download-dir") {
+		t.Errorf("error details sent outside debug mode: %s", body)
+	}
+}
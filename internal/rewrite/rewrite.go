@@ -0,0 +1,99 @@
+package rewrite
+
+import (
+	"io"
+	"strings"
+)
+
+// Rule replaces From with To. From ending with slash does not match when followed by another slash,
+// so that e.g. rule for `href="/` leaves protocol-relative `href="//host/..."` alone.
+type Rule struct {
+	From, To string
+}
+
+// Writer applies rules to the content streamed through it, holding back only the tail which may be the start
+// of a match. Close must be called to write the tail.
+type Writer struct {
+	w     io.Writer
+	rules []Rule
+	first [256]bool
+	keep  int
+	buf   []byte
+}
+
+func NewWriter(w io.Writer, rules ...Rule) *Writer {
+	rw := &Writer{w: w, rules: rules}
+	for _, r := range rules {
+		rw.first[r.From[0]] = true
+		rw.keep = max(rw.keep, len(r.From))
+	}
+
+	return rw
+}
+
+func (rw *Writer) Write(p []byte) (int, error) {
+	rw.buf = append(rw.buf, p...)
+	if err := rw.flush(false); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+// Close writes the rest of the content. It does not close the underlying writer.
+func (rw *Writer) Close() error {
+	return rw.flush(true)
+}
+
+func (rw *Writer) flush(final bool) error {
+	end := len(rw.buf)
+	if !final {
+		// a match needs From and the byte following it, so the tail must stay until more content arrives
+		end -= rw.keep
+	}
+
+	out := make([]byte, 0, len(rw.buf))
+	i, run := 0, 0
+	for i < end {
+		if !rw.first[rw.buf[i]] {
+			i++
+			continue
+		}
+
+		r, ok := rw.match(rw.buf[i:])
+		if !ok {
+			i++
+			continue
+		}
+
+		out = append(out, rw.buf[run:i]...)
+		out = append(out, r.To...)
+		i += len(r.From)
+		run = i
+	}
+	i = max(i, end)
+	out = append(out, rw.buf[run:i]...)
+	rw.buf = append(rw.buf[:0], rw.buf[i:]...)
+
+	if len(out) == 0 {
+		return nil
+	}
+
+	_, err := rw.w.Write(out)
+	return err
+}
+
+func (rw *Writer) match(b []byte) (Rule, bool) {
+	for _, r := range rw.rules {
+		if len(b) < len(r.From) || string(b[:len(r.From)]) != r.From {
+			continue
+		}
+		if strings.HasSuffix(r.From, "/") && len(b) > len(r.From) && b[len(r.From)] == '/' {
+			continue
+		}
+
+		return r, true
+	}
+
+	return Rule{}, false
+}
@@ -0,0 +1,74 @@
+package rewrite
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+var rules = []Rule{
+	{From: `href="/`, To: `href="/torrents/`},
+	{From: `"/transmission/rpc`, To: `"/torrents/transmission/rpc`},
+}
+
+const (
+	content = `<a href="/transmission/web/">UI</a> <a href="//cdn.example/x.js">CDN</a> <a href="https://example.com/">out</a>` +
+		` <script>fetch("/transmission/rpc", {}); fetch('/transmission/rpc')</script> <a href="/`
+	want = `<a href="/torrents/transmission/web/">UI</a> <a href="//cdn.example/x.js">CDN</a> <a href="https://example.com/">out</a>` +
+		` <script>fetch("/torrents/transmission/rpc", {}); fetch('/transmission/rpc')</script> <a href="/torrents/`
+)
+
+// rewrite streams the content through Writer in chunks of the size.
+func rewrite(t *testing.T, content string, size int) string {
+	t.Helper()
+
+	var buf bytes.Buffer
+	rw := NewWriter(&buf, rules...)
+	for i := 0; i < len(content); i += size {
+		if _, err := rw.Write([]byte(content[i:min(i+size, len(content))])); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := rw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.String()
+}
+
+func TestWriter(t *testing.T) {
+	// matches split between writes are found
+	for _, size := range []int{1, 2, 3, 7, 16, len(content)} {
+		if got := rewrite(t, content, size); got != want {
+			t.Errorf("chunks of %d:\n got %s\nwant %s", size, got, want)
+		}
+	}
+
+	if got := rewrite(t, "", 1); got != "" {
+		t.Errorf("empty: got %q", got)
+	}
+	if got := rewrite(t, `href="/`, 100); got != `href="/torrents/` {
+		t.Errorf("match at the end: got %q", got)
+	}
+}
+
+func TestWriterStreams(t *testing.T) {
+	var buf bytes.Buffer
+	rw := NewWriter(&buf, rules...)
+
+	// only the tail which may start a match is held back
+	text := strings.Repeat("x", 100)
+	if _, err := rw.Write([]byte(text)); err != nil {
+		t.Fatal(err)
+	}
+	if n := buf.Len(); n != 100-len(rules[1].From) {
+		t.Errorf("got %d bytes written, want all but the tail", n)
+	}
+
+	if err := rw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != text {
+		t.Errorf("got %q after close", buf.String())
+	}
+}
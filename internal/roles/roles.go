@@ -0,0 +1,131 @@
+package roles
+
+import (
+	"fmt"
+	"os"
+
+	"gopkg.in/yaml.v3"
+
+	"transmission-proxy/internal/quota"
+)
+
+// Roles knows which authenticated users have elevated privileges and what the users are allowed to do.
+type Roles struct {
+	Admins map[string]bool
+	Users  map[string]*User
+	// BandwidthGroups are the limits of the groups the proxy maintains upstream.
+	BandwidthGroups map[string]*BandwidthGroup
+}
+
+// User holds per-user settings from the users config.
+type User struct {
+	Admin bool `yaml:"admin"`
+	// Groups are the bandwidth groups the user may assign to torrents.
+	Groups []string `yaml:"groups"`
+	// BandwidthGroup is assigned to all torrents of the user, whatever groups clients ask for.
+	BandwidthGroup string `yaml:"bandwidth_group"`
+	// Quota limits torrents the user may own (see OWNERSHIP_DB).
+	Quota quota.Limits `yaml:"quota"`
+}
+
+// BandwidthGroup holds limits of a bandwidth group, unset limits are disabled.
+type BandwidthGroup struct {
+	// SpeedLimitDown and SpeedLimitUp are in kB/s.
+	SpeedLimitDown      *int  `yaml:"speed_limit_down"`
+	SpeedLimitUp        *int  `yaml:"speed_limit_up"`
+	HonorsSessionLimits *bool `yaml:"honors_session_limits"`
+}
+
+type config struct {
+	Users           map[string]*User           `yaml:"users"`
+	BandwidthGroups map[string]*BandwidthGroup `yaml:"bandwidth_groups"`
+}
+
+func New(admins []string) *Roles {
+	r := &Roles{
+		Admins:          make(map[string]bool, len(admins)),
+		Users:           map[string]*User{},
+		BandwidthGroups: map[string]*BandwidthGroup{},
+	}
+	for _, a := range admins {
+		r.Admins[a] = true
+	}
+
+	return r
+}
+
+// LoadUsers adds users from the YAML config, e.g.
+//
+//	users:
+//	  alice:
+//	    groups: [slow]
+//	    bandwidth_group: alice
+//	    quota: {bytes: 107374182400, torrents: 50}
+//	  root:
+//	    admin: true
+//	bandwidth_groups:
+//	  alice:
+//	    speed_limit_down: 1024
+func (r *Roles) LoadUsers(path string) error {
+	bs, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var cfg config
+	if err = yaml.Unmarshal(bs, &cfg); err != nil {
+		return fmt.Errorf("parse %s: %w", path, err)
+	}
+
+	for name, u := range cfg.Users {
+		if u == nil {
+			u = &User{}
+		}
+
+		r.Users[name] = u
+		if u.Admin {
+			r.Admins[name] = true
+		}
+	}
+
+	for name, g := range cfg.BandwidthGroups {
+		if g == nil {
+			g = &BandwidthGroup{}
+		}
+
+		r.BandwidthGroups[name] = g
+	}
+
+	return nil
+}
+
+// IsAdmin reports whether the user is an administrator. Anonymous users never are.
+func (r *Roles) IsAdmin(user string) bool {
+	return user != "" && r.Admins[user]
+}
+
+// Known reports whether the user is listed as admin or in the users config.
+func (r *Roles) Known(user string) bool {
+	_, ok := r.Users[user]
+	return ok || r.Admins[user]
+}
+
+// User returns settings of the user, or empty settings for users missing from the config.
+func (r *Roles) User(user string) *User {
+	if u, ok := r.Users[user]; ok {
+		return u
+	}
+
+	return &User{}
+}
+
+// AllowsGroup reports whether the user may assign the bandwidth group to torrents.
+func (u *User) AllowsGroup(group string) bool {
+	for _, g := range u.Groups {
+		if g == group {
+			return true
+		}
+	}
+
+	return false
+}
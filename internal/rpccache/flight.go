@@ -0,0 +1,70 @@
+package rpccache
+
+import (
+	"net/http"
+	"sync"
+)
+
+// Response is the buffered response shared by coalesced requests.
+type Response struct {
+	Status         int
+	UpstreamStatus int
+	Header         http.Header
+	Body           []byte
+	// Tagless tells that the tag was stripped from Body, see StripTag.
+	Tagless bool
+}
+
+// Flight is the request in progress whose response is shared.
+type Flight struct {
+	done chan struct{}
+	resp *Response
+}
+
+// Done returns channel closed once the response is ready.
+func (f *Flight) Done() <-chan struct{} {
+	return f.done
+}
+
+// Response returns the response once Done is closed. It must not be modified.
+func (f *Flight) Response() *Response {
+	return f.resp
+}
+
+// Group coalesces concurrent identical requests, so that only the first of them is forwarded and the others
+// get its response, be it success or error.
+type Group struct {
+	mu      sync.Mutex
+	flights map[string]*Flight
+}
+
+// Do calls fetch unless the request with the key is in progress already, returning the flight to wait for.
+// fetch runs in its own goroutine, so that callers may stop waiting without affecting the others; it must not
+// depend on the cancellation of the request of any single caller.
+func (g *Group) Do(key string, fetch func() *Response) (f *Flight, shared bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if f, ok := g.flights[key]; ok {
+		return f, true
+	}
+
+	if g.flights == nil {
+		g.flights = map[string]*Flight{}
+	}
+	f = &Flight{done: make(chan struct{})}
+	g.flights[key] = f
+
+	go func() {
+		defer func() {
+			g.mu.Lock()
+			delete(g.flights, key)
+			g.mu.Unlock()
+			close(f.done)
+		}()
+
+		f.resp = fetch()
+	}()
+
+	return f, false
+}
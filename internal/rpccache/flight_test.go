@@ -0,0 +1,68 @@
+package rpccache
+
+import (
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestGroupDo(t *testing.T) {
+	var g Group
+	var calls atomic.Int32
+	release := make(chan struct{})
+	fetch := func() *Response {
+		calls.Add(1)
+		<-release
+		return &Response{Status: http.StatusOK, Body: []byte(`{"result":"success"}`)}
+	}
+
+	// the flight is in progress until released, so that all the callers find it
+	flights := make([]*Flight, 5)
+	var shared int
+	for i := range flights {
+		var s bool
+		flights[i], s = g.Do("torrent-get", fetch)
+		if s {
+			shared++
+		}
+	}
+	if shared != len(flights)-1 {
+		t.Errorf("got %d shared flights, want all but the first", shared)
+	}
+
+	// other requests are not coalesced with it
+	other, s := g.Do("session-get", func() *Response { return &Response{Status: http.StatusBadGateway} })
+	if s {
+		t.Error("different key shared")
+	}
+	<-other.Done()
+	if other.Response().Status != http.StatusBadGateway {
+		t.Errorf("got response %+v", other.Response())
+	}
+
+	close(release)
+	var wg sync.WaitGroup
+	for _, f := range flights {
+		wg.Add(1)
+		go func(f *Flight) {
+			defer wg.Done()
+			<-f.Done()
+			if f.Response() != flights[0].Response() {
+				t.Errorf("got response %+v, want the shared one", f.Response())
+			}
+		}(f)
+	}
+	wg.Wait()
+
+	if n := calls.Load(); n != 1 {
+		t.Errorf("got %d fetches, want 1", n)
+	}
+
+	// completed flights are forgotten
+	f, s := g.Do("torrent-get", fetch)
+	<-f.Done()
+	if s || calls.Load() != 2 {
+		t.Errorf("after completion: got shared %v, %d fetches", s, calls.Load())
+	}
+}
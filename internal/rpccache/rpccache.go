@@ -0,0 +1,153 @@
+package rpccache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"transmission-proxy/internal/jrpc"
+)
+
+// Methods lists the methods whose responses may be cached. They change nothing, so repeating them
+// within a short time gives the same response.
+var Methods = []string{"torrent-get", "session-get", "session-stats", "free-space"}
+
+// Key returns the key of the response to the request, which is the same for requests with the same method
+// and arguments regardless of their order. Scope distinguishes requests getting different responses for
+// the same arguments, e.g. made with different upstream credentials. It returns false if the response
+// may not be cached.
+func Key(req *jrpc.Request, scope string) (string, bool) {
+	if !slices.Contains(Methods, req.Method) {
+		return "", false
+	}
+
+	// encoding/json sorts the keys of maps
+	args, err := json.Marshal(req.Arguments)
+	if err != nil {
+		return "", false
+	}
+
+	h := sha256.New()
+	h.Write([]byte(req.Method))
+	h.Write([]byte{0})
+	h.Write([]byte(scope))
+	h.Write([]byte{0})
+	h.Write(args)
+	return hex.EncodeToString(h.Sum(nil)), true
+}
+
+// Entry is the cached response.
+type Entry struct {
+	Header http.Header
+	// Body is the response object without tag, see WithTag.
+	Body    []byte
+	expires time.Time
+}
+
+// StripTag returns the response body without tag, so that the tag of another request can be added to it
+// with WithTag. It returns false if the body is not JSON object.
+func StripTag(body []byte) ([]byte, bool) {
+	var members map[string]json.RawMessage
+	if err := json.Unmarshal(body, &members); err != nil || members == nil {
+		return nil, false
+	}
+	delete(members, "tag")
+
+	body, err := json.Marshal(members)
+	return body, err == nil
+}
+
+// WithTag returns the body returned by StripTag with the tag of the request added, as Transmission echoes it
+// in responses.
+func WithTag(body []byte, tag int, hasTag bool) []byte {
+	if !hasTag && tag == 0 {
+		return body
+	}
+
+	out := make([]byte, 0, len(body)+20)
+	out = append(out, body[:len(body)-1]...)
+	if len(body) > len("{}") {
+		out = append(out, ',')
+	}
+	out = append(out, `"tag":`...)
+	out = strconv.AppendInt(out, int64(tag), 10)
+	return append(out, '}')
+}
+
+// Cache keeps responses to read-only requests for TTL, so that clients polling the same data do not each
+// reach Transmission. Up to MaxEntries responses are kept, the ones expiring first are dropped to make room.
+type Cache struct {
+	TTL        time.Duration
+	MaxEntries int
+
+	mu      sync.Mutex
+	entries map[string]*Entry
+	// gen changes on invalidation, so that responses requested before it are not stored afterwards
+	gen uint64
+}
+
+// Get returns the cached response and the generation of the cache to pass to Put if there is none.
+func (c *Cache) Get(key string) (*Entry, uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok || time.Now().After(e.expires) {
+		return nil, c.gen
+	}
+
+	return e, c.gen
+}
+
+// Put stores the response, unless the cache was invalidated since gen was returned by Get. The body must be
+// stripped of tag with StripTag.
+func (c *Cache) Put(key string, gen uint64, header http.Header, body []byte) {
+	header = header.Clone()
+	header.Del("Content-Length")
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if gen != c.gen {
+		return
+	}
+	if c.entries == nil {
+		c.entries = map[string]*Entry{}
+	}
+	if _, ok := c.entries[key]; !ok && len(c.entries) >= c.MaxEntries {
+		c.evict()
+	}
+
+	c.entries[key] = &Entry{Header: header, Body: body, expires: time.Now().Add(c.TTL)}
+}
+
+// evict drops the expired responses, or the one expiring first if none are.
+func (c *Cache) evict() {
+	now := time.Now()
+	var first string
+	for key, e := range c.entries {
+		if now.After(e.expires) {
+			delete(c.entries, key)
+		} else if first == "" || e.expires.Before(c.entries[first].expires) {
+			first = key
+		}
+	}
+
+	if len(c.entries) >= c.MaxEntries && first != "" {
+		delete(c.entries, first)
+	}
+}
+
+// Invalidate drops all the responses, e.g. after a request changing torrents or settings.
+func (c *Cache) Invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	clear(c.entries)
+	c.gen++
+}
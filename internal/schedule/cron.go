@@ -0,0 +1,189 @@
+package schedule
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Spec is a parsed cron expression of five fields: minute, hour, day of month, month and day of week
+// (0-7, both 0 and 7 being Sunday). Fields are lists of values, ranges (1-5), steps (*/15, 8-18/2) or *.
+// As in cron, when both day of month and day of week are restricted, a day matching either of them matches.
+// Times skipped when clocks are turned forward never match; times repeated when clocks are turned back
+// match once, unless the hour is *.
+type Spec struct {
+	minute, hour, dom, month, dow uint64
+	domAny, dowAny, hourAny       bool
+}
+
+// maxSearch bounds the search for the next or previous matching time, so that expressions like
+// "0 0 30 2 *" which never match do not loop forever.
+const maxSearch = 5 * 366 * 24 * time.Hour
+
+func ParseSpec(expr string) (*Spec, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron expression %q must have 5 fields", expr)
+	}
+
+	var s Spec
+	var err error
+	if s.minute, err = parseField(fields[0], 0, 59); err != nil {
+		return nil, fmt.Errorf("minute: %w", err)
+	}
+	if s.hour, err = parseField(fields[1], 0, 23); err != nil {
+		return nil, fmt.Errorf("hour: %w", err)
+	}
+	if s.dom, err = parseField(fields[2], 1, 31); err != nil {
+		return nil, fmt.Errorf("day of month: %w", err)
+	}
+	if s.month, err = parseField(fields[3], 1, 12); err != nil {
+		return nil, fmt.Errorf("month: %w", err)
+	}
+	if s.dow, err = parseField(fields[4], 0, 7); err != nil {
+		return nil, fmt.Errorf("day of week: %w", err)
+	}
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+	s.domAny, s.dowAny, s.hourAny = fields[2] == "*", fields[4] == "*", fields[1] == "*"
+
+	return &s, nil
+}
+
+func parseField(field string, lo, hi int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		rng, stepStr, hasStep := strings.Cut(part, "/")
+
+		step := 1
+		if hasStep {
+			n, err := strconv.Atoi(stepStr)
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("bad step %q", stepStr)
+			}
+			step = n
+		}
+
+		from, to := lo, hi
+		switch {
+		case rng == "*":
+		case strings.Contains(rng, "-"):
+			a, b, _ := strings.Cut(rng, "-")
+			var err error
+			if from, err = parseValue(a, lo, hi); err != nil {
+				return 0, err
+			}
+			if to, err = parseValue(b, lo, hi); err != nil {
+				return 0, err
+			}
+			if from > to {
+				return 0, fmt.Errorf("bad range %q", rng)
+			}
+		default:
+			v, err := parseValue(rng, lo, hi)
+			if err != nil {
+				return 0, err
+			}
+			from = v
+			if !hasStep {
+				to = v
+			}
+		}
+
+		for v := from; v <= to; v += step {
+			bits |= 1 << v
+		}
+	}
+
+	return bits, nil
+}
+
+func parseValue(s string, lo, hi int) (int, error) {
+	v, err := strconv.Atoi(s)
+	if err != nil || v < lo || v > hi {
+		return 0, fmt.Errorf("value %q must be a number from %d to %d", s, lo, hi)
+	}
+
+	return v, nil
+}
+
+func (s *Spec) matchDay(t time.Time) bool {
+	if s.month&(1<<uint(t.Month())) == 0 {
+		return false
+	}
+
+	dom := s.dom&(1<<uint(t.Day())) != 0
+	dow := s.dow&(1<<uint(t.Weekday())) != 0
+	switch {
+	case s.domAny && s.dowAny:
+		return true
+	case s.domAny:
+		return dow
+	case s.dowAny:
+		return dom
+	default:
+		return dom || dow
+	}
+}
+
+func (s *Spec) match(t time.Time) bool {
+	return s.minute&(1<<uint(t.Minute())) != 0 && s.hour&(1<<uint(t.Hour())) != 0 && s.matchDay(t)
+}
+
+// Next returns the first matching time after t (in t's location), or zero time if there is none.
+func (s *Spec) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	for limit := t.Add(maxSearch); t.Before(limit); {
+		switch {
+		case !s.matchDay(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		case s.hour&(1<<uint(t.Hour())) == 0:
+			t = startOfHour(t).Add(time.Hour)
+		case s.minute&(1<<uint(t.Minute())) == 0, !s.hourAny && repeated(t):
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+
+	return time.Time{}
+}
+
+// repeated reports whether the clock showed the time of t already, before it was turned back.
+func repeated(t time.Time) bool {
+	start, _ := t.ZoneBounds()
+	if start.IsZero() {
+		return false
+	}
+
+	_, off := t.Zone()
+	_, prevOff := start.Add(-time.Second).Zone()
+	return prevOff > off && t.Sub(start) < time.Duration(prevOff-off)*time.Second
+}
+
+// Prev returns the last matching time not after t (in t's location), or zero time if there is none.
+func (s *Spec) Prev(t time.Time) time.Time {
+	t = t.Truncate(time.Minute)
+	for limit := t.Add(-maxSearch); t.After(limit); {
+		switch {
+		case !s.matchDay(t):
+			t = time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location()).Add(-time.Minute)
+		case s.hour&(1<<uint(t.Hour())) == 0:
+			t = startOfHour(t).Add(-time.Minute)
+		case s.minute&(1<<uint(t.Minute())) == 0:
+			t = t.Add(-time.Minute)
+		default:
+			return t
+		}
+	}
+
+	return time.Time{}
+}
+
+// startOfHour truncates t to the hour in its location (Truncate works with absolute time, which is wrong
+// for locations with offsets which are not whole hours).
+func startOfHour(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, t.Location())
+}
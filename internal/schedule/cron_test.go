@@ -0,0 +1,147 @@
+package schedule
+
+import (
+	"strings"
+	"testing"
+	"time"
+	_ "time/tzdata"
+)
+
+func TestParseSpecInvalid(t *testing.T) {
+	cases := []struct {
+		expr, err string
+	}{
+		{expr: "* * * *", err: "must have 5 fields"},
+		{expr: "* * * * * *", err: "must have 5 fields"},
+		{expr: "60 * * * *", err: "minute: "},
+		{expr: "* 24 * * *", err: "hour: "},
+		{expr: "* * 0 * *", err: "day of month: "},
+		{expr: "* * * 13 *", err: "month: "},
+		{expr: "* * * * 8", err: "day of week: "},
+		{expr: "*/0 * * * *", err: "bad step"},
+		{expr: "5-1 * * * *", err: "bad range"},
+		{expr: "a * * * *", err: `value "a" must be a number from 0 to 59`},
+	}
+
+	for _, tc := range cases {
+		if _, err := ParseSpec(tc.expr); err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("%q: got error %v, want %q", tc.expr, err, tc.err)
+		}
+	}
+}
+
+func mustParse(t *testing.T, expr string) *Spec {
+	t.Helper()
+
+	s, err := ParseSpec(expr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return s
+}
+
+func TestSpecNext(t *testing.T) {
+	// a Wednesday
+	from := time.Date(2024, 5, 15, 10, 7, 30, 0, time.UTC)
+
+	cases := []struct {
+		expr string
+		want time.Time
+	}{
+		{expr: "* * * * *", want: time.Date(2024, 5, 15, 10, 8, 0, 0, time.UTC)},
+		{expr: "*/15 * * * *", want: time.Date(2024, 5, 15, 10, 15, 0, 0, time.UTC)},
+		{expr: "0 8 * * 1-5", want: time.Date(2024, 5, 16, 8, 0, 0, 0, time.UTC)},
+		{expr: "0 8 * * 6,0", want: time.Date(2024, 5, 18, 8, 0, 0, 0, time.UTC)},
+		// 7 is Sunday too
+		{expr: "0 8 * * 7", want: time.Date(2024, 5, 19, 8, 0, 0, 0, time.UTC)},
+		{expr: "30 23 1 * *", want: time.Date(2024, 6, 1, 23, 30, 0, 0, time.UTC)},
+		// either the day of month or the day of week matches
+		{expr: "0 0 1 * 5", want: time.Date(2024, 5, 17, 0, 0, 0, 0, time.UTC)},
+		{expr: "0 0 29 2 *", want: time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{expr: "0 0 30 2 *"},
+	}
+
+	for _, tc := range cases {
+		if got := mustParse(t, tc.expr).Next(from); !got.Equal(tc.want) {
+			t.Errorf("%q: got %v, want %v", tc.expr, got, tc.want)
+		}
+	}
+
+	// the time itself is not the next one
+	at := time.Date(2024, 5, 16, 8, 0, 0, 0, time.UTC)
+	if got := mustParse(t, "0 8 * * 1-5").Next(at); !got.Equal(at.AddDate(0, 0, 1)) {
+		t.Errorf("next after a match: got %v", got)
+	}
+}
+
+func TestSpecPrev(t *testing.T) {
+	from := time.Date(2024, 5, 15, 10, 7, 30, 0, time.UTC)
+
+	cases := []struct {
+		expr string
+		want time.Time
+	}{
+		{expr: "*/15 * * * *", want: time.Date(2024, 5, 15, 10, 0, 0, 0, time.UTC)},
+		{expr: "0 8 * * 1-5", want: time.Date(2024, 5, 15, 8, 0, 0, 0, time.UTC)},
+		{expr: "0 8 * * 6,0", want: time.Date(2024, 5, 12, 8, 0, 0, 0, time.UTC)},
+		{expr: "0 0 30 2 *"},
+	}
+
+	for _, tc := range cases {
+		if got := mustParse(t, tc.expr).Prev(from); !got.Equal(tc.want) {
+			t.Errorf("%q: got %v, want %v", tc.expr, got, tc.want)
+		}
+	}
+}
+
+func TestSpecLocation(t *testing.T) {
+	kolkata, err := time.LoadLocation("Asia/Kolkata")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the times are of the clock of the location, including those with half hour offsets
+	s := mustParse(t, "0 8 * * *")
+	got := s.Next(time.Date(2024, 5, 15, 0, 0, 0, 0, time.UTC).In(kolkata))
+	if want := time.Date(2024, 5, 15, 2, 30, 0, 0, time.UTC); !got.Equal(want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestSpecDaylightSaving(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// fires returns the times the spec matches between from and to in Berlin
+	fires := func(expr string, from, to time.Time) []string {
+		var res []string
+		s := mustParse(t, expr)
+		for at := s.Next(from); !at.IsZero() && at.Before(to); at = s.Next(at) {
+			res = append(res, at.Format("01-02 15:04 MST"))
+		}
+		return res
+	}
+
+	// clocks are turned forward from 02:00 to 03:00 on 31 March 2024
+	from := time.Date(2024, 3, 30, 12, 0, 0, 0, berlin)
+	to := time.Date(2024, 4, 1, 12, 0, 0, 0, berlin)
+	if got := strings.Join(fires("30 2 * * *", from, to), ", "); got != "04-01 02:30 CEST" {
+		t.Errorf("skipped time: got %s", got)
+	}
+	if got := strings.Join(fires("0 8 * * *", from, to), ", "); got != "03-31 08:00 CEST, 04-01 08:00 CEST" {
+		t.Errorf("8:00 across the change: got %s", got)
+	}
+
+	// clocks are turned back from 03:00 to 02:00 on 27 October 2024
+	from = time.Date(2024, 10, 26, 12, 0, 0, 0, berlin)
+	to = time.Date(2024, 10, 28, 0, 0, 0, 0, berlin)
+	if got := strings.Join(fires("30 2 * * *", from, to), ", "); got != "10-27 02:30 CEST" {
+		t.Errorf("repeated time: got %s, want it matched once", got)
+	}
+	if got := strings.Join(fires("30 * 27 10 *", from, to)[:4], ", "); got != "10-27 00:30 CEST, 10-27 01:30 CEST, 10-27 02:30 CEST, 10-27 02:30 CET" {
+		t.Errorf("every hour: got %s, want the repeated hour matched", got)
+	}
+}
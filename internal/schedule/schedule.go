@@ -0,0 +1,177 @@
+package schedule
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"sort"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"transmission-proxy/internal/audit"
+	"transmission-proxy/internal/jrpc"
+	"transmission-proxy/internal/logger"
+	"transmission-proxy/internal/transmission"
+	"transmission-proxy/internal/upstream"
+)
+
+// User is the name scheduled calls are audited as.
+const User = "scheduler"
+
+// catchUpWindow is how far back missed calls are looked for on startup, enough for weekly schedules.
+const catchUpWindow = 7 * 24 * time.Hour
+
+// callTimeout limits time of a single scheduled call.
+const callTimeout = 30 * time.Second
+
+// Entry is an RPC call made at the times matching the cron expression.
+type Entry struct {
+	Cron      string         `yaml:"cron"`
+	Method    string         `yaml:"method"`
+	Arguments map[string]any `yaml:"arguments"`
+
+	spec *Spec
+}
+
+// Config is the schedule file, e.g.
+//
+//	catch_up: true
+//	entries:
+//	  - cron: "0 8 * * 1-5"
+//	    method: session-set
+//	    arguments: {alt-speed-enabled: true}
+type Config struct {
+	// CatchUp makes the scheduler run on startup the calls missed while the proxy was down.
+	CatchUp bool     `yaml:"catch_up"`
+	Entries []*Entry `yaml:"entries"`
+}
+
+func Load(path string) (*Config, error) {
+	bs, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var cfg Config
+	if err = yaml.Unmarshal(bs, &cfg); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+
+	for i, e := range cfg.Entries {
+		if e.spec, err = ParseSpec(e.Cron); err != nil {
+			return nil, fmt.Errorf("entry %d: %w", i+1, err)
+		}
+		if !slices.Contains(transmission.SpecMethods(), e.Method) {
+			return nil, fmt.Errorf("entry %d: unknown method %q", i+1, e.Method)
+		}
+	}
+
+	return &cfg, nil
+}
+
+// Scheduler makes the calls of the schedule upstream at their times in Location.
+type Scheduler struct {
+	Config   *Config
+	Upstream *upstream.Client
+	Location *time.Location
+	// DryRun only logs the calls instead of making them.
+	DryRun bool
+	// Audit, if not nil, records the calls.
+	Audit *audit.Log
+}
+
+type firing struct {
+	at    time.Time
+	entry *Entry
+}
+
+// Run makes the scheduled calls until the context is done.
+func (s *Scheduler) Run(ctx context.Context) {
+	now := time.Now().In(s.Location)
+	if s.Config.CatchUp {
+		s.catchUp(ctx, now)
+	}
+
+	for {
+		next := s.next(now)
+		if len(next) == 0 {
+			slog.Warn("schedule has no upcoming calls")
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Until(next[0].at)):
+		}
+
+		for _, f := range next {
+			s.call(ctx, f)
+		}
+		now = next[0].at
+	}
+}
+
+// catchUp makes the calls missed within catchUpWindow, in the order they should have been made, so that
+// e.g. the latest of alt-speed switches is in effect.
+func (s *Scheduler) catchUp(ctx context.Context, now time.Time) {
+	var missed []firing
+	for _, e := range s.Config.Entries {
+		if at := e.spec.Prev(now); !at.IsZero() && now.Sub(at) < catchUpWindow {
+			missed = append(missed, firing{at: at, entry: e})
+		}
+	}
+
+	sort.SliceStable(missed, func(i, j int) bool { return missed[i].at.Before(missed[j].at) })
+	for _, f := range missed {
+		s.call(ctx, f)
+	}
+}
+
+// next returns the entries to fire at the earliest upcoming time.
+func (s *Scheduler) next(now time.Time) []firing {
+	var res []firing
+	for _, e := range s.Config.Entries {
+		at := e.spec.Next(now)
+		switch {
+		case at.IsZero():
+		case len(res) == 0 || at.Before(res[0].at):
+			res = []firing{{at: at, entry: e}}
+		case at.Equal(res[0].at):
+			res = append(res, firing{at: at, entry: e})
+		}
+	}
+
+	return res
+}
+
+func (s *Scheduler) call(ctx context.Context, f firing) {
+	e := f.entry
+	attrs := []slog.Attr{logger.RPCMethod(e.Method), slog.String("cron", e.Cron), slog.Time("scheduled_at", f.at)}
+
+	if s.DryRun {
+		slog.LogAttrs(ctx, slog.LevelInfo, "dry run: scheduled call skipped", attrs...)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, callTimeout)
+	defer cancel()
+
+	rec := &audit.Record{Time: time.Now(), User: User, Method: e.Method, Result: jrpc.ResultSuccess}
+	_, err := s.Upstream.Call(ctx, nil, &jrpc.Request{Method: e.Method, Arguments: e.Arguments})
+	if err != nil {
+		rec.Result = err.Error()
+		slog.LogAttrs(ctx, slog.LevelError, "scheduled call failed: "+err.Error(), append(attrs, logger.IgnoredAttr(err))...)
+	} else {
+		slog.LogAttrs(ctx, slog.LevelInfo, "scheduled call made", attrs...)
+	}
+
+	if s.Audit != nil {
+		if err := s.Audit.Write(rec); err != nil {
+			slog.ErrorContext(ctx, "failed to write audit log: "+err.Error(), logger.IgnoredAttr(err))
+		}
+	}
+}
@@ -0,0 +1,219 @@
+package schedule
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"transmission-proxy/internal/audit"
+	"transmission-proxy/internal/jrpc"
+	"transmission-proxy/internal/upstream"
+)
+
+// recordingUpstream answers every call with success, or fails while down, recording the calls as method and
+// arguments.
+type recordingUpstream struct {
+	calls []string
+	down  bool
+}
+
+func (u *recordingUpstream) RoundTrip(r *http.Request) (*http.Response, error) {
+	if u.down {
+		return nil, errors.New("connection refused")
+	}
+
+	var req jrpc.Request
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, err
+	}
+	bs, _ := json.Marshal(req.Arguments)
+	u.calls = append(u.calls, req.Method+" "+string(bs))
+
+	w := httptest.NewRecorder()
+	_, _ = w.WriteString(`{"result":"success","arguments":{}}`)
+	return w.Result(), nil
+}
+
+func writeSchedule(t *testing.T, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "schedule.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+const altSpeedSchedule = `
+catch_up: true
+entries:
+  - cron: "0 8 * * 1-5"
+    method: session-set
+    arguments: {alt-speed-enabled: true}
+  - cron: "0 18 * * 1-5"
+    method: session-set
+    arguments: {alt-speed-enabled: false}
+  - cron: "0 18 * * 5"
+    method: torrent-start
+`
+
+func testScheduler(t *testing.T, up *recordingUpstream) *Scheduler {
+	t.Helper()
+
+	cfg, err := Load(writeSchedule(t, altSpeedSchedule))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &Scheduler{
+		Config:   cfg,
+		Upstream: &upstream.Client{URL: "http://transmission:9091/transmission/rpc", HTTP: &http.Client{Transport: up}},
+		Location: time.UTC,
+	}
+}
+
+func captureLog(t *testing.T) *bytes.Buffer {
+	var buf bytes.Buffer
+	prev := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&buf, nil)))
+	t.Cleanup(func() { slog.SetDefault(prev) })
+
+	return &buf
+}
+
+func TestLoad(t *testing.T) {
+	cfg, err := Load(writeSchedule(t, altSpeedSchedule))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cfg.CatchUp || len(cfg.Entries) != 3 || cfg.Entries[0].Arguments["alt-speed-enabled"] != true {
+		t.Errorf("got config %+v", cfg)
+	}
+
+	cases := []struct {
+		name, entries, err string
+	}{
+		{name: "bad cron", entries: `[{cron: "0 25 * * *", method: session-set}]`, err: "entry 1: hour: "},
+		{name: "unknown method", entries: `[{cron: "0 8 * * *", method: session-set}, {cron: "0 8 * * *", method: rm-rf}]`,
+			err: `entry 2: unknown method "rm-rf"`},
+		{name: "not yaml", entries: `{`, err: "parse "},
+	}
+	for _, tc := range cases {
+		if _, err := Load(writeSchedule(t, "entries: "+tc.entries)); err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("%s: got error %v, want %q", tc.name, err, tc.err)
+		}
+	}
+}
+
+func TestNext(t *testing.T) {
+	s := testScheduler(t, &recordingUpstream{})
+
+	// a Friday
+	next := s.next(time.Date(2024, 5, 17, 12, 0, 0, 0, time.UTC))
+	if len(next) != 2 || next[0].entry.Method != "session-set" || next[1].entry.Method != "torrent-start" ||
+		!next[0].at.Equal(time.Date(2024, 5, 17, 18, 0, 0, 0, time.UTC)) {
+		t.Errorf("got %+v, want both calls at 18:00", next)
+	}
+
+	next = s.next(time.Date(2024, 5, 17, 18, 0, 0, 0, time.UTC))
+	if len(next) != 1 || !next[0].at.Equal(time.Date(2024, 5, 20, 8, 0, 0, 0, time.UTC)) {
+		t.Errorf("got %+v, want Monday 8:00", next)
+	}
+}
+
+func TestCatchUp(t *testing.T) {
+	up := &recordingUpstream{}
+	s := testScheduler(t, up)
+
+	// on Saturday the missed calls are made in order, so that alt speed ends up disabled
+	s.catchUp(context.Background(), time.Date(2024, 5, 18, 12, 0, 0, 0, time.UTC))
+	want := `session-set {"alt-speed-enabled":true}, session-set {"alt-speed-enabled":false}, torrent-start null`
+	if got := strings.Join(up.calls, ", "); got != want {
+		t.Errorf("got calls %s, want %s", got, want)
+	}
+
+	// calls older than the window are not made
+	up.calls = nil
+	s.Config.Entries = s.Config.Entries[2:]
+	s.Config.Entries[0].spec = mustParse(t, "0 0 1 1 *")
+	s.catchUp(context.Background(), time.Date(2024, 5, 18, 12, 0, 0, 0, time.UTC))
+	if len(up.calls) != 0 {
+		t.Errorf("got calls %v, want none", up.calls)
+	}
+}
+
+func TestCallAudited(t *testing.T) {
+	logs := captureLog(t)
+	up := &recordingUpstream{}
+	s := testScheduler(t, up)
+
+	auditPath := filepath.Join(t.TempDir(), "audit.log")
+	al, err := audit.Open(auditPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = al.Close() })
+	s.Audit = al
+
+	at := time.Date(2024, 5, 17, 8, 0, 0, 0, time.UTC)
+	s.call(context.Background(), firing{at: at, entry: s.Config.Entries[0]})
+	up.down = true
+	s.call(context.Background(), firing{at: at, entry: s.Config.Entries[0]})
+
+	if len(up.calls) != 1 || up.calls[0] != `session-set {"alt-speed-enabled":true}` {
+		t.Errorf("got calls %v", up.calls)
+	}
+	if !strings.Contains(logs.String(), `"msg":"scheduled call made"`) ||
+		!strings.Contains(logs.String(), `"level":"ERROR","msg":"scheduled call failed: `) {
+		t.Errorf("got log:\n%s", logs)
+	}
+
+	bs, err := os.ReadFile(auditPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(bs)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("got audit log %s, want 2 records", bs)
+	}
+	for i, line := range lines {
+		var rec audit.Record
+		if err := json.Unmarshal([]byte(line), &rec); err != nil {
+			t.Fatal(err)
+		}
+		if rec.User != User || rec.Method != "session-set" {
+			t.Errorf("got record %+v", rec)
+		}
+		if success := rec.Result == jrpc.ResultSuccess; success != (i == 0) {
+			t.Errorf("record %d: got result %q", i, rec.Result)
+		}
+	}
+}
+
+func TestCallDryRun(t *testing.T) {
+	logs := captureLog(t)
+	up := &recordingUpstream{}
+	s := testScheduler(t, up)
+	s.DryRun = true
+
+	s.catchUp(context.Background(), time.Date(2024, 5, 18, 12, 0, 0, 0, time.UTC))
+	if len(up.calls) != 0 {
+		t.Errorf("dry run made calls %v", up.calls)
+	}
+	if n := strings.Count(logs.String(), `"msg":"dry run: scheduled call skipped"`); n != 3 {
+		t.Errorf("got %d calls logged as skipped, want 3:\n%s", n, logs)
+	}
+	if !strings.Contains(logs.String(), `"cron":"0 18 * * 5"`) {
+		t.Errorf("got log without the cron expression:\n%s", logs)
+	}
+}
@@ -0,0 +1,145 @@
+package session
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	CookieName     = "transmission_proxy_session"
+	CSRFCookieName = "transmission_proxy_csrf"
+
+	// csrfLifetime limits how long the login form may stay open before submitting.
+	csrfLifetime = time.Hour
+)
+
+var (
+	ErrNoSession      = errors.New("no session")
+	ErrInvalidSession = errors.New("invalid session cookie")
+	ErrExpiredSession = errors.New("session expired")
+	ErrCSRF           = errors.New("invalid CSRF token")
+)
+
+// Manager issues and verifies sessions stored in signed cookies. Sessions are stateless: the cookie holds
+// the user name and expiry time signed with Key, so changing the key invalidates all sessions.
+type Manager struct {
+	Key      []byte
+	Lifetime time.Duration
+	// Secure marks cookies to be sent over HTTPS only.
+	Secure bool
+}
+
+// NewKey generates random signing key.
+func NewKey() []byte {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		panic(err)
+	}
+
+	return key
+}
+
+// Start sets the session cookie for the user.
+func (m *Manager) Start(w http.ResponseWriter, user string) {
+	expires := time.Now().Add(m.Lifetime)
+	http.SetCookie(w, m.cookie(CookieName, m.sign("session", user, expires), expires))
+}
+
+// End clears the session cookie.
+func (m *Manager) End(w http.ResponseWriter) {
+	c := m.cookie(CookieName, "", time.Unix(0, 0))
+	c.MaxAge = -1
+	http.SetCookie(w, c)
+}
+
+// User returns name of the user of the request session.
+func (m *Manager) User(r *http.Request) (string, error) {
+	c, err := r.Cookie(CookieName)
+	if err != nil {
+		return "", ErrNoSession
+	}
+
+	return m.verify("session", c.Value)
+}
+
+// CSRFToken sets the cookie with a new CSRF token and returns the token to be included in the form.
+func (m *Manager) CSRFToken(w http.ResponseWriter) string {
+	expires := time.Now().Add(csrfLifetime)
+	token := m.sign("csrf", base64.RawURLEncoding.EncodeToString(NewKey()[:16]), expires)
+	http.SetCookie(w, m.cookie(CSRFCookieName, token, expires))
+
+	return token
+}
+
+// CheckCSRF verifies the token submitted with the form matches the CSRF cookie.
+func (m *Manager) CheckCSRF(r *http.Request, token string) error {
+	c, err := r.Cookie(CSRFCookieName)
+	if err != nil || !hmac.Equal([]byte(c.Value), []byte(token)) {
+		return ErrCSRF
+	}
+
+	if _, err = m.verify("csrf", token); err != nil {
+		return ErrCSRF
+	}
+
+	return nil
+}
+
+func (m *Manager) cookie(name, value string, expires time.Time) *http.Cookie {
+	return &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   m.Secure,
+		SameSite: http.SameSiteStrictMode,
+	}
+}
+
+// sign produces "payload.expires.signature" with payload base64 encoded. Purpose is signed as well,
+// so that a token issued for one purpose is not accepted for another.
+func (m *Manager) sign(purpose, payload string, expires time.Time) string {
+	value := base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." + strconv.FormatInt(expires.Unix(), 10)
+	return value + "." + m.mac(purpose, value)
+}
+
+func (m *Manager) verify(purpose, token string) (string, error) {
+	i := strings.LastIndexByte(token, '.')
+	if i < 0 || !hmac.Equal([]byte(token[i+1:]), []byte(m.mac(purpose, token[:i]))) {
+		return "", ErrInvalidSession
+	}
+
+	payload, exp, ok := strings.Cut(token[:i], ".")
+	if !ok {
+		return "", ErrInvalidSession
+	}
+
+	expires, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil {
+		return "", ErrInvalidSession
+	}
+	if time.Now().Unix() >= expires {
+		return "", ErrExpiredSession
+	}
+
+	bs, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return "", ErrInvalidSession
+	}
+
+	return string(bs), nil
+}
+
+func (m *Manager) mac(purpose, value string) string {
+	h := hmac.New(sha256.New, m.Key)
+	h.Write([]byte(purpose + "\x00" + value))
+	return base64.RawURLEncoding.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,148 @@
+package session
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testManager() *Manager {
+	return &Manager{Key: []byte("0123456789abcdef0123456789abcdef"), Lifetime: time.Hour}
+}
+
+// startSession returns the session cookie the manager sets for the user.
+func startSession(m *Manager, user string) *http.Cookie {
+	w := httptest.NewRecorder()
+	m.Start(w, user)
+
+	return w.Result().Cookies()[0]
+}
+
+func withCookies(cookies ...*http.Cookie) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "/transmission/web/", nil)
+	for _, c := range cookies {
+		r.AddCookie(c)
+	}
+
+	return r
+}
+
+func TestSession(t *testing.T) {
+	m := testManager()
+	m.Secure = true
+	c := startSession(m, "alice")
+
+	if c.Name != CookieName || !c.HttpOnly || !c.Secure || c.SameSite != http.SameSiteStrictMode || c.Path != "/" {
+		t.Errorf("got cookie %+v", c)
+	}
+	if d := time.Until(c.Expires); d < 59*time.Minute || d > time.Hour {
+		t.Errorf("cookie expires in %s, want the lifetime", d)
+	}
+	if strings.Contains(c.Value, "alice") {
+		t.Errorf("cookie %q holds the user name in clear", c.Value)
+	}
+
+	if user, err := m.User(withCookies(c)); err != nil || user != "alice" {
+		t.Errorf("got user %q, %v", user, err)
+	}
+	if _, err := m.User(withCookies()); !errors.Is(err, ErrNoSession) {
+		t.Errorf("without cookie: got %v, want ErrNoSession", err)
+	}
+}
+
+func TestSessionExpiry(t *testing.T) {
+	m := testManager()
+	m.Lifetime = -time.Second
+	c := startSession(m, "alice")
+
+	if _, err := m.User(withCookies(c)); !errors.Is(err, ErrExpiredSession) {
+		t.Errorf("got %v, want ErrExpiredSession", err)
+	}
+}
+
+func TestSessionTampered(t *testing.T) {
+	m := testManager()
+	value := startSession(m, "alice").Value
+	parts := strings.Split(value, ".")
+	if len(parts) != 3 {
+		t.Fatalf("got cookie %q, want payload.expires.signature", value)
+	}
+
+	other := testManager()
+	other.Key = []byte("another key")
+	csrf := httptest.NewRecorder()
+	token := m.CSRFToken(csrf)
+
+	cases := map[string]string{
+		"other user":       base64.RawURLEncoding.EncodeToString([]byte("root")) + "." + parts[1] + "." + parts[2],
+		"extended expiry":  parts[0] + "." + "99999999999" + "." + parts[2],
+		"bad signature":    parts[0] + "." + parts[1] + "." + strings.Repeat("A", len(parts[2])),
+		"no signature":     parts[0] + "." + parts[1],
+		"garbage":          "alice",
+		"other key":        startSession(other, "alice").Value,
+		"CSRF token reuse": token,
+	}
+
+	for name, value := range cases {
+		t.Run(name, func(t *testing.T) {
+			if user, err := m.User(withCookies(&http.Cookie{Name: CookieName, Value: value})); !errors.Is(err, ErrInvalidSession) {
+				t.Errorf("got user %q, %v, want ErrInvalidSession", user, err)
+			}
+		})
+	}
+}
+
+func TestSessionEnd(t *testing.T) {
+	w := httptest.NewRecorder()
+	testManager().End(w)
+
+	c := w.Result().Cookies()[0]
+	if c.Name != CookieName || c.Value != "" || c.MaxAge >= 0 {
+		t.Errorf("got cookie %+v, want it cleared", c)
+	}
+}
+
+func TestCSRF(t *testing.T) {
+	m := testManager()
+	w := httptest.NewRecorder()
+	token := m.CSRFToken(w)
+	cookie := w.Result().Cookies()[0]
+	if cookie.Name != CSRFCookieName || cookie.Value != token {
+		t.Fatalf("got cookie %+v, want the token", cookie)
+	}
+
+	otherToken := m.CSRFToken(httptest.NewRecorder())
+	session := startSession(m, "alice")
+
+	cases := []struct {
+		name   string
+		cookie *http.Cookie
+		token  string
+		valid  bool
+	}{
+		{name: "matching", cookie: cookie, token: token, valid: true},
+		{name: "no cookie", token: token},
+		{name: "no token", cookie: cookie},
+		{name: "other form", cookie: cookie, token: otherToken},
+		{name: "forged pair", cookie: &http.Cookie{Name: CSRFCookieName, Value: "forged"}, token: "forged"},
+		{name: "session as token", cookie: &http.Cookie{Name: CSRFCookieName, Value: session.Value}, token: session.Value},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := withCookies()
+			if tc.cookie != nil {
+				r.AddCookie(tc.cookie)
+			}
+
+			err := m.CheckCSRF(r, tc.token)
+			if tc.valid && err != nil || !tc.valid && !errors.Is(err, ErrCSRF) {
+				t.Errorf("got %v", err)
+			}
+		})
+	}
+}
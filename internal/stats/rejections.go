@@ -0,0 +1,83 @@
+package stats
+
+import (
+	"sync"
+	"time"
+)
+
+// recentRejectionsSize is the number of most recent rejected requests kept for inspection.
+const recentRejectionsSize = 50
+
+type Rejection struct {
+	Time     time.Time `json:"time"`
+	Method   string    `json:"method,omitempty"`
+	Tag      int       `json:"tag,omitempty"`
+	ClientIP string    `json:"client_ip,omitempty"`
+	Reason   string    `json:"reason"`
+	Body     string    `json:"body,omitempty"`
+	// Shadow is set for requests which were forwarded anyway, as the check runs in shadow mode.
+	Shadow bool `json:"shadow,omitempty"`
+}
+
+type rejections struct {
+	mu    sync.Mutex
+	items [recentRejectionsSize]Rejection
+	count int
+}
+
+func (r *rejections) add(rej Rejection) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.items[r.count%recentRejectionsSize] = rej
+	r.count++
+}
+
+// list returns the stored rejections, newest first.
+func (r *rejections) list() []Rejection {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	n := min(r.count, recentRejectionsSize)
+	res := make([]Rejection, 0, n)
+	for i := 1; i <= n; i++ {
+		res = append(res, r.items[(r.count-i)%recentRejectionsSize])
+	}
+
+	return res
+}
+
+// RecordRejection stores the rejected request in the ring buffer of recent rejections.
+func (r *Registry) RecordRejection(rej Rejection) {
+	r.rejections.add(rej)
+}
+
+// RecentRejections returns the most recent rejected requests, newest first.
+func (r *Registry) RecentRejections() []Rejection {
+	return r.rejections.list()
+}
+
+// RecordShadowRejection stores the request which would have been rejected or changed by the check
+// of the feature group running in shadow mode, and counts it by the group.
+func (r *Registry) RecordShadowRejection(group string, rej Rejection) {
+	rej.Shadow = true
+	r.rejections.add(rej)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.shadow[group]++
+}
+
+// ShadowRejections returns the numbers of shadow rejections by feature group.
+func (r *Registry) ShadowRejections() map[string]uint64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	res := make(map[string]uint64, len(r.shadow))
+	for group, n := range r.shadow {
+		res[group] = n
+	}
+
+	return res
+}
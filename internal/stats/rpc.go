@@ -0,0 +1,80 @@
+package stats
+
+import "sort"
+
+// Outcomes of RPC requests counted by RPC.
+const (
+	OutcomeForwarded     = "forwarded"
+	OutcomeUpstreamError = "upstream_error"
+	OutcomeRejected      = "rejected"
+	OutcomeDryRun        = "dry_run"
+	// OutcomeFailed is the outcome of requests which were neither rejected nor forwarded, e.g. failed policy.
+	OutcomeFailed = "failed"
+)
+
+type rpcKey struct {
+	method, outcome, reason string
+}
+
+// RPC tracks one RPC request from its arrival to the response. Method and outcome are set while the request
+// is processed, Done counts it.
+type RPC struct {
+	r *Registry
+
+	Method  string
+	Outcome string
+	// Reason is the reject reason of rejected requests.
+	Reason string
+}
+
+// StartRPC counts the RPC request in flight until Done is called.
+func (r *Registry) StartRPC() *RPC {
+	r.rpcInFlight.Add(1)
+	return &RPC{r: r, Outcome: OutcomeFailed}
+}
+
+// Reject sets the outcome of rejected request.
+func (c *RPC) Reject(reason string) {
+	c.Outcome, c.Reason = OutcomeRejected, reason
+}
+
+func (c *RPC) Done() {
+	c.r.rpcInFlight.Add(-1)
+
+	c.r.mu.Lock()
+	defer c.r.mu.Unlock()
+
+	c.r.rpcs[rpcKey{method: c.Method, outcome: c.Outcome, reason: c.Reason}]++
+}
+
+func (r *Registry) writeRPCPrometheus(ew *errWriter) {
+	r.mu.Lock()
+	keys := make([]rpcKey, 0, len(r.rpcs))
+	counts := make(map[rpcKey]uint64, len(r.rpcs))
+	for k, n := range r.rpcs {
+		keys = append(keys, k)
+		counts[k] = n
+	}
+	r.mu.Unlock()
+
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i], keys[j]
+		if a.method != b.method {
+			return a.method < b.method
+		}
+		if a.outcome != b.outcome {
+			return a.outcome < b.outcome
+		}
+		return a.reason < b.reason
+	})
+
+	ew.printf("# HELP transmission_proxy_rpc_requests_total RPC requests by method and outcome.\n")
+	ew.printf("# TYPE transmission_proxy_rpc_requests_total counter\n")
+	for _, k := range keys {
+		ew.printf("transmission_proxy_rpc_requests_total{method=%q,outcome=%q,reason=%q} %d\n", k.method, k.outcome, k.reason, counts[k])
+	}
+
+	ew.printf("# HELP transmission_proxy_rpc_requests_in_flight RPC requests being processed.\n")
+	ew.printf("# TYPE transmission_proxy_rpc_requests_in_flight gauge\n")
+	ew.printf("transmission_proxy_rpc_requests_in_flight %d\n", r.rpcInFlight.Load())
+}
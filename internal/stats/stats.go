@@ -0,0 +1,303 @@
+package stats
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// latencyWindow is the number of most recent latency samples kept per upstream to compute quantiles.
+const latencyWindow = 1024
+
+var quantiles = []float64{0.5, 0.9, 0.99}
+
+// latencyBuckets are the upper bounds of upstream latency histogram buckets in seconds.
+var latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+type Registry struct {
+	mu           sync.Mutex
+	upstreams    map[string]*Upstream
+	rejections   rejections
+	shadow       map[string]uint64
+	authLockouts atomic.Uint64
+	mirrorDrops  atomic.Uint64
+	rpcs         map[rpcKey]uint64
+	rpcInFlight  atomic.Int64
+}
+
+func NewRegistry() *Registry {
+	return &Registry{upstreams: map[string]*Upstream{}, shadow: map[string]uint64{}, rpcs: map[rpcKey]uint64{}}
+}
+
+// Upstream returns statistics tracker for the given upstream host, creating it if needed.
+func (r *Registry) Upstream(host string) *Upstream {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	u, ok := r.upstreams[host]
+	if !ok {
+		u = &Upstream{errors: map[string]uint64{}, buckets: make([]uint64, len(latencyBuckets))}
+		r.upstreams[host] = u
+	}
+
+	return u
+}
+
+// RecordAuthLockout counts client locked out after repeated authentication failures.
+func (r *Registry) RecordAuthLockout() {
+	r.authLockouts.Add(1)
+}
+
+// RecordMirrorDrop counts request not mirrored as the mirror queue was full.
+func (r *Registry) RecordMirrorDrop() {
+	r.mirrorDrops.Add(1)
+}
+
+// MirrorDrops returns the number of requests not mirrored as the mirror queue was full.
+func (r *Registry) MirrorDrops() uint64 {
+	return r.mirrorDrops.Load()
+}
+
+type Upstream struct {
+	// connsOpen and connsActive count the connections to the upstream, and the ones serving a request
+	connsOpen   atomic.Int64
+	connsActive atomic.Int64
+	connsReused atomic.Uint64
+	connsNew    atomic.Uint64
+
+	mu        sync.Mutex
+	requests  uint64
+	errors    map[string]uint64
+	latencies [latencyWindow]float64
+	samples   int
+	// buckets count all the round trips by the first of latencyBuckets they fit in
+	buckets    []uint64
+	latencySum float64
+}
+
+// Observe records one upstream round trip. Empty errClass means the request succeeded.
+func (u *Upstream) Observe(d time.Duration, errClass string) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	u.requests++
+	if errClass != "" {
+		u.errors[errClass]++
+	}
+
+	u.latencies[u.samples%latencyWindow] = float64(d) / float64(time.Millisecond)
+	u.samples++
+
+	u.latencySum += d.Seconds()
+	if i := sort.SearchFloat64s(latencyBuckets, d.Seconds()); i < len(latencyBuckets) {
+		u.buckets[i]++
+	}
+}
+
+// ConnOpened records new connection to the upstream.
+func (u *Upstream) ConnOpened() {
+	u.connsOpen.Add(1)
+}
+
+// ConnClosed records connection to the upstream closed.
+func (u *Upstream) ConnClosed() {
+	u.connsOpen.Add(-1)
+}
+
+// ConnAcquired records request getting connection to the upstream, either reused or new one.
+func (u *Upstream) ConnAcquired(reused bool) {
+	u.connsActive.Add(1)
+	if reused {
+		u.connsReused.Add(1)
+	} else {
+		u.connsNew.Add(1)
+	}
+}
+
+// ConnReleased records request done with its connection.
+func (u *Upstream) ConnReleased() {
+	u.connsActive.Add(-1)
+}
+
+type UpstreamSnapshot struct {
+	Requests  uint64             `json:"requests"`
+	Errors    map[string]uint64  `json:"errors"`
+	LatencyMs map[string]float64 `json:"latency_ms"`
+	// ConnsActive counts the requests being served, several of which may share one HTTP/2 connection.
+	ConnsActive int64  `json:"connections_active"`
+	ConnsIdle   int64  `json:"connections_idle"`
+	ConnsReused uint64 `json:"connections_reused"`
+	ConnsNew    uint64 `json:"connections_new"`
+
+	buckets    []uint64
+	latencySum float64
+}
+
+func (u *Upstream) snapshot() UpstreamSnapshot {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	active := u.connsActive.Load()
+	s := UpstreamSnapshot{
+		Requests:    u.requests,
+		Errors:      make(map[string]uint64, len(u.errors)),
+		LatencyMs:   make(map[string]float64, len(quantiles)),
+		ConnsActive: active,
+		ConnsIdle:   max(u.connsOpen.Load()-active, 0),
+		ConnsReused: u.connsReused.Load(),
+		ConnsNew:    u.connsNew.Load(),
+
+		buckets:    slices.Clone(u.buckets),
+		latencySum: u.latencySum,
+	}
+	for class, n := range u.errors {
+		s.Errors[class] = n
+	}
+
+	n := min(u.samples, latencyWindow)
+	if n == 0 {
+		return s
+	}
+
+	sorted := make([]float64, n)
+	copy(sorted, u.latencies[:n])
+	sort.Float64s(sorted)
+	for _, q := range quantiles {
+		s.LatencyMs[quantileLabel(q)] = sorted[int(q*float64(n-1))]
+	}
+
+	return s
+}
+
+// Upstreams returns the point-in-time statistics of every upstream keyed by host.
+func (r *Registry) Upstreams() map[string]UpstreamSnapshot {
+	r.mu.Lock()
+	ups := make(map[string]*Upstream, len(r.upstreams))
+	for host, u := range r.upstreams {
+		ups[host] = u
+	}
+	r.mu.Unlock()
+
+	res := make(map[string]UpstreamSnapshot, len(ups))
+	for host, u := range ups {
+		res[host] = u.snapshot()
+	}
+
+	return res
+}
+
+// WritePrometheus renders the statistics in Prometheus text exposition format.
+func (r *Registry) WritePrometheus(w io.Writer) error {
+	ups := r.Upstreams()
+	hosts := make([]string, 0, len(ups))
+	for host := range ups {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+
+	ew := &errWriter{w: w}
+
+	ew.printf("# HELP transmission_proxy_upstream_requests_total Requests sent to upstream.\n")
+	ew.printf("# TYPE transmission_proxy_upstream_requests_total counter\n")
+	for _, host := range hosts {
+		ew.printf("transmission_proxy_upstream_requests_total{upstream=%q} %d\n", host, ups[host].Requests)
+	}
+
+	ew.printf("# HELP transmission_proxy_upstream_errors_total Failed upstream requests by error class.\n")
+	ew.printf("# TYPE transmission_proxy_upstream_errors_total counter\n")
+	for _, host := range hosts {
+		classes := make([]string, 0, len(ups[host].Errors))
+		for class := range ups[host].Errors {
+			classes = append(classes, class)
+		}
+		sort.Strings(classes)
+
+		for _, class := range classes {
+			ew.printf("transmission_proxy_upstream_errors_total{upstream=%q,class=%q} %d\n",
+				host, class, ups[host].Errors[class])
+		}
+	}
+
+	ew.printf("# HELP transmission_proxy_upstream_latency_ms Upstream round trip latency over recent requests.\n")
+	ew.printf("# TYPE transmission_proxy_upstream_latency_ms summary\n")
+	for _, host := range hosts {
+		for _, q := range quantiles {
+			if v, ok := ups[host].LatencyMs[quantileLabel(q)]; ok {
+				ew.printf("transmission_proxy_upstream_latency_ms{upstream=%q,quantile=\"%g\"} %g\n", host, q, v)
+			}
+		}
+	}
+
+	ew.printf("# HELP transmission_proxy_upstream_latency_seconds Upstream round trip latency.\n")
+	ew.printf("# TYPE transmission_proxy_upstream_latency_seconds histogram\n")
+	for _, host := range hosts {
+		var n uint64
+		for i, le := range latencyBuckets {
+			n += ups[host].buckets[i]
+			ew.printf("transmission_proxy_upstream_latency_seconds_bucket{upstream=%q,le=\"%g\"} %d\n", host, le, n)
+		}
+		ew.printf("transmission_proxy_upstream_latency_seconds_bucket{upstream=%q,le=\"+Inf\"} %d\n", host, ups[host].Requests)
+		ew.printf("transmission_proxy_upstream_latency_seconds_sum{upstream=%q} %g\n", host, ups[host].latencySum)
+		ew.printf("transmission_proxy_upstream_latency_seconds_count{upstream=%q} %d\n", host, ups[host].Requests)
+	}
+
+	ew.printf("# HELP transmission_proxy_upstream_connections Connections to upstream by state, active ones are counted by requests served.\n")
+	ew.printf("# TYPE transmission_proxy_upstream_connections gauge\n")
+	for _, host := range hosts {
+		ew.printf("transmission_proxy_upstream_connections{upstream=%q,state=\"active\"} %d\n", host, ups[host].ConnsActive)
+		ew.printf("transmission_proxy_upstream_connections{upstream=%q,state=\"idle\"} %d\n", host, ups[host].ConnsIdle)
+	}
+
+	ew.printf("# HELP transmission_proxy_upstream_connections_acquired_total Connections to upstream acquired by requests, by whether they were reused.\n")
+	ew.printf("# TYPE transmission_proxy_upstream_connections_acquired_total counter\n")
+	for _, host := range hosts {
+		ew.printf("transmission_proxy_upstream_connections_acquired_total{upstream=%q,reused=\"true\"} %d\n", host, ups[host].ConnsReused)
+		ew.printf("transmission_proxy_upstream_connections_acquired_total{upstream=%q,reused=\"false\"} %d\n", host, ups[host].ConnsNew)
+	}
+
+	r.writeRPCPrometheus(ew)
+
+	ew.printf("# HELP transmission_proxy_auth_lockouts_total Clients locked out after repeated authentication failures.\n")
+	ew.printf("# TYPE transmission_proxy_auth_lockouts_total counter\n")
+	ew.printf("transmission_proxy_auth_lockouts_total %d\n", r.authLockouts.Load())
+
+	ew.printf("# HELP transmission_proxy_mirror_dropped_total Requests not mirrored as the mirror queue was full.\n")
+	ew.printf("# TYPE transmission_proxy_mirror_dropped_total counter\n")
+	ew.printf("transmission_proxy_mirror_dropped_total %d\n", r.mirrorDrops.Load())
+
+	shadow := r.ShadowRejections()
+	groups := make([]string, 0, len(shadow))
+	for group := range shadow {
+		groups = append(groups, group)
+	}
+	sort.Strings(groups)
+
+	ew.printf("# HELP transmission_proxy_shadow_rejections_total Requests forwarded although checks in shadow mode would have rejected or changed them.\n")
+	ew.printf("# TYPE transmission_proxy_shadow_rejections_total counter\n")
+	for _, group := range groups {
+		ew.printf("transmission_proxy_shadow_rejections_total{group=%q} %d\n", group, shadow[group])
+	}
+
+	return ew.err
+}
+
+func quantileLabel(q float64) string {
+	return fmt.Sprintf("p%g", q*100)
+}
+
+type errWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (e *errWriter) printf(format string, args ...any) {
+	if e.err != nil {
+		return
+	}
+
+	_, e.err = fmt.Fprintf(e.w, format, args...)
+}
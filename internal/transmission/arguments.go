@@ -0,0 +1,109 @@
+package transmission
+
+import "errors"
+
+var ErrFilenameOrMetainfo = errors.New("filename or metainfo must be provided")
+
+type TorrentAddArguments struct {
+	Cookies           *string  `json:"cookies"`
+	DownloadDir       *string  `json:"download-dir"`
+	Filename          *string  `json:"filename"`
+	Labels            []string `json:"labels"`
+	Metainfo          *string  `json:"metainfo"`
+	Paused            *bool    `json:"paused"`
+	PeerLimit         *int64   `json:"peer-limit"`
+	BandwidthPriority *int64   `json:"bandwidthPriority"`
+	FilesWanted       []int64  `json:"files-wanted"`
+	FilesUnwanted     []int64  `json:"files-unwanted"`
+	PriorityHigh      []int64  `json:"priority-high"`
+	PriorityLow       []int64  `json:"priority-low"`
+	PriorityNormal    []int64  `json:"priority-normal"`
+}
+
+func (a *TorrentAddArguments) ValidateArguments() error {
+	if a.Filename == nil && a.Metainfo == nil {
+		return ErrFilenameOrMetainfo
+	}
+
+	return nil
+}
+
+type TorrentSetArguments struct {
+	BandwidthPriority   *int64   `json:"bandwidthPriority"`
+	DownloadLimit       *int64   `json:"downloadLimit"`
+	DownloadLimited     *bool    `json:"downloadLimited"`
+	FilesUnwanted       []int64  `json:"files-unwanted"`
+	FilesWanted         []int64  `json:"files-wanted"`
+	Group               *string  `json:"group"`
+	HonorsSessionLimits *bool    `json:"honorsSessionLimits"`
+	Ids                 any      `json:"ids"`
+	Labels              []string `json:"labels"`
+	Location            *string  `json:"location"`
+	PeerLimit           *int64   `json:"peer-limit"`
+	PriorityHigh        []int64  `json:"priority-high"`
+	PriorityLow         []int64  `json:"priority-low"`
+	PriorityNormal      []int64  `json:"priority-normal"`
+	QueuePosition       *int64   `json:"queuePosition"`
+	SeedIdleLimit       *int64   `json:"seedIdleLimit"`
+	SeedIdleMode        *int64   `json:"seedIdleMode"`
+	SeedRatioLimit      *float64 `json:"seedRatioLimit"`
+	SeedRatioMode       *int64   `json:"seedRatioMode"`
+	SequentialDownload  *bool    `json:"sequentialDownload"`
+	TrackerList         *string  `json:"trackerList"`
+	UploadLimit         *int64   `json:"uploadLimit"`
+	UploadLimited       *bool    `json:"uploadLimited"`
+}
+
+type TorrentSetLocationArguments struct {
+	Ids      any     `json:"ids"`
+	Location *string `json:"location"`
+	Move     *bool   `json:"move"`
+}
+
+type TorrentRenamePathArguments struct {
+	Ids  any     `json:"ids"`
+	Path *string `json:"path"`
+	Name *string `json:"name"`
+}
+
+// SessionSetArguments lists settings which may be changed through the proxy. Settings allowing to run
+// scripts, to change incomplete dir or peer port are deliberately absent and are skipped.
+type SessionSetArguments struct {
+	AltSpeedDown              *int64   `json:"alt-speed-down"`
+	AltSpeedEnabled           *bool    `json:"alt-speed-enabled"`
+	AltSpeedTimeBegin         *int64   `json:"alt-speed-time-begin"`
+	AltSpeedTimeDay           *int64   `json:"alt-speed-time-day"`
+	AltSpeedTimeEnabled       *bool    `json:"alt-speed-time-enabled"`
+	AltSpeedTimeEnd           *int64   `json:"alt-speed-time-end"`
+	AltSpeedUp                *int64   `json:"alt-speed-up"`
+	BlocklistEnabled          *bool    `json:"blocklist-enabled"`
+	BlocklistURL              *string  `json:"blocklist-url"`
+	CacheSizeMB               *int64   `json:"cache-size-mb"`
+	DefaultTrackers           *string  `json:"default-trackers"`
+	DHTEnabled                *bool    `json:"dht-enabled"`
+	DownloadDir               *string  `json:"download-dir"`
+	DownloadQueueEnabled      *bool    `json:"download-queue-enabled"`
+	DownloadQueueSize         *int64   `json:"download-queue-size"`
+	Encryption                *string  `json:"encryption"`
+	IdleSeedingLimitEnabled   *bool    `json:"idle-seeding-limit-enabled"`
+	IdleSeedingLimit          *int64   `json:"idle-seeding-limit"`
+	LPDEnabled                *bool    `json:"lpd-enabled"`
+	PeerLimitGlobal           *int64   `json:"peer-limit-global"`
+	PeerLimitPerTorrent       *int64   `json:"peer-limit-per-torrent"`
+	PEXEnabled                *bool    `json:"pex-enabled"`
+	PortForwardingEnabled     *bool    `json:"port-forwarding-enabled"`
+	QueueStalledEnabled       *bool    `json:"queue-stalled-enabled"`
+	QueueStalledMinutes       *int64   `json:"queue-stalled-minutes"`
+	RenamePartialFiles        *bool    `json:"rename-partial-files"`
+	SeedQueueEnabled          *bool    `json:"seed-queue-enabled"`
+	SeedQueueSize             *int64   `json:"seed-queue-size"`
+	SeedRatioLimit            *float64 `json:"seedRatioLimit"`
+	SeedRatioLimited          *bool    `json:"seedRatioLimited"`
+	SpeedLimitDownEnabled     *bool    `json:"speed-limit-down-enabled"`
+	SpeedLimitDown            *int64   `json:"speed-limit-down"`
+	SpeedLimitUpEnabled       *bool    `json:"speed-limit-up-enabled"`
+	SpeedLimitUp              *int64   `json:"speed-limit-up"`
+	StartAddedTorrents        *bool    `json:"start-added-torrents"`
+	TrashOriginalTorrentFiles *bool    `json:"trash-original-torrent-files"`
+	UTPEnabled                *bool    `json:"utp-enabled"`
+}
@@ -0,0 +1,136 @@
+package transmission
+
+import (
+	"fmt"
+	"os"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ValidatorConfig is the YAML configuration of additional validation rules, e.g.:
+//
+//	methods:
+//	  torrent-set-location:
+//	    rules:
+//	      - requires: [move, location]
+//	  session-set:
+//	    rules:
+//	      - mutually_exclusive: [speed-limit-up, alt-speed-up]
+//	      - required_when: {field: alt-speed-enabled, value: true, requires: alt-speed-up}
+type ValidatorConfig struct {
+	Methods map[string]MethodConfig `yaml:"methods"`
+	// Metainfo enables decoding and checking torrent files sent as torrent-add metainfo.
+	Metainfo *MetainfoConfig `yaml:"metainfo"`
+}
+
+type MetainfoConfig struct {
+	MaxSize  int64  `yaml:"max_size"`
+	MaxFiles int    `yaml:"max_files"`
+	Private  string `yaml:"private"`
+}
+
+type MethodConfig struct {
+	Rules []RuleConfig `yaml:"rules"`
+}
+
+type RuleConfig struct {
+	Requires          []string            `yaml:"requires"`
+	MutuallyExclusive []string            `yaml:"mutually_exclusive"`
+	RequiredWhen      *RequiredWhenConfig `yaml:"required_when"`
+}
+
+type RequiredWhenConfig struct {
+	Field    string `yaml:"field"`
+	Value    any    `yaml:"value"`
+	Requires string `yaml:"requires"`
+}
+
+func LoadValidatorConfig(path string) (*ValidatorConfig, error) {
+	bs, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var cfg ValidatorConfig
+	if err = yaml.Unmarshal(bs, &cfg); err != nil {
+		return nil, fmt.Errorf("parse: %w", err)
+	}
+
+	return &cfg, nil
+}
+
+func (r *RuleConfig) rule() (DependencyRule, error) {
+	switch {
+	case r.Requires != nil && r.MutuallyExclusive == nil && r.RequiredWhen == nil:
+		if len(r.Requires) != 2 {
+			return nil, fmt.Errorf("requires must list exactly two fields")
+		}
+		return Requires(r.Requires[0], r.Requires[1]), nil
+	case r.MutuallyExclusive != nil && r.Requires == nil && r.RequiredWhen == nil:
+		if len(r.MutuallyExclusive) != 2 {
+			return nil, fmt.Errorf("mutually_exclusive must list exactly two fields")
+		}
+		return MutuallyExclusive(r.MutuallyExclusive[0], r.MutuallyExclusive[1]), nil
+	case r.RequiredWhen != nil && r.Requires == nil && r.MutuallyExclusive == nil:
+		if r.RequiredWhen.Field == "" || r.RequiredWhen.Requires == "" {
+			return nil, fmt.Errorf("required_when must define field and requires")
+		}
+		return RequiredWhen(r.RequiredWhen.Field, r.RequiredWhen.Value, r.RequiredWhen.Requires), nil
+	default:
+		return nil, fmt.Errorf("rule must define exactly one of requires, mutually_exclusive, required_when")
+	}
+}
+
+// Apply adds configured rules to the methods of the validator.
+func (c *ValidatorConfig) Apply(p *MethodsValidator) error {
+	for method, mc := range c.Methods {
+		v, ok := p.Methods[method]
+		if !ok {
+			return fmt.Errorf("unknown method %q", method)
+		}
+
+		hr, ok := v.(HasRules)
+		if !ok {
+			return fmt.Errorf("method %q does not support rules", method)
+		}
+
+		rules := make([]DependencyRule, 0, len(mc.Rules))
+		for i, rc := range mc.Rules {
+			rule, err := rc.rule()
+			if err != nil {
+				return fmt.Errorf("method %q rule #%d: %w", method, i+1, err)
+			}
+			rules = append(rules, rule)
+		}
+
+		p.Methods[method] = hr.WithRules(rules...)
+	}
+
+	if c.Metainfo != nil {
+		return c.Metainfo.apply(p)
+	}
+
+	return nil
+}
+
+func (c *MetainfoConfig) apply(p *MethodsValidator) error {
+	switch {
+	case c.MaxSize < 0 || c.MaxFiles < 0:
+		return fmt.Errorf("metainfo limits must not be negative")
+	case c.Private != PrivateAny && c.Private != PrivateOnly && c.Private != PrivateForbid:
+		return fmt.Errorf("metainfo private must be either %s or %s", PrivateOnly, PrivateForbid)
+	}
+
+	v, ok := p.Methods["torrent-add"]
+	if !ok {
+		return nil
+	}
+
+	hf, ok := v.(HasFields)
+	if !ok {
+		return fmt.Errorf("method torrent-add does not support metainfo checks")
+	}
+
+	p.Methods["torrent-add"] = hf.WithField("metainfo", &MetainfoValidator{MaxSize: c.MaxSize, MaxFiles: c.MaxFiles, Private: c.Private})
+	return nil
+}
@@ -0,0 +1,118 @@
+package transmission
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"transmission-proxy/internal/logger"
+	"transmission-proxy/internal/response"
+)
+
+// MaxArgumentErrors caps the number of argument errors collected in one validation pass.
+const MaxArgumentErrors = 20
+
+// ArgumentErrors is returned when more than one argument failed validation.
+// Every item implements IsBadArgument.
+type ArgumentErrors struct {
+	Errors []error
+	// Truncated is set when validation stopped after collecting MaxArgumentErrors errors.
+	Truncated bool
+}
+
+func (e *ArgumentErrors) Error() string {
+	parts := make([]string, 0, len(e.Errors))
+	for _, err := range e.Errors {
+		parts = append(parts, argumentName(err)+": "+argumentReason(err))
+	}
+
+	msg := fmt.Sprintf("%d bad arguments: %s", len(e.Errors), strings.Join(parts, "; "))
+	if e.Truncated {
+		msg += "; more errors omitted"
+	}
+
+	return msg
+}
+
+func (e *ArgumentErrors) Unwrap() []error {
+	return e.Errors
+}
+
+func (e *ArgumentErrors) GetLoggableAttrs() []slog.Attr {
+	fields := make([]string, 0, len(e.Errors))
+	for _, err := range e.Errors {
+		fields = append(fields, argumentName(err))
+	}
+
+	return []slog.Attr{logger.RPC(slog.Any(logger.KeyFields, fields))}
+}
+
+func (e *ArgumentErrors) ErrorDetails() []response.ErrorDetail {
+	res := make([]response.ErrorDetail, 0, len(e.Errors))
+	for _, err := range e.Errors {
+		res = append(res, response.ErrorDetail{Field: argumentName(err), Reason: argumentReason(err)})
+	}
+
+	return res
+}
+
+func argumentReason(err error) string {
+	if ba, ok := err.(*badArgument); ok {
+		return ba.err.Error()
+	}
+
+	return err.Error()
+}
+
+func argumentName(err error) string {
+	if ba, ok := err.(IsBadArgument); ok {
+		return ba.GetBadArgument()
+	}
+
+	return ""
+}
+
+// argumentErrors collects errors of a single validation pass.
+type argumentErrors struct {
+	failFast  bool
+	errs      []error
+	truncated bool
+}
+
+// add records the error and reports whether validation should stop.
+func (c *argumentErrors) add(err error) bool {
+	if len(c.errs) >= MaxArgumentErrors {
+		c.truncated = true
+		return true
+	}
+
+	c.errs = append(c.errs, err)
+	return c.failFast
+}
+
+// has reports whether an error was recorded for the argument already.
+func (c *argumentErrors) has(field string) bool {
+	for _, err := range c.errs {
+		if ba, ok := err.(IsBadArgument); ok && ba.GetBadArgument() == field {
+			return true
+		}
+	}
+
+	return false
+}
+
+// done reports whether validation should not continue collecting errors.
+func (c *argumentErrors) done() bool {
+	return c.truncated || c.failFast && len(c.errs) > 0
+}
+
+func (c *argumentErrors) err() error {
+	switch len(c.errs) {
+	case 0:
+		return nil
+	case 1:
+		return c.errs[0]
+	default:
+		return &ArgumentErrors{Errors: c.errs, Truncated: c.truncated}
+	}
+}
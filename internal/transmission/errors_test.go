@@ -0,0 +1,135 @@
+package transmission
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"transmission-proxy/internal/response"
+)
+
+// mustBePositive rejects everything but positive integers.
+type mustBePositive struct{}
+
+func (mustBePositive) Validate(key string, value any) error {
+	if n, ok := value.(int); !ok || n <= 0 {
+		return fmt.Errorf("must be positive, got %v", value)
+	}
+
+	return nil
+}
+
+// badArguments returns arguments of which n are rejected by the validator returned too.
+func badArguments(n int) (*MethodArgumentsValidator, map[string]any) {
+	v := &MethodArgumentsValidator{Arguments: map[string]ArgumentValidator{"good": mustBePositive{}}}
+	args := map[string]any{"good": 1}
+	for i := 0; i < n; i++ {
+		key := fmt.Sprintf("bad-%02d", i)
+		v.Arguments[key] = mustBePositive{}
+		args[key] = -i
+	}
+
+	return v, args
+}
+
+func TestArgumentErrorsCollected(t *testing.T) {
+	v, args := badArguments(3)
+
+	_, err, _ := v.Validate(args)
+	var errs *ArgumentErrors
+	if !errors.As(err, &errs) {
+		t.Fatalf("got error %v, want all bad arguments", err)
+	}
+	if len(errs.Errors) != 3 || errs.Truncated {
+		t.Fatalf("got %v", errs)
+	}
+	for i, e := range errs.Errors {
+		if ba, ok := e.(IsBadArgument); !ok || ba.GetBadArgument() != fmt.Sprintf("bad-%02d", i) {
+			t.Errorf("error %d: got %v", i, e)
+		}
+	}
+	if !strings.HasPrefix(err.Error(), "3 bad arguments: bad-00: must be positive, got 0; bad-01") {
+		t.Errorf("got message %q", err)
+	}
+	if RejectReason(err) != RejectBadArgument {
+		t.Errorf("got reject reason %s", RejectReason(err))
+	}
+
+	attrs := errs.GetLoggableAttrs()
+	if len(attrs) != 1 || !strings.Contains(attrs[0].String(), "fields=[bad-00 bad-01 bad-02]") {
+		t.Errorf("got attributes %v", attrs)
+	}
+}
+
+func TestArgumentErrorsCap(t *testing.T) {
+	v, args := badArguments(MaxArgumentErrors + 5)
+
+	_, err, _ := v.Validate(args)
+	var errs *ArgumentErrors
+	if !errors.As(err, &errs) {
+		t.Fatalf("got error %v", err)
+	}
+	if len(errs.Errors) != MaxArgumentErrors || !errs.Truncated || !strings.HasSuffix(err.Error(), "more errors omitted") {
+		t.Errorf("got %d errors, truncated %v: %v", len(errs.Errors), errs.Truncated, err)
+	}
+}
+
+func TestArgumentErrorsSingle(t *testing.T) {
+	v, args := badArguments(1)
+
+	_, err, _ := v.Validate(args)
+	var errs *ArgumentErrors
+	var ba IsBadArgument
+	if errors.As(err, &errs) || !errors.As(err, &ba) || ba.GetBadArgument() != "bad-00" {
+		t.Errorf("got error %#v, want the single bad argument", err)
+	}
+}
+
+func TestArgumentErrorsFailFast(t *testing.T) {
+	v, args := badArguments(3)
+	v.FailFast = true
+
+	_, err, _ := v.Validate(args)
+	var errs *ArgumentErrors
+	var ba IsBadArgument
+	if errors.As(err, &errs) || !errors.As(err, &ba) || ba.GetBadArgument() != "bad-00" {
+		t.Errorf("got error %v, want the first bad argument only", err)
+	}
+}
+
+func TestArgumentErrorsResponse(t *testing.T) {
+	v, args := badArguments(2)
+	_, err, _ := v.Validate(args)
+
+	respond := func(debug bool) map[string]any {
+		w := httptest.NewRecorder()
+		rr := &response.Responder{DebugMode: debug}
+		rr.RespondAndLogCustom(w, context.Background(), err, 0, slog.LevelDebug, http.StatusBadRequest)
+
+		var body map[string]any
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			t.Fatal(err)
+		}
+		return body
+	}
+
+	body := respond(true)
+	details, _ := body["errors"].([]any)
+	if len(details) != 2 {
+		t.Fatalf("got body %v, want both errors listed", body)
+	}
+	if d, _ := details[1].(map[string]any); d["field"] != "bad-01" || d["reason"] != "must be positive, got -1" {
+		t.Errorf("got detail %v", details[1])
+	}
+
+	body = respond(false)
+	if _, ok := body["errors"]; ok || strings.Contains(fmt.Sprint(body), "bad-0") {
+		t.Errorf("got body %v, want generic one outside debug mode", body)
+	}
+}
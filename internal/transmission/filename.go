@@ -0,0 +1,99 @@
+package transmission
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"slices"
+	"strings"
+
+	"transmission-proxy/internal/logger"
+	"transmission-proxy/internal/magnet"
+)
+
+// FilenameValidator checks torrent-add filename, which Transmission takes as magnet link, URL to fetch
+// the torrent file from or path of the torrent file on the daemon host. Magnet links are checked to be
+// well-formed. Zero value accepts any filename but malformed magnet links.
+type FilenameValidator struct {
+	// ForbidLocal rejects paths on the daemon host, including file:// URLs.
+	ForbidLocal bool
+	// TrackerAllow, when not empty, lists the only tracker hosts magnet links may have, TrackerDeny the hosts
+	// they may not have.
+	TrackerAllow []string
+	TrackerDeny  []string
+	// URLHosts, when not empty, lists the only hosts torrent files may be fetched from. Only http and https
+	// URLs are accepted then.
+	URLHosts []string
+}
+
+// valueError is rejection of the argument which logs the rejected value.
+type valueError struct {
+	value string
+	err   error
+}
+
+func (e *valueError) Error() string {
+	return e.err.Error()
+}
+
+func (e *valueError) Unwrap() error {
+	return e.err
+}
+
+func (e *valueError) GetLoggableAttrs() []slog.Attr {
+	return []slog.Attr{logger.RPC(slog.String(logger.KeyValue, e.value))}
+}
+
+func (v *FilenameValidator) Validate(key string, value any) error {
+	s, ok := value.(string)
+	if !ok {
+		return fmt.Errorf("must be string, got %s", represent(value))
+	}
+
+	if err := v.check(s); err != nil {
+		return &valueError{value: s, err: err}
+	}
+
+	return nil
+}
+
+func (v *FilenameValidator) check(s string) error {
+	if strings.HasPrefix(strings.ToLower(s), "magnet:") {
+		link, err := magnet.Parse(s)
+		if err == nil && len(v.TrackerAllow) > 0 {
+			err = link.CheckTrackers(v.TrackerAllow)
+		}
+		if err == nil && len(v.TrackerDeny) > 0 {
+			err = link.DenyTrackers(v.TrackerDeny)
+		}
+		return err
+	}
+
+	// Transmission fetches anything looking like URL and opens everything else as a file
+	if !strings.Contains(s, "://") {
+		if v.ForbidLocal {
+			return errors.New("paths on the daemon host are not allowed")
+		}
+		return nil
+	}
+
+	u, err := url.Parse(s)
+	if err != nil {
+		return errors.New("malformed URL")
+	}
+
+	scheme := strings.ToLower(u.Scheme)
+	switch {
+	case scheme == "file" && v.ForbidLocal:
+		return errors.New("paths on the daemon host are not allowed")
+	case len(v.URLHosts) == 0:
+		return nil
+	case scheme != "http" && scheme != "https":
+		return fmt.Errorf("only http and https URLs are allowed, got %s", scheme)
+	case !slices.Contains(v.URLHosts, strings.ToLower(u.Hostname())):
+		return fmt.Errorf("host %s is not allowed", u.Hostname())
+	}
+
+	return nil
+}
@@ -0,0 +1,86 @@
+package transmission
+
+import (
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"transmission-proxy/internal/logger"
+	"transmission-proxy/internal/magnet"
+)
+
+const testMagnetURI = "magnet:?xt=urn:btih:c9e15763f722f23e98a29decdfae341b98d53056&dn=Debian"
+
+func TestFilenameValidator(t *testing.T) {
+	restricted := FilenameValidator{
+		ForbidLocal:  true,
+		TrackerAllow: []string{"tracker.example", "open.example"},
+		TrackerDeny:  []string{"open.example"},
+		URLHosts:     []string{"torrents.example"},
+	}
+
+	cases := []struct {
+		name     string
+		v        FilenameValidator
+		filename any
+		// err is the message expected in the error, empty if valid
+		err string
+	}{
+		// no configuration keeps accepting everything but malformed magnet links
+		{name: "default path", filename: "/var/lib/transmission/a.torrent"},
+		{name: "default file URL", filename: "file:///etc/passwd"},
+		{name: "default URL", filename: "ftp://anywhere.example/a.torrent"},
+		{name: "default magnet", filename: testMagnetURI + "&tr=udp://any.example:1337"},
+		{name: "malformed magnet", filename: "magnet:?dn=Debian", err: magnet.ErrNoInfoHash.Error()},
+		{name: "bad hash", filename: "magnet:?xt=urn:btih:xyz", err: magnet.ErrBadInfoHash.Error()},
+		{name: "uppercase magnet", filename: "MAGNET:" + strings.TrimPrefix(testMagnetURI, "magnet:")},
+		{name: "uppercase malformed magnet", filename: "Magnet:?dn=Debian", err: magnet.ErrNoInfoHash.Error()},
+		{name: "not string", filename: 1, err: "must be string"},
+
+		{name: "local path", v: restricted, filename: "/var/lib/transmission/a.torrent", err: "paths on the daemon host are not allowed"},
+		{name: "relative path", v: restricted, filename: "a.torrent", err: "paths on the daemon host are not allowed"},
+		{name: "file URL", v: FilenameValidator{ForbidLocal: true}, filename: "FILE:///etc/passwd", err: "paths on the daemon host are not allowed"},
+		{name: "allowed tracker", v: restricted, filename: testMagnetURI + "&tr=http://Tracker.example/announce"},
+		{name: "no trackers", v: restricted, filename: testMagnetURI},
+		{name: "unlisted tracker", v: restricted, filename: testMagnetURI + "&tr=http://tracker.example/a&tr=udp://other.example:1",
+			err: "tracker is not allowed: udp://other.example:1"},
+		{name: "denied tracker", v: restricted, filename: testMagnetURI + "&tr=udp://open.example:1337",
+			err: "tracker is not allowed: udp://open.example:1337"},
+		{name: "allowed host", v: restricted, filename: "https://Torrents.example/a.torrent"},
+		{name: "unlisted host", v: restricted, filename: "https://evil.example/a.torrent", err: "host evil.example is not allowed"},
+		{name: "host with userinfo", v: restricted, filename: "https://torrents.example@evil.example/a.torrent", err: "host evil.example is not allowed"},
+		{name: "other scheme", v: restricted, filename: "ftp://torrents.example/a.torrent", err: "only http and https URLs are allowed, got ftp"},
+		{name: "malformed URL", v: restricted, filename: "http://torrents.example:port/", err: "malformed URL"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.v.Validate("filename", tc.filename)
+			if tc.err == "" {
+				if err != nil {
+					t.Errorf("got error %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("got error %v, want %q", err, tc.err)
+			}
+		})
+	}
+}
+
+func TestFilenameValidatorAttrs(t *testing.T) {
+	v := &FilenameValidator{TrackerDeny: []string{"open.example"}}
+	filename := testMagnetURI + "&tr=udp://open.example:1337"
+	err := v.Validate("filename", filename)
+
+	// the rejected value is logged, and the cause is kept
+	var ha logger.HasLoggableAttrs
+	if !errors.As(err, &ha) || !errors.Is(err, magnet.ErrTrackerDenied) {
+		t.Fatalf("got error %v", err)
+	}
+	want := slog.Group(logger.GroupRPC, slog.String(logger.KeyValue, filename))
+	if attrs := ha.GetLoggableAttrs(); len(attrs) != 1 || !attrs[0].Equal(want) {
+		t.Errorf("got attributes %v", attrs)
+	}
+}
@@ -0,0 +1,55 @@
+package transmission
+
+import (
+	"context"
+
+	"transmission-proxy/internal/jrpc"
+)
+
+// ValidationHook implements custom policy on RPC requests. Returned error rejects the request;
+// errors implementing logger.HasLoggableAttrs keep their attributes in the rejection log record.
+// Authenticated user and client address are available via reqctx from the context.
+type ValidationHook func(ctx context.Context, req *jrpc.Request) error
+
+type hookError struct {
+	err error
+}
+
+func (h *hookError) Error() string {
+	return h.err.Error()
+}
+
+func (h *hookError) Unwrap() error {
+	return h.err
+}
+
+// RegisterPreValidateHook adds hook executed before built-in validation, receiving the original request.
+// Hooks run in registration order. Hooks may only be registered before the validator is first used,
+// registering later panics.
+func (p *MethodsValidator) RegisterPreValidateHook(h ValidationHook) {
+	p.mustNotBeSealed()
+	p.preHooks = append(p.preHooks, h)
+}
+
+// RegisterPostValidateHook adds hook executed after successful built-in validation, receiving the sanitized
+// request which would be forwarded upstream. Same registration restrictions as for pre-validate hooks apply.
+func (p *MethodsValidator) RegisterPostValidateHook(h ValidationHook) {
+	p.mustNotBeSealed()
+	p.postHooks = append(p.postHooks, h)
+}
+
+func (p *MethodsValidator) mustNotBeSealed() {
+	if p.sealed.Load() {
+		panic("transmission: validator must be configured before it is used")
+	}
+}
+
+func runHooks(hooks []ValidationHook, req *jrpc.Request) error {
+	for _, h := range hooks {
+		if err := h(req.Ctx(), req); err != nil {
+			return &hookError{err: err}
+		}
+	}
+
+	return nil
+}
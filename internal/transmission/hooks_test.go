@@ -0,0 +1,166 @@
+package transmission
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"transmission-proxy/internal/jrpc"
+	"transmission-proxy/internal/logger"
+	"transmission-proxy/internal/reqctx"
+)
+
+// quietHours is the example hook of an embedding application: no torrents are added between 02:00
+// and 04:00 local time, except by admin.
+func quietHours(now func() time.Time) ValidationHook {
+	return func(ctx context.Context, req *jrpc.Request) error {
+		if req.Method != "torrent-add" || reqctx.User(ctx) == "admin" {
+			return nil
+		}
+		if h := now().Local().Hour(); h < 2 || h >= 4 {
+			return nil
+		}
+
+		return logger.WithAttributes(fmt.Errorf("no torrents are added during quiet hours, %s from %s", reqctx.User(ctx), reqctx.ClientIP(ctx)),
+			slog.String("policy", "quiet_hours"))
+	}
+}
+
+func hookRequest(t *testing.T, user, body string) *jrpc.Request {
+	req := parseRequest(t, body)
+	ctx := reqctx.WithUser(context.Background(), user)
+	req.Context = reqctx.WithClientIP(ctx, netip.MustParseAddr("192.0.2.7"))
+
+	return req
+}
+
+func TestQuietHoursHook(t *testing.T) {
+	const add = `{"method":"torrent-add","arguments":{"filename":"magnet:?xt=urn:btih:x","download-dir":"/downloads/"}}`
+	at := func(hour int) func() time.Time {
+		return func() time.Time { return time.Date(2024, 5, 1, hour, 30, 0, 0, time.Local) }
+	}
+
+	cases := []struct {
+		name, user, body string
+		hour             int
+		rejected         bool
+	}{
+		{name: "day", user: "alice", body: add, hour: 14},
+		{name: "quiet hours", user: "alice", body: add, hour: 3, rejected: true},
+		{name: "end of quiet hours", user: "alice", body: add, hour: 4},
+		{name: "admin", user: "admin", body: add, hour: 3},
+		{name: "other method", user: "alice", body: `{"method":"torrent-get","arguments":{"fields":["id"]}}`, hour: 3},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			v := DefaultMethodsValidator("/downloads/")
+			v.RegisterPreValidateHook(quietHours(at(tc.hour)))
+
+			_, err := v.Validate(hookRequest(t, tc.user, tc.body))
+			if !tc.rejected {
+				if err != nil {
+					t.Errorf("got error %v", err)
+				}
+				return
+			}
+
+			if err == nil || !strings.Contains(err.Error(), "alice from 192.0.2.7") {
+				t.Fatalf("got error %v, want rejection naming user and address", err)
+			}
+			if RejectReason(err) != RejectPolicy {
+				t.Errorf("got reject reason %s", RejectReason(err))
+			}
+			// attributes of the hook error are kept for the rejection log record
+			var ha logger.HasLoggableAttrs
+			if !errors.As(err, &ha) || !strings.Contains(fmt.Sprint(ha.GetLoggableAttrs()), "policy=quiet_hours") {
+				t.Errorf("got error %#v, want hook attributes", err)
+			}
+		})
+	}
+}
+
+func TestHooksOrder(t *testing.T) {
+	var calls []string
+	hook := func(name string, err error) ValidationHook {
+		return func(ctx context.Context, req *jrpc.Request) error {
+			_, skipped := req.Arguments["bogus"]
+			calls = append(calls, fmt.Sprintf("%s(bogus=%v)", name, skipped))
+			return err
+		}
+	}
+
+	v := DefaultMethodsValidator("/downloads/")
+	v.RegisterPreValidateHook(hook("pre1", nil))
+	v.RegisterPreValidateHook(hook("pre2", nil))
+	v.RegisterPostValidateHook(hook("post1", nil))
+	v.RegisterPostValidateHook(hook("post2", nil))
+
+	body := `{"method":"torrent-get","arguments":{"fields":["id"],"bogus":1}}`
+	if _, err := v.Validate(hookRequest(t, "alice", body)); err != nil {
+		t.Fatal(err)
+	}
+	// pre-validate hooks see the original request, post-validate ones the sanitized
+	if got := strings.Join(calls, " "); got != "pre1(bogus=true) pre2(bogus=true) post1(bogus=false) post2(bogus=false)" {
+		t.Errorf("got calls %s", got)
+	}
+}
+
+func TestHooksRejectionStops(t *testing.T) {
+	var calls []string
+	hook := func(name string, err error) ValidationHook {
+		return func(context.Context, *jrpc.Request) error {
+			calls = append(calls, name)
+			return err
+		}
+	}
+
+	v := DefaultMethodsValidator("/downloads/")
+	v.RegisterPreValidateHook(hook("pre1", errors.New("denied")))
+	v.RegisterPreValidateHook(hook("pre2", nil))
+	v.RegisterPostValidateHook(hook("post", nil))
+
+	// the request is invalid, but the hook rejects it first
+	_, err := v.Validate(hookRequest(t, "alice", `{"method":"torrent-set-location","arguments":{"ids":1,"location":"/etc"}}`))
+	if err == nil || err.Error() != "denied" || RejectReason(err) != RejectPolicy {
+		t.Errorf("got error %v", err)
+	}
+	if len(calls) != 1 {
+		t.Errorf("got calls %v, want only the rejecting hook", calls)
+	}
+
+	// post-validate hooks are not run for invalid requests
+	calls = nil
+	v = DefaultMethodsValidator("/downloads/")
+	v.RegisterPostValidateHook(hook("post", nil))
+	if _, err := v.Validate(hookRequest(t, "alice", `{"method":"torrent-set-location","arguments":{"ids":1,"location":"/etc"}}`)); err == nil || len(calls) != 0 {
+		t.Errorf("got error %v, calls %v", err, calls)
+	}
+}
+
+func TestHooksRegisteredAfterUse(t *testing.T) {
+	v := DefaultMethodsValidator("/downloads/")
+	if _, err := v.Validate(hookRequest(t, "alice", `{"method":"session-get"}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, register := range map[string]func(){
+		"pre":  func() { v.RegisterPreValidateHook(func(context.Context, *jrpc.Request) error { return nil }) },
+		"post": func() { v.RegisterPostValidateHook(func(context.Context, *jrpc.Request) error { return nil }) },
+		"deny": func() { v.DenyMethods("torrent-add") },
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%s: registration after use did not panic", name)
+				}
+			}()
+			register()
+		}()
+	}
+}
@@ -0,0 +1,185 @@
+package transmission
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"strings"
+
+	"transmission-proxy/internal/bencode"
+	"transmission-proxy/internal/logger"
+)
+
+// Private flag requirements of MetainfoValidator.
+const (
+	PrivateAny    = ""
+	PrivateOnly   = "only"
+	PrivateForbid = "forbid"
+)
+
+// MetainfoValidator decodes torrent-add metainfo (base64 of .torrent file) and checks the torrent against
+// the limits. Zero limits are not enforced, but malformed metainfo is rejected regardless.
+type MetainfoValidator struct {
+	// MaxSize is the largest total size of the torrent files in bytes.
+	MaxSize  int64
+	MaxFiles int
+	// Private is one of PrivateAny, PrivateOnly or PrivateForbid.
+	Private string
+}
+
+// torrentInfo is what MetainfoValidator learns about the torrent.
+type torrentInfo struct {
+	name    string
+	size    int64
+	files   int
+	private bool
+}
+
+// metainfoError is rejection of a well-formed torrent, carrying its name and size for logs.
+type metainfoError struct {
+	info *torrentInfo
+	err  error
+}
+
+func (e *metainfoError) Error() string {
+	return e.err.Error()
+}
+
+func (e *metainfoError) GetLoggableAttrs() []slog.Attr {
+	return []slog.Attr{logger.RPC(slog.String(logger.KeyTorrentName, e.info.name), slog.Int64(logger.KeyTorrentSize, e.info.size))}
+}
+
+func (v *MetainfoValidator) Validate(key string, value any) error {
+	s, ok := value.(string)
+	if !ok {
+		return fmt.Errorf("must be base64 encoded torrent file, got %s", represent(value))
+	}
+
+	info, err := parseMetainfo(s)
+	if err != nil {
+		return fmt.Errorf("must be base64 encoded torrent file: %w", err)
+	}
+
+	switch {
+	case v.MaxSize > 0 && info.size > v.MaxSize:
+		err = fmt.Errorf("torrent size %d exceeds %d bytes", info.size, v.MaxSize)
+	case v.MaxFiles > 0 && info.files > v.MaxFiles:
+		err = fmt.Errorf("torrent has %d files, at most %d allowed", info.files, v.MaxFiles)
+	case v.Private == PrivateOnly && !info.private:
+		err = errors.New("only private torrents are allowed")
+	case v.Private == PrivateForbid && info.private:
+		err = errors.New("private torrents are not allowed")
+	default:
+		return nil
+	}
+
+	return &metainfoError{info: info, err: err}
+}
+
+func parseMetainfo(s string) (*torrentInfo, error) {
+	// clients may wrap long base64 lines
+	s = strings.Map(func(r rune) rune {
+		if r == '\n' || r == '\r' || r == ' ' || r == '\t' {
+			return -1
+		}
+		return r
+	}, s)
+
+	bs, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		if bs, err = base64.RawStdEncoding.DecodeString(s); err != nil {
+			return nil, errors.New("bad base64")
+		}
+	}
+
+	v, err := bencode.Decode(bs)
+	if err != nil {
+		return nil, fmt.Errorf("bad bencode: %w", err)
+	}
+
+	torrent, ok := v.(map[string]any)
+	if !ok {
+		return nil, errors.New("not a dictionary")
+	}
+	dict, ok := torrent["info"].(map[string]any)
+	if !ok {
+		return nil, errors.New("info dictionary is missing")
+	}
+
+	info := &torrentInfo{}
+	info.name, _ = dict["name"].(string)
+	if p, _ := dict["private"].(int64); p == 1 {
+		info.private = true
+	}
+
+	switch {
+	case dict["length"] != nil:
+		n, ok := dict["length"].(int64)
+		if !ok || n < 0 {
+			return nil, errors.New("bad length")
+		}
+		info.size, info.files = n, 1
+	case dict["files"] != nil:
+		files, ok := dict["files"].([]any)
+		if !ok {
+			return nil, errors.New("bad files list")
+		}
+		for _, f := range files {
+			fd, _ := f.(map[string]any)
+			if err := info.addFile(fd["length"]); err != nil {
+				return nil, err
+			}
+		}
+	case dict["file tree"] != nil:
+		// BitTorrent v2 only torrent
+		if err := walkFileTree(dict["file tree"], info); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, errors.New("torrent has no files")
+	}
+
+	return info, nil
+}
+
+// walkFileTree sums up files of BitTorrent v2 file tree, where files are dictionaries with empty key.
+func walkFileTree(node any, info *torrentInfo) error {
+	dir, ok := node.(map[string]any)
+	if !ok {
+		return errors.New("bad file tree")
+	}
+
+	for name, child := range dir {
+		if name != "" {
+			if err := walkFileTree(child, info); err != nil {
+				return err
+			}
+			continue
+		}
+
+		fd, _ := child.(map[string]any)
+		if err := info.addFile(fd["length"]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// addFile counts the file of the length in. Lengths adding up beyond int64 would wrap the size around,
+// letting huge torrents pass the size limit, so they are rejected.
+func (info *torrentInfo) addFile(length any) error {
+	n, ok := length.(int64)
+	if !ok || n < 0 {
+		return errors.New("bad file length")
+	}
+	if n > math.MaxInt64-info.size {
+		return errors.New("torrent size overflows")
+	}
+
+	info.size += n
+	info.files++
+	return nil
+}
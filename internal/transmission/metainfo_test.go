@@ -0,0 +1,235 @@
+package transmission
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"testing"
+
+	"transmission-proxy/internal/jrpc"
+	"transmission-proxy/internal/logger"
+)
+
+// encodeBencode encodes strings, integers, lists and dictionaries of them.
+func encodeBencode(v any) string {
+	switch v := v.(type) {
+	case string:
+		return fmt.Sprintf("%d:%s", len(v), v)
+	case int:
+		return fmt.Sprintf("i%de", v)
+	case int64:
+		return fmt.Sprintf("i%de", v)
+	case []any:
+		var b strings.Builder
+		for _, item := range v {
+			b.WriteString(encodeBencode(item))
+		}
+		return "l" + b.String() + "e"
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		var b strings.Builder
+		for _, k := range keys {
+			b.WriteString(encodeBencode(k) + encodeBencode(v[k]))
+		}
+		return "d" + b.String() + "e"
+	}
+
+	panic(fmt.Sprintf("cannot encode %T", v))
+}
+
+// torrentFile returns base64 of the torrent file with the info dictionary.
+func torrentFile(info map[string]any) string {
+	return base64.StdEncoding.EncodeToString([]byte(encodeBencode(map[string]any{"announce": "http://tracker/announce", "info": info})))
+}
+
+func TestParseMetainfo(t *testing.T) {
+	nested := map[string]any{"": map[string]any{"length": 5}}
+	for i := 0; i < 10; i++ {
+		nested = map[string]any{fmt.Sprint("dir", i): nested}
+	}
+
+	cases := []struct {
+		name     string
+		metainfo string
+		want     torrentInfo
+	}{
+		{name: "single file", metainfo: torrentFile(map[string]any{"name": "a.iso", "length": 1000, "piece length": 16384}),
+			want: torrentInfo{name: "a.iso", size: 1000, files: 1}},
+		{name: "multiple files", metainfo: torrentFile(map[string]any{"name": "show", "private": 1, "files": []any{
+			map[string]any{"length": 100, "path": []any{"e1.mkv"}},
+			map[string]any{"length": 200, "path": []any{"e2.mkv"}},
+		}}), want: torrentInfo{name: "show", size: 300, files: 2, private: true}},
+		{name: "v2 file tree", metainfo: torrentFile(map[string]any{"name": "v2", "file tree": map[string]any{
+			"a.txt": map[string]any{"": map[string]any{"length": 10}},
+			"dir":   map[string]any{"b.txt": map[string]any{"": map[string]any{"length": 20}}},
+		}}), want: torrentInfo{name: "v2", size: 30, files: 2}},
+		{name: "deep file tree", metainfo: torrentFile(map[string]any{"name": "deep", "file tree": nested}),
+			want: torrentInfo{name: "deep", size: 5, files: 1}},
+		{name: "not private", metainfo: torrentFile(map[string]any{"name": "p", "length": 1, "private": 0}),
+			want: torrentInfo{name: "p", size: 1, files: 1}},
+		{name: "largest size", metainfo: torrentFile(map[string]any{"name": "big", "files": []any{
+			map[string]any{"length": int64(1<<62 - 1)}, map[string]any{"length": int64(1 << 62)},
+		}}), want: torrentInfo{name: "big", size: 1<<63 - 1, files: 2}},
+		{name: "wrapped lines", metainfo: wrap(torrentFile(map[string]any{"name": "w", "length": 7})),
+			want: torrentInfo{name: "w", size: 7, files: 1}},
+		{name: "unpadded", metainfo: strings.TrimRight(torrentFile(map[string]any{"name": "u", "length": 7}), "="),
+			want: torrentInfo{name: "u", size: 7, files: 1}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			info, err := parseMetainfo(tc.metainfo)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *info != tc.want {
+				t.Errorf("got %+v, want %+v", *info, tc.want)
+			}
+		})
+	}
+}
+
+// wrap breaks the base64 into lines as some clients do.
+func wrap(s string) string {
+	var b strings.Builder
+	for len(s) > 16 {
+		b.WriteString(s[:16] + "\r\n")
+		s = s[16:]
+	}
+
+	return b.String() + s
+}
+
+func TestParseMetainfoMalformed(t *testing.T) {
+	valid := encodeBencode(map[string]any{"info": map[string]any{"name": "a", "length": 10}})
+	b64 := func(s string) string { return base64.StdEncoding.EncodeToString([]byte(s)) }
+	tooDeep := map[string]any{"": map[string]any{"length": 1}}
+	for i := 0; i < 70; i++ {
+		tooDeep = map[string]any{"d": tooDeep}
+	}
+
+	cases := []struct {
+		name, metainfo string
+		// err is the message expected in the error
+		err string
+	}{
+		{name: "not base64", metainfo: "not base64!", err: "bad base64"},
+		{name: "truncated base64", metainfo: b64(valid)[:5], err: "bad base64"},
+		{name: "empty", metainfo: "", err: "bad bencode"},
+		{name: "truncated bencode", metainfo: b64(valid[:len(valid)-3]), err: "bad bencode"},
+		{name: "trailing data", metainfo: b64(valid + "i1e"), err: "bad bencode"},
+		{name: "string beyond input", metainfo: b64("d4:infod4:name99:ae"), err: "bad bencode"},
+		{name: "leading zeros", metainfo: b64("d4:infod6:lengthi010e4:name1:aee"), err: "bad bencode"},
+		{name: "integer overflow", metainfo: b64("d4:infod6:lengthi99999999999999999999e4:name1:aee"), err: "bad bencode"},
+		{name: "nested too deep", metainfo: torrentFile(map[string]any{"name": "a", "file tree": tooDeep}), err: "nested too deep"},
+		{name: "not dictionary", metainfo: b64("l4:infoe"), err: "not a dictionary"},
+		{name: "no info", metainfo: b64("d8:announce1:xe"), err: "info dictionary is missing"},
+		{name: "info not dictionary", metainfo: b64("d4:info4:infoe"), err: "info dictionary is missing"},
+		{name: "no files", metainfo: torrentFile(map[string]any{"name": "a"}), err: "torrent has no files"},
+		{name: "negative length", metainfo: torrentFile(map[string]any{"name": "a", "length": -1}), err: "bad length"},
+		{name: "length not integer", metainfo: torrentFile(map[string]any{"name": "a", "length": "10"}), err: "bad length"},
+		{name: "files not list", metainfo: torrentFile(map[string]any{"name": "a", "files": "x"}), err: "bad files list"},
+		{name: "file not dictionary", metainfo: torrentFile(map[string]any{"name": "a", "files": []any{"x"}}), err: "bad file length"},
+		{name: "negative file length", metainfo: torrentFile(map[string]any{"name": "a", "files": []any{map[string]any{"length": -5}}}),
+			err: "bad file length"},
+		{name: "size overflow", metainfo: torrentFile(map[string]any{"name": "a", "files": []any{
+			map[string]any{"length": int64(1 << 62)}, map[string]any{"length": int64(1 << 62)},
+		}}), err: "torrent size overflows"},
+		{name: "file tree size overflow", metainfo: torrentFile(map[string]any{"name": "a", "file tree": map[string]any{
+			"a": map[string]any{"": map[string]any{"length": int64(1 << 62)}},
+			"b": map[string]any{"": map[string]any{"length": int64(1 << 62)}},
+		}}), err: "torrent size overflows"},
+		{name: "bad file tree", metainfo: torrentFile(map[string]any{"name": "a", "file tree": map[string]any{"a": "x"}}), err: "bad file tree"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if info, err := parseMetainfo(tc.metainfo); err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("got %+v, error %v, want %q", info, err, tc.err)
+			}
+		})
+	}
+}
+
+func TestMetainfoValidator(t *testing.T) {
+	single := torrentFile(map[string]any{"name": "a.iso", "length": 1000})
+	private := torrentFile(map[string]any{"name": "show", "private": 1, "files": []any{
+		map[string]any{"length": 100}, map[string]any{"length": 200}, map[string]any{"length": 300},
+	}})
+
+	cases := []struct {
+		name     string
+		v        MetainfoValidator
+		metainfo any
+		// err is the message expected in the error, empty if valid
+		err string
+	}{
+		{name: "no limits", metainfo: private},
+		{name: "size at limit", v: MetainfoValidator{MaxSize: 1000}, metainfo: single},
+		{name: "size over limit", v: MetainfoValidator{MaxSize: 999}, metainfo: single, err: "torrent size 1000 exceeds 999 bytes"},
+		{name: "files at limit", v: MetainfoValidator{MaxFiles: 3}, metainfo: private},
+		{name: "files over limit", v: MetainfoValidator{MaxFiles: 2}, metainfo: private, err: "torrent has 3 files, at most 2 allowed"},
+		{name: "private only", v: MetainfoValidator{Private: PrivateOnly}, metainfo: private},
+		{name: "private only public", v: MetainfoValidator{Private: PrivateOnly}, metainfo: single, err: "only private torrents are allowed"},
+		{name: "private forbidden", v: MetainfoValidator{Private: PrivateForbid}, metainfo: private, err: "private torrents are not allowed"},
+		{name: "private forbidden public", v: MetainfoValidator{Private: PrivateForbid}, metainfo: single},
+		// malformed torrents are rejected even without limits
+		{name: "malformed", metainfo: "AAAA", err: "must be base64 encoded torrent file: bad bencode"},
+		{name: "not string", metainfo: 12, err: "must be base64 encoded torrent file, got"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.v.Validate("metainfo", tc.metainfo)
+			if tc.err == "" {
+				if err != nil {
+					t.Errorf("got error %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("got error %v, want %q", err, tc.err)
+			}
+		})
+	}
+}
+
+func TestMetainfoValidatorAttrs(t *testing.T) {
+	v := &MetainfoValidator{MaxSize: 10}
+	err := v.Validate("metainfo", torrentFile(map[string]any{"name": "a.iso", "length": 1000}))
+
+	// the rejected torrent is named in logs for audit
+	var ha logger.HasLoggableAttrs
+	if !errors.As(err, &ha) {
+		t.Fatalf("got error %v without attributes", err)
+	}
+	want := slog.Group(logger.GroupRPC, slog.String(logger.KeyTorrentName, "a.iso"), slog.Int64(logger.KeyTorrentSize, 1000))
+	if attrs := ha.GetLoggableAttrs(); len(attrs) != 1 || !attrs[0].Equal(want) {
+		t.Errorf("got attributes %v", attrs)
+	}
+}
+
+func TestMetainfoConfig(t *testing.T) {
+	v := DefaultMethodsValidator("/downloads/")
+	if err := (&ValidatorConfig{Metainfo: &MetainfoConfig{MaxFiles: 1}}).Apply(v); err != nil {
+		t.Fatal(err)
+	}
+
+	req := &jrpc.Request{Method: "torrent-add", Arguments: map[string]any{"metainfo": base64.StdEncoding.EncodeToString([]byte("d4:infoe"))}}
+	_, err := v.Validate(req)
+	var ba IsBadArgument
+	if !errors.As(err, &ba) || ba.GetBadArgument() != "metainfo" || !strings.HasPrefix(err.Error(), "bad argument: ") {
+		t.Errorf("got error %v, want bad argument metainfo", err)
+	}
+
+	for _, cfg := range []*MetainfoConfig{{MaxSize: -1}, {MaxFiles: -1}, {Private: "yes"}} {
+		if err := (&ValidatorConfig{Metainfo: cfg}).Apply(DefaultMethodsValidator("/downloads/")); err == nil {
+			t.Errorf("%+v: got no error", *cfg)
+		}
+	}
+}
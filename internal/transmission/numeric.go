@@ -0,0 +1,215 @@
+package transmission
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+)
+
+// StrictNumericTypes makes integer arguments reject values Transmission would silently truncate or
+// reinterpret: fractional numbers, numbers which do not fit int64 or may have lost precision while
+// being decoded as float64, and numbers sent as strings. Validators may also opt in individually.
+var StrictNumericTypes bool
+
+// maxExactInteger is the largest integer every smaller one of which float64 represents exactly,
+// so larger values may be the result of rounding the number the client sent.
+const maxExactInteger = 1<<53 - 1
+
+// IntValidator accepts integer arguments. Unless strict, any number or numeric string is accepted,
+// leaving its interpretation to Transmission.
+type IntValidator struct {
+	Strict bool
+}
+
+func (v *IntValidator) Validate(key string, value any) error {
+	return checkInteger(value, v.Strict || StrictNumericTypes)
+}
+
+// NumberValidator accepts numeric arguments. Unless strict, numeric strings are accepted too,
+// leaving their interpretation to Transmission.
+type NumberValidator struct {
+	Strict bool
+}
+
+func (v *NumberValidator) Validate(key string, value any) error {
+	_, err := number(value, v.Strict || StrictNumericTypes)
+	return err
+}
+
+// NumberRangeValidator accepts numbers within [Min, Max]. Use math.Inf for unbounded side.
+type NumberRangeValidator struct {
+	Min, Max float64
+}
+
+// AtLeast returns validator of numbers not less than min.
+func AtLeast(min float64) *NumberRangeValidator {
+	return &NumberRangeValidator{Min: min, Max: math.Inf(1)}
+}
+
+func (v *NumberRangeValidator) Validate(key string, value any) error {
+	f, err := number(value, StrictNumericTypes)
+	if err != nil {
+		return err
+	}
+
+	if f < v.Min || f > v.Max {
+		switch {
+		case math.IsInf(v.Max, 1):
+			return fmt.Errorf("must be at least %s, got %s", represent(v.Min), represent(value))
+		case math.IsInf(v.Min, -1):
+			return fmt.Errorf("must be at most %s, got %s", represent(v.Max), represent(value))
+		default:
+			return fmt.Errorf("must be between %s and %s, got %s", represent(v.Min), represent(v.Max), represent(value))
+		}
+	}
+
+	return nil
+}
+
+// IdsValidator accepts torrent ids: a single id, an array of ids or "recently-active". Ids are
+// integers or torrent hashes (SHA-1 or, for BitTorrent v2 torrents, SHA-256 in hex).
+// With Single only one torrent may be given: a single id or an array of one.
+type IdsValidator struct {
+	Strict bool
+	Single bool
+}
+
+func (v *IdsValidator) Validate(key string, value any) error {
+	strict := v.Strict || StrictNumericTypes
+
+	if s, ok := value.(string); ok && s == "recently-active" {
+		if v.Single {
+			return errors.New("must be single id, got \"recently-active\"")
+		}
+		return nil
+	}
+
+	ids, ok := value.([]any)
+	if !ok {
+		ids = []any{value}
+	}
+	if v.Single && len(ids) != 1 {
+		return fmt.Errorf("must be single id or array of one id, got %d ids", len(ids))
+	}
+
+	for _, id := range ids {
+		if s, ok := id.(string); ok {
+			if IsTorrentHash(s) {
+				continue
+			}
+			if _, err := json.Number(s).Float64(); err != nil {
+				return fmt.Errorf("must be id, array of ids or \"recently-active\": %s is neither integer nor torrent hash of 40 or 64 hex digits", represent(s))
+			}
+		}
+
+		if err := checkInteger(id, strict); err != nil {
+			return fmt.Errorf("must be id, array of ids or \"recently-active\": %w", err)
+		}
+	}
+
+	return nil
+}
+
+var torrentHash = regexp.MustCompile(`^(?:[0-9a-fA-F]{40}|[0-9a-fA-F]{64})$`)
+
+// IsTorrentHash reports whether s is info hash of a torrent as Transmission accepts in ids.
+func IsTorrentHash(s string) bool {
+	return torrentHash.MatchString(s)
+}
+
+// number returns the value of numeric argument, which may be sent as string unless strict.
+func number(value any, strict bool) (float64, error) {
+	switch n := value.(type) {
+	case float64:
+		return n, nil
+	case json.Number:
+		return n.Float64()
+	case int:
+		return float64(n), nil
+	case int64:
+		return float64(n), nil
+	case string:
+		if f, err := json.Number(n).Float64(); err == nil && !strict {
+			return f, nil
+		}
+	}
+
+	return 0, fmt.Errorf("must be number, got %s", represent(value))
+}
+
+func checkInteger(value any, strict bool) error {
+	var f float64
+	switch n := value.(type) {
+	case float64:
+		f = n
+	case float32:
+		f = float64(n)
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		return nil
+	case json.Number:
+		if _, err := n.Int64(); err == nil || !strict {
+			return nil
+		}
+		// other numbers, e.g. 1.5 or 1e3, are checked as float64 below
+		var err error
+		if f, err = n.Float64(); err != nil || f == math.Trunc(f) && (f >= math.MaxInt64 || f < math.MinInt64) {
+			return fmt.Errorf("must be integer within int64 range, got %s", n)
+		}
+	case string:
+		if strict {
+			return fmt.Errorf("must be integer, got string %s", represent(n))
+		}
+		if _, err := json.Number(n).Float64(); err != nil {
+			return fmt.Errorf("must be integer, got %s", represent(n))
+		}
+		return nil
+	default:
+		return fmt.Errorf("must be integer, got %s", represent(value))
+	}
+
+	if !strict {
+		return nil
+	}
+
+	switch {
+	case math.IsNaN(f) || math.IsInf(f, 0) || f != math.Trunc(f):
+		return fmt.Errorf("must be integer, got fractional number %s", represent(f))
+	case f >= math.MaxInt64 || f < math.MinInt64:
+		return fmt.Errorf("must be integer within int64 range, got %s", represent(f))
+	case math.Abs(f) > maxExactInteger:
+		return fmt.Errorf("must be integer within ±%d, got %s which may have lost precision",
+			maxExactInteger, represent(f))
+	}
+
+	return nil
+}
+
+// integerValue returns the value of the argument accepted by checkInteger as int64. Fractional numbers,
+// accepted unless strict, are truncated.
+func integerValue(value any) int64 {
+	switch n := value.(type) {
+	case json.Number:
+		if i, err := n.Int64(); err == nil {
+			return i
+		}
+	case int64:
+		return n
+	case int:
+		return int64(n)
+	}
+
+	f, _ := number(value, false)
+	return int64(f)
+}
+
+// represent renders the value as it appeared in JSON for error messages.
+func represent(value any) string {
+	bs, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprintf("%v", value)
+	}
+
+	return string(bs)
+}
@@ -0,0 +1,284 @@
+package transmission
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"strings"
+	"testing"
+
+	"transmission-proxy/internal/jrpc"
+)
+
+// setStrictNumericTypes sets StrictNumericTypes for the duration of the test.
+func setStrictNumericTypes(t *testing.T, strict bool) {
+	prev := StrictNumericTypes
+	StrictNumericTypes = strict
+	t.Cleanup(func() { StrictNumericTypes = prev })
+}
+
+// decodeNumber decodes the JSON value the way RPC request arguments are decoded.
+func decodeNumber(t *testing.T, value string) any {
+	var req jrpc.Request
+	if err := json.Unmarshal([]byte(`{"method":"x","arguments":{"v":`+value+`}}`), &req); err != nil {
+		t.Fatalf("decode %s: %v", value, err)
+	}
+
+	return req.Arguments["v"]
+}
+
+var integerCases = []struct {
+	value string
+	// strict and lax tell whether the value is accepted as integer in strict and default mode
+	strict, lax bool
+	// errText is expected in the error message in strict mode
+	errText string
+}{
+	{value: `50`, strict: true, lax: true},
+	{value: `1e3`, strict: true, lax: true},
+	{value: `1.0`, strict: true, lax: true},
+	{value: `-0`, strict: true, lax: true},
+	{value: `-0.0`, strict: true, lax: true},
+	// kept exact as json.Number, so there is no precision to lose
+	{value: `9007199254740993`, strict: true, lax: true},
+	{value: `9223372036854775807`, strict: true, lax: true},
+	// 2^53+1 written as float cannot be represented exactly
+	{value: `9.007199254740993e15`, strict: false, lax: true, errText: "may have lost precision"},
+	{value: `1e19`, strict: false, lax: true, errText: "must be integer within int64 range, got 1e19"},
+	{value: `50.7`, strict: false, lax: true, errText: "must be integer, got fractional number 50.7"},
+	{value: `"50"`, strict: false, lax: true, errText: `must be integer, got string "50"`},
+	{value: `"fifty"`, strict: false, lax: false, errText: `must be integer, got string "fifty"`},
+	{value: `true`, strict: false, lax: false, errText: "must be integer, got true"},
+	{value: `[1]`, strict: false, lax: false, errText: "must be integer, got [1]"},
+}
+
+func TestIntValidator(t *testing.T) {
+	for _, tc := range integerCases {
+		value := decodeNumber(t, tc.value)
+
+		err := (&IntValidator{Strict: true}).Validate("v", value)
+		if (err == nil) != tc.strict {
+			t.Errorf("strict %s: got error %v, want accepted %v", tc.value, err, tc.strict)
+		}
+		if err != nil && !strings.Contains(err.Error(), tc.errText) {
+			t.Errorf("strict %s: got error %q, want it to contain %q", tc.value, err, tc.errText)
+		}
+
+		err = (&IntValidator{}).Validate("v", value)
+		if (err == nil) != tc.lax {
+			t.Errorf("lax %s: got error %v, want accepted %v", tc.value, err, tc.lax)
+		}
+	}
+}
+
+func TestIntValidatorFloat64(t *testing.T) {
+	// values decoded without json.Number, e.g. by internal callers
+	cases := []struct {
+		value float64
+		ok    bool
+	}{
+		{1000, true},
+		{-0.0, true},
+		{1<<53 - 1, true},
+		{1<<53 + 2, false},
+		{50.7, false},
+	}
+
+	for _, tc := range cases {
+		err := (&IntValidator{Strict: true}).Validate("v", tc.value)
+		if (err == nil) != tc.ok {
+			t.Errorf("%v: got error %v, want accepted %v", tc.value, err, tc.ok)
+		}
+	}
+}
+
+func TestIdsValidatorStrict(t *testing.T) {
+	cases := []struct {
+		value  string
+		strict bool
+		lax    bool
+	}{
+		{value: `1e3`, strict: true, lax: true},
+		{value: `[1, 2e0, "0123456789abcdef0123456789abcdef01234567"]`, strict: true, lax: true},
+		{value: `"recently-active"`, strict: true, lax: true},
+		{value: `[1.5]`, strict: false, lax: true},
+		{value: `["7"]`, strict: false, lax: true},
+		{value: `[true]`, strict: false, lax: false},
+		{value: `["abc"]`, strict: false, lax: false},
+	}
+
+	for _, tc := range cases {
+		value := decodeNumber(t, tc.value)
+		if err := (&IdsValidator{Strict: true}).Validate("ids", value); (err == nil) != tc.strict {
+			t.Errorf("strict %s: got error %v, want accepted %v", tc.value, err, tc.strict)
+		}
+		if err := (&IdsValidator{}).Validate("ids", value); (err == nil) != tc.lax {
+			t.Errorf("lax %s: got error %v, want accepted %v", tc.value, err, tc.lax)
+		}
+	}
+}
+
+// TestTypedIntegerArguments checks that integer fields of typed arguments accept the same values as IntValidator.
+func TestTypedIntegerArguments(t *testing.T) {
+	requests := []struct {
+		method, field string
+	}{
+		{"torrent-set", "peer-limit"},
+		{"torrent-add", "peer-limit"},
+		{"session-set", "peer-limit-global"},
+	}
+
+	for _, strict := range []bool{true, false} {
+		setStrictNumericTypes(t, strict)
+		v := DefaultMethodsValidator("/downloads/")
+
+		for _, r := range requests {
+			for _, tc := range integerCases {
+				// range checks of the fields are not of interest here
+				if strings.HasPrefix(tc.value, "-") {
+					continue
+				}
+
+				body := `{"method":"` + r.method + `","arguments":{"filename":"magnet:?x","` + r.field + `":` + tc.value + `}}`
+				var req jrpc.Request
+				if err := json.Unmarshal([]byte(body), &req); err != nil {
+					t.Fatal(err)
+				}
+
+				_, err := v.Validate(&req)
+				want := tc.lax
+				if strict {
+					want = tc.strict
+				}
+				if (err == nil) != want {
+					t.Errorf("strict=%v %s %s=%s: got error %v, want accepted %v", strict, r.method, r.field, tc.value, err, want)
+				}
+				if err != nil && strict && !strings.Contains(err.Error(), tc.errText) {
+					t.Errorf("strict=%v %s %s=%s: got error %q, want it to contain %q", strict, r.method, r.field, tc.value, err, tc.errText)
+				}
+			}
+		}
+	}
+}
+
+func TestNumberRangeValidator(t *testing.T) {
+	cases := []struct {
+		v     *NumberRangeValidator
+		value any
+		// errText is expected in the error message, empty if the value is accepted
+		errText string
+	}{
+		{v: AtLeast(0), value: float64(0)},
+		{v: AtLeast(0), value: 100},
+		{v: AtLeast(0), value: int64(1) << 40},
+		{v: AtLeast(0), value: json.Number("1.5")},
+		{v: AtLeast(0), value: "250"},
+		{v: AtLeast(0), value: float64(-1), errText: "must be at least 0, got -1"},
+		{v: AtLeast(0), value: -5, errText: "must be at least 0, got -5"},
+		{v: AtLeast(0), value: "banana", errText: `must be number, got "banana"`},
+		{v: AtLeast(0), value: true, errText: "must be number, got true"},
+		{v: &NumberRangeValidator{Min: -1, Max: 1}, value: -1},
+		{v: &NumberRangeValidator{Min: -1, Max: 1}, value: float64(2), errText: "must be between -1 and 1, got 2"},
+		{v: &NumberRangeValidator{Min: math.Inf(-1), Max: 10}, value: json.Number("11"), errText: "must be at most 10, got 11"},
+	}
+
+	for _, tc := range cases {
+		err := tc.v.Validate("v", tc.value)
+		if (err == nil) != (tc.errText == "") || err != nil && !strings.Contains(err.Error(), tc.errText) {
+			t.Errorf("%+v %#v: got error %v, want %q", *tc.v, tc.value, err, tc.errText)
+		}
+	}
+
+	// numeric strings are left to Transmission unless strict
+	setStrictNumericTypes(t, true)
+	if err := AtLeast(0).Validate("v", "250"); err == nil {
+		t.Error("strict: numeric string accepted")
+	}
+}
+
+func TestNumberValidator(t *testing.T) {
+	for _, value := range []any{float64(1.5), 3, int64(-2), json.Number("1e3"), "7"} {
+		if err := (&NumberValidator{}).Validate("v", value); err != nil {
+			t.Errorf("%#v: got error %v", value, err)
+		}
+	}
+	for _, value := range []any{"seven", true, nil, []any{1}, map[string]any{}} {
+		if err := (&NumberValidator{}).Validate("v", value); err == nil {
+			t.Errorf("%#v: accepted", value)
+		}
+	}
+	if err := (&NumberValidator{Strict: true}).Validate("v", "7"); err == nil {
+		t.Error("strict: numeric string accepted")
+	}
+}
+
+func TestIdsValidator(t *testing.T) {
+	const (
+		v1 = "0123456789abcdef0123456789abcdef01234567"
+		v2 = "0123456789abcdef0123456789abcdef0123456789abcdef0123456789ABCDEF"
+	)
+
+	cases := []struct {
+		value string
+		// errText is expected in the error message, empty if the value is accepted
+		errText string
+	}{
+		{value: `7`},
+		{value: `"` + v1 + `"`},
+		{value: `"` + v2 + `"`},
+		{value: `"recently-active"`},
+		{value: `[]`},
+		{value: `[1, "` + v1 + `", 3, "` + v2 + `"]`},
+		{value: `"` + v1[:39] + `"`, errText: `"` + v1[:39] + `" is neither integer nor torrent hash of 40 or 64 hex digits`},
+		{value: `[1, "` + v1 + `0"]`, errText: "is neither integer nor torrent hash"},
+		{value: `["` + strings.Repeat("g", 40) + `"]`, errText: "is neither integer nor torrent hash"},
+		{value: `[2, "recently-active"]`, errText: `"recently-active" is neither integer nor torrent hash`},
+		{value: `[1.5]`, errText: "must be integer, got fractional number 1.5"},
+		{value: `[1, [2]]`, errText: "must be integer, got [2]"},
+		{value: `{"id":1}`, errText: `must be id, array of ids or "recently-active"`},
+		{value: `[{"id":1}]`, errText: `must be id, array of ids or "recently-active"`},
+		{value: `null`, errText: `must be id, array of ids or "recently-active"`},
+		{value: `true`, errText: `must be id, array of ids or "recently-active"`},
+	}
+
+	for _, tc := range cases {
+		err := (&IdsValidator{Strict: true}).Validate("ids", decodeNumber(t, tc.value))
+		if (err == nil) != (tc.errText == "") || err != nil && !strings.Contains(err.Error(), tc.errText) {
+			t.Errorf("%s: got error %v, want %q", tc.value, err, tc.errText)
+		}
+	}
+
+	single := &IdsValidator{Single: true}
+	for value, ok := range map[string]bool{`1`: true, `["` + v2 + `"]`: true, `[1, 2]`: false, `[]`: false, `"recently-active"`: false} {
+		if err := single.Validate("ids", decodeNumber(t, value)); (err == nil) != ok {
+			t.Errorf("single %s: got error %v, want accepted %v", value, err, ok)
+		}
+	}
+}
+
+// TestIdsValidatorMethods checks that ids are validated by every method taking them.
+func TestIdsValidatorMethods(t *testing.T) {
+	v := DefaultMethodsValidator("/downloads/")
+
+	var methods int
+	for method, args := range specMethods {
+		if args["ids"] != ArgIds {
+			continue
+		}
+		methods++
+
+		body := `{"method":"` + method + `","arguments":{"ids":[1,{"nested":[2]}]}}`
+		_, err := v.Validate(parseRequest(t, body))
+		var errs *ArgumentErrors
+		if errors.As(err, &errs) {
+			err = errs.Errors[0]
+		}
+		var ba IsBadArgument
+		if !errors.As(err, &ba) || ba.GetBadArgument() != "ids" {
+			t.Errorf("%s: got error %v, want bad ids", method, err)
+		}
+	}
+	if methods < 10 {
+		t.Errorf("got %d methods taking ids", methods)
+	}
+}
@@ -0,0 +1,62 @@
+package transmission
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"transmission-proxy/internal/jrpc"
+)
+
+// Client makes RPC calls to Transmission, negotiating the session id as needed (see upstream.Client).
+// Authorization is taken from header unless the client has credentials of its own.
+type Client interface {
+	Call(ctx context.Context, header http.Header, req *jrpc.Request) (*jrpc.Response, error)
+}
+
+// RemoveDataCheck rejects torrent-remove requests deleting local data of torrents downloaded outside Prefix,
+// so that data of torrents the user cannot otherwise touch is safe. The download directories of the torrents
+// are looked up upstream, the request is rejected if the lookup fails.
+type RemoveDataCheck struct {
+	Prefix  string
+	Client  Client
+	Timeout time.Duration
+}
+
+// Hook returns the check as a post-validate hook.
+func (c *RemoveDataCheck) Hook(ctx context.Context, req *jrpc.Request) error {
+	if req.Method != "torrent-remove" {
+		return nil
+	}
+	if deleteData, _ := req.Arguments["delete-local-data"].(bool); !deleteData {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.Timeout)
+	defer cancel()
+
+	args := map[string]any{"fields": []string{"id", "downloadDir"}}
+	// without ids Transmission removes all the torrents
+	if ids, ok := req.Arguments["ids"]; ok {
+		args["ids"] = ids
+	}
+
+	resp, err := c.Client.Call(ctx, req.Header, &jrpc.Request{Method: "torrent-get", Arguments: args})
+	if err != nil {
+		return fmt.Errorf("look up torrents to remove: %w", err)
+	}
+	torrents, err := ParseTorrents(resp.Arguments["torrents"])
+	if err != nil {
+		return fmt.Errorf("look up torrents to remove: %w", err)
+	}
+
+	for i := 0; i < torrents.Len(); i++ {
+		if !InPrefix(torrents.String(i, "downloadDir"), c.Prefix) {
+			id, _ := torrents.Get(i, "id")
+			return fmt.Errorf("torrent %v is downloaded to %w, its data cannot be deleted", id, ErrTorrentForbiddenLocation)
+		}
+	}
+
+	return nil
+}
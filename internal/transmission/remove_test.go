@@ -0,0 +1,69 @@
+package transmission
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"transmission-proxy/internal/jrpc"
+)
+
+// fakeClient answers torrent-get with the download directories of the torrents by id.
+type fakeClient struct {
+	dirs  map[int]string
+	err   error
+	calls []*jrpc.Request
+}
+
+func (c *fakeClient) Call(ctx context.Context, header http.Header, req *jrpc.Request) (*jrpc.Response, error) {
+	c.calls = append(c.calls, req)
+	if c.err != nil {
+		return nil, c.err
+	}
+
+	ids, _ := req.Arguments["ids"].([]any)
+	var torrents []any
+	for id, dir := range c.dirs {
+		for _, want := range ids {
+			if want == id {
+				torrents = append(torrents, map[string]any{"id": id, "downloadDir": dir})
+			}
+		}
+	}
+
+	return &jrpc.Response{Result: jrpc.ResultSuccess, Arguments: map[string]any{"torrents": torrents}}, nil
+}
+
+func TestRemoveDataCheck(t *testing.T) {
+	c := &fakeClient{dirs: map[int]string{1: "/downloads/a", 2: "/downloads/b", 3: "/srv/c"}}
+	check := &RemoveDataCheck{Prefix: "/downloads/", Client: c, Timeout: time.Second}
+
+	remove := func(ids []any, deleteData bool) error {
+		return check.Hook(context.Background(), &jrpc.Request{
+			Method:    "torrent-remove",
+			Arguments: map[string]any{"ids": ids, "delete-local-data": deleteData},
+		})
+	}
+
+	if err := remove([]any{1, 2}, true); err != nil {
+		t.Errorf("torrents under the prefix: got error %v", err)
+	}
+	if err := remove([]any{1, 3}, true); !errors.Is(err, ErrTorrentForbiddenLocation) {
+		t.Errorf("torrent outside the prefix: got error %v, want %v", err, ErrTorrentForbiddenLocation)
+	}
+
+	calls := len(c.calls)
+	if err := remove([]any{3}, false); err != nil {
+		t.Errorf("keeping data: got error %v", err)
+	}
+	if len(c.calls) != calls {
+		t.Errorf("torrents were looked up for removal keeping the data")
+	}
+
+	c.err = errors.New("connection refused")
+	if err := remove([]any{1}, true); err == nil {
+		t.Errorf("failed lookup: request was allowed")
+	}
+}
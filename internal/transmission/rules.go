@@ -0,0 +1,149 @@
+package transmission
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+
+	"transmission-proxy/internal/logger"
+)
+
+// DependencyRule checks relations between several arguments of the method.
+// Rules are evaluated after every argument passed its own validation.
+type DependencyRule interface {
+	Check(args map[string]any) error
+	String() string
+}
+
+type ruleViolation struct {
+	rule   DependencyRule
+	field  string
+	reason string
+}
+
+func (r *ruleViolation) Error() string {
+	return fmt.Sprintf("rule %s violated: %s", r.rule, r.reason)
+}
+
+func (r *ruleViolation) GetBadArgument() string {
+	return r.field
+}
+
+func (r *ruleViolation) GetLoggableAttrs() []slog.Attr {
+	return []slog.Attr{logger.RPC(slog.String(logger.KeyField, r.field), slog.String(logger.KeyRule, r.rule.String()))}
+}
+
+type requires struct {
+	field, required string
+}
+
+// Requires demands argument required to be present whenever argument field is.
+func Requires(field, required string) DependencyRule {
+	return &requires{field: field, required: required}
+}
+
+func (r *requires) Check(args map[string]any) error {
+	if _, ok := args[r.field]; !ok {
+		return nil
+	}
+
+	if _, ok := args[r.required]; !ok {
+		return &ruleViolation{rule: r, field: r.required, reason: r.required + " must be present when " + r.field + " is"}
+	}
+
+	return nil
+}
+
+func (r *requires) String() string {
+	return fmt.Sprintf("requires(%s, %s)", r.field, r.required)
+}
+
+type mutuallyExclusive struct {
+	a, b string
+}
+
+// MutuallyExclusive forbids arguments a and b to be present together.
+func MutuallyExclusive(a, b string) DependencyRule {
+	return &mutuallyExclusive{a: a, b: b}
+}
+
+func (m *mutuallyExclusive) Check(args map[string]any) error {
+	_, okA := args[m.a]
+	_, okB := args[m.b]
+	if okA && okB {
+		return &ruleViolation{rule: m, field: m.b, reason: m.a + " and " + m.b + " cannot be present together"}
+	}
+
+	return nil
+}
+
+func (m *mutuallyExclusive) String() string {
+	return fmt.Sprintf("mutuallyExclusive(%s, %s)", m.a, m.b)
+}
+
+type requiredWhen struct {
+	field    string
+	value    any
+	required string
+}
+
+// RequiredWhen demands argument required to be present when argument field equals value.
+func RequiredWhen(field string, value any, required string) DependencyRule {
+	return &requiredWhen{field: field, value: value, required: required}
+}
+
+func (r *requiredWhen) Check(args map[string]any) error {
+	val, ok := args[r.field]
+	if !ok || !sameValue(val, r.value) {
+		return nil
+	}
+
+	if _, ok := args[r.required]; !ok {
+		return &ruleViolation{rule: r, field: r.required,
+			reason: fmt.Sprintf("%s must be present when %s is %v", r.required, r.field, r.value)}
+	}
+
+	return nil
+}
+
+func (r *requiredWhen) String() string {
+	return fmt.Sprintf("requiredWhen(%s, %v, %s)", r.field, r.value, r.required)
+}
+
+// sameValue compares values by their JSON representation, so that numbers decoded
+// from different sources (float64 from request, int from config) compare equal.
+func sameValue(a, b any) bool {
+	ba, errA := json.Marshal(a)
+	bb, errB := json.Marshal(b)
+	return errA == nil && errB == nil && bytes.Equal(ba, bb)
+}
+
+// HasFields is implemented by argument validators whose field validators may be replaced.
+type HasFields interface {
+	WithField(key string, fv ArgumentValidator) ArgumentsValidator
+}
+
+// HasDefaults is implemented by argument validators which may add missing arguments.
+type HasDefaults interface {
+	WithDefault(key string, value any) ArgumentsValidator
+}
+
+// HasRules is implemented by argument validators which support dependency rules.
+type HasRules interface {
+	WithRules(rules ...DependencyRule) ArgumentsValidator
+}
+
+func checkRules(rules []DependencyRule, args map[string]any, errs *argumentErrors) {
+	if errs.done() {
+		return
+	}
+
+	for _, rule := range rules {
+		if err := rule.Check(args); err != nil {
+			if errs.add(err) {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package transmission
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDependencyRules(t *testing.T) {
+	cases := []struct {
+		name string
+		rule DependencyRule
+		args string
+		// field is the argument the violation names, empty if the rule holds
+		field string
+	}{
+		{name: "requires absent", rule: Requires("move", "location"), args: `{}`},
+		{name: "requires present", rule: Requires("move", "location"), args: `{"move":true,"location":"/x"}`},
+		{name: "requires missing", rule: Requires("move", "location"), args: `{"move":true}`, field: "location"},
+		{name: "requires other only", rule: Requires("move", "location"), args: `{"location":"/x"}`},
+
+		{name: "exclusive none", rule: MutuallyExclusive("a", "b"), args: `{}`},
+		{name: "exclusive one", rule: MutuallyExclusive("a", "b"), args: `{"a":1}`},
+		{name: "exclusive both", rule: MutuallyExclusive("a", "b"), args: `{"a":1,"b":null}`, field: "b"},
+
+		{name: "required when other value", rule: RequiredWhen("enabled", true, "begin"), args: `{"enabled":false}`},
+		{name: "required when absent", rule: RequiredWhen("enabled", true, "begin"), args: `{}`},
+		{name: "required when present", rule: RequiredWhen("enabled", true, "begin"), args: `{"enabled":true,"begin":60}`},
+		{name: "required when missing", rule: RequiredWhen("enabled", true, "begin"), args: `{"enabled":true}`, field: "begin"},
+		// numbers of the request compare equal to numbers of the configuration
+		{name: "required when number", rule: RequiredWhen("mode", 1, "limit"), args: `{"mode":1}`, field: "limit"},
+		{name: "required when string", rule: RequiredWhen("mode", "1", "limit"), args: `{"mode":1}`},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.rule.Check(parseArguments(t, tc.args))
+			if tc.field == "" {
+				if err != nil {
+					t.Errorf("got error %v", err)
+				}
+				return
+			}
+
+			var ba IsBadArgument
+			if !errors.As(err, &ba) || ba.GetBadArgument() != tc.field {
+				t.Fatalf("got error %v, want violation naming %s", err, tc.field)
+			}
+			if !strings.Contains(err.Error(), tc.rule.String()) {
+				t.Errorf("got error %q, want the rule named", err)
+			}
+		})
+	}
+}
+
+func TestDefaultDependencyRules(t *testing.T) {
+	cases := []struct {
+		body  string
+		field string
+	}{
+		{body: `{"method":"torrent-set-location","arguments":{"ids":1,"move":true}}`, field: "location"},
+		{body: `{"method":"torrent-set-location","arguments":{"ids":1,"move":true,"location":"/downloads/x"}}`},
+		{body: `{"method":"session-set","arguments":{"alt-speed-time-enabled":true,"alt-speed-time-begin":60}}`, field: "alt-speed-time-end"},
+		{body: `{"method":"session-set","arguments":{"alt-speed-time-enabled":true,"alt-speed-time-begin":60,"alt-speed-time-end":120}}`},
+		{body: `{"method":"session-set","arguments":{"alt-speed-time-enabled":false}}`},
+		{body: `{"method":"session-set","arguments":{"speed-limit-up":100}}`, field: "speed-limit-up-enabled"},
+		{body: `{"method":"session-set","arguments":{"speed-limit-up":100,"speed-limit-up-enabled":true}}`},
+	}
+
+	v := DefaultMethodsValidator("/downloads/")
+	for _, tc := range cases {
+		_, err := v.Validate(parseRequest(t, tc.body))
+		if tc.field == "" {
+			if err != nil {
+				t.Errorf("%s: got error %v", tc.body, err)
+			}
+			continue
+		}
+
+		var ba IsBadArgument
+		if !errors.As(err, &ba) || ba.GetBadArgument() != tc.field || RejectReason(err) != RejectBadArgument {
+			t.Errorf("%s: got error %v, want violation naming %s", tc.body, err, tc.field)
+		}
+	}
+}
+
+func loadConfig(t *testing.T, yaml string) (*ValidatorConfig, error) {
+	path := filepath.Join(t.TempDir(), "validator.yaml")
+	if err := os.WriteFile(path, []byte(yaml), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	return LoadValidatorConfig(path)
+}
+
+func TestValidatorConfigRules(t *testing.T) {
+	cfg, err := loadConfig(t, `
+methods:
+  torrent-get:
+    rules:
+      - mutually_exclusive: [ids, format]
+  session-set:
+    rules:
+      - required_when: {field: alt-speed-enabled, value: true, requires: alt-speed-up}
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := DefaultMethodsValidator("/downloads/")
+	if err := cfg.Apply(v); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		body  string
+		field string
+	}{
+		{body: `{"method":"torrent-get","arguments":{"fields":["id"],"ids":[1],"format":"table"}}`, field: "format"},
+		{body: `{"method":"torrent-get","arguments":{"fields":["id"],"ids":[1]}}`},
+		{body: `{"method":"session-set","arguments":{"alt-speed-enabled":true}}`, field: "alt-speed-up"},
+		{body: `{"method":"session-set","arguments":{"alt-speed-enabled":true,"alt-speed-up":10}}`},
+		// the built-in rules still apply
+		{body: `{"method":"session-set","arguments":{"speed-limit-up":100}}`, field: "speed-limit-up-enabled"},
+	}
+	for _, tc := range cases {
+		_, err := v.Validate(parseRequest(t, tc.body))
+		var ba IsBadArgument
+		switch {
+		case tc.field == "" && err != nil:
+			t.Errorf("%s: got error %v", tc.body, err)
+		case tc.field != "" && (!errors.As(err, &ba) || ba.GetBadArgument() != tc.field):
+			t.Errorf("%s: got error %v, want violation naming %s", tc.body, err, tc.field)
+		}
+	}
+}
+
+func TestValidatorConfigBadRules(t *testing.T) {
+	cases := []struct {
+		yaml, err string
+	}{
+		{yaml: "methods: {torrent-get: {rules: [{requires: [ids]}]}}", err: "requires must list exactly two fields"},
+		{yaml: "methods: {torrent-get: {rules: [{mutually_exclusive: [a, b, c]}]}}", err: "mutually_exclusive must list exactly two fields"},
+		{yaml: "methods: {torrent-get: {rules: [{required_when: {field: a}}]}}", err: "required_when must define field and requires"},
+		{yaml: "methods: {torrent-get: {rules: [{requires: [a, b], mutually_exclusive: [a, b]}]}}", err: "exactly one of"},
+		{yaml: "methods: {torrent-get: {rules: [{}]}}", err: "exactly one of"},
+		{yaml: "methods: {torrent-fly: {rules: [{requires: [a, b]}]}}", err: `unknown method "torrent-fly"`},
+	}
+
+	for _, tc := range cases {
+		cfg, err := loadConfig(t, tc.yaml)
+		if err == nil {
+			err = cfg.Apply(DefaultMethodsValidator("/downloads/"))
+		}
+		if err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("%s: got error %v, want %q", tc.yaml, err, tc.err)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package transmission
+
+//go:generate go run transmission-proxy/internal/gen -spec spec/rpc-17.json -out methods_gen.go
+
+// ArgType is the type of RPC argument according to the spec.
+type ArgType int
+
+const (
+	ArgAny ArgType = iota
+	ArgArray
+	ArgBoolean
+	ArgIds
+	ArgInteger
+	ArgNumber
+	ArgObject
+	ArgString
+)
+
+// disabledMethods are defined by the spec but not allowed through the proxy.
+var disabledMethods = map[string]bool{}
+
+// disabledArguments are defined by the spec but skipped from the requests:
+// they allow to run arbitrary scripts, to write outside the download prefix or to change the listening port.
+var disabledArguments = map[string][]string{
+	"session-set": {
+		"incomplete-dir",
+		"incomplete-dir-enabled",
+		"peer-port",
+		"peer-port-random-on-start",
+		"script-torrent-added-enabled",
+		"script-torrent-added-filename",
+		"script-torrent-done-enabled",
+		"script-torrent-done-filename",
+		"script-torrent-done-seeding-enabled",
+		"script-torrent-done-seeding-filename",
+	},
+}
+
+// SpecMethods returns names of all methods allowed through the proxy by default.
+func SpecMethods() []string {
+	var res []string
+	for method := range specMethods {
+		if !disabledMethods[method] {
+			res = append(res, method)
+		}
+	}
+
+	return res
+}
+
+// IsSpecMethod reports whether the method is described by the RPC spec, whether allowed by default or not.
+func IsSpecMethod(method string) bool {
+	_, ok := specMethods[method]
+	return ok
+}
+
+// SpecArguments returns arguments of the method allowed through the proxy by default, with their spec types.
+func SpecArguments(method string) map[string]ArgType {
+	args, ok := specMethods[method]
+	if !ok || disabledMethods[method] {
+		return nil
+	}
+
+	res := make(map[string]ArgType, len(args))
+	for arg, typ := range args {
+		res[arg] = typ
+	}
+	for _, arg := range disabledArguments[method] {
+		delete(res, arg)
+	}
+
+	return res
+}
+
+// NewSpecMethod builds validator of the method arguments from the spec, layering given validators
+// over the default ones for the argument types by argument name.
+func NewSpecMethod(method string, overrides map[string]ArgumentValidator) *MethodArgumentsValidator {
+	args := SpecArguments(method)
+
+	v := &MethodArgumentsValidator{Arguments: make(map[string]ArgumentValidator, len(args))}
+	for arg, typ := range args {
+		if o, ok := overrides[arg]; ok {
+			v.Arguments[arg] = o
+		} else {
+			v.Arguments[arg] = argTypeValidator(typ)
+		}
+	}
+
+	return v
+}
+
+func argTypeValidator(typ ArgType) ArgumentValidator {
+	switch typ {
+	case ArgInteger:
+		return &IntValidator{}
+	case ArgIds:
+		return &IdsValidator{}
+	case ArgNumber:
+		return &NumberValidator{}
+	case ArgBoolean:
+		return &BoolValidator{}
+	case ArgString:
+		return &StringValidator{}
+	default:
+		return &Any{}
+	}
+}
+
+func DefaultMethodsValidator(requiredLocPrefix string) *MethodsValidator {
+	typed := map[string]ArgumentsValidator{
+		"torrent-add":          NewMethodTorrentAdd(requiredLocPrefix),
+		"torrent-set":          NewMethodTorrentSet(requiredLocPrefix),
+		"torrent-set-location": NewMethodTorrentSetLocation(requiredLocPrefix),
+		"torrent-rename-path":  NewMethodTorrentRenamePath(),
+		"session-set":          NewMethodSessionSet(requiredLocPrefix),
+	}
+
+	methods := map[string]ArgumentsValidator{}
+	for _, method := range SpecMethods() {
+		if v, ok := typed[method]; ok {
+			methods[method] = v
+		} else {
+			methods[method] = NewSpecMethod(method, nil)
+		}
+	}
+
+	return &MethodsValidator{Methods: methods}
+}
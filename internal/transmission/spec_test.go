@@ -0,0 +1,102 @@
+package transmission
+
+import (
+	"encoding/json"
+	"os"
+	"reflect"
+	"slices"
+	"testing"
+)
+
+var specTypes = map[string]ArgType{
+	"any":     ArgAny,
+	"array":   ArgArray,
+	"boolean": ArgBoolean,
+	"ids":     ArgIds,
+	"integer": ArgInteger,
+	"number":  ArgNumber,
+	"object":  ArgObject,
+	"string":  ArgString,
+}
+
+func TestSpecMatchesSpecFile(t *testing.T) {
+	bs, err := os.ReadFile("spec/rpc-17.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var s struct {
+		RPCVersion int                          `json:"rpc-version"`
+		Methods    map[string]map[string]string `json:"methods"`
+	}
+	if err := json.Unmarshal(bs, &s); err != nil {
+		t.Fatal(err)
+	}
+
+	if s.RPCVersion != SpecRPCVersion {
+		t.Errorf("got rpc version %d, want %d", SpecRPCVersion, s.RPCVersion)
+	}
+
+	var want []string
+	for method, args := range s.Methods {
+		if !disabledMethods[method] {
+			want = append(want, method)
+		}
+
+		wantArgs := map[string]ArgType{}
+		for arg, typ := range args {
+			if !slices.Contains(disabledArguments[method], arg) {
+				wantArgs[arg] = specTypes[typ]
+			}
+		}
+		if got := SpecArguments(method); !disabledMethods[method] && !reflect.DeepEqual(got, wantArgs) {
+			t.Errorf("%s: got arguments %v, want %v", method, got, wantArgs)
+		}
+	}
+
+	got := SpecMethods()
+	slices.Sort(got)
+	slices.Sort(want)
+	if !slices.Equal(got, want) {
+		t.Errorf("got methods %v, want %v", got, want)
+	}
+}
+
+func TestDefaultValidatorCoversSpec(t *testing.T) {
+	v := DefaultMethodsValidator("/downloads/")
+
+	for _, method := range SpecMethods() {
+		if _, ok := v.Methods[method]; !ok {
+			t.Errorf("no validator of %s", method)
+		}
+	}
+	if len(v.Methods) != len(SpecMethods()) {
+		t.Errorf("got %d validators, want %d", len(v.Methods), len(SpecMethods()))
+	}
+}
+
+// TestTypedArgumentsInSpec catches typos in the hand-written argument structs, such as "honorsSessionLimit"
+// for "honorsSessionLimits", which would make the proxy skip the argument.
+func TestTypedArgumentsInSpec(t *testing.T) {
+	typed := map[string]any{
+		"torrent-add":          TorrentAddArguments{},
+		"torrent-set":          TorrentSetArguments{},
+		"torrent-set-location": TorrentSetLocationArguments{},
+		"torrent-rename-path":  TorrentRenamePathArguments{},
+		"session-set":          SessionSetArguments{},
+	}
+
+	for method, args := range typed {
+		spec := SpecArguments(method)
+		fields := fieldIndex(reflect.TypeOf(args))
+		for name := range fields {
+			if _, ok := spec[name]; !ok {
+				t.Errorf("%s: argument %s is not in the spec or disabled", method, name)
+			}
+		}
+		for name := range spec {
+			if _, ok := fields[name]; !ok {
+				t.Errorf("%s: spec argument %s is missing", method, name)
+			}
+		}
+	}
+}
@@ -0,0 +1,208 @@
+package transmission
+
+import (
+	"encoding/json"
+	"errors"
+	"slices"
+)
+
+const (
+	FormatObjects = "objects"
+	FormatTable   = "table"
+)
+
+// statusNames are the names of torrent statuses by their codes.
+var statusNames = []string{"stopped", "check_wait", "check", "download_wait", "download", "seed_wait", "seed"}
+
+// StatusName returns the name of the torrent status code, e.g. "seed".
+func StatusName(code int64) string {
+	if code < 0 || code >= int64(len(statusNames)) {
+		return "unknown"
+	}
+
+	return statusNames[code]
+}
+
+// StatusNames returns the names of all known statuses.
+func StatusNames() []string {
+	return slices.Clone(statusNames)
+}
+
+var ErrMalformedTorrents = errors.New("malformed torrents list")
+
+// Torrents gives uniform access to the torrents list of torrent-get response in both "objects" format
+// (array of objects) and "table" format (array of arrays, the first one holding field names).
+type Torrents struct {
+	table   bool
+	fields  []string
+	rows    []any
+	changed bool
+}
+
+// ParseTorrents wraps the "torrents" argument of torrent-get response.
+func ParseTorrents(v any) (*Torrents, error) {
+	rows, ok := v.([]any)
+	if !ok {
+		return nil, ErrMalformedTorrents
+	}
+
+	t := &Torrents{}
+	if len(rows) == 0 {
+		t.rows = rows
+		return t, nil
+	}
+
+	header, ok := rows[0].([]any)
+	if !ok {
+		for _, row := range rows {
+			if _, ok := row.(map[string]any); !ok {
+				return nil, ErrMalformedTorrents
+			}
+		}
+
+		t.rows = rows
+		return t, nil
+	}
+
+	t.table = true
+	for _, f := range header {
+		name, ok := f.(string)
+		if !ok {
+			return nil, ErrMalformedTorrents
+		}
+		t.fields = append(t.fields, name)
+	}
+	for _, row := range rows[1:] {
+		if r, ok := row.([]any); !ok || len(r) != len(t.fields) {
+			return nil, ErrMalformedTorrents
+		}
+	}
+
+	t.rows = rows[1:]
+	return t, nil
+}
+
+func (t *Torrents) Len() int {
+	return len(t.rows)
+}
+
+// Get returns the field of the i-th torrent.
+func (t *Torrents) Get(i int, field string) (any, bool) {
+	if !t.table {
+		v, ok := t.rows[i].(map[string]any)[field]
+		return v, ok
+	}
+
+	j := slices.Index(t.fields, field)
+	if j < 0 {
+		return nil, false
+	}
+
+	return t.rows[i].([]any)[j], true
+}
+
+// Int64 returns the numeric field of the i-th torrent, or 0 if it is missing or not an integer.
+// Responses must be parsed with numbers kept as json.Number (see jrpc.ParseResponse).
+func (t *Torrents) Int64(i int, field string) int64 {
+	v, _ := t.Get(i, field)
+	n, _ := v.(json.Number)
+	res, _ := n.Int64()
+	return res
+}
+
+// Float64 returns the numeric field of the i-th torrent, or 0 if it is missing or not a number.
+func (t *Torrents) Float64(i int, field string) float64 {
+	v, _ := t.Get(i, field)
+	n, _ := v.(json.Number)
+	res, _ := n.Float64()
+	return res
+}
+
+// String returns the string field of the i-th torrent, or empty string.
+func (t *Torrents) String(i int, field string) string {
+	v, _ := t.Get(i, field)
+	s, _ := v.(string)
+	return s
+}
+
+// Set changes the field of the i-th torrent, which must be present.
+func (t *Torrents) Set(i int, field string, v any) {
+	if !t.table {
+		t.rows[i].(map[string]any)[field] = v
+		t.changed = true
+		return
+	}
+
+	if j := slices.Index(t.fields, field); j >= 0 {
+		t.rows[i].([]any)[j] = v
+		t.changed = true
+	}
+}
+
+// Filter keeps only torrents for which keep returns true.
+func (t *Torrents) Filter(keep func(i int) bool) {
+	res := make([]any, 0, len(t.rows))
+	for i, row := range t.rows {
+		if keep(i) {
+			res = append(res, row)
+		}
+	}
+
+	t.changed = t.changed || len(res) != len(t.rows)
+	t.rows = res
+}
+
+// DropField removes the field from all torrents.
+func (t *Torrents) DropField(field string) {
+	if !t.table {
+		for _, row := range t.rows {
+			if _, ok := row.(map[string]any)[field]; ok {
+				delete(row.(map[string]any), field)
+				t.changed = true
+			}
+		}
+		return
+	}
+
+	j := slices.Index(t.fields, field)
+	if j < 0 {
+		return
+	}
+
+	t.changed = true
+	t.fields = slices.Delete(slices.Clone(t.fields), j, j+1)
+	for i, row := range t.rows {
+		t.rows[i] = slices.Delete(slices.Clone(row.([]any)), j, j+1)
+	}
+}
+
+// Changed reports whether any torrent was changed or removed since the list was parsed.
+func (t *Torrents) Changed() bool {
+	return t.changed
+}
+
+// Value returns the torrents list in the original format.
+func (t *Torrents) Value() any {
+	if !t.table {
+		return t.rows
+	}
+
+	header := make([]any, len(t.fields))
+	for i, f := range t.fields {
+		header[i] = f
+	}
+
+	return append([]any{header}, t.rows...)
+}
+
+// HasLabel reports whether the labels field value contains the label.
+func HasLabel(labels any, label string) bool {
+	ls, _ := labels.([]any)
+	for _, l := range ls {
+		if l == label {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,129 @@
+package transmission
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+// tableTorrents is the torrents argument of torrent-get response in "table" format as sent by Transmission 4.
+const tableTorrents = `[["id","name","downloadDir","percentDone","sizeWhenDone","labels"],` +
+	`[1,"ubuntu-24.04-desktop-amd64.iso","/downloads/linux",1,6114656256,["linux"]],` +
+	`[2,"Tom & Jerry (1940)","/downloads/films",0.4581,734003200,[]],` +
+	`[3,"debian-12.5.0-amd64-netinst.iso","/srv/other",0,659554304,["linux","alice"]]]`
+
+// objectTorrents is the torrents argument in "objects" format, with keys sorted as encoding/json writes them.
+const objectTorrents = `[{"downloadDir":"/downloads/linux","id":1,"name":"ubuntu-24.04-desktop-amd64.iso"},` +
+	`{"downloadDir":"/srv/other","id":3,"name":"debian-12.5.0-amd64-netinst.iso"}]`
+
+func parseTorrents(t *testing.T, s string) *Torrents {
+	dec := json.NewDecoder(bytes.NewReader([]byte(s)))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		t.Fatal(err)
+	}
+
+	torrents, err := ParseTorrents(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return torrents
+}
+
+func marshalTorrents(t *testing.T, torrents *Torrents) string {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	// the names are compared as Transmission sends them
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(torrents.Value()); err != nil {
+		t.Fatal(err)
+	}
+
+	return string(bytes.TrimSuffix(buf.Bytes(), []byte("\n")))
+}
+
+func TestTorrentsTableRoundTrip(t *testing.T) {
+	torrents := parseTorrents(t, tableTorrents)
+
+	if torrents.Len() != 3 {
+		t.Fatalf("got %d torrents, want 3", torrents.Len())
+	}
+	if got := torrents.String(1, "name"); got != "Tom & Jerry (1940)" {
+		t.Errorf("got name %q", got)
+	}
+	if got := torrents.Int64(0, "sizeWhenDone"); got != 6114656256 {
+		t.Errorf("got sizeWhenDone %d", got)
+	}
+	if got := torrents.Float64(1, "percentDone"); got != 0.4581 {
+		t.Errorf("got percentDone %v", got)
+	}
+
+	// operations which do not change anything
+	torrents.Filter(func(i int) bool { return true })
+	torrents.DropField("status")
+	if torrents.Changed() {
+		t.Error("unchanged torrents reported changed")
+	}
+	if got := marshalTorrents(t, torrents); got != tableTorrents {
+		t.Errorf("round trip changed torrents:\n got %s\nwant %s", got, tableTorrents)
+	}
+}
+
+func TestTorrentsTableRewrite(t *testing.T) {
+	torrents := parseTorrents(t, tableTorrents)
+
+	torrents.Filter(func(i int) bool { return torrents.String(i, "downloadDir") != "/srv/other" })
+	torrents.DropField("labels")
+	torrents.Set(0, "downloadDir", "/linux")
+
+	if !torrents.Changed() {
+		t.Error("changed torrents reported unchanged")
+	}
+	want := `[["id","name","downloadDir","percentDone","sizeWhenDone"],` +
+		`[1,"ubuntu-24.04-desktop-amd64.iso","/linux",1,6114656256],` +
+		`[2,"Tom & Jerry (1940)","/downloads/films",0.4581,734003200]]`
+	if got := marshalTorrents(t, torrents); got != want {
+		t.Errorf("got %s\nwant %s", got, want)
+	}
+}
+
+func TestTorrentsObjects(t *testing.T) {
+	torrents := parseTorrents(t, objectTorrents)
+
+	torrents.DropField("labels")
+	if torrents.Changed() {
+		t.Error("dropping missing field reported as change")
+	}
+	if got := marshalTorrents(t, torrents); got != objectTorrents {
+		t.Errorf("round trip changed torrents:\n got %s\nwant %s", got, objectTorrents)
+	}
+
+	torrents.Filter(func(i int) bool { return torrents.Int64(i, "id") == 3 })
+	torrents.DropField("downloadDir")
+	if !torrents.Changed() {
+		t.Error("changed torrents reported unchanged")
+	}
+	want := `[{"id":3,"name":"debian-12.5.0-amd64-netinst.iso"}]`
+	if got := marshalTorrents(t, torrents); got != want {
+		t.Errorf("got %s\nwant %s", got, want)
+	}
+}
+
+func TestParseTorrentsMalformed(t *testing.T) {
+	for _, s := range []string{
+		`{}`,
+		`[["id","name"],[1]]`,
+		`[["id",2],[1,"x"]]`,
+		`[{"id":1},[1]]`,
+	} {
+		var v any
+		if err := json.Unmarshal([]byte(s), &v); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ParseTorrents(v); err != ErrMalformedTorrents {
+			t.Errorf("%s: got error %v, want %v", s, err, ErrMalformedTorrents)
+		}
+	}
+}
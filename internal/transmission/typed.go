@@ -0,0 +1,285 @@
+package transmission
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// TypedArguments may be implemented by argument structs to check rules involving several fields.
+// It is called only after all fields have been decoded successfully.
+type TypedArguments interface {
+	ValidateArguments() error
+}
+
+// TypedArgumentsValidator validates method arguments by decoding them into struct T, whose json tags define
+// the set of known arguments and whose field types define the accepted types. Fields may additionally
+// have validators of their own (e.g. location prefix). Sanitized arguments differ from the original ones
+// only by removed unknown fields and added Defaults, so other valid requests are forwarded exactly as received.
+type TypedArgumentsValidator[T any] struct {
+	Fields map[string]ArgumentValidator
+	Rules  []DependencyRule
+	// Defaults are added to valid requests which miss the arguments, e.g. so that Transmission does not
+	// fall back to its own defaults. They are not validated.
+	Defaults       map[string]any
+	ErrorOnUnknown bool
+	// FailFast stops validation on the first bad argument instead of collecting all of them.
+	FailFast bool
+}
+
+// WithRules returns copy of the validator with additional dependency rules.
+func (v *TypedArgumentsValidator[T]) WithRules(rules ...DependencyRule) ArgumentsValidator {
+	c := *v
+	c.Rules = append(append([]DependencyRule{}, v.Rules...), rules...)
+	return &c
+}
+
+// WithField returns copy of the validator with the field validator set.
+func (v *TypedArgumentsValidator[T]) WithField(key string, fv ArgumentValidator) ArgumentsValidator {
+	c := *v
+	c.Fields = make(map[string]ArgumentValidator, len(v.Fields)+1)
+	for k, f := range v.Fields {
+		c.Fields[k] = f
+	}
+	c.Fields[key] = fv
+	return &c
+}
+
+// WithDefault returns copy of the validator adding the argument to requests which miss it.
+func (v *TypedArgumentsValidator[T]) WithDefault(key string, value any) ArgumentsValidator {
+	c := *v
+	c.Defaults = make(map[string]any, len(v.Defaults)+1)
+	for k, d := range v.Defaults {
+		c.Defaults[k] = d
+	}
+	c.Defaults[key] = value
+	return &c
+}
+
+func (v *TypedArgumentsValidator[T]) Validate(args map[string]any) (sanitized map[string]any, err error, info []any) {
+	errs := argumentErrors{failFast: v.FailFast}
+	res := argumentsCopy{orig: args}
+
+	// the values are stored only if the struct checks them itself, otherwise only their types are checked
+	var typed *T
+	var dst reflect.Value
+	if _, ok := any(typed).(TypedArguments); ok {
+		typed = new(T)
+		dst = reflect.ValueOf(typed).Elem()
+	}
+
+	fields := fieldIndex(reflect.TypeOf(typed).Elem())
+	for _, key := range sortedKeys(args) {
+		f, ok := fields[key]
+		if !ok {
+			if v.ErrorOnUnknown {
+				if errs.add(&forbiddenField{name: key}) {
+					return nil, errs.err(), info
+				}
+				continue
+			}
+
+			info = append(info, skippedField{field: key})
+			res.delete(key)
+			continue
+		}
+
+		var err error
+		if args[key] != nil {
+			err = f.check(args[key])
+		}
+		// arguments of wrong type are not validated further
+		if fv, ok := v.Fields[key]; ok && err == nil {
+			err = fv.Validate(key, args[key])
+		}
+		if err == nil && dst.IsValid() {
+			storeValue(dst.Field(f.index), args[key])
+		}
+		if err != nil {
+			if errs.add(&badArgument{field: key, err: err}) {
+				return nil, errs.err(), info
+			}
+		}
+	}
+
+	sanitized = res.result()
+	checkRules(v.Rules, sanitized, &errs)
+
+	if err := errs.err(); err != nil {
+		return nil, err, info
+	}
+
+	if typed != nil {
+		if err := any(typed).(TypedArguments).ValidateArguments(); err != nil {
+			return nil, err, info
+		}
+	}
+
+	for _, key := range sortedKeys(v.Defaults) {
+		if _, ok := sanitized[key]; !ok {
+			res.set(key, v.Defaults[key])
+		}
+	}
+
+	return res.result(), nil, info
+}
+
+// typedField is the field of argument struct the argument is decoded into.
+type typedField struct {
+	index int
+	check func(value any) error
+}
+
+var typedFields sync.Map // reflect.Type -> map[string]typedField
+
+func fieldIndex(t reflect.Type) map[string]typedField {
+	if idx, ok := typedFields.Load(t); ok {
+		return idx.(map[string]typedField)
+	}
+
+	idx := map[string]typedField{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name != "" && name != "-" {
+			idx[name] = typedField{index: i, check: typeCheck(f.Type)}
+		}
+	}
+
+	typedFields.Store(t, idx)
+	return idx
+}
+
+// typeCheck returns the function checking that non-null arguments may be stored in value of type t
+// as json.Unmarshal would. Numbers are checked the same way IntValidator and NumberValidator check them,
+// so that typed and untyped validators accept the same values: e.g. 1e3 is integer, and fractions
+// or numeric strings are rejected only with StrictNumericTypes.
+func typeCheck(t reflect.Type) func(value any) error {
+	switch t.Kind() {
+	case reflect.Pointer:
+		return typeCheck(t.Elem())
+	case reflect.Interface:
+		return func(value any) error {
+			return nil
+		}
+	case reflect.Slice:
+		check := typeCheck(t.Elem())
+		return func(value any) error {
+			items, ok := value.([]any)
+			if !ok {
+				return typeError(t, value)
+			}
+			for _, item := range items {
+				if item == nil {
+					continue
+				}
+				if err := check(item); err != nil {
+					return fmt.Errorf("must be %s: %w", typeName(t), err)
+				}
+			}
+			return nil
+		}
+	case reflect.Bool:
+		return func(value any) error {
+			if _, ok := value.(bool); !ok {
+				return typeError(t, value)
+			}
+			return nil
+		}
+	case reflect.String:
+		return func(value any) error {
+			if _, ok := value.(string); !ok {
+				return typeError(t, value)
+			}
+			return nil
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return func(value any) error {
+			if err := checkInteger(value, StrictNumericTypes); err != nil {
+				return err
+			}
+			// int64 range is checked already
+			if t.Bits() < 64 && reflect.Zero(t).OverflowInt(integerValue(value)) {
+				return fmt.Errorf("must be integer within %s range, got %s", t.Kind(), represent(value))
+			}
+			return nil
+		}
+	case reflect.Float32, reflect.Float64:
+		return func(value any) error {
+			_, err := number(value, StrictNumericTypes)
+			return err
+		}
+	default:
+		return func(value any) error {
+			bs, err := json.Marshal(value)
+			if err == nil {
+				err = json.Unmarshal(bs, reflect.New(t).Interface())
+			}
+			if err != nil {
+				return typeError(t, value)
+			}
+			return nil
+		}
+	}
+}
+
+func typeError(t reflect.Type, value any) error {
+	return fmt.Errorf("must be %s, got %s", typeName(t), represent(value))
+}
+
+// storeValue stores the argument accepted by typeCheck in v.
+func storeValue(v reflect.Value, value any) {
+	// null leaves the field unset
+	if value == nil {
+		return
+	}
+
+	switch v.Kind() {
+	case reflect.Pointer:
+		p := reflect.New(v.Type().Elem())
+		storeValue(p.Elem(), value)
+		v.Set(p)
+	case reflect.Interface:
+		v.Set(reflect.ValueOf(value))
+	case reflect.Slice:
+		items := value.([]any)
+		s := reflect.MakeSlice(v.Type(), len(items), len(items))
+		for i, item := range items {
+			storeValue(s.Index(i), item)
+		}
+		v.Set(s)
+	case reflect.Bool:
+		v.SetBool(value.(bool))
+	case reflect.String:
+		v.SetString(value.(string))
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		v.SetInt(integerValue(value))
+	case reflect.Float32, reflect.Float64:
+		f, _ := number(value, false)
+		v.SetFloat(f)
+	default:
+		bs, _ := json.Marshal(value)
+		_ = json.Unmarshal(bs, v.Addr().Interface())
+	}
+}
+
+func typeName(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Pointer:
+		return typeName(t.Elem())
+	case reflect.Slice:
+		return "array of " + typeName(t.Elem())
+	case reflect.Bool:
+		return "boolean"
+	case reflect.String:
+		return "string"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return "integer"
+	case reflect.Float32, reflect.Float64:
+		return "number"
+	default:
+		return "valid value"
+	}
+}
@@ -0,0 +1,182 @@
+package transmission
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"transmission-proxy/internal/jrpc"
+)
+
+func parseArguments(t testing.TB, args string) map[string]any {
+	var req jrpc.Request
+	if err := json.Unmarshal([]byte(`{"method":"x","arguments":`+args+`}`), &req); err != nil {
+		t.Fatalf("decode %s: %v", args, err)
+	}
+
+	return req.Arguments
+}
+
+func TestTypedArgumentsStrictNumericTypes(t *testing.T) {
+	cases := []struct {
+		args string
+		// strict and lax tell whether the arguments are accepted in strict and default mode
+		strict, lax bool
+		errText     string
+	}{
+		{args: `{"peer-limit": 50}`, strict: true, lax: true},
+		{args: `{"peer-limit": 50.7}`, strict: false, lax: true, errText: "got fractional number 50.7"},
+		{args: `{"peer-limit": "50"}`, strict: false, lax: true, errText: `got string "50"`},
+		{args: `{"files-wanted": [1, 2e0]}`, strict: true, lax: true},
+		{args: `{"files-wanted": [1, 2.5]}`, strict: false, lax: true, errText: "must be array of integer: must be integer, got fractional number 2.5"},
+		{args: `{"seedRatioLimit": 1.5}`, strict: true, lax: true},
+		{args: `{"seedRatioLimit": "1.5"}`, strict: false, lax: true, errText: `must be number, got "1.5"`},
+		{args: `{"labels": ["a", 1]}`, strict: false, lax: false, errText: "must be array of string: must be string, got 1"},
+		{args: `{"downloadLimited": 1}`, strict: false, lax: false, errText: "must be boolean, got 1"},
+		{args: `{"downloadLimited": null, "labels": null}`, strict: true, lax: true},
+	}
+
+	for _, strict := range []bool{true, false} {
+		setStrictNumericTypes(t, strict)
+		v := NewMethodTorrentSet("/downloads/")
+
+		for _, tc := range cases {
+			_, err, _ := v.Validate(parseArguments(t, tc.args))
+			want := tc.lax
+			if strict {
+				want = tc.strict
+			}
+			if (err == nil) != want {
+				t.Errorf("strict=%v %s: got error %v, want accepted %v", strict, tc.args, err, want)
+			}
+			if err != nil && !strings.Contains(err.Error(), tc.errText) {
+				t.Errorf("strict=%v %s: got error %q, want it to contain %q", strict, tc.args, err, tc.errText)
+			}
+		}
+	}
+}
+
+func TestTypedArgumentsForwardedUnchanged(t *testing.T) {
+	body := `{"method":"torrent-set","arguments":{"ids":[1,"0123456789abcdef0123456789abcdef01234567"],"peer-limit":1e3,"seedRatioLimit":1.50,"labels":["a"]},"tag":7}`
+	var req jrpc.Request
+	if err := json.Unmarshal([]byte(body), &req); err != nil {
+		t.Fatal(err)
+	}
+
+	sanitized, err := DefaultMethodsValidator("/downloads/").Validate(&req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the proxy forwards the raw body of requests the validator returns as they are
+	if sanitized != &req {
+		t.Errorf("valid request was copied: %+v", sanitized)
+	}
+}
+
+func TestTypedArgumentsValidateArguments(t *testing.T) {
+	v := NewMethodTorrentAdd("/downloads/")
+
+	if _, err, _ := v.Validate(parseArguments(t, `{"paused": true, "filename": null}`)); !errors.Is(err, ErrFilenameOrMetainfo) {
+		t.Errorf("got error %v, want %v", err, ErrFilenameOrMetainfo)
+	}
+	if _, err, _ := v.Validate(parseArguments(t, `{"paused": true, "filename": "magnet:?xt=urn:btih:x"}`)); err != nil {
+		t.Errorf("got error %v for valid arguments", err)
+	}
+}
+
+func TestTypedArgumentsSkipUnknown(t *testing.T) {
+	args := parseArguments(t, `{"ids":1,"location":"/downloads/x","script":"rm -rf /"}`)
+
+	sanitized, err, info := NewMethodTorrentSetLocation("/downloads/").Validate(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := sanitized["script"]; ok || len(sanitized) != 2 {
+		t.Errorf("unknown argument was not skipped: %v", sanitized)
+	}
+	if _, ok := args["script"]; !ok {
+		t.Errorf("original arguments were modified: %v", args)
+	}
+	if len(info) != 1 || info[0].(skippedField).field != "script" {
+		t.Errorf("got info %v, want skipped script", info)
+	}
+}
+
+// torrentSetBenchmarkArgs are arguments of a typical torrent-set request of a web client.
+const torrentSetBenchmarkArgs = `{"ids":[1,2,3,"0123456789abcdef0123456789abcdef01234567"],"downloadLimit":500,"downloadLimited":true,` +
+	`"files-wanted":[0,1,2,3,4,5,6,7],"labels":["movies","hd"],"location":"/downloads/movies","peer-limit":60,` +
+	`"seedRatioLimit":2.5,"seedRatioMode":1,"uploadLimit":100,"uploadLimited":false}`
+
+func BenchmarkTorrentSetTyped(b *testing.B) {
+	v := NewMethodTorrentSet("/downloads/")
+	benchmarkArguments(b, v)
+}
+
+// BenchmarkTorrentSetGeneric validates the same arguments as BenchmarkTorrentSetTyped with the generic
+// validator built from the spec, checking the spec types and then the same field validators.
+func BenchmarkTorrentSetGeneric(b *testing.B) {
+	fields := NewMethodTorrentSet("/downloads/").Fields
+	v := NewSpecMethod("torrent-set", nil)
+	for key, fv := range fields {
+		v.Arguments[key] = chainValidator{v.Arguments[key], fv}
+	}
+	benchmarkArguments(b, v)
+}
+
+type chainValidator []ArgumentValidator
+
+func (c chainValidator) Validate(key string, value any) error {
+	for _, v := range c {
+		if err := v.Validate(key, value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func benchmarkArguments(b *testing.B, v ArgumentsValidator) {
+	args := parseArguments(b, torrentSetBenchmarkArgs)
+	if _, err, _ := v.Validate(args); err != nil {
+		b.Fatal(err)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, _, _ = v.Validate(args)
+	}
+}
+
+func TestTypedArgumentsDefaults(t *testing.T) {
+	base := NewMethodTorrentAdd("/downloads/")
+	v := base.WithDefault("download-dir", "/downloads/")
+
+	cases := []struct {
+		args, want string
+	}{
+		{args: `{"filename":"magnet:?x"}`, want: `{"download-dir":"/downloads/","filename":"magnet:?x"}`},
+		// explicit values are kept
+		{args: `{"filename":"magnet:?x","download-dir":"/downloads/tv"}`, want: `{"download-dir":"/downloads/tv","filename":"magnet:?x"}`},
+		// the default is added to what is left of sanitized arguments
+		{args: `{"filename":"magnet:?x","script":"rm"}`, want: `{"download-dir":"/downloads/","filename":"magnet:?x"}`},
+	}
+	for _, tc := range cases {
+		got, err, _ := v.Validate(parseArguments(t, tc.args))
+		if err != nil {
+			t.Fatalf("%s: %v", tc.args, err)
+		}
+		if bs, _ := json.Marshal(got); string(bs) != tc.want {
+			t.Errorf("%s: got %s, want %s", tc.args, bs, tc.want)
+		}
+	}
+
+	// rejected arguments get no defaults, and the original validator has none
+	if got, err, _ := v.Validate(parseArguments(t, `{"filename":"magnet:?x","download-dir":"/etc"}`)); !errors.Is(err, ErrTorrentForbiddenLocation) || got != nil {
+		t.Errorf("outside prefix: got %v, %v", got, err)
+	}
+	if got, _, _ := base.Validate(parseArguments(t, `{"filename":"magnet:?x"}`)); got["download-dir"] != nil {
+		t.Errorf("base validator: got %v", got)
+	}
+}
@@ -1,9 +1,15 @@
 package transmission
 
 import (
+	"errors"
 	"fmt"
 	"log/slog"
+	"path"
+	"reflect"
+	"slices"
+	"sort"
 	"strings"
+	"sync/atomic"
 
 	"transmission-proxy/internal/jrpc"
 	"transmission-proxy/internal/logger"
@@ -11,6 +17,7 @@ import (
 
 var (
 	ErrUnknownMethod            = fmt.Errorf("unknown method")
+	ErrMethodDenied             = fmt.Errorf("method not allowed by proxy policy")
 	ErrTorrentLocationWrongType = fmt.Errorf("must be string")
 	ErrTorrentForbiddenLocation = fmt.Errorf("forbidden location")
 )
@@ -32,7 +39,35 @@ func (f *forbiddenField) Error() string {
 }
 
 func (f *forbiddenField) GetLoggableAttrs() []slog.Attr {
-	return []slog.Attr{slog.String("field", f.name)}
+	return []slog.Attr{logger.RPCField(f.name)}
+}
+
+type badArgument struct {
+	field string
+	err   error
+}
+
+func (b *badArgument) Error() string {
+	return "bad argument: " + b.err.Error()
+}
+
+func (b *badArgument) Unwrap() error {
+	return b.err
+}
+
+func (b *badArgument) GetBadArgument() string {
+	return b.field
+}
+
+func (b *badArgument) GetLoggableAttrs() []slog.Attr {
+	attrs := []slog.Attr{logger.RPCField(b.field)}
+	// e.g. the torrent of rejected metainfo
+	var ha logger.HasLoggableAttrs
+	if errors.As(b.err, &ha) {
+		attrs = append(attrs, ha.GetLoggableAttrs()...)
+	}
+
+	return attrs
 }
 
 type skippedField struct {
@@ -48,15 +83,60 @@ func (s *skippedField) GetBadArgument() string {
 }
 
 func (s *skippedField) GetLoggableAttrs() []slog.Attr {
-	return []slog.Attr{slog.String("field", s.field)}
+	return []slog.Attr{logger.RPCField(s.field)}
 }
 
+// ReadOnlyMethods lists methods which do not change state of the daemon or its torrents.
+var ReadOnlyMethods = []string{
+	"torrent-get",
+	"session-get",
+	"session-stats",
+	"free-space",
+	"port-test",
+	"group-get",
+}
+
+// Reasons of request rejection reported by RejectReason.
+const (
+	RejectUnknownMethod  = "unknown_method"
+	RejectMethodDenied   = "method_denied"
+	RejectForbiddenField = "forbidden_field"
+	RejectLocation       = "forbidden_location"
+	RejectBadArgument    = "bad_argument"
+	RejectPolicy         = "policy"
+)
+
+// RejectReason classifies the error returned by the validator for logging and statistics.
+func RejectReason(err error) string {
+	var ff *forbiddenField
+	var he *hookError
+	switch {
+	// hooks may reject locations too, e.g. RemoveDataCheck
+	case errors.Is(err, ErrTorrentForbiddenLocation):
+		return RejectLocation
+	case errors.As(err, &he):
+		return RejectPolicy
+	case errors.Is(err, ErrUnknownMethod):
+		return RejectUnknownMethod
+	case errors.Is(err, ErrMethodDenied):
+		return RejectMethodDenied
+	case errors.As(err, &ff):
+		return RejectForbiddenField
+	default:
+		return RejectBadArgument
+	}
+}
+
+// RequestValidator checks the request and returns its sanitized copy which should be forwarded upstream.
+// The original request is never modified.
 type RequestValidator interface {
-	Validate(req *jrpc.Request) error
+	Validate(req *jrpc.Request) (*jrpc.Request, error)
 }
 
+// ArgumentsValidator checks the arguments and returns their sanitized version, which may be the same map
+// if nothing had to be changed. The passed map is never modified.
 type ArgumentsValidator interface {
-	Validate(args map[string]any) (err error, info []any)
+	Validate(args map[string]any) (sanitized map[string]any, err error, info []any)
 }
 
 type ArgumentValidator interface {
@@ -65,81 +145,192 @@ type ArgumentValidator interface {
 
 type MethodsValidator struct {
 	Methods map[string]ArgumentsValidator
+
+	denied    map[string]bool
+	preHooks  []ValidationHook
+	postHooks []ValidationHook
+	sealed    atomic.Bool
 }
 
-func (p *MethodsValidator) Validate(req *jrpc.Request) error {
+func (p *MethodsValidator) Validate(req *jrpc.Request) (*jrpc.Request, error) {
+	p.sealed.Store(true)
+
+	if err := runHooks(p.preHooks, req); err != nil {
+		return nil, logger.WithAttributes(err, logger.RPCMethod(req.Method))
+	}
+
+	sanitized, err := p.validateMethod(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := runHooks(p.postHooks, sanitized); err != nil {
+		return nil, logger.WithAttributes(err, logger.RPCMethod(req.Method))
+	}
+
+	return sanitized, nil
+}
+
+// DenyMethods makes requests for the methods fail with ErrMethodDenied, rather than ErrUnknownMethod
+// as if they were removed from Methods. Same registration restrictions as for hooks apply.
+func (p *MethodsValidator) DenyMethods(methods ...string) {
+	p.mustNotBeSealed()
+
+	if p.denied == nil {
+		p.denied = map[string]bool{}
+	}
+	for _, method := range methods {
+		delete(p.Methods, method)
+		p.denied[method] = true
+	}
+}
+
+// AllowOnlyMethods denies all the methods but the listed ones, see DenyMethods.
+func (p *MethodsValidator) AllowOnlyMethods(methods ...string) {
+	var denied []string
+	for method := range p.Methods {
+		if !slices.Contains(methods, method) {
+			denied = append(denied, method)
+		}
+	}
+
+	p.DenyMethods(denied...)
+}
+
+func (p *MethodsValidator) validateMethod(req *jrpc.Request) (*jrpc.Request, error) {
+	if p.denied[req.Method] {
+		return nil, logger.WithAttributes(ErrMethodDenied, logger.RPCMethod(req.Method))
+	}
+
 	if v, ok := p.Methods[req.Method]; ok {
-		err, info := v.Validate(req.Arguments)
+		ctx := req.Ctx()
+		args, err, info := v.Validate(req.Arguments)
 		for _, i := range info {
 			if sf, ok := i.(skippedField); ok {
-				slog.WarnContext(req.Context, "skip field from RPC request",
-					slog.String("method", req.Method),
-					slog.String("field", sf.field))
+				slog.WarnContext(ctx, "skip field from RPC request",
+					logger.RPCMethod(req.Method),
+					logger.RPCTag(req.Tag),
+					logger.RPCField(sf.field))
 			} else if ba, ok := i.(IsBadArgument); ok {
-				slog.WarnContext(req.Context, fmt.Sprintf("%v", i),
-					slog.String("method", req.Method),
-					slog.String("field", ba.GetBadArgument()))
+				slog.WarnContext(ctx, fmt.Sprintf("%v", i),
+					logger.RPCMethod(req.Method),
+					logger.RPCTag(req.Tag),
+					logger.RPCField(ba.GetBadArgument()))
 			} else {
-				slog.WarnContext(req.Context, fmt.Sprintf("%v", i), slog.String("method", req.Method))
+				slog.WarnContext(ctx, fmt.Sprintf("%v", i),
+					logger.RPCMethod(req.Method),
+					logger.RPCTag(req.Tag))
 			}
 		}
 
-		return logger.WithAttributes(err, slog.String("method", req.Method))
+		if err != nil {
+			return nil, logger.WithAttributes(err, logger.RPCMethod(req.Method))
+		}
+
+		// unchanged requests are passed on as they are, so that they are forwarded without re-encoding
+		if sameMap(args, req.Arguments) {
+			return req, nil
+		}
+
+		sanitized := *req
+		sanitized.Arguments = args
+		return &sanitized, nil
 	}
 
-	return logger.WithAttributes(ErrUnknownMethod, slog.String("method", req.Method))
-}
-
-func DefaultMethodsValidator(requiredLocPrefix string) *MethodsValidator {
-	return &MethodsValidator{Methods: map[string]ArgumentsValidator{
-		"torrent-start":        &MethodTorrentAction,
-		"torrent-start-now":    &MethodTorrentAction,
-		"torrent-stop":         &MethodTorrentAction,
-		"torrent-verify":       &MethodTorrentAction,
-		"torrent-reannounce":   &MethodTorrentAction,
-		"torrent-set":          NewMethodTorrentSet(requiredLocPrefix),
-		"torrent-get":          &MethodTorrentGet,
-		"torrent-add":          NewMethodTorrentAdd(requiredLocPrefix),
-		"torrent-remove":       &MethodTorrentRemove,
-		"torrent-set-location": NewMethodTorrentSetLocation(requiredLocPrefix),
-		"session-set":          NewMethodSessionSet(requiredLocPrefix),
-		"session-get":          &MethodSessionGet,
-		"session-stats":        &EmptyMethod,
-		"blocklist-update":     &EmptyMethod,
-		"port-test":            &MethodPortTest,
-		"session-close":        &EmptyMethod,
-		"queue-move-top":       &MethodTorrentAction,
-		"queue-move-up":        &MethodTorrentAction,
-		"queue-move-down":      &MethodTorrentAction,
-		"queue-move-bottom":    &MethodTorrentAction,
-		"free-space":           &MethodFreeSpace,
-		"group-set":            &MethodGroupSet,
-		"group-get":            &MethodGroupGet,
-	}}
+	return nil, logger.WithAttributes(ErrUnknownMethod, logger.RPCMethod(req.Method))
 }
 
 type MethodArgumentsValidator struct {
 	Arguments      map[string]ArgumentValidator
+	Rules          []DependencyRule
 	ErrorOnUnknown bool
+	// FailFast stops validation on the first bad argument instead of collecting all of them.
+	FailFast bool
 }
 
-func (a *MethodArgumentsValidator) Validate(args map[string]any) (err error, info []any) {
-	for key, val := range args {
+// WithRules returns copy of the validator with additional dependency rules.
+func (a *MethodArgumentsValidator) WithRules(rules ...DependencyRule) ArgumentsValidator {
+	c := *a
+	c.Rules = append(append([]DependencyRule{}, a.Rules...), rules...)
+	return &c
+}
+
+func (a *MethodArgumentsValidator) Validate(args map[string]any) (sanitized map[string]any, err error, info []any) {
+	errs := argumentErrors{failFast: a.FailFast}
+	res := argumentsCopy{orig: args}
+
+	for _, key := range sortedKeys(args) {
 		if v, ok := a.Arguments[key]; ok {
-			if err := v.Validate(key, val); err != nil {
-				return logger.WithAttributes(
-					fmt.Errorf("bad argument: %w", err), slog.String("field", key),
-				), info
+			if err := v.Validate(key, args[key]); err != nil {
+				if errs.add(&badArgument{field: key, err: err}) {
+					break
+				}
 			}
 		} else if a.ErrorOnUnknown {
-			return &forbiddenField{name: key}, info
+			if errs.add(&forbiddenField{name: key}) {
+				break
+			}
 		} else {
 			info = append(info, skippedField{field: key})
-			delete(args, key)
+			res.delete(key)
+		}
+	}
+
+	checkRules(a.Rules, res.result(), &errs)
+
+	if err := errs.err(); err != nil {
+		return nil, err, info
+	}
+
+	return res.result(), nil, info
+}
+
+// sameMap reports whether both arguments are the same map (not just equal ones).
+func sameMap(a, b map[string]any) bool {
+	return reflect.ValueOf(a).UnsafePointer() == reflect.ValueOf(b).UnsafePointer()
+}
+
+func sortedKeys(args map[string]any) []string {
+	keys := make([]string, 0, len(args))
+	for key := range args {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// argumentsCopy produces sanitized arguments, copying the original map lazily on the first change.
+type argumentsCopy struct {
+	orig   map[string]any
+	copied map[string]any
+}
+
+func (c *argumentsCopy) delete(key string) {
+	c.ensureCopied()
+	delete(c.copied, key)
+}
+
+func (c *argumentsCopy) set(key string, value any) {
+	c.ensureCopied()
+	c.copied[key] = value
+}
+
+func (c *argumentsCopy) ensureCopied() {
+	if c.copied == nil {
+		c.copied = make(map[string]any, len(c.orig)+1)
+		for k, v := range c.orig {
+			c.copied[k] = v
 		}
 	}
+}
+
+func (c *argumentsCopy) result() map[string]any {
+	if c.copied == nil {
+		return c.orig
+	}
 
-	return nil, info
+	return c.copied
 }
 
 type Any struct{}
@@ -148,47 +339,68 @@ func (a *Any) Validate(key string, value any) error {
 	return nil
 }
 
-var EmptyMethod = MethodArgumentsValidator{Arguments: map[string]ArgumentValidator{}}
-
-var MethodTorrentAction = MethodArgumentsValidator{Arguments: map[string]ArgumentValidator{
-	"ids": &Any{},
-}}
-
-func NewMethodTorrentSet(requiredLocPrefix string) *MethodArgumentsValidator {
-	return &MethodArgumentsValidator{Arguments: map[string]ArgumentValidator{
-		"bandwidthPriority":           &Any{},
-		"downloadLimit":               &Any{},
-		"downloadLimited":             &Any{},
-		"files-unwanted":              &Any{},
-		"files-wanted":                &Any{},
-		"group":                       &Any{},
-		"honorsSessionLimit: &Any{}s": &Any{},
-		"ids":                         &Any{},
-		"labels":                      &Any{},
-		"location":                    &PrefixedLocation{RequiredPrefix: requiredLocPrefix},
-		"peer-limit":                  &Any{},
-		"priority-high":               &Any{},
-		"priority-low":                &Any{},
-		"priority-normal":             &Any{},
-		"queuePosition":               &Any{},
-		"seedIdleLimit":               &Any{},
-		"seedIdleMode":                &Any{},
-		"seedRatioLimit":              &Any{},
-		"seedRatioMode":               &Any{},
-		"sequentialDownload":          &Any{},
-		"trackerList":                 &Any{},
-		"uploadLimit":                 &Any{},
-		"uploadLimited":               &Any{},
+// BoolValidator accepts boolean arguments.
+type BoolValidator struct{}
+
+func (v *BoolValidator) Validate(key string, value any) error {
+	if _, ok := value.(bool); !ok {
+		return fmt.Errorf("must be boolean, got %s", represent(value))
+	}
+
+	return nil
+}
+
+// StringValidator accepts string arguments, only the listed ones if OneOf is set.
+type StringValidator struct {
+	OneOf []string
+}
+
+func (v *StringValidator) Validate(key string, value any) error {
+	s, ok := value.(string)
+	if !ok {
+		return fmt.Errorf("must be string, got %s", represent(value))
+	}
+
+	if v.OneOf != nil && !slices.Contains(v.OneOf, s) {
+		return fmt.Errorf("must be one of %s, got %s", strings.Join(v.OneOf, ", "), represent(s))
+	}
+
+	return nil
+}
+
+// Ranges of the arguments Transmission accepts: bandwidth priority is low (-1), normal or high (1),
+// seed limit modes are global (0), single (1) or unlimited (2), alt speed times are minutes since midnight
+// and alt speed days is bitmask of the week days.
+var (
+	bandwidthPriority = &NumberRangeValidator{Min: -1, Max: 1}
+	limitMode         = &NumberRangeValidator{Min: 0, Max: 2}
+	minuteOfDay       = &NumberRangeValidator{Min: 0, Max: 24*60 - 1}
+)
+
+func NewMethodTorrentSet(requiredLocPrefix string) *TypedArgumentsValidator[TorrentSetArguments] {
+	return &TypedArgumentsValidator[TorrentSetArguments]{Fields: map[string]ArgumentValidator{
+		"bandwidthPriority": bandwidthPriority,
+		"downloadLimit":     AtLeast(0),
+		"ids":               &IdsValidator{},
+		"location":          &PrefixedLocation{RequiredPrefix: requiredLocPrefix},
+		"peer-limit":        AtLeast(0),
+		"queuePosition":     AtLeast(0),
+		"seedIdleLimit":     AtLeast(0),
+		"seedIdleMode":      limitMode,
+		"seedRatioLimit":    AtLeast(0),
+		"seedRatioMode":     limitMode,
+		"uploadLimit":       AtLeast(0),
 	}}
 }
 
+// PrefixedLocation accepts locations under RequiredPrefix, see InPrefix.
 type PrefixedLocation struct {
 	RequiredPrefix string
 }
 
 func (t *PrefixedLocation) Validate(key string, value any) error {
 	if loc, ok := value.(string); ok {
-		if !strings.HasPrefix(loc, t.RequiredPrefix) {
+		if !InPrefix(loc, t.RequiredPrefix) {
 			return ErrTorrentForbiddenLocation
 		}
 
@@ -198,116 +410,90 @@ func (t *PrefixedLocation) Validate(key string, value any) error {
 	return ErrTorrentLocationWrongType
 }
 
-var MethodTorrentGet = MethodArgumentsValidator{Arguments: map[string]ArgumentValidator{
-	"ids":    &Any{},
-	"fields": &Any{},
-	"format": &Any{},
-}}
+// InPrefix reports whether the location is the prefix directory or is under it, after removing "." segments
+// and duplicate slashes; trailing slashes of either do not matter, so "/downloads" is in prefix "/downloads/"
+// and "/downloadsevil" is not in prefix "/downloads". Locations with ".." segments are rejected even if they
+// would not leave the prefix, since the daemon resolves them following symbolic links. Backslashes and NUL
+// bytes are rejected as well.
+func InPrefix(loc, prefix string) bool {
+	if !strings.HasPrefix(loc, "/") || strings.ContainsAny(loc, "\\\x00") || slices.Contains(strings.Split(loc, "/"), "..") {
+		return false
+	}
+
+	dir := strings.TrimSuffix(path.Clean(prefix), "/")
+	loc = path.Clean(loc)
+	return loc == dir || strings.HasPrefix(loc, dir+"/")
+}
 
-func NewMethodTorrentAdd(requiredLocPrefix string) *MethodArgumentsValidator {
-	return &MethodArgumentsValidator{Arguments: map[string]ArgumentValidator{
-		"cookies":           &Any{},
+func NewMethodTorrentAdd(requiredLocPrefix string) *TypedArgumentsValidator[TorrentAddArguments] {
+	return &TypedArgumentsValidator[TorrentAddArguments]{Fields: map[string]ArgumentValidator{
+		"bandwidthPriority": bandwidthPriority,
 		"download-dir":      &PrefixedLocation{RequiredPrefix: requiredLocPrefix},
-		"filename":          &Any{},
-		"labels":            &Any{},
-		"metainfo":          &Any{},
-		"paused":            &Any{},
-		"peer-limit":        &Any{},
-		"bandwidthPriority": &Any{},
-		"files-wanted":      &Any{},
-		"files-unwanted":    &Any{},
-		"priority-high":     &Any{},
-		"priority-low":      &Any{},
-		"priority-normal":   &Any{},
+		"peer-limit":        AtLeast(0),
 	}}
 }
 
-var MethodTorrentRemove = MethodArgumentsValidator{Arguments: map[string]ArgumentValidator{
-	"ids":               &Any{},
-	"delete-local-data": &Any{},
-}}
-
-func NewMethodTorrentSetLocation(requiredLocPrefix string) *MethodArgumentsValidator {
-	return &MethodArgumentsValidator{Arguments: map[string]ArgumentValidator{
-		"ids":      &Any{},
+func NewMethodTorrentSetLocation(requiredLocPrefix string) *TypedArgumentsValidator[TorrentSetLocationArguments] {
+	return &TypedArgumentsValidator[TorrentSetLocationArguments]{Fields: map[string]ArgumentValidator{
+		"ids":      &IdsValidator{},
 		"location": &PrefixedLocation{RequiredPrefix: requiredLocPrefix},
-		"move":     &Any{},
+	}, Rules: []DependencyRule{
+		Requires("move", "location"),
 	}}
 }
 
-func NewMethodSessionSet(requiredLocPrefix string) *MethodArgumentsValidator {
-	return &MethodArgumentsValidator{Arguments: map[string]ArgumentValidator{
-		"alt-speed-down":             &Any{},
-		"alt-speed-enabled":          &Any{},
-		"alt-speed-time-begin":       &Any{},
-		"alt-speed-time-day":         &Any{},
-		"alt-speed-time-enabled":     &Any{},
-		"alt-speed-time-end":         &Any{},
-		"alt-speed-up":               &Any{},
-		"blocklist-enabled":          &Any{},
-		"blocklist-url":              &Any{},
-		"cache-size-mb":              &Any{},
-		"default-trackers":           &Any{},
-		"dht-enabled":                &Any{},
-		"download-dir":               &PrefixedLocation{RequiredPrefix: requiredLocPrefix},
-		"download-queue-enabled":     &Any{},
-		"download-queue-size":        &Any{},
-		"encryption":                 &Any{},
-		"idle-seeding-limit-enabled": &Any{},
-		"idle-seeding-limit":         &Any{},
-		//"incomplete-dir-enabled":               &Any{},
-		//"incomplete-dir":                       &Any{},
-		"lpd-enabled":            &Any{},
-		"peer-limit-global":      &Any{},
-		"peer-limit-per-torrent": &Any{},
-		//"peer-port-random-on-start":            &Any{},
-		//"peer-port":                            &Any{},
-		"pex-enabled":             &Any{},
-		"port-forwarding-enabled": &Any{},
-		"queue-stalled-enabled":   &Any{},
-		"queue-stalled-minutes":   &Any{},
-		"rename-partial-files":    &Any{},
-		//"script-torrent-added-enabled":         &Any{},
-		//"script-torrent-added-filename":        &Any{},
-		//"script-torrent-done-enabled":          &Any{},
-		//"script-torrent-done-filename":         &Any{},
-		//"script-torrent-done-seeding-enabled":  &Any{},
-		//"script-torrent-done-seeding-filename": &Any{},
-		"seed-queue-enabled":           &Any{},
-		"seed-queue-size":              &Any{},
-		"seedRatioLimit":               &Any{},
-		"seedRatioLimited":             &Any{},
-		"speed-limit-down-enabled":     &Any{},
-		"speed-limit-down":             &Any{},
-		"speed-limit-up-enabled":       &Any{},
-		"speed-limit-up":               &Any{},
-		"start-added-torrents":         &Any{},
-		"trash-original-torrent-files": &Any{},
-		"utp-enabled":                  &Any{},
-	}}
-}
+// RenameNameValidator checks the new name given to torrent-rename-path, which must be a single path component,
+// so that files cannot be moved out of the torrent directory.
+type RenameNameValidator struct{}
 
-var MethodSessionGet = MethodArgumentsValidator{Arguments: map[string]ArgumentValidator{
-	"fields": &Any{},
-}}
+func (v *RenameNameValidator) Validate(key string, value any) error {
+	s, ok := value.(string)
+	if !ok {
+		return fmt.Errorf("must be string, got %s", represent(value))
+	}
 
-var MethodPortTest = MethodArgumentsValidator{Arguments: map[string]ArgumentValidator{
-	"ipProtocol": &Any{},
-}}
+	switch {
+	case s == "":
+		return errors.New("must not be empty")
+	case strings.ContainsAny(s, "/\\\x00"):
+		return &valueError{value: s, err: errors.New("must not contain path separators")}
+	case s == "." || s == "..":
+		return &valueError{value: s, err: fmt.Errorf("must not be %s", represent(s))}
+	}
 
-var MethodFreeSpace = MethodArgumentsValidator{Arguments: map[string]ArgumentValidator{
-	"path": &Any{},
-}}
+	return nil
+}
 
-var MethodGroupSet = MethodArgumentsValidator{Arguments: map[string]ArgumentValidator{
-	"honorsSessionLimits":      &Any{},
-	"name":                     &Any{},
-	"speed-limit-down-enabled": &Any{},
-	"speed-limit-down":         &Any{},
-	"speed-limit-up-enabled":   &Any{},
-	"speed-limit-up":           &Any{},
-}}
+func NewMethodTorrentRenamePath() *TypedArgumentsValidator[TorrentRenamePathArguments] {
+	return &TypedArgumentsValidator[TorrentRenamePathArguments]{Fields: map[string]ArgumentValidator{
+		"ids":  &IdsValidator{Single: true},
+		"path": &StringValidator{},
+		"name": &RenameNameValidator{},
+	}}
+}
 
-var MethodGroupGet = MethodArgumentsValidator{Arguments: map[string]ArgumentValidator{
-	"group": &Any{},
-}}
+func NewMethodSessionSet(requiredLocPrefix string) *TypedArgumentsValidator[SessionSetArguments] {
+	return &TypedArgumentsValidator[SessionSetArguments]{Fields: map[string]ArgumentValidator{
+		"alt-speed-down":         AtLeast(0),
+		"alt-speed-time-begin":   minuteOfDay,
+		"alt-speed-time-day":     &NumberRangeValidator{Min: 0, Max: 127},
+		"alt-speed-time-end":     minuteOfDay,
+		"alt-speed-up":           AtLeast(0),
+		"cache-size-mb":          AtLeast(0),
+		"download-dir":           &PrefixedLocation{RequiredPrefix: requiredLocPrefix},
+		"download-queue-size":    AtLeast(0),
+		"encryption":             &StringValidator{OneOf: []string{"required", "preferred", "tolerated"}},
+		"idle-seeding-limit":     AtLeast(0),
+		"peer-limit-global":      AtLeast(0),
+		"peer-limit-per-torrent": AtLeast(0),
+		"queue-stalled-minutes":  AtLeast(0),
+		"seed-queue-size":        AtLeast(0),
+		"seedRatioLimit":         AtLeast(0),
+		"speed-limit-down":       AtLeast(0),
+		"speed-limit-up":         AtLeast(0),
+	}, Rules: []DependencyRule{
+		RequiredWhen("alt-speed-time-enabled", true, "alt-speed-time-begin"),
+		RequiredWhen("alt-speed-time-enabled", true, "alt-speed-time-end"),
+		Requires("speed-limit-up", "speed-limit-up-enabled"),
+	}}
+}
@@ -0,0 +1,362 @@
+package transmission
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	"transmission-proxy/internal/jrpc"
+	"transmission-proxy/internal/logger"
+)
+
+func parseRequest(t testing.TB, body string) *jrpc.Request {
+	var req jrpc.Request
+	if err := json.Unmarshal([]byte(body), &req); err != nil {
+		t.Fatalf("decode %s: %v", body, err)
+	}
+
+	return &req
+}
+
+func TestValidateKeepsOriginal(t *testing.T) {
+	v := DefaultMethodsValidator("/downloads/")
+	if hd, ok := v.Methods["torrent-add"].(HasDefaults); ok {
+		v.Methods["torrent-add"] = hd.WithDefault("download-dir", "/downloads/")
+	}
+
+	cases := []struct {
+		name, body string
+		// removed and added are the arguments the sanitized request should lack or have in addition
+		removed []string
+		added   map[string]any
+	}{
+		{name: "spec method", body: `{"method":"torrent-get","arguments":{"fields":["id"],"ids":[1],"bogus":1}}`,
+			removed: []string{"bogus"}},
+		{name: "typed method", body: `{"method":"torrent-set-location","arguments":{"ids":1,"location":"/downloads/x","move":true,"script":"rm"}}`,
+			removed: []string{"script"}},
+		{name: "default added", body: `{"method":"torrent-add","arguments":{"filename":"magnet:?xt=urn:btih:x","extra":{"a":[1]}}}`,
+			removed: []string{"extra"}, added: map[string]any{"download-dir": "/downloads/"}},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := parseRequest(t, tc.body)
+			orig := parseRequest(t, tc.body)
+
+			sanitized, err := v.Validate(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(req.Arguments, orig.Arguments) {
+				t.Fatalf("original arguments modified: %v", req.Arguments)
+			}
+			if sanitized == req {
+				t.Fatal("changed request not copied")
+			}
+
+			want := map[string]any{}
+			for k, val := range orig.Arguments {
+				want[k] = val
+			}
+			for _, k := range tc.removed {
+				delete(want, k)
+			}
+			for k, val := range tc.added {
+				want[k] = val
+			}
+			if !reflect.DeepEqual(sanitized.Arguments, want) {
+				t.Errorf("got sanitized arguments %v, want %v", sanitized.Arguments, want)
+			}
+			if sanitized.Method != req.Method || string(sanitized.Raw) != string(req.Raw) {
+				t.Errorf("got sanitized request %+v", sanitized)
+			}
+
+			// validators are safe to run again, e.g. in shadow mode
+			again, err := v.Validate(req)
+			if err != nil || !reflect.DeepEqual(again.Arguments, sanitized.Arguments) {
+				t.Errorf("second run: got %v, %v", again.Arguments, err)
+			}
+		})
+	}
+}
+
+func TestValidateRejectedKeepsOriginal(t *testing.T) {
+	body := `{"method":"torrent-set-location","arguments":{"ids":1,"location":"/etc","script":"rm"}}`
+	req := parseRequest(t, body)
+
+	if _, err := DefaultMethodsValidator("/downloads/").Validate(req); RejectReason(err) != RejectLocation {
+		t.Fatalf("got error %v", err)
+	}
+	if !reflect.DeepEqual(req.Arguments, parseRequest(t, body).Arguments) {
+		t.Errorf("original arguments modified: %v", req.Arguments)
+	}
+}
+
+func TestBoolValidator(t *testing.T) {
+	for _, value := range []any{true, false} {
+		if err := (&BoolValidator{}).Validate("paused", value); err != nil {
+			t.Errorf("%v: got error %v", value, err)
+		}
+	}
+	for _, value := range []any{42, float64(1), "true", nil} {
+		if err := (&BoolValidator{}).Validate("paused", value); err == nil || !strings.Contains(err.Error(), "must be boolean") {
+			t.Errorf("%#v: got error %v", value, err)
+		}
+	}
+}
+
+func TestStringValidator(t *testing.T) {
+	oneOf := &StringValidator{OneOf: []string{"required", "preferred"}}
+	cases := []struct {
+		v       *StringValidator
+		value   any
+		errText string
+	}{
+		{v: &StringValidator{}, value: ""},
+		{v: &StringValidator{}, value: "anything"},
+		{v: &StringValidator{}, value: 1, errText: "must be string, got 1"},
+		{v: oneOf, value: "preferred"},
+		{v: oneOf, value: "none", errText: `must be one of required, preferred, got "none"`},
+		{v: oneOf, value: true, errText: "must be string, got true"},
+	}
+
+	for _, tc := range cases {
+		err := tc.v.Validate("v", tc.value)
+		if (err == nil) != (tc.errText == "") || err != nil && !strings.Contains(err.Error(), tc.errText) {
+			t.Errorf("%v %#v: got error %v, want %q", tc.v.OneOf, tc.value, err, tc.errText)
+		}
+	}
+}
+
+func TestTypedArgumentsWrongType(t *testing.T) {
+	v := DefaultMethodsValidator("/downloads/")
+
+	cases := []struct {
+		body  string
+		field string
+	}{
+		{body: `{"method":"torrent-set","arguments":{"ids":1,"downloadLimit":"banana"}}`, field: "downloadLimit"},
+		{body: `{"method":"torrent-set","arguments":{"ids":1,"uploadLimit":-1}}`, field: "uploadLimit"},
+		{body: `{"method":"torrent-set","arguments":{"ids":1,"bandwidthPriority":2}}`, field: "bandwidthPriority"},
+		{body: `{"method":"torrent-set","arguments":{"ids":1,"seedRatioMode":3.0}}`, field: "seedRatioMode"},
+		{body: `{"method":"torrent-add","arguments":{"filename":"magnet:?x","paused":42}}`, field: "paused"},
+		{body: `{"method":"torrent-add","arguments":{"filename":"magnet:?x","peer-limit":-3}}`, field: "peer-limit"},
+		{body: `{"method":"session-set","arguments":{"encryption":"none"}}`, field: "encryption"},
+		{body: `{"method":"session-set","arguments":{"speed-limit-down":"fast"}}`, field: "speed-limit-down"},
+		{body: `{"method":"session-set","arguments":{"alt-speed-time-begin":1440}}`, field: "alt-speed-time-begin"},
+	}
+
+	for _, tc := range cases {
+		_, err := v.Validate(parseRequest(t, tc.body))
+		var ba IsBadArgument
+		if !errors.As(err, &ba) || ba.GetBadArgument() != tc.field || RejectReason(err) != RejectBadArgument {
+			t.Errorf("%s: got error %v, want bad argument %s", tc.body, err, tc.field)
+		}
+	}
+
+	// the same arguments of the right types and ranges pass
+	for _, body := range []string{
+		`{"method":"torrent-set","arguments":{"ids":1,"downloadLimit":100,"uploadLimit":0,"bandwidthPriority":-1,"seedRatioMode":2}}`,
+		`{"method":"torrent-add","arguments":{"filename":"magnet:?x","paused":true,"peer-limit":50}}`,
+		`{"method":"session-set","arguments":{"encryption":"required","speed-limit-down":1.5e3,"alt-speed-time-begin":1439}}`,
+	} {
+		if _, err := v.Validate(parseRequest(t, body)); err != nil {
+			t.Errorf("%s: got error %v", body, err)
+		}
+	}
+}
+
+func TestPrefixedLocation(t *testing.T) {
+	v := &PrefixedLocation{RequiredPrefix: "/downloads/"}
+	cases := []struct {
+		value any
+		err   error
+	}{
+		{value: "/downloads/"},
+		{value: "/downloads/tv"},
+		{value: "/etc", err: ErrTorrentForbiddenLocation},
+		{value: "/downloads/../etc", err: ErrTorrentForbiddenLocation},
+		{value: 1, err: ErrTorrentLocationWrongType},
+	}
+
+	for _, tc := range cases {
+		if err := v.Validate("location", tc.value); err != tc.err {
+			t.Errorf("%#v: got error %v, want %v", tc.value, err, tc.err)
+		}
+	}
+
+	// locations are rejected for their reason, not as wrongly typed strings
+	req := parseRequest(t, `{"method":"session-set","arguments":{"download-dir":"/etc"}}`)
+	if _, err := DefaultMethodsValidator("/downloads/").Validate(req); RejectReason(err) != RejectLocation {
+		t.Errorf("session-set: got error %v", err)
+	}
+}
+
+func TestDenyMethods(t *testing.T) {
+	v := DefaultMethodsValidator("/downloads/")
+	v.DenyMethods("session-set", "blocklist-update")
+
+	cases := []struct {
+		body, reason string
+	}{
+		{body: `{"method":"session-set","arguments":{"speed-limit-down":10}}`, reason: RejectMethodDenied},
+		{body: `{"method":"blocklist-update"}`, reason: RejectMethodDenied},
+		{body: `{"method":"torrent-explode"}`, reason: RejectUnknownMethod},
+		{body: `{"method":"session-get"}`},
+		{body: `{"method":"torrent-remove","arguments":{"ids":[1]}}`},
+	}
+	for _, tc := range cases {
+		req := parseRequest(t, tc.body)
+		_, err := v.Validate(req)
+		if tc.reason == "" {
+			if err != nil {
+				t.Errorf("%s: got error %v", req.Method, err)
+			}
+			continue
+		}
+
+		if RejectReason(err) != tc.reason {
+			t.Errorf("%s: got error %v, want %s", req.Method, err, tc.reason)
+		}
+		if tc.reason == RejectMethodDenied {
+			var la logger.HasLoggableAttrs
+			if !errors.Is(err, ErrMethodDenied) || errors.Is(err, ErrUnknownMethod) || !errors.As(err, &la) ||
+				!strings.Contains(fmt.Sprint(la.GetLoggableAttrs()), "method="+req.Method) {
+				t.Errorf("%s: got error %v", req.Method, err)
+			}
+		}
+	}
+}
+
+func TestAllowOnlyMethods(t *testing.T) {
+	v := DefaultMethodsValidator("/downloads/")
+	v.AllowOnlyMethods(ReadOnlyMethods...)
+
+	for method := range DefaultMethodsValidator("/downloads/").Methods {
+		_, err := v.Validate(parseRequest(t, `{"method":"`+method+`"}`))
+		allowed := slices.Contains(ReadOnlyMethods, method)
+		if allowed && errors.Is(err, ErrMethodDenied) || !allowed && !errors.Is(err, ErrMethodDenied) {
+			t.Errorf("%s: got error %v, want allowed %v", method, err, allowed)
+		}
+	}
+
+	// the allowed methods still validate their arguments
+	if _, err := v.Validate(parseRequest(t, `{"method":"torrent-get","arguments":{"ids":[{}],"fields":["id"]}}`)); RejectReason(err) != RejectBadArgument {
+		t.Errorf("torrent-get: got error %v", err)
+	}
+}
+
+func TestInPrefix(t *testing.T) {
+	cases := []struct {
+		loc string
+		// in tells whether loc is in prefix "/downloads/" and in the same prefix without the trailing slash
+		in, inNoSlash bool
+	}{
+		{loc: "/downloads/", in: true, inNoSlash: true},
+		{loc: "/downloads", in: true, inNoSlash: true},
+		{loc: "/downloads/x", in: true, inNoSlash: true},
+		{loc: "/downloads/x/", in: true, inNoSlash: true},
+		{loc: "/downloads/./sub", in: true, inNoSlash: true},
+		{loc: "/downloads//x", in: true, inNoSlash: true},
+		{loc: "//downloads///x//", in: true, inNoSlash: true},
+		{loc: "/downloads/.", in: true, inNoSlash: true},
+		{loc: "/downloads/x..y", in: true, inNoSlash: true},
+		{loc: "/downloads/...", in: true, inNoSlash: true},
+		// ".." segments are rejected even if they stay within the prefix
+		{loc: "/downloads/../downloads/x"},
+		{loc: "/downloads/x/.."},
+		{loc: "/downloads/.."},
+		{loc: "/downloads/../etc"},
+		{loc: "/downloadsevil"},
+		{loc: "/downloadsevil/x"},
+		{loc: "/download"},
+		{loc: "/"},
+		{loc: "/etc/downloads/x"},
+		{loc: "downloads/x"},
+		{loc: ""},
+		{loc: `/downloads/x\..\..\etc`},
+		{loc: "/downloads/x\x00/etc"},
+	}
+
+	for _, tc := range cases {
+		if got := InPrefix(tc.loc, "/downloads/"); got != tc.in {
+			t.Errorf("InPrefix(%q, \"/downloads/\") = %v, want %v", tc.loc, got, tc.in)
+		}
+		if got := InPrefix(tc.loc, "/downloads"); got != tc.inNoSlash {
+			t.Errorf("InPrefix(%q, \"/downloads\") = %v, want %v", tc.loc, got, tc.inNoSlash)
+		}
+	}
+
+	// the root prefix allows any absolute location without ".." segments
+	for loc, want := range map[string]bool{"/": true, "/etc": true, "//x/./y": true, "/x/../y": false, "x": false} {
+		if got := InPrefix(loc, "/"); got != want {
+			t.Errorf("InPrefix(%q, \"/\") = %v, want %v", loc, got, want)
+		}
+	}
+}
+
+func TestRenameNameValidator(t *testing.T) {
+	cases := []struct {
+		value   any
+		errText string
+	}{
+		{value: "episode 1.mkv"},
+		{value: "Season 1"},
+		{value: ".hidden"},
+		{value: "..."},
+		{value: "x..y"},
+		{value: "", errText: "must not be empty"},
+		{value: ".", errText: `must not be "."`},
+		{value: "..", errText: `must not be ".."`},
+		{value: "../etc", errText: "must not contain path separators"},
+		{value: "sub/file", errText: "must not contain path separators"},
+		{value: "/etc/passwd", errText: "must not contain path separators"},
+		{value: `..\..\etc`, errText: "must not contain path separators"},
+		{value: "file\x00.mkv", errText: "must not contain path separators"},
+		{value: 1, errText: "must be string, got 1"},
+	}
+
+	v := &RenameNameValidator{}
+	for _, tc := range cases {
+		err := v.Validate("name", tc.value)
+		if (err == nil) != (tc.errText == "") || err != nil && !strings.Contains(err.Error(), tc.errText) {
+			t.Errorf("%#v: got error %v, want %q", tc.value, err, tc.errText)
+		}
+	}
+}
+
+func TestTorrentRenamePath(t *testing.T) {
+	v := DefaultMethodsValidator("/downloads/")
+
+	for _, args := range []string{
+		`{"ids":1,"path":"debian/debian.iso","name":"debian-12.iso"}`,
+		`{"ids":["a94a8fe5ccb19ba61c4c0873d391e987982fbbd3"],"path":"Season 1","name":"S01"}`,
+	} {
+		req := parseRequest(t, `{"method":"torrent-rename-path","arguments":`+args+`}`)
+		if sanitized, err := v.Validate(req); err != nil || sanitized != req {
+			t.Errorf("%s: got error %v", args, err)
+		}
+	}
+
+	cases := []struct {
+		args, field string
+	}{
+		{args: `{"ids":1,"path":"debian.iso","name":"../../etc/cron.d/x"}`, field: "name"},
+		{args: `{"ids":1,"path":"debian.iso","name":".."}`, field: "name"},
+		{args: `{"ids":[1,2],"path":"debian.iso","name":"x.iso"}`, field: "ids"},
+		{args: `{"ids":[],"path":"debian.iso","name":"x.iso"}`, field: "ids"},
+		{args: `{"ids":"recently-active","path":"debian.iso","name":"x.iso"}`, field: "ids"},
+		{args: `{"ids":1,"path":["debian.iso"],"name":"x.iso"}`, field: "path"},
+	}
+	for _, tc := range cases {
+		_, err := v.Validate(parseRequest(t, `{"method":"torrent-rename-path","arguments":`+tc.args+`}`))
+		var ba IsBadArgument
+		if !errors.As(err, &ba) || ba.GetBadArgument() != tc.field || RejectReason(err) != RejectBadArgument {
+			t.Errorf("%s: got error %v, want bad argument %s", tc.args, err, tc.field)
+		}
+	}
+}
@@ -0,0 +1,221 @@
+// Package transmissiontest provides the fake Transmission daemon the tests of the proxy forward requests to.
+package transmissiontest
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"transmission-proxy/internal/jrpc"
+)
+
+// DefaultRPCPath is the RPC endpoint of Transmission.
+const DefaultRPCPath = "/transmission/rpc"
+
+// sessionIDHeader is the header Transmission answers 409 with. It is not taken from package upstream,
+// whose own tests use the Server.
+const sessionIDHeader = "X-Transmission-Session-Id"
+
+// Request is a request the Server got.
+type Request struct {
+	Method     string
+	RequestURI string
+	Proto      string
+	RemoteAddr string
+	Header     http.Header
+	Body       string
+}
+
+// Server is the fake Transmission. RPC requests are answered with success and the tag of the request,
+// other paths with empty 200 response, unless the handlers are set. Every request is recorded.
+// Zero value is ready to use, the fields must not be changed after the first request.
+type Server struct {
+	// RPCPath is the path of RPC requests, DefaultRPCPath if empty.
+	RPCPath string
+	// SessionID, if set, is required in RPC requests, which are answered with 409 carrying the id otherwise.
+	SessionID string
+	// Hold, if set, delays the answers to all requests until a value is received from it, or it is closed.
+	Hold <-chan struct{}
+	// RPC, if set, answers RPC requests passing the session check.
+	RPC func(w http.ResponseWriter, r *http.Request, req *jrpc.Request)
+	// Web, if set, answers requests of other paths.
+	Web http.HandlerFunc
+
+	mu        sync.Mutex
+	requests  []*Request
+	conflicts int
+	canceled  chan string
+	once      sync.Once
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// the server notices the client went away only once the body is read
+	var body []byte
+	if r.Body != nil {
+		body, _ = io.ReadAll(r.Body)
+	}
+	s.mu.Lock()
+	s.requests = append(s.requests, &Request{
+		Method:     r.Method,
+		RequestURI: r.RequestURI,
+		Proto:      r.Proto,
+		RemoteAddr: r.RemoteAddr,
+		Header:     r.Header.Clone(),
+		Body:       string(body),
+	})
+	s.mu.Unlock()
+
+	if s.Hold != nil {
+		select {
+		case <-s.Hold:
+		case <-r.Context().Done():
+			s.canceledPaths() <- r.URL.Path
+			return
+		}
+	}
+
+	rpcPath := s.RPCPath
+	if rpcPath == "" {
+		rpcPath = DefaultRPCPath
+	}
+	if r.URL.Path != rpcPath {
+		if s.Web != nil {
+			s.Web(w, r)
+		}
+		return
+	}
+
+	if s.SessionID != "" && r.Header.Get(sessionIDHeader) != s.SessionID {
+		s.mu.Lock()
+		s.conflicts++
+		s.mu.Unlock()
+
+		w.Header().Set(sessionIDHeader, s.SessionID)
+		w.WriteHeader(http.StatusConflict)
+		return
+	}
+
+	var req jrpc.Request
+	if err := json.Unmarshal(body, &req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if s.RPC != nil {
+		s.RPC(w, r, &req)
+		return
+	}
+
+	Reply(w, http.StatusOK, &req, `{}`)
+}
+
+// RoundTrip serves the request in-process, as the upstream of clients using the Server as their transport.
+func (s *Server) RoundTrip(r *http.Request) (*http.Response, error) {
+	if r.RequestURI == "" {
+		r.RequestURI = r.URL.RequestURI()
+	}
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+
+	return w.Result(), nil
+}
+
+// Start serves the fake at a local address until the end of the test and returns the URL of the daemon.
+func (s *Server) Start(t testing.TB) *url.URL {
+	srv := httptest.NewServer(s)
+	t.Cleanup(srv.Close)
+
+	u, _ := url.Parse(srv.URL + "/")
+	return u
+}
+
+// StartTLS serves the fake with HTTP/2 enabled over TLS until the end of the test.
+func (s *Server) StartTLS(t testing.TB) *httptest.Server {
+	srv := httptest.NewUnstartedServer(s)
+	srv.EnableHTTP2 = true
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+
+	return srv
+}
+
+// Requests returns the requests received so far.
+func (s *Server) Requests() []*Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]*Request(nil), s.requests...)
+}
+
+// Bodies returns the bodies of the RPC requests which passed the session check.
+func (s *Server) Bodies() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rpcPath := s.RPCPath
+	if rpcPath == "" {
+		rpcPath = DefaultRPCPath
+	}
+
+	var res []string
+	for _, r := range s.requests {
+		u, _ := url.ParseRequestURI(r.RequestURI)
+		if u != nil && u.Path == rpcPath && (s.SessionID == "" || r.Header.Get(sessionIDHeader) == s.SessionID) {
+			res = append(res, r.Body)
+		}
+	}
+
+	return res
+}
+
+// Conflicts returns the number of RPC requests answered with 409 for the missing session id.
+func (s *Server) Conflicts() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.conflicts
+}
+
+// Canceled reports paths of the held requests canceled by their clients.
+func (s *Server) Canceled() <-chan string {
+	return s.canceledPaths()
+}
+
+func (s *Server) canceledPaths() chan string {
+	s.once.Do(func() { s.canceled = make(chan string, 100) })
+	return s.canceled
+}
+
+// WaitRequests waits until the Server has received n requests in total.
+func (s *Server) WaitRequests(t testing.TB, n int) {
+	t.Helper()
+
+	for deadline := time.Now().Add(5 * time.Second); len(s.Requests()) < n; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("got %d requests, want %d", len(s.Requests()), n)
+		}
+	}
+}
+
+// Reply answers the RPC request with the status and the arguments, echoing the tag of the request as Transmission
+// does. Result is success for status 200.
+func Reply(w http.ResponseWriter, status int, req *jrpc.Request, arguments string) {
+	result := "success"
+	if status != http.StatusOK {
+		result = http.StatusText(status)
+	}
+
+	body := `{"arguments":` + arguments + `,"result":` + strconv.Quote(result)
+	if req.HasTag {
+		body += `,"tag":` + strconv.Itoa(req.Tag)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, _ = w.Write([]byte(body + "}"))
+}
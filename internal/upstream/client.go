@@ -0,0 +1,184 @@
+package upstream
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+
+	"transmission-proxy/internal/jrpc"
+)
+
+// SessionIDHeader is the header Transmission uses for CSRF protection: requests without the current
+// session id are answered with 409 carrying the id to retry with.
+const SessionIDHeader = "X-Transmission-Session-Id"
+
+// maxResponseSize limits responses read by the Client.
+const maxResponseSize = 64 << 20
+
+// Client makes RPC calls to Transmission on behalf of the proxy itself, e.g. to check which torrents
+// a request refers to.
+type Client struct {
+	// URL of the RPC endpoint.
+	URL  string
+	HTTP *http.Client
+	// Username and Password, if set, are sent instead of the credentials of the client request.
+	Username, Password string
+	// Session is the session id shared with others talking to the same daemon, if set.
+	Session *Session
+	// Pool, if set, lists the hosts the requests are sent to, with the path of URL. Sessions of the hosts
+	// are used instead of Session.
+	Pool *Pool
+
+	own Session
+}
+
+// Session holds the current Transmission session id.
+type Session struct {
+	mu sync.Mutex
+	id string
+}
+
+// ID returns the session id last seen, or empty string.
+func (s *Session) ID() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.id
+}
+
+// Set remembers the session id Transmission answered 409 with.
+func (s *Session) Set(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.id = id
+}
+
+func (c *Client) session() *Session {
+	if c.Session != nil {
+		return c.Session
+	}
+
+	return &c.own
+}
+
+// Call sends the RPC request. Unless the client has credentials of its own, Authorization header (if any)
+// is taken from header, normally the headers of the client request being handled. Transmission session id is negotiated as needed.
+// With Pool the hosts of the pool are tried in order until one of them answers.
+func (c *Client) Call(ctx context.Context, header http.Header, req *jrpc.Request) (*jrpc.Response, error) {
+	bs, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("serialize: %w", err)
+	}
+
+	if c.Pool == nil {
+		res, err, _ := c.call(ctx, header, req.Method, bs, c.URL, c.session())
+		return res, err
+	}
+
+	u, err := url.Parse(c.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	hosts := c.Pool.Order(false)
+	for i, h := range hosts {
+		sess := h.Session
+		if sess == nil {
+			sess = c.session()
+		}
+
+		res, err, failed := c.call(ctx, header, req.Method, bs, h.URL.JoinPath(u.Path).String(), sess)
+		if !failed {
+			c.Pool.Succeeded(ctx, h)
+			return res, err
+		}
+		if ctx.Err() != nil || i == len(hosts)-1 {
+			c.Pool.Failed(ctx, h, err.Error(), nil)
+			return nil, err
+		}
+		c.Pool.Failed(ctx, h, err.Error(), hosts[i+1])
+	}
+
+	return nil, errors.New("no upstream hosts")
+}
+
+// call sends the request to the url, reporting whether the upstream failed, as opposed to answering with error.
+func (c *Client) call(ctx context.Context, header http.Header, method string, bs []byte, target string, sess *Session) (_ *jrpc.Response, _ error, failed bool) {
+	for attempt := 0; ; attempt++ {
+		hr, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(bs))
+		if err != nil {
+			return nil, err, false
+		}
+
+		hr.Header.Set("Content-Type", "application/json")
+		if c.Username != "" {
+			hr.SetBasicAuth(c.Username, c.Password)
+		} else if auth := header.Get("Authorization"); auth != "" {
+			hr.Header.Set("Authorization", auth)
+		}
+
+		sid := sess.ID()
+		if sid == "" {
+			sid = header.Get(SessionIDHeader)
+		}
+		if sid != "" {
+			hr.Header.Set(SessionIDHeader, sid)
+		}
+
+		resp, err := c.HTTP.Do(hr)
+		if err != nil {
+			return nil, err, true
+		}
+
+		body, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+		_ = resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("read response: %w", err), true
+		}
+
+		if resp.StatusCode == http.StatusConflict && attempt == 0 && resp.Header.Get(SessionIDHeader) != "" {
+			sess.Set(resp.Header.Get(SessionIDHeader))
+			continue
+		}
+
+		if resp.StatusCode != http.StatusOK {
+			return nil, &StatusError{Status: resp.StatusCode}, IsFailoverStatus(resp.StatusCode)
+		}
+
+		res, err := jrpc.ParseResponse(body)
+		if err != nil {
+			return nil, err, false
+		}
+		if res.Result != jrpc.ResultSuccess {
+			return nil, &ResultError{Method: method, Result: res.Result}, false
+		}
+
+		return res, nil, false
+	}
+}
+
+// StatusError is returned by Client when the upstream answers with unexpected HTTP status.
+type StatusError struct {
+	Status int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("upstream answered with status %d", e.Status)
+}
+
+// ResultError is returned by Client when Transmission answers with result other than success.
+type ResultError struct {
+	Method string
+	Result string
+}
+
+func (e *ResultError) Error() string {
+	return fmt.Sprintf("%s failed: %s", e.Method, e.Result)
+}
@@ -0,0 +1,53 @@
+package upstream
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"transmission-proxy/internal/jrpc"
+	"transmission-proxy/internal/transmissiontest"
+)
+
+func TestClientSession(t *testing.T) {
+	up := &transmissiontest.Server{SessionID: "sid"}
+	u := up.Start(t).JoinPath(transmissiontest.DefaultRPCPath).String()
+	sess := &Session{}
+
+	// the clients sharing the session negotiate the id once
+	for _, c := range []*Client{{URL: u, HTTP: http.DefaultClient, Session: sess}, {URL: u, HTTP: http.DefaultClient, Session: sess}} {
+		for i := 0; i < 2; i++ {
+			if _, err := c.Call(context.Background(), nil, &jrpc.Request{Method: "session-get"}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if n := up.Conflicts(); n != 1 || len(up.Bodies()) != 4 || sess.ID() != "sid" {
+		t.Errorf("got %d conflicts, %d requests served, session id %q", n, len(up.Bodies()), sess.ID())
+	}
+
+	// the id of the client request is used until the daemon answers with one
+	c := &Client{URL: u, HTTP: http.DefaultClient}
+	if _, err := c.Call(context.Background(), http.Header{SessionIDHeader: {"sid"}}, &jrpc.Request{Method: "session-get"}); err != nil {
+		t.Fatal(err)
+	}
+	if n := up.Conflicts(); n != 1 {
+		t.Errorf("got %d conflicts, want the id of the request used", n)
+	}
+}
+
+func TestClientSessionConflict(t *testing.T) {
+	up := &transmissiontest.Server{RPC: func(w http.ResponseWriter, _ *http.Request, _ *jrpc.Request) {
+		w.Header().Set(SessionIDHeader, "sid")
+		w.WriteHeader(http.StatusConflict)
+	}}
+	c := &Client{URL: up.Start(t).JoinPath(transmissiontest.DefaultRPCPath).String(), HTTP: http.DefaultClient}
+
+	// the daemon answering 409 to the retry is an error, not retried again
+	_, err := c.Call(context.Background(), nil, &jrpc.Request{Method: "session-get"})
+	var se *StatusError
+	if !errors.As(err, &se) || se.Status != http.StatusConflict || len(up.Requests()) != 2 {
+		t.Errorf("got error %v after %d requests", err, len(up.Requests()))
+	}
+}
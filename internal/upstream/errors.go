@@ -0,0 +1,74 @@
+package upstream
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"net/http"
+	"syscall"
+)
+
+// Error classes used to label upstream failures in logs and statistics.
+const (
+	ClassTimeout           = "timeout"
+	ClassCanceled          = "canceled"
+	ClassConnectionRefused = "connection_refused"
+	ClassConnectionReset   = "connection_reset"
+	ClassDNS               = "dns"
+	ClassTLS               = "tls"
+	ClassStatus5xx         = "status_5xx"
+	ClassOther             = "other"
+)
+
+// Classify maps an error returned by the upstream round trip to one of the error classes.
+func Classify(err error) string {
+	if err == nil {
+		return ""
+	}
+
+	if errors.Is(err, context.Canceled) {
+		return ClassCanceled
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return ClassTimeout
+	}
+
+	var ne net.Error
+	if errors.As(err, &ne) && ne.Timeout() {
+		return ClassTimeout
+	}
+
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return ClassDNS
+	}
+
+	if errors.Is(err, syscall.ECONNREFUSED) {
+		return ClassConnectionRefused
+	}
+	if errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE) {
+		return ClassConnectionReset
+	}
+
+	var certErr *tls.CertificateVerificationError
+	var recordErr tls.RecordHeaderError
+	var unknownAuthErr x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	if errors.As(err, &certErr) || errors.As(err, &recordErr) ||
+		errors.As(err, &unknownAuthErr) || errors.As(err, &hostnameErr) {
+		return ClassTLS
+	}
+
+	return ClassOther
+}
+
+// ClassifyStatus returns error class for upstream response status, or empty string if status is not an error.
+func ClassifyStatus(status int) string {
+	if status >= http.StatusInternalServerError {
+		return ClassStatus5xx
+	}
+
+	return ""
+}
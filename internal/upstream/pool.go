@@ -0,0 +1,147 @@
+package upstream
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"transmission-proxy/internal/logger"
+)
+
+// Pool is the set of interchangeable Transmission hosts, e.g. primary and standby. Hosts failing
+// are skipped for Backoff, unless all of them are failing.
+type Pool struct {
+	Hosts []*Host
+	// Backoff is how long the host is considered unhealthy after a failure.
+	Backoff time.Duration
+
+	next atomic.Uint64
+}
+
+// Host is the Transmission host of the Pool.
+type Host struct {
+	// URL of the host without path.
+	URL *url.URL
+	// Session is the session id of the host, nil if the clients negotiate it themselves.
+	Session *Session
+
+	mu             sync.Mutex
+	unhealthyUntil time.Time
+	lastError      string
+	failures       int
+}
+
+// HostStatus is the health of the Host as reported by Pool.Status.
+type HostStatus struct {
+	URL            string     `json:"url"`
+	Healthy        bool       `json:"healthy"`
+	UnhealthyUntil *time.Time `json:"unhealthy_until,omitempty"`
+	LastError      string     `json:"last_error,omitempty"`
+	Failures       int        `json:"failures"`
+}
+
+// NewPool returns the pool of the hosts, each keeping session id of its own if withSessions is set.
+func NewPool(urls []*url.URL, backoff time.Duration, withSessions bool) *Pool {
+	p := &Pool{Backoff: backoff}
+	for _, u := range urls {
+		h := &Host{URL: u}
+		if withSessions {
+			h.Session = &Session{}
+		}
+		p.Hosts = append(p.Hosts, h)
+	}
+
+	return p
+}
+
+// Order returns the hosts in the order they should be tried: healthy hosts in the order of the pool,
+// or starting with the next one in turn if rotate is set, followed by the unhealthy ones.
+func (p *Pool) Order(rotate bool) []*Host {
+	start := 0
+	if rotate && len(p.Hosts) > 1 {
+		start = int(p.next.Add(1) % uint64(len(p.Hosts)))
+	}
+
+	now := time.Now()
+	res := make([]*Host, 0, len(p.Hosts))
+	var unhealthy []*Host
+	for i := range p.Hosts {
+		h := p.Hosts[(start+i)%len(p.Hosts)]
+		if h.Healthy(now) {
+			res = append(res, h)
+		} else {
+			unhealthy = append(unhealthy, h)
+		}
+	}
+
+	return append(res, unhealthy...)
+}
+
+// Status returns the health of the hosts.
+func (p *Pool) Status() []HostStatus {
+	now := time.Now()
+	res := make([]HostStatus, 0, len(p.Hosts))
+	for _, h := range p.Hosts {
+		h.mu.Lock()
+		s := HostStatus{URL: h.URL.String(), Healthy: !now.Before(h.unhealthyUntil), LastError: h.lastError, Failures: h.failures}
+		if !s.Healthy {
+			until := h.unhealthyUntil
+			s.UnhealthyUntil = &until
+		}
+		h.mu.Unlock()
+		res = append(res, s)
+	}
+
+	return res
+}
+
+// Failed marks the host unhealthy for the backoff of the pool and logs failing over to next. If next is nil,
+// e.g. when no host is left or the request cannot be resent, it logs that the request is not failed over,
+// unless the pool has the host only.
+func (p *Pool) Failed(ctx context.Context, h *Host, reason string, next *Host) {
+	h.mu.Lock()
+	h.unhealthyUntil = time.Now().Add(p.Backoff)
+	h.lastError = reason
+	h.failures++
+	h.mu.Unlock()
+
+	if next == nil {
+		if len(p.Hosts) > 1 {
+			slog.WarnContext(ctx, "upstream "+h.URL.Host+" failed ("+reason+"), not failing over",
+				logger.HTTPFailedUpstream(h.URL.Host))
+		}
+		return
+	}
+
+	slog.WarnContext(ctx, "upstream "+h.URL.Host+" failed ("+reason+"), failing over to "+next.URL.Host,
+		logger.HTTPUpstream(next.URL.Host), logger.HTTPFailedUpstream(h.URL.Host))
+}
+
+// Succeeded marks the host healthy again, logging the recovery if it was in backoff.
+func (p *Pool) Succeeded(ctx context.Context, h *Host) {
+	h.mu.Lock()
+	recovered := !h.unhealthyUntil.IsZero()
+	h.unhealthyUntil = time.Time{}
+	h.mu.Unlock()
+
+	if recovered && len(p.Hosts) > 1 {
+		slog.InfoContext(ctx, "upstream "+h.URL.Host+" recovered", logger.HTTPUpstream(h.URL.Host))
+	}
+}
+
+// Healthy reports whether the host is not in backoff at the time.
+func (h *Host) Healthy(now time.Time) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return !now.Before(h.unhealthyUntil)
+}
+
+// IsFailoverStatus reports whether the upstream answering with the status should be skipped for another one.
+func IsFailoverStatus(status int) bool {
+	return status == http.StatusBadGateway || status == http.StatusServiceUnavailable
+}
@@ -0,0 +1,58 @@
+package upstream
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"transmission-proxy/internal/jrpc"
+	"transmission-proxy/internal/transmission"
+)
+
+// TorrentList caches the list of all torrents with the fields needed by the proxy's own features
+// (quotas, statistics), so that their users do not add load on Transmission. Concurrent callers
+// wait for a single fetch.
+type TorrentList struct {
+	Client *Client
+	Fields []string
+	TTL    time.Duration
+
+	mu       sync.Mutex
+	torrents *transmission.Torrents
+	fetched  time.Time
+}
+
+// Get returns the cached list, fetching it if it is older than TTL. The list must not be modified.
+func (l *TorrentList) Get(ctx context.Context) (*transmission.Torrents, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.torrents != nil && time.Since(l.fetched) < l.TTL {
+		return l.torrents, nil
+	}
+
+	resp, err := l.Client.Call(ctx, nil, &jrpc.Request{
+		Method:    "torrent-get",
+		Arguments: map[string]any{"fields": l.Fields},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("list torrents: %w", err)
+	}
+
+	torrents, err := transmission.ParseTorrents(resp.Arguments["torrents"])
+	if err != nil {
+		return nil, fmt.Errorf("list torrents: %w", err)
+	}
+
+	l.torrents, l.fetched = torrents, time.Now()
+	return torrents, nil
+}
+
+// Invalidate drops the cached list, e.g. after a torrent is added.
+func (l *TorrentList) Invalidate() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.torrents = nil
+}
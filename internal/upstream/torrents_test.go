@@ -0,0 +1,105 @@
+package upstream
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"transmission-proxy/internal/jrpc"
+)
+
+// listUpstream lists one torrent, counting the requests, or fails while down.
+type listUpstream struct {
+	calls  atomic.Int32
+	down   atomic.Bool
+	fields atomic.Value
+}
+
+func (l *listUpstream) RoundTrip(r *http.Request) (*http.Response, error) {
+	l.calls.Add(1)
+	if l.down.Load() {
+		return nil, errors.New("connection refused")
+	}
+
+	var req jrpc.Request
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, err
+	}
+	l.fields.Store(req.Arguments["fields"])
+
+	// slow enough for the concurrent callers to wait for the fetch
+	time.Sleep(10 * time.Millisecond)
+	w := httptest.NewRecorder()
+	_, _ = w.WriteString(`{"arguments":{"torrents":[{"hashString":"aaaa","totalSize":1000}]},"result":"success"}`)
+
+	return w.Result(), nil
+}
+
+func testList(up *listUpstream, ttl time.Duration) *TorrentList {
+	return &TorrentList{
+		Client: &Client{URL: "http://transmission:9091/transmission/rpc", HTTP: &http.Client{Transport: up}},
+		Fields: []string{"hashString", "totalSize"},
+		TTL:    ttl,
+	}
+}
+
+func TestTorrentListCached(t *testing.T) {
+	up := &listUpstream{}
+	l := testList(up, time.Hour)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			torrents, err := l.Get(context.Background())
+			if err != nil || torrents.Len() != 1 || torrents.Int64(0, "totalSize") != 1000 {
+				t.Errorf("got torrents %v, error %v", torrents, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// only the fields of the list are asked for
+	if n := up.calls.Load(); n != 1 {
+		t.Errorf("got %d upstream requests, want 1", n)
+	}
+	if fields, _ := json.Marshal(up.fields.Load()); string(fields) != `["hashString","totalSize"]` {
+		t.Errorf("got fields %s", fields)
+	}
+
+	l.Invalidate()
+	if _, err := l.Get(context.Background()); err != nil || up.calls.Load() != 2 {
+		t.Errorf("after invalidation: got %d upstream requests, error %v", up.calls.Load(), err)
+	}
+}
+
+func TestTorrentListExpired(t *testing.T) {
+	up := &listUpstream{}
+	l := testList(up, time.Nanosecond)
+
+	for i := 0; i < 2; i++ {
+		if _, err := l.Get(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := up.calls.Load(); n != 2 {
+		t.Errorf("got %d upstream requests, want 2", n)
+	}
+
+	// failures are reported, and the list fetched again by the next caller
+	up.down.Store(true)
+	if _, err := l.Get(context.Background()); err == nil {
+		t.Error("got no error while upstream is down")
+	}
+	up.down.Store(false)
+	if _, err := l.Get(context.Background()); err != nil || up.calls.Load() != 4 {
+		t.Errorf("got %d upstream requests, error %v", up.calls.Load(), err)
+	}
+}
@@ -0,0 +1,51 @@
+package userstats
+
+import (
+	"strings"
+
+	"transmission-proxy/internal/ownership"
+	"transmission-proxy/internal/transmission"
+)
+
+// Fields are the torrent fields the summaries are computed from.
+var Fields = []string{"hashString", "status", "totalSize", "uploadedEver", "downloadedEver", "rateUpload", "rateDownload"}
+
+// Summary aggregates torrents of a user.
+type Summary struct {
+	Torrents   int            `json:"torrents"`
+	ByStatus   map[string]int `json:"by_status"`
+	TotalSize  int64          `json:"total_size"`
+	Uploaded   int64          `json:"uploaded"`
+	Downloaded int64          `json:"downloaded"`
+	// RateUpload and RateDownload are current rates in bytes per second.
+	RateUpload   int64 `json:"rate_upload"`
+	RateDownload int64 `json:"rate_download"`
+}
+
+// Compute summarizes the torrents by their owners. Torrents unknown to the store are skipped.
+func Compute(torrents *transmission.Torrents, owners map[string]*ownership.Entry) map[string]*Summary {
+	res := map[string]*Summary{}
+	for i := 0; i < torrents.Len(); i++ {
+		hash := torrents.String(i, "hashString")
+		e := owners[strings.ToLower(hash)]
+		if e == nil {
+			continue
+		}
+
+		s := res[e.User]
+		if s == nil {
+			s = &Summary{ByStatus: map[string]int{}}
+			res[e.User] = s
+		}
+
+		s.Torrents++
+		s.ByStatus[transmission.StatusName(torrents.Int64(i, "status"))]++
+		s.TotalSize += torrents.Int64(i, "totalSize")
+		s.Uploaded += torrents.Int64(i, "uploadedEver")
+		s.Downloaded += torrents.Int64(i, "downloadedEver")
+		s.RateUpload += torrents.Int64(i, "rateUpload")
+		s.RateDownload += torrents.Int64(i, "rateDownload")
+	}
+
+	return res
+}
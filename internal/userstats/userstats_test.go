@@ -0,0 +1,63 @@
+package userstats
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"transmission-proxy/internal/ownership"
+	"transmission-proxy/internal/transmission"
+)
+
+// parseTorrents parses the torrents list of torrent-get response.
+func parseTorrents(t *testing.T, list string) *transmission.Torrents {
+	t.Helper()
+
+	d := json.NewDecoder(strings.NewReader(list))
+	d.UseNumber()
+	var v any
+	if err := d.Decode(&v); err != nil {
+		t.Fatal(err)
+	}
+
+	torrents, err := transmission.ParseTorrents(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return torrents
+}
+
+func TestCompute(t *testing.T) {
+	owners := map[string]*ownership.Entry{"aaaa": {User: "alice"}, "bbbb": {User: "alice"}, "cccc": {User: "bob"}}
+	want := map[string]*Summary{
+		"alice": {Torrents: 2, ByStatus: map[string]int{"download": 1, "seed": 1}, TotalSize: 3000, Uploaded: 50,
+			Downloaded: 2500, RateUpload: 10, RateDownload: 300},
+		"bob": {Torrents: 1, ByStatus: map[string]int{"stopped": 1}, TotalSize: 100},
+	}
+
+	cases := []struct {
+		name, list string
+	}{
+		{name: "objects", list: `[
+			{"hashString":"aaaa","status":4,"totalSize":1000,"uploadedEver":0,"downloadedEver":500,"rateUpload":0,"rateDownload":300},
+			{"hashString":"BBBB","status":6,"totalSize":2000,"uploadedEver":50,"downloadedEver":2000,"rateUpload":10,"rateDownload":0},
+			{"hashString":"cccc","status":0,"totalSize":100,"uploadedEver":0,"downloadedEver":0,"rateUpload":0,"rateDownload":0},
+			{"hashString":"dddd","status":6,"totalSize":9000,"uploadedEver":9,"downloadedEver":9,"rateUpload":9,"rateDownload":9}]`},
+		{name: "table", list: `[
+			["hashString","status","totalSize","uploadedEver","downloadedEver","rateUpload","rateDownload"],
+			["aaaa",4,1000,0,500,0,300],
+			["BBBB",6,2000,50,2000,10,0],
+			["cccc",0,100,0,0,0,0],
+			["dddd",6,9000,9,9,9,9]]`},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			// torrents unknown to the store are not anyone's
+			if got := Compute(parseTorrents(t, tc.list), owners); !reflect.DeepEqual(got, want) {
+				t.Errorf("got %+v, want %+v", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,48 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// Notifier posts events as JSON to the webhook URL.
+type Notifier struct {
+	URL     string
+	Timeout time.Duration
+	HTTP    *http.Client
+}
+
+// Send posts the event, failing unless the webhook answers with 2xx.
+func (n *Notifier) Send(ctx context.Context, event any) error {
+	bs, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("serialize event: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, n.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.URL, bytes.NewReader(bs))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := n.HTTP.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<20))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook answered with status %d", resp.StatusCode)
+	}
+
+	return nil
+}
@@ -0,0 +1,56 @@
+package transmissionproxy
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"mime"
+	"net/http"
+	"strings"
+
+	"transmission-proxy/internal/logger"
+	"transmission-proxy/internal/response"
+)
+
+// RequestCheck describes the RPC requests answered before they are read, see Allow.
+type RequestCheck struct {
+	// RequireJSONContentType rejects RPC requests which are not sent as application/json.
+	RequireJSONContentType bool
+	// RejectMissingContentType rejects RPC requests without Content-Type too, which some clients do not send.
+	RejectMissingContentType bool
+}
+
+// Allow answers RPC requests other than POST with 405 and, with RequireJSONContentType, requests with other
+// Content-Type than application/json with 415. It reports whether the request may be handled.
+func (c RequestCheck) Allow(rr *response.Responder, w http.ResponseWriter, r *http.Request) bool {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		err := logger.WithAttributes(errors.New("RPC requests must be sent with POST"), logger.HTTPMethod(r.Method))
+		rr.RespondAndLogCustom(w, r.Context(), err, 0, slog.LevelWarn, http.StatusMethodNotAllowed)
+		return false
+	}
+
+	if !c.RequireJSONContentType {
+		return true
+	}
+
+	ct := r.Header.Get("Content-Type")
+	if ct == "" && !c.RejectMissingContentType || isJSONContentType(ct) {
+		return true
+	}
+
+	err := logger.WithAttributes(fmt.Errorf("RPC requests must be sent as application/json, got %q", ct), logger.HTTPContentType(ct))
+	rr.RespondAndLogCustom(w, r.Context(), err, 0, slog.LevelWarn, http.StatusUnsupportedMediaType)
+	return false
+}
+
+// isJSONContentType reports whether the Content-Type is application/json, in UTF-8 if charset is given.
+func isJSONContentType(ct string) bool {
+	typ, params, err := mime.ParseMediaType(ct)
+	if err != nil || typ != "application/json" {
+		return false
+	}
+
+	charset, ok := params["charset"]
+	return !ok || strings.EqualFold(charset, "utf-8")
+}
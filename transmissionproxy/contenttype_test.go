@@ -0,0 +1,23 @@
+package transmissionproxy
+
+import "testing"
+
+func TestIsJSONContentType(t *testing.T) {
+	for ct, want := range map[string]bool{
+		"application/json":                  true,
+		"application/json; charset=utf-8":   true,
+		"Application/JSON; charset=UTF-8":   true,
+		"application/json;charset=utf-8":    true,
+		"application/json; charset=latin1":  false,
+		"application/json; charset":         false,
+		"text/plain":                        false,
+		"application/x-www-form-urlencoded": false,
+		"multipart/form-data; boundary=x":   false,
+		"application/jsonp":                 false,
+		"":                                  false,
+	} {
+		if got := isJSONContentType(ct); got != want {
+			t.Errorf("%q: got %v, want %v", ct, got, want)
+		}
+	}
+}
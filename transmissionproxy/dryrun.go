@@ -0,0 +1,58 @@
+package transmissionproxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"transmission-proxy/internal/jrpc"
+	"transmission-proxy/internal/logger"
+)
+
+// DryRunHeader asks the proxy to check the RPC request without forwarding it.
+const DryRunHeader = "X-Proxy-Dry-Run"
+
+// IsDryRun reports whether the request asks for a dry run.
+func IsDryRun(r *http.Request) bool {
+	return r.Header.Get(DryRunHeader) == "1"
+}
+
+// respondDryRun answers the accepted request in dry-run mode instead of forwarding it: the arguments
+// list those dropped or rewritten by validation and policies, and the arguments which would be forwarded.
+// Response rewriters do not run, so that nothing is recorded for the request (ownership, quota usage etc.).
+func respondDryRun(w http.ResponseWriter, r *http.Request, req, sanitized *jrpc.Request) {
+	dropped, rewritten := jrpc.DiffArguments(req, sanitized)
+
+	slog.InfoContext(r.Context(), "RPC dry run accepted",
+		logger.RPCMethod(req.Method),
+		logger.RPCTag(req.Tag))
+
+	arguments := sanitized.Arguments
+	if arguments == nil {
+		arguments = map[string]any{}
+	}
+
+	writeJSON(w, r, http.StatusOK, &jrpc.Response{
+		Result: jrpc.ResultSuccess,
+		Arguments: map[string]any{
+			"dry-run":   true,
+			"dropped":   dropped,
+			"rewritten": rewritten,
+			"arguments": arguments,
+		},
+		Tag:    req.Tag,
+		HasTag: req.HasTag,
+	})
+}
+
+func writeJSON(w http.ResponseWriter, r *http.Request, status int, data any) {
+	bs, _ := json.Marshal(data)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	if _, err := fmt.Fprintln(w, string(bs)); err != nil {
+		slog.ErrorContext(r.Context(), "failed to write response: "+err.Error(), logger.IgnoredAttr(err))
+	}
+}
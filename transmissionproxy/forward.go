@@ -0,0 +1,241 @@
+package transmissionproxy
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"transmission-proxy/internal/clientip"
+	"transmission-proxy/internal/jrpc"
+	"transmission-proxy/internal/logger"
+	"transmission-proxy/internal/reqctx"
+	"transmission-proxy/internal/response"
+	"transmission-proxy/internal/stats"
+	"transmission-proxy/internal/transmission"
+	"transmission-proxy/internal/upstream"
+)
+
+// UpstreamErrorLogWindow is the default period during which repeated upstream errors of the same class are logged
+// only once, and after which the number of the suppressed ones is logged.
+const UpstreamErrorLogWindow = 5 * time.Minute
+
+// ForwardConfig configures the handler returned by Forward.
+type ForwardConfig struct {
+	// Upstream is the URL of Transmission without path, e.g. http://localhost:9091/.
+	Upstream *url.URL
+	// Client makes the requests to Upstream, http.DefaultClient if nil. Redirects are never followed,
+	// they are passed to the client of the proxy.
+	Client *http.Client
+	// Username and Password authenticate the requests to Upstream if set. Otherwise the credentials
+	// of the client of the proxy are passed on.
+	Username string
+	Password string
+	// Session remembers the session id of Transmission, so that requests answered with 409 are retried
+	// with the new one. If nil, the clients negotiate the session id themselves.
+	Session *upstream.Session
+	// Pool, if set, replaces Upstream and Session: the hosts of the pool are tried in order, skipping
+	// to the next one on connection errors and 502 or 503 responses.
+	Pool *upstream.Pool
+	// CollapseSlashes replaces repeated slashes in the paths with single ones. Otherwise the path is forwarded
+	// as sent, including percent-encoded characters.
+	CollapseSlashes bool
+	// BalanceReads makes requests for read-only RPC methods and the web interface start with the next host
+	// of Pool in turn rather than with the first healthy one.
+	BalanceReads bool
+	// Resolver sets the X-Forwarded-* headers, trusting no proxies if nil.
+	Resolver *clientip.Resolver
+	// Stats records the upstream requests, a registry of its own if nil.
+	Stats *stats.Registry
+	// Responder answers the failed requests, without debug mode if nil.
+	Responder *response.Responder
+	// ErrorLogWindow overrides UpstreamErrorLogWindow if set.
+	ErrorLogWindow time.Duration
+}
+
+// Forward returns handler forwarding requests to the upstream at the same path. RPC requests are expected
+// to have the request as validated in the context (see reqctx.WithForwardedRPC), so that errors are
+// answered with its tag.
+func Forward(cfg ForwardConfig) http.HandlerFunc {
+	c := &http.Client{}
+	if cfg.Client != nil {
+		*c = *cfg.Client
+	}
+	c.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+
+	pool, st, rr := cfg.Pool, cfg.Stats, cfg.Responder
+	if pool == nil {
+		pool = &upstream.Pool{Hosts: []*upstream.Host{{URL: cfg.Upstream, Session: cfg.Session}}}
+	}
+	ipr := cfg.Resolver
+	if ipr == nil {
+		ipr = &clientip.Resolver{}
+	}
+	if st == nil {
+		st = stats.NewRegistry()
+	}
+	if rr == nil {
+		rr = &response.Responder{}
+	}
+
+	window := cfg.ErrorLogWindow
+	if window == 0 {
+		window = UpstreamErrorLogWindow
+	}
+	throttle := logger.NewThrottle(window)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		rpc := reqctx.ForwardedRPC(r.Context())
+		rotate := cfg.BalanceReads && (rpc != nil && slices.Contains(transmission.ReadOnlyMethods, rpc.Method) ||
+			rpc == nil && (r.Method == http.MethodGet || r.Method == http.MethodHead))
+		// another host may be tried if the body can be sent again
+		resendable := r.GetBody != nil || r.Body == nil || r.Body == http.NoBody
+
+		hosts := pool.Order(rotate)
+		for i, h := range hosts {
+			var next *upstream.Host
+			if i < len(hosts)-1 && resendable {
+				next = hosts[i+1]
+			}
+
+			out := r.Clone(r.Context())
+			if i > 0 && r.GetBody != nil {
+				body, err := r.GetBody()
+				if err != nil {
+					rr.RespondAndLogError(w, r.Context(), fmt.Errorf("cannot resend request: %w", err), rpcTag(rpc))
+					return
+				}
+				out.Body = body
+			}
+			out.URL = upstreamURL(h.URL, r.URL, cfg.CollapseSlashes)
+			out.RequestURI = ""
+			removeHopHeaders(out.Header)
+			ipr.SetForwardHeaders(r, out.Header)
+			if id := reqctx.RequestID(r.Context()); id != "" {
+				out.Header.Set("X-Request-Id", id)
+			}
+			if cfg.Username != "" {
+				out.SetBasicAuth(cfg.Username, cfg.Password)
+			}
+			if h.Session != nil && h.Session.ID() != "" {
+				out.Header.Set(upstream.SessionIDHeader, h.Session.ID())
+			}
+
+			reqctx.SetUpstream(r.Context(), h.URL.Host)
+			start := time.Now()
+			resp, err := c.Do(out)
+			if err == nil && h.Session != nil {
+				resp, err = retryConflict(c, out, resp, h.Session)
+			}
+			if err != nil {
+				class := upstream.Classify(err)
+				st.Upstream(h.URL.Host).Observe(time.Since(start), class)
+
+				if r.Context().Err() == nil {
+					pool.Failed(r.Context(), h, class, next)
+					if next != nil {
+						continue
+					}
+				}
+
+				attrs := []slog.Attr{logger.HTTPUpstream(h.URL.Host), logger.ErrClass(class)}
+				if rpc != nil {
+					attrs = append(attrs, logger.RPCMethod(rpc.Method))
+				}
+
+				status := http.StatusBadGateway
+				if class == upstream.ClassTimeout {
+					status = http.StatusGatewayTimeout
+				}
+
+				lvl := slog.LevelError
+				if class == upstream.ClassCanceled {
+					// the client went away
+					lvl = slog.LevelInfo
+				}
+				lvl = throttle.Level(r.Context(), "upstream "+class+" errors from "+h.URL.Host, lvl)
+				err = logger.WithAttributes(err, attrs...)
+				rr.RespondAndLogCustom(w, r.Context(), fmt.Errorf("upstream error: %w", err), rpcTag(rpc), lvl, status)
+				return
+			}
+
+			st.Upstream(h.URL.Host).Observe(time.Since(start), upstream.ClassifyStatus(resp.StatusCode))
+			if upstream.IsFailoverStatus(resp.StatusCode) {
+				pool.Failed(r.Context(), h, fmt.Sprintf("status %d", resp.StatusCode), next)
+				if next != nil {
+					_, _ = io.Copy(io.Discard, resp.Body)
+					_ = resp.Body.Close()
+					continue
+				}
+			} else {
+				pool.Succeeded(r.Context(), h)
+			}
+
+			copyResponse(w, r, resp)
+			return
+		}
+	}
+}
+
+// upstreamURL returns the URL of the request at the upstream, keeping the path as the client escaped it:
+// e.g. %2F is not the same as / to the upstream.
+func upstreamURL(base, u *url.URL, collapseSlashes bool) *url.URL {
+	escaped := u.EscapedPath()
+	if collapseSlashes {
+		escaped = repeatedSlashes.ReplaceAllString(escaped, "/")
+	}
+	p, err := url.PathUnescape(escaped)
+	if err != nil {
+		p = u.Path
+	}
+
+	res := *base
+	res.Path = strings.TrimSuffix(base.Path, "/") + p
+	res.RawPath = strings.TrimSuffix(base.EscapedPath(), "/") + escaped
+	res.RawQuery = u.RawQuery
+	res.Fragment, res.RawFragment = "", ""
+
+	return &res
+}
+
+var repeatedSlashes = regexp.MustCompile(`//+`)
+
+// copyResponse sends the upstream response to the client.
+func copyResponse(w http.ResponseWriter, r *http.Request, resp *http.Response) {
+	if rec, ok := w.(interface{ SetUpstreamStatus(int) }); ok {
+		rec.SetUpstreamStatus(resp.StatusCode)
+	}
+
+	// header names of the parsed response are canonical already, copy them without Add re-canonicalizing each
+	dst := w.Header()
+	removeHopHeaders(resp.Header)
+	// the client gets the ID of this request already
+	resp.Header.Del("X-Request-Id")
+	for h, vals := range resp.Header {
+		dst[h] = append(dst[h], vals...)
+	}
+
+	w.WriteHeader(resp.StatusCode)
+
+	defer func() { _ = resp.Body.Close() }()
+
+	if _, err := io.Copy(w, resp.Body); err != nil {
+		slog.ErrorContext(r.Context(), "proxy: failed to write response: "+err.Error(), logger.IgnoredAttr(err))
+	}
+}
+
+// rpcTag returns the tag to answer the RPC request with, if any.
+func rpcTag(req *jrpc.Request) int {
+	if req == nil {
+		return 0
+	}
+
+	return req.Tag
+}
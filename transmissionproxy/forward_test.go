@@ -0,0 +1,255 @@
+package transmissionproxy
+
+import (
+	"bytes"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"transmission-proxy/internal/clientip"
+	"transmission-proxy/internal/jrpc"
+	"transmission-proxy/internal/reqctx"
+	"transmission-proxy/internal/stats"
+	"transmission-proxy/internal/transmissiontest"
+	"transmission-proxy/internal/upstream"
+)
+
+// captureLog makes the default logger write to the returned buffer for the duration of the test.
+func captureLog(t *testing.T) *bytes.Buffer {
+	var buf bytes.Buffer
+	prev := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&buf, nil)))
+	t.Cleanup(func() { slog.SetDefault(prev) })
+
+	return &buf
+}
+
+// fakeUpstreams starts a failing upstream answering 503 and a working one, returning the pool trying the failing one first.
+func fakeUpstreams(t *testing.T, backoff time.Duration) (*upstream.Pool, *url.URL, *url.URL) {
+	bad := &transmissiontest.Server{RPC: func(w http.ResponseWriter, _ *http.Request, req *jrpc.Request) {
+		transmissiontest.Reply(w, http.StatusServiceUnavailable, req, `{}`)
+	}}
+	badURL, goodURL := bad.Start(t), (&transmissiontest.Server{}).Start(t)
+
+	return upstream.NewPool([]*url.URL{badURL, goodURL}, backoff, false), badURL, goodURL
+}
+
+func forwardRPC(t *testing.T, h http.Handler) (*httptest.ResponseRecorder, *reqctx.RPCCall) {
+	body := `{"method":"session-get"}`
+	r := httptest.NewRequest(http.MethodPost, "/transmission/rpc", strings.NewReader(body))
+	r.GetBody = func() (io.ReadCloser, error) { return io.NopCloser(strings.NewReader(body)), nil }
+	ctx, call := reqctx.WithRPCCall(r.Context())
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r.WithContext(ctx))
+
+	return w, call
+}
+
+func TestForwardUpstreamStats(t *testing.T) {
+	pool, bad, good := fakeUpstreams(t, 0)
+	st := stats.NewRegistry()
+	h := Forward(ForwardConfig{Pool: pool, Stats: st})
+	logs := captureLog(t)
+
+	for i := 0; i < 3; i++ {
+		w, call := forwardRPC(t, h)
+		if w.Code != http.StatusOK {
+			t.Fatalf("got status %d", w.Code)
+		}
+		if call.Upstream != good.Host {
+			t.Errorf("got upstream %q, want %q", call.Upstream, good.Host)
+		}
+	}
+
+	// without backoff the failing upstream is tried first every time
+	ups := st.Upstreams()
+	if got := ups[bad.Host]; got.Requests != 3 || got.Errors[upstream.ClassStatus5xx] != 3 {
+		t.Errorf("failing upstream: got %d requests, errors %v", got.Requests, got.Errors)
+	}
+	if got := ups[good.Host]; got.Requests != 3 || len(got.Errors) != 0 {
+		t.Errorf("working upstream: got %d requests, errors %v", got.Requests, got.Errors)
+	}
+
+	if n := strings.Count(logs.String(), "failed (status 503), failing over to "+good.Host); n != 3 {
+		t.Errorf("got %d failover records, want 3:\n%s", n, logs)
+	}
+	if !strings.Contains(logs.String(), `"failed_upstream":"`+bad.Host+`"`) {
+		t.Errorf("failed upstream not logged:\n%s", logs)
+	}
+}
+
+func TestForwardUpstreamBackoff(t *testing.T) {
+	pool, bad, good := fakeUpstreams(t, time.Minute)
+	st := stats.NewRegistry()
+	h := Forward(ForwardConfig{Pool: pool, Stats: st})
+	captureLog(t)
+
+	for i := 0; i < 3; i++ {
+		if w, _ := forwardRPC(t, h); w.Code != http.StatusOK {
+			t.Fatalf("got status %d", w.Code)
+		}
+	}
+
+	// the failing upstream is skipped once it failed
+	ups := st.Upstreams()
+	if got := ups[bad.Host]; got.Requests != 1 {
+		t.Errorf("failing upstream: got %d requests, want 1", got.Requests)
+	}
+	if got := ups[good.Host]; got.Requests != 3 {
+		t.Errorf("working upstream: got %d requests, want 3", got.Requests)
+	}
+
+	status := pool.Status()
+	if status[0].Healthy || status[0].Failures != 1 || status[0].LastError != "status 503" || !status[1].Healthy {
+		t.Errorf("got pool status %+v", status)
+	}
+}
+
+func TestForwardNotFailedOver(t *testing.T) {
+	pool, bad, _ := fakeUpstreams(t, 0)
+	h := Forward(ForwardConfig{Pool: pool})
+	logs := captureLog(t)
+
+	// the body of the request cannot be sent again
+	r := httptest.NewRequest(http.MethodPost, "/transmission/rpc", strings.NewReader(`{"method":"session-get"}`))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("got status %d, want the status of the failing upstream", w.Code)
+	}
+	if !strings.Contains(logs.String(), "upstream "+bad.Host+" failed (status 503), not failing over") {
+		t.Errorf("failure not logged:\n%s", logs)
+	}
+}
+
+func TestForwardHeaders(t *testing.T) {
+	setHeaders := func(w http.ResponseWriter) {
+		w.Header().Set("Connection", "X-Upstream-Hop")
+		w.Header().Set("X-Upstream-Hop", "1")
+		w.Header().Set("Keep-Alive", "timeout=5")
+		w.Header().Set("X-Upstream", "1")
+	}
+	up := &transmissiontest.Server{
+		RPC: func(w http.ResponseWriter, _ *http.Request, req *jrpc.Request) {
+			setHeaders(w)
+			transmissiontest.Reply(w, http.StatusOK, req, `{}`)
+		},
+		Web: func(w http.ResponseWriter, _ *http.Request) { setHeaders(w) },
+	}
+	upURL := up.Start(t)
+
+	trusted, err := clientip.ParseTrustedProxies("127.0.0.0/8, ::1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(Forward(ForwardConfig{Upstream: upURL, Resolver: &clientip.Resolver{TrustedProxies: trusted}}))
+	defer srv.Close()
+	srvURL, _ := url.Parse(srv.URL)
+
+	for _, path := range []string{"/transmission/web/", "/transmission/rpc"} {
+		r, _ := http.NewRequest(http.MethodPost, srv.URL+path, strings.NewReader(`{"method":"session-get"}`))
+		r.Header.Set("Connection", "X-Client-Hop")
+		r.Header.Set("X-Client-Hop", "1")
+		r.Header.Set("Keep-Alive", "timeout=5")
+		r.Header.Set("Upgrade", "websocket")
+		r.Header.Set("Te", "trailers")
+		r.Header.Set("X-Forwarded-For", "203.0.113.5")
+		r.Header.Set("X-Client", "1")
+		resp, err := srv.Client().Do(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = resp.Body.Close()
+		requests := up.Requests()
+		got := requests[len(requests)-1].Header
+
+		// the test client connects through the loopback, a trusted proxy
+		for k, want := range map[string]string{
+			"X-Forwarded-For":   "203.0.113.5, 127.0.0.1",
+			"X-Forwarded-Proto": "http",
+			"X-Forwarded-Host":  srvURL.Host,
+			"X-Client":          "1",
+		} {
+			if v := got.Get(k); v != want {
+				t.Errorf("%s: got %s %q sent upstream, want %q", path, k, v, want)
+			}
+		}
+		for _, k := range []string{"X-Client-Hop", "Keep-Alive", "Upgrade", "Te"} {
+			if v, ok := got[k]; ok {
+				t.Errorf("%s: hop-by-hop header %s %v sent upstream", path, k, v)
+			}
+		}
+
+		if resp.Header.Get("X-Upstream") != "1" {
+			t.Errorf("%s: got response headers %v", path, resp.Header)
+		}
+		for _, k := range []string{"X-Upstream-Hop", "Keep-Alive"} {
+			if v, ok := resp.Header[k]; ok {
+				t.Errorf("%s: hop-by-hop header %s %v sent to the client", path, k, v)
+			}
+		}
+	}
+}
+
+func TestForwardRawPath(t *testing.T) {
+	up := &transmissiontest.Server{}
+	u := up.Start(t)
+
+	cases := []struct {
+		target string
+		// want is the request URI at the upstream, and collapsed the one with CollapseSlashes
+		want, collapsed string
+	}{
+		{target: "/transmission/web/index.html"},
+		{target: "/transmission/web/a%2Fb.torrent"},
+		{target: "/transmission/web/a%2fb.torrent"},
+		{target: "/transmission/web/my%20file.txt"},
+		{target: "/transmission/web/%D1%84%D0%B0%D0%B9%D0%BB.txt"},
+		{target: "/transmission/web/index.html?q=a%20b&x=%2F"},
+		{target: "/transmission/web/a+b%3Bc"},
+		{target: "//transmission//web/index.html", collapsed: "/transmission/web/index.html"},
+		{target: "/transmission/web///style.css?x=//", collapsed: "/transmission/web/style.css?x=//"},
+		// encoded slashes are not path separators to collapse
+		{target: "/transmission/web//a%2F%2Fb", collapsed: "/transmission/web/a%2F%2Fb"},
+	}
+
+	for _, collapse := range []bool{false, true} {
+		h := Forward(ForwardConfig{Upstream: u, CollapseSlashes: collapse})
+		for _, tc := range cases {
+			want := tc.target
+			if collapse && tc.collapsed != "" {
+				want = tc.collapsed
+			}
+
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tc.target, nil))
+			if w.Code != http.StatusOK {
+				t.Fatalf("%s: got status %d, body %s", tc.target, w.Code, w.Body)
+			}
+			requests := up.Requests()
+			if got := requests[len(requests)-1].RequestURI; got != want {
+				t.Errorf("%s (collapse %v): got upstream request URI %s, want %s", tc.target, collapse, got, want)
+			}
+		}
+	}
+}
+
+func TestUpstreamURLBasePath(t *testing.T) {
+	base, _ := url.Parse("http://nas:9091/tr/")
+	for target, want := range map[string]string{
+		"/transmission/web/a%2Fb": "http://nas:9091/tr/transmission/web/a%2Fb",
+		"/transmission/rpc?x=1":   "http://nas:9091/tr/transmission/rpc?x=1",
+		"/":                       "http://nas:9091/tr/",
+	} {
+		r := httptest.NewRequest(http.MethodGet, target, nil)
+		if got := upstreamURL(base, r.URL, false).String(); got != want {
+			t.Errorf("%s: got %s, want %s", target, got, want)
+		}
+	}
+}
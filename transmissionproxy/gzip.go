@@ -0,0 +1,93 @@
+package transmissionproxy
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"transmission-proxy/internal/response"
+)
+
+// acceptsGzip reports whether the client accepts gzip-encoded responses.
+func acceptsGzip(r *http.Request) bool {
+	for _, v := range r.Header.Values("Accept-Encoding") {
+		for _, coding := range strings.Split(v, ",") {
+			name, params, _ := strings.Cut(coding, ";")
+			name = strings.ToLower(strings.TrimSpace(name))
+			if name != "gzip" && name != "*" {
+				continue
+			}
+
+			// gzip;q=0 refuses gzip
+			q, ok := strings.CutPrefix(strings.ReplaceAll(params, " ", ""), "q=")
+			if f, err := strconv.ParseFloat(q, 64); ok && err == nil && f == 0 {
+				continue
+			}
+
+			return true
+		}
+	}
+
+	return false
+}
+
+// gzipBody compresses the body of buffered response, setting the headers accordingly.
+func gzipBody(buf *response.Buffer, body []byte) []byte {
+	var out bytes.Buffer
+	gz := gzip.NewWriter(&out)
+	// writes to bytes.Buffer do not fail
+	_, _ = gz.Write(body)
+	_ = gz.Close()
+
+	buf.Header().Set("Content-Encoding", "gzip")
+	buf.Header().Add("Vary", "Accept-Encoding")
+	return out.Bytes()
+}
+
+// gzipWriter compresses the response unless it is encoded already, e.g. by the upstream.
+type gzipWriter struct {
+	*response.Recorder
+	gz          *gzip.Writer
+	wroteHeader bool
+}
+
+func (g *gzipWriter) WriteHeader(status int) {
+	if g.wroteHeader {
+		g.Recorder.WriteHeader(status)
+		return
+	}
+	g.wroteHeader = true
+
+	h := g.Header()
+	if h.Get("Content-Encoding") == "" && status != http.StatusNoContent && status != http.StatusNotModified {
+		h.Set("Content-Encoding", "gzip")
+		h.Add("Vary", "Accept-Encoding")
+		h.Del("Content-Length")
+		g.gz = gzip.NewWriter(g.Recorder)
+	}
+
+	g.Recorder.WriteHeader(status)
+}
+
+func (g *gzipWriter) Write(bs []byte) (int, error) {
+	if !g.wroteHeader {
+		g.WriteHeader(http.StatusOK)
+	}
+
+	if g.gz != nil {
+		return g.gz.Write(bs)
+	}
+
+	return g.Recorder.Write(bs)
+}
+
+// Close flushes the compressed response.
+func (g *gzipWriter) Close() error {
+	if g.gz == nil {
+		return nil
+	}
+
+	return g.gz.Close()
+}
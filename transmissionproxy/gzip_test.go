@@ -0,0 +1,32 @@
+package transmissionproxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAcceptsGzip(t *testing.T) {
+	cases := []struct {
+		header []string
+		want   bool
+	}{
+		{header: nil},
+		{header: []string{"gzip"}, want: true},
+		{header: []string{"deflate, GZIP;q=0.5"}, want: true},
+		{header: []string{"br", "gzip"}, want: true},
+		{header: []string{"*"}, want: true},
+		{header: []string{"gzip;q=0"}},
+		{header: []string{"gzip; q=0.0, br"}},
+		{header: []string{"identity"}},
+		{header: []string{"x-gzip2"}},
+	}
+
+	for _, tc := range cases {
+		r := httptest.NewRequest(http.MethodPost, DefaultRPCPath, nil)
+		r.Header["Accept-Encoding"] = tc.header
+		if got := acceptsGzip(r); got != tc.want {
+			t.Errorf("%q: got %v, want %v", tc.header, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,35 @@
+package transmissionproxy
+
+import (
+	"net/http"
+	"net/textproto"
+	"strings"
+)
+
+// hopHeaders apply to a single connection and must not be forwarded by proxies (RFC 7230, section 6.1).
+var hopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Connection",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"TE",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// removeHopHeaders removes hop-by-hop headers, including the ones listed in Connection header.
+func removeHopHeaders(h http.Header) {
+	for _, vals := range h["Connection"] {
+		for _, name := range strings.Split(vals, ",") {
+			if name = textproto.TrimString(name); name != "" {
+				h.Del(name)
+			}
+		}
+	}
+
+	for _, name := range hopHeaders {
+		h.Del(name)
+	}
+}
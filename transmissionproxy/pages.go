@@ -0,0 +1,36 @@
+package transmissionproxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"transmission-proxy/internal/logger"
+)
+
+// HomePage serves the root page with gw, answering 404 for other paths it is mounted at.
+func HomePage(gw http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/" {
+			gw.ServeHTTP(w, r)
+			return
+		}
+
+		NotFound(w, r)
+	}
+}
+
+func NotFound(w http.ResponseWriter, r *http.Request) {
+	data := map[string]any{}
+	data["result"] = "page not found"
+
+	bs, _ := json.Marshal(data)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusNotFound)
+
+	if _, err := fmt.Fprintln(w, string(bs)); err != nil {
+		slog.ErrorContext(r.Context(), "not_found: failed to write response: "+err.Error(), logger.IgnoredAttr(err))
+	}
+}
@@ -0,0 +1,111 @@
+// Package transmissionproxy builds the proxy validating RPC requests to Transmission as http.Handler, so that it
+// can be embedded in other services. It has the core of the proxy only: the features configured by the environment
+// of the transmission-proxy command (authentication, audit log etc.) plug into the handler of RPC requests
+// with RPCHooks.
+package transmissionproxy
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+
+	"transmission-proxy/internal/policy"
+	"transmission-proxy/internal/response"
+	"transmission-proxy/internal/stats"
+	"transmission-proxy/internal/transmission"
+	"transmission-proxy/internal/upstream"
+)
+
+const (
+	DefaultRPCPath      = "/transmission/rpc"
+	DefaultWebPath      = "/transmission/web/"
+	DefaultMaxBodyBytes = 16 << 20
+)
+
+// Config configures the proxy built by New.
+type Config struct {
+	// Upstream is the URL of Transmission without path, e.g. http://localhost:9091/.
+	Upstream *url.URL
+	// RPCPath is the path of the RPC endpoint, DefaultRPCPath if empty.
+	RPCPath string
+	// WebPath is the path of the web interface, DefaultWebPath if empty.
+	WebPath string
+	// DownloadPrefix is the directory torrents may be downloaded to by the default validator, "/" if empty.
+	DownloadPrefix string
+	// Validator validates RPC requests instead of transmission.DefaultMethodsValidator for DownloadPrefix.
+	Validator transmission.RequestValidator
+	// Client makes the requests to Upstream, http.DefaultClient if nil.
+	Client *http.Client
+	// Username and Password authenticate the requests to Upstream if set, see ForwardConfig.
+	Username string
+	Password string
+	// CollapseSlashes replaces repeated slashes in the forwarded paths with single ones.
+	CollapseSlashes bool
+	// Policies are applied to the validated RPC requests in order, see policy.Chain.
+	Policies []policy.Policy
+	// Hooks extend the handling of RPC requests, e.g. with rate limits.
+	Hooks RPCHooks
+	// MaxBodyBytes limits the size of RPC requests, DefaultMaxBodyBytes if zero.
+	MaxBodyBytes int64
+	// RequireJSONContentType and RejectMissingContentType restrict the Content-Type of RPC requests,
+	// see RequestCheck.
+	RequireJSONContentType   bool
+	RejectMissingContentType bool
+	// DebugMode sends error messages to the clients rather than only error IDs.
+	DebugMode bool
+}
+
+// New returns the proxy forwarding RPC requests to Transmission once validated, as well as the requests
+// for the web interface and the root page. The session id of Transmission is negotiated by the proxy.
+func New(cfg Config) (http.Handler, error) {
+	if cfg.Upstream == nil || cfg.Upstream.Host == "" {
+		return nil, errors.New("upstream URL must be set")
+	}
+	if cfg.Upstream.Path != "" && cfg.Upstream.Path != "/" || cfg.Upstream.RawQuery != "" {
+		return nil, errors.New("upstream URL must not define path or query")
+	}
+	if cfg.RPCPath == "" {
+		cfg.RPCPath = DefaultRPCPath
+	}
+	if cfg.WebPath == "" {
+		cfg.WebPath = DefaultWebPath
+	}
+	if cfg.DownloadPrefix == "" {
+		cfg.DownloadPrefix = "/"
+	}
+	if cfg.Validator == nil {
+		cfg.Validator = transmission.DefaultMethodsValidator(cfg.DownloadPrefix)
+	}
+
+	rr, st := &response.Responder{DebugMode: cfg.DebugMode}, stats.NewRegistry()
+	fw := Forward(ForwardConfig{
+		Upstream:        cfg.Upstream,
+		Client:          cfg.Client,
+		Username:        cfg.Username,
+		Password:        cfg.Password,
+		Session:         &upstream.Session{},
+		CollapseSlashes: cfg.CollapseSlashes,
+		Stats:           st,
+		Responder:       rr,
+	})
+	rpc := RPC(fw, RPCConfig{
+		Validator:    cfg.Validator,
+		Policies:     cfg.Policies,
+		MaxBodyBytes: cfg.MaxBodyBytes,
+		Responder:    rr,
+		Stats:        st,
+		Hooks:        cfg.Hooks,
+	})
+	check := RequestCheck{RequireJSONContentType: cfg.RequireJSONContentType, RejectMissingContentType: cfg.RejectMissingContentType}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(cfg.RPCPath, func(w http.ResponseWriter, r *http.Request) {
+		if check.Allow(rr, w, r) {
+			rpc.ServeHTTP(w, r)
+		}
+	})
+	mux.Handle(cfg.WebPath, fw)
+	mux.Handle("/", HomePage(fw))
+
+	return mux, nil
+}
@@ -0,0 +1,217 @@
+package transmissionproxy
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"transmission-proxy/internal/jrpc"
+	"transmission-proxy/internal/transmissiontest"
+)
+
+// testProxy returns the proxy of the fake Transmission, configured by cfg. The fake negotiates the session id
+// and serves the paths the proxy is configured with.
+func testProxy(t *testing.T, cfg Config) (http.Handler, *transmissiontest.Server) {
+	t.Helper()
+
+	webPath := DefaultWebPath
+	if cfg.WebPath != "" {
+		webPath = cfg.WebPath
+	}
+	f := &transmissiontest.Server{
+		RPCPath:   cfg.RPCPath,
+		SessionID: "sid",
+		Web: func(w http.ResponseWriter, r *http.Request) {
+			if strings.HasPrefix(r.URL.Path, webPath) {
+				_, _ = w.Write([]byte("web " + r.URL.Path))
+				return
+			}
+			_, _ = w.Write([]byte("home " + r.URL.Path))
+		},
+	}
+
+	cfg.Upstream = f.Start(t)
+	h, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return h, f
+}
+
+func serve(h http.Handler, method, target, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+
+	return w
+}
+
+func TestNewInvalidConfig(t *testing.T) {
+	for _, u := range []string{"", "/transmission", "http://localhost:9091/transmission/", "http://localhost:9091/?a=1"} {
+		cfg := Config{}
+		if u != "" {
+			cfg.Upstream, _ = url.Parse(u)
+		}
+		if _, err := New(cfg); err == nil {
+			t.Errorf("%q: got no error", u)
+		}
+	}
+}
+
+func TestProxyRPC(t *testing.T) {
+	captureLog(t)
+	h, f := testProxy(t, Config{DownloadPrefix: "/downloads/"})
+
+	// valid requests are forwarded as sent, the session id negotiated by the proxy once
+	bodies := []string{
+		`{"method":"torrent-get","arguments":{"fields":["id", "name"]},"tag":1}`,
+		`{"method":"torrent-add","arguments":{"filename":"magnet:?xt=urn:btih:abc","download-dir":"/downloads/tv"},"tag":2}`,
+		`{"method":"session-get"}`,
+	}
+	for _, body := range bodies {
+		w := serve(h, http.MethodPost, DefaultRPCPath, body)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: got status %d, body %s", body, w.Code, w.Body)
+		}
+	}
+	if f.Conflicts() != 1 || strings.Join(f.Bodies(), "\n") != strings.Join(bodies, "\n") {
+		t.Errorf("got %d conflicts, forwarded %q", f.Conflicts(), f.Bodies())
+	}
+
+	// invalid ones are answered by the proxy
+	cases := []struct {
+		name, body string
+		status     int
+	}{
+		{name: "forbidden location", body: `{"method":"torrent-add","arguments":{"filename":"x.torrent","download-dir":"/etc"},"tag":3}`,
+			status: http.StatusBadRequest},
+		{name: "unknown method", body: `{"method":"shell-exec","tag":4}`, status: http.StatusBadRequest},
+		{name: "malformed", body: `{"method":"torrent-get","arguments":[1],"tag":5}`, status: http.StatusBadRequest},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			w := serve(h, http.MethodPost, DefaultRPCPath, tc.body)
+			var res map[string]any
+			if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil || w.Code != tc.status {
+				t.Fatalf("got status %d, body %s", w.Code, w.Body)
+			}
+			// the client matches the error to its request by the tag
+			var req struct{ Tag float64 }
+			_ = json.Unmarshal([]byte(tc.body), &req)
+			if res["tag"] != req.Tag || res["result"] == "success" {
+				t.Errorf("got body %s", w.Body)
+			}
+		})
+	}
+	if forwarded := f.Bodies(); len(forwarded) != len(bodies) {
+		t.Errorf("invalid requests forwarded: %q", forwarded[len(bodies):])
+	}
+}
+
+func TestProxyPages(t *testing.T) {
+	h, _ := testProxy(t, Config{})
+
+	cases := []struct {
+		target string
+		status int
+		body   string
+	}{
+		{target: "/", status: http.StatusOK, body: "home /"},
+		{target: "/transmission/web/", status: http.StatusOK, body: "web /transmission/web/"},
+		{target: "/transmission/web/style.css", status: http.StatusOK, body: "web /transmission/web/style.css"},
+		// other paths of the upstream are not exposed
+		{target: "/etc/passwd", status: http.StatusNotFound, body: `{"result":"page not found"}` + "\n"},
+		{target: "/transmission/", status: http.StatusNotFound, body: `{"result":"page not found"}` + "\n"},
+	}
+	for _, tc := range cases {
+		w := serve(h, http.MethodGet, tc.target, "")
+		if w.Code != tc.status || w.Body.String() != tc.body {
+			t.Errorf("%s: got status %d, body %q", tc.target, w.Code, w.Body)
+		}
+	}
+}
+
+// validatorFunc is the validator injected by the library users.
+type validatorFunc func(req *jrpc.Request) (*jrpc.Request, error)
+
+func (f validatorFunc) Validate(req *jrpc.Request) (*jrpc.Request, error) {
+	return f(req)
+}
+
+// countingTransport counts the requests made with it.
+type countingTransport struct {
+	n atomic.Int32
+}
+
+func (c *countingTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	c.n.Add(1)
+	return http.DefaultTransport.RoundTrip(r)
+}
+
+func TestProxyCustomConfig(t *testing.T) {
+	captureLog(t)
+	ct := &countingTransport{}
+	// only torrents of the proxy are listed, which the validator does by adding the label filter
+	v := validatorFunc(func(req *jrpc.Request) (*jrpc.Request, error) {
+		if req.Method != "torrent-get" {
+			return nil, errors.New("only torrent-get is allowed")
+		}
+		sanitized := *req
+		sanitized.Arguments = map[string]any{"fields": req.Arguments["fields"], "labels": []any{"proxy"}}
+		return &sanitized, nil
+	})
+	h, f := testProxy(t, Config{
+		RPCPath:      "/rpc",
+		WebPath:      "/ui/",
+		Validator:    v,
+		Client:       &http.Client{Transport: ct},
+		Username:     "admin",
+		Password:     "secret",
+		MaxBodyBytes: 100,
+		DebugMode:    true,
+	})
+
+	w := serve(h, http.MethodPost, "/rpc", `{"method":"torrent-get","arguments":{"fields":["id"]},"tag":7}`)
+	if w.Code != http.StatusOK || w.Body.String() != `{"arguments":{},"result":"success","tag":7}` {
+		t.Fatalf("got status %d, body %s", w.Code, w.Body)
+	}
+	if bodies := f.Bodies(); len(bodies) != 1 || bodies[0] != `{"method":"torrent-get","arguments":{"fields":["id"],"labels":["proxy"]},"tag":7}` {
+		t.Errorf("forwarded %q, want the sanitized request", bodies)
+	}
+	for _, r := range f.Requests() {
+		if user, pass, _ := (&http.Request{Header: r.Header}).BasicAuth(); user != "admin" || pass != "secret" {
+			t.Errorf("got upstream credentials %q", r.Header.Get("Authorization"))
+		}
+	}
+	// the conflict and its retry go through the client
+	if n := ct.n.Load(); n != 2 {
+		t.Errorf("got %d requests through the client, want 2", n)
+	}
+
+	// the messages of the validator are shown in debug mode
+	w = serve(h, http.MethodPost, "/rpc", `{"method":"torrent-remove","arguments":{"ids":[1]},"tag":8}`)
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "only torrent-get is allowed") {
+		t.Errorf("got status %d, body %s", w.Code, w.Body)
+	}
+	w = serve(h, http.MethodPost, "/rpc", `{"method":"torrent-get","arguments":{"fields":["`+strings.Repeat("x", 100)+`"]}}`)
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("large request: got status %d, body %s", w.Code, w.Body)
+	}
+
+	// the paths are the configured ones only
+	if w := serve(h, http.MethodGet, "/ui/index.html", ""); w.Code != http.StatusOK || w.Body.String() != "web /ui/index.html" {
+		t.Errorf("web: got status %d, body %s", w.Code, w.Body)
+	}
+	if w := serve(h, http.MethodPost, DefaultRPCPath, `{"method":"torrent-get"}`); w.Code != http.StatusNotFound {
+		t.Errorf("default RPC path: got status %d, body %s", w.Code, w.Body)
+	}
+	if bodies := f.Bodies(); len(bodies) != 1 {
+		t.Errorf("forwarded %q", bodies)
+	}
+}
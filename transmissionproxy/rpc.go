@@ -0,0 +1,324 @@
+package transmissionproxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"transmission-proxy/internal/jrpc"
+	"transmission-proxy/internal/logger"
+	"transmission-proxy/internal/policy"
+	"transmission-proxy/internal/reqctx"
+	"transmission-proxy/internal/response"
+	"transmission-proxy/internal/stats"
+	"transmission-proxy/internal/transmission"
+)
+
+const (
+	// RejectMalformed is the reject reason for requests which could not be parsed.
+	RejectMalformed = "malformed_request"
+	// RejectTooLarge is the reject reason for requests with body exceeding RPCConfig.MaxBodyBytes.
+	RejectTooLarge = "body_too_large"
+)
+
+// RPCConfig configures the handler returned by RPC.
+type RPCConfig struct {
+	// Validator validates the requests, it must be set.
+	Validator transmission.RequestValidator
+	// ShadowValidation, if set, runs validation in shadow mode: the requests are forwarded as they are,
+	// and what validation would have done is passed to it, the sanitized request or the error.
+	ShadowValidation func(r *http.Request, req, sanitized *jrpc.Request, err error)
+	// Policies are applied to the validated requests in order, see policy.Chain.
+	Policies []policy.Policy
+	// MaxBodyBytes limits the size of the requests, DefaultMaxBodyBytes if zero.
+	MaxBodyBytes int64
+	// Timeout returns the time the upstream may take to answer the request for the method, unlimited if nil.
+	Timeout func(method string) time.Duration
+	// CompressResponses compresses the responses for the clients accepting gzip when the upstream did not.
+	// Responses rewritten by policies are compressed for such clients regardless.
+	CompressResponses bool
+	// Responder answers the rejected and failed requests, without debug mode if nil.
+	Responder *response.Responder
+	// Stats records the requests, a registry of its own if nil.
+	Stats *stats.Registry
+	// Hooks extend the handling of the requests.
+	Hooks RPCHooks
+}
+
+// RPCHooks extend the handling of RPC requests, e.g. with rate limits or audit log. Every hook is optional.
+type RPCHooks struct {
+	// Begin is called before the request is read. The function it returns, if any, is called with the outcome
+	// once the request is handled.
+	Begin func(w *response.Recorder, r *http.Request) (done func(*RPCOutcome))
+	// Admit is called with the parsed request before it is validated. It may refuse the request, answering it
+	// itself, by returning the reject reason.
+	Admit func(w http.ResponseWriter, r *http.Request, req *jrpc.Request) (reason string)
+	// Rejected is called with the requests rejected by validation or policy, the reject reason and the status
+	// of the response, before the rejection is recorded and answered. It may complete the record, and returns
+	// err with the attributes to log it with added.
+	Rejected func(r *http.Request, req *jrpc.Request, err error, reason string, status int, rej *stats.Rejection) error
+	// Forward is called with the accepted request and its body right before it is forwarded. It may answer
+	// the request itself instead, e.g. with an injected fault, by returning false.
+	Forward func(w *response.Recorder, r *http.Request, sanitized *jrpc.Request, body []byte) bool
+	// Forwarded is called once the upstream answered the forwarded request or failed to.
+	Forwarded func(w *response.Recorder, r *http.Request, req, sanitized *jrpc.Request)
+}
+
+// RPCOutcome describes how the request was handled, see RPCHooks.Begin.
+type RPCOutcome struct {
+	// Request is the parsed request, nil if it could not be parsed.
+	Request *jrpc.Request
+	// RejectReason is the reason the request was rejected for, empty if it was not.
+	RejectReason string
+	// Accepted tells whether the request passed the checks. Forwarded is its body as forwarded,
+	// nil for dry runs.
+	Accepted  bool
+	Forwarded []byte
+}
+
+// RPC returns the handler of RPC requests: they are parsed, validated and checked by the policies, and forwarded
+// to gw in the form returned by them. The responses are rewritten by the policies if they ask for it.
+func RPC(gw http.Handler, cfg RPCConfig) http.HandlerFunc {
+	rr, st, hooks := cfg.Responder, cfg.Stats, cfg.Hooks
+	if rr == nil {
+		rr = &response.Responder{}
+	}
+	if st == nil {
+		st = stats.NewRegistry()
+	}
+	maxBodyBytes := cfg.MaxBodyBytes
+	if maxBodyBytes == 0 {
+		maxBodyBytes = DefaultMaxBodyBytes
+	}
+
+	return func(rw http.ResponseWriter, r *http.Request) {
+		w := response.NewRecorder(rw)
+
+		var out RPCOutcome
+		if hooks.Begin != nil {
+			if done := hooks.Begin(w, r); done != nil {
+				defer func() { done(&out) }()
+			}
+		}
+
+		m := st.StartRPC()
+		defer m.Done()
+		rejected := func(reason string) {
+			out.RejectReason = reason
+			m.Reject(reason)
+		}
+
+		r.Body = http.MaxBytesReader(w, r.Body, maxBodyBytes)
+		req, err := jrpc.FromRequest(r)
+		var tooLarge *jrpc.BodyTooLargeError
+		if errors.As(err, &tooLarge) {
+			rejected(RejectTooLarge)
+			err = logger.WithAttributes(err, logger.RPCRejectReason(RejectTooLarge))
+			rr.RespondAndLogCustom(w, r.Context(), fmt.Errorf("invalid RPC request: %w", err), tooLarge.Tag, slog.LevelWarn, http.StatusRequestEntityTooLarge)
+			return
+		}
+		var malformed *jrpc.MalformedError
+		if errors.As(err, &malformed) {
+			rejected(RejectMalformed)
+			err = logger.WithAttributes(err, logger.RPCRejectReason(RejectMalformed))
+			rr.RespondAndLogCustom(w, r.Context(), fmt.Errorf("invalid RPC request: %w", err), malformed.Tag, slog.LevelWarn, http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			rejected(RejectMalformed)
+			err = logger.WithAttributes(err, logger.RPCRejectReason(RejectMalformed))
+			rr.RespondAndLogCustom(w, r.Context(), fmt.Errorf("failed to unmarshal RPC request: %w", err), 0, slog.LevelError, http.StatusBadRequest)
+			return
+		}
+		out.Request = req
+		reqctx.SetRPCMethod(r.Context(), req.Method)
+		// methods unknown to the spec are counted together, so that clients cannot inflate the metrics
+		m.Method = "other"
+		if transmission.IsSpecMethod(req.Method) {
+			m.Method = req.Method
+		}
+
+		if hooks.Admit != nil {
+			if reason := hooks.Admit(w, r, req); reason != "" {
+				rejected(reason)
+				return
+			}
+		}
+
+		sanitized, err := cfg.Validator.Validate(req)
+		if cfg.ShadowValidation != nil {
+			cfg.ShadowValidation(r, req, sanitized, err)
+			sanitized, err = req, nil
+		}
+		if err != nil {
+			rejected(transmission.RejectReason(err))
+			reject(w, r, req, err, transmission.RejectReason(err), http.StatusBadRequest, rr, st, hooks)
+			return
+		}
+
+		sanitized, rewrite, err := policy.Chain(r.Context(), cfg.Policies, sanitized)
+		if err != nil {
+			var violation *policy.Violation
+			if errors.As(err, &violation) {
+				rejected(transmission.RejectPolicy)
+				reject(w, r, req, err, transmission.RejectPolicy, http.StatusForbidden, rr, st, hooks)
+			} else {
+				rr.RespondAndLogCustom(w, r.Context(), fmt.Errorf("failed to apply policy: %w", err), req.Tag, slog.LevelError, http.StatusBadGateway)
+			}
+			return
+		}
+
+		if IsDryRun(r) {
+			out.Accepted = true
+			m.Outcome = stats.OutcomeDryRun
+			respondDryRun(w, r, req, sanitized)
+			return
+		}
+
+		// requests left as is (e.g. when checks run in shadow mode) are forwarded byte for byte
+		bs := req.Raw
+		if sanitized != req {
+			if bs, err = json.Marshal(sanitized); err != nil {
+				rr.RespondAndLogError(w, r.Context(), fmt.Errorf("cannot serialize RPC request: %w", err), req.Tag)
+				return
+			}
+		}
+
+		if hooks.Forward != nil && !hooks.Forward(w, r, sanitized, bs) {
+			return
+		}
+		out.Accepted, out.Forwarded = true, bs
+
+		r.ContentLength = -1
+		r.Header.Del("Content-Length")
+		r.Body = io.NopCloser(bytes.NewReader(bs))
+		r.GetBody = func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(bs)), nil
+		}
+		// the timeout covers reading the response, so it does not apply to other requests, e.g. of the web interface
+		ctx := r.Context()
+		if cfg.Timeout != nil {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, cfg.Timeout(req.Method))
+			defer cancel()
+		}
+		r = r.WithContext(reqctx.WithForwardedRPC(ctx, sanitized))
+
+		switch {
+		case rewrite == nil && cfg.CompressResponses && acceptsGzip(r):
+			gz := &gzipWriter{Recorder: w}
+			gw.ServeHTTP(gz, r)
+			if err := gz.Close(); err != nil {
+				slog.ErrorContext(r.Context(), "proxy: failed to write response: "+err.Error(), logger.IgnoredAttr(err))
+			}
+		case rewrite == nil:
+			gw.ServeHTTP(w, r)
+		default:
+			forwardRewritten(gw, w, r, rewrite, rr, req.Tag)
+		}
+
+		if hooks.Forwarded != nil {
+			hooks.Forwarded(w, r, req, sanitized)
+		}
+
+		m.Outcome = stats.OutcomeForwarded
+		if w.UpstreamStatus() == 0 || w.UpstreamStatus() >= http.StatusInternalServerError {
+			m.Outcome = stats.OutcomeUpstreamError
+		}
+
+		// upstream transport failures are logged by the responder already
+		if w.UpstreamStatus() == 0 {
+			return
+		}
+
+		lvl := slog.LevelDebug
+		if w.UpstreamStatus() >= http.StatusInternalServerError {
+			lvl = slog.LevelError
+		}
+		// completions are logged at debug level mostly, don't build the attributes just to drop them
+		if !slog.Default().Enabled(r.Context(), lvl) {
+			return
+		}
+
+		slog.LogAttrs(r.Context(), lvl, "RPC request completed", append(w.OutcomeAttrs(),
+			logger.RPCMethod(req.Method),
+			logger.RPCTag(req.Tag),
+			logger.HTTPStatus(w.Status()))...)
+	}
+}
+
+// reject responds to the request rejected by validation or policy and records the rejection.
+func reject(w http.ResponseWriter, r *http.Request, req *jrpc.Request, err error, reason string, status int,
+	rr *response.Responder, st *stats.Registry, hooks RPCHooks,
+) {
+	err = logger.WithAttributes(err, logger.RPCRejectReason(reason))
+
+	rej := stats.Rejection{
+		Time:   time.Now(),
+		Method: req.Method,
+		Tag:    req.Tag,
+		Reason: err.Error(),
+	}
+	if ip := reqctx.ClientIP(r.Context()); ip.IsValid() {
+		rej.ClientIP = ip.String()
+	}
+	if hooks.Rejected != nil {
+		err = hooks.Rejected(r, req, err, reason, status, &rej)
+	}
+	st.RecordRejection(rej)
+	// dry runs are logged as such, as nothing was attempted
+	if IsDryRun(r) {
+		err = logger.WithAttributes(err, logger.RPC(slog.Bool("dry_run", true)))
+	}
+
+	rr.RespondAndLogCustom(w, r.Context(), fmt.Errorf("invalid RPC request: %w", err), req.Tag, slog.LevelWarn, status)
+}
+
+// rewriteResponse applies the rewriter to the parsed response and returns its new body. Responses the rewriter
+// left as they were are returned as the upstream sent them, in whichever format (e.g. torrent-get "table").
+func rewriteResponse(resp *jrpc.Response, rewrite policy.ResponseRewriter, orig []byte) ([]byte, error) {
+	changed, err := rewrite(resp)
+	if err != nil || !changed {
+		return orig, err
+	}
+
+	return json.Marshal(resp)
+}
+
+// forwardRewritten forwards the request buffering the response, so that policies may rewrite it.
+// Unsuccessful responses are passed through as is. The response is compressed if the client accepts gzip.
+func forwardRewritten(gw http.Handler, w *response.Recorder, r *http.Request, rewrite policy.ResponseRewriter, rr *response.Responder, tag int) {
+	gzipped := acceptsGzip(r)
+	// the response has to be readable to be rewritten, the transport decompresses it if the upstream compresses
+	// it nevertheless
+	r.Header.Del("Accept-Encoding")
+
+	buf := response.NewBuffer()
+	gw.ServeHTTP(buf, r)
+	w.SetUpstreamStatus(buf.UpstreamStatus())
+
+	body := buf.Body.Bytes()
+	if buf.UpstreamStatus() == http.StatusOK {
+		resp, err := jrpc.ParseResponse(body)
+		if err == nil && resp.Result == jrpc.ResultSuccess {
+			body, err = rewriteResponse(resp, rewrite, body)
+		}
+		if err != nil {
+			rr.RespondAndLogCustom(w, r.Context(), fmt.Errorf("cannot rewrite RPC response: %w", err), tag, slog.LevelError, http.StatusBadGateway)
+			return
+		}
+	}
+
+	if gzipped {
+		body = gzipBody(buf, body)
+	}
+	if err := buf.Send(w, body); err != nil {
+		slog.ErrorContext(r.Context(), "proxy: failed to write response: "+err.Error(), logger.IgnoredAttr(err))
+	}
+}
@@ -0,0 +1,221 @@
+package transmissionproxy
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"transmission-proxy/internal/jrpc"
+	"transmission-proxy/internal/policy"
+	"transmission-proxy/internal/reqctx"
+	"transmission-proxy/internal/response"
+	"transmission-proxy/internal/roles"
+	"transmission-proxy/internal/stats"
+)
+
+// tableResponse is torrent-get response in "table" format as sent by Transmission 4: members in its order,
+// names not escaped the way encoding/json would escape them, and tag 0.
+const tableResponse = `{"arguments":{"torrents":[["id","name","downloadDir"],` +
+	`[1,"Tom & Jerry <1940>","/downloads/films"],[2,"ubuntu-24.04.iso","/downloads/linux"]]},"result":"success","tag":0}`
+
+func prefixRewriter(t *testing.T, fields ...any) policy.ResponseRewriter {
+	f := &policy.PrefixFilter{Prefix: "/downloads/", Roles: &roles.Roles{}}
+	ctx := reqctx.WithUser(context.Background(), "alice")
+	req := &jrpc.Request{Method: "torrent-get", Arguments: map[string]any{"fields": fields, "format": "table"}}
+
+	_, rewrite, err := f.Apply(ctx, req)
+	if err != nil || rewrite == nil {
+		t.Fatalf("got rewriter %v, error %v", rewrite, err)
+	}
+
+	return rewrite
+}
+
+func TestRewriteResponseUnchanged(t *testing.T) {
+	rewrite := prefixRewriter(t, "id", "name", "downloadDir")
+
+	resp, err := jrpc.ParseResponse([]byte(tableResponse))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := rewriteResponse(resp, rewrite, []byte(tableResponse))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(body) != tableResponse {
+		t.Errorf("unchanged response was re-serialized:\n got %s\nwant %s", body, tableResponse)
+	}
+}
+
+func TestRewriteResponseChanged(t *testing.T) {
+	// downloadDir was not requested, so the filter adds it and removes it from the response
+	rewrite := prefixRewriter(t, "id", "name")
+
+	orig := `{"arguments":{"torrents":[["id","name","downloadDir"],[1,"a","/downloads/a"],[2,"b","/srv/b"]]},` +
+		`"result":"success","tag":0,"x-daemon":{"pid":42}}`
+	resp, err := jrpc.ParseResponse([]byte(orig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := rewriteResponse(resp, rewrite, []byte(orig))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got map[string]json.RawMessage
+	if err := json.Unmarshal(body, &got); err != nil {
+		t.Fatal(err)
+	}
+	if s := string(got["arguments"]); s != `{"torrents":[["id","name"],[1,"a"]]}` {
+		t.Errorf("got arguments %s", s)
+	}
+	if s := string(got["tag"]); s != "0" {
+		t.Errorf("got tag %q, want 0", s)
+	}
+	if s := string(got["x-daemon"]); s != `{"pid":42}` {
+		t.Errorf("got unknown member %q, want it kept", s)
+	}
+}
+
+// policyFunc is the policy of the tests.
+type policyFunc func(ctx context.Context, req *jrpc.Request) (*jrpc.Request, policy.ResponseRewriter, error)
+
+func (f policyFunc) Apply(ctx context.Context, req *jrpc.Request) (*jrpc.Request, policy.ResponseRewriter, error) {
+	return f(ctx, req)
+}
+
+func TestProxyPolicies(t *testing.T) {
+	captureLog(t)
+	// torrents may not be removed, and the responses to torrent-get are marked
+	p := policyFunc(func(_ context.Context, req *jrpc.Request) (*jrpc.Request, policy.ResponseRewriter, error) {
+		switch req.Method {
+		case "torrent-remove":
+			return nil, nil, &policy.Violation{Policy: "keep", Reason: "torrents may not be removed"}
+		case "torrent-get":
+			return req, func(resp *jrpc.Response) (bool, error) {
+				resp.Arguments["checked"] = true
+				return true, nil
+			}, nil
+		}
+		return req, nil, nil
+	})
+	h, f := testProxy(t, Config{Policies: []policy.Policy{p}, DebugMode: true})
+
+	w := serve(h, http.MethodPost, DefaultRPCPath, `{"method":"torrent-remove","arguments":{"ids":[1]},"tag":1}`)
+	if w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), "torrents may not be removed") {
+		t.Errorf("got status %d, body %s", w.Code, w.Body)
+	}
+	if bodies := f.Bodies(); len(bodies) != 0 {
+		t.Errorf("forwarded %q", bodies)
+	}
+
+	w = serve(h, http.MethodPost, DefaultRPCPath, `{"method":"torrent-get","arguments":{"fields":["id"]},"tag":2}`)
+	var resp jrpc.Response
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || w.Code != http.StatusOK || resp.Arguments["checked"] != true || resp.Tag != 2 {
+		t.Errorf("got status %d, body %s", w.Code, w.Body)
+	}
+}
+
+func TestProxyRequestCheck(t *testing.T) {
+	captureLog(t)
+	h, f := testProxy(t, Config{RequireJSONContentType: true})
+
+	w := serve(h, http.MethodGet, DefaultRPCPath, "")
+	if w.Code != http.StatusMethodNotAllowed || w.Header().Get("Allow") != http.MethodPost {
+		t.Errorf("GET: got status %d, headers %v", w.Code, w.Header())
+	}
+
+	for ct, want := range map[string]int{"text/plain": http.StatusUnsupportedMediaType, "application/json": http.StatusOK, "": http.StatusOK} {
+		r := httptest.NewRequest(http.MethodPost, DefaultRPCPath, strings.NewReader(`{"method":"session-get"}`))
+		if ct != "" {
+			r.Header.Set("Content-Type", ct)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != want {
+			t.Errorf("%q: got status %d, want %d", ct, w.Code, want)
+		}
+	}
+	if bodies := f.Bodies(); len(bodies) != 2 {
+		t.Errorf("forwarded %q", bodies)
+	}
+}
+
+func TestProxyHooks(t *testing.T) {
+	captureLog(t)
+	var outcomes []RPCOutcome
+	var rejections []string
+	var forwarded, answered []int
+	h, f := testProxy(t, Config{Hooks: RPCHooks{
+		Begin: func(*response.Recorder, *http.Request) func(*RPCOutcome) {
+			return func(out *RPCOutcome) { outcomes = append(outcomes, *out) }
+		},
+		// torrent-start is refused before validation, as by a rate limit
+		Admit: func(w http.ResponseWriter, r *http.Request, req *jrpc.Request) string {
+			if req.Method != "torrent-start" {
+				return ""
+			}
+			writeJSON(w, r, http.StatusTooManyRequests, &jrpc.Response{Result: "slow down", Tag: req.Tag, HasTag: req.HasTag})
+			return "rate_limited"
+		},
+		Rejected: func(_ *http.Request, req *jrpc.Request, err error, reason string, _ int, _ *stats.Rejection) error {
+			rejections = append(rejections, req.Method+" "+reason)
+			return err
+		},
+		// torrent-stop is answered as by an injected fault
+		Forward: func(w *response.Recorder, r *http.Request, sanitized *jrpc.Request, _ []byte) bool {
+			forwarded = append(forwarded, sanitized.Tag)
+			if sanitized.Method == "torrent-stop" {
+				writeJSON(w, r, http.StatusServiceUnavailable, &jrpc.Response{Result: "fault", Tag: sanitized.Tag})
+				return false
+			}
+			return true
+		},
+		Forwarded: func(w *response.Recorder, _ *http.Request, _, _ *jrpc.Request) {
+			answered = append(answered, w.UpstreamStatus())
+		},
+	}})
+
+	requests := []struct {
+		body   string
+		status int
+		want   RPCOutcome
+	}{
+		{body: `{"method":"session-get","tag":1}`, status: http.StatusOK, want: RPCOutcome{Accepted: true}},
+		{body: `{"method":"torrent-start","arguments":{"ids":[1]},"tag":2}`, status: http.StatusTooManyRequests, want: RPCOutcome{RejectReason: "rate_limited"}},
+		{body: `{"method":"shell-exec","tag":3}`, status: http.StatusBadRequest, want: RPCOutcome{RejectReason: "unknown_method"}},
+		{body: `{"method":"torrent-stop","arguments":{"ids":[1]},"tag":4}`, status: http.StatusServiceUnavailable},
+		{body: `{"method":`, status: http.StatusBadRequest, want: RPCOutcome{RejectReason: RejectMalformed}},
+	}
+	for _, req := range requests {
+		if w := serve(h, http.MethodPost, DefaultRPCPath, req.body); w.Code != req.status {
+			t.Errorf("%s: got status %d, body %s", req.body, w.Code, w.Body)
+		}
+	}
+
+	if len(outcomes) != len(requests) {
+		t.Fatalf("got outcomes %+v", outcomes)
+	}
+	for i, out := range outcomes {
+		want := requests[i].want
+		if out.RejectReason != want.RejectReason || out.Accepted != want.Accepted || (out.Request == nil) != (want.RejectReason == RejectMalformed) {
+			t.Errorf("%s: got outcome %+v", requests[i].body, out)
+		}
+	}
+	if string(outcomes[0].Forwarded) != requests[0].body {
+		t.Errorf("got forwarded body %q", outcomes[0].Forwarded)
+	}
+	if len(rejections) != 1 || rejections[0] != "shell-exec unknown_method" {
+		t.Errorf("got rejections %q", rejections)
+	}
+	if len(forwarded) != 2 || forwarded[0] != 1 || forwarded[1] != 4 || len(answered) != 1 || answered[0] != http.StatusOK {
+		t.Errorf("got forwarded tags %v, answered %v", forwarded, answered)
+	}
+	if bodies := f.Bodies(); len(bodies) != 1 {
+		t.Errorf("forwarded %q", bodies)
+	}
+}
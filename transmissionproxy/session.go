@@ -0,0 +1,37 @@
+package transmissionproxy
+
+import (
+	"io"
+	"net/http"
+
+	"transmission-proxy/internal/upstream"
+)
+
+// retryConflict retries the request answered with 409 with the session id from the response, provided
+// the request body can be sent again. Otherwise, and if the retry fails too, the response is returned as is.
+func retryConflict(c *http.Client, r *http.Request, resp *http.Response, sess *upstream.Session) (*http.Response, error) {
+	id := resp.Header.Get(upstream.SessionIDHeader)
+	if resp.StatusCode != http.StatusConflict || id == "" {
+		return resp, nil
+	}
+	sess.Set(id)
+
+	retry := r.Clone(r.Context())
+	switch {
+	case r.GetBody != nil:
+		body, err := r.GetBody()
+		if err != nil {
+			return resp, nil
+		}
+		retry.Body = body
+	case r.Body != nil && r.Body != http.NoBody:
+		// the body was consumed already
+		return resp, nil
+	}
+	retry.Header.Set(upstream.SessionIDHeader, id)
+
+	_, _ = io.Copy(io.Discard, resp.Body)
+	_ = resp.Body.Close()
+
+	return c.Do(retry)
+}
@@ -0,0 +1,95 @@
+package transmissionproxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"transmission-proxy/internal/transmissiontest"
+	"transmission-proxy/internal/upstream"
+)
+
+func TestForwardSessionRetry(t *testing.T) {
+	up := &transmissiontest.Server{SessionID: "sid"}
+	sess := &upstream.Session{}
+	h := Forward(ForwardConfig{Upstream: up.Start(t), Session: sess})
+
+	// the first request is retried with the id from the conflict, the others are sent with it
+	for i := 0; i < 3; i++ {
+		if w, _ := forwardRPC(t, h); w.Code != http.StatusOK {
+			t.Fatalf("got status %d, body %s", w.Code, w.Body)
+		}
+	}
+	if n := up.Conflicts(); n != 1 || len(up.Bodies()) != 3 || sess.ID() != "sid" {
+		t.Errorf("got %d conflicts, %d requests served, session id %q", n, len(up.Bodies()), sess.ID())
+	}
+}
+
+func TestForwardSessionNotRetried(t *testing.T) {
+	up := &transmissiontest.Server{SessionID: "sid"}
+	sess := &upstream.Session{}
+	h := Forward(ForwardConfig{Upstream: up.Start(t), Session: sess})
+
+	// the body of the request cannot be sent again, so the client retries itself
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/transmission/rpc", strings.NewReader(`{"method":"session-get"}`)))
+	if w.Code != http.StatusConflict || w.Header().Get(upstream.SessionIDHeader) != "sid" {
+		t.Errorf("got status %d, headers %v", w.Code, w.Header())
+	}
+	// the id is remembered for the next requests nevertheless
+	if w, _ := forwardRPC(t, h); w.Code != http.StatusOK || up.Conflicts() != 1 {
+		t.Errorf("got status %d after %d conflicts", w.Code, up.Conflicts())
+	}
+}
+
+func TestForwardSessionPassthrough(t *testing.T) {
+	up := &transmissiontest.Server{SessionID: "sid"}
+	h := Forward(ForwardConfig{Upstream: up.Start(t)})
+
+	// without the session the client negotiates the id
+	w, _ := forwardRPC(t, h)
+	if w.Code != http.StatusConflict || w.Header().Get(upstream.SessionIDHeader) != "sid" {
+		t.Fatalf("got status %d, headers %v", w.Code, w.Header())
+	}
+
+	r := httptest.NewRequest(http.MethodPost, "/transmission/rpc", strings.NewReader(`{"method":"session-get"}`))
+	r.Header.Set(upstream.SessionIDHeader, "sid")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusOK || up.Conflicts() != 1 {
+		t.Errorf("got status %d after %d conflicts", w.Code, up.Conflicts())
+	}
+}
+
+// rotatingSession answers every request with 409 and a new session id, as Transmission restarting in a loop.
+type rotatingSession struct {
+	n atomic.Int32
+}
+
+func (s *rotatingSession) RoundTrip(r *http.Request) (*http.Response, error) {
+	w := httptest.NewRecorder()
+	w.Header().Set(upstream.SessionIDHeader, "sid"+strconv.Itoa(int(s.n.Add(1))))
+	w.WriteHeader(http.StatusConflict)
+
+	return w.Result(), nil
+}
+
+func TestForwardSessionRetryFails(t *testing.T) {
+	rs := &rotatingSession{}
+	sess := &upstream.Session{}
+	u, _ := url.Parse("http://transmission:9091/")
+	h := Forward(ForwardConfig{Upstream: u, Client: &http.Client{Transport: rs}, Session: sess})
+
+	// the conflict of the retry is passed to the client instead of retrying again
+	w, _ := forwardRPC(t, h)
+	if w.Code != http.StatusConflict || w.Header().Get(upstream.SessionIDHeader) != "sid2" {
+		t.Errorf("got status %d, headers %v", w.Code, w.Header())
+	}
+	if n := rs.n.Load(); n != 2 || sess.ID() != "sid1" {
+		t.Errorf("got %d requests, session id %q", n, sess.ID())
+	}
+}